@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/corpus"
+	"github.com/mailsentinel/core/internal/profile"
+	"github.com/mailsentinel/core/internal/resolver"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// runCorpusClassify classifies the messages of an mbox file or maildir with
+// the selected profiles, resolves their results and reports the decisions.
+// Only the LLM backend is contacted: thread context and preview expansion,
+// which fetch from the mail provider, are disabled, and classifications are
+// not audited.
+func runCorpusClassify(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("corpus classify", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "config.yaml", "configuration file")
+	path := flags.String("path", "", "mbox file or maildir to classify")
+	profileIDs := flags.String("profile", "", "comma-separated profiles to classify with (defaults to every active profile)")
+	limit := flags.Int("limit", 0, "only classify the first messages")
+	dryRun := flags.Bool("dry-run", false, "parse and route the messages without classifying them")
+	labelHeader := flags.String("label-header", "", "header carrying each message's expected action, e.g. X-Expected-Action")
+	jsonOutput := flags.Bool("json", false, "print the full report as JSON")
+	deterministic := flags.Bool("deterministic", false, "classify with temperature 0 and the configured seed")
+	verbose := flags.Bool("verbose", false, "enable verbose logging")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: mailsentinel corpus classify -path <mbox|maildir> [-profile <id,...>] [flags]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" {
+		flags.Usage()
+		return 2
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(stderr)
+	logger.SetLevel(logrus.WarnLevel)
+	if *verbose {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "corpus classify failed: %v\n", err)
+		return 2
+	}
+	if *deterministic {
+		cfg.Ollama.Deterministic = true
+		cfg.LLM.OpenAI.Deterministic = true
+	}
+	cfg.LLM.ThreadContext.Enabled = false
+	cfg.LLM.Previews.Enabled = false
+
+	loader, err := profile.NewLoaderFromConfig(&cfg.Profiles, logger)
+	if err != nil {
+		fmt.Fprintf(stderr, "corpus classify failed: %v\n", err)
+		return 1
+	}
+	if err := loader.LoadAll(); err != nil {
+		fmt.Fprintf(stderr, "corpus classify failed: %v\n", err)
+		return 1
+	}
+	profiles, err := selectProfiles(loader.GetRegistry(), *profileIDs)
+	if err != nil {
+		fmt.Fprintf(stderr, "corpus classify failed: %v\n", err)
+		return 2
+	}
+
+	router, err := profile.NewRouter(cfg.Profiles.Routing, logger)
+	if err != nil {
+		fmt.Fprintf(stderr, "corpus classify failed: profiles.routing: %v\n", err)
+		return 2
+	}
+	var policyResolver corpus.Resolver
+	if cfg.Profiles.ResolverConfig != "" {
+		loaded, err := resolver.NewPolicyResolver(cfg.Profiles.ResolverConfig, logger)
+		if err != nil {
+			fmt.Fprintf(stderr, "corpus classify failed: %v\n", err)
+			return 1
+		}
+		policyResolver = loaded
+	} else if len(profiles) > 1 {
+		fmt.Fprintln(stderr, "corpus classify failed: several profiles need profiles.resolver_config to resolve their results; select one with -profile")
+		return 2
+	}
+
+	messages, err := corpus.Read(*path, *limit)
+	if err != nil {
+		fmt.Fprintf(stderr, "corpus classify failed: %v\n", err)
+		return 1
+	}
+
+	var classifier corpus.Classifier
+	if !*dryRun {
+		// A nil audit logger keeps corpus classifications out of the audit log
+		_, backend, _, err := newClassifier(cfg, nil, nil, logger)
+		if err != nil {
+			fmt.Fprintf(stderr, "corpus classify failed: %v\n", err)
+			return 2
+		}
+		if closer, ok := backend.(io.Closer); ok {
+			defer closer.Close()
+		}
+		classifier = backend
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	evaluator := corpus.NewEvaluator(classifier, router, policyResolver, logger)
+	evaluator.SetLabelHeader(*labelHeader)
+	report := evaluator.Evaluate(ctx, profiles, messages, *dryRun)
+	if *jsonOutput {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintf(stderr, "corpus classify failed: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	printCorpusReport(stdout, report)
+	return 0
+}
+
+// selectProfiles returns the profiles named by a comma-separated list or,
+// when it is empty, every active profile, in load order so that profiles
+// come after the ones they depend on
+func selectProfiles(registry *types.ProfileRegistry, ids string) ([]*types.Profile, error) {
+	selected := make(map[string]bool)
+	for _, id := range strings.Split(ids, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			if _, exists := registry.Profiles[id]; !exists {
+				return nil, fmt.Errorf("profile %s not found", id)
+			}
+			selected[id] = true
+		}
+	}
+
+	var profiles []*types.Profile
+	for _, id := range registry.LoadOrder {
+		profile, exists := registry.Profiles[id]
+		if !exists {
+			continue
+		}
+		if len(selected) > 0 && selected[id] || len(selected) == 0 && !profile.IsShadow() {
+			profiles = append(profiles, profile)
+		}
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("no profiles to classify with")
+	}
+	return profiles, nil
+}
+
+// printCorpusReport prints the summary, the decisions by action, the
+// confusion of labeled decisions and every decision that missed its label
+func printCorpusReport(stdout io.Writer, report *corpus.Report) {
+	fmt.Fprintf(stdout, "CORPUS %s\n", report)
+
+	actions := make([]string, 0, len(report.ActionCounts))
+	for action := range report.ActionCounts {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	for _, action := range actions {
+		fmt.Fprintf(stdout, "  %s: %d\n", action, report.ActionCounts[action])
+	}
+
+	expectedActions := make([]string, 0, len(report.Confusion))
+	for action := range report.Confusion {
+		expectedActions = append(expectedActions, action)
+	}
+	sort.Strings(expectedActions)
+	for _, expected := range expectedActions {
+		decided := make([]string, 0, len(report.Confusion[expected]))
+		for action := range report.Confusion[expected] {
+			decided = append(decided, action)
+		}
+		sort.Strings(decided)
+		for _, action := range decided {
+			fmt.Fprintf(stdout, "  expected %s -> %s: %d\n", expected, action, report.Confusion[expected][action])
+		}
+	}
+
+	for _, outcome := range report.Outcomes {
+		if report.DryRun && outcome.Error == "" && !outcome.Skipped {
+			fmt.Fprintf(stdout, "ROUTE %s %q from %s: %s\n", outcome.EmailID, outcome.Subject, outcome.From, strings.Join(outcome.Profiles, ", "))
+		}
+	}
+	for _, outcome := range report.Mistakes() {
+		fmt.Fprintf(stdout, "MISS %s %q from %s: expected %s, got %s (%.2f)\n",
+			outcome.EmailID, outcome.Subject, outcome.From, outcome.Expected, outcome.Action, outcome.Confidence)
+	}
+	for _, outcome := range report.Outcomes {
+		if outcome.Error != "" {
+			fmt.Fprintf(stdout, "FAIL %s: %s\n", outcome.Source, outcome.Error)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/export"
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+// Export formats selectable with -format
+const (
+	exportFormatCSV  = "csv"
+	exportFormatJSON = "json"
+)
+
+// runAuditExport writes the classifications recorded in the audit log as
+// CSV or JSON, one row per decision, for analysis outside mailsentinel
+func runAuditExport(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("audit export", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "config.yaml", "configuration file")
+	profileID := flags.String("profile", "", "only export decisions of this profile")
+	dir := flags.String("dir", "", "audit directory (defaults to audit.directory)")
+	since := flags.Duration("since", 0, "only export decisions recorded within this duration, e.g. 168h")
+	format := flags.String("format", exportFormatCSV, "output format: csv or json")
+	output := flags.String("output", "", "file to write (defaults to standard output)")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: mailsentinel audit export [-format csv|json] [flags]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *format != exportFormatCSV && *format != exportFormatJSON {
+		fmt.Fprintf(stderr, "unknown export format %q\n", *format)
+		flags.Usage()
+		return 2
+	}
+
+	if *dir == "" {
+		cfg, err := config.LoadConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "export failed: %v\n", err)
+			return 2
+		}
+		*dir = cfg.Audit.Directory
+	}
+
+	query := audit.Query{EventTypes: []string{audit.EventEmailClassified}, ProfileID: *profileID}
+	if *since > 0 {
+		query.Since = time.Now().Add(-*since)
+	}
+	entries, err := audit.QueryDirectory(*dir, query)
+	if err != nil {
+		fmt.Fprintf(stderr, "export failed: %v\n", err)
+		return 1
+	}
+
+	var rows []export.Row
+	for i := range entries {
+		if row, ok := export.EntryRow(&entries[i]); ok {
+			rows = append(rows, row)
+		}
+	}
+
+	if *output == "" {
+		err = writeExport(stdout, *format, rows)
+	} else {
+		var file *os.File
+		file, err = os.Create(*output)
+		if err == nil {
+			err = writeExport(file, *format, rows)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "export failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// writeExport writes rows in format
+func writeExport(w io.Writer, format string, rows []export.Row) error {
+	if format == exportFormatJSON {
+		return export.WriteJSON(w, rows)
+	}
+	return export.WriteCSV(w, rows)
+}
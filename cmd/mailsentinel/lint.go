@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/expr"
+	"github.com/mailsentinel/core/internal/profile"
+	"github.com/mailsentinel/core/internal/reputation"
+	"github.com/mailsentinel/core/internal/resolver"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// runProfileLint validates every profile and the resolver configuration,
+// printing one line per problem. It exits with 1 when any problem is found.
+func runProfileLint(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("profile lint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	directory := flags.String("dir", "profiles", "directory containing profile YAML files")
+	resolverPath := flags.String("resolver", "profiles/resolver.yaml", "resolver configuration to validate (empty to skip)")
+	verbose := flags.Bool("verbose", false, "enable verbose logging")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(stderr)
+	logger.SetLevel(logrus.WarnLevel)
+	if *verbose {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+
+	var rules []types.PriorityRule
+	var resolverIssues []profile.Issue
+	if *resolverPath != "" {
+		rules, resolverIssues = lintResolverConfig(*resolverPath)
+	}
+
+	loader := profile.NewLoader(*directory, logger)
+	issues, err := loader.LintWithRules(rules, *resolverPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "lint failed: %v\n", err)
+		return 2
+	}
+	issues = append(issues, resolverIssues...)
+
+	for _, issue := range issues {
+		fmt.Fprintln(stdout, issue.String())
+	}
+
+	if len(issues) > 0 {
+		fmt.Fprintf(stdout, "%d problem(s) found\n", len(issues))
+		return 1
+	}
+
+	fmt.Fprintln(stdout, "all profiles valid")
+	return 0
+}
+
+// lintResolverConfig parses the resolver configuration and compiles its
+// priority rule conditions, returning the rules for the profile checks
+func lintResolverConfig(path string) ([]types.PriorityRule, []profile.Issue) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	cfg, err := resolver.LoadConfig(path)
+	if err != nil {
+		return nil, []profile.Issue{{File: path, Message: err.Error()}}
+	}
+
+	var issues []profile.Issue
+	for i, rule := range cfg.PriorityRules {
+		if _, err := expr.Compile(rule.Condition); err != nil {
+			issues = append(issues, profile.Issue{
+				File:    path,
+				Field:   fmt.Sprintf("priority_rules[%d].condition", i),
+				Message: err.Error(),
+			})
+		}
+	}
+	if cfg.ConfidenceFloor < 0 || cfg.ConfidenceFloor > 1 {
+		issues = append(issues, profile.Issue{
+			File:    path,
+			Field:   "confidence_floor",
+			Message: fmt.Sprintf("confidence_floor must be between 0 and 1, got %g", cfg.ConfidenceFloor),
+		})
+	}
+	if _, err := reputation.New(cfg.SenderReputation); err != nil {
+		issues = append(issues, profile.Issue{
+			File:    path,
+			Field:   "sender_reputation",
+			Message: err.Error(),
+		})
+	}
+	return cfg.PriorityRules, issues
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `Usage: mailsentinel <command> [flags]
+
+Commands:
+  profile lint    Validate all profiles and resolver rules without contacting Ollama or Gmail
+  audit verify    Verify signed audit files offline with an Ed25519 public key
+  audit replay    Re-classify audited emails with a profile and report agreement
+  audit shadow    Report agreement between a shadow profile and its active profile
+  audit stats     Report top senders, per-domain actions and confidence from the audit log
+  audit export    Export audited classifications as CSV or JSON
+  corpus classify Classify an mbox file or maildir offline and report the decisions
+  serve           Serve the classification HTTP API (POST /v1/batch)
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run dispatches a command and returns the process exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) > 0 && args[0] == "serve" {
+		return runServe(args[1:], stdout, stderr)
+	}
+	if len(args) < 2 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	switch args[0] + " " + args[1] {
+	case "profile lint":
+		return runProfileLint(args[2:], stdout, stderr)
+	case "audit verify":
+		return runAuditVerify(args[2:], stdout, stderr)
+	case "audit replay":
+		return runAuditReplay(args[2:], stdout, stderr)
+	case "audit shadow":
+		return runAuditShadow(args[2:], stdout, stderr)
+	case "audit stats":
+		return runAuditStats(args[2:], stdout, stderr)
+	case "audit export":
+		return runAuditExport(args[2:], stdout, stderr)
+	case "corpus classify":
+		return runCorpusClassify(args[2:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0]+" "+args[1], usage)
+		return 2
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/profile"
+	"github.com/mailsentinel/core/internal/replay"
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+// runAuditReplay re-classifies the emails recorded in the audit log with a
+// profile and reports how often the new decisions agree with the recorded
+// ones. The audit log is only read; replayed classifications are not
+// audited and Gmail is never contacted.
+func runAuditReplay(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("audit replay", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "config.yaml", "configuration file")
+	profileID := flags.String("profile", "", "profile to replay with")
+	against := flags.String("against", "", "profile whose recorded decisions are compared (defaults to -profile)")
+	dir := flags.String("dir", "", "audit directory (defaults to audit.directory)")
+	since := flags.Duration("since", 0, "only replay decisions recorded within this duration, e.g. 168h")
+	limit := flags.Int("limit", 0, "only replay the most recent recorded decisions")
+	jsonOutput := flags.Bool("json", false, "print the full report as JSON")
+	deterministic := flags.Bool("deterministic", false, "classify with temperature 0 and the configured seed")
+	verbose := flags.Bool("verbose", false, "enable verbose logging")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: mailsentinel audit replay -profile <id> [-against <id>] [flags]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *profileID == "" {
+		flags.Usage()
+		return 2
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(stderr)
+	logger.SetLevel(logrus.WarnLevel)
+	if *verbose {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "replay failed: %v\n", err)
+		return 2
+	}
+	if *deterministic {
+		cfg.Ollama.Deterministic = true
+		cfg.LLM.OpenAI.Deterministic = true
+	}
+	if *dir == "" {
+		*dir = cfg.Audit.Directory
+	}
+
+	loader, err := profile.NewLoaderFromConfig(&cfg.Profiles, logger)
+	if err != nil {
+		fmt.Fprintf(stderr, "replay failed: %v\n", err)
+		return 1
+	}
+	if err := loader.LoadAll(); err != nil {
+		fmt.Fprintf(stderr, "replay failed: %v\n", err)
+		return 1
+	}
+	replayProfile, err := loader.GetProfile(*profileID)
+	if err != nil {
+		fmt.Fprintf(stderr, "replay failed: %v\n", err)
+		return 2
+	}
+
+	recordedProfile := *against
+	if recordedProfile == "" {
+		recordedProfile = replayProfile.ID
+	}
+	query := replay.RecordedQuery(recordedProfile)
+	if *since > 0 {
+		query.Since = time.Now().Add(-*since)
+	}
+	query.Limit = *limit
+	entries, err := audit.QueryDirectory(*dir, query)
+	if err != nil {
+		fmt.Fprintf(stderr, "replay failed: %v\n", err)
+		return 1
+	}
+
+	// A nil audit logger keeps replayed classifications out of the audit log
+	_, classifier, _, err := newClassifier(cfg, nil, nil, logger)
+	if err != nil {
+		fmt.Fprintf(stderr, "replay failed: %v\n", err)
+		return 2
+	}
+	if closer, ok := classifier.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	report := replay.NewReplayer(classifier, logger).Replay(ctx, replayProfile, entries, recordedProfile)
+	if *jsonOutput {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintf(stderr, "replay failed: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	printReplayReport(stdout, "REPLAY", report)
+	return 0
+}
+
+// printReplayReport prints the agreement summary under title, the action
+// transitions and every disagreement
+func printReplayReport(stdout io.Writer, title string, report *replay.Report) {
+	fmt.Fprintf(stdout, "%s %s\n", title, report)
+
+	recordedActions := make([]string, 0, len(report.Transitions))
+	for action := range report.Transitions {
+		recordedActions = append(recordedActions, action)
+	}
+	sort.Strings(recordedActions)
+	for _, recorded := range recordedActions {
+		replayedActions := make([]string, 0, len(report.Transitions[recorded]))
+		for action := range report.Transitions[recorded] {
+			replayedActions = append(replayedActions, action)
+		}
+		sort.Strings(replayedActions)
+		for _, replayed := range replayedActions {
+			fmt.Fprintf(stdout, "  %s -> %s: %d\n", recorded, replayed, report.Transitions[recorded][replayed])
+		}
+	}
+
+	for _, outcome := range report.Disagreements() {
+		fmt.Fprintf(stdout, "DIFF %s %q from %s: %s (%.2f) -> %s (%.2f)\n",
+			outcome.EmailID, outcome.Subject, outcome.From,
+			outcome.RecordedAction, outcome.RecordedConfidence,
+			outcome.ReplayedAction, outcome.ReplayedConfidence)
+	}
+	for _, outcome := range report.Outcomes {
+		if outcome.Error != "" {
+			fmt.Fprintf(stdout, "FAIL %s: %s\n", outcome.EmailID, outcome.Error)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/anomaly"
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/cursor"
+	"github.com/mailsentinel/core/internal/deadletter"
+	"github.com/mailsentinel/core/internal/feedback"
+	"github.com/mailsentinel/core/internal/gmail"
+	"github.com/mailsentinel/core/internal/lifecycle"
+	"github.com/mailsentinel/core/internal/limit"
+	"github.com/mailsentinel/core/internal/llm"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/internal/mailbox"
+	"github.com/mailsentinel/core/internal/notify"
+	"github.com/mailsentinel/core/internal/ollama"
+	"github.com/mailsentinel/core/internal/openai"
+	"github.com/mailsentinel/core/internal/processor"
+	"github.com/mailsentinel/core/internal/profile"
+	"github.com/mailsentinel/core/internal/redact"
+	"github.com/mailsentinel/core/internal/resolver"
+	"github.com/mailsentinel/core/internal/server"
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+// runServe loads the configuration and profiles and serves the HTTP API
+// until interrupted
+func runServe(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "config.yaml", "configuration file")
+	verbose := flags.Bool("verbose", false, "enable verbose logging")
+	deterministic := flags.Bool("deterministic", false, "classify with temperature 0 and the configured seed")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(stderr)
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "serve failed: %v\n", err)
+		return 2
+	}
+	if err := logging.Configure(logger, cfg.Logging); err != nil {
+		fmt.Fprintf(stderr, "serve failed: %v\n", err)
+		return 2
+	}
+	if *verbose {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+
+	if *deterministic {
+		cfg.Ollama.Deterministic = true
+		cfg.LLM.OpenAI.Deterministic = true
+	}
+
+	auditLogger, err := audit.NewLogger(&cfg.Audit, logger)
+	if err != nil {
+		fmt.Fprintf(stderr, "serve failed: %v\n", err)
+		return 1
+	}
+	defer auditLogger.Close()
+
+	loader, err := profile.NewLoaderFromConfig(&cfg.Profiles, logger)
+	if err != nil {
+		fmt.Fprintf(stderr, "serve failed: %v\n", err)
+		return 1
+	}
+	if err := loader.LoadAll(); err != nil {
+		fmt.Fprintf(stderr, "serve failed: %v\n", err)
+		return 1
+	}
+
+	// Classifications and Gmail requests across the server share one set of
+	// caps
+	limits := limit.NewLimits(cfg.Concurrency)
+	backend, classifier, healthCheck, err := newClassifier(cfg, limits, auditLogger, logger)
+	if err != nil {
+		fmt.Fprintf(stderr, "serve failed: %v\n", err)
+		return 2
+	}
+	// The fail action applies when serving only: replays report failures
+	// as they are. Profiles may set one even when llm.fail_action is off.
+	failAction := llm.NewFailActionClassifier(classifier, cfg.LLM.FailAction, logger)
+	failAction.SetAuditLogger(auditLogger)
+	classifier = failAction
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// On SIGINT or SIGTERM the coordinator drains in-flight classifications,
+	// closes the backend client and closes the audit log with a stop event
+	coordinator := lifecycle.NewCoordinator(auditLogger, logger)
+	if closer, ok := classifier.(io.Closer); ok {
+		coordinator.OnShutdown(backend, func(context.Context) error {
+			return closer.Close()
+		})
+	}
+	if cfg.Audit.Anomaly.Enabled {
+		detector := anomaly.NewDetector(cfg.Audit.Anomaly, auditLogger, logger)
+		auditLogger.AddObserver(detector.Observe)
+		coordinator.OnShutdown("anomaly", func(context.Context) error {
+			return detector.Close()
+		})
+	}
+	if cfg.Notifications.Enabled {
+		notifier, err := notify.NewNotifier(cfg.Notifications, auditLogger, logger)
+		if err != nil {
+			fmt.Fprintf(stderr, "serve failed: %v\n", err)
+			return 2
+		}
+		auditLogger.AddObserver(notifier.Observe)
+		coordinator.OnShutdown("notifications", func(context.Context) error {
+			return notifier.Close()
+		})
+	}
+
+	srv := server.NewServer(cfg, classifier, loader, logger)
+	srv.AddHealthCheck(backend, healthCheck)
+	srv.SetLifecycle(coordinator)
+	srv.SetAuditLogger(auditLogger)
+	recorder := feedback.NewRecorder(auditLogger, logger)
+	srv.SetFeedback(recorder)
+	if cfg.Server.DeadLetter.Enabled {
+		queue, err := deadletter.NewQueue(&cfg.Server.DeadLetter, auditLogger, logger)
+		if err != nil {
+			fmt.Fprintf(stderr, "serve failed: %v\n", err)
+			return 1
+		}
+		srv.SetDeadLetters(queue)
+	}
+	if cfg.Server.ClassifyByID || cfg.Server.ApplyActions {
+		client, err := mailbox.New(cfg, logger)
+		if err != nil {
+			fmt.Fprintf(stderr, "serve failed: %s: %v\n", mailboxOption(cfg), err)
+			return 2
+		}
+		if gmailClient, ok := client.(*gmail.Client); ok {
+			gmailClient.SetLimiter(limits.Gmail)
+		}
+		if cfg.Server.ClassifyByID {
+			srv.SetMailbox(client)
+			// Only Gmail lists snippets without fetching messages
+			if lister, ok := client.(server.PreviewLister); ok {
+				srv.SetPreviews(lister)
+			}
+		}
+		if cfg.Server.ApplyActions {
+			client.SetAuditLogger(auditLogger)
+			actions := processor.NewProcessor(&cfg.Actions, client, auditLogger, logger)
+			actions.SetLifecycle(coordinator)
+			if path := cfg.Actions.CursorFile; path != "" {
+				progress, err := cursor.Open(path, logger)
+				if err != nil {
+					fmt.Fprintf(stderr, "serve failed: actions.cursor_file: %v\n", err)
+					return 1
+				}
+				// Each batch drops its progress once it completes; the
+				// batches left unfinished are kept for the next server
+				coordinator.OnShutdown("cursor", func(context.Context) error {
+					return progress.Close()
+				})
+				actions.SetCursor(progress)
+			}
+			srv.SetProcessor(actions)
+		}
+	}
+	if every := cfg.Server.BatchProgressEvery; every > 0 {
+		srv.SetBatchProgress(every, func(progress server.BatchProgress) {
+			logging.Bind(logger, progress.CorrelationID).WithFields(logrus.Fields{
+				"processed": progress.Processed,
+				"failed":    progress.Failed,
+				"skipped":   progress.Skipped,
+				"total":     progress.Total,
+			}).Info("Batch progress")
+		})
+	}
+
+	// Routed batches resolve the results of several profiles, so they need
+	// the resolver configuration
+	if cfg.Profiles.ResolverConfig != "" {
+		router, err := profile.NewRouter(cfg.Profiles.Routing, logger)
+		if err != nil {
+			fmt.Fprintf(stderr, "serve failed: profiles.routing: %v\n", err)
+			return 2
+		}
+		policyResolver, err := resolver.NewPolicyResolver(cfg.Profiles.ResolverConfig, logger)
+		if err != nil {
+			fmt.Fprintf(stderr, "serve failed: %v\n", err)
+			return 1
+		}
+		srv.SetRouting(router, policyResolver)
+		if policyResolver.AdaptiveEnabled() {
+			// User feedback also scores the profiles that classified the email
+			recorder.SetWeights(policyResolver)
+		}
+	}
+
+	if err := srv.Run(ctx); err != nil {
+		fmt.Fprintf(stderr, "serve failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// mailboxOption names the option that needs the mailbox, for errors
+// creating its client
+func mailboxOption(cfg *config.Config) string {
+	if cfg.Server.ApplyActions {
+		return "server.apply_actions"
+	}
+	return "server.classify_by_id"
+}
+
+// newClassifier creates the LLM backend selected by llm.backend, with
+// few-shot selection when the backend can embed and the retry queue when
+// llm.retry_queue is enabled, behind the classification
+// cache when llm.cache is enabled, behind personal data redaction when
+// security.redaction is enabled, with thread context when
+// llm.thread_context is enabled and expanding previews when llm.previews is
+// enabled, returning the backend name along with its health check. Calls
+// reaching the backend and Gmail requests share the caps of limits, which
+// are created from concurrency when nil.
+func newClassifier(cfg *config.Config, limits *limit.Limits, auditLogger *audit.Logger, logger *logrus.Logger) (string, llm.Classifier, server.HealthCheck, error) {
+	backend, classifier, healthCheck, err := newBackend(cfg, auditLogger, logger)
+	if err != nil {
+		return backend, classifier, healthCheck, err
+	}
+	if limits == nil {
+		limits = limit.NewLimits(cfg.Concurrency)
+	}
+	breaker, observable := classifier.(llm.BreakerObservable)
+	embedder, embeds := classifier.(llm.Embedder)
+	// Cache hits and queued retries do not hold an inference permit
+	classifier = llm.NewLimitedClassifier(classifier, limits.LLM)
+	if embeds {
+		classifier = llm.NewFewShotClassifier(classifier, llm.NewLimitedEmbedder(embedder, limits.LLM), logger)
+	}
+	if cfg.LLM.RetryQueue.Enabled && observable {
+		classifier = llm.NewRetryQueue(classifier, breaker, cfg.LLM.RetryQueue, logger)
+	}
+	// System mail is answered without the backend, so it does not wait in
+	// the retry queue while the backend is down
+	systemMail := llm.NewSystemMailClassifier(classifier, logger)
+	systemMail.SetAuditLogger(auditLogger)
+	classifier = systemMail
+	if cfg.LLM.Cache.Enabled {
+		cached := llm.NewCachingClassifier(classifier, llm.NewMemoryCache(cfg.LLM.Cache.MaxEntries), logger)
+		cached.SetAuditLogger(auditLogger)
+		classifier = cached
+	}
+	if cfg.Security.Redaction.Enabled {
+		redactor, err := redact.New(cfg.Security.Redaction)
+		if err != nil {
+			return backend, nil, nil, fmt.Errorf("security.redaction: %w", err)
+		}
+		classifier = llm.NewRedactingClassifier(classifier, redactor, cfg.Security.Redaction.Restore)
+		if auditLogger != nil {
+			auditLogger.SetRedactor(redactor)
+		}
+	}
+	if !cfg.LLM.ThreadContext.Enabled && !cfg.LLM.Previews.Enabled {
+		return backend, classifier, healthCheck, nil
+	}
+
+	if cfg.LLM.ThreadContext.Enabled {
+		// Thread context wraps redaction, which covers the included
+		// messages, and the cache, whose keys cover them
+		fetcher, err := newThreadFetcher(cfg, limits, "llm.thread_context", logger)
+		if err != nil {
+			return backend, nil, nil, err
+		}
+		classifier = llm.NewThreadClassifier(classifier, fetcher, cfg.LLM.ThreadContext, logger)
+	}
+	if cfg.LLM.Previews.Enabled {
+		// Expanded previews are classified with their thread context
+		fetcher, err := newThreadFetcher(cfg, limits, "llm.previews", logger)
+		if err != nil {
+			return backend, nil, nil, err
+		}
+		classifier = llm.NewPreviewClassifier(classifier, fetcher, logger)
+	}
+	return backend, classifier, healthCheck, nil
+}
+
+// newThreadFetcher creates the mail client threads are fetched with, which
+// only Gmail supports, for the feature configured under key, sharing the
+// Gmail permits of limits
+func newThreadFetcher(cfg *config.Config, limits *limit.Limits, key string, logger *logrus.Logger) (llm.ThreadFetcher, error) {
+	client, err := mailbox.New(cfg, logger)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	if gmailClient, ok := client.(*gmail.Client); ok {
+		gmailClient.SetLimiter(limits.Gmail)
+	}
+	fetcher, ok := client.(llm.ThreadFetcher)
+	if !ok {
+		return nil, fmt.Errorf("%s: the %s mail provider cannot fetch threads", key, cfg.Mail.Provider)
+	}
+	return fetcher, nil
+}
+
+// newBackend creates the LLM backend selected by llm.backend. Ollama has to
+// be healthy at startup.
+func newBackend(cfg *config.Config, auditLogger *audit.Logger, logger *logrus.Logger) (string, llm.Classifier, server.HealthCheck, error) {
+	switch cfg.LLM.Backend {
+	case "", config.LLMBackendOllama:
+		client := ollama.NewClient(&cfg.Ollama, logger)
+		client.SetAuditLogger(auditLogger)
+		client.SetRawResponse(cfg.LLM.RawResponse)
+		client.SetEmptyResponse(cfg.LLM.EmptyResponse)
+		// Closing the client on shutdown stops the health loop
+		if err := client.Start(); err != nil {
+			return config.LLMBackendOllama, nil, nil, err
+		}
+		if cfg.Ollama.PullMissing {
+			if err := client.EnsureModel(context.Background(), cfg.Ollama.DefaultModel); err != nil {
+				logger.WithError(err).Warn("Failed to pull the default model")
+			}
+		}
+		// With a keep-alive configured, load the default model now rather
+		// than on the first batch
+		if cfg.Ollama.KeepAlive != 0 {
+			if err := client.Preload(context.Background(), cfg.Ollama.DefaultModel); err != nil {
+				logger.WithError(err).Warn("Failed to preload the default model")
+			}
+		}
+		return config.LLMBackendOllama, client, func(ctx context.Context) server.ComponentStatus {
+			return client.Status(ctx)
+		}, nil
+	case config.LLMBackendOpenAI:
+		client := openai.NewClient(&cfg.LLM.OpenAI, logger)
+		client.SetAuditLogger(auditLogger)
+		client.SetRawResponse(cfg.LLM.RawResponse)
+		client.SetEmptyResponse(cfg.LLM.EmptyResponse)
+		return config.LLMBackendOpenAI, client, func(ctx context.Context) server.ComponentStatus {
+			return llm.CheckHealth(ctx, client)
+		}, nil
+	default:
+		return "", nil, nil, fmt.Errorf("unknown llm.backend %q", cfg.LLM.Backend)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/replay"
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+// runAuditShadow compares the decisions a shadow profile recorded in the
+// audit log with those of the active profile it runs alongside. Nothing is
+// classified; the report is built from the audit log alone.
+func runAuditShadow(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("audit shadow", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "config.yaml", "configuration file")
+	profileID := flags.String("profile", "", "shadow profile to report on")
+	against := flags.String("against", "", "active profile to compare with (defaults to the recorded shadow_of)")
+	dir := flags.String("dir", "", "audit directory (defaults to audit.directory)")
+	since := flags.Duration("since", 0, "only compare decisions recorded within this duration, e.g. 168h")
+	jsonOutput := flags.Bool("json", false, "print the full report as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: mailsentinel audit shadow -profile <id> [-against <id>] [flags]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *profileID == "" {
+		flags.Usage()
+		return 2
+	}
+
+	if *dir == "" {
+		cfg, err := config.LoadConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "shadow report failed: %v\n", err)
+			return 2
+		}
+		*dir = cfg.Audit.Directory
+	}
+
+	query := audit.Query{EventTypes: []string{audit.EventEmailClassified}}
+	if *since > 0 {
+		query.Since = time.Now().Add(-*since)
+	}
+	entries, err := audit.QueryDirectory(*dir, query)
+	if err != nil {
+		fmt.Fprintf(stderr, "shadow report failed: %v\n", err)
+		return 1
+	}
+
+	report := replay.CompareShadow(entries, *profileID, *against)
+	if report.RecordedProfile == "" {
+		fmt.Fprintf(stderr, "shadow report failed: no shadow decisions recorded for %s; name the active profile with -against\n", *profileID)
+		return 1
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintf(stderr, "shadow report failed: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	printReplayReport(stdout, "SHADOW", report)
+	return 0
+}
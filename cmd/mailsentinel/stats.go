@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/stats"
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+// runAuditStats reports sender and domain statistics over the
+// classifications recorded in the audit log
+func runAuditStats(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("audit stats", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "config.yaml", "configuration file")
+	profileID := flags.String("profile", "", "only count decisions of this profile")
+	dir := flags.String("dir", "", "audit directory (defaults to audit.directory)")
+	since := flags.Duration("since", 0, "only count decisions recorded within this duration, e.g. 168h")
+	top := flags.Int("top", 10, "number of top senders to list")
+	buckets := flags.Int("buckets", stats.DefaultBuckets, "number of confidence buckets")
+	jsonOutput := flags.Bool("json", false, "print the full report as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: mailsentinel audit stats [flags]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *dir == "" {
+		cfg, err := config.LoadConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "stats report failed: %v\n", err)
+			return 2
+		}
+		*dir = cfg.Audit.Directory
+	}
+
+	query := audit.Query{EventTypes: []string{audit.EventEmailClassified}, ProfileID: *profileID}
+	if *since > 0 {
+		query.Since = time.Now().Add(-*since)
+	}
+	entries, err := audit.QueryDirectory(*dir, query)
+	if err != nil {
+		fmt.Fprintf(stderr, "stats report failed: %v\n", err)
+		return 1
+	}
+
+	aggregator := stats.NewAggregator(*buckets)
+	for _, entry := range entries {
+		aggregator.AddEntry(entry)
+	}
+	report := aggregator.Report(*top)
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintf(stderr, "stats report failed: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	printStatsReport(stdout, report)
+	return 0
+}
+
+// printStatsReport prints the summary, the top senders, the per-domain
+// action breakdown and the confidence histogram
+func printStatsReport(stdout io.Writer, report *stats.StatsReport) {
+	fmt.Fprintf(stdout, "STATS %s\n", report)
+
+	for _, sender := range report.TopSenders {
+		fmt.Fprintf(stdout, "SENDER %s: %d (%s)\n", sender.Sender, sender.Count, formatCounts(sender.Actions))
+	}
+	for _, domain := range report.Domains {
+		fmt.Fprintf(stdout, "DOMAIN %s: %d from %d senders, mean confidence %.3f (%s)\n",
+			domain.Domain, domain.Count, domain.Senders, domain.MeanConfidence, formatCounts(domain.Actions))
+	}
+	for _, bucket := range report.Confidence {
+		fmt.Fprintf(stdout, "CONFIDENCE %.2f-%.2f: %d\n", bucket.Min, bucket.Max, bucket.Count)
+	}
+}
+
+// formatCounts formats action counts as "archive: 3, keep: 1", by action
+func formatCounts(counts map[string]int) string {
+	actions := make([]string, 0, len(counts))
+	for action := range counts {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	formatted := make([]string, len(actions))
+	for i, action := range actions {
+		formatted[i] = fmt.Sprintf("%s: %d", action, counts[action])
+	}
+	return strings.Join(formatted, ", ")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/mailsentinel/core/internal/audit"
+)
+
+// runAuditVerify verifies exported audit files offline using only the
+// Ed25519 public key. It exits with 1 when any file fails verification.
+func runAuditVerify(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	publicKeyPath := flags.String("pubkey", "", "PEM encoded Ed25519 public key")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: mailsentinel audit verify -pubkey <key.pem> <audit file>...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *publicKeyPath == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	publicKey, err := audit.LoadPublicKey(*publicKeyPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "verify failed: %v\n", err)
+		return 2
+	}
+
+	failed := 0
+	for _, filename := range flags.Args() {
+		report, err := audit.VerifyFile(filename, publicKey)
+		if err != nil {
+			failed++
+			printVerifyFailure(stdout, filename, err)
+			continue
+		}
+
+		fmt.Fprintf(stdout, "PASS %s: %d entries verified, chain %q, final hash %s\n", filename, report.Entries, report.ChainID, report.FinalHash)
+		if report.PrevFile != "" {
+			fmt.Fprintf(stdout, "     continues from %s, which must be verified separately\n", report.PrevFile)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(stdout, "%d of %d file(s) failed verification\n", failed, flags.NArg())
+		return 1
+	}
+	return 0
+}
+
+// printVerifyFailure reports a failed file along with the first failing entry
+func printVerifyFailure(stdout io.Writer, filename string, err error) {
+	fmt.Fprintf(stdout, "FAIL %s: %v\n", filename, err)
+
+	var verr *audit.VerificationError
+	if !errors.As(err, &verr) || verr.Entry == nil {
+		return
+	}
+	if data, err := json.Marshal(verr.Entry); err == nil {
+		fmt.Fprintf(stdout, "     first failing entry: %s\n", data)
+	}
+}
@@ -0,0 +1,25 @@
+// Package annotate computes everything the server derives from an email
+// before it is classified, so that every path classifying an email, from
+// the API and the dead letter queue to corpus evaluation, sees the same
+// annotations.
+package annotate
+
+import (
+	"github.com/mailsentinel/core/internal/emptybody"
+	"github.com/mailsentinel/core/internal/homoglyph"
+	"github.com/mailsentinel/core/internal/language"
+	"github.com/mailsentinel/core/internal/links"
+	"github.com/mailsentinel/core/internal/replyspoof"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Email sets an email's links, homoglyph and reply analyses, language and
+// empty body flag, replacing any it carries. Links come first, as the
+// homoglyph analysis normalizes their hosts.
+func Email(email *types.Email) {
+	links.Annotate(email)
+	homoglyph.Annotate(email)
+	replyspoof.Annotate(email)
+	language.Annotate(email)
+	emptybody.Annotate(email)
+}
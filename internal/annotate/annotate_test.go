@@ -0,0 +1,30 @@
+package annotate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestEmail(t *testing.T) {
+	email := &types.Email{
+		From:      "support@pаypal.com",
+		Subject:   "Re: Your account",
+		Body:      "Please verify your account at https://bit.ly/verify before it is closed today.",
+		BodyHTML:  `<a href="https://evil.example/login">https://paypal.com</a>`,
+		EmptyBody: true,
+	}
+	Email(email)
+
+	require.Len(t, email.URLs, 2)
+	assert.True(t, email.URLs[0].Mismatch, "the anchor text names another host")
+	require.NotNil(t, email.Homoglyphs)
+	assert.True(t, email.Homoglyphs.Suspicious, "the sender domain uses a Cyrillic a")
+	require.NotNil(t, email.Reply)
+	assert.True(t, email.Reply.Spoofed, "a reply without threading headers")
+	assert.Equal(t, "en", email.Language)
+	assert.False(t, email.EmptyBody, "flags the email carries are replaced")
+}
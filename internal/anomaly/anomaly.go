@@ -0,0 +1,236 @@
+// Package anomaly watches the audit event stream for sudden changes that
+// suggest a targeted attack or a broken profile: a spike of classifications
+// toward one action, such as delete, or a burst of security violations.
+//
+// A Detector counts events over a sliding window and, when a configured
+// threshold is crossed, records an audit.EventAnomalyDetected entry and
+// optionally posts the anomaly to a webhook. Each anomaly is reported at most
+// once per cooldown while it persists.
+package anomaly
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Anomaly rules
+const (
+	RuleActionCount        = "action_count"
+	RuleActionShare        = "action_share"
+	RuleSecurityViolations = "security_violations"
+)
+
+// Anomaly is a threshold crossed within the window
+type Anomaly struct {
+	Rule       string        `json:"rule"`
+	Action     string        `json:"action,omitempty"`
+	Observed   float64       `json:"observed"`
+	Threshold  float64       `json:"threshold"`
+	Window     time.Duration `json:"window"`
+	DetectedAt time.Time     `json:"detected_at"`
+}
+
+// key identifies an anomaly for its cooldown
+func (a Anomaly) key() string {
+	return a.Rule + "/" + a.Action
+}
+
+// event is a counted audit event: a classification to action, or a
+// security violation when action is empty
+type event struct {
+	at     time.Time
+	action string
+}
+
+// Detector tracks action counts and security violations of audit entries
+// over a sliding window
+type Detector struct {
+	config     config.AnomalyConfig
+	audit      *audit.Logger
+	logger     *logrus.Logger
+	httpClient *http.Client
+	clock      clock.Clock
+
+	mutex        sync.Mutex
+	classified   []event
+	violations   []event
+	lastReported map[string]time.Time
+	webhooks     sync.WaitGroup
+}
+
+// NewDetector creates a detector reporting to auditLogger. Register its
+// Observe method with the audit logger to feed it events.
+func NewDetector(cfg config.AnomalyConfig, auditLogger *audit.Logger, logger *logrus.Logger) *Detector {
+	return &Detector{
+		config:       cfg,
+		audit:        auditLogger,
+		logger:       logger,
+		httpClient:   &http.Client{Timeout: cfg.WebhookTimeout},
+		clock:        clock.Real{},
+		lastReported: make(map[string]time.Time),
+	}
+}
+
+// Observe counts an audit entry and reports the anomalies it causes. It is
+// an audit.Observer.
+func (d *Detector) Observe(entry audit.AuditEntry) {
+	// Shadow profiles never act, so their decisions are not counted
+	if shadow, _ := entry.Metadata[types.MetadataShadow].(bool); shadow {
+		return
+	}
+	var anomalies []Anomaly
+
+	d.mutex.Lock()
+	now := d.clock.Now()
+	switch entry.EventType {
+	case audit.EventEmailClassified:
+		d.classified = append(d.classified, event{at: now, action: entry.Action})
+	case audit.EventSecurityViolation:
+		d.violations = append(d.violations, event{at: now})
+	default:
+		d.mutex.Unlock()
+		return
+	}
+	d.prune(now)
+	for _, anomaly := range d.check(now) {
+		if last, reported := d.lastReported[anomaly.key()]; reported && now.Sub(last) < d.config.Cooldown {
+			continue
+		}
+		d.lastReported[anomaly.key()] = now
+		anomalies = append(anomalies, anomaly)
+	}
+	d.mutex.Unlock()
+
+	// Reported without the lock, as the audit entry is observed in turn
+	for _, anomaly := range anomalies {
+		d.report(anomaly)
+	}
+}
+
+// Close waits for webhook deliveries in flight
+func (d *Detector) Close() error {
+	d.webhooks.Wait()
+	return nil
+}
+
+// prune drops events older than the window. The caller must hold the mutex.
+func (d *Detector) prune(now time.Time) {
+	cutoff := now.Add(-d.config.Window)
+	d.classified = dropBefore(d.classified, cutoff)
+	d.violations = dropBefore(d.violations, cutoff)
+}
+
+// dropBefore removes the events at or before cutoff from a list in time order
+func dropBefore(events []event, cutoff time.Time) []event {
+	i := 0
+	for i < len(events) && !events[i].at.After(cutoff) {
+		i++
+	}
+	return events[i:]
+}
+
+// check returns the thresholds the window's events exceed, in a stable
+// order. The caller must hold the mutex.
+func (d *Detector) check(now time.Time) []Anomaly {
+	newAnomaly := func(rule, action string, observed, threshold float64) Anomaly {
+		return Anomaly{Rule: rule, Action: action, Observed: observed, Threshold: threshold, Window: d.config.Window, DetectedAt: now}
+	}
+
+	counts := make(map[string]int)
+	for _, e := range d.classified {
+		counts[e.action]++
+	}
+
+	var anomalies []Anomaly
+	for _, action := range sortedKeys(d.config.ActionCounts) {
+		if limit := d.config.ActionCounts[action]; counts[action] > limit {
+			anomalies = append(anomalies, newAnomaly(RuleActionCount, action, float64(counts[action]), float64(limit)))
+		}
+	}
+	if total := len(d.classified); total > 0 && total >= d.config.MinClassifications {
+		for _, action := range sortedKeys(d.config.ActionShares) {
+			share := float64(counts[action]) / float64(total)
+			if limit := d.config.ActionShares[action]; share > limit {
+				anomalies = append(anomalies, newAnomaly(RuleActionShare, action, share, limit))
+			}
+		}
+	}
+	if limit := d.config.SecurityViolations; limit > 0 && len(d.violations) > limit {
+		anomalies = append(anomalies, newAnomaly(RuleSecurityViolations, "", float64(len(d.violations)), float64(limit)))
+	}
+	return anomalies
+}
+
+// report records an anomaly in the audit log and posts it to the webhook
+func (d *Detector) report(anomaly Anomaly) {
+	fields := logrus.Fields{
+		"rule":      anomaly.Rule,
+		"action":    anomaly.Action,
+		"observed":  anomaly.Observed,
+		"threshold": anomaly.Threshold,
+		"window":    anomaly.Window,
+	}
+	d.logger.WithFields(fields).Warn("Anomaly detected")
+
+	if err := d.audit.LogSystemEvent(audit.EventAnomalyDetected, map[string]interface{}{
+		"rule":      anomaly.Rule,
+		"action":    anomaly.Action,
+		"observed":  anomaly.Observed,
+		"threshold": anomaly.Threshold,
+		"window":    anomaly.Window.String(),
+	}); err != nil {
+		d.logger.WithError(err).WithFields(fields).Error("Failed to audit anomaly")
+	}
+
+	if d.config.WebhookURL == "" {
+		return
+	}
+	d.webhooks.Add(1)
+	go func() {
+		defer d.webhooks.Done()
+		if err := d.post(anomaly); err != nil {
+			d.logger.WithError(err).WithFields(fields).Error("Failed to deliver anomaly webhook")
+		}
+	}()
+}
+
+// post sends an anomaly to the webhook as JSON
+func (d *Detector) post(anomaly Anomaly) error {
+	body, err := json.Marshal(anomaly)
+	if err != nil {
+		return fmt.Errorf("failed to encode anomaly: %w", err)
+	}
+
+	resp, err := d.httpClient.Post(d.config.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post anomaly: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("anomaly webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sortedKeys returns a threshold map's actions in order
+func sortedKeys[V int | float64](thresholds map[string]V) []string {
+	keys := make([]string, 0, len(thresholds))
+	for key := range thresholds {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,160 @@
+package anomaly
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestDetectorActionCountOverWindow(t *testing.T) {
+	auditLogger := newTestAudit(t)
+	clk := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	detector := newTestDetector(t, config.AnomalyConfig{
+		Window:       10 * time.Minute,
+		Cooldown:     10 * time.Minute,
+		ActionCounts: map[string]int{"delete": 3},
+	}, auditLogger, clk)
+
+	// Three deletes spread beyond the window never coexist in it
+	for i := 0; i < 4; i++ {
+		classify(t, auditLogger, i, "delete")
+		clk.Advance(6 * time.Minute)
+	}
+	assert.Empty(t, anomalies(t, auditLogger))
+
+	for i := 0; i < 4; i++ {
+		classify(t, auditLogger, i, "delete")
+		clk.Advance(time.Second)
+	}
+	reported := anomalies(t, auditLogger)
+	require.Len(t, reported, 1)
+	assert.Equal(t, RuleActionCount, reported[0].Metadata["rule"])
+	assert.Equal(t, "delete", reported[0].Metadata["action"])
+	assert.Equal(t, float64(4), reported[0].Metadata["observed"])
+	assert.Equal(t, "10m0s", reported[0].Metadata["window"])
+
+	// Still breached, but within the cooldown
+	classify(t, auditLogger, 5, "delete")
+	assert.Len(t, anomalies(t, auditLogger), 1)
+
+	clk.Advance(10 * time.Minute)
+	for i := 0; i < 4; i++ {
+		classify(t, auditLogger, i, "delete")
+	}
+	assert.Len(t, anomalies(t, auditLogger), 2, "reported again after the cooldown")
+	require.NoError(t, detector.Close())
+}
+
+func TestDetectorActionShare(t *testing.T) {
+	auditLogger := newTestAudit(t)
+	clk := clock.NewFake(time.Now())
+	newTestDetector(t, config.AnomalyConfig{
+		Window:             time.Hour,
+		ActionShares:       map[string]float64{"delete": 0.5},
+		MinClassifications: 4,
+	}, auditLogger, clk)
+
+	classify(t, auditLogger, 1, "delete")
+	classify(t, auditLogger, 2, "delete")
+	assert.Empty(t, anomalies(t, auditLogger), "too few classifications to judge a share")
+
+	classify(t, auditLogger, 3, "archive")
+	classify(t, auditLogger, 4, "delete")
+	reported := anomalies(t, auditLogger)
+	require.Len(t, reported, 1)
+	assert.Equal(t, RuleActionShare, reported[0].Metadata["rule"])
+	assert.Equal(t, 0.75, reported[0].Metadata["observed"])
+}
+
+func TestDetectorSecurityViolationsAndWebhook(t *testing.T) {
+	var mutex sync.Mutex
+	var received []Anomaly
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var anomaly Anomaly
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&anomaly))
+		mutex.Lock()
+		received = append(received, anomaly)
+		mutex.Unlock()
+	}))
+	defer webhook.Close()
+
+	auditLogger := newTestAudit(t)
+	clk := clock.NewFake(time.Now())
+	detector := newTestDetector(t, config.AnomalyConfig{
+		Window:             time.Minute,
+		SecurityViolations: 2,
+		WebhookURL:         webhook.URL,
+		WebhookTimeout:     time.Second,
+	}, auditLogger, clk)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, auditLogger.LogSecurityViolation("prompt_injection", "suspicious instructions", nil))
+	}
+	require.NoError(t, detector.Close())
+
+	reported := anomalies(t, auditLogger)
+	require.Len(t, reported, 1)
+	assert.Equal(t, RuleSecurityViolations, reported[0].Metadata["rule"])
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.Len(t, received, 1)
+	assert.Equal(t, RuleSecurityViolations, received[0].Rule)
+	assert.Equal(t, float64(3), received[0].Observed)
+	assert.Equal(t, time.Minute, received[0].Window)
+}
+
+// Helper functions
+
+func newTestAudit(t *testing.T) *audit.Logger {
+	auditLogger, err := audit.NewLogger(&config.AuditConfig{
+		Enabled:     true,
+		Directory:   t.TempDir(),
+		MaxFileSize: 1024 * 1024,
+		MaxFiles:    5,
+	}, testLogger())
+	require.NoError(t, err)
+	t.Cleanup(func() { auditLogger.Close() })
+	return auditLogger
+}
+
+func newTestDetector(t *testing.T, cfg config.AnomalyConfig, auditLogger *audit.Logger, clk *clock.Fake) *Detector {
+	detector := NewDetector(cfg, auditLogger, testLogger())
+	detector.clock = clk
+	auditLogger.AddObserver(detector.Observe)
+	return detector
+}
+
+func classify(t *testing.T, auditLogger *audit.Logger, n int, action string) {
+	email := &types.Email{ID: fmt.Sprintf("email-%d", n)}
+	require.NoError(t, auditLogger.LogEmailClassification(context.Background(), email, &types.ClassificationResponse{
+		ProfileID: "spam",
+		Action:    action,
+	}))
+}
+
+func anomalies(t *testing.T, auditLogger *audit.Logger) []audit.AuditEntry {
+	entries, err := auditLogger.Query(audit.Query{EventTypes: []string{audit.EventAnomalyDetected}})
+	require.NoError(t, err)
+	return entries
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"fmt"
+	"time"
+)
+
+// Defaults for buffered write mode when AuditConfig leaves them unset
+const (
+	DefaultFlushInterval = time.Second
+	DefaultFlushEntries  = 100
+)
+
+// criticalEvents are flushed to disk as soon as they are written, even in
+// buffered mode, so they are never reported as logged without being durable
+var criticalEvents = map[string]bool{
+	EventSecurityViolation: true,
+	EventSystemStart:       true,
+	EventSystemStop:        true,
+	EventMessageDeleted:    true,
+	EventChainGenesis:      true,
+	EventChainRotated:      true,
+	EventChainContinued:    true,
+}
+
+// Flush writes any buffered entries to the audit file and syncs it to disk.
+// It is a no-op unless buffered writes are enabled.
+func (l *Logger) Flush() error {
+	if !l.config.Enabled || l.file == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.flush()
+}
+
+// flush writes the pending entries with a single write followed by a sync.
+// Entries are only ever written whole and in chain order, so the file on
+// disk is always a verifiable prefix of the chain. The caller must hold the
+// mutex.
+func (l *Logger) flush() error {
+	if len(l.pending) == 0 {
+		return nil
+	}
+
+	written, err := l.file.Write(l.pending)
+	l.pending = l.pending[written:]
+	if err != nil {
+		return fmt.Errorf("failed to write audit entries: %w", err)
+	}
+
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit file: %w", err)
+	}
+
+	l.logger.WithField("entries", l.pendingEntries).Debug("Flushed audit entries")
+	l.pending = l.pending[:0]
+	l.pendingEntries = 0
+	return nil
+}
+
+// flushEntries returns the number of buffered entries that triggers a flush
+func (l *Logger) flushEntries() int {
+	if l.config.FlushEntries > 0 {
+		return l.config.FlushEntries
+	}
+	return DefaultFlushEntries
+}
+
+// startFlusher starts the background goroutine that flushes buffered
+// entries every FlushInterval
+func (l *Logger) startFlusher() {
+	if !l.config.BufferedWrites {
+		return
+	}
+
+	interval := l.config.FlushInterval
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+
+	l.stopFlusher = make(chan struct{})
+	l.flusherDone = make(chan struct{})
+
+	go func() {
+		defer close(l.flusherDone)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := l.Flush(); err != nil {
+					l.logger.WithError(err).Error("Failed to flush audit entries")
+				}
+			case <-l.stopFlusher:
+				return
+			}
+		}
+	}()
+}
+
+// stopFlushing stops the background flusher and flushes any remaining entries
+func (l *Logger) stopFlushing() error {
+	if l.stopFlusher != nil {
+		close(l.stopFlusher)
+		<-l.flusherDone
+		l.stopFlusher = nil
+	}
+	return l.Flush()
+}
@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestBufferedWritesFlushOnEntryCount(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	cfg.BufferedWrites = true
+	cfg.FlushEntries = 4
+	cfg.FlushInterval = time.Hour
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	defer logger.Close()
+
+	// Genesis is critical and reaches disk immediately
+	assert.Equal(t, 1, countLines(t, logger.filename))
+
+	email := &types.Email{ID: "email"}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, logger.LogAction(context.Background(), email, "archive", "-INBOX"))
+	}
+	assert.Equal(t, 1, countLines(t, logger.filename), "entries below the threshold stay buffered")
+
+	require.NoError(t, logger.LogAction(context.Background(), email, "archive", "-INBOX"))
+	assert.Equal(t, 5, countLines(t, logger.filename))
+	assert.NoError(t, logger.VerifyChain())
+}
+
+func TestBufferedWritesFlushCriticalEvents(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	cfg.BufferedWrites = true
+	cfg.FlushEntries = 100
+	cfg.FlushInterval = time.Hour
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	defer logger.Close()
+
+	require.NoError(t, logger.LogAction(context.Background(), &types.Email{ID: "email"}, "archive", "-INBOX"))
+	require.NoError(t, logger.LogSecurityViolation("prompt_injection", "Injected instructions", nil))
+
+	// The security violation flushes everything queued before it
+	assert.Equal(t, 3, countLines(t, logger.filename))
+}
+
+func TestBufferedWritesFlushOnInterval(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	cfg.BufferedWrites = true
+	cfg.FlushEntries = 100
+	cfg.FlushInterval = 10 * time.Millisecond
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	defer logger.Close()
+
+	require.NoError(t, logger.LogAction(context.Background(), &types.Email{ID: "email"}, "archive", "-INBOX"))
+	assert.Eventually(t, func() bool {
+		return countLines(t, logger.filename) == 2
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestBufferedWritesFlushedOnClose(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	cfg.BufferedWrites = true
+	cfg.FlushEntries = 100
+	cfg.FlushInterval = time.Hour
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+
+	writeTestEntries(t, logger, 5)
+	require.NoError(t, logger.Close())
+
+	reopened, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	entries, err := reopened.readAllEntries()
+	require.NoError(t, err)
+	assert.Len(t, entries, 12, "genesis, ten entries and system stop")
+	assert.NoError(t, reopened.VerifyAllChains())
+}
+
+func BenchmarkLogActionSynced(b *testing.B) {
+	benchmarkLogAction(b, false)
+}
+
+func BenchmarkLogActionBuffered(b *testing.B) {
+	benchmarkLogAction(b, true)
+}
+
+// Helper functions
+
+func benchmarkLogAction(b *testing.B, buffered bool) {
+	cfg := testAuditConfig(b.TempDir())
+	cfg.BufferedWrites = buffered
+	cfg.IntegrityCheck = false
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(b, err)
+	defer logger.Close()
+
+	email := &types.Email{ID: "email"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := logger.LogAction(context.Background(), email, "archive", "-INBOX"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "entries/sec")
+}
+
+func countLines(t *testing.T, filename string) int {
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	return strings.Count(string(data), "\n")
+}
@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// canonicalJSON encodes v as JSON with object keys sorted at every level, no
+// insignificant whitespace, no HTML escaping and numbers in their shortest
+// form. Logically equal values always produce identical bytes, including
+// after being written to the audit log and read back with different Go types.
+func canonicalJSON(v interface{}) ([]byte, error) {
+	// Round-trip through encoding/json so structs, typed maps and slices are
+	// reduced to the generic JSON data model before canonicalisation
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, generic); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, v interface{}) error {
+	switch value := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(value))
+	case json.Number:
+		buf.WriteString(canonicalNumber(value))
+	case string:
+		writeCanonicalString(buf, value)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range value {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, key)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, value[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unsupported canonical JSON type %T", v)
+	}
+	return nil
+}
+
+// canonicalNumber formats integers without a fraction or exponent and all
+// other numbers in the shortest float64 representation
+func canonicalNumber(number json.Number) string {
+	if i, err := strconv.ParseInt(number.String(), 10, 64); err == nil {
+		return strconv.FormatInt(i, 10)
+	}
+	if f, err := number.Float64(); err == nil {
+		if f == float64(int64(f)) && f >= -1<<53 && f <= 1<<53 {
+			return strconv.FormatInt(int64(f), 10)
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return number.String()
+}
+
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	// Encode terminates each value with a newline
+	buf.Truncate(buf.Len() - 1)
+}
@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"sorted keys", map[string]interface{}{"b": 1, "a": 2}, `{"a":2,"b":1}`},
+		{"nested maps", map[string]interface{}{"z": map[string]int{"y": 1, "x": 2}}, `{"z":{"x":2,"y":1}}`},
+		{"integral float", 5.0, `5`},
+		{"fraction", 0.85, `0.85`},
+		{"no html escaping", "<a&b>", `"<a&b>"`},
+		{"special characters in keys", map[string]interface{}{"a|b": true, "a\"b": false}, `{"a\"b":false,"a|b":true}`},
+		{"typed slice", []string{"x", "y"}, `["x","y"]`},
+		{"null", nil, `null`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := canonicalJSON(tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(data))
+		})
+	}
+}
+
+func TestCalculateHashIgnoresMetadataOrdering(t *testing.T) {
+	l := testHashLogger()
+	timestamp := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	first := map[string]interface{}{}
+	first["labels"] = []string{"spam", "promo"}
+	first["email_size"] = 1024
+	first["nested"] = map[string]interface{}{"b": 0.5, "a": "x"}
+
+	second := map[string]interface{}{}
+	second["nested"] = map[string]interface{}{"a": "x", "b": 0.5}
+	second["email_size"] = float64(1024)
+	second["labels"] = []interface{}{"spam", "promo"}
+
+	entryA := testHashEntry(timestamp, first)
+	entryB := testHashEntry(timestamp, second)
+	assert.Equal(t, mustHash(t, l, entryA), mustHash(t, l, entryB))
+
+	// The hash must also survive a round trip through the log file format
+	data, err := json.Marshal(entryA)
+	require.NoError(t, err)
+	var decoded AuditEntry
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, mustHash(t, l, entryA), mustHash(t, l, &decoded))
+
+	entryB.Metadata["email_size"] = 2048
+	assert.NotEqual(t, mustHash(t, l, entryA), mustHash(t, l, entryB))
+}
+
+func TestCalculateHashCoversReasoning(t *testing.T) {
+	l := testHashLogger()
+	entry := testHashEntry(time.Now(), nil)
+	original := mustHash(t, l, entry)
+
+	entry.Reasoning = "tampered"
+	assert.NotEqual(t, original, mustHash(t, l, entry))
+}
+
+func TestCalculateHashFailsForUnencodableEntry(t *testing.T) {
+	entry := testHashEntry(time.Now(), nil)
+	entry.Confidence = math.NaN()
+
+	_, err := testHashLogger().calculateHash(entry)
+	assert.Error(t, err)
+}
+
+// Helper functions
+
+func testHashLogger() *Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return &Logger{config: &config.AuditConfig{}, logger: logger}
+}
+
+func mustHash(t *testing.T, l *Logger, entry *AuditEntry) string {
+	hash, err := l.calculateHash(entry)
+	require.NoError(t, err)
+	return hash
+}
+
+func testHashEntry(timestamp time.Time, metadata map[string]interface{}) *AuditEntry {
+	return &AuditEntry{
+		ID:         "1",
+		Timestamp:  timestamp,
+		EventType:  EventEmailClassified,
+		EmailID:    "email-1",
+		ProfileID:  "spam",
+		Action:     "delete",
+		Confidence: 0.9,
+		Reasoning:  "Obvious spam",
+		PrevHash:   "abc",
+		Metadata:   metadata,
+	}
+}
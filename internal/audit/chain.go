@@ -0,0 +1,302 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// VerificationError identifies the exact location of an audit chain break
+type VerificationError struct {
+	File   string
+	Index  int
+	Entry  *AuditEntry // the failing entry, when it could be parsed
+	Reason string
+}
+
+// Error implements the error interface
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("audit chain invalid at %s entry %d: %s", e.File, e.Index, e.Reason)
+}
+
+// VerifyAllChains verifies the complete audit history. Files are verified in
+// chronological order and the hash chain is followed across file boundaries
+// using the rotation markers, so a modified, removed or reordered file is
+// reported with the file and entry index where the chain breaks.
+func (l *Logger) VerifyAllChains() error {
+	if !l.config.Enabled || !l.config.IntegrityCheck {
+		return nil
+	}
+
+	if err := l.Flush(); err != nil {
+		return fmt.Errorf("failed to flush audit entries: %w", err)
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	files, err := l.listAuditFiles()
+	if err != nil {
+		return fmt.Errorf("failed to list audit files: %w", err)
+	}
+	legacy, files, err := splitLegacyFiles(files)
+	if err != nil {
+		return fmt.Errorf("failed to list audit files: %w", err)
+	}
+	if len(legacy) > 0 {
+		l.logger.WithFields(logrus.Fields{
+			"legacy_files": len(legacy),
+			"version":      legacyChainVersion,
+		}).Warn("Skipping the audit files of a legacy chain, which cannot be verified under the current hash scheme")
+	}
+
+	var prevHash, prevFile string
+	total := 0
+	for fileIndex, path := range files {
+		file := filepath.Base(path)
+		entries, err := readEntries(path)
+		if err != nil {
+			return fmt.Errorf("failed to read audit file %s: %w", file, err)
+		}
+		if len(entries) == 0 {
+			return &VerificationError{File: file, Index: 0, Reason: "file is empty"}
+		}
+
+		// The first file starts the chain; later files must continue it
+		first := entries[0]
+		if fileIndex == 0 {
+			if first.EventType != EventChainGenesis {
+				return &VerificationError{File: file, Index: 0, Reason: fmt.Sprintf("expected %s, got %s", EventChainGenesis, first.EventType)}
+			}
+		} else {
+			if first.EventType != EventChainContinued {
+				return &VerificationError{File: file, Index: 0, Reason: fmt.Sprintf("expected %s, got %s", EventChainContinued, first.EventType)}
+			}
+			if linked, _ := first.Metadata["prev_file"].(string); linked != prevFile {
+				return &VerificationError{File: file, Index: 0, Reason: fmt.Sprintf("continues from %s, but previous file is %s", linked, prevFile)}
+			}
+		}
+
+		// Every file but the last must end with a rotation marker naming its successor
+		if fileIndex < len(files)-1 {
+			last := entries[len(entries)-1]
+			next := filepath.Base(files[fileIndex+1])
+			if last.EventType != EventChainRotated {
+				return &VerificationError{File: file, Index: len(entries) - 1, Reason: fmt.Sprintf("expected %s, got %s", EventChainRotated, last.EventType)}
+			}
+			if linked, _ := last.Metadata["next_file"].(string); linked != next {
+				return &VerificationError{File: file, Index: len(entries) - 1, Reason: fmt.Sprintf("rotates to %s, but next file is %s", linked, next)}
+			}
+		}
+
+		prevHash, err = l.verifyEntries(file, entries, prevHash)
+		if err != nil {
+			return err
+		}
+		prevFile = file
+		total += len(entries)
+	}
+
+	l.logger.WithFields(logrus.Fields{
+		"files_verified":   len(files),
+		"entries_verified": total,
+	}).Info("Audit history verification completed successfully")
+	return nil
+}
+
+// rotateIfNeeded starts a new audit file when the current one has reached
+// MaxFileSize or is older than RotationPeriod. The caller must hold the mutex.
+func (l *Logger) rotateIfNeeded() error {
+	if l.file == nil || l.fileSize == 0 {
+		return nil
+	}
+
+	sizeExceeded := l.config.MaxFileSize > 0 && l.fileSize >= l.config.MaxFileSize
+	periodElapsed := l.config.RotationPeriod > 0 && l.clock.Now().Sub(l.openedAt) >= l.config.RotationPeriod
+	if !sizeExceeded && !periodElapsed {
+		return nil
+	}
+
+	return l.rotate()
+}
+
+// rotate closes the current audit file with a marker naming the next file
+// and opens the next file with a marker continuing the chain. The caller
+// must hold the mutex.
+func (l *Logger) rotate() error {
+	prevFile := l.filename
+	nextFile := l.nextFileName()
+
+	if err := l.writeEntry(&AuditEntry{
+		Timestamp: l.clock.Now(),
+		EventType: EventChainRotated,
+		Metadata: map[string]interface{}{
+			"next_file": filepath.Base(nextFile),
+		},
+	}); err != nil {
+		return err
+	}
+
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit file: %w", err)
+	}
+	if err := l.openFile(nextFile); err != nil {
+		return err
+	}
+
+	if err := l.writeEntry(&AuditEntry{
+		Timestamp: l.clock.Now(),
+		EventType: EventChainContinued,
+		Metadata: map[string]interface{}{
+			"prev_file": filepath.Base(prevFile),
+			"prev_hash": l.lastHash,
+		},
+	}); err != nil {
+		return err
+	}
+
+	l.logger.WithFields(logrus.Fields{
+		"prev_file": prevFile,
+		"next_file": nextFile,
+	}).Info("Rotated audit file")
+	return nil
+}
+
+// nextFileName returns an unused audit file name for today
+func (l *Logger) nextFileName() string {
+	date := l.clock.Now().Format("2006-01-02")
+	filename := filepath.Join(l.config.Directory, fmt.Sprintf("audit_%s.log", date))
+	for sequence := 1; fileInUse(filename); sequence++ {
+		filename = filepath.Join(l.config.Directory, fmt.Sprintf("audit_%s.%d.log", date, sequence))
+	}
+	return filename
+}
+
+// fileInUse reports whether a file exists and holds any data
+func fileInUse(filename string) bool {
+	info, err := os.Stat(filename)
+	return err == nil && info.Size() > 0
+}
+
+// listAuditFiles returns the non-empty audit files in chronological order,
+// determined by the timestamp of each file's first entry
+func (l *Logger) listAuditFiles() ([]string, error) {
+	return listFiles(l.config.Directory)
+}
+
+// listFiles returns the non-empty audit files in dir in chronological order
+func listFiles(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "audit_*.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	type auditFile struct {
+		path    string
+		startAt time.Time
+	}
+
+	var files []auditFile
+	for _, path := range paths {
+		if !fileInUse(path) {
+			continue
+		}
+		first, err := readFirstEntry(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		files = append(files, auditFile{path: path, startAt: first.Timestamp})
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].startAt.Equal(files[j].startAt) {
+			return files[i].path < files[j].path
+		}
+		return files[i].startAt.Before(files[j].startAt)
+	})
+
+	ordered := make([]string, len(files))
+	for i, file := range files {
+		ordered[i] = file.path
+	}
+	return ordered, nil
+}
+
+// splitLegacyFiles splits chronologically ordered audit files into the
+// leading files of a legacy chain and the files after them
+func splitLegacyFiles(files []string) ([]string, []string, error) {
+	for i, path := range files {
+		first, err := readFirstEntry(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if !legacyGenesis(first) {
+			return files[:i], files[i:], nil
+		}
+	}
+	return files, nil, nil
+}
+
+// legacyGenesis reports whether an entry starts a legacy chain: a genesis
+// entry of version 1.0 without a chain identity
+func legacyGenesis(entry *AuditEntry) bool {
+	version, _ := entry.Metadata["version"].(string)
+	return entry.EventType == EventChainGenesis && entry.ChainID == "" && version == legacyChainVersion
+}
+
+// readEntries reads and parses every entry in an audit file
+func readEntries(filename string) ([]AuditEntry, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse entry on line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// readFirstEntry parses the first entry of an audit file
+func readFirstEntry(filename string) (*AuditEntry, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("file is empty")
+	}
+
+	var entry AuditEntry
+	if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse first entry: %w", err)
+	}
+	return &entry, nil
+}
@@ -0,0 +1,333 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestVerifyChainCurrentFile(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+
+	writeTestEntries(t, logger, 3)
+	assert.NoError(t, logger.VerifyChain())
+
+	entries, err := logger.readAllEntries()
+	require.NoError(t, err)
+	require.Len(t, entries, 7, "genesis plus two entries per email")
+	assert.Equal(t, EventChainGenesis, entries[0].EventType)
+	for i := 1; i < len(entries); i++ {
+		assert.Equal(t, entries[i-1].Hash, entries[i].PrevHash)
+	}
+}
+
+func TestRotationLinksFiles(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	cfg.MaxFileSize = 1024
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+
+	writeTestEntries(t, logger, 10)
+
+	files, err := logger.listAuditFiles()
+	require.NoError(t, err)
+	require.Greater(t, len(files), 2, "small max file size forces rotation")
+
+	first, err := readEntries(files[0])
+	require.NoError(t, err)
+	second, err := readEntries(files[1])
+	require.NoError(t, err)
+
+	last := first[len(first)-1]
+	assert.Equal(t, EventChainRotated, last.EventType)
+	assert.Equal(t, filepath.Base(files[1]), last.Metadata["next_file"])
+	assert.Equal(t, EventChainContinued, second[0].EventType)
+	assert.Equal(t, filepath.Base(files[0]), second[0].Metadata["prev_file"])
+	assert.Equal(t, last.Hash, second[0].PrevHash)
+
+	assert.NoError(t, logger.VerifyAllChains())
+	assert.NoError(t, logger.VerifyChain())
+}
+
+func TestRotationPeriod(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	cfg.RotationPeriod = 24 * time.Hour
+	clk := clock.NewFake(time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC))
+	logger, err := NewLoggerWithClock(cfg, clk, testLogger())
+	require.NoError(t, err)
+
+	writeTestEntries(t, logger, 1)
+	clk.Advance(23 * time.Hour)
+	writeTestEntries(t, logger, 1)
+	files, err := logger.listAuditFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 1, "the file is not older than the rotation period yet")
+
+	clk.Advance(time.Hour)
+	writeTestEntries(t, logger, 1)
+	files, err = logger.listAuditFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, "audit_2026-03-01.log", filepath.Base(files[0]))
+	assert.Equal(t, "audit_2026-03-02.log", filepath.Base(files[1]))
+
+	second, err := readEntries(files[1])
+	require.NoError(t, err)
+	assert.Equal(t, EventChainContinued, second[0].EventType)
+	assert.Equal(t, clk.Now(), second[0].Timestamp)
+	assert.NoError(t, logger.VerifyAllChains())
+}
+
+func TestVerifyAllChainsDetectsTamperedOldFile(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	cfg.MaxFileSize = 1024
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+
+	writeTestEntries(t, logger, 10)
+
+	files, err := logger.listAuditFiles()
+	require.NoError(t, err)
+	rewriteFile(t, files[0], func(lines []string) []string {
+		lines[1] = strings.Replace(lines[1], `"action":"archive"`, `"action":"delete"`, 1)
+		return lines
+	})
+
+	err = logger.VerifyAllChains()
+	var verr *VerificationError
+	require.True(t, errors.As(err, &verr), "expected VerificationError, got %v", err)
+	assert.Equal(t, filepath.Base(files[0]), verr.File)
+	assert.Equal(t, 1, verr.Index)
+	assert.Contains(t, verr.Reason, "hash mismatch")
+
+	// Only the current file is checked by VerifyChain
+	assert.NoError(t, logger.VerifyChain())
+}
+
+func TestVerifyAllChainsDetectsRemovedFile(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	cfg.MaxFileSize = 1024
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+
+	writeTestEntries(t, logger, 10)
+
+	files, err := logger.listAuditFiles()
+	require.NoError(t, err)
+	require.Greater(t, len(files), 2)
+	require.NoError(t, os.Remove(files[1]))
+
+	err = logger.VerifyAllChains()
+	var verr *VerificationError
+	require.True(t, errors.As(err, &verr), "expected VerificationError, got %v", err)
+	assert.Equal(t, filepath.Base(files[0]), verr.File)
+	assert.Contains(t, verr.Reason, "rotates to")
+}
+
+func TestNewLoggerContinuesExistingChain(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	writeTestEntries(t, logger, 2)
+	require.NoError(t, logger.Close())
+
+	reopened, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	writeTestEntries(t, reopened, 2)
+
+	valid, err := reopened.VerifyIntegrity()
+	require.NoError(t, err)
+	assert.True(t, valid)
+
+	entries, err := reopened.readAllEntries()
+	require.NoError(t, err)
+	genesisCount := 0
+	for _, entry := range entries {
+		if entry.EventType == EventChainGenesis {
+			genesisCount++
+		}
+	}
+	assert.Equal(t, 1, genesisCount, "reopening must not start a new chain")
+}
+
+func TestNewLoggerDiscardsTornEntry(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	writeTestEntries(t, logger, 2)
+	require.NoError(t, logger.Close())
+
+	files, err := filepath.Glob(filepath.Join(cfg.Directory, "audit_*.log"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	before, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+
+	// A crash while appending leaves half an entry without its newline
+	file, err := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0640)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"timestamp":"2026-03-01T09:00:00Z","event_type":"act`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	reopened, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	after, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(after), string(before)), "the entries before the torn one are kept")
+	writeTestEntries(t, reopened, 1)
+
+	valid, err := reopened.VerifyIntegrity()
+	require.NoError(t, err)
+	assert.True(t, valid, "the chain continues from the last complete entry")
+	require.NoError(t, reopened.Close())
+}
+
+func TestNewLoggerMigratesLegacyChain(t *testing.T) {
+	// An audit file written by a release hashing entries under chain
+	// version 1.0, on the day of the upgrade
+	dir := t.TempDir()
+	legacy, err := os.ReadFile(filepath.Join("testdata", "legacy_audit.log"))
+	require.NoError(t, err)
+	legacyFile := filepath.Join(dir, "audit_2026-10-14.log")
+	require.NoError(t, os.WriteFile(legacyFile, legacy, 0640))
+
+	cfg := testAuditConfig(dir)
+	clk := clock.NewFake(time.Date(2026, 10, 14, 15, 0, 0, 0, time.UTC))
+	logger, err := NewLoggerWithClock(cfg, clk, testLogger())
+	require.NoError(t, err)
+	writeTestEntries(t, logger, 2)
+
+	assert.Equal(t, "audit_2026-10-14.1.log", filepath.Base(logger.filename), "the new chain starts in a file of its own")
+	entries, err := logger.readAllEntries()
+	require.NoError(t, err)
+	genesis := entries[0]
+	assert.Equal(t, EventChainGenesis, genesis.EventType)
+	assert.Equal(t, ChainVersion, genesis.Metadata["version"])
+	assert.Equal(t, HashScheme, genesis.Metadata["hash_scheme"])
+	assert.Equal(t, "audit_2026-10-14.log", genesis.Metadata["migrated_from"])
+
+	valid, err := logger.VerifyIntegrity()
+	require.NoError(t, err)
+	assert.True(t, valid, "the legacy file is skipped rather than reported as a chain break")
+	require.NoError(t, logger.Close())
+
+	after, err := os.ReadFile(legacyFile)
+	require.NoError(t, err)
+	assert.Equal(t, legacy, after, "the legacy file is never appended to")
+
+	_, err = VerifyFile(legacyFile, nil)
+	var verr *VerificationError
+	require.True(t, errors.As(err, &verr), "expected VerificationError, got %v", err)
+	assert.Contains(t, verr.Reason, "version 1.0")
+
+	// Restarting continues the new chain
+	reopened, err := NewLoggerWithClock(cfg, clk, testLogger())
+	require.NoError(t, err)
+	assert.Equal(t, "audit_2026-10-14.1.log", filepath.Base(reopened.filename))
+	writeTestEntries(t, reopened, 1)
+	valid, err = reopened.VerifyIntegrity()
+	require.NoError(t, err)
+	assert.True(t, valid)
+	require.NoError(t, reopened.Close())
+}
+
+func TestGenesisRecordsChainIdentity(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	cfg.ChainID = "prod-eu"
+	cfg.NodeID = "node-1"
+	cfg.GenesisMetadata = map[string]string{"environment": "production", "chain_id": "ignored"}
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	writeTestEntries(t, logger, 1)
+
+	entries, err := logger.readAllEntries()
+	require.NoError(t, err)
+	genesis := entries[0]
+	assert.Equal(t, "prod-eu", genesis.Metadata["chain_id"])
+	assert.Equal(t, "node-1", genesis.Metadata["node_id"])
+	assert.Equal(t, "production", genesis.Metadata["environment"])
+	for _, entry := range entries {
+		assert.Equal(t, "prod-eu", entry.ChainID)
+	}
+	assert.NoError(t, logger.VerifyChain())
+}
+
+func TestMismatchedChainIDs(t *testing.T) {
+	dir := t.TempDir()
+	cfg := testAuditConfig(dir)
+	cfg.ChainID = "chain-a"
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	writeTestEntries(t, logger, 1)
+
+	// Appending an entry from another chain is rejected
+	err = logger.appendEntry(&AuditEntry{EventType: EventSystemStart, ChainID: "chain-b"})
+	assert.ErrorIs(t, err, ErrChainMismatch)
+	require.NoError(t, logger.Close())
+
+	// Continuing the log under a different chain ID is rejected
+	other := testAuditConfig(dir)
+	other.ChainID = "chain-b"
+	_, err = NewLogger(other, testLogger())
+	assert.ErrorIs(t, err, ErrChainMismatch)
+
+	// Verification against a different chain ID reports the first entry
+	logger.chainID = "chain-b"
+	err = logger.VerifyAllChains()
+	var verr *VerificationError
+	require.True(t, errors.As(err, &verr), "expected VerificationError, got %v", err)
+	assert.Equal(t, 0, verr.Index)
+	assert.Contains(t, verr.Reason, `belongs to chain "chain-a"`)
+}
+
+// Helper functions
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
+
+func testAuditConfig(dir string) *config.AuditConfig {
+	return &config.AuditConfig{
+		Enabled:        true,
+		Directory:      dir,
+		IntegrityCheck: true,
+	}
+}
+
+func writeTestEntries(t *testing.T, logger *Logger, count int) {
+	for i := 0; i < count; i++ {
+		email := &types.Email{ID: "email", Subject: "Weekly newsletter", From: "news@example.com"}
+		require.NoError(t, logger.LogClassification(context.Background(), email, &types.ClassificationResponse{
+			ProfileID:  "newsletter",
+			Action:     "archive",
+			Confidence: 0.8,
+			Reasoning:  "Newsletter content",
+		}))
+		require.NoError(t, logger.LogAction(context.Background(), email, "archive", "-INBOX"))
+	}
+}
+
+func rewriteFile(t *testing.T, path string, edit func([]string) []string) {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	lines = edit(lines)
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0640))
+}
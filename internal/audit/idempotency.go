@@ -0,0 +1,122 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/logging"
+)
+
+// Metadata keys recording the idempotency of classification entries
+const (
+	// MetadataIdempotencyKey is a hash of the classification event an
+	// entry records, equal for every entry recording the same event
+	MetadataIdempotencyKey = "idempotency_key"
+	// MetadataDuplicateOf names the entry that first recorded a
+	// classification event logged again
+	MetadataDuplicateOf = "duplicate_of"
+)
+
+// idempotencyWindowSize is how many recent classifications re-logged events
+// are checked against
+const idempotencyWindowSize = 4096
+
+// entryID derives a unique ID from an entry's content, the logger's
+// instance nonce and its sequence number. The nonce keeps IDs from
+// colliding across restarts and the sequence within the same nanosecond.
+// The caller must hold the mutex.
+func (l *Logger) entryID(entry *AuditEntry) string {
+	l.sequence++
+	var sequence [8]byte
+	binary.BigEndian.PutUint64(sequence[:], l.sequence)
+
+	hash := sha256.New()
+	hash.Write(l.instanceNonce)
+	hash.Write(sequence[:])
+	for _, field := range []string{entry.EventType, entry.EmailID, entry.ProfileID, entry.Action, entry.Timestamp.UTC().Format(time.RFC3339Nano)} {
+		hash.Write([]byte(field))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+// flagDuplicate records the idempotency key of a classification entry and,
+// when the same event was logged within the window, the ID of the entry
+// that first recorded it. The duplicate is still written, so a replayed
+// event stays visible in the chain. It returns the key to remember once the
+// entry is written, or "" for other entries and duplicates. The caller must
+// hold the mutex.
+func (l *Logger) flagDuplicate(entry *AuditEntry) string {
+	if entry.EventType != EventEmailClassified {
+		return ""
+	}
+
+	key := idempotencyKey(entry)
+	if entry.Metadata == nil {
+		entry.Metadata = make(map[string]interface{})
+	}
+	entry.Metadata[MetadataIdempotencyKey] = key
+	if original, seen := l.recent.lookup(key); seen {
+		entry.Metadata[MetadataDuplicateOf] = original
+		l.logger.WithFields(logrus.Fields{
+			"email_id":    entry.EmailID,
+			"profile_id":  entry.ProfileID,
+			"original_id": original,
+		}).Warn("Duplicate classification event logged")
+		return ""
+	}
+	return key
+}
+
+// idempotencyKey hashes the fields identifying a classification event: the
+// email, profile, outcome and correlation ID, so that classifying the same
+// email again in a later batch is a new event
+func idempotencyKey(entry *AuditEntry) string {
+	data, _ := canonicalJSON([]interface{}{
+		entry.EmailID,
+		entry.ProfileID,
+		entry.Action,
+		entry.Confidence,
+		entry.Reasoning,
+		entry.Metadata["labels"],
+		entry.Metadata[logging.FieldCorrelationID],
+	})
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// idempotencyWindow maps the most recent keys to entry IDs, forgetting the
+// oldest key once full
+type idempotencyWindow struct {
+	ids   map[string]string
+	order []string
+	next  int
+}
+
+func newIdempotencyWindow(size int) idempotencyWindow {
+	return idempotencyWindow{
+		ids:   make(map[string]string, size),
+		order: make([]string, size),
+	}
+}
+
+func (w *idempotencyWindow) lookup(key string) (string, bool) {
+	id, seen := w.ids[key]
+	return id, seen
+}
+
+func (w *idempotencyWindow) remember(key, id string) {
+	if len(w.order) == 0 {
+		return
+	}
+	if oldest := w.order[w.next]; oldest != "" {
+		delete(w.ids, oldest)
+	}
+	w.order[w.next] = key
+	w.ids[key] = id
+	w.next = (w.next + 1) % len(w.order)
+}
@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestEntryIDsUnique(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	cfg.BufferedWrites = true
+	cfg.FlushInterval = time.Hour
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	defer logger.Close()
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			email := &types.Email{ID: "email"}
+			for i := 0; i < 250; i++ {
+				assert.NoError(t, logger.LogAction(context.Background(), email, "archive", "-INBOX"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, err := logger.Query(Query{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2001, "genesis plus every action")
+	ids := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		require.NotEmpty(t, entry.ID)
+		require.False(t, ids[entry.ID], "duplicate entry ID %s", entry.ID)
+		ids[entry.ID] = true
+	}
+	assert.NoError(t, logger.VerifyChain())
+}
+
+func TestEntryIDsUniqueForIdenticalEntries(t *testing.T) {
+	first, second := testHashLogger(), testHashLogger()
+	first.instanceNonce, second.instanceNonce = []byte("first"), []byte("second")
+	timestamp := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	ids := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		for _, logger := range []*Logger{first, second} {
+			id := logger.entryID(testHashEntry(timestamp, nil))
+			require.False(t, ids[id], "entries created in the same nanosecond get distinct IDs")
+			ids[id] = true
+		}
+	}
+}
+
+func TestDuplicateClassificationFlagged(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	defer logger.Close()
+
+	email := &types.Email{ID: "email-1", Subject: "Subject 1"}
+	result := &types.ClassificationResponse{ProfileID: "spam", Action: "archive", Confidence: 0.9, Reasoning: "Bulk mail"}
+	batch1 := logging.WithCorrelationID(context.Background(), "batch-1/email-1")
+	batch2 := logging.WithCorrelationID(context.Background(), "batch-2/email-1")
+	require.NoError(t, logger.LogEmailClassification(batch1, email, result))
+	require.NoError(t, logger.LogEmailClassification(batch1, email, result))
+	require.NoError(t, logger.LogEmailClassification(batch2, email, result))
+
+	entries, err := logger.Query(Query{EventTypes: []string{EventEmailClassified}})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	original, replayed, reclassified := entries[0], entries[1], entries[2]
+	assert.NotContains(t, original.Metadata, MetadataDuplicateOf)
+	assert.Equal(t, original.ID, replayed.Metadata[MetadataDuplicateOf])
+	assert.Equal(t, original.Metadata[MetadataIdempotencyKey], replayed.Metadata[MetadataIdempotencyKey])
+	assert.NotContains(t, reclassified.Metadata, MetadataDuplicateOf, "a later batch is a new event")
+	assert.NotEqual(t, original.Metadata[MetadataIdempotencyKey], reclassified.Metadata[MetadataIdempotencyKey])
+	assert.NoError(t, logger.VerifyAllChains())
+}
+
+func TestIdempotencyWindowForgetsOldestKey(t *testing.T) {
+	window := newIdempotencyWindow(2)
+	window.remember("a", "1")
+	window.remember("b", "2")
+	window.remember("c", "3")
+
+	_, seen := window.lookup("a")
+	assert.False(t, seen)
+	id, seen := window.lookup("c")
+	assert.True(t, seen)
+	assert.Equal(t, "3", id)
+}
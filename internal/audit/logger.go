@@ -1,9 +1,14 @@
 package audit
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -13,6 +18,9 @@ import (
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/internal/redact"
 	"github.com/mailsentinel/core/pkg/config"
 	"github.com/mailsentinel/core/pkg/types"
 )
@@ -22,11 +30,52 @@ type Logger struct {
 	config     *config.AuditConfig
 	logger     *logrus.Logger
 	file       *os.File
+	filename   string
+	fileSize   int64
+	openedAt   time.Time
+	clock      clock.Clock
 	mutex      sync.RWMutex
 	entryCount int64
 	lastHash   string
+	chainID    string
+	closed     bool
+
+	// Buffered write mode: entries are collected in pending and written
+	// and synced together by flush
+	pending        []byte
+	pendingEntries int
+	stopFlusher    chan struct{}
+	flusherDone    chan struct{}
+
+	// Ed25519 signing: entries are signed with signingKey and verified with
+	// publicKey. requireSignatures rejects entries without an Ed25519
+	// signature during verification.
+	signingKey        ed25519.PrivateKey
+	publicKey         ed25519.PublicKey
+	requireSignatures bool
+
+	// observers are called with each entry once it is appended
+	observers []Observer
+
+	// redactor, when set, replaces personal data in the email fields and
+	// reasoning of classification and decision entries
+	redactor *redact.Redactor
+
+	// Entry IDs hash each entry's content with instanceNonce, random per
+	// logger, and sequence, incremented for every entry written
+	instanceNonce []byte
+	sequence      uint64
+
+	// recent maps the idempotency keys of the latest classifications to
+	// the IDs of the entries that first recorded them
+	recent idempotencyWindow
 }
 
+// Observer receives audit entries as they are appended to the chain. It is
+// called without the logger's lock held, so it may log entries itself, and
+// must not modify the entry's metadata.
+type Observer func(entry AuditEntry)
+
 // AuditEntry represents a single audit log entry
 type AuditEntry struct {
 	ID          string                 `json:"id"`
@@ -38,27 +87,78 @@ type AuditEntry struct {
 	Confidence  float64                `json:"confidence,omitempty"`
 	Reasoning   string                 `json:"reasoning,omitempty"`
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	ChainID     string                 `json:"chain_id,omitempty"`
 	PrevHash    string                 `json:"prev_hash"`
 	Hash        string                 `json:"hash"`
 	Signature   string                 `json:"signature,omitempty"`
+	SignedWith  string                 `json:"signed_with,omitempty"`
 }
 
 // EventType constants for audit logging
 const (
 	EventEmailClassified   = "email_classified"
+	EventDecision          = "decision"
 	EventProfileLoaded     = "profile_loaded"
 	EventConfigChanged     = "config_changed"
 	EventAuthTokenRefresh  = "auth_token_refresh"
 	EventSecurityViolation = "security_violation"
 	EventSystemStart       = "system_start"
 	EventSystemStop        = "system_stop"
+	EventAction            = "action"
+	EventActionApplied     = "action_applied"
+	EventActionPlanned     = "action_planned"
+	EventActionDowngraded  = "action_downgraded"
+	EventActionIntercepted = "action_intercepted"
+	EventMessageTrashed    = "message_trashed"
+	EventMessageDeleted    = "message_deleted"
+	EventAnomalyDetected   = "anomaly_detected"
+	EventNotification      = "notification"
+	EventDeadLettered      = "dead_lettered"
+	EventUserFeedback      = "user_feedback"
 	EventError             = "error"
+
+	// Chain linkage markers. A rotated file ends with EventChainRotated naming
+	// the next file, and the next file starts with EventChainContinued naming
+	// the previous file and the hash it continues from.
+	EventChainGenesis   = "chain_genesis"
+	EventChainRotated   = "chain_rotated"
+	EventChainContinued = "chain_continued"
 )
 
-// NewLogger creates a new audit logger
+// DefaultChainID identifies the audit chain when AuditConfig.ChainID is unset
+const DefaultChainID = "mailsentinel"
+
+// ChainVersion is recorded in the genesis entry of every new chain, with
+// HashScheme naming how its entries are hashed. Chains of version 1.0, from
+// releases before chains had an identity, hashed another representation of
+// their entries and cannot be verified under HashScheme.
+const (
+	ChainVersion = "2.0"
+	HashScheme   = "sha256-canonical-json"
+)
+
+// legacyChainVersion is the version of chains predating HashScheme
+const legacyChainVersion = "1.0"
+
+// ErrChainMismatch is returned when an entry or existing log belongs to a
+// different audit chain than the one the logger is configured for
+var ErrChainMismatch = errors.New("audit chain identity mismatch")
+
+// ErrClosed is returned for entries logged after Close, which would
+// otherwise follow the system stop event
+var ErrClosed = errors.New("audit logger is closed")
+
+// NewLogger creates a new audit logger. If the directory already holds an
+// audit chain, the logger continues it from the most recent file.
 func NewLogger(cfg *config.AuditConfig, logger *logrus.Logger) (*Logger, error) {
+	return NewLoggerWithClock(cfg, clock.Real{}, logger)
+}
+
+// NewLoggerWithClock creates a new audit logger that timestamps entries and
+// times file rotation with clk
+func NewLoggerWithClock(cfg *config.AuditConfig, clk clock.Clock, logger *logrus.Logger) (*Logger, error) {
 	if !cfg.Enabled {
-		return &Logger{config: cfg, logger: logger}, nil
+		return &Logger{config: cfg, logger: logger, clock: clk}, nil
 	}
 
 	// Ensure audit directory exists
@@ -66,85 +166,260 @@ func NewLogger(cfg *config.AuditConfig, logger *logrus.Logger) (*Logger, error)
 		return nil, fmt.Errorf("failed to create audit directory: %w", err)
 	}
 
-	// Open current audit file
-	filename := filepath.Join(cfg.Directory, fmt.Sprintf("audit_%s.log", time.Now().Format("2006-01-02")))
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate audit instance nonce: %w", err)
+	}
+	auditLogger := &Logger{
+		config:        cfg,
+		logger:        logger,
+		clock:         clk,
+		chainID:       cfg.ChainID,
+		instanceNonce: nonce,
+		recent:        newIdempotencyWindow(idempotencyWindowSize),
+	}
+	if auditLogger.chainID == "" {
+		auditLogger.chainID = DefaultChainID
+	}
+	if cfg.SigningKeyFile != "" {
+		signingKey, err := LoadSigningKey(cfg.SigningKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load audit signing key: %w", err)
+		}
+		auditLogger.signingKey = signingKey
+		auditLogger.publicKey = signingKey.Public().(ed25519.PublicKey)
+	}
+
+	files, err := auditLogger.listAuditFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit files: %w", err)
+	}
+
+	// Start a new chain if there is no history. Legacy files are sealed: the
+	// new chain starts in a file of its own, recording the last of them.
+	legacy, files, err := splitLegacyFiles(files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit files: %w", err)
+	}
+	if len(files) == 0 {
+		var migratedFrom string
+		if len(legacy) > 0 {
+			migratedFrom = filepath.Base(legacy[len(legacy)-1])
+			logger.WithFields(logrus.Fields{
+				"legacy_files": len(legacy),
+				"version":      legacyChainVersion,
+			}).Warn("Starting a new audit chain beside the audit files of a legacy chain")
+		}
+		if err := auditLogger.openFile(auditLogger.nextFileName()); err != nil {
+			return nil, err
+		}
+		if err := auditLogger.initializeChain(migratedFrom); err != nil {
+			return nil, fmt.Errorf("failed to initialize audit chain: %w", err)
+		}
+		auditLogger.startFlusher()
+		return auditLogger, nil
+	}
+
+	// Continue the chain from the most recent file
+	if err := auditLogger.repairTornEntry(files[len(files)-1]); err != nil {
+		return nil, fmt.Errorf("failed to continue audit chain: %w", err)
+	}
+	if err := auditLogger.openFile(files[len(files)-1]); err != nil {
+		return nil, err
+	}
+	if err := auditLogger.loadLastHash(); err != nil {
+		auditLogger.file.Close()
+		return nil, fmt.Errorf("failed to continue audit chain: %w", err)
+	}
+
+	auditLogger.mutex.Lock()
+	defer auditLogger.mutex.Unlock()
+	if err := auditLogger.rotateIfNeeded(); err != nil {
+		return nil, fmt.Errorf("failed to rotate audit file: %w", err)
+	}
+	auditLogger.startFlusher()
+
+	return auditLogger, nil
+}
+
+// openFile opens an audit file for appending and makes it the current file
+func (l *Logger) openFile(filename string) error {
 	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open audit file: %w", err)
+		return fmt.Errorf("failed to open audit file: %w", err)
 	}
 
-	auditLogger := &Logger{
-		config: cfg,
-		logger: logger,
-		file:   file,
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit file: %w", err)
 	}
 
-	// Initialize chain if file is empty
-	if stat, err := file.Stat(); err == nil && stat.Size() == 0 {
-		if err := auditLogger.initializeChain(); err != nil {
-			return nil, fmt.Errorf("failed to initialize audit chain: %w", err)
+	l.file = file
+	l.filename = filename
+	l.fileSize = stat.Size()
+	l.openedAt = l.clock.Now()
+	return nil
+}
+
+// repairTornEntry truncates the last entry of an audit file when a crash
+// tore it while it was written, leaving a line without a newline that does
+// not parse. An entry complete but for its newline is kept, with the
+// newline appended.
+func (l *Logger) repairTornEntry(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read audit file: %w", err)
+	}
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return nil
+	}
+
+	start := bytes.LastIndexByte(data, '\n') + 1
+	var entry AuditEntry
+	if json.Unmarshal(data[start:], &entry) == nil {
+		file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return fmt.Errorf("failed to open audit file: %w", err)
 		}
-	} else {
-		// Load last hash from existing file
-		if err := auditLogger.loadLastHash(); err != nil {
-			logger.WithError(err).Warn("Failed to load last hash, starting new chain")
-			auditLogger.lastHash = ""
+		defer file.Close()
+		if _, err := file.Write([]byte("\n")); err != nil {
+			return fmt.Errorf("failed to terminate audit entry: %w", err)
 		}
+		return nil
 	}
 
-	return auditLogger, nil
+	l.logger.WithFields(logrus.Fields{
+		"file":  filename,
+		"bytes": len(data) - start,
+	}).Warn("Discarding torn audit entry")
+	if err := os.Truncate(filename, int64(start)); err != nil {
+		return fmt.Errorf("failed to truncate audit file: %w", err)
+	}
+	return nil
 }
 
-// initializeChain creates the genesis entry for a new audit chain
-func (l *Logger) initializeChain() error {
-	genesis := &AuditEntry{
-		ID:        generateID(),
-		Timestamp: time.Now(),
-		EventType: "chain_genesis",
-		PrevHash:  "",
-		Metadata: map[string]interface{}{
-			"version": "1.0",
-			"system":  "mailsentinel",
-		},
+// initializeChain creates the genesis entry for a new audit chain, recording
+// the chain identity, version and hash scheme, any configured genesis
+// metadata and, when it replaces a legacy chain, the last legacy file
+func (l *Logger) initializeChain(migratedFrom string) error {
+	metadata := make(map[string]interface{}, len(l.config.GenesisMetadata)+6)
+	for key, value := range l.config.GenesisMetadata {
+		metadata[key] = value
+	}
+	metadata["version"] = ChainVersion
+	metadata["hash_scheme"] = HashScheme
+	metadata["system"] = "mailsentinel"
+	metadata["chain_id"] = l.chainID
+	if l.config.NodeID != "" {
+		metadata["node_id"] = l.config.NodeID
+	}
+	if migratedFrom != "" {
+		metadata["migrated_from"] = migratedFrom
 	}
 
-	genesis.Hash = l.calculateHash(genesis)
-	l.lastHash = genesis.Hash
+	genesis := &AuditEntry{
+		Timestamp: l.clock.Now(),
+		EventType: EventChainGenesis,
+		Metadata:  metadata,
+	}
 
-	return l.writeEntry(genesis)
+	return l.appendEntry(genesis)
 }
 
 // LogEmailClassification logs an email classification event
-func (l *Logger) LogEmailClassification(email *types.Email, response *types.ClassificationResponse) error {
+func (l *Logger) LogEmailClassification(ctx context.Context, email *types.Email, response *types.ClassificationResponse) error {
 	if !l.config.Enabled {
 		return nil
 	}
 
+	scrub := l.scrubber()
 	entry := &AuditEntry{
-		ID:        generateID(),
-		Timestamp: time.Now(),
+		Timestamp: l.clock.Now(),
 		EventType: EventEmailClassified,
 		EmailID:   email.ID,
 		ProfileID: response.ProfileID,
 		Action:    response.Action,
 		Confidence: response.Confidence,
-		Reasoning: response.Reasoning,
-		PrevHash:  l.lastHash,
+		Reasoning: scrub(response.Reasoning),
 		Metadata: map[string]interface{}{
-			"email_subject": email.Subject,
-			"email_from":    email.From,
+			"email_subject": scrub(email.Subject),
+			"email_from":    scrub(email.From),
 			"email_size":    email.Size,
 			"labels":        response.Labels,
 		},
 	}
+	if len(email.Context) > 0 {
+		context := make(map[string]string, len(email.Context))
+		for key, value := range email.Context {
+			context[key] = scrub(value)
+		}
+		entry.Metadata["email_context"] = context
+	}
+	for _, key := range []string{types.MetadataResolution, types.MetadataShadow, types.MetadataShadowOf, types.MetadataReasoningTruncated, types.MetadataClassificationFailed, types.MetadataClassificationError} {
+		if value, exists := response.Metadata[key]; exists {
+			entry.Metadata[key] = value
+		}
+	}
+	if response.Fingerprint != nil {
+		entry.Metadata[types.MetadataFingerprint] = response.Fingerprint
+	}
+	correlate(ctx, entry)
 
-	entry.Hash = l.calculateHash(entry)
-	l.mutex.Lock()
-	l.lastHash = entry.Hash
-	l.entryCount++
-	l.mutex.Unlock()
+	return l.appendEntry(entry)
+}
+
+// MetadataDecision is the decision entry metadata key holding the whole
+// types.Decision
+const MetadataDecision = "decision"
+
+// LogDecision logs everything decided for an email as one entry: its
+// classifications, their resolution and the action taken, if any. The
+// entry's action is the action taken or, until the email is acted on, the
+// resolved one.
+func (l *Logger) LogDecision(ctx context.Context, email *types.Email, decision *types.Decision) error {
+	if !l.config.Enabled {
+		return nil
+	}
 
-	return l.writeEntry(entry)
+	scrub := l.scrubber()
+	entry := &AuditEntry{
+		Timestamp:  l.clock.Now(),
+		EventType:  EventDecision,
+		EmailID:    email.ID,
+		ProfileID:  decision.Resolution.ProfileID,
+		Action:     decision.FinalAction(),
+		Confidence: decision.Resolution.Confidence,
+		Reasoning:  scrub(decision.Resolution.Reasoning),
+		Metadata: map[string]interface{}{
+			"email_subject":  scrub(email.Subject),
+			"email_from":     scrub(email.From),
+			"acted":          decision.Action != nil,
+			MetadataDecision: scrubDecision(decision, scrub),
+		},
+	}
+	if decision.Action != nil {
+		entry.Metadata["dry_run"] = decision.Action.DryRun
+	}
+	correlate(ctx, entry)
+
+	return l.appendEntry(entry)
+}
+
+// scrubDecision returns a copy of a decision whose results' reasonings are
+// scrubbed, leaving the decision itself unchanged
+func scrubDecision(decision *types.Decision, scrub func(string) string) *types.Decision {
+	scrubbed := *decision
+	scrubbed.Classifications = make([]*types.ClassificationResponse, len(decision.Classifications))
+	for i, classification := range decision.Classifications {
+		copied := *classification
+		copied.Reasoning = scrub(classification.Reasoning)
+		scrubbed.Classifications[i] = &copied
+	}
+	resolution := *decision.Resolution
+	resolution.Reasoning = scrub(decision.Resolution.Reasoning)
+	scrubbed.Resolution = &resolution
+	return &scrubbed
 }
 
 // LogProfileLoad logs a profile loading event
@@ -154,24 +429,16 @@ func (l *Logger) LogProfileLoad(profileID, version string, success bool) error {
 	}
 
 	entry := &AuditEntry{
-		ID:        generateID(),
-		Timestamp: time.Now(),
+		Timestamp: l.clock.Now(),
 		EventType: EventProfileLoaded,
 		ProfileID: profileID,
-		PrevHash:  l.lastHash,
 		Metadata: map[string]interface{}{
 			"version": version,
 			"success": success,
 		},
 	}
 
-	entry.Hash = l.calculateHash(entry)
-	l.mutex.Lock()
-	l.lastHash = entry.Hash
-	l.entryCount++
-	l.mutex.Unlock()
-
-	return l.writeEntry(entry)
+	return l.appendEntry(entry)
 }
 
 // LogSecurityViolation logs a security violation event
@@ -181,10 +448,8 @@ func (l *Logger) LogSecurityViolation(violationType, description string, metadat
 	}
 
 	entry := &AuditEntry{
-		ID:        generateID(),
-		Timestamp: time.Now(),
+		Timestamp: l.clock.Now(),
 		EventType: EventSecurityViolation,
-		PrevHash:  l.lastHash,
 		Metadata: map[string]interface{}{
 			"violation_type": violationType,
 			"description":    description,
@@ -196,13 +461,82 @@ func (l *Logger) LogSecurityViolation(violationType, description string, metadat
 		entry.Metadata[k] = v
 	}
 
-	entry.Hash = l.calculateHash(entry)
-	l.mutex.Lock()
-	l.lastHash = entry.Hash
-	l.entryCount++
-	l.mutex.Unlock()
+	return l.appendEntry(entry)
+}
+
+// LogNotification logs an attempt to notify a webhook rule about an email's
+// classification. A nil deliveryErr means the notification was delivered.
+func (l *Logger) LogNotification(ctx context.Context, emailID, profileID, action, rule string, attempts int, deliveryErr error) error {
+	if !l.config.Enabled {
+		return nil
+	}
+
+	entry := &AuditEntry{
+		Timestamp: l.clock.Now(),
+		EventType: EventNotification,
+		EmailID:   emailID,
+		ProfileID: profileID,
+		Action:    action,
+		Metadata: map[string]interface{}{
+			"rule":      rule,
+			"attempts":  attempts,
+			"delivered": deliveryErr == nil,
+		},
+	}
+	if deliveryErr != nil {
+		entry.Metadata["error"] = deliveryErr.Error()
+	}
+	correlate(ctx, entry)
 
-	return l.writeEntry(entry)
+	return l.appendEntry(entry)
+}
+
+// LogDeadLetter logs an email set aside in the dead-letter queue after its
+// classification failed
+func (l *Logger) LogDeadLetter(ctx context.Context, email *types.Email, profileID, deadLetterID, reason string, classifyErr error) error {
+	if !l.config.Enabled {
+		return nil
+	}
+
+	entry := &AuditEntry{
+		Timestamp: l.clock.Now(),
+		EventType: EventDeadLettered,
+		EmailID:   email.ID,
+		ProfileID: profileID,
+		Metadata: map[string]interface{}{
+			"dead_letter_id": deadLetterID,
+			"reason":         reason,
+			"error":          classifyErr.Error(),
+		},
+	}
+	correlate(ctx, entry)
+
+	return l.appendEntry(entry)
+}
+
+// MetadataUserAction is the user_feedback entry metadata key holding the
+// action the user took, while the entry's action is the one MailSentinel took
+const MetadataUserAction = "user_action"
+
+// LogUserFeedback logs a user contradicting the action taken on an email,
+// as when an archived email is moved back to the inbox
+func (l *Logger) LogUserFeedback(ctx context.Context, emailID, userAction, ourAction string) error {
+	if !l.config.Enabled {
+		return nil
+	}
+
+	entry := &AuditEntry{
+		Timestamp: l.clock.Now(),
+		EventType: EventUserFeedback,
+		EmailID:   emailID,
+		Action:    ourAction,
+		Metadata: map[string]interface{}{
+			MetadataUserAction: userAction,
+		},
+	}
+	correlate(ctx, entry)
+
+	return l.appendEntry(entry)
 }
 
 // LogSystemEvent logs system start/stop events
@@ -212,53 +546,123 @@ func (l *Logger) LogSystemEvent(eventType string, metadata map[string]interface{
 	}
 
 	entry := &AuditEntry{
-		ID:        generateID(),
-		Timestamp: time.Now(),
+		Timestamp: l.clock.Now(),
 		EventType: eventType,
-		PrevHash:  l.lastHash,
 		Metadata:  metadata,
 	}
 
-	entry.Hash = l.calculateHash(entry)
-	l.mutex.Lock()
-	l.lastHash = entry.Hash
-	l.entryCount++
-	l.mutex.Unlock()
-
-	return l.writeEntry(entry)
+	return l.appendEntry(entry)
 }
 
-// calculateHash calculates SHA-256 hash of audit entry
-func (l *Logger) calculateHash(entry *AuditEntry) string {
-	// Create deterministic string representation
-	data := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%f|%s",
+// calculateHash calculates SHA-256 hash of audit entry. The hashed form is
+// the canonical JSON encoding of the entry's fields in a fixed order, so the
+// hash does not depend on map ordering or on the Go types metadata values
+// happen to have. Entries that cannot be encoded, such as those with a NaN
+// confidence, fail rather than hash to the hash of nothing.
+func (l *Logger) calculateHash(entry *AuditEntry) (string, error) {
+	data, err := canonicalJSON([]interface{}{
 		entry.ID,
-		entry.Timestamp.Format(time.RFC3339Nano),
+		entry.Timestamp.UTC().Format(time.RFC3339Nano),
 		entry.EventType,
 		entry.EmailID,
 		entry.ProfileID,
 		entry.Action,
 		entry.Confidence,
+		entry.Reasoning,
+		entry.ChainID,
 		entry.PrevHash,
-	)
-
-	// Add metadata in sorted order for deterministic hash
-	if entry.Metadata != nil {
-		metadataJSON, _ := json.Marshal(entry.Metadata)
-		data += "|" + string(metadataJSON)
+		entry.Metadata,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit entry: %w", err)
 	}
 
-	hash := sha256.Sum256([]byte(data))
-	return hex.EncodeToString(hash[:])
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
 }
 
-// writeEntry writes an audit entry to the log file
-func (l *Logger) writeEntry(entry *AuditEntry) error {
+// SetRedactor replaces personal data in the subject, sender, context and
+// reasoning that email_classified and decision entries record, and in the
+// reasonings of a decision's results, with placeholders. A nil redactor
+// records them as they are.
+func (l *Logger) SetRedactor(redactor *redact.Redactor) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.redactor = redactor
+}
+
+// scrubber returns a function replacing the personal data of one entry,
+// numbering placeholders per entry, or one returning texts unchanged
+// without a redactor
+func (l *Logger) scrubber() func(string) string {
+	l.mutex.RLock()
+	redactor := l.redactor
+	l.mutex.RUnlock()
+	if redactor == nil {
+		return func(text string) string { return text }
+	}
+	return redactor.NewSession().Redact
+}
+
+// AddObserver registers an observer for every entry appended from now on
+func (l *Logger) AddObserver(observer Observer) {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
+	l.observers = append(l.observers, observer)
+}
+
+// appendEntry links an entry to the chain, rotating the audit file first if
+// it is due, writes it and passes it to the observers
+func (l *Logger) appendEntry(entry *AuditEntry) error {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return ErrClosed
+	}
+	if err := l.rotateIfNeeded(); err != nil {
+		l.mutex.Unlock()
+		return fmt.Errorf("failed to rotate audit file: %w", err)
+	}
+	key := l.flagDuplicate(entry)
+	if err := l.writeEntry(entry); err != nil {
+		l.mutex.Unlock()
+		return err
+	}
+	if key != "" {
+		l.recent.remember(key, entry.ID)
+	}
+	observers := l.observers
+	l.mutex.Unlock()
+
+	for _, observer := range observers {
+		observer(*entry)
+	}
+	return nil
+}
+
+// writeEntry links an entry to the chain and writes it to the current file,
+// assigning its ID unless it has one. The caller must hold the mutex.
+func (l *Logger) writeEntry(entry *AuditEntry) error {
+	if entry.ID == "" {
+		entry.ID = l.entryID(entry)
+	}
+	if entry.ChainID == "" {
+		entry.ChainID = l.chainID
+	} else if entry.ChainID != l.chainID {
+		return fmt.Errorf("%w: entry belongs to chain %s, logger writes chain %s", ErrChainMismatch, entry.ChainID, l.chainID)
+	}
+	entry.PrevHash = l.lastHash
+	hash, err := l.calculateHash(entry)
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
 
-	// Sign entry if encryption key is provided
-	if l.config.EncryptionKey != "" {
+	// Sign entry with the Ed25519 key, or the encryption key if provided
+	if l.signingKey != nil {
+		entry.Signature = hex.EncodeToString(ed25519.Sign(l.signingKey, []byte(entry.Hash)))
+		entry.SignedWith = SignatureEd25519
+	} else if l.config.EncryptionKey != "" {
 		signature, err := l.signEntry(entry)
 		if err != nil {
 			l.logger.WithError(err).Error("Failed to sign audit entry")
@@ -273,14 +677,34 @@ func (l *Logger) writeEntry(entry *AuditEntry) error {
 		return fmt.Errorf("failed to marshal audit entry: %w", err)
 	}
 
-	// Write to file
-	if _, err := l.file.WriteString(string(data) + "\n"); err != nil {
-		return fmt.Errorf("failed to write audit entry: %w", err)
+	line := append(data, '\n')
+	if l.config.BufferedWrites {
+		// Queue the entry; it reaches disk on the next flush
+		l.pending = append(l.pending, line...)
+		l.pendingEntries++
+		l.fileSize += int64(len(line))
+	} else {
+		// Write to file
+		written, err := l.file.Write(line)
+		l.fileSize += int64(written)
+		if err != nil {
+			return fmt.Errorf("failed to write audit entry: %w", err)
+		}
+
+		// Sync to disk for integrity
+		if err := l.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync audit file: %w", err)
+		}
 	}
 
-	// Sync to disk for integrity
-	if err := l.file.Sync(); err != nil {
-		return fmt.Errorf("failed to sync audit file: %w", err)
+	l.lastHash = entry.Hash
+	l.entryCount++
+
+	// Critical events are durable before the write is reported as successful
+	if l.config.BufferedWrites && (l.pendingEntries >= l.flushEntries() || criticalEvents[entry.EventType]) {
+		if err := l.flush(); err != nil {
+			return err
+		}
 	}
 
 	l.logger.WithFields(logrus.Fields{
@@ -304,12 +728,18 @@ func (l *Logger) signEntry(entry *AuditEntry) (string, error) {
 	return hex.EncodeToString(hash), nil
 }
 
-// VerifyChain verifies the integrity of the audit chain
+// VerifyChain verifies the integrity of the current audit file. A file that
+// continues a rotated chain is verified from its continuation marker; use
+// VerifyAllChains to verify the history across files.
 func (l *Logger) VerifyChain() error {
 	if !l.config.Enabled || !l.config.IntegrityCheck {
 		return nil
 	}
 
+	if err := l.Flush(); err != nil {
+		return fmt.Errorf("failed to flush audit entries: %w", err)
+	}
+
 	l.logger.Info("Starting audit chain verification")
 
 	// Read all entries from current file
@@ -322,31 +752,72 @@ func (l *Logger) VerifyChain() error {
 		return nil // Empty chain is valid
 	}
 
-	// Verify each entry's hash and chain integrity
-	var prevHash string
-	for i, entry := range entries {
+	prevHash := ""
+	if entries[0].EventType == EventChainContinued {
+		prevHash = entries[0].PrevHash
+	}
+	if _, err := l.verifyEntries(filepath.Base(l.filename), entries, prevHash); err != nil {
+		return err
+	}
+
+	l.logger.WithField("entries_verified", len(entries)).Info("Audit chain verification completed successfully")
+	return nil
+}
+
+// verifyEntries checks each entry's chain identity, hash, signature and link
+// to its predecessor, returning the hash of the last entry
+func (l *Logger) verifyEntries(file string, entries []AuditEntry, prevHash string) (string, error) {
+	for i := range entries {
+		entry := &entries[i]
+
+		// Verify chain identity
+		if entry.ChainID != l.chainID {
+			return "", &VerificationError{File: file, Index: i, Entry: entry, Reason: fmt.Sprintf("entry belongs to chain %q, expected %q", entry.ChainID, l.chainID)}
+		}
+
 		// Verify hash
-		expectedHash := l.calculateHash(&entry)
+		expectedHash, err := l.calculateHash(entry)
+		if err != nil {
+			return "", &VerificationError{File: file, Index: i, Entry: entry, Reason: err.Error()}
+		}
 		if entry.Hash != expectedHash {
-			return fmt.Errorf("hash mismatch at entry %d: expected %s, got %s", i, expectedHash, entry.Hash)
+			return "", &VerificationError{File: file, Index: i, Entry: entry, Reason: fmt.Sprintf("hash mismatch: expected %s, got %s", expectedHash, entry.Hash)}
 		}
 
 		// Verify chain link
 		if entry.PrevHash != prevHash {
-			return fmt.Errorf("chain break at entry %d: expected prev_hash %s, got %s", i, prevHash, entry.PrevHash)
+			return "", &VerificationError{File: file, Index: i, Entry: entry, Reason: fmt.Sprintf("chain break: expected prev_hash %s, got %s", prevHash, entry.PrevHash)}
 		}
 
 		// Verify signature if present
-		if entry.Signature != "" && l.config.EncryptionKey != "" {
-			if err := l.verifySignature(&entry); err != nil {
-				return fmt.Errorf("signature verification failed at entry %d: %w", i, err)
-			}
+		if err := l.verifyEntrySignature(entry); err != nil {
+			return "", &VerificationError{File: file, Index: i, Entry: entry, Reason: fmt.Sprintf("signature verification failed: %v", err)}
 		}
 
 		prevHash = entry.Hash
 	}
 
-	l.logger.WithField("entries_verified", len(entries)).Info("Audit chain verification completed successfully")
+	return prevHash, nil
+}
+
+// verifyEntrySignature checks an entry's signature with whichever key the
+// logger holds for its signing scheme. Signatures that cannot be checked
+// with the available keys are skipped unless signatures are required.
+func (l *Logger) verifyEntrySignature(entry *AuditEntry) error {
+	if entry.SignedWith == SignatureEd25519 {
+		if l.publicKey == nil {
+			return nil
+		}
+		return verifyEd25519(l.publicKey, entry)
+	}
+
+	if l.requireSignatures {
+		return fmt.Errorf("entry is not signed with %s", SignatureEd25519)
+	}
+
+	if entry.Signature != "" && l.config.EncryptionKey != "" {
+		return l.verifySignature(entry)
+	}
 	return nil
 }
 
@@ -367,33 +838,53 @@ func (l *Logger) verifySignature(entry *AuditEntry) error {
 
 // readAllEntries reads all audit entries from the current file
 func (l *Logger) readAllEntries() ([]AuditEntry, error) {
-	// Implementation would read and parse JSON entries from file
-	// Simplified for brevity
-	return []AuditEntry{}, nil
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return readEntries(l.filename)
 }
 
-// loadLastHash loads the last hash from the audit file
+// loadLastHash restores the chain state from the current file
 func (l *Logger) loadLastHash() error {
-	// Implementation would read the last entry and extract its hash
-	// Simplified for brevity
-	l.lastHash = ""
+	entries, err := readEntries(l.filename)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		return fmt.Errorf("audit file %s is empty", l.filename)
+	}
+
+	last := entries[len(entries)-1]
+	if last.ChainID != l.chainID {
+		return fmt.Errorf("%w: %s continues chain %q, configured chain is %q", ErrChainMismatch, l.filename, last.ChainID, l.chainID)
+	}
+
+	l.lastHash = last.Hash
+	l.openedAt = entries[0].Timestamp
 	return nil
 }
 
-// generateID generates a unique ID for audit entries
-func generateID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
+// correlate records the context's correlation ID, if any, in an entry's
+// metadata, where the entry hash covers it
+func correlate(ctx context.Context, entry *AuditEntry) {
+	id := logging.CorrelationID(ctx)
+	if id == "" {
+		return
+	}
+	if entry.Metadata == nil {
+		entry.Metadata = make(map[string]interface{})
+	}
+	entry.Metadata[logging.FieldCorrelationID] = id
 }
 
 // LogClassification logs an email classification event
-func (l *Logger) LogClassification(email *types.Email, result *types.ClassificationResponse) error {
+func (l *Logger) LogClassification(ctx context.Context, email *types.Email, result *types.ClassificationResponse) error {
 	if !l.config.Enabled {
 		return nil
 	}
 
 	entry := &AuditEntry{
-		ID:         generateID(),
-		Timestamp:  time.Now(),
+		Timestamp:  l.clock.Now(),
 		EventType:  EventEmailClassified,
 		EmailID:    email.ID,
 		ProfileID:  result.ProfileID,
@@ -401,52 +892,184 @@ func (l *Logger) LogClassification(email *types.Email, result *types.Classificat
 		Confidence: result.Confidence,
 		Reasoning:  result.Reasoning,
 	}
+	correlate(ctx, entry)
 
-	return l.writeEntry(entry)
+	return l.appendEntry(entry)
 }
 
 // LogAction logs an email action event
-func (l *Logger) LogAction(email *types.Email, action, label string) error {
+func (l *Logger) LogAction(ctx context.Context, email *types.Email, action, label string) error {
 	if !l.config.Enabled {
 		return nil
 	}
 
 	entry := &AuditEntry{
-		ID:        generateID(),
-		Timestamp: time.Now(),
-		EventType: "action",
+		Timestamp: l.clock.Now(),
+		EventType: EventAction,
 		EmailID:   email.ID,
 		Action:    action,
 		Metadata: map[string]interface{}{
 			"label": label,
 		},
 	}
+	correlate(ctx, entry)
 
-	return l.writeEntry(entry)
+	return l.appendEntry(entry)
 }
 
-// VerifyIntegrity verifies the integrity of the audit log chain
-func (l *Logger) VerifyIntegrity() (bool, error) {
-	if !l.config.Enabled || !l.config.IntegrityCheck {
-		return true, nil
+// LogActionDowngrade logs an action replaced by another because the result's
+// confidence was below the action's minimum
+func (l *Logger) LogActionDowngrade(ctx context.Context, email *types.Email, proposed, action string, confidence, threshold float64, dryRun bool) error {
+	if !l.config.Enabled {
+		return nil
+	}
+
+	entry := &AuditEntry{
+		Timestamp:  l.clock.Now(),
+		EventType:  EventActionDowngraded,
+		EmailID:    email.ID,
+		Action:     action,
+		Confidence: confidence,
+		Metadata: map[string]interface{}{
+			types.MetadataProposedAction: proposed,
+			"min_confidence":             threshold,
+			"dry_run":                    dryRun,
+		},
+	}
+	correlate(ctx, entry)
+
+	return l.appendEntry(entry)
+}
+
+// LogActionIntercept logs an action whose message operation safe mode
+// replaced with an archive and a label
+func (l *Logger) LogActionIntercept(ctx context.Context, email *types.Email, action, operation, label string, confidence float64, dryRun bool) error {
+	if !l.config.Enabled {
+		return nil
+	}
+
+	entry := &AuditEntry{
+		Timestamp:  l.clock.Now(),
+		EventType:  EventActionIntercepted,
+		EmailID:    email.ID,
+		Action:     action,
+		Confidence: confidence,
+		Metadata: map[string]interface{}{
+			"operation": operation,
+			"label":     label,
+			"dry_run":   dryRun,
+		},
+	}
+	correlate(ctx, entry)
+
+	return l.appendEntry(entry)
+}
+
+// LogMessageRemoval logs a message moved to the trash or, when permanent,
+// deleted for good. The two are distinct event types so that the
+// irreversible deletes can be queried on their own.
+func (l *Logger) LogMessageRemoval(ctx context.Context, messageID string, permanent bool) error {
+	if !l.config.Enabled {
+		return nil
+	}
+
+	eventType := EventMessageTrashed
+	if permanent {
+		eventType = EventMessageDeleted
+	}
+
+	entry := &AuditEntry{
+		Timestamp: l.clock.Now(),
+		EventType: eventType,
+		EmailID:   messageID,
+		Metadata: map[string]interface{}{
+			"permanent": permanent,
+		},
+	}
+	correlate(ctx, entry)
+
+	return l.appendEntry(entry)
+}
+
+// LogLabelChange logs the label changes and message operation applied to an
+// email. In dry-run mode the changes are recorded as planned rather than
+// applied.
+func (l *Logger) LogLabelChange(ctx context.Context, email *types.Email, action string, addLabels, removeLabels []string, operation string, dryRun bool) error {
+	if !l.config.Enabled {
+		return nil
+	}
+
+	eventType := EventActionApplied
+	if dryRun {
+		eventType = EventActionPlanned
 	}
 
-	// Implementation would verify the hash chain
-	// For now, return true as a placeholder
+	entry := &AuditEntry{
+		Timestamp: l.clock.Now(),
+		EventType: eventType,
+		EmailID:   email.ID,
+		Action:    action,
+		Metadata: map[string]interface{}{
+			"add_labels":    addLabels,
+			"remove_labels": removeLabels,
+			"dry_run":       dryRun,
+		},
+	}
+	if operation != "" {
+		entry.Metadata["operation"] = operation
+	}
+	correlate(ctx, entry)
+
+	return l.appendEntry(entry)
+}
+
+// VerifyIntegrity verifies the integrity of the whole audit log history
+func (l *Logger) VerifyIntegrity() (bool, error) {
+	if err := l.VerifyAllChains(); err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
-// Close closes the audit logger and performs final verification
+// Enabled reports whether entries are written, rather than dropped as they
+// are with auditing disabled
+func (l *Logger) Enabled() bool {
+	return l.config.Enabled
+}
+
+// Close logs the system stop event, closes the audit logger and performs
+// final verification. The stop event is the last entry: anything logged
+// afterwards fails with ErrClosed. Closing a closed logger is a no-op.
 func (l *Logger) Close() error {
 	if !l.config.Enabled || l.file == nil {
 		return nil
 	}
 
-	// Log system stop event
-	l.LogSystemEvent(EventSystemStop, map[string]interface{}{
-		"total_entries": l.entryCount,
-		"final_hash":    l.lastHash,
-	})
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return nil
+	}
+	err := l.rotateIfNeeded()
+	if err == nil {
+		err = l.writeEntry(&AuditEntry{
+			Timestamp: l.clock.Now(),
+			EventType: EventSystemStop,
+			Metadata: map[string]interface{}{
+				"total_entries": l.entryCount,
+				"final_hash":    l.lastHash,
+			},
+		})
+	}
+	if err != nil {
+		l.logger.WithError(err).Error("Failed to log system stop event")
+	}
+	l.closed = true
+	l.mutex.Unlock()
+
+	if err := l.stopFlushing(); err != nil {
+		l.logger.WithError(err).Error("Failed to flush audit entries")
+	}
 
 	// Perform final integrity check
 	if err := l.VerifyChain(); err != nil {
@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// Query selects audit entries. Zero fields match every entry.
+type Query struct {
+	EventTypes []string
+	EmailID    string
+	ProfileID  string
+	Since      time.Time // entries at or after Since
+	Until      time.Time // entries before Until
+	Limit      int       // keep only the most recent matching entries
+}
+
+// Matches reports whether an entry satisfies the query, ignoring Limit
+func (q Query) Matches(entry *AuditEntry) bool {
+	if len(q.EventTypes) > 0 {
+		matched := false
+		for _, eventType := range q.EventTypes {
+			if entry.EventType == eventType {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if q.EmailID != "" && entry.EmailID != q.EmailID {
+		return false
+	}
+	if q.ProfileID != "" && entry.ProfileID != q.ProfileID {
+		return false
+	}
+	if !q.Since.IsZero() && entry.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !entry.Timestamp.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Query returns the entries of the whole audit history, including rotated
+// files, that match q, oldest first. Buffered entries are flushed first so
+// they are included.
+func (l *Logger) Query(q Query) ([]AuditEntry, error) {
+	if !l.config.Enabled {
+		return nil, nil
+	}
+
+	if err := l.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to flush audit entries: %w", err)
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	files, err := l.listAuditFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit files: %w", err)
+	}
+	return queryFiles(files, q)
+}
+
+// QueryDirectory is Query over the audit history in dir without opening a
+// Logger, which would append to the chain. The entries are not verified;
+// use VerifyAllChains or VerifyFile for that.
+func QueryDirectory(dir string, q Query) ([]AuditEntry, error) {
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit files: %w", err)
+	}
+	return queryFiles(files, q)
+}
+
+// queryFiles returns the entries in files, in order, that match q
+func queryFiles(files []string, q Query) ([]AuditEntry, error) {
+	var matched []AuditEntry
+	for _, path := range files {
+		entries, err := readEntries(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit file %s: %w", filepath.Base(path), err)
+		}
+		for i := range entries {
+			if q.Matches(&entries[i]) {
+				matched = append(matched, entries[i])
+			}
+		}
+	}
+
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[len(matched)-q.Limit:]
+	}
+	return matched, nil
+}
@@ -0,0 +1,203 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/internal/redact"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestQueryAcrossRotatedFiles(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	cfg.MaxFileSize = 1024
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	defer logger.Close()
+
+	writeQueryEntries(t, logger, 6)
+	files, err := logger.listAuditFiles()
+	require.NoError(t, err)
+	require.Greater(t, len(files), 1, "small max file size forces rotation")
+
+	tests := []struct {
+		name     string
+		query    Query
+		expected []string
+	}{
+		{"event type", Query{EventTypes: []string{EventEmailClassified}}, []string{"email-1", "email-2", "email-3", "email-4", "email-5", "email-6"}},
+		{"profile", Query{EventTypes: []string{EventEmailClassified}, ProfileID: "spam"}, []string{"email-2", "email-4", "email-6"}},
+		{"email", Query{EmailID: "email-3"}, []string{"email-3", "email-3"}},
+		{"limit keeps the most recent", Query{EventTypes: []string{EventEmailClassified}, Limit: 2}, []string{"email-5", "email-6"}},
+		{"no match", Query{ProfileID: "missing"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := logger.Query(tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, entryEmailIDs(entries))
+		})
+	}
+}
+
+func TestQueryTimeRange(t *testing.T) {
+	now := time.Now()
+	entries := []AuditEntry{
+		{EmailID: "old", Timestamp: now.Add(-2 * time.Hour)},
+		{EmailID: "recent", Timestamp: now.Add(-time.Minute)},
+		{EmailID: "future", Timestamp: now.Add(time.Hour)},
+	}
+
+	query := Query{Since: now.Add(-time.Hour), Until: now}
+	var matched []string
+	for i := range entries {
+		if query.Matches(&entries[i]) {
+			matched = append(matched, entries[i].EmailID)
+		}
+	}
+	assert.Equal(t, []string{"recent"}, matched)
+}
+
+func TestQueryIncludesBufferedEntries(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	cfg.BufferedWrites = true
+	cfg.FlushInterval = time.Hour
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	defer logger.Close()
+
+	writeQueryEntries(t, logger, 2)
+
+	entries, err := logger.Query(Query{EventTypes: []string{EventEmailClassified}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"email-1", "email-2"}, entryEmailIDs(entries))
+}
+
+func TestEntriesRecordCorrelationID(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	defer logger.Close()
+
+	email := &types.Email{ID: "email-1", Subject: "Subject 1"}
+	ctx := logging.WithCorrelationID(context.Background(), "batch-1/email-1")
+	require.NoError(t, logger.LogEmailClassification(ctx, email, &types.ClassificationResponse{ProfileID: "spam", Action: "archive"}))
+	require.NoError(t, logger.LogAction(ctx, email, "archive", "-INBOX"))
+	require.NoError(t, logger.LogAction(context.Background(), email, "archive", "-INBOX"))
+
+	entries, err := logger.Query(Query{EmailID: "email-1"})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "batch-1/email-1", entries[0].Metadata[logging.FieldCorrelationID])
+	assert.Equal(t, "batch-1/email-1", entries[1].Metadata[logging.FieldCorrelationID])
+	assert.NotContains(t, entries[2].Metadata, logging.FieldCorrelationID)
+	assert.NoError(t, logger.VerifyAllChains(), "the entry hash covers the correlation ID")
+}
+
+func TestEntriesRecordEmailContext(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	defer logger.Close()
+
+	result := &types.ClassificationResponse{ProfileID: "spam", Action: "archive"}
+	email := &types.Email{ID: "email-1", Context: map[string]string{"source": "quarantine"}}
+	require.NoError(t, logger.LogEmailClassification(context.Background(), email, result))
+	require.NoError(t, logger.LogEmailClassification(context.Background(), &types.Email{ID: "email-2"}, result))
+
+	entries, err := logger.Query(Query{EventTypes: []string{EventEmailClassified}})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, map[string]interface{}{"source": "quarantine"}, entries[0].Metadata["email_context"])
+	assert.NotContains(t, entries[1].Metadata, "email_context")
+	assert.NoError(t, logger.VerifyAllChains(), "the entry hash covers the context")
+}
+
+func TestEntriesRedacted(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	defer logger.Close()
+
+	redactor, err := redact.New(config.RedactionConfig{Enabled: true})
+	require.NoError(t, err)
+	logger.SetRedactor(redactor)
+
+	email := &types.Email{
+		ID:      "email-1",
+		From:    "jane.doe@example.com",
+		Subject: "Card 4111 1111 1111 1111 declined",
+		Context: map[string]string{"caller": "555-123-4567"},
+	}
+	result := &types.ClassificationResponse{ProfileID: "billing", Action: "label", Reasoning: "Card 4111 1111 1111 1111 was declined"}
+	require.NoError(t, logger.LogEmailClassification(context.Background(), email, result))
+	require.NoError(t, logger.LogDecision(context.Background(), email, types.NewDecision(email.ID, []*types.ClassificationResponse{result}, result)))
+
+	entries, err := logger.Query(Query{})
+	require.NoError(t, err)
+	raw, err := json.Marshal(entries)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "4111")
+	assert.NotContains(t, string(raw), "555-123-4567")
+	assert.NotContains(t, string(raw), "jane.doe@example.com")
+	assert.Equal(t, "Card [CARD_1] declined", entries[1].Metadata["email_subject"])
+	assert.Equal(t, "Card 4111 1111 1111 1111 was declined", result.Reasoning, "the result itself is not modified")
+	assert.NoError(t, logger.VerifyAllChains())
+}
+
+func TestQueryDirectoryDoesNotAppend(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	writeQueryEntries(t, logger, 3)
+	require.NoError(t, logger.Close())
+
+	before, err := QueryDirectory(cfg.Directory, Query{})
+	require.NoError(t, err)
+
+	entries, err := QueryDirectory(cfg.Directory, Query{EventTypes: []string{EventEmailClassified}})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "Subject 1", entries[0].Metadata["email_subject"])
+
+	after, err := QueryDirectory(cfg.Directory, Query{})
+	require.NoError(t, err)
+	assert.Len(t, after, len(before))
+}
+
+// Helper functions
+
+// writeQueryEntries logs a classification and an action for count emails,
+// alternating between the newsletter and spam profiles
+func writeQueryEntries(t *testing.T, logger *Logger, count int) {
+	for i := 1; i <= count; i++ {
+		email := &types.Email{ID: fmt.Sprintf("email-%d", i), Subject: fmt.Sprintf("Subject %d", i), From: "news@example.com"}
+		profileID := "newsletter"
+		if i%2 == 0 {
+			profileID = "spam"
+		}
+		require.NoError(t, logger.LogEmailClassification(context.Background(), email, &types.ClassificationResponse{
+			ProfileID:  profileID,
+			Action:     "archive",
+			Confidence: 0.8,
+		}))
+		require.NoError(t, logger.LogAction(context.Background(), email, "archive", "-INBOX"))
+	}
+}
+
+func entryEmailIDs(entries []AuditEntry) []string {
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry.EmailID)
+	}
+	return ids
+}
@@ -0,0 +1,85 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// SignatureEd25519 marks entries signed with an Ed25519 private key. Such
+// signatures cover the entry hash and can be verified with the public key
+// alone.
+const SignatureEd25519 = "ed25519"
+
+// LoadSigningKey reads a PEM encoded PKCS #8 Ed25519 private key, as
+// produced by `openssl genpkey -algorithm ed25519`
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	signingKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is %T, not Ed25519", key)
+	}
+	return signingKey, nil
+}
+
+// LoadPublicKey reads a PEM encoded PKIX Ed25519 public key, as produced by
+// `openssl pkey -pubout`
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is %T, not Ed25519", key)
+	}
+	return publicKey, nil
+}
+
+// readPEM reads the first PEM block of a file and checks its type
+func readPEM(path, blockType string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s contains no PEM data", path)
+	}
+	if block.Type != blockType {
+		return nil, fmt.Errorf("%s contains a %s block, expected %s", path, block.Type, blockType)
+	}
+	return block, nil
+}
+
+// verifyEd25519 checks an entry's Ed25519 signature over its hash
+func verifyEd25519(publicKey ed25519.PublicKey, entry *AuditEntry) error {
+	signature, err := hex.DecodeString(entry.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature format: %w", err)
+	}
+
+	if !ed25519.Verify(publicKey, []byte(entry.Hash), signature) {
+		return fmt.Errorf("invalid %s signature", SignatureEd25519)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+// FileReport summarises an offline verification of a single audit file
+type FileReport struct {
+	File      string
+	ChainID   string
+	Entries   int
+	PrevFile  string // set when the file continues a chain from a rotated file
+	FinalHash string
+}
+
+// VerifyFile verifies an exported audit file without a running logger or
+// the signing key. Every hash is recomputed, the chain linkage is followed
+// from the file's first entry, and every entry must carry a valid Ed25519
+// signature for publicKey. On failure the returned error is a
+// *VerificationError identifying the first failing entry.
+//
+// A file that starts with a chain_continued marker is verified from the hash
+// it continues; the rotated predecessor must be verified separately.
+func VerifyFile(filename string, publicKey ed25519.PublicKey) (*FileReport, error) {
+	file := filepath.Base(filename)
+	entries, err := readEntries(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit file %s: %w", file, err)
+	}
+	if len(entries) == 0 {
+		return nil, &VerificationError{File: file, Index: 0, Reason: "file is empty"}
+	}
+	if legacyGenesis(&entries[0]) {
+		return nil, &VerificationError{File: file, Index: 0, Entry: &entries[0], Reason: fmt.Sprintf("file starts a chain of version %s, which cannot be verified under hash scheme %s", legacyChainVersion, HashScheme)}
+	}
+
+	report := &FileReport{
+		File:    file,
+		ChainID: entries[0].ChainID,
+		Entries: len(entries),
+	}
+
+	var prevHash string
+	switch entries[0].EventType {
+	case EventChainGenesis:
+		// A new chain starts from an empty prev_hash
+	case EventChainContinued:
+		prevHash = entries[0].PrevHash
+		report.PrevFile, _ = entries[0].Metadata["prev_file"].(string)
+	default:
+		return nil, &VerificationError{File: file, Index: 0, Entry: &entries[0], Reason: fmt.Sprintf("expected %s or %s, got %s", EventChainGenesis, EventChainContinued, entries[0].EventType)}
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	verifier := &Logger{
+		config:            &config.AuditConfig{},
+		logger:            logger,
+		chainID:           report.ChainID,
+		publicKey:         publicKey,
+		requireSignatures: true,
+	}
+
+	report.FinalHash, err = verifier.verifyEntries(file, entries, prevHash)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyFileSignedLog(t *testing.T) {
+	dir := t.TempDir()
+	publicKey, privateKeyFile := writeTestSigningKey(t, dir)
+
+	cfg := testAuditConfig(filepath.Join(dir, "audit"))
+	cfg.SigningKeyFile = privateKeyFile
+	logger, err := NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	writeTestEntries(t, logger, 3)
+	assert.NoError(t, logger.VerifyChain())
+	require.NoError(t, logger.Close())
+
+	report, err := VerifyFile(logger.filename, publicKey)
+	require.NoError(t, err)
+	assert.Equal(t, 8, report.Entries, "genesis, six entries and system stop")
+	assert.Equal(t, DefaultChainID, report.ChainID)
+	assert.Equal(t, logger.lastHash, report.FinalHash)
+	assert.Empty(t, report.PrevFile)
+}
+
+func TestVerifyFileFailures(t *testing.T) {
+	dir := t.TempDir()
+	publicKey, privateKeyFile := writeTestSigningKey(t, dir)
+	otherKey, _ := writeTestSigningKey(t, t.TempDir())
+
+	signedCfg := testAuditConfig(filepath.Join(dir, "signed"))
+	signedCfg.SigningKeyFile = privateKeyFile
+	signed, err := NewLogger(signedCfg, testLogger())
+	require.NoError(t, err)
+	writeTestEntries(t, signed, 2)
+	require.NoError(t, signed.Close())
+
+	unsigned, err := NewLogger(testAuditConfig(filepath.Join(dir, "unsigned")), testLogger())
+	require.NoError(t, err)
+	writeTestEntries(t, unsigned, 2)
+	require.NoError(t, unsigned.Close())
+
+	tests := []struct {
+		name      string
+		filename  string
+		publicKey ed25519.PublicKey
+		edit      func(entry *AuditEntry)
+		index     int
+		reason    string
+	}{
+		{"wrong public key", signed.filename, otherKey, nil, 0, "invalid ed25519 signature"},
+		{"unsigned log", unsigned.filename, publicKey, nil, 0, "not signed with ed25519"},
+		{"tampered entry", signed.filename, publicKey, func(entry *AuditEntry) {
+			entry.Action = "delete"
+		}, 1, "hash mismatch"},
+		{"rehashed entry", signed.filename, publicKey, func(entry *AuditEntry) {
+			entry.Action = "delete"
+			entry.Hash = mustHash(t, testHashLogger(), entry)
+		}, 1, "invalid ed25519 signature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tt.filename
+			if tt.edit != nil {
+				filename = copyWithEditedEntry(t, tt.filename, 1, tt.edit)
+			}
+
+			_, err := VerifyFile(filename, tt.publicKey)
+			var verr *VerificationError
+			require.True(t, errors.As(err, &verr), "expected VerificationError, got %v", err)
+			assert.Equal(t, tt.index, verr.Index)
+			assert.Contains(t, verr.Reason, tt.reason)
+			require.NotNil(t, verr.Entry)
+		})
+	}
+}
+
+func TestLoadPublicKeyRejectsPrivateKey(t *testing.T) {
+	_, privateKeyFile := writeTestSigningKey(t, t.TempDir())
+
+	_, err := LoadPublicKey(privateKeyFile)
+	assert.ErrorContains(t, err, "expected PUBLIC KEY")
+}
+
+// Helper functions
+
+// writeTestSigningKey generates an Ed25519 key pair and writes the private
+// key as PKCS #8 PEM, returning the public key and the private key path
+func writeTestSigningKey(t *testing.T, dir string) (ed25519.PublicKey, string) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "signing.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+	return publicKey, path
+}
+
+// copyWithEditedEntry writes a copy of an audit file with one entry changed
+func copyWithEditedEntry(t *testing.T, filename string, index int, edit func(entry *AuditEntry)) string {
+	entries, err := readEntries(filename)
+	require.NoError(t, err)
+	edit(&entries[index])
+
+	var lines []string
+	for i := range entries {
+		data, err := json.Marshal(&entries[i])
+		require.NoError(t, err)
+		lines = append(lines, string(data))
+	}
+
+	path := filepath.Join(t.TempDir(), filepath.Base(filename))
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0640))
+	return path
+}
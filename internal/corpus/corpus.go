@@ -0,0 +1,140 @@
+// Package corpus classifies mailboxes exported to disk, so that profiles
+// can be evaluated against a labeled corpus without a connection to Gmail
+// or an IMAP server; only the LLM backend is contacted.
+//
+// A corpus is an mbox file or a maildir. Messages are parsed with the
+// shared rfc822 parser, classified by the selected profiles as a routed
+// batch would be, and resolved into one decision per email. When the
+// messages carry their expected action in a header, the report also scores
+// the decisions against it.
+package corpus
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Message is one raw message of a corpus. ID names it within the corpus:
+// its position in an mbox, starting at 1, or its maildir unique name.
+type Message struct {
+	ID     string
+	Source string
+	Raw    []byte
+}
+
+// Read reads up to limit messages of the mbox file or maildir at path; a
+// limit of zero reads them all
+func Read(path string, limit int) ([]Message, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open corpus: %w", err)
+	}
+	if info.IsDir() {
+		return ReadMaildir(path, limit)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open corpus: %w", err)
+	}
+	defer file.Close()
+	return ReadMbox(file, path, limit)
+}
+
+// ReadMbox reads up to limit messages of an mbox, split on its "From "
+// separator lines. Body lines quoted as ">From ", or with more '>', lose
+// one '>' as in the mboxrd format; other mbox variants differ only on
+// lines that already began with ">From ".
+func ReadMbox(r io.Reader, source string, limit int) ([]Message, error) {
+	var messages []Message
+	var current *bytes.Buffer
+	flush := func() {
+		if current == nil {
+			return
+		}
+		raw := bytes.TrimRight(current.Bytes(), "\r\n")
+		id := strconv.Itoa(len(messages) + 1)
+		messages = append(messages, Message{ID: id, Source: source + "#" + id, Raw: append(raw, '\n')})
+		current = nil
+	}
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			switch {
+			case bytes.HasPrefix(line, []byte("From ")):
+				flush()
+				if limit > 0 && len(messages) == limit {
+					return messages, nil
+				}
+				current = &bytes.Buffer{}
+			case current == nil:
+				// Text before the first separator belongs to no message
+			case bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")):
+				current.Write(line[1:])
+			default:
+				current.Write(line)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mbox %s: %w", source, err)
+		}
+	}
+	flush()
+	return messages, nil
+}
+
+// ReadMaildir reads up to limit messages of a maildir's cur and new
+// directories, in name order
+func ReadMaildir(dir string, limit int) ([]Message, error) {
+	var paths []string
+	found := false
+	for _, sub := range []string{"cur", "new"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read maildir %s: %w", dir, err)
+		}
+		found = true
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+				paths = append(paths, filepath.Join(dir, sub, entry.Name()))
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%s is not a maildir: it has neither cur nor new", dir)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return filepath.Base(paths[i]) < filepath.Base(paths[j])
+	})
+	if limit > 0 && len(paths) > limit {
+		paths = paths[:limit]
+	}
+
+	messages := make([]Message, 0, len(paths))
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message %s: %w", path, err)
+		}
+		// The unique name precedes the ":2," info whose flags change
+		unique, _, _ := strings.Cut(filepath.Base(path), ":")
+		messages = append(messages, Message{ID: unique, Source: path, Raw: raw})
+	}
+	return messages, nil
+}
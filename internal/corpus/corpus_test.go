@@ -0,0 +1,102 @@
+package corpus
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMbox = `From alice@example.com Mon Jan  1 00:00:00 2024
+From: Alice <alice@example.com>
+Subject: Lunch
+
+Are we still on?
+>From the office, I mean.
+>>From here.
+
+From billing@vendor.example Mon Jan  1 00:01:00 2024
+From: billing@vendor.example
+Subject: Invoice overdue
+
+Pay now.
+
+From promo@shop.example Mon Jan  1 00:02:00 2024
+From: promo@shop.example
+Subject: Flash sale
+
+Half price.
+`
+
+func TestReadMbox(t *testing.T) {
+	messages, err := ReadMbox(strings.NewReader(testMbox), "inbox.mbox", 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 3)
+
+	assert.Equal(t, "1", messages[0].ID)
+	assert.Equal(t, "inbox.mbox#1", messages[0].Source)
+	assert.Equal(t, "From: Alice <alice@example.com>\nSubject: Lunch\n\nAre we still on?\nFrom the office, I mean.\n>From here.\n", string(messages[0].Raw))
+	assert.Equal(t, "3", messages[2].ID)
+	assert.Contains(t, string(messages[2].Raw), "Half price.")
+}
+
+func TestReadMboxLimit(t *testing.T) {
+	messages, err := ReadMbox(strings.NewReader(testMbox), "inbox.mbox", 2)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Contains(t, string(messages[1].Raw), "Invoice overdue")
+}
+
+func TestReadMaildir(t *testing.T) {
+	dir := t.TempDir()
+	writeMessage(t, filepath.Join(dir, "cur", "1700000002.M2.host:2,S"), "Subject: Second\n\nBody\n")
+	writeMessage(t, filepath.Join(dir, "new", "1700000001.M1.host"), "Subject: First\n\nBody\n")
+	writeMessage(t, filepath.Join(dir, "new", "1700000003.M3.host"), "Subject: Third\n\nBody\n")
+	writeMessage(t, filepath.Join(dir, "tmp", "1700000000.M0.host"), "Subject: Being delivered\n\nBody\n")
+
+	messages, err := Read(dir, 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 3, "tmp holds messages still being delivered")
+	assert.Equal(t, []string{"1700000001.M1.host", "1700000002.M2.host", "1700000003.M3.host"}, messageIDs(messages))
+	assert.Equal(t, filepath.Join(dir, "cur", "1700000002.M2.host:2,S"), messages[1].Source)
+
+	messages, err = Read(dir, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1700000001.M1.host", "1700000002.M2.host"}, messageIDs(messages))
+}
+
+func TestReadRejectsNonMaildir(t *testing.T) {
+	_, err := Read(t.TempDir(), 0)
+	assert.ErrorContains(t, err, "is not a maildir")
+
+	_, err = Read(filepath.Join(t.TempDir(), "missing.mbox"), 0)
+	assert.ErrorContains(t, err, "failed to open corpus")
+}
+
+func TestReadMboxFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox.mbox")
+	require.NoError(t, os.WriteFile(path, []byte(testMbox), 0600))
+
+	messages, err := Read(path, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2", "3"}, messageIDs(messages))
+	assert.Equal(t, path+"#2", messages[1].Source)
+}
+
+// Helper functions
+
+func writeMessage(t *testing.T, path, raw string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+	require.NoError(t, os.WriteFile(path, []byte(raw), 0600))
+}
+
+func messageIDs(messages []Message) []string {
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	return ids
+}
@@ -0,0 +1,252 @@
+package corpus
+
+import (
+	"context"
+	"fmt"
+	"net/textproto"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/annotate"
+	"github.com/mailsentinel/core/internal/rfc822"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Classifier classifies a corpus email with one profile
+type Classifier interface {
+	ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error)
+}
+
+// Router selects the profiles classifying an email and gates their
+// conditional execution
+type Router interface {
+	Route(email *types.Email, profiles []*types.Profile) []*types.Profile
+	ShouldExecute(profile *types.Profile, email *types.Email, prior []*types.ClassificationResponse) bool
+}
+
+// Resolver combines the results of every profile that classified an email
+type Resolver interface {
+	ResolveDecision(email *types.Email, results []*types.ClassificationResponse) (*types.ClassificationResponse, error)
+}
+
+// ProfileResult is the result of one profile for an email
+type ProfileResult struct {
+	ProfileID  string  `json:"profile_id"`
+	Action     string  `json:"action"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Outcome is the decision reached for one message. In a dry run Profiles
+// lists the profiles routed to the email, which conditional execution may
+// still skip, and nothing is classified.
+type Outcome struct {
+	Source     string          `json:"source"`
+	EmailID    string          `json:"email_id"`
+	Subject    string          `json:"subject,omitempty"`
+	From       string          `json:"from,omitempty"`
+	Profiles   []string        `json:"profiles,omitempty"`
+	Results    []ProfileResult `json:"results,omitempty"`
+	Action     string          `json:"action,omitempty"`
+	Confidence float64         `json:"confidence,omitempty"`
+	Expected   string          `json:"expected,omitempty"`
+	Correct    bool            `json:"correct,omitempty"`
+	Skipped    bool            `json:"skipped,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// Report is the evaluation report of a corpus
+type Report struct {
+	DryRun     bool `json:"dry_run"`
+	Emails     int  `json:"emails"`
+	Classified int  `json:"classified"`
+	// Skipped counts the emails no profile applied to
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+	// ActionCounts counts the decisions by action
+	ActionCounts map[string]int `json:"action_counts"`
+
+	// Labeled counts the classified emails carrying an expected action, and
+	// Correct those whose decision matched it
+	Labeled  int     `json:"labeled"`
+	Correct  int     `json:"correct"`
+	Accuracy float64 `json:"accuracy"`
+	// Confusion counts decided actions by expected action
+	Confusion map[string]map[string]int `json:"confusion,omitempty"`
+
+	Outcomes []Outcome `json:"outcomes"`
+}
+
+// Mistakes returns the labeled outcomes whose decision differs from the
+// expected action
+func (r *Report) Mistakes() []Outcome {
+	var mistakes []Outcome
+	for _, outcome := range r.Outcomes {
+		if outcome.Expected != "" && outcome.Action != "" && !outcome.Correct {
+			mistakes = append(mistakes, outcome)
+		}
+	}
+	return mistakes
+}
+
+// String summarises the report in one line
+func (r *Report) String() string {
+	if r.DryRun {
+		return fmt.Sprintf("%d emails, %d routed to a profile, %d skipped, %d failed (dry run)",
+			r.Emails, r.Emails-r.Skipped-r.Failed, r.Skipped, r.Failed)
+	}
+	summary := fmt.Sprintf("%d emails, %d classified, %d skipped, %d failed", r.Emails, r.Classified, r.Skipped, r.Failed)
+	if r.Labeled > 0 {
+		summary += fmt.Sprintf(", %d/%d correct (%.1f%%)", r.Correct, r.Labeled, r.Accuracy*100)
+	}
+	return summary
+}
+
+// Evaluator classifies corpus messages
+type Evaluator struct {
+	classifier  Classifier
+	router      Router
+	resolver    Resolver
+	labelHeader string
+	logger      *logrus.Logger
+}
+
+// NewEvaluator creates an evaluator classifying with classifier and routing
+// with router. The resolver combines the results of several profiles; nil
+// is allowed when at most one profile classifies each email.
+func NewEvaluator(classifier Classifier, router Router, resolver Resolver, logger *logrus.Logger) *Evaluator {
+	return &Evaluator{
+		classifier: classifier,
+		router:     router,
+		resolver:   resolver,
+		logger:     logger,
+	}
+}
+
+// SetLabelHeader names the header carrying each message's expected action,
+// such as X-Expected-Action, compared without regard to case. An empty name
+// leaves messages unlabeled.
+func (e *Evaluator) SetLabelHeader(name string) {
+	e.labelHeader = name
+}
+
+// Evaluate parses each message and classifies it with the profiles, in the
+// given order, that the router selects, resolving their results into one
+// decision. A dry run parses and routes the messages without classifying
+// them. Evaluation stops early when ctx is cancelled, reporting the
+// messages evaluated so far.
+func (e *Evaluator) Evaluate(ctx context.Context, profiles []*types.Profile, messages []Message, dryRun bool) *Report {
+	report := &Report{
+		DryRun:       dryRun,
+		ActionCounts: make(map[string]int),
+		Confusion:    make(map[string]map[string]int),
+	}
+
+	for _, message := range messages {
+		if ctx.Err() != nil {
+			break
+		}
+		report.Emails++
+
+		outcome := e.evaluate(ctx, profiles, message, dryRun)
+		switch {
+		case outcome.Error != "":
+			report.Failed++
+		case outcome.Skipped:
+			report.Skipped++
+		case !dryRun:
+			report.Classified++
+			report.ActionCounts[outcome.Action]++
+			if outcome.Expected != "" {
+				report.Labeled++
+				if outcome.Correct {
+					report.Correct++
+				}
+				if report.Confusion[outcome.Expected] == nil {
+					report.Confusion[outcome.Expected] = make(map[string]int)
+				}
+				report.Confusion[outcome.Expected][outcome.Action]++
+			}
+		}
+		report.Outcomes = append(report.Outcomes, outcome)
+	}
+
+	if report.Labeled > 0 {
+		report.Accuracy = float64(report.Correct) / float64(report.Labeled)
+	}
+	return report
+}
+
+// evaluate reaches the decision for one message
+func (e *Evaluator) evaluate(ctx context.Context, profiles []*types.Profile, message Message, dryRun bool) Outcome {
+	outcome := Outcome{Source: message.Source, EmailID: message.ID}
+
+	email, err := rfc822.Parse(message.Raw)
+	if err != nil {
+		outcome.Error = err.Error()
+		return outcome
+	}
+	email.ID = message.ID
+	annotate.Email(email)
+	outcome.Subject = email.Subject
+	outcome.From = email.From
+	if e.labelHeader != "" {
+		outcome.Expected = strings.ToLower(strings.TrimSpace(email.Headers[textproto.CanonicalMIMEHeaderKey(e.labelHeader)]))
+	}
+
+	routed := e.router.Route(email, profiles)
+	if len(routed) == 0 {
+		outcome.Skipped = true
+		return outcome
+	}
+	if dryRun {
+		for _, profile := range routed {
+			outcome.Profiles = append(outcome.Profiles, profile.ID)
+		}
+		return outcome
+	}
+
+	var results []*types.ClassificationResponse
+	for _, profile := range routed {
+		if !e.router.ShouldExecute(profile, email, results) {
+			continue
+		}
+		result, err := e.classifier.ClassifyEmail(ctx, profile, email)
+		if err != nil {
+			e.logger.WithError(err).WithFields(logrus.Fields{
+				"email_id":   email.ID,
+				"profile_id": profile.ID,
+			}).Warn("Failed to classify corpus email")
+			outcome.Error = fmt.Sprintf("profile %s: %v", profile.ID, err)
+			return outcome
+		}
+		results = append(results, result)
+		outcome.Profiles = append(outcome.Profiles, profile.ID)
+		outcome.Results = append(outcome.Results, ProfileResult{
+			ProfileID:  profile.ID,
+			Action:     result.Action,
+			Confidence: result.Confidence,
+		})
+	}
+	if len(results) == 0 {
+		outcome.Skipped = true
+		return outcome
+	}
+
+	decision := results[0]
+	if e.resolver != nil {
+		decision, err = e.resolver.ResolveDecision(email, results)
+		if err != nil {
+			outcome.Error = fmt.Sprintf("failed to resolve decision: %v", err)
+			return outcome
+		}
+	} else if len(results) > 1 {
+		outcome.Error = "several profiles classified the email but no resolver is configured"
+		return outcome
+	}
+
+	outcome.Action = decision.Action
+	outcome.Confidence = decision.Confidence
+	outcome.Correct = outcome.Expected != "" && outcome.Expected == decision.Action
+	return outcome
+}
@@ -0,0 +1,202 @@
+package corpus
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/profile"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestEvaluateScoresLabeledCorpus(t *testing.T) {
+	classifier := &subjectClassifier{actions: map[string]string{"spam": "archive", "finance": "label"}}
+	evaluator := NewEvaluator(classifier, testRouter(t), &confidentResolver{}, testLogger())
+	evaluator.SetLabelHeader("x-expected-action")
+
+	messages := []Message{
+		testMessage("1", "Flash sale", "Archive"),
+		testMessage("2", "Invoice overdue", "label"),
+		testMessage("3", "Lunch?", "keep"),
+		testMessage("4", "Unlabeled sale", ""),
+	}
+	report := evaluator.Evaluate(context.Background(), testProfiles(), messages, false)
+
+	assert.Equal(t, 4, report.Emails)
+	assert.Equal(t, 4, report.Classified)
+	assert.Equal(t, 3, report.Labeled)
+	assert.Equal(t, 2, report.Correct)
+	assert.InDelta(t, 2.0/3.0, report.Accuracy, 1e-9)
+	assert.Equal(t, map[string]int{"archive": 3, "label": 1}, report.ActionCounts)
+	assert.Equal(t, map[string]map[string]int{
+		"archive": {"archive": 1},
+		"label":   {"label": 1},
+		"keep":    {"archive": 1},
+	}, report.Confusion)
+
+	invoice := report.Outcomes[1]
+	assert.Equal(t, []string{"spam", "finance"}, invoice.Profiles, "the finance route matches invoices")
+	assert.Len(t, invoice.Results, 2)
+	assert.Equal(t, "label", invoice.Action, "the resolver picks the most confident result")
+	assert.Equal(t, []string{"spam"}, report.Outcomes[0].Profiles)
+
+	mistakes := report.Mistakes()
+	require.Len(t, mistakes, 1)
+	assert.Equal(t, "3", mistakes[0].EmailID)
+	assert.Equal(t, "keep", mistakes[0].Expected)
+	assert.Contains(t, report.String(), "2/3 correct (66.7%)")
+}
+
+func TestEvaluateDryRun(t *testing.T) {
+	classifier := &subjectClassifier{}
+	evaluator := NewEvaluator(classifier, testRouter(t), nil, testLogger())
+
+	report := evaluator.Evaluate(context.Background(), testProfiles(), []Message{
+		testMessage("1", "Flash sale", ""),
+		testMessage("2", "Invoice overdue", ""),
+	}, true)
+
+	assert.Zero(t, classifier.count(), "a dry run classifies nothing")
+	assert.Equal(t, 2, report.Emails)
+	assert.Zero(t, report.Classified)
+	assert.Equal(t, []string{"spam"}, report.Outcomes[0].Profiles)
+	assert.Equal(t, []string{"spam", "finance"}, report.Outcomes[1].Profiles)
+	assert.Equal(t, "2 emails, 2 routed to a profile, 0 skipped, 0 failed (dry run)", report.String())
+}
+
+func TestEvaluateFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		classifier *subjectClassifier
+		resolver   Resolver
+		message    Message
+		wantError  string
+	}{
+		{
+			name:       "unparseable message",
+			classifier: &subjectClassifier{},
+			message:    Message{ID: "1", Source: "inbox.mbox#1", Raw: []byte("no header here")},
+			wantError:  "failed to read message",
+		},
+		{
+			name:       "classification failure",
+			classifier: &subjectClassifier{err: errors.New("model not found")},
+			message:    testMessage("1", "Flash sale", ""),
+			wantError:  "profile spam: model not found",
+		},
+		{
+			name:       "several results without a resolver",
+			classifier: &subjectClassifier{},
+			message:    testMessage("1", "Invoice overdue", ""),
+			wantError:  "no resolver is configured",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluator := NewEvaluator(tt.classifier, testRouter(t), tt.resolver, testLogger())
+
+			report := evaluator.Evaluate(context.Background(), testProfiles(), []Message{tt.message}, false)
+
+			assert.Equal(t, 1, report.Failed)
+			assert.Zero(t, report.Classified)
+			assert.Contains(t, report.Outcomes[0].Error, tt.wantError)
+		})
+	}
+}
+
+func TestEvaluateStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	evaluator := NewEvaluator(&subjectClassifier{}, testRouter(t), nil, testLogger())
+
+	report := evaluator.Evaluate(ctx, testProfiles(), []Message{testMessage("1", "Flash sale", "")}, false)
+	assert.Zero(t, report.Emails)
+}
+
+// Helper functions
+
+// subjectClassifier gives each profile its configured action, archive by
+// default, with more confidence for the finance profile
+type subjectClassifier struct {
+	mutex   sync.Mutex
+	calls   int
+	actions map[string]string
+	err     error
+}
+
+func (s *subjectClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	s.mutex.Lock()
+	s.calls++
+	s.mutex.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	action, exists := s.actions[profile.ID]
+	if !exists {
+		action = "archive"
+	}
+	confidence := 0.6
+	if profile.ID == "finance" {
+		confidence = 0.9
+	}
+	return &types.ClassificationResponse{EmailID: email.ID, ProfileID: profile.ID, Action: action, Confidence: confidence}, nil
+}
+
+func (s *subjectClassifier) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.calls
+}
+
+// confidentResolver decides with the most confident result
+type confidentResolver struct{}
+
+func (confidentResolver) ResolveDecision(email *types.Email, results []*types.ClassificationResponse) (*types.ClassificationResponse, error) {
+	best := results[0]
+	for _, result := range results[1:] {
+		if result.Confidence > best.Confidence {
+			best = result
+		}
+	}
+	return best, nil
+}
+
+func testRouter(t *testing.T) *profile.Router {
+	router, err := profile.NewRouter(config.RoutingConfig{Routes: []config.Route{
+		{Name: "invoices", When: "email.subject.contains('Invoice')", Profiles: []string{"finance"}},
+	}}, testLogger())
+	require.NoError(t, err)
+	return router
+}
+
+func testProfiles() []*types.Profile {
+	return []*types.Profile{
+		{ID: "spam", Version: "1.0.0"},
+		{ID: "finance", Version: "1.0.0"},
+	}
+}
+
+func testMessage(id, subject, expected string) Message {
+	var raw strings.Builder
+	raw.WriteString("From: sender@example.com\nSubject: " + subject + "\n")
+	if expected != "" {
+		raw.WriteString("X-Expected-Action: " + expected + "\n")
+	}
+	raw.WriteString("\nBody\n")
+	return Message{ID: id, Source: "inbox.mbox#" + id, Raw: []byte(raw.String())}
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
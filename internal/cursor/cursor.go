@@ -0,0 +1,291 @@
+// Package cursor records the progress of processing batches on disk, so that
+// a batch interrupted by a crash resumes where it stopped instead of acting
+// on every email again.
+//
+// The cursor is an append-only file of JSON records, one per line, each
+// synced to disk before the call recording it returns. Progress is kept per
+// batch ID: an email completed in one batch is not completed in another, and
+// finishing a batch drops its records, compacting the file. An email is marked
+// started before its action is applied and completed once the action and
+// its audit entry are written. Gmail modifications and the local file cannot
+// be committed together, so an email found started but not completed may or
+// may not have been acted on: it is applied again on resume rather than
+// skipped. Label changes and moves to the trash end in the same state when
+// repeated, so acting again only repeats their audit entries. Two actions
+// do not: a repeated permanent delete fails, since the message is already
+// gone, leaving the email failed in the summary, and a repeated report_spam
+// signals the email to Gmail's spam filter a second time. A record torn by
+// a crash mid-write is discarded on open.
+package cursor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Record states
+const (
+	StateStarted   = "started"
+	StateCompleted = "completed"
+)
+
+// Record is one line of the cursor file
+type Record struct {
+	BatchID    string    `json:"batch_id,omitempty"`
+	EmailID    string    `json:"email_id"`
+	State      string    `json:"state"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Cursor is the durable progress of the batches not yet finished
+type Cursor struct {
+	path   string
+	file   *os.File
+	logger *logrus.Logger
+
+	mutex sync.Mutex
+	// batches holds the last record of each email, by batch ID and email ID
+	batches map[string]map[string]Record
+}
+
+// Open opens the cursor file at path, creating it and its directory when
+// needed, and loads the progress of the interrupted batches it holds
+func Open(path string, logger *logrus.Logger) (*Cursor, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cursor directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cursor: %w", err)
+	}
+
+	c := &Cursor{
+		path:    path,
+		file:    file,
+		logger:  logger,
+		batches: make(map[string]map[string]Record),
+	}
+	if err := c.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if len(c.batches) > 0 {
+		completed, interrupted := 0, 0
+		for _, records := range c.batches {
+			for _, record := range records {
+				if record.State == StateCompleted {
+					completed++
+				} else {
+					interrupted++
+				}
+			}
+		}
+		logger.WithFields(logrus.Fields{
+			"cursor":      path,
+			"batches":     len(c.batches),
+			"completed":   completed,
+			"interrupted": interrupted,
+		}).Info("Resuming interrupted batches from cursor")
+	}
+	return c, nil
+}
+
+// load reads the records of the cursor file, truncating a torn last record,
+// and leaves the file positioned for appending
+func (c *Cursor) load() error {
+	data, err := io.ReadAll(c.file)
+	if err != nil {
+		return fmt.Errorf("failed to read cursor: %w", err)
+	}
+
+	valid := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var record Record
+		if len(line) == 0 || json.Unmarshal(line, &record) != nil || valid+len(line) >= len(data) {
+			// Only the last line can be torn; it has no newline yet
+			break
+		}
+		valid += len(line) + 1
+		c.apply(record)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read cursor: %w", err)
+	}
+
+	if valid < len(data) {
+		c.logger.WithField("cursor", c.path).Warn("Discarding torn cursor record")
+		if err := c.file.Truncate(int64(valid)); err != nil {
+			return fmt.Errorf("failed to truncate cursor: %w", err)
+		}
+	}
+	if _, err := c.file.Seek(int64(valid), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek cursor: %w", err)
+	}
+	return nil
+}
+
+// apply updates the in-memory progress with a record. A completed email
+// stays completed.
+func (c *Cursor) apply(record Record) {
+	if record.State != StateStarted && record.State != StateCompleted {
+		return
+	}
+	records := c.batches[record.BatchID]
+	if records == nil {
+		records = make(map[string]Record)
+		c.batches[record.BatchID] = records
+	}
+	if records[record.EmailID].State != StateCompleted {
+		records[record.EmailID] = record
+	}
+}
+
+// state returns the state recorded for an email of a batch, or "" when none
+// is
+func (c *Cursor) state(batchID, emailID string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.batches[batchID][emailID].State
+}
+
+// Completed reports whether the email was fully processed in the batch
+func (c *Cursor) Completed(batchID, emailID string) bool {
+	return c.state(batchID, emailID) == StateCompleted
+}
+
+// Interrupted reports whether processing of the email in the batch started
+// but was not recorded as completed, as when the server crashed while
+// acting on it
+func (c *Cursor) Interrupted(batchID, emailID string) bool {
+	return c.state(batchID, emailID) == StateStarted
+}
+
+// Start records that processing of the email in the batch begins. It must
+// succeed before the email is acted on, so that no action goes unrecorded.
+func (c *Cursor) Start(batchID, emailID string) error {
+	return c.append(Record{BatchID: batchID, EmailID: emailID, State: StateStarted})
+}
+
+// Complete records that the email was classified, acted on and audited in
+// the batch
+func (c *Cursor) Complete(batchID, emailID string) error {
+	return c.append(Record{BatchID: batchID, EmailID: emailID, State: StateCompleted})
+}
+
+// append writes a record and syncs it to disk before updating the
+// in-memory progress
+func (c *Cursor) append(record Record) error {
+	record.RecordedAt = time.Now()
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode cursor record: %w", err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.file == nil {
+		return errors.New("cursor is closed")
+	}
+	if _, err := c.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write cursor record: %w", err)
+	}
+	if err := c.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync cursor: %w", err)
+	}
+	c.apply(record)
+	return nil
+}
+
+// FinishBatch drops the progress of a batch once each of its emails was
+// processed, so that sending the batch again acts on every email afresh.
+// The file is rewritten with the records of the other batches only.
+func (c *Cursor) FinishBatch(batchID string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.file == nil {
+		return errors.New("cursor is closed")
+	}
+	if _, ok := c.batches[batchID]; !ok {
+		return nil
+	}
+
+	var data []byte
+	for id, records := range c.batches {
+		if id == batchID {
+			continue
+		}
+		for _, record := range records {
+			line, err := json.Marshal(record)
+			if err != nil {
+				return fmt.Errorf("failed to encode cursor record: %w", err)
+			}
+			data = append(append(data, line...), '\n')
+		}
+	}
+
+	// The compacted records replace the file in one rename, so that a crash
+	// leaves either file whole
+	temp := c.path + ".tmp"
+	file, err := os.OpenFile(temp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to compact cursor: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to compact cursor: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync cursor: %w", err)
+	}
+	if err := os.Rename(temp, c.path); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to compact cursor: %w", err)
+	}
+	c.file.Close()
+	c.file = file
+	delete(c.batches, batchID)
+	return nil
+}
+
+// Finish ends every batch, removing the cursor file so that the next server
+// starts afresh. Only call it once every email of every batch was processed.
+func (c *Cursor) Finish() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	c.file.Close()
+	c.file = nil
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cursor: %w", err)
+	}
+	return nil
+}
+
+// Close closes the cursor file, keeping the progress of the batches not
+// finished for the next Open
+func (c *Cursor) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
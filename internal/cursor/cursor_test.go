@@ -0,0 +1,117 @@
+package cursor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursorPersistsProgress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "run.cursor")
+	c, err := Open(path, testLogger())
+	require.NoError(t, err)
+
+	require.NoError(t, c.Start("batch-1", "email-1"))
+	require.NoError(t, c.Complete("batch-1", "email-1"))
+	require.NoError(t, c.Start("batch-1", "email-2"))
+	assert.True(t, c.Completed("batch-1", "email-1"))
+	assert.False(t, c.Interrupted("batch-1", "email-1"))
+	assert.True(t, c.Interrupted("batch-1", "email-2"))
+	require.NoError(t, c.Close())
+
+	reopened, err := Open(path, testLogger())
+	require.NoError(t, err)
+	defer reopened.Close()
+	assert.True(t, reopened.Completed("batch-1", "email-1"))
+	assert.True(t, reopened.Interrupted("batch-1", "email-2"))
+	assert.False(t, reopened.Completed("batch-1", "email-2"))
+	assert.False(t, reopened.Completed("batch-1", "email-3"))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestCursorDiscardsTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.cursor")
+	c, err := Open(path, testLogger())
+	require.NoError(t, err)
+	require.NoError(t, c.Complete("batch-1", "email-1"))
+	require.NoError(t, c.Close())
+
+	// A crash mid-write leaves half a record without its newline
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"batch_id":"batch-1","email_id":"email-2","sta`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	reopened, err := Open(path, testLogger())
+	require.NoError(t, err)
+	assert.True(t, reopened.Completed("batch-1", "email-1"))
+	assert.False(t, reopened.Completed("batch-1", "email-2"))
+	require.NoError(t, reopened.Complete("batch-1", "email-3"))
+	require.NoError(t, reopened.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2, "the torn record is truncated before appending")
+	assert.Contains(t, lines[1], `"email_id":"email-3"`)
+}
+
+func TestCursorFinishRemovesProgress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.cursor")
+	c, err := Open(path, testLogger())
+	require.NoError(t, err)
+	require.NoError(t, c.Complete("batch-1", "email-1"))
+
+	require.NoError(t, c.Finish())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.Error(t, c.Start("batch-1", "email-2"), "a finished cursor records nothing")
+}
+
+func TestCursorFinishBatchCompactsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.cursor")
+	c, err := Open(path, testLogger())
+	require.NoError(t, err)
+	require.NoError(t, c.Start("batch-1", "email-1"))
+	require.NoError(t, c.Complete("batch-1", "email-1"))
+	require.NoError(t, c.Start("batch-2", "email-1"))
+	assert.False(t, c.Completed("batch-2", "email-1"), "progress is kept per batch")
+
+	require.NoError(t, c.FinishBatch("batch-1"))
+	assert.False(t, c.Completed("batch-1", "email-1"))
+	assert.True(t, c.Interrupted("batch-2", "email-1"))
+	require.NoError(t, c.Complete("batch-2", "email-2"))
+	require.NoError(t, c.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2, "the finished batch's records are dropped")
+	for _, line := range lines {
+		assert.Contains(t, line, `"batch_id":"batch-2"`)
+	}
+
+	reopened, err := Open(path, testLogger())
+	require.NoError(t, err)
+	defer reopened.Close()
+	assert.False(t, reopened.Completed("batch-1", "email-1"))
+	assert.True(t, reopened.Interrupted("batch-2", "email-1"))
+	assert.True(t, reopened.Completed("batch-2", "email-2"))
+}
+
+// Helper functions
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
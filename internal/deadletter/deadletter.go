@@ -0,0 +1,257 @@
+// Package deadletter keeps the emails whose classification failed, with the
+// reason it failed, so that they can be inspected and submitted again
+// instead of only surfacing as an error in a batch summary.
+//
+// Each dead-lettered email is a JSON file in the configured directory, named
+// after its entry ID. The ID is derived from the email and profile IDs, so
+// an email failing again replaces its entry and counts another attempt.
+// Entries hold the full email, personal data included, and are written
+// readable by their owner only.
+package deadletter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/internal/llm"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Reasons an email is dead-lettered
+const (
+	// ReasonParse is a model response that could not be parsed into a
+	// classification
+	ReasonParse = "parse"
+	// ReasonSize is a prompt exceeding the configured size limit
+	ReasonSize = "size"
+	// ReasonRetriesExhausted is a transient failure, such as a timeout or an
+	// open circuit breaker, that outlasted the retries
+	ReasonRetriesExhausted = "retries_exhausted"
+	// ReasonError is any other classification failure
+	ReasonError = "error"
+)
+
+// ErrNotFound is returned for an entry ID that is not in the queue
+var ErrNotFound = errors.New("dead letter not found")
+
+// Entry is one dead-lettered email. ProfileID is empty for emails that
+// failed a routed classification.
+type Entry struct {
+	ID             string      `json:"id"`
+	EmailID        string      `json:"email_id"`
+	ProfileID      string      `json:"profile_id,omitempty"`
+	Reason         string      `json:"reason"`
+	Error          string      `json:"error"`
+	Attempts       int         `json:"attempts"`
+	DeadLetteredAt time.Time   `json:"dead_lettered_at"`
+	Email          types.Email `json:"email"`
+}
+
+// Queue stores dead-lettered emails in a directory
+type Queue struct {
+	directory   string
+	auditLogger *audit.Logger
+	logger      *logrus.Logger
+	clock       clock.Clock
+	mutex       sync.Mutex
+}
+
+// NewQueue creates a dead-letter queue in the configured directory,
+// creating the directory if needed
+func NewQueue(cfg *config.DeadLetterConfig, auditLogger *audit.Logger, logger *logrus.Logger) (*Queue, error) {
+	if err := os.MkdirAll(cfg.Directory, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create dead-letter directory: %w", err)
+	}
+	return &Queue{
+		directory:   cfg.Directory,
+		auditLogger: auditLogger,
+		logger:      logger,
+		clock:       clock.Real{},
+	}, nil
+}
+
+// SetClock sets the clock entries are timestamped with
+func (q *Queue) SetClock(clk clock.Clock) {
+	q.clock = clk
+}
+
+// Reason returns the reason recorded for a classification error
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, llm.ErrInvalidResponse):
+		return ReasonParse
+	case errors.Is(err, llm.ErrPromptTooLarge):
+		return ReasonSize
+	case llm.IsRetryable(err):
+		return ReasonRetriesExhausted
+	default:
+		return ReasonError
+	}
+}
+
+// EntryID returns the ID of the entry of an email classified by profileID
+func EntryID(emailID, profileID string) string {
+	sum := sha256.Sum256([]byte(profileID + "\x00" + emailID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Add dead-letters an email whose classification by profileID failed with
+// classifyErr and records a dead_lettered audit event
+func (q *Queue) Add(ctx context.Context, email *types.Email, profileID string, classifyErr error) (*Entry, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	entry := &Entry{
+		ID:             EntryID(email.ID, profileID),
+		EmailID:        email.ID,
+		ProfileID:      profileID,
+		Reason:         Reason(classifyErr),
+		Error:          classifyErr.Error(),
+		Attempts:       1,
+		DeadLetteredAt: q.clock.Now(),
+		Email:          *email,
+	}
+	if previous, err := q.read(entry.ID); err == nil {
+		entry.Attempts = previous.Attempts + 1
+	}
+	if err := q.write(entry); err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx, q.logger).WithFields(logrus.Fields{
+		"email_id":       email.ID,
+		"profile_id":     profileID,
+		"dead_letter_id": entry.ID,
+		"reason":         entry.Reason,
+		"attempts":       entry.Attempts,
+	}).Warn("Dead-lettered email")
+
+	if q.auditLogger != nil {
+		if err := q.auditLogger.LogDeadLetter(ctx, email, profileID, entry.ID, entry.Reason, classifyErr); err != nil {
+			logging.FromContext(ctx, q.logger).WithError(err).Warn("Failed to audit dead letter")
+		}
+	}
+	return entry, nil
+}
+
+// List returns the entries in the queue, oldest first
+func (q *Queue) List() ([]Entry, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(q.directory, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(paths))
+	for _, path := range paths {
+		entry, err := q.read(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].DeadLetteredAt.Equal(entries[j].DeadLetteredAt) {
+			return entries[i].ID < entries[j].ID
+		}
+		return entries[i].DeadLetteredAt.Before(entries[j].DeadLetteredAt)
+	})
+	return entries, nil
+}
+
+// Get returns an entry by ID, or ErrNotFound
+func (q *Queue) Get(id string) (*Entry, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.read(id)
+}
+
+// Remove deletes an entry, typically once its email was requeued
+// successfully
+func (q *Queue) Remove(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	path, err := q.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		return fmt.Errorf("failed to remove dead letter %s: %w", id, err)
+	}
+	return nil
+}
+
+// read loads an entry; the mutex must be held
+func (q *Queue) read(id string) (*Entry, error) {
+	path, err := q.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letter %s: %w", id, err)
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse dead letter %s: %w", id, err)
+	}
+	return &entry, nil
+}
+
+// write atomically replaces an entry's file; the mutex must be held
+func (q *Queue) write(entry *Entry) error {
+	path, err := q.path(entry.ID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace dead letter: %w", err)
+	}
+	return nil
+}
+
+// path returns the file of an entry, rejecting IDs that are not ones
+// EntryID produces so that a requested ID cannot name another file
+func (q *Queue) path(id string) (string, error) {
+	if len(id) != 16 || strings.Trim(id, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return filepath.Join(q.directory, id+".json"), nil
+}
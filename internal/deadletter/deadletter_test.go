@@ -0,0 +1,113 @@
+package deadletter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/internal/llm"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"unparseable response", fmt.Errorf("failed to parse classification response: %w", llm.ErrInvalidResponse), ReasonParse},
+		{"truncated response", fmt.Errorf("%w: %w", llm.ErrInvalidResponse, llm.ErrTruncatedResponse), ReasonParse},
+		{"prompt too large", fmt.Errorf("%w: 9000 bytes", llm.ErrPromptTooLarge), ReasonSize},
+		{"circuit open", fmt.Errorf("%w: %w", llm.ErrCircuitOpen, llm.ErrRetryQueueFull), ReasonRetriesExhausted},
+		{"timeout", fmt.Errorf("%w: deadline", llm.ErrTimeout), ReasonRetriesExhausted},
+		{"server error", &llm.APIError{StatusCode: 503, Body: "overloaded"}, ReasonRetriesExhausted},
+		{"missing model", fmt.Errorf("%w: llama3", llm.ErrModelNotFound), ReasonError},
+		{"other", fmt.Errorf("boom"), ReasonError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Reason(tt.err))
+		})
+	}
+}
+
+func TestQueue(t *testing.T) {
+	auditDir := t.TempDir()
+	auditLogger, err := audit.NewLogger(&config.AuditConfig{Enabled: true, Directory: auditDir}, testLogger())
+	require.NoError(t, err)
+	defer auditLogger.Close()
+
+	dir := filepath.Join(t.TempDir(), "dead_letters")
+	queue, err := NewQueue(&config.DeadLetterConfig{Enabled: true, Directory: dir}, auditLogger, testLogger())
+	require.NoError(t, err)
+	fake := clock.NewFake(time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC))
+	queue.SetClock(fake)
+
+	ctx := context.Background()
+	email := &types.Email{ID: "email-1", Subject: "Invoice", Body: "Please pay"}
+	first, err := queue.Add(ctx, email, "spam", fmt.Errorf("%w: 9000 bytes", llm.ErrPromptTooLarge))
+	require.NoError(t, err)
+	assert.Equal(t, ReasonSize, first.Reason)
+	assert.Equal(t, 1, first.Attempts)
+
+	fake.Advance(time.Minute)
+	_, err = queue.Add(ctx, &types.Email{ID: "email-2"}, "", fmt.Errorf("boom"))
+	require.NoError(t, err)
+
+	// Failing again replaces the entry and counts the attempt
+	fake.Advance(time.Minute)
+	again, err := queue.Add(ctx, email, "spam", fmt.Errorf("%w: timed out", llm.ErrTimeout))
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+	assert.Equal(t, 2, again.Attempts)
+	assert.Equal(t, ReasonRetriesExhausted, again.Reason)
+
+	entries, err := queue.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "email-2", entries[0].EmailID, "entries are listed oldest first")
+	assert.Equal(t, "email-1", entries[1].EmailID)
+	assert.Equal(t, "Please pay", entries[1].Email.Body, "the email is kept whole")
+
+	info, err := os.Stat(filepath.Join(dir, first.ID+".json"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	entry, err := queue.Get(first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "spam", entry.ProfileID)
+
+	require.NoError(t, queue.Remove(first.ID))
+	_, err = queue.Get(first.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, queue.Remove(first.ID), ErrNotFound)
+	_, err = queue.Get("../../etc/passwd")
+	assert.ErrorIs(t, err, ErrNotFound, "IDs cannot name files outside the queue")
+
+	audited, err := audit.QueryDirectory(auditDir, audit.Query{EventTypes: []string{audit.EventDeadLettered}})
+	require.NoError(t, err)
+	require.Len(t, audited, 3)
+	assert.Equal(t, "email-1", audited[0].EmailID)
+	assert.Equal(t, "spam", audited[0].ProfileID)
+	assert.Equal(t, ReasonSize, audited[0].Metadata["reason"])
+	assert.Equal(t, first.ID, audited[0].Metadata["dead_letter_id"])
+}
+
+// Helper functions
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
@@ -0,0 +1,185 @@
+// Package dedup groups near-identical emails in a batch, such as one bulk
+// message sent to many recipients, so that each group is classified once.
+//
+// Emails are compared on their normalized subject and body: case,
+// punctuation, whitespace, links and numbers are ignored, so per-recipient
+// tracking links and order numbers do not keep copies apart. Exact copies are
+// found by a content hash; near-duplicates by the Jaccard similarity of their
+// word shingles. Emails only group when they share a sender address,
+// authentication results, attachments and classification context, so that a
+// spoofed copy of a legitimate message is still classified on its own.
+package dedup
+
+import (
+	"crypto/sha256"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/mailsentinel/core/internal/reputation"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// DefaultSimilarityThreshold is used when DedupConfig.SimilarityThreshold is unset
+const DefaultSimilarityThreshold = 0.9
+
+// shingleSize is the number of consecutive words in a shingle
+const shingleSize = 3
+
+var (
+	urlPattern    = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+	numberPattern = regexp.MustCompile(`\d+`)
+)
+
+// Group is a representative email and the near-identical emails that take
+// its classification
+type Group struct {
+	Representative *types.Email
+	Duplicates     []*types.Email
+}
+
+// Deduplicator groups near-identical emails
+type Deduplicator struct {
+	threshold float64
+}
+
+// NewDeduplicator creates a deduplicator from the dedup configuration
+func NewDeduplicator(cfg config.DedupConfig) *Deduplicator {
+	threshold := cfg.SimilarityThreshold
+	if threshold <= 0 || threshold > 1 {
+		threshold = DefaultSimilarityThreshold
+	}
+	return &Deduplicator{threshold: threshold}
+}
+
+// group is a Group under construction with its representative's content
+type group struct {
+	Group
+	shingles map[uint64]struct{}
+}
+
+// Group partitions emails into groups in order of first appearance. Each
+// email joins the first group whose representative it matches; emails
+// without a match start a group of their own, so every email belongs to
+// exactly one group.
+func (d *Deduplicator) Group(emails []types.Email) []Group {
+	var groups []*group
+	exact := make(map[[sha256.Size]byte]*group)
+	bySender := make(map[string][]*group)
+
+	for i := range emails {
+		email := &emails[i]
+		key := senderKey(email)
+		words := normalizedWords(email)
+		sum := sha256.Sum256([]byte(key + "\x00" + strings.Join(words, " ")))
+
+		if match, exists := exact[sum]; exists {
+			match.Duplicates = append(match.Duplicates, email)
+			continue
+		}
+
+		candidate := &group{Group: Group{Representative: email}, shingles: shingles(words)}
+		if d.threshold < 1 {
+			if match := d.similar(bySender[key], candidate.shingles); match != nil {
+				match.Duplicates = append(match.Duplicates, email)
+				continue
+			}
+		}
+
+		groups = append(groups, candidate)
+		exact[sum] = candidate
+		bySender[key] = append(bySender[key], candidate)
+	}
+
+	result := make([]Group, len(groups))
+	for i, g := range groups {
+		result[i] = g.Group
+	}
+	return result
+}
+
+// similar returns the first group whose representative is at least as
+// similar as the threshold
+func (d *Deduplicator) similar(groups []*group, shingles map[uint64]struct{}) *group {
+	for _, g := range groups {
+		if similarity(g.shingles, shingles) >= d.threshold {
+			return g
+		}
+	}
+	return nil
+}
+
+// similarity is the Jaccard similarity of two shingle sets. Two empty sets
+// are identical.
+func similarity(a, b map[uint64]struct{}) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	shared := 0
+	for shingle := range a {
+		if _, exists := b[shingle]; exists {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// normalizedWords lowercases the subject and body, falling back to the HTML
+// body, and splits them into words with links and numbers replaced by
+// placeholders
+func normalizedWords(email *types.Email) []string {
+	body := email.Body
+	if body == "" {
+		body = email.BodyHTML
+	}
+	text := strings.ToLower(email.Subject + "\n" + body)
+	text = urlPattern.ReplaceAllString(text, " url ")
+	text = numberPattern.ReplaceAllString(text, "0")
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// shingles hashes every run of shingleSize words, or the whole text when it
+// is shorter
+func shingles(words []string) map[uint64]struct{} {
+	set := make(map[uint64]struct{})
+	size := shingleSize
+	if len(words) < size {
+		size = len(words)
+	}
+	for i := 0; size > 0 && i+size <= len(words); i++ {
+		hash := fnv.New64a()
+		hash.Write([]byte(strings.Join(words[i:i+size], " ")))
+		set[hash.Sum64()] = struct{}{}
+	}
+	return set
+}
+
+// senderKey identifies what must be equal besides content for two emails to
+// group: the sender address, the authentication results, the attachments and
+// the caller's context
+func senderKey(email *types.Email) string {
+	var auth string
+	if email.Auth != nil {
+		auth = email.Auth.SPF + "/" + email.Auth.DKIM + "/" + email.Auth.DMARC
+	}
+
+	attachments := make([]string, len(email.Attachments))
+	for i, attachment := range email.Attachments {
+		attachments[i] = strings.ToLower(attachment.Filename) + "/" + attachment.MimeType
+	}
+	sort.Strings(attachments)
+
+	context := make([]string, 0, len(email.Context))
+	for _, key := range email.ContextKeys() {
+		context = append(context, key+"="+email.Context[key])
+	}
+	return strings.Join([]string{reputation.NormalizeAddress(email.From), auth, strings.Join(attachments, ","), strings.Join(context, "\x01")}, "\x00")
+}
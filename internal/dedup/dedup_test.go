@@ -0,0 +1,88 @@
+package dedup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestGroupExactCopies(t *testing.T) {
+	emails := []types.Email{
+		{ID: "a", From: "Deals <deals@shop.example>", Subject: "Flash sale", Body: "Order 1234 ships today: https://shop.example/t/abc"},
+		{ID: "b", From: "deals@shop.example", Subject: "FLASH SALE", Body: "Order 5678 ships  today:\nhttps://shop.example/t/xyz"},
+		{ID: "c", From: "deals@shop.example", Subject: "Team lunch", Body: "Pizza on Friday?"},
+		{ID: "d", From: "deals@shop.example", Subject: "Flash sale!", Body: "order 9 ships today: www.shop.example/t/q"},
+	}
+
+	groups := NewDeduplicator(config.DedupConfig{SimilarityThreshold: 1}).Group(emails)
+
+	require.Len(t, groups, 2)
+	assert.Equal(t, "a", groups[0].Representative.ID)
+	assert.Equal(t, []string{"b", "d"}, ids(groups[0].Duplicates))
+	assert.Equal(t, "c", groups[1].Representative.ID)
+	assert.Empty(t, groups[1].Duplicates)
+}
+
+func TestGroupNearDuplicates(t *testing.T) {
+	body := "Thank you for being a valued customer. This week only, every item in our spring collection is half price. " +
+		"Free shipping applies to all orders and returns are accepted within thirty days of delivery."
+	emails := []types.Email{
+		{ID: "alice", From: "news@shop.example", Subject: "Spring sale", Body: "Hi Alice, " + body},
+		{ID: "bob", From: "news@shop.example", Subject: "Spring sale", Body: "Hi Bob, " + body},
+	}
+
+	groups := NewDeduplicator(config.DedupConfig{SimilarityThreshold: 0.8}).Group(emails)
+	require.Len(t, groups, 1)
+	assert.Equal(t, []string{"bob"}, ids(groups[0].Duplicates))
+
+	groups = NewDeduplicator(config.DedupConfig{SimilarityThreshold: 1}).Group(emails)
+	assert.Len(t, groups, 2, "a threshold of 1 only groups exact copies")
+}
+
+func TestGroupKeepsSendersApart(t *testing.T) {
+	message := types.Email{From: "billing@bank.example", Subject: "Your statement", Body: "Your statement is ready."}
+	tests := []struct {
+		name  string
+		other func(email *types.Email)
+	}{
+		{"different sender", func(email *types.Email) { email.From = "billing@bank-example.net" }},
+		{"failed authentication", func(email *types.Email) {
+			email.Auth = &types.AuthResults{SPF: types.AuthFail, DKIM: types.AuthNone, DMARC: types.AuthFail}
+		}},
+		{"attachment", func(email *types.Email) {
+			email.Attachments = []types.Attachment{{Filename: "statement.pdf.exe", MimeType: "application/octet-stream"}}
+		}},
+		{"context", func(email *types.Email) { email.Context = map[string]string{"source": "quarantine"} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original, other := message, message
+			original.ID, other.ID = "original", "other"
+			tt.other(&other)
+
+			groups := NewDeduplicator(config.DedupConfig{}).Group([]types.Email{original, other})
+			assert.Len(t, groups, 2)
+		})
+	}
+}
+
+func TestNewDeduplicatorDefaultsThreshold(t *testing.T) {
+	assert.Equal(t, DefaultSimilarityThreshold, NewDeduplicator(config.DedupConfig{}).threshold)
+	assert.Equal(t, DefaultSimilarityThreshold, NewDeduplicator(config.DedupConfig{SimilarityThreshold: 2}).threshold)
+	assert.Equal(t, 0.75, NewDeduplicator(config.DedupConfig{SimilarityThreshold: 0.75}).threshold)
+}
+
+// Helper functions
+
+func ids(emails []*types.Email) []string {
+	result := make([]string, len(emails))
+	for i, email := range emails {
+		result[i] = email.ID
+	}
+	return result
+}
@@ -0,0 +1,129 @@
+// Package emptybody detects emails whose body is empty or nearly so, such
+// as calendar invites, read receipts and delivery failures, whose content
+// is in their headers and attachments. A prompt with such a body gives the
+// model little to classify on, so these emails are flagged for profiles to
+// classify from their subject and headers with a capped confidence, or to
+// handle as system mail without prompting the model.
+package emptybody
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// minLetters is the fewest letters a body needs not to be near-empty, not
+// counting quoted lines and the signature
+const minLetters = 20
+
+// systemHeaders are the headers telling system mail apart, in the order
+// they are listed in the prompt of an empty email
+var systemHeaders = []string{
+	"Content-Type",
+	"Auto-Submitted",
+	"Precedence",
+	"X-Auto-Response-Suppress",
+	"X-Failed-Recipients",
+	"Disposition-Notification-To",
+	"Original-Recipient",
+}
+
+// IsEmpty reports whether the plain text body of an email, or the text of
+// its HTML body when it has no plain one, is empty or nearly so. Quoted
+// lines and the signature, after a "-- " line, are not counted. The snippet
+// stands for the body of an email only fetched as a preview. Encrypted
+// emails are not empty: their body cannot be read.
+func IsEmpty(email *types.Email) bool {
+	if email.IsEncrypted() {
+		return false
+	}
+	body := email.Body
+	switch {
+	case email.Preview:
+		body = email.Snippet
+	case strings.TrimSpace(body) == "" && email.BodyHTML != "":
+		body = htmlText(email.BodyHTML)
+	}
+
+	letters := 0
+	for _, line := range strings.Split(body, "\n") {
+		if strings.TrimRight(line, "\r") == "-- " {
+			break
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), ">") {
+			continue
+		}
+		for _, r := range line {
+			if unicode.IsLetter(r) {
+				letters++
+			}
+		}
+		if letters >= minLetters {
+			return false
+		}
+	}
+	return true
+}
+
+// Annotate sets whether an email's body is empty, replacing any flag it
+// carries
+func Annotate(email *types.Email) {
+	email.EmptyBody = IsEmpty(email)
+}
+
+// Headers returns the headers of an email telling system mail apart, such
+// as its Content-Type and Auto-Submitted, as "Name: value" lines
+func Headers(email *types.Email) []string {
+	var lines []string
+	for _, name := range systemHeaders {
+		if value := header(email, name); value != "" {
+			lines = append(lines, name+": "+value)
+		}
+	}
+	return lines
+}
+
+// header returns the value of an email's header, matching its name
+// regardless of case as mail providers capitalize names differently
+func header(email *types.Email, name string) string {
+	if value, ok := email.Headers[name]; ok {
+		return value
+	}
+	for key, value := range email.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// htmlText returns the text of an HTML body, without its scripts and style
+// sheets
+func htmlText(body string) string {
+	var text strings.Builder
+	skip := 0
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			// io.EOF at the end of the body, or a malformed body
+			return text.String()
+		case html.StartTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "script" || string(name) == "style" {
+				skip++
+			}
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); (string(name) == "script" || string(name) == "style") && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			if skip == 0 {
+				text.Write(tokenizer.Text())
+				text.WriteString("\n")
+			}
+		}
+	}
+}
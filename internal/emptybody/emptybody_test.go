@@ -0,0 +1,59 @@
+package emptybody
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestHeaders(t *testing.T) {
+	dsn := &types.Email{Headers: map[string]string{
+		"Auto-Submitted":      "auto-replied",
+		"Content-Type":        "multipart/report; report-type=delivery-status",
+		"Received":            "from mail.example by mx.example",
+		"X-Failed-Recipients": "j.doe@partner.example",
+	}}
+	assert.Equal(t, []string{
+		"Content-Type: multipart/report; report-type=delivery-status",
+		"Auto-Submitted: auto-replied",
+		"X-Failed-Recipients: j.doe@partner.example",
+	}, Headers(dsn))
+}
+
+func TestIsEmpty(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    types.Email
+		expected bool
+	}{
+		{"empty", types.Email{}, true},
+		{"whitespace", types.Email{Body: " \r\n\r\n\t"}, true},
+		{"mobile signature only", types.Email{Body: "\n-- \nSent from my iPhone"}, true},
+		{"quoted original only", types.Email{Body: "ok\n\n> Can you confirm the meeting on Monday at ten in the main office?"}, true},
+		{"short read receipt", types.Email{Body: "Read: Invoice 42"}, true},
+		{"short message", types.Email{Body: "Can you buy gift cards for me today?"}, false},
+		{"html only", types.Email{BodyHTML: "<html><body><p>Your account has been suspended, verify now</p></body></html>"}, false},
+		{"html without text", types.Email{BodyHTML: "<html><head><style>p { color: red; }</style></head><body><img src=\"cid:logo\"></body></html>"}, true},
+		{"preview with snippet", types.Email{Preview: true, Snippet: "Your parcel could not be delivered, reschedule now"}, false},
+		{"encrypted", types.Email{Security: &types.MessageSecurity{Protocol: "pgp", Encrypted: true}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, IsEmpty(&tt.email))
+		})
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	email := &types.Email{Body: "Please review the attached contract before Friday.", EmptyBody: true}
+	Annotate(email)
+	assert.False(t, email.EmptyBody, "a flag sent by the caller is replaced")
+
+	empty := &types.Email{Headers: map[string]string{"auto-submitted": "auto-generated"}}
+	Annotate(empty)
+	assert.True(t, empty.EmptyBody)
+	assert.Equal(t, []string{"Auto-Submitted: auto-generated"}, Headers(empty), "header names match regardless of case")
+}
@@ -0,0 +1,208 @@
+// Package export writes classification results as JSON or CSV for analysts,
+// one row per email with a stable set of columns: the email ID, sender,
+// subject, action, confidence, profile, reasoning and labels.
+//
+// CSV fields are quoted as RFC 4180 requires, so commas, quotes and line
+// breaks in subjects or reasoning survive a round trip. Text fields starting
+// with a character spreadsheets read as a formula, such as "=", are prefixed
+// with a single quote, since senders control the subject.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Columns is the CSV header, in column order
+var Columns = []string{"email_id", "from", "subject", "action", "confidence", "profile_id", "reasoning", "labels"}
+
+// labelSeparator joins the labels of a row into one CSV field
+const labelSeparator = ";"
+
+// Row is the exported form of one classification
+type Row struct {
+	EmailID    string   `json:"email_id"`
+	From       string   `json:"from"`
+	Subject    string   `json:"subject"`
+	Action     string   `json:"action"`
+	Confidence float64  `json:"confidence"`
+	ProfileID  string   `json:"profile_id"`
+	Reasoning  string   `json:"reasoning"`
+	Labels     []string `json:"labels"`
+}
+
+// NewRow builds the row of a classification of email. The email may be nil
+// when it is not known, leaving the sender and subject empty.
+func NewRow(email *types.Email, result *types.ClassificationResponse) Row {
+	row := Row{
+		EmailID:    result.EmailID,
+		Action:     result.Action,
+		Confidence: result.Confidence,
+		ProfileID:  result.ProfileID,
+		Reasoning:  result.Reasoning,
+		Labels:     result.Labels,
+	}
+	if email != nil {
+		row.From = email.From
+		row.Subject = email.Subject
+		if row.EmailID == "" {
+			row.EmailID = email.ID
+		}
+	}
+	return row
+}
+
+// BatchRows builds the rows of a batch response, taking the sender and
+// subject of each result from the batch's emails by ID
+func BatchRows(response *types.BatchResponse, emails []types.Email) []Row {
+	byID := make(map[string]*types.Email, len(emails))
+	for i := range emails {
+		if _, exists := byID[emails[i].ID]; !exists {
+			byID[emails[i].ID] = &emails[i]
+		}
+	}
+
+	rows := make([]Row, len(response.Results))
+	for i := range response.Results {
+		result := &response.Results[i]
+		rows[i] = NewRow(byID[result.EmailID], result)
+	}
+	return rows
+}
+
+// EntryRow builds the row of an email_classified audit entry, from the
+// sender and subject recorded with it. It reports false for other entries,
+// shadow decisions and duplicates, which are not decisions of their own.
+func EntryRow(entry *audit.AuditEntry) (Row, bool) {
+	if entry.EventType != audit.EventEmailClassified {
+		return Row{}, false
+	}
+	if shadow, _ := entry.Metadata[types.MetadataShadow].(bool); shadow {
+		return Row{}, false
+	}
+	if _, duplicate := entry.Metadata[audit.MetadataDuplicateOf]; duplicate {
+		return Row{}, false
+	}
+
+	row := Row{
+		EmailID:    entry.EmailID,
+		Action:     entry.Action,
+		Confidence: entry.Confidence,
+		ProfileID:  entry.ProfileID,
+		Reasoning:  entry.Reasoning,
+	}
+	row.From, _ = entry.Metadata["email_from"].(string)
+	row.Subject, _ = entry.Metadata["email_subject"].(string)
+	// Labels decode from JSON as []interface{}
+	switch labels := entry.Metadata["labels"].(type) {
+	case []string:
+		row.Labels = labels
+	case []interface{}:
+		for _, label := range labels {
+			if s, ok := label.(string); ok {
+				row.Labels = append(row.Labels, s)
+			}
+		}
+	}
+	return row, true
+}
+
+// WriteJSON writes rows as an indented JSON array
+func WriteJSON(w io.Writer, rows []Row) error {
+	if rows == nil {
+		rows = []Row{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(rows); err != nil {
+		return fmt.Errorf("failed to write JSON export: %w", err)
+	}
+	return nil
+}
+
+// WriteCSV writes rows as CSV under the Columns header
+func WriteCSV(w io.Writer, rows []Row) error {
+	writer := NewCSVWriter(w)
+	for _, row := range rows {
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// CSVWriter writes a stream of rows as CSV, starting with the Columns
+// header
+type CSVWriter struct {
+	writer      *csv.Writer
+	wroteHeader bool
+}
+
+// NewCSVWriter creates a CSV writer writing to w
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{writer: csv.NewWriter(w)}
+}
+
+// Write writes one row, after the header if it is the first
+func (c *CSVWriter) Write(row Row) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	if err := c.writer.Write(row.fields()); err != nil {
+		return fmt.Errorf("failed to write CSV export: %w", err)
+	}
+	return nil
+}
+
+// Flush writes any buffered rows, and the header when no row was written
+func (c *CSVWriter) Flush() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.writer.Flush()
+	if err := c.writer.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV export: %w", err)
+	}
+	return nil
+}
+
+// writeHeader writes the header once
+func (c *CSVWriter) writeHeader() error {
+	if c.wroteHeader {
+		return nil
+	}
+	c.wroteHeader = true
+	if err := c.writer.Write(Columns); err != nil {
+		return fmt.Errorf("failed to write CSV export: %w", err)
+	}
+	return nil
+}
+
+// fields returns the row's CSV fields in column order
+func (r Row) fields() []string {
+	return []string{
+		cell(r.EmailID),
+		cell(r.From),
+		cell(r.Subject),
+		cell(r.Action),
+		strconv.FormatFloat(r.Confidence, 'f', -1, 64),
+		cell(r.ProfileID),
+		cell(r.Reasoning),
+		cell(strings.Join(r.Labels, labelSeparator)),
+	}
+}
+
+// cell neutralizes a text field a spreadsheet would evaluate as a formula
+func cell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,169 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestWriteCSVEscaping(t *testing.T) {
+	tests := []struct {
+		name     string
+		subject  string
+		expected string
+	}{
+		{"plain", "Weekly deals", "Weekly deals"},
+		{"comma", "Deals, coupons and more", "Deals, coupons and more"},
+		{"quotes", `Your "free" gift`, `Your "free" gift`},
+		{"newline", "Line one\nLine two", "Line one\nLine two"},
+		// Readers fold a quoted CRLF into a line feed
+		{"crlf", "Line one\r\nLine two", "Line one\nLine two"},
+		{"quote comma newline", "\"Act now\",\nor miss out", "\"Act now\",\nor miss out"},
+		{"leading space", "  padded  ", "  padded  "},
+		{"unicode", "Réduction de 20 % 🎉", "Réduction de 20 % 🎉"},
+		{"empty", "", ""},
+		{"formula", "=HYPERLINK(\"http://evil.example\")", "'=HYPERLINK(\"http://evil.example\")"},
+		{"plus", "+1 555 0100", "'+1 555 0100"},
+		{"at", "@channel urgent", "'@channel urgent"},
+		{"minus", "-50% today", "'-50% today"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := testRow()
+			row.Subject = tt.subject
+
+			var buf bytes.Buffer
+			require.NoError(t, WriteCSV(&buf, []Row{row}))
+
+			records := readCSV(t, buf.String())
+			require.Len(t, records, 2)
+			assert.Equal(t, Columns, records[0])
+			assert.Equal(t, tt.expected, records[1][2])
+			assert.Equal(t, "archive", records[1][3], "later columns are not shifted")
+		})
+	}
+}
+
+func TestWriteCSVColumns(t *testing.T) {
+	row := testRow()
+	row.Reasoning = "Sender matched, \"promo\" keywords\nfound"
+	row.Labels = []string{"promotions", "shopping"}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteCSV(&buf, []Row{row}))
+
+	records := readCSV(t, buf.String())
+	require.Len(t, records, 2)
+	assert.Equal(t, []string{
+		"email-1",
+		"Shop <deals@shop.example.com>",
+		"Weekly deals",
+		"archive",
+		"0.85",
+		"promotional",
+		"Sender matched, \"promo\" keywords\nfound",
+		"promotions;shopping",
+	}, records[1])
+}
+
+func TestWriteCSVEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteCSV(&buf, nil))
+	assert.Equal(t, strings.Join(Columns, ",")+"\n", buf.String())
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteJSON(&buf, []Row{testRow()}))
+
+	var rows []map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rows))
+	require.Len(t, rows, 1)
+	for _, column := range Columns {
+		assert.Contains(t, rows[0], column)
+	}
+	assert.Equal(t, "Weekly deals", rows[0]["subject"])
+
+	buf.Reset()
+	require.NoError(t, WriteJSON(&buf, nil))
+	assert.Equal(t, "[]\n", buf.String())
+}
+
+func TestBatchRows(t *testing.T) {
+	emails := []types.Email{
+		{ID: "email-1", From: "deals@shop.example.com", Subject: "Weekly deals"},
+		{ID: "email-2", From: "boss@example.com", Subject: "Quarterly plan"},
+	}
+	response := &types.BatchResponse{Results: []types.ClassificationResponse{
+		{EmailID: "email-2", ProfileID: "importance", Action: "star", Confidence: 0.9},
+		{EmailID: "unknown", ProfileID: "importance", Action: "keep", Confidence: 0.5},
+	}}
+
+	rows := BatchRows(response, emails)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "boss@example.com", rows[0].From)
+	assert.Equal(t, "Quarterly plan", rows[0].Subject)
+	assert.Equal(t, "star", rows[0].Action)
+	assert.Empty(t, rows[1].From, "results without a matching email keep an empty sender")
+	assert.Equal(t, "unknown", rows[1].EmailID)
+}
+
+func TestEntryRow(t *testing.T) {
+	entry := &audit.AuditEntry{
+		EventType:  audit.EventEmailClassified,
+		EmailID:    "email-1",
+		ProfileID:  "promotional",
+		Action:     "archive",
+		Confidence: 0.85,
+		Reasoning:  "Promotional content",
+		Metadata: map[string]interface{}{
+			"email_from":    "deals@shop.example.com",
+			"email_subject": "Weekly deals",
+			"labels":        []interface{}{"promotions"},
+		},
+	}
+
+	row, ok := EntryRow(entry)
+	require.True(t, ok)
+	assert.Equal(t, "deals@shop.example.com", row.From)
+	assert.Equal(t, "Weekly deals", row.Subject)
+	assert.Equal(t, []string{"promotions"}, row.Labels)
+
+	entry.Metadata[types.MetadataShadow] = true
+	_, ok = EntryRow(entry)
+	assert.False(t, ok, "shadow decisions are not exported")
+
+	_, ok = EntryRow(&audit.AuditEntry{EventType: audit.EventActionApplied})
+	assert.False(t, ok)
+}
+
+// Helper functions
+
+func testRow() Row {
+	return NewRow(
+		&types.Email{ID: "email-1", From: "Shop <deals@shop.example.com>", Subject: "Weekly deals"},
+		&types.ClassificationResponse{
+			EmailID:    "email-1",
+			ProfileID:  "promotional",
+			Action:     "archive",
+			Confidence: 0.85,
+			Reasoning:  "Promotional content",
+		},
+	)
+}
+
+func readCSV(t *testing.T, data string) [][]string {
+	t.Helper()
+	records, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	require.NoError(t, err)
+	return records
+}
@@ -0,0 +1,202 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// macro is a function that receives its arguments unevaluated so that it can
+// evaluate them once per element of a collection
+type macro func(args []node, s *scope) (interface{}, error)
+
+var macros map[string]macro
+
+func init() {
+	macros = map[string]macro{
+		"any":   quantifier("any"),
+		"all":   quantifier("all"),
+		"count": quantifier("count"),
+		"max":   extremum("max"),
+		"min":   extremum("min"),
+	}
+}
+
+// DefaultCollection is the identifier iterated by the single-argument forms
+// of any, all and count, with each element bound as ElementName
+const (
+	DefaultCollection = "results"
+	ElementName       = "profile"
+)
+
+// quantifier implements any/all/count. The two-argument form takes a list
+// and a predicate evaluated with each element bound as "it" (and, for map
+// elements, with the element's keys in scope). The single-argument form
+// iterates DefaultCollection, binding each element as ElementName.
+func quantifier(name string) macro {
+	return func(args []node, s *scope) (interface{}, error) {
+		var collection interface{}
+		var predicate node
+
+		switch len(args) {
+		case 1:
+			collection, _ = s.lookup(DefaultCollection)
+			predicate = args[0]
+		case 2:
+			value, err := eval(args[0], s)
+			if err != nil {
+				return nil, err
+			}
+			collection = value
+			predicate = args[1]
+		default:
+			return nil, fmt.Errorf("%s expects 1 or 2 arguments, got %d", name, len(args))
+		}
+
+		items, _ := toList(collection)
+		matches := 0
+		for _, item := range items {
+			result, err := eval(predicate, elementScope(s, item))
+			if err != nil {
+				return nil, err
+			}
+			if truthy(result) {
+				matches++
+				if name == "any" {
+					return true, nil
+				}
+			} else if name == "all" {
+				return false, nil
+			}
+		}
+
+		switch name {
+		case "any":
+			return false, nil
+		case "all":
+			return true, nil
+		default:
+			return float64(matches), nil
+		}
+	}
+}
+
+// extremum implements max/min. It accepts a list, a list and an expression
+// evaluated per element, or two or more numbers.
+func extremum(name string) macro {
+	return func(args []node, s *scope) (interface{}, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s expects at least 1 argument", name)
+		}
+
+		first, err := eval(args[0], s)
+		if err != nil {
+			return nil, err
+		}
+
+		var values []interface{}
+		items, isList := toList(first)
+		switch {
+		case isList && len(args) == 1:
+			values = items
+		case isList && len(args) == 2:
+			for _, item := range items {
+				value, err := eval(args[1], elementScope(s, item))
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, value)
+			}
+		default:
+			rest, err := evalArgs(args[1:], s)
+			if err != nil {
+				return nil, err
+			}
+			values = append([]interface{}{first}, rest...)
+		}
+
+		var best interface{}
+		for _, value := range values {
+			number, ok := toNumber(value)
+			if !ok {
+				if value == nil {
+					continue
+				}
+				return nil, fmt.Errorf("%s expects numbers, got %s", name, typeName(value))
+			}
+			current, _ := toNumber(best)
+			if best == nil || (name == "max" && number > current) || (name == "min" && number < current) {
+				best = number
+			}
+		}
+		return best, nil
+	}
+}
+
+// elementScope binds a collection element for per-element evaluation
+func elementScope(s *scope, item interface{}) *scope {
+	vars := map[string]interface{}{
+		"it":        item,
+		ElementName: item,
+	}
+	if fields, ok := item.(map[string]interface{}); ok {
+		for key, value := range fields {
+			if _, reserved := vars[key]; !reserved {
+				vars[key] = value
+			}
+		}
+	}
+	return s.child(vars)
+}
+
+// builtins are functions available in every expression
+var builtins = map[string]Func{
+	"len":        builtinLen,
+	"size":       builtinLen,
+	"lower":      stringFunc("lower", strings.ToLower),
+	"upper":      stringFunc("upper", strings.ToUpper),
+	"trim":       stringFunc("trim", strings.TrimSpace),
+	"contains":   builtinContains,
+	"startsWith": stringPredicate("startsWith", strings.HasPrefix),
+	"endsWith":   stringPredicate("endsWith", strings.HasSuffix),
+}
+
+func builtinLen(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("len expects 1 argument, got %d", len(args))
+	}
+	switch v := args[0].(type) {
+	case nil:
+		return float64(0), nil
+	case string:
+		return float64(len(v)), nil
+	}
+	if items, ok := toList(args[0]); ok {
+		return float64(len(items)), nil
+	}
+	return nil, fmt.Errorf("len expects a string or list, got %s", typeName(args[0]))
+}
+
+func builtinContains(args ...interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("contains expects 2 arguments, got %d", len(args))
+	}
+	return containsValue(args[0], args[1]), nil
+}
+
+func stringFunc(name string, fn func(string) string) Func {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument, got %d", name, len(args))
+		}
+		return fn(toString(args[0])), nil
+	}
+}
+
+func stringPredicate(name string, fn func(string, string) bool) Func {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s expects 2 arguments, got %d", name, len(args))
+		}
+		return fn(toString(args[0]), toString(args[1])), nil
+	}
+}
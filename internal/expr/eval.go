@@ -0,0 +1,515 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// Func is a function that can be called from an expression
+type Func func(args ...interface{}) (interface{}, error)
+
+// scope resolves identifiers, falling back to the enclosing scope
+type scope struct {
+	vars   map[string]interface{}
+	parent *scope
+}
+
+func (s *scope) lookup(name string) (interface{}, bool) {
+	for current := s; current != nil; current = current.parent {
+		if value, exists := current.vars[name]; exists {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func (s *scope) child(vars map[string]interface{}) *scope {
+	return &scope{vars: vars, parent: s}
+}
+
+// eval evaluates a node within a scope
+func eval(n node, s *scope) (interface{}, error) {
+	switch n := n.(type) {
+	case *literalNode:
+		return n.value, nil
+
+	case *identNode:
+		value, _ := s.lookup(n.name)
+		return value, nil
+
+	case *memberNode:
+		object, err := eval(n.object, s)
+		if err != nil {
+			return nil, err
+		}
+		return field(object, n.name), nil
+
+	case *indexNode:
+		object, err := eval(n.object, s)
+		if err != nil {
+			return nil, err
+		}
+		index, err := eval(n.index, s)
+		if err != nil {
+			return nil, err
+		}
+		return indexValue(object, index), nil
+
+	case *listNode:
+		items := make([]interface{}, len(n.items))
+		for i, item := range n.items {
+			value, err := eval(item, s)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = value
+		}
+		return items, nil
+
+	case *unaryNode:
+		operand, err := eval(n.operand, s)
+		if err != nil {
+			return nil, err
+		}
+		if n.op == "!" {
+			return !truthy(operand), nil
+		}
+		number, ok := toNumber(operand)
+		if !ok {
+			return nil, fmt.Errorf("cannot negate %s", typeName(operand))
+		}
+		return -number, nil
+
+	case *binaryNode:
+		return evalBinary(n, s)
+
+	case *callNode:
+		return evalCall(n, s)
+	}
+
+	return nil, fmt.Errorf("unsupported expression node %T", n)
+}
+
+func evalBinary(n *binaryNode, s *scope) (interface{}, error) {
+	left, err := eval(n.left, s)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logical operators short-circuit
+	switch n.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+		right, err := eval(n.right, s)
+		if err != nil {
+			return nil, err
+		}
+		return truthy(right), nil
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+		right, err := eval(n.right, s)
+		if err != nil {
+			return nil, err
+		}
+		return truthy(right), nil
+	}
+
+	right, err := eval(n.right, s)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "<", "<=", ">", ">=":
+		return compare(n.op, left, right)
+	case "in":
+		return containsValue(right, left), nil
+	case "contains":
+		return containsValue(left, right), nil
+	case "+":
+		if ls, ok := left.(string); ok {
+			return ls + toString(right), nil
+		}
+		return arithmetic(n.op, left, right)
+	default:
+		return arithmetic(n.op, left, right)
+	}
+}
+
+func evalCall(n *callNode, s *scope) (interface{}, error) {
+	switch callee := n.callee.(type) {
+	case *identNode:
+		if macro, isMacro := macros[callee.name]; isMacro {
+			return macro(n.args, s)
+		}
+
+		if value, exists := s.lookup(callee.name); exists {
+			fn, ok := value.(Func)
+			if !ok {
+				return nil, fmt.Errorf("%s is not a function", callee.name)
+			}
+			args, err := evalArgs(n.args, s)
+			if err != nil {
+				return nil, err
+			}
+			return fn(args...)
+		}
+
+		builtin, exists := builtins[callee.name]
+		if !exists {
+			return nil, fmt.Errorf("unknown function %s", callee.name)
+		}
+		args, err := evalArgs(n.args, s)
+		if err != nil {
+			return nil, err
+		}
+		return builtin(args...)
+
+	case *memberNode:
+		// A method call is sugar for calling the function with the receiver
+		// as its first argument, so x.contains(y) is contains(x, y)
+		if fn, ok := memberFunc(callee, s); ok {
+			args, err := evalArgs(n.args, s)
+			if err != nil {
+				return nil, err
+			}
+			return fn(args...)
+		}
+		return evalCall(&callNode{
+			callee: &identNode{name: callee.name},
+			args:   append([]node{callee.object}, n.args...),
+		}, s)
+	}
+
+	return nil, fmt.Errorf("expression is not callable")
+}
+
+// memberFunc returns a Func stored as a field of a map, such as helpers
+// registered under a namespace
+func memberFunc(member *memberNode, s *scope) (Func, bool) {
+	object, err := eval(member.object, s)
+	if err != nil {
+		return nil, false
+	}
+	fn, ok := field(object, member.name).(Func)
+	return fn, ok
+}
+
+func evalArgs(nodes []node, s *scope) ([]interface{}, error) {
+	args := make([]interface{}, len(nodes))
+	for i, arg := range nodes {
+		value, err := eval(arg, s)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	return args, nil
+}
+
+// field returns the named field of a map or struct, or nil when absent
+func field(object interface{}, name string) interface{} {
+	switch object := object.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		return object[name]
+	case map[string]string:
+		value, exists := object[name]
+		if !exists {
+			return nil
+		}
+		return value
+	case map[string]float64:
+		value, exists := object[name]
+		if !exists {
+			return nil
+		}
+		return value
+	}
+
+	value := reflect.ValueOf(object)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		entry := value.MapIndex(reflect.ValueOf(name).Convert(value.Type().Key()))
+		if !entry.IsValid() {
+			return nil
+		}
+		return entry.Interface()
+	case reflect.Struct:
+		fieldValue := value.FieldByNameFunc(func(candidate string) bool {
+			return strings.EqualFold(strings.ReplaceAll(candidate, "_", ""), strings.ReplaceAll(name, "_", ""))
+		})
+		if !fieldValue.IsValid() || !fieldValue.CanInterface() {
+			return nil
+		}
+		return fieldValue.Interface()
+	}
+
+	return nil
+}
+
+// indexValue returns object[index] for lists, strings and maps
+func indexValue(object, index interface{}) interface{} {
+	if key, ok := index.(string); ok {
+		return field(object, key)
+	}
+
+	position, ok := toNumber(index)
+	if !ok {
+		return nil
+	}
+
+	items, ok := toList(object)
+	if !ok {
+		return nil
+	}
+	i := int(position)
+	if i < 0 {
+		i += len(items)
+	}
+	if i < 0 || i >= len(items) {
+		return nil
+	}
+	return items[i]
+}
+
+// truthy reports whether a value counts as true in a condition
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	}
+
+	if number, ok := toNumber(value); ok {
+		return number != 0
+	}
+	if items, ok := toList(value); ok {
+		return len(items) > 0
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Map {
+		return rv.Len() > 0
+	}
+	return true
+}
+
+func equal(left, right interface{}) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+
+	if ln, ok := toNumber(left); ok {
+		rn, ok := toNumber(right)
+		return ok && ln == rn
+	}
+
+	if ls, ok := left.(string); ok {
+		rs, ok := right.(string)
+		return ok && ls == rs
+	}
+
+	return reflect.DeepEqual(left, right)
+}
+
+// compare applies an ordering operator. Comparisons involving a missing value
+// are false rather than errors so rules over optional fields stay simple.
+func compare(op string, left, right interface{}) (bool, error) {
+	if left == nil || right == nil {
+		return false, nil
+	}
+
+	var result int
+	if ln, ok := toNumber(left); ok {
+		rn, ok := toNumber(right)
+		if !ok {
+			return false, fmt.Errorf("cannot compare %s %s %s", typeName(left), op, typeName(right))
+		}
+		switch {
+		case ln < rn:
+			result = -1
+		case ln > rn:
+			result = 1
+		}
+	} else if ls, ok := left.(string); ok {
+		rs, ok := right.(string)
+		if !ok {
+			return false, fmt.Errorf("cannot compare %s %s %s", typeName(left), op, typeName(right))
+		}
+		result = strings.Compare(ls, rs)
+	} else {
+		return false, fmt.Errorf("cannot compare %s %s %s", typeName(left), op, typeName(right))
+	}
+
+	switch op {
+	case "<":
+		return result < 0, nil
+	case "<=":
+		return result <= 0, nil
+	case ">":
+		return result > 0, nil
+	default:
+		return result >= 0, nil
+	}
+}
+
+func arithmetic(op string, left, right interface{}) (interface{}, error) {
+	ln, lok := toNumber(left)
+	rn, rok := toNumber(right)
+	if !lok || !rok {
+		return nil, fmt.Errorf("cannot apply %s to %s and %s", op, typeName(left), typeName(right))
+	}
+
+	switch op {
+	case "+":
+		return ln + rn, nil
+	case "-":
+		return ln - rn, nil
+	case "*":
+		return ln * rn, nil
+	case "/":
+		if rn == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return ln / rn, nil
+	case "%":
+		if rn == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(ln, rn), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+// containsValue reports whether container holds item. Strings match
+// substrings case-insensitively, lists match elements and maps match keys.
+func containsValue(container, item interface{}) bool {
+	switch c := container.(type) {
+	case nil:
+		return false
+	case string:
+		return strings.Contains(strings.ToLower(c), strings.ToLower(toString(item)))
+	}
+
+	if items, ok := toList(container); ok {
+		for _, element := range items {
+			if equal(element, item) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if key, ok := item.(string); ok {
+		return field(container, key) != nil
+	}
+	return false
+}
+
+// toNumber converts any numeric value to float64
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// toList converts slices and arrays of any element type to []interface{}
+func toList(value interface{}) ([]interface{}, bool) {
+	if items, ok := value.([]interface{}); ok {
+		return items, true
+	}
+	if value == nil {
+		return nil, false
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+
+	items := make([]interface{}, rv.Len())
+	for i := range items {
+		items[i] = rv.Index(i).Interface()
+	}
+	return items, true
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	if number, ok := toNumber(value); ok {
+		return fmt.Sprintf("%g", number)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	}
+	if _, ok := toNumber(value); ok {
+		return "number"
+	}
+	if _, ok := toList(value); ok {
+		return "list"
+	}
+	return fmt.Sprintf("%T", value)
+}
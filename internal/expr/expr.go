@@ -0,0 +1,113 @@
+// Package expr implements the small expression language used by profile
+// policies, resolver priority rules and conditional profile execution.
+//
+// Expressions are compiled once and evaluated against an environment of
+// named values, for example:
+//
+//	metadata.phishing_score >= 0.8 && features.auth contains "dmarc=fail"
+//	any(profile.importance == 'critical' && profile.confidence >= 0.7)
+//
+// Missing fields evaluate to null, and ordering comparisons against null are
+// false, so rules over optional data do not need explicit existence checks.
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Env is the set of named values an expression is evaluated against
+type Env map[string]interface{}
+
+// Program is a compiled expression
+type Program struct {
+	source string
+	root   node
+}
+
+// Compile parses an expression into a reusable program
+func Compile(source string) (*Program, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	root, err := parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile %q: %w", source, err)
+	}
+
+	return &Program{source: source, root: root}, nil
+}
+
+// MustCompile is like Compile but panics if the expression is invalid
+func MustCompile(source string) *Program {
+	program, err := Compile(source)
+	if err != nil {
+		panic(err)
+	}
+	return program
+}
+
+// Eval evaluates the program against an environment
+func (p *Program) Eval(env Env) (interface{}, error) {
+	result, err := eval(p.root, &scope{vars: env})
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate %q: %w", p.source, err)
+	}
+	return result, nil
+}
+
+// EvalBool evaluates the program and reports whether the result is truthy
+func (p *Program) EvalBool(env Env) (bool, error) {
+	result, err := p.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	return truthy(result), nil
+}
+
+// String returns the source of the program
+func (p *Program) String() string {
+	return p.source
+}
+
+// Mentions reports whether word appears in the program as an identifier, a
+// field name or a string literal. It lets callers find the expressions that
+// refer to a named entity, such as a profile ID.
+func (p *Program) Mentions(word string) bool {
+	return mentions(p.root, word)
+}
+
+func mentions(n node, word string) bool {
+	switch n := n.(type) {
+	case *literalNode:
+		value, ok := n.value.(string)
+		return ok && value == word
+	case *identNode:
+		return n.name == word
+	case *memberNode:
+		return n.name == word || mentions(n.object, word)
+	case *indexNode:
+		return mentions(n.object, word) || mentions(n.index, word)
+	case *callNode:
+		if mentions(n.callee, word) {
+			return true
+		}
+		for _, arg := range n.args {
+			if mentions(arg, word) {
+				return true
+			}
+		}
+	case *unaryNode:
+		return mentions(n.operand, word)
+	case *binaryNode:
+		return mentions(n.left, word) || mentions(n.right, word)
+	case *listNode:
+		for _, item := range n.items {
+			if mentions(item, word) {
+				return true
+			}
+		}
+	}
+	return false
+}
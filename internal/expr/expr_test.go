@@ -0,0 +1,173 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvalBool(t *testing.T) {
+	env := testEnv()
+
+	tests := []struct {
+		name       string
+		expression string
+		expected   bool
+	}{
+		{"numeric comparison", "metadata.phishing_score >= 0.8", true},
+		{"numeric comparison false", "metadata.phishing_score < 0.5", false},
+		{"logical and", "metadata.phishing_score >= 0.8 && confidence > 0.9", true},
+		{"logical or keywords", "subject contains 'newsletter' or subject contains 'unsubscribe'", true},
+		{"not keyword", "not risk_factors.social_engineering", false},
+		{"bang operator", "!(confidence < 0.5)", true},
+		{"string equality", `action == "delete"`, true},
+		{"string inequality", "action != 'delete'", false},
+		{"bool equality", "risk_factors.social_engineering == true", true},
+		{"contains on string is case-insensitive", `features.auth contains "DMARC=FAIL"`, true},
+		{"in list", "'spam' in labels", true},
+		{"in list missing", "'work' in labels", false},
+		{"method call", "subject.contains('weekly')", true},
+		{"missing field compares false", "metadata.unknown >= 0.1", false},
+		{"missing field equals null", "metadata.unknown == null", true},
+		{"any over results", "any(profile.importance == 'critical' && profile.confidence >= 0.7)", true},
+		{"any over results no match", "any(profile.risk_factors.phishing_score >= 0.99)", false},
+		{"all with explicit list", "all(results, it.confidence > 0.5)", true},
+		{"arithmetic", "confidence * 100 >= 95", true},
+		{"len builtin", "len(labels) == 2", true},
+		{"max over results", "max(results, confidence) == 0.9", true},
+		{"list literal", "action in ['delete', 'archive']", true},
+		{"index", "labels[0] == 'spam'", true},
+		{"always literal", "true", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Compile(tt.expression)
+			require.NoError(t, err)
+
+			result, err := program.EvalBool(env)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		errorMsg   string
+	}{
+		{"empty", "  ", "empty expression"},
+		{"unterminated string", "subject == 'oops", "unterminated string"},
+		{"dangling operator", "confidence >=", "unexpected end of expression"},
+		{"unbalanced paren", "(confidence > 0.5", `expected ")"`},
+		{"trailing token", "confidence > 0.5 0.6", "unexpected"},
+		{"bad character", "confidence # 1", "unexpected character"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.expression)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errorMsg)
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		errorMsg   string
+	}{
+		{"type mismatch", "subject > 5", "cannot compare string > number"},
+		{"division by zero", "confidence / 0", "division by zero"},
+		{"unknown function", "explode(subject)", "unknown function explode"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Compile(tt.expression)
+			require.NoError(t, err)
+
+			_, err = program.Eval(testEnv())
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errorMsg)
+		})
+	}
+}
+
+func TestEvalCustomFunc(t *testing.T) {
+	env := testEnv()
+	env["domain"] = Func(func(args ...interface{}) (interface{}, error) {
+		return "example.com", nil
+	})
+
+	program, err := Compile("domain(sender) == 'example.com'")
+	require.NoError(t, err)
+
+	result, err := program.EvalBool(env)
+	require.NoError(t, err)
+	assert.True(t, result)
+}
+
+func TestEvalStructFields(t *testing.T) {
+	type sender struct {
+		TrustScore float64
+	}
+
+	program, err := Compile("sender_reputation.trust_score >= 0.9")
+	require.NoError(t, err)
+
+	result, err := program.EvalBool(Env{"sender_reputation": &sender{TrustScore: 0.95}})
+	require.NoError(t, err)
+	assert.True(t, result)
+}
+
+func TestMentions(t *testing.T) {
+	program, err := Compile("any(profile.profile_id == 'spam' && metadata.phishing_score >= 0.8) || domain(promo) == sender")
+	require.NoError(t, err)
+
+	for _, word := range []string{"spam", "profile", "profile_id", "phishing_score", "domain", "promo"} {
+		assert.True(t, program.Mentions(word), word)
+	}
+	for _, word := range []string{"alpha", "spa", "0.8", "&&"} {
+		assert.False(t, program.Mentions(word), word)
+	}
+}
+
+// Helper functions
+
+func testEnv() Env {
+	return Env{
+		"subject":    "Weekly newsletter - unsubscribe anytime",
+		"sender":     "news@example.com",
+		"action":     "delete",
+		"confidence": 0.95,
+		"labels":     []string{"spam", "promo"},
+		"metadata": map[string]interface{}{
+			"phishing_score": 0.85,
+		},
+		"features": map[string]interface{}{
+			"auth": "spf=pass dkim=pass dmarc=fail",
+		},
+		"risk_factors": map[string]interface{}{
+			"social_engineering": true,
+		},
+		"results": []interface{}{
+			map[string]interface{}{
+				"importance": "critical",
+				"confidence": 0.9,
+				"risk_factors": map[string]interface{}{
+					"phishing_score": 0.4,
+				},
+			},
+			map[string]interface{}{
+				"importance": "low",
+				"confidence": 0.6,
+			},
+		},
+	}
+}
@@ -0,0 +1,132 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokenKind identifies the lexical class of a token
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+// token is a single lexical element of an expression
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+// keywordOperators are words that act as operators rather than identifiers
+var keywordOperators = map[string]string{
+	"and":      "&&",
+	"or":       "||",
+	"not":      "!",
+	"in":       "in",
+	"contains": "contains",
+}
+
+// operators lists symbolic operators, longest first so that greedy matching works
+var operators = []string{
+	"&&", "||", "==", "!=", "<=", ">=",
+	"<", ">", "!", "+", "-", "*", "/", "%",
+	"(", ")", "[", "]", ",", ".",
+}
+
+// tokenize splits an expression into tokens
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(source) {
+		c := rune(source[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '"' || c == '\'':
+			text, next, err := scanString(source, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: source[i:next], value: text, pos: i})
+			i = next
+
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(source) && (unicode.IsDigit(rune(source[i])) || source[i] == '.') {
+				i++
+			}
+			value, err := strconv.ParseFloat(source[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", source[start:i], start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[start:i], value: value, pos: start})
+
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(source) && (source[i] == '_' || unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i]))) {
+				i++
+			}
+			word := source[start:i]
+			if op, isOperator := keywordOperators[word]; isOperator {
+				tokens = append(tokens, token{kind: tokenOperator, text: op, pos: start})
+			} else {
+				tokens = append(tokens, token{kind: tokenIdent, text: word, pos: start})
+			}
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(source)})
+	return tokens, nil
+}
+
+// scanString reads a quoted string literal starting at source[start]
+func scanString(source string, start int) (string, int, error) {
+	quote := source[start]
+	var text strings.Builder
+	for i := start + 1; i < len(source); i++ {
+		switch source[i] {
+		case quote:
+			return text.String(), i + 1, nil
+		case '\\':
+			if i+1 < len(source) {
+				i++
+				switch source[i] {
+				case 'n':
+					text.WriteByte('\n')
+				case 't':
+					text.WriteByte('\t')
+				default:
+					text.WriteByte(source[i])
+				}
+			}
+		default:
+			text.WriteByte(source[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string starting at position %d", start)
+}
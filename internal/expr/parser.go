@@ -0,0 +1,265 @@
+package expr
+
+import "fmt"
+
+// node is an element of a parsed expression tree
+type node interface{}
+
+type literalNode struct {
+	value interface{}
+}
+
+type identNode struct {
+	name string
+}
+
+type memberNode struct {
+	object node
+	name   string
+}
+
+type indexNode struct {
+	object node
+	index  node
+}
+
+type callNode struct {
+	callee node
+	args   []node
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+type binaryNode struct {
+	op    string
+	left  node
+	right node
+}
+
+type listNode struct {
+	items []node
+}
+
+// binaryPrecedence maps binary operators to their binding strength
+var binaryPrecedence = map[string]int{
+	"||":       1,
+	"&&":       2,
+	"==":       3,
+	"!=":       3,
+	"<":        3,
+	"<=":       3,
+	">":        3,
+	">=":       3,
+	"in":       3,
+	"contains": 3,
+	"+":        4,
+	"-":        4,
+	"*":        5,
+	"/":        5,
+	"%":        5,
+}
+
+// parser is a precedence-climbing parser over a token stream
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parse builds an expression tree from source
+func parse(source string) (node, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseExpression(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+	}
+	return root, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the given operator
+func (p *parser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokenOperator && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		if tok.kind == tokenEOF {
+			return fmt.Errorf("expected %q at end of expression", op)
+		}
+		return fmt.Errorf("expected %q at position %d, found %q", op, tok.pos, tok.text)
+	}
+	return nil
+}
+
+// parseExpression parses binary operators binding at least as tightly as minPrecedence
+func (p *parser) parseExpression(minPrecedence int) (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		precedence, isBinary := binaryPrecedence[tok.text]
+		if tok.kind != tokenOperator || !isBinary || precedence < minPrecedence {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseExpression(precedence + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: tok.text, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "!", operand: operand}, nil
+	}
+	if p.accept("-") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "-", operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	current, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.accept("."):
+			tok := p.next()
+			isKeywordName := tok.kind == tokenOperator && (tok.text == "contains" || tok.text == "in")
+			if tok.kind != tokenIdent && !isKeywordName {
+				return nil, fmt.Errorf("expected field name at position %d", tok.pos)
+			}
+			current = &memberNode{object: current, name: tok.text}
+
+		case p.accept("["):
+			index, err := p.parseExpression(1)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			current = &indexNode{object: current, index: index}
+
+		case p.accept("("):
+			args, err := p.parseList(")")
+			if err != nil {
+				return nil, err
+			}
+			current = &callNode{callee: current, args: args}
+
+		default:
+			return current, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenNumber, tokenString:
+		return &literalNode{value: tok.value}, nil
+
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null", "nil":
+			return &literalNode{value: nil}, nil
+		}
+		return &identNode{name: tok.text}, nil
+
+	case tokenOperator:
+		switch tok.text {
+		case "(":
+			inner, err := p.parseExpression(1)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		case "[":
+			items, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{items: items}, nil
+		}
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+	}
+
+	return nil, fmt.Errorf("unexpected end of expression")
+}
+
+// parseList parses comma-separated expressions up to the closing operator
+func (p *parser) parseList(closing string) ([]node, error) {
+	var items []node
+	if p.accept(closing) {
+		return items, nil
+	}
+
+	for {
+		item, err := p.parseExpression(1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		if p.accept(closing) {
+			return items, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
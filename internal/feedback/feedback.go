@@ -0,0 +1,202 @@
+// Package feedback records users contradicting the actions MailSentinel
+// took, such as moving an auto-archived email back to the inbox, and reports
+// them as misclassifications.
+//
+// Each piece of feedback is a user_feedback audit entry carrying the action
+// MailSentinel took and the one the user took instead. When adaptive
+// weighting is wired in, the profiles that classified the email are also
+// scored: a profile whose action matches the user's was right, and any other
+// was wrong.
+package feedback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/internal/resolver"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// ErrNoContradiction is returned for feedback whose user action is the
+// action MailSentinel took
+var ErrNoContradiction = errors.New("the user action must differ from ours")
+
+// ErrAuditDisabled is returned for feedback while auditing is disabled, as
+// feedback is only kept in the audit log
+var ErrAuditDisabled = errors.New("user feedback needs auditing enabled")
+
+// WeightRecorder moves adaptive profile weights by whether the profiles'
+// results were correct
+type WeightRecorder interface {
+	RecordFeedback(correct map[string]bool) (resolver.AdaptiveState, error)
+}
+
+// Recorder records user feedback in the audit log
+type Recorder struct {
+	audit   *audit.Logger
+	weights WeightRecorder
+	logger  *logrus.Logger
+}
+
+// NewRecorder creates a recorder auditing feedback with auditLogger
+func NewRecorder(auditLogger *audit.Logger, logger *logrus.Logger) *Recorder {
+	return &Recorder{
+		audit:  auditLogger,
+		logger: logger,
+	}
+}
+
+// SetWeights feeds every piece of feedback to adaptive weights, scoring the
+// profiles whose classifications of the email are in the audit log. Nil
+// weights stop feeding them.
+func (r *Recorder) SetWeights(weights WeightRecorder) {
+	r.weights = weights
+}
+
+// RecordFeedback records that the user took userAction on an email that
+// MailSentinel had given ourAction. The feedback is audited first; failing
+// to move the adaptive weights afterwards is only logged.
+func (r *Recorder) RecordFeedback(ctx context.Context, emailID, userAction, ourAction string) error {
+	if emailID == "" || userAction == "" || ourAction == "" {
+		return errors.New("email ID, user action and our action are required")
+	}
+	if userAction == ourAction {
+		return ErrNoContradiction
+	}
+	if !r.audit.Enabled() {
+		return ErrAuditDisabled
+	}
+	if err := r.audit.LogUserFeedback(ctx, emailID, userAction, ourAction); err != nil {
+		return fmt.Errorf("failed to audit feedback: %w", err)
+	}
+
+	logger := logging.FromContext(ctx, r.logger).WithFields(logrus.Fields{
+		"email_id":    emailID,
+		"user_action": userAction,
+		"our_action":  ourAction,
+	})
+	logger.Info("Recorded user feedback")
+	if r.weights == nil {
+		return nil
+	}
+
+	correct, err := r.profileCorrectness(emailID, userAction)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to look up the classifications of the email")
+		return nil
+	}
+	if len(correct) == 0 {
+		logger.Debug("No classifications of the email to score")
+		return nil
+	}
+	if _, err := r.weights.RecordFeedback(correct); err != nil {
+		logger.WithError(err).Warn("Failed to move adaptive weights")
+	}
+	return nil
+}
+
+// profileCorrectness scores the latest classification of the email by each
+// profile against the user's action, leaving out shadow classifications and
+// duplicates
+func (r *Recorder) profileCorrectness(emailID, userAction string) (map[string]bool, error) {
+	entries, err := r.audit.Query(audit.Query{EventTypes: []string{audit.EventEmailClassified}, EmailID: emailID})
+	if err != nil {
+		return nil, err
+	}
+
+	correct := make(map[string]bool)
+	for _, entry := range entries {
+		if shadow, _ := entry.Metadata[types.MetadataShadow].(bool); shadow || entry.ProfileID == "" {
+			continue
+		}
+		if _, duplicate := entry.Metadata[audit.MetadataDuplicateOf]; duplicate {
+			continue
+		}
+		// Entries are oldest first, so a reclassification wins
+		correct[entry.ProfileID] = entry.Action == userAction
+	}
+	return correct, nil
+}
+
+// Report returns the misclassification report of the feedback audited at
+// or after since; a zero since covers the whole audit history
+func (r *Recorder) Report(since time.Time) (*Report, error) {
+	entries, err := r.audit.Query(audit.Query{EventTypes: []string{audit.EventUserFeedback}, Since: since})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query feedback: %w", err)
+	}
+	return BuildReport(entries), nil
+}
+
+// Correction is one email whose action the user contradicted
+type Correction struct {
+	EmailID    string    `json:"email_id"`
+	OurAction  string    `json:"our_action"`
+	UserAction string    `json:"user_action"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Report summarizes user feedback as misclassifications, counting only the
+// latest feedback on each email
+type Report struct {
+	Feedback int `json:"feedback"`
+	// Corrections counts the feedback by the action MailSentinel took, then
+	// by the action the user took instead
+	Corrections map[string]map[string]int `json:"corrections"`
+	// Emails lists every correction, most recent first
+	Emails []Correction `json:"emails"`
+}
+
+// String summarises the report in one line
+func (r *Report) String() string {
+	return fmt.Sprintf("%d corrections across %d of our actions", r.Feedback, len(r.Corrections))
+}
+
+// BuildReport builds the report of user_feedback audit entries, skipping
+// other entries. Entries are oldest first, so repeated feedback on an email
+// replaces the earlier feedback.
+func BuildReport(entries []audit.AuditEntry) *Report {
+	report := &Report{
+		Corrections: make(map[string]map[string]int),
+		Emails:      []Correction{},
+	}
+	latest := make(map[string]int)
+	for _, entry := range entries {
+		if entry.EventType != audit.EventUserFeedback {
+			continue
+		}
+		userAction, _ := entry.Metadata[audit.MetadataUserAction].(string)
+		correction := Correction{
+			EmailID:    entry.EmailID,
+			OurAction:  entry.Action,
+			UserAction: userAction,
+			RecordedAt: entry.Timestamp,
+		}
+		if i, seen := latest[entry.EmailID]; seen {
+			report.Emails[i] = correction
+			continue
+		}
+		latest[entry.EmailID] = len(report.Emails)
+		report.Emails = append(report.Emails, correction)
+	}
+
+	for _, correction := range report.Emails {
+		if report.Corrections[correction.OurAction] == nil {
+			report.Corrections[correction.OurAction] = make(map[string]int)
+		}
+		report.Corrections[correction.OurAction][correction.UserAction]++
+	}
+	report.Feedback = len(report.Emails)
+
+	sort.SliceStable(report.Emails, func(i, j int) bool {
+		return report.Emails[i].RecordedAt.After(report.Emails[j].RecordedAt)
+	})
+	return report
+}
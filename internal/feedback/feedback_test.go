@@ -0,0 +1,131 @@
+package feedback
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/internal/resolver"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestRecordFeedbackIsAuditedAndReported(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC))
+	auditLogger, err := audit.NewLoggerWithClock(&config.AuditConfig{Enabled: true, Directory: t.TempDir()}, clk, testLogger())
+	require.NoError(t, err)
+	defer auditLogger.Close()
+	email := &types.Email{ID: "email-1", From: "deals@shop.example.com"}
+	for _, result := range []*types.ClassificationResponse{
+		{EmailID: "email-1", ProfileID: "promotions", Action: "archive", Confidence: 0.9},
+		{EmailID: "email-1", ProfileID: "personal", Action: "keep", Confidence: 0.6},
+		{EmailID: "email-1", ProfileID: "promotions-v2", Action: "keep", Confidence: 0.7, Metadata: map[string]interface{}{types.MetadataShadow: true}},
+	} {
+		require.NoError(t, auditLogger.LogEmailClassification(context.Background(), email, result))
+	}
+
+	weights := &recordingWeights{}
+	recorder := NewRecorder(auditLogger, testLogger())
+	recorder.SetWeights(weights)
+	clk.Advance(time.Hour)
+	require.NoError(t, recorder.RecordFeedback(context.Background(), "email-1", "keep", "archive"))
+	clk.Advance(time.Minute)
+	require.NoError(t, recorder.RecordFeedback(context.Background(), "email-2", "inbox", "archive"))
+
+	entries, err := auditLogger.Query(audit.Query{EventTypes: []string{audit.EventUserFeedback}})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "email-1", entries[0].EmailID)
+	assert.Equal(t, "archive", entries[0].Action)
+	assert.Equal(t, "keep", entries[0].Metadata[audit.MetadataUserAction])
+
+	require.Len(t, weights.correct, 1, "email-2 has no classifications to score")
+	assert.Equal(t, map[string]bool{"promotions": false, "personal": true}, weights.correct[0], "shadow classifications are not scored")
+
+	report, err := recorder.Report(entries[0].Timestamp)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Feedback)
+	assert.Equal(t, map[string]map[string]int{"archive": {"keep": 1, "inbox": 1}}, report.Corrections)
+	require.Len(t, report.Emails, 2)
+	assert.Equal(t, Correction{EmailID: "email-2", OurAction: "archive", UserAction: "inbox", RecordedAt: entries[1].Timestamp}, report.Emails[0], "most recent first")
+	assert.Equal(t, "email-1", report.Emails[1].EmailID)
+
+	recent, err := recorder.Report(entries[1].Timestamp)
+	require.NoError(t, err)
+	assert.Equal(t, 1, recent.Feedback, "feedback audited before since is left out")
+
+	clk.Advance(time.Minute)
+	require.NoError(t, recorder.RecordFeedback(context.Background(), "email-1", "inbox", "archive"))
+	report, err = recorder.Report(time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Feedback, "repeated feedback on an email is counted once")
+	assert.Equal(t, map[string]map[string]int{"archive": {"inbox": 2}}, report.Corrections)
+	require.Len(t, report.Emails, 2)
+	assert.Equal(t, "email-1", report.Emails[0].EmailID, "the latest feedback replaces the earlier one")
+	assert.Equal(t, "inbox", report.Emails[0].UserAction)
+}
+
+func TestRecordFeedbackNeedsAuditing(t *testing.T) {
+	auditLogger, err := audit.NewLogger(&config.AuditConfig{Enabled: false}, testLogger())
+	require.NoError(t, err)
+	defer auditLogger.Close()
+	recorder := NewRecorder(auditLogger, testLogger())
+
+	assert.ErrorIs(t, recorder.RecordFeedback(context.Background(), "email-1", "keep", "archive"), ErrAuditDisabled)
+}
+
+func TestRecordFeedbackValidation(t *testing.T) {
+	auditLogger := testAuditLogger(t)
+	recorder := NewRecorder(auditLogger, testLogger())
+
+	tests := []struct {
+		name                           string
+		emailID, userAction, ourAction string
+	}{
+		{"missing email", "", "keep", "archive"},
+		{"missing user action", "email-1", "", "archive"},
+		{"agreeing actions", "email-1", "archive", "archive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, recorder.RecordFeedback(context.Background(), tt.emailID, tt.userAction, tt.ourAction))
+		})
+	}
+	assert.ErrorIs(t, recorder.RecordFeedback(context.Background(), "email-1", "keep", "keep"), ErrNoContradiction)
+
+	report, err := recorder.Report(time.Time{})
+	require.NoError(t, err)
+	assert.Zero(t, report.Feedback)
+	assert.Empty(t, report.Emails)
+}
+
+// Helper functions
+
+// recordingWeights records the correctness maps it is fed
+type recordingWeights struct {
+	correct []map[string]bool
+}
+
+func (r *recordingWeights) RecordFeedback(correct map[string]bool) (resolver.AdaptiveState, error) {
+	r.correct = append(r.correct, correct)
+	return resolver.AdaptiveState{}, nil
+}
+
+func testAuditLogger(t *testing.T) *audit.Logger {
+	auditLogger, err := audit.NewLogger(&config.AuditConfig{Enabled: true, Directory: t.TempDir()}, testLogger())
+	require.NoError(t, err)
+	t.Cleanup(func() { auditLogger.Close() })
+	return auditLogger
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
@@ -2,10 +2,15 @@ package gmail
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	"mime"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -14,15 +19,40 @@ import (
 	"google.golang.org/api/option"
 	"github.com/sirupsen/logrus"
 
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/limit"
+	"github.com/mailsentinel/core/internal/mailauth"
+	"github.com/mailsentinel/core/internal/mailsec"
+	"github.com/mailsentinel/core/internal/rfc822"
 	"github.com/mailsentinel/core/pkg/config"
 	"github.com/mailsentinel/core/pkg/types"
 )
 
+// ErrPermanentDeleteDisabled is returned by DeleteMessage unless
+// gmail.allow_permanent_delete is set
+var ErrPermanentDeleteDisabled = errors.New("permanent delete is not enabled")
+
 // Client represents a Gmail API client with OAuth authentication
 type Client struct {
 	service *gmail.Service
 	config  *config.GmailConfig
 	logger  *logrus.Logger
+	audit   *audit.Logger
+	
+	// transport holds a permit of the Gmail limiter for each request
+	transport *limit.Transport
+	
+	// labelIDs caches lower-cased label names to IDs, and labelNames IDs to
+	// names; both are nil until first use
+	labelIDs   map[string]string
+	labelNames map[string]string
+	labelMutex sync.Mutex
+	
+	// syncMutex serializes incremental syncs sharing the sync state file
+	syncMutex sync.Mutex
+	
+	watch      *watchState
+	watchMutex sync.Mutex
 }
 
 // NewClient creates a new Gmail client with OAuth configuration
@@ -44,21 +74,102 @@ func NewClient(cfg *config.GmailConfig, logger *logrus.Logger) (*Client, error)
 		return nil, fmt.Errorf("failed to get OAuth token: %w", err)
 	}
 	
-	// Create HTTP client with token
-	httpClient := oauthConfig.Client(ctx, token)
+	client := &Client{
+		config: cfg,
+		logger: logger,
+	}
+	
+	// Create HTTP client with token, noting each refresh
+	tokenSource := newRefreshNotifyingTokenSource(oauthConfig.TokenSource(ctx, token), token, client.onTokenRefresh)
+	httpClient := oauth2.NewClient(ctx, tokenSource)
 	httpClient.Timeout = cfg.Timeout
+	client.transport = &limit.Transport{Base: httpClient.Transport}
+	httpClient.Transport = client.transport
 	
 	// Create Gmail service
 	service, err := gmail.NewService(ctx, option.WithHTTPClient(httpClient))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Gmail service: %w", err)
 	}
+	client.service = service
 	
+	return client, nil
+}
+
+// NewClientFromService creates a client over an existing Gmail service, such
+// as one pointed at a test server with option.WithEndpoint. Its requests are
+// not capped by SetLimiter.
+func NewClientFromService(service *gmail.Service, cfg *config.GmailConfig, logger *logrus.Logger) *Client {
 	return &Client{
-		service: service,
-		config:  cfg,
-		logger:  logger,
-	}, nil
+		service:   service,
+		config:    cfg,
+		logger:    logger,
+		transport: &limit.Transport{},
+	}
+}
+
+// SetLimiter caps the requests the client has in flight to the Gmail API
+// with limiter, which clients sharing it share the cap of. Call it before
+// the client is used; a nil limiter removes the cap.
+func (c *Client) SetLimiter(limiter *limit.Limiter) {
+	c.transport.Limiter = limiter
+}
+
+// SetAuditLogger makes the client record label changes, removals and OAuth
+// token refreshes in the audit log. A nil logger disables auditing.
+func (c *Client) SetAuditLogger(auditLogger *audit.Logger) {
+	c.audit = auditLogger
+}
+
+// onTokenRefresh records an OAuth access token refresh
+func (c *Client) onTokenRefresh(token *oauth2.Token) {
+	c.logger.WithField("expiry", token.Expiry).Info("Refreshed Gmail OAuth token")
+	
+	if c.audit == nil {
+		return
+	}
+	
+	if err := c.audit.LogSystemEvent(audit.EventAuthTokenRefresh, map[string]interface{}{
+		"token_type": token.TokenType,
+		"expiry":     token.Expiry.Format(time.RFC3339),
+	}); err != nil {
+		c.logger.WithError(err).Error("Failed to audit OAuth token refresh")
+	}
+}
+
+// refreshNotifyingTokenSource wraps a token source and calls onRefresh
+// whenever it hands out a new access token
+type refreshNotifyingTokenSource struct {
+	base        oauth2.TokenSource
+	onRefresh   func(*oauth2.Token)
+	mutex       sync.Mutex
+	accessToken string
+}
+
+func newRefreshNotifyingTokenSource(base oauth2.TokenSource, initial *oauth2.Token, onRefresh func(*oauth2.Token)) *refreshNotifyingTokenSource {
+	source := &refreshNotifyingTokenSource{base: base, onRefresh: onRefresh}
+	if initial != nil {
+		source.accessToken = initial.AccessToken
+	}
+	return source
+}
+
+// Token implements oauth2.TokenSource
+func (s *refreshNotifyingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+	
+	s.mutex.Lock()
+	refreshed := token.AccessToken != s.accessToken
+	s.accessToken = token.AccessToken
+	s.mutex.Unlock()
+	
+	if refreshed {
+		s.onRefresh(token)
+	}
+	return token, nil
 }
 
 // getToken retrieves a token from file or initiates OAuth flow
@@ -150,13 +261,221 @@ func (c *Client) ListEmails(ctx context.Context, query string, maxResults int64)
 	return emails, nil
 }
 
+// ListPreviews lists the threads matching query without fetching their
+// messages, returning one preview email per thread that carries Gmail's
+// snippet of its latest message. users.threads.list is the only listing
+// with snippets, and it returns no headers or message IDs, so a preview
+// holds its IDs and snippet alone. Its ID is that of the thread's latest
+// message matching query, read from the message list of the same query,
+// which holds IDs only. Use GetThread to fetch the messages a preview
+// stands for.
+func (c *Client) ListPreviews(ctx context.Context, query string, maxResults int64) ([]*types.Email, error) {
+	c.logger.WithFields(logrus.Fields{
+		"query":       query,
+		"max_results": maxResults,
+	}).Info("Listing email previews from Gmail")
+	
+	call := c.service.Users.Threads.List("me").Q(query)
+	if maxResults > 0 {
+		call = call.MaxResults(maxResults)
+	}
+	
+	response, err := call.Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list threads: %w", err)
+	}
+	
+	latest, err := c.latestMessageIDs(ctx, query, response.Threads)
+	if err != nil {
+		return nil, err
+	}
+
+	emails := make([]*types.Email, 0, len(response.Threads))
+	for _, thread := range response.Threads {
+		id, found := latest[thread.Id]
+		if !found {
+			// The thread ID is the ID of the thread's first message
+			id = thread.Id
+		}
+		emails = append(emails, &types.Email{
+			ID:       id,
+			ThreadID: thread.Id,
+			// Gmail escapes HTML entities in snippets
+			Snippet: html.UnescapeString(thread.Snippet),
+			Preview: true,
+		})
+	}
+	
+	return emails, nil
+}
+
+// errThreadsFound stops the message listing of latestMessageIDs
+var errThreadsFound = errors.New("every thread found")
+
+// latestMessageIDs maps each of threads to the ID of its latest message
+// matching query, listing the matching messages, newest first, only until
+// every thread is found
+func (c *Client) latestMessageIDs(ctx context.Context, query string, threads []*gmail.Thread) (map[string]string, error) {
+	latest := make(map[string]string, len(threads))
+	if len(threads) == 0 {
+		return latest, nil
+	}
+	pending := make(map[string]bool, len(threads))
+	for _, thread := range threads {
+		pending[thread.Id] = true
+	}
+
+	call := c.service.Users.Messages.List("me").Q(query)
+	if pageSize := c.pageSize(); pageSize > 0 {
+		call = call.MaxResults(pageSize)
+	}
+	err := call.Pages(ctx, func(response *gmail.ListMessagesResponse) error {
+		for _, message := range response.Messages {
+			if pending[message.ThreadId] {
+				latest[message.ThreadId] = message.Id
+				delete(pending, message.ThreadId)
+			}
+		}
+		if len(pending) == 0 {
+			return errThreadsFound
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errThreadsFound) {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	return latest, nil
+}
+
+// maxPageSize is the largest page users.messages.list returns
+const maxPageSize = 500
+
+// StreamEmails retrieves every email matching query, following the message
+// list across pages, and sends each one as soon as it is fetched. The next
+// message is only fetched once the previous one is received, so a slow
+// consumer slows the listing down instead of buffering the mailbox.
+//
+// Both channels are closed when the listing ends. Emails that cannot be
+// fetched are logged and skipped, like ListEmails does; a failure to list a
+// page or the cancellation of ctx ends the stream and is sent on the error
+// channel, which never holds more than one error.
+func (c *Client) StreamEmails(ctx context.Context, query string) (<-chan *types.Email, <-chan error) {
+	emails := make(chan *types.Email)
+	errs := make(chan error, 1)
+	
+	go func() {
+		defer close(errs)
+		defer close(emails)
+		
+		c.logger.WithField("query", query).Info("Streaming emails from Gmail")
+		
+		call := c.service.Users.Messages.List("me").Q(query)
+		if pageSize := c.pageSize(); pageSize > 0 {
+			call = call.MaxResults(pageSize)
+		}
+		
+		err := call.Pages(ctx, func(response *gmail.ListMessagesResponse) error {
+			for _, message := range response.Messages {
+				email, err := c.GetEmail(ctx, message.Id)
+				if err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					c.logger.WithError(err).WithField("message_id", message.Id).Warn("Failed to get email")
+					continue
+				}
+				select {
+				case emails <- email:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			errs <- fmt.Errorf("failed to stream messages: %w", err)
+		}
+	}()
+	
+	return emails, errs
+}
+
+// StreamBatches streams the emails matching query and passes them to
+// process in batches of gmail.batch_size, so classification starts with
+// the first page instead of after the whole listing. The listing waits
+// while a batch is processed. An error from process or from the listing
+// stops the stream.
+func (c *Client) StreamBatches(ctx context.Context, query string, process EmailProcessor) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	
+	batchSize := c.config.BatchSize
+	if batchSize <= 0 {
+		batchSize = maxPageSize
+	}
+	
+	emails, errs := c.StreamEmails(ctx, query)
+	batch := make([]*types.Email, 0, batchSize)
+	for email := range emails {
+		batch = append(batch, email)
+		if len(batch) < batchSize {
+			continue
+		}
+		if err := process(ctx, batch); err != nil {
+			return fmt.Errorf("failed to process streamed emails: %w", err)
+		}
+		batch = make([]*types.Email, 0, batchSize)
+	}
+	if err := <-errs; err != nil {
+		return err
+	}
+	
+	if len(batch) > 0 {
+		if err := process(ctx, batch); err != nil {
+			return fmt.Errorf("failed to process streamed emails: %w", err)
+		}
+	}
+	return nil
+}
+
+// pageSize is the number of messages requested per list page: the
+// configured batch size, capped at what Gmail allows, or 0 for Gmail's
+// default
+func (c *Client) pageSize() int64 {
+	if c.config.BatchSize > maxPageSize {
+		return maxPageSize
+	}
+	if c.config.BatchSize > 0 {
+		return int64(c.config.BatchSize)
+	}
+	return 0
+}
+
 // GetEmail retrieves a single email by ID
 func (c *Client) GetEmail(ctx context.Context, messageID string) (*types.Email, error) {
 	message, err := c.service.Users.Messages.Get("me", messageID).Context(ctx).Do()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get message: %w", err)
 	}
+	return messageToEmail(message), nil
+}
+
+// GetThread retrieves the messages of a thread, oldest first
+func (c *Client) GetThread(ctx context.Context, threadID string) ([]*types.Email, error) {
+	thread, err := c.service.Users.Threads.Get("me", threadID).Format("full").Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread: %w", err)
+	}
 	
+	emails := make([]*types.Email, 0, len(thread.Messages))
+	for _, message := range thread.Messages {
+		emails = append(emails, messageToEmail(message))
+	}
+	return emails, nil
+}
+
+// messageToEmail converts a full Gmail message into an email
+func messageToEmail(message *gmail.Message) *types.Email {
 	email := &types.Email{
 		ID:       message.Id,
 		ThreadID: message.ThreadId,
@@ -165,6 +484,7 @@ func (c *Client) GetEmail(ctx context.Context, messageID string) (*types.Email,
 	}
 	
 	// Parse headers
+	var authenticationResults, receivedSPF []string
 	for _, header := range message.Payload.Headers {
 		switch strings.ToLower(header.Name) {
 		case "subject":
@@ -172,31 +492,50 @@ func (c *Client) GetEmail(ctx context.Context, messageID string) (*types.Email,
 		case "from":
 			email.From = header.Value
 		case "to":
-			email.To = strings.Split(header.Value, ",")
+			email.To = rfc822.AddressList(header.Value)
 		case "cc":
-			if header.Value != "" {
-				email.CC = strings.Split(header.Value, ",")
-			}
+			email.CC = rfc822.AddressList(header.Value)
+		case "message-id":
+			email.MessageID = rfc822.MessageID(header.Value)
+		case "in-reply-to":
+			email.InReplyTo = rfc822.MessageID(header.Value)
+		case "references":
+			email.References = rfc822.MessageIDs(header.Value)
 		case "date":
 			if date, err := time.Parse(time.RFC1123Z, header.Value); err == nil {
 				email.Date = date
 			}
+		case "authentication-results":
+			authenticationResults = append(authenticationResults, header.Value)
+		case "received-spf":
+			receivedSPF = append(receivedSPF, header.Value)
 		}
 		email.Headers[header.Name] = header.Value
 	}
-	
-	// Extract body
-	email.Body = extractBody(message.Payload)
+	email.Auth = mailauth.Parse(authenticationResults, receivedSPF)
+	
+	// Extract body, unless it is encrypted, then recognize inline PGP in it
+	email.Security = extractSecurity(message.Payload)
+	if !email.IsEncrypted() {
+		body, security := mailsec.Inline(decodeBody(extractBody(message.Payload)))
+		mailsec.Mark(email, security)
+		email.Body = body
+	}
 	email.Size = message.SizeEstimate
 	
 	// Extract attachments
 	email.Attachments = extractAttachments(message.Payload)
 	
-	return email, nil
+	return email
 }
 
 // extractBody extracts plain text body from message payload
 func extractBody(payload *gmail.MessagePart) string {
+	// The first part of a signed payload is the signed content
+	if payload.MimeType == "multipart/signed" && len(payload.Parts) > 0 {
+		return extractBody(payload.Parts[0])
+	}
+	
 	if payload.Body != nil && payload.Body.Data != "" {
 		return payload.Body.Data
 	}
@@ -217,11 +556,28 @@ func extractBody(payload *gmail.MessagePart) string {
 	return ""
 }
 
-// extractAttachments extracts attachment information from message payload
+// decodeBody decodes the base64url data of a message part, which Gmail may
+// or may not pad, returning data that does not decode unchanged
+func decodeBody(data string) string {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(data, "="))
+	if err != nil {
+		return data
+	}
+	return string(decoded)
+}
+
+// extractAttachments extracts attachment information from message payload,
+// including attachments nested in multipart parts such as forwarded messages
 func extractAttachments(payload *gmail.MessagePart) []types.Attachment {
 	var attachments []types.Attachment
+	if security := partSecurity(payload); security != nil && security.Encrypted {
+		return nil
+	}
 	
 	for _, part := range payload.Parts {
+		if mailsec.IsSignature(part.MimeType) {
+			continue
+		}
 		if part.Filename != "" && part.Body != nil && part.Body.AttachmentId != "" {
 			attachments = append(attachments, types.Attachment{
 				ID:       part.Body.AttachmentId,
@@ -230,11 +586,44 @@ func extractAttachments(payload *gmail.MessagePart) []types.Attachment {
 				Size:     part.Body.Size,
 			})
 		}
+		attachments = append(attachments, extractAttachments(part)...)
 	}
 	
 	return attachments
 }
 
+// extractSecurity returns the PGP or S/MIME protection of a message
+// payload and the parts nested in it, or nil for an unprotected message
+func extractSecurity(payload *gmail.MessagePart) *types.MessageSecurity {
+	email := &types.Email{}
+	var walk func(part *gmail.MessagePart)
+	walk = func(part *gmail.MessagePart) {
+		security := partSecurity(part)
+		mailsec.Mark(email, security)
+		if security != nil && security.Encrypted {
+			return
+		}
+		for _, child := range part.Parts {
+			walk(child)
+		}
+	}
+	walk(payload)
+	return email.Security
+}
+
+// partSecurity returns the protection a single message part declares in
+// its Content-Type header
+func partSecurity(part *gmail.MessagePart) *types.MessageSecurity {
+	var params map[string]string
+	for _, header := range part.Headers {
+		if strings.EqualFold(header.Name, "Content-Type") {
+			_, params, _ = mime.ParseMediaType(header.Value)
+			break
+		}
+	}
+	return mailsec.FromMediaType(part.MimeType, params)
+}
+
 // ModifyLabels adds or removes labels from an email
 func (c *Client) ModifyLabels(ctx context.Context, messageID string, addLabels, removeLabels []string) error {
 	c.logger.WithFields(logrus.Fields{
@@ -253,11 +642,87 @@ func (c *Client) ModifyLabels(ctx context.Context, messageID string, addLabels,
 		return fmt.Errorf("failed to modify labels: %w", err)
 	}
 	
+	c.auditLabelChanges(ctx, messageID, addLabels, removeLabels)
+	return nil
+}
+
+// auditLabelChanges records each applied label change in the audit log, if
+// one is configured. Audit failures are logged but do not fail the request.
+func (c *Client) auditLabelChanges(ctx context.Context, messageID string, addLabels, removeLabels []string) {
+	if c.audit == nil {
+		return
+	}
+	
+	email := &types.Email{ID: messageID}
+	changes := make([]string, 0, len(addLabels)+len(removeLabels))
+	for _, label := range addLabels {
+		changes = append(changes, "+"+label)
+	}
+	for _, label := range removeLabels {
+		changes = append(changes, "-"+label)
+	}
+	
+	for _, change := range changes {
+		if err := c.audit.LogAction(ctx, email, "modify_labels", change); err != nil {
+			c.logger.WithError(err).WithField("message_id", messageID).Error("Failed to audit label change")
+		}
+	}
+}
+
+// TrashMessage moves an email to the trash, from which it can be recovered
+// for 30 days
+func (c *Client) TrashMessage(ctx context.Context, messageID string) error {
+	c.logger.WithField("message_id", messageID).Info("Moving email to trash")
+	
+	if _, err := c.service.Users.Messages.Trash("me", messageID).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to trash message: %w", err)
+	}
+	
+	c.auditRemoval(ctx, messageID, false)
 	return nil
 }
 
+// DeleteMessage permanently deletes an email, bypassing the trash. It cannot
+// be undone, so it fails with ErrPermanentDeleteDisabled unless
+// gmail.allow_permanent_delete is set.
+func (c *Client) DeleteMessage(ctx context.Context, messageID string) error {
+	if !c.config.AllowPermanentDelete {
+		return fmt.Errorf("failed to delete message %s: %w", messageID, ErrPermanentDeleteDisabled)
+	}
+	
+	c.logger.WithField("message_id", messageID).Warn("Permanently deleting email")
+	
+	if err := c.service.Users.Messages.Delete("me", messageID).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	
+	c.auditRemoval(ctx, messageID, true)
+	return nil
+}
+
+// auditRemoval records a trashed or deleted email in the audit log, if one
+// is configured. Audit failures are logged but do not fail the request.
+func (c *Client) auditRemoval(ctx context.Context, messageID string, permanent bool) {
+	if c.audit == nil {
+		return
+	}
+	
+	if err := c.audit.LogMessageRemoval(ctx, messageID, permanent); err != nil {
+		c.logger.WithError(err).WithField("message_id", messageID).Error("Failed to audit message removal")
+	}
+}
+
 // CreateLabel creates a new Gmail label
 func (c *Client) CreateLabel(ctx context.Context, name string) (*gmail.Label, error) {
+	c.labelMutex.Lock()
+	defer c.labelMutex.Unlock()
+	
+	return c.createLabel(ctx, name)
+}
+
+// createLabel creates a label and invalidates the label cache. The caller
+// must hold labelMutex.
+func (c *Client) createLabel(ctx context.Context, name string) (*gmail.Label, error) {
 	c.logger.WithField("label_name", name).Info("Creating Gmail label")
 	
 	label := &gmail.Label{
@@ -271,6 +736,8 @@ func (c *Client) CreateLabel(ctx context.Context, name string) (*gmail.Label, er
 		return nil, fmt.Errorf("failed to create label: %w", err)
 	}
 	
+	c.labelIDs = nil
+	c.labelNames = nil
 	return createdLabel, nil
 }
 
@@ -284,6 +751,121 @@ func (c *Client) ListLabels(ctx context.Context) ([]*gmail.Label, error) {
 	return response.Labels, nil
 }
 
+// LabelIDForName returns the ID of the label with the given name, creating
+// the label if it does not exist. Names match case-insensitively, as Gmail
+// does not allow two labels differing only in case. Labels are listed once
+// and cached until a label is created.
+func (c *Client) LabelIDForName(ctx context.Context, name string) (string, error) {
+	c.labelMutex.Lock()
+	defer c.labelMutex.Unlock()
+	
+	if err := c.loadLabels(ctx); err != nil {
+		return "", err
+	}
+	
+	if id, exists := c.labelIDs[strings.ToLower(name)]; exists {
+		return id, nil
+	}
+	
+	label, err := c.createLabel(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return label.Id, nil
+}
+
+// LabelNameForID returns the name of the label with the given ID, or the ID
+// itself when no label has it. It shares the label cache of LabelIDForName.
+func (c *Client) LabelNameForID(ctx context.Context, id string) (string, error) {
+	c.labelMutex.Lock()
+	defer c.labelMutex.Unlock()
+	
+	if err := c.loadLabels(ctx); err != nil {
+		return "", err
+	}
+	
+	if name, exists := c.labelNames[id]; exists {
+		return name, nil
+	}
+	return id, nil
+}
+
+// loadLabels lists the labels into the label cache, unless it is already
+// filled. The caller must hold labelMutex.
+func (c *Client) loadLabels(ctx context.Context) error {
+	if c.labelIDs != nil {
+		return nil
+	}
+	
+	labels, err := c.ListLabels(ctx)
+	if err != nil {
+		return err
+	}
+	
+	c.labelIDs = make(map[string]string, len(labels))
+	c.labelNames = make(map[string]string, len(labels))
+	for _, label := range labels {
+		c.labelIDs[strings.ToLower(label.Name)] = label.Id
+		c.labelNames[label.Id] = label.Name
+	}
+	return nil
+}
+
+// EnsureLabels creates the labels among names that do not exist yet and
+// returns the ID of every one of them by name. A nested label such as
+// "Parent/Child" is created after its parent, which is created as well and
+// included in the map. Labels are listed afresh, so EnsureLabels reconciles
+// the label cache with Gmail and is safe to run repeatedly.
+func (c *Client) EnsureLabels(ctx context.Context, names []string) (map[string]string, error) {
+	c.labelMutex.Lock()
+	defer c.labelMutex.Unlock()
+	
+	labels, err := c.ListLabels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]string, len(labels))
+	labelNames := make(map[string]string, len(labels))
+	for _, label := range labels {
+		existing[strings.ToLower(label.Name)] = label.Id
+		labelNames[label.Id] = label.Name
+	}
+	
+	ids := make(map[string]string, len(names))
+	var created int
+	for _, name := range names {
+		name = strings.Trim(name, "/")
+		if name == "" {
+			continue
+		}
+		segments := strings.Split(name, "/")
+		for i := range segments {
+			path := strings.Join(segments[:i+1], "/")
+			if id, exists := existing[strings.ToLower(path)]; exists {
+				ids[path] = id
+				continue
+			}
+			
+			label, err := c.createLabel(ctx, path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to ensure label %q: %w", path, err)
+			}
+			existing[strings.ToLower(path)] = label.Id
+			labelNames[label.Id] = path
+			ids[path] = label.Id
+			created++
+		}
+	}
+	c.labelIDs = existing
+	c.labelNames = labelNames
+	
+	c.logger.WithFields(logrus.Fields{
+		"labels":  len(ids),
+		"created": created,
+	}).Info("Ensured Gmail labels")
+	return ids, nil
+}
+
 // HealthCheck verifies Gmail API connectivity
 func (c *Client) HealthCheck(ctx context.Context) error {
 	_, err := c.service.Users.GetProfile("me").Context(ctx).Do()
@@ -0,0 +1,707 @@
+package gmail
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/replyspoof"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/testutil"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestRefreshNotifyingTokenSource(t *testing.T) {
+	base := &sequenceTokenSource{tokens: []string{"initial", "initial", "refreshed", "refreshed"}}
+	var refreshed []string
+	source := newRefreshNotifyingTokenSource(base, &oauth2.Token{AccessToken: "initial"}, func(token *oauth2.Token) {
+		refreshed = append(refreshed, token.AccessToken)
+	})
+
+	for i := 0; i < len(base.tokens); i++ {
+		_, err := source.Token()
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"refreshed"}, refreshed)
+}
+
+func TestTokenRefreshAudited(t *testing.T) {
+	dir := t.TempDir()
+	client := &Client{logger: testLogger()}
+	client.SetAuditLogger(testAuditLogger(t, dir))
+
+	client.onTokenRefresh(&oauth2.Token{AccessToken: "secret-token", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)})
+
+	contents := readAuditFiles(t, dir)
+	assert.Contains(t, contents, `"event_type":"auth_token_refresh"`)
+	assert.NotContains(t, contents, "secret-token")
+}
+
+func TestModifyLabelsAudited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&gmail.Message{Id: "msg-1"})
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := testClient(t, server.URL)
+	client.SetAuditLogger(testAuditLogger(t, dir))
+
+	require.NoError(t, client.ModifyLabels(context.Background(), "msg-1", []string{"Newsletters"}, []string{"INBOX"}))
+
+	contents := readAuditFiles(t, dir)
+	assert.Contains(t, contents, `"label":"+Newsletters"`)
+	assert.Contains(t, contents, `"label":"-INBOX"`)
+	assert.Equal(t, 2, strings.Count(contents, `"event_type":"action"`))
+}
+
+func TestModifyLabelsWithoutAuditLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&gmail.Message{Id: "msg-1"})
+	}))
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	assert.NoError(t, client.ModifyLabels(context.Background(), "msg-1", []string{"Newsletters"}, nil))
+}
+
+func TestLabelIDForName(t *testing.T) {
+	var mutex sync.Mutex
+	requests := make(map[string]int)
+	labels := []*gmail.Label{{Id: "INBOX", Name: "INBOX"}, {Id: "Label_1", Name: "Newsletters"}}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests[r.Method]++
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(&gmail.ListLabelsResponse{Labels: labels})
+		case http.MethodPost:
+			var label gmail.Label
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&label))
+			label.Id = fmt.Sprintf("Label_%d", len(labels))
+			labels = append(labels, &label)
+			json.NewEncoder(w).Encode(&label)
+		}
+	}))
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	ctx := context.Background()
+
+	id, err := client.LabelIDForName(ctx, "Newsletters")
+	require.NoError(t, err)
+	assert.Equal(t, "Label_1", id)
+
+	id, err = client.LabelIDForName(ctx, "inbox")
+	require.NoError(t, err)
+	assert.Equal(t, "INBOX", id)
+	assert.Equal(t, 1, requests[http.MethodGet], "labels are listed once")
+
+	// A missing label is created, which invalidates the cache
+	id, err = client.LabelIDForName(ctx, "Receipts")
+	require.NoError(t, err)
+	assert.Equal(t, "Label_2", id)
+	assert.Equal(t, 1, requests[http.MethodPost])
+
+	id, err = client.LabelIDForName(ctx, "Receipts")
+	require.NoError(t, err)
+	assert.Equal(t, "Label_2", id)
+	assert.Equal(t, 2, requests[http.MethodGet], "labels are listed again after a create")
+	assert.Equal(t, 1, requests[http.MethodPost])
+}
+
+func TestLabelIDForNameListError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	_, err := testClient(t, server.URL).LabelIDForName(context.Background(), "Newsletters")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to list labels")
+}
+
+func TestLabelNameForID(t *testing.T) {
+	var mutex sync.Mutex
+	lists := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		lists++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&gmail.ListLabelsResponse{Labels: []*gmail.Label{{Id: "Label_1", Name: "Newsletters"}}})
+	}))
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	ctx := context.Background()
+
+	name, err := client.LabelNameForID(ctx, "Label_1")
+	require.NoError(t, err)
+	assert.Equal(t, "Newsletters", name)
+
+	name, err = client.LabelNameForID(ctx, "Label_9")
+	require.NoError(t, err)
+	assert.Equal(t, "Label_9", name, "an unknown ID is kept")
+
+	id, err := client.LabelIDForName(ctx, "newsletters")
+	require.NoError(t, err)
+	assert.Equal(t, "Label_1", id)
+	assert.Equal(t, 1, lists, "both lookups share the label cache")
+}
+
+func TestListEmailsFromMockServer(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	server := testData.MockGmailServer(t)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{"recorded list", "", []string{"test-email-001", "test-email-002", "test-email-003"}},
+		{"sender", "from:company.com", []string{"test-email-003"}},
+		{"free text", "suspended", []string{"test-email-001"}},
+		{"label", "label:important", []string{"test-email-005"}},
+		{"combined terms", "is:unread subject:your -label:newsletter", []string{"test-email-001", "test-email-004", "test-email-006"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emails, err := client.ListEmails(ctx, tt.query, 0)
+			require.NoError(t, err)
+
+			var ids []string
+			for _, email := range emails {
+				expected := testData.GetTestEmail(email.ID)
+				require.NotNil(t, expected)
+				assert.Equal(t, expected.Subject, email.Subject)
+				ids = append(ids, email.ID)
+			}
+			assert.Equal(t, tt.expected, ids)
+		})
+	}
+
+	_, err := client.GetEmail(ctx, "missing-email")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+}
+
+func TestStreamEmailsAcrossPages(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	mock := testData.MockGmailServer(t)
+	defer mock.Close()
+
+	var mutex sync.Mutex
+	var pageTokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/messages") {
+			mutex.Lock()
+			pageTokens = append(pageTokens, r.URL.Query().Get("pageToken"))
+			mutex.Unlock()
+		}
+		mock.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	client.config.BatchSize = 5
+
+	emails, errs := client.StreamEmails(context.Background(), "label:inbox")
+	var ids []string
+	for email := range emails {
+		expected := testData.GetTestEmail(email.ID)
+		require.NotNil(t, expected)
+		assert.Equal(t, expected.Subject, email.Subject)
+		ids = append(ids, email.ID)
+	}
+	require.NoError(t, <-errs)
+
+	var expected []string
+	for _, email := range testData.Emails {
+		expected = append(expected, email.ID)
+	}
+	assert.Equal(t, expected, ids)
+	assert.Equal(t, []string{"", "5", "10"}, pageTokens)
+}
+
+func TestListPreviewsMakesNoMessageRequests(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	mock := testData.MockGmailServer(t)
+	defer mock.Close()
+
+	var mutex sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		paths = append(paths, r.URL.Path)
+		mutex.Unlock()
+		mock.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	emails, err := client.ListPreviews(context.Background(), "is:unread subject:your -label:newsletter", 0)
+	require.NoError(t, err)
+
+	var ids []string
+	for _, email := range emails {
+		expected := testData.GetTestEmail(email.ID)
+		require.NotNil(t, expected)
+		assert.True(t, email.Preview)
+		assert.Equal(t, email.ID, email.ThreadID)
+		assert.Equal(t, expected.Snippet, email.Snippet)
+		assert.Empty(t, email.Body)
+		ids = append(ids, email.ID)
+	}
+	assert.Equal(t, []string{"test-email-001", "test-email-004", "test-email-006"}, ids)
+	assert.Equal(t, []string{"/gmail/v1/users/me/threads", "/gmail/v1/users/me/messages"}, paths, "previews are listed without fetching any message")
+}
+
+func TestListPreviewsTakeTheLatestMessageID(t *testing.T) {
+	var pageTokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/gmail/v1/users/me/threads":
+			json.NewEncoder(w).Encode(&gmail.ListThreadsResponse{Threads: []*gmail.Thread{
+				{Id: "t1", Snippet: "Re: the invoice &amp; the receipt"},
+				{Id: "t2", Snippet: "Lunch?"},
+			}})
+		case "/gmail/v1/users/me/messages":
+			// Messages are listed newest first, a page at a time
+			pageTokens = append(pageTokens, r.URL.Query().Get("pageToken"))
+			switch r.URL.Query().Get("pageToken") {
+			case "":
+				json.NewEncoder(w).Encode(&gmail.ListMessagesResponse{
+					Messages:      []*gmail.Message{{Id: "m3", ThreadId: "t1"}, {Id: "m1", ThreadId: "t1"}},
+					NextPageToken: "2",
+				})
+			case "2":
+				json.NewEncoder(w).Encode(&gmail.ListMessagesResponse{
+					Messages:      []*gmail.Message{{Id: "m2", ThreadId: "t2"}},
+					NextPageToken: "3",
+				})
+			default:
+				t.Errorf("listed past the page finding every thread")
+			}
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	emails, err := client.ListPreviews(context.Background(), "in:inbox", 10)
+	require.NoError(t, err)
+	require.Len(t, emails, 2)
+	assert.Equal(t, "m3", emails[0].ID, "a preview stands for its thread's latest message")
+	assert.Equal(t, "t1", emails[0].ThreadID)
+	assert.Equal(t, "Re: the invoice & the receipt", emails[0].Snippet)
+	assert.Equal(t, "m2", emails[1].ID)
+	assert.Equal(t, []string{"", "2"}, pageTokens)
+}
+
+func TestStreamEmailsCancelled(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	server := testData.MockGmailServer(t)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	client.config.BatchSize = 5
+
+	ctx, cancel := context.WithCancel(context.Background())
+	emails, errs := client.StreamEmails(ctx, "label:inbox")
+	first, ok := <-emails
+	require.True(t, ok)
+	assert.Equal(t, "test-email-001", first.ID)
+
+	// The stream stops without the rest of the emails being received
+	cancel()
+	for range emails {
+	}
+	err := <-errs
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestStreamEmailsListError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "backend error", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	emails, errs := testClient(t, server.URL).StreamEmails(context.Background(), "label:inbox")
+	_, ok := <-emails
+	assert.False(t, ok)
+	err := <-errs
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to stream messages")
+}
+
+func TestStreamBatches(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	server := testData.MockGmailServer(t)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	client.config.BatchSize = 5
+
+	var sizes []int
+	err := client.StreamBatches(context.Background(), "label:inbox", func(ctx context.Context, emails []*types.Email) error {
+		sizes = append(sizes, len(emails))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{5, 5, 4}, sizes)
+
+	calls := 0
+	err = client.StreamBatches(context.Background(), "label:inbox", func(ctx context.Context, emails []*types.Email) error {
+		calls++
+		return fmt.Errorf("classifier unavailable")
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "classifier unavailable")
+	assert.Equal(t, 1, calls, "a failed batch stops the stream")
+}
+
+func TestGetEmailParsesAuthResults(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	server := testData.MockGmailServer(t)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+
+	tests := []struct {
+		messageID string
+		expected  types.AuthResults
+	}{
+		{"auth-pass-001", types.AuthResults{SPF: types.AuthPass, DKIM: types.AuthPass, DMARC: types.AuthPass}},
+		// The forged results below Gmail's are ignored
+		{"auth-fail-001", types.AuthResults{SPF: "softfail", DKIM: types.AuthNone, DMARC: types.AuthFail}},
+		{"test-email-001", types.AuthResults{SPF: types.AuthNone, DKIM: types.AuthNone, DMARC: types.AuthNone}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.messageID, func(t *testing.T) {
+			email, err := client.GetEmail(context.Background(), tt.messageID)
+			require.NoError(t, err)
+			require.NotNil(t, email.Auth)
+			assert.Equal(t, tt.expected, *email.Auth)
+		})
+	}
+}
+
+func TestGetEmailParsesRecipients(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	server := testData.MockGmailServer(t)
+	defer server.Close()
+
+	email, err := testClient(t, server.URL).GetEmail(context.Background(), "recipients-001")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`"Doe, John" <john.doe@example.com>`,
+		"alice@example.com",
+		"Bob Stone <bob@example.com>",
+		"jane@example.com",
+	}, email.To, "display names keep their commas and groups list their members")
+	assert.Equal(t, []string{`"Smith, Ann (Finance)" <ann.smith@example.com>`}, email.CC)
+}
+
+func TestGetEmailParsesThreadingHeaders(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	server := testData.MockGmailServer(t)
+	defer server.Close()
+	client := testClient(t, server.URL)
+
+	reply, err := client.GetEmail(context.Background(), "reply-genuine-001")
+	require.NoError(t, err)
+	assert.Equal(t, "reply-2@vendor.example", reply.MessageID)
+	assert.Equal(t, "paid-1@company.example", reply.InReplyTo)
+	assert.Equal(t, []string{"invoice-1@vendor.example", "paid-1@company.example"}, reply.References)
+	assert.False(t, replyspoof.Analyze(reply).Spoofed)
+
+	// The spoofed reply references a message Gmail does not have, so it
+	// starts a thread of its own
+	spoofed, err := client.GetEmail(context.Background(), "reply-spoofed-001")
+	require.NoError(t, err)
+	assert.Equal(t, "wire-1@company.example", spoofed.InReplyTo)
+	assert.Equal(t, spoofed.ID, spoofed.ThreadID)
+	analysis := replyspoof.Analyze(spoofed)
+	assert.True(t, analysis.Spoofed)
+	assert.Contains(t, analysis.Reasons, types.ReplyNoThread)
+}
+
+func TestTrashMessage(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	server := testData.MockGmailServer(t)
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := testClient(t, server.URL)
+	client.SetAuditLogger(testAuditLogger(t, dir))
+
+	require.NoError(t, client.TrashMessage(context.Background(), "test-email-008"))
+	assert.ErrorContains(t, client.TrashMessage(context.Background(), "missing-email"), "404")
+
+	contents := readAuditFiles(t, dir)
+	assert.Equal(t, 1, strings.Count(contents, `"event_type":"message_trashed"`))
+	assert.Contains(t, contents, `"email_id":"test-email-008"`)
+	assert.NotContains(t, contents, `"event_type":"message_deleted"`)
+}
+
+func TestDeleteMessage(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	server := testData.MockGmailServer(t)
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := testClient(t, server.URL)
+	client.SetAuditLogger(testAuditLogger(t, dir))
+
+	err := client.DeleteMessage(context.Background(), "test-email-008")
+	assert.ErrorIs(t, err, ErrPermanentDeleteDisabled, "permanent delete requires the opt-in")
+	assert.NotContains(t, readAuditFiles(t, dir), `"event_type":"message_deleted"`)
+
+	client.config.AllowPermanentDelete = true
+	require.NoError(t, client.DeleteMessage(context.Background(), "test-email-008"))
+	assert.ErrorContains(t, client.DeleteMessage(context.Background(), "missing-email"), "404")
+
+	contents := readAuditFiles(t, dir)
+	assert.Equal(t, 1, strings.Count(contents, `"event_type":"message_deleted"`))
+	assert.Contains(t, contents, `"permanent":true`)
+	assert.NotContains(t, contents, `"event_type":"message_trashed"`)
+}
+
+func TestGetEmailExtractsAttachments(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	server := testData.MockGmailServer(t)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	for _, id := range []string{"test-email-009", "test-email-010"} {
+		email, err := client.GetEmail(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, testData.GetTestEmail(id).Attachments, email.Attachments)
+	}
+}
+
+func TestExtractAttachmentsFromNestedParts(t *testing.T) {
+	payload := &gmail.MessagePart{
+		MimeType: "multipart/mixed",
+		Parts: []*gmail.MessagePart{
+			{MimeType: "text/plain", Body: &gmail.MessagePartBody{Data: "c2Vl"}},
+			{MimeType: "application/pdf", Filename: "invoice.pdf", Body: &gmail.MessagePartBody{AttachmentId: "att-1", Size: 2048}},
+			{MimeType: "message/rfc822", Parts: []*gmail.MessagePart{
+				{MimeType: "application/x-msdownload", Filename: "update.exe", Body: &gmail.MessagePartBody{AttachmentId: "att-2", Size: 4096}},
+			}},
+		},
+	}
+
+	assert.Equal(t, []types.Attachment{
+		{ID: "att-1", Filename: "invoice.pdf", MimeType: "application/pdf", Size: 2048},
+		{ID: "att-2", Filename: "update.exe", MimeType: "application/x-msdownload", Size: 4096},
+	}, extractAttachments(payload))
+}
+
+func TestExtractSignedAndEncryptedParts(t *testing.T) {
+	signed := &gmail.MessagePart{
+		MimeType: "multipart/signed",
+		Headers:  []*gmail.MessagePartHeader{{Name: "Content-Type", Value: `multipart/signed; protocol="application/pgp-signature"; boundary=b`}},
+		Parts: []*gmail.MessagePart{
+			{MimeType: "multipart/alternative", Parts: []*gmail.MessagePart{
+				{MimeType: "text/plain", Body: &gmail.MessagePartBody{Data: "c2Vl"}},
+			}},
+			{MimeType: "application/pgp-signature", Filename: "signature.asc", Body: &gmail.MessagePartBody{AttachmentId: "att-1", Size: 228}},
+		},
+	}
+	assert.Equal(t, &types.MessageSecurity{Signed: true, Protocol: types.SecurityPGP}, extractSecurity(signed))
+	assert.Equal(t, "c2Vl", extractBody(signed), "the signed payload is the body")
+	assert.Empty(t, extractAttachments(signed), "the signature is not an attachment")
+
+	encrypted := &gmail.MessagePart{
+		MimeType: "multipart/encrypted",
+		Headers:  []*gmail.MessagePartHeader{{Name: "Content-Type", Value: `multipart/encrypted; protocol="application/pgp-encrypted"; boundary=b`}},
+		Parts: []*gmail.MessagePart{
+			{MimeType: "application/pgp-encrypted", Body: &gmail.MessagePartBody{Data: "VmVyc2lvbjogMQ"}},
+			{MimeType: "application/octet-stream", Filename: "encrypted.asc", Body: &gmail.MessagePartBody{AttachmentId: "att-2", Size: 1024}},
+		},
+	}
+	assert.Equal(t, &types.MessageSecurity{Encrypted: true, Protocol: types.SecurityPGP}, extractSecurity(encrypted))
+	assert.Empty(t, extractAttachments(encrypted))
+
+	assert.Nil(t, extractSecurity(&gmail.MessagePart{MimeType: "text/plain"}))
+}
+
+func TestMessageToEmailRecognizesInlinePGP(t *testing.T) {
+	message := func(body string) *gmail.Message {
+		return &gmail.Message{Id: "msg-1", Payload: &gmail.MessagePart{
+			MimeType: "text/plain",
+			Body:     &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString([]byte(body))},
+		}}
+	}
+
+	signed := messageToEmail(message("-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA512\n\nShip it.\n-----BEGIN PGP SIGNATURE-----\n\niHUE\n-----END PGP SIGNATURE-----\n"))
+	assert.Equal(t, &types.MessageSecurity{Signed: true, Protocol: types.SecurityPGP}, signed.Security)
+	assert.Equal(t, "Ship it.", strings.TrimSpace(signed.Body), "the clear-signed text is the body")
+
+	encrypted := messageToEmail(message("Sent from my phone\n-----BEGIN PGP MESSAGE-----\n\nhF4DdGhp\n-----END PGP MESSAGE-----\n"))
+	assert.True(t, encrypted.IsEncrypted())
+	assert.NotContains(t, encrypted.Body, "hF4DdGhp", "encrypted blocks are removed")
+
+	plain := messageToEmail(message("Lunch at noon?"))
+	assert.Nil(t, plain.Security)
+	assert.Equal(t, "Lunch at noon?", plain.Body)
+}
+
+func TestEnsureLabels(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	server := testData.MockGmailServer(t)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	names := []string{"INBOX", "newsletter", "Receipts/2026", "Receipts/Travel"}
+
+	ids, err := client.EnsureLabels(context.Background(), names)
+	require.NoError(t, err)
+	assert.Equal(t, "INBOX", ids["INBOX"])
+	assert.Equal(t, "Label_2", ids["newsletter"], "existing labels match ignoring case")
+	assert.Equal(t, "Label_created_1", ids["Receipts"], "the parent is created first")
+	assert.Equal(t, "Label_created_2", ids["Receipts/2026"])
+	assert.Equal(t, "Label_created_3", ids["Receipts/Travel"])
+
+	again, err := client.EnsureLabels(context.Background(), names)
+	require.NoError(t, err)
+	assert.Equal(t, ids, again, "no label is created twice")
+
+	id, err := client.LabelIDForName(context.Background(), "receipts/travel")
+	require.NoError(t, err)
+	assert.Equal(t, "Label_created_3", id, "the label cache knows the created labels")
+}
+
+func TestEnsureLabelsCreateError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error": {"code": 403, "message": "Insufficient Permission"}}`)
+			return
+		}
+		json.NewEncoder(w).Encode(&gmail.ListLabelsResponse{})
+	}))
+	defer server.Close()
+
+	_, err := testClient(t, server.URL).EnsureLabels(context.Background(), []string{"Receipts"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `failed to ensure label "Receipts"`)
+}
+
+func TestGetThread(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/gmail/v1/users/me/threads/thread-1" {
+			http.NotFound(w, r)
+			return
+		}
+		assert.Equal(t, "full", r.URL.Query().Get("format"))
+		message := func(id, subject string) *gmail.Message {
+			return &gmail.Message{Id: id, ThreadId: "thread-1", Payload: &gmail.MessagePart{
+				MimeType: "text/plain",
+				Headers:  []*gmail.MessagePartHeader{{Name: "Subject", Value: subject}},
+				Body:     &gmail.MessagePartBody{Data: "Ym9keQ"},
+			}}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&gmail.Thread{Id: "thread-1", Messages: []*gmail.Message{
+			message("msg-1", "Invoice"),
+			message("msg-2", "Re: Invoice"),
+		}})
+	}))
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	emails, err := client.GetThread(context.Background(), "thread-1")
+	require.NoError(t, err)
+	require.Len(t, emails, 2)
+	assert.Equal(t, "msg-1", emails[0].ID)
+	assert.Equal(t, "Re: Invoice", emails[1].Subject)
+	assert.Equal(t, "thread-1", emails[1].ThreadID)
+
+	_, err = client.GetThread(context.Background(), "missing-thread")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to get thread")
+}
+
+// Helper functions
+
+// sequenceTokenSource hands out the configured access tokens in order
+type sequenceTokenSource struct {
+	tokens []string
+	next   int
+}
+
+func (s *sequenceTokenSource) Token() (*oauth2.Token, error) {
+	token := &oauth2.Token{AccessToken: s.tokens[s.next]}
+	s.next++
+	return token, nil
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
+
+func testClient(t *testing.T, endpoint string) *Client {
+	service, err := gmail.NewService(context.Background(), option.WithHTTPClient(http.DefaultClient), option.WithEndpoint(endpoint))
+	require.NoError(t, err)
+	return &Client{service: service, config: &config.GmailConfig{}, logger: testLogger()}
+}
+
+func testAuditLogger(t *testing.T, dir string) *audit.Logger {
+	auditLogger, err := audit.NewLogger(&config.AuditConfig{Enabled: true, Directory: dir}, testLogger())
+	require.NoError(t, err)
+	t.Cleanup(func() { auditLogger.Close() })
+	return auditLogger
+}
+
+func readAuditFiles(t *testing.T, dir string) string {
+	paths, err := filepath.Glob(filepath.Join(dir, "audit_*.log"))
+	require.NoError(t, err)
+
+	var contents strings.Builder
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		contents.Write(data)
+	}
+	return contents.String()
+}
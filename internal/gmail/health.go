@@ -0,0 +1,68 @@
+package gmail
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// quotaReasons are the Gmail API error reasons that mean a quota or rate
+// limit has been reached rather than a real failure
+var quotaReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+	"quotaExceeded":         true,
+	"dailyLimitExceeded":    true,
+}
+
+// HealthStatus describes the client's ability to reach the Gmail API
+type HealthStatus struct {
+	State          types.HealthState `json:"status"`
+	QuotaExhausted bool              `json:"quota_exhausted"`
+	CheckedAt      time.Time         `json:"checked_at"`
+	Error          string            `json:"error,omitempty"`
+}
+
+// HealthState returns the overall health state
+func (s HealthStatus) HealthState() types.HealthState {
+	return s.State
+}
+
+// Status probes the Gmail API. Quota and rate limit errors are reported as
+// degraded, since they clear on their own; any other failure is unhealthy.
+func (c *Client) Status(ctx context.Context) HealthStatus {
+	status := HealthStatus{State: types.HealthHealthy, CheckedAt: time.Now()}
+
+	if err := c.HealthCheck(ctx); err != nil {
+		status.Error = err.Error()
+		status.State = types.HealthUnhealthy
+		if isQuotaError(err) {
+			status.State = types.HealthDegraded
+			status.QuotaExhausted = true
+		}
+	}
+	return status
+}
+
+// isQuotaError reports whether err is Gmail rejecting a request for quota
+// or rate limit reasons
+func isQuotaError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	for _, item := range apiErr.Errors {
+		if quotaReasons[item.Reason] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package gmail
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		expected types.HealthState
+		quota    bool
+	}{
+		{"healthy", http.StatusOK, `{"emailAddress": "user@example.com"}`, types.HealthHealthy, false},
+		{"rate limited", http.StatusTooManyRequests, `{"error": {"code": 429, "message": "Too many requests"}}`, types.HealthDegraded, true},
+		{"quota exceeded", http.StatusForbidden, `{"error": {"code": 403, "message": "Quota exceeded", "errors": [{"reason": "userRateLimitExceeded"}]}}`, types.HealthDegraded, true},
+		{"forbidden", http.StatusForbidden, `{"error": {"code": 403, "message": "Insufficient permissions", "errors": [{"reason": "insufficientPermissions"}]}}`, types.HealthUnhealthy, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			status := testClient(t, server.URL).Status(context.Background())
+			assert.Equal(t, tt.expected, status.HealthState())
+			assert.Equal(t, tt.quota, status.QuotaExhausted)
+			assert.Equal(t, tt.expected != types.HealthHealthy, status.Error != "")
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package gmail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// History types requested from users.history.list
+const (
+	historyMessageAdded = "messageAdded"
+	historyLabelAdded   = "labelAdded"
+)
+
+// SyncState records the mailbox position reached by the last sync
+type SyncState struct {
+	HistoryID uint64    `json:"history_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SyncEmails returns the emails that arrived since the previous sync, using
+// the Gmail history API and the history ID stored in the configured sync
+// state file. Messages added to the mailbox and messages moved into the
+// inbox are returned; other label changes, including the ones MailSentinel
+// applies itself, are not, so repeated syncs do not reclassify handled mail.
+//
+// The first sync, and any sync whose stored history ID Gmail has expired,
+// falls back to a full ListEmails with query and maxResults. The query is
+// not applied to incremental results. Without a sync state file every call
+// is a full sync.
+func (c *Client) SyncEmails(ctx context.Context, query string, maxResults int64) ([]*types.Email, error) {
+	var synced []*types.Email
+	err := c.syncEmails(ctx, query, maxResults, func(ctx context.Context, emails []*types.Email) error {
+		synced = emails
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return synced, nil
+}
+
+// syncEmails fetches the emails since the previous sync and passes them to
+// process. The stored history ID only advances once process succeeds, so a
+// failed batch is fetched again by the next sync.
+func (c *Client) syncEmails(ctx context.Context, query string, maxResults int64, process EmailProcessor) error {
+	c.syncMutex.Lock()
+	defer c.syncMutex.Unlock()
+
+	state, err := loadSyncState(c.config.SyncStateFile)
+	if err != nil {
+		return err
+	}
+
+	var emails []*types.Email
+	var historyID uint64
+	if state.HistoryID != 0 {
+		emails, historyID, err = c.emailsSince(ctx, state.HistoryID)
+		if isHistoryExpired(err) {
+			logging.FromContext(ctx, c.logger).WithField("history_id", state.HistoryID).Warn("Gmail history ID expired, falling back to a full sync")
+			state.HistoryID = 0
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if state.HistoryID == 0 {
+		emails, historyID, err = c.fullSync(ctx, query, maxResults)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := process(ctx, emails); err != nil {
+		return fmt.Errorf("failed to process synced emails: %w", err)
+	}
+
+	if err := saveSyncState(c.config.SyncStateFile, &SyncState{HistoryID: historyID, UpdatedAt: time.Now()}); err != nil {
+		return err
+	}
+
+	logging.FromContext(ctx, c.logger).WithFields(logrus.Fields{
+		"history_id":  historyID,
+		"email_count": len(emails),
+	}).Info("Synced emails from Gmail")
+
+	return nil
+}
+
+// fullSync lists emails matching query and returns the history ID to resume
+// from. The ID is read before listing so that mail arriving during the list
+// is picked up by the next incremental sync.
+func (c *Client) fullSync(ctx context.Context, query string, maxResults int64) ([]*types.Email, uint64, error) {
+	profile, err := c.service.Users.GetProfile("me").Context(ctx).Do()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get mailbox history ID: %w", err)
+	}
+
+	emails, err := c.ListEmails(ctx, query, maxResults)
+	if err != nil {
+		return nil, 0, err
+	}
+	return emails, profile.HistoryId, nil
+}
+
+// emailsSince fetches the messages added or moved into the inbox after
+// startHistoryID, along with the latest history ID. Messages deleted since
+// are skipped; failing to fetch any other fails the sync, so that the
+// history ID is not advanced past it.
+func (c *Client) emailsSince(ctx context.Context, startHistoryID uint64) ([]*types.Email, uint64, error) {
+	var messageIDs []string
+	seen := make(map[string]bool)
+	add := func(message *gmail.Message) {
+		if message == nil || seen[message.Id] || hasLabel(message.LabelIds, "DRAFT") {
+			return
+		}
+		seen[message.Id] = true
+		messageIDs = append(messageIDs, message.Id)
+	}
+
+	historyID := startHistoryID
+	call := c.service.Users.History.List("me").
+		StartHistoryId(startHistoryID).
+		HistoryTypes(historyMessageAdded, historyLabelAdded)
+	err := call.Pages(ctx, func(response *gmail.ListHistoryResponse) error {
+		for _, history := range response.History {
+			for _, added := range history.MessagesAdded {
+				add(added.Message)
+			}
+			for _, labeled := range history.LabelsAdded {
+				if hasLabel(labeled.LabelIds, "INBOX") {
+					add(labeled.Message)
+				}
+			}
+		}
+		if response.HistoryId > historyID {
+			historyID = response.HistoryId
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list history: %w", err)
+	}
+
+	emails := make([]*types.Email, 0, len(messageIDs))
+	for _, messageID := range messageIDs {
+		email, err := c.GetEmail(ctx, messageID)
+		if isNotFound(err) {
+			// Messages deleted since the history record are expected here
+			logging.FromContext(ctx, c.logger).WithError(err).WithField("message_id", messageID).Warn("Skipping deleted email")
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		emails = append(emails, email)
+	}
+
+	return emails, historyID, nil
+}
+
+// isHistoryExpired reports whether err is Gmail rejecting a start history ID
+// that is too old
+func isHistoryExpired(err error) bool {
+	return isNotFound(err)
+}
+
+// isNotFound reports whether err is Gmail reporting that the requested
+// entity does not exist
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// hasLabel reports whether labels contains label
+func hasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// loadSyncState reads the sync state, returning an empty state when the
+// file does not exist yet
+func loadSyncState(path string) (*SyncState, error) {
+	state := &SyncState{}
+	if path == "" {
+		return state, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync state: %w", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse sync state %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// saveSyncState atomically replaces the sync state file
+func saveSyncState(path string, state *SyncState) error {
+	if path == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create sync state directory: %w", err)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sync state: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write sync state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace sync state: %w", err)
+	}
+	return nil
+}
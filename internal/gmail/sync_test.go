@@ -0,0 +1,215 @@
+package gmail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestSyncEmails(t *testing.T) {
+	mailbox := newFakeMailbox()
+	server := httptest.NewServer(mailbox)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	client.config.SyncStateFile = filepath.Join(t.TempDir(), "sync.json")
+	ctx := context.Background()
+
+	// The first sync lists the mailbox and records the current history ID
+	emails, err := client.SyncEmails(ctx, "in:inbox", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"m1"}, emailIDs(emails))
+	assert.Equal(t, uint64(100), readSyncState(t, client.config.SyncStateFile).HistoryID)
+	assert.Equal(t, 0, mailbox.historyCalls)
+
+	// Later syncs return only new and re-inboxed messages
+	emails, err = client.SyncEmails(ctx, "in:inbox", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"m2", "m3"}, emailIDs(emails))
+	assert.Equal(t, uint64(120), readSyncState(t, client.config.SyncStateFile).HistoryID)
+	assert.Equal(t, "100", mailbox.lastStartHistoryID)
+	assert.Equal(t, 1, mailbox.listCalls)
+}
+
+func TestSyncEmailsExpiredHistory(t *testing.T) {
+	mailbox := newFakeMailbox()
+	server := httptest.NewServer(mailbox)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	client.config.SyncStateFile = filepath.Join(t.TempDir(), "sync.json")
+	require.NoError(t, saveSyncState(client.config.SyncStateFile, &SyncState{HistoryID: 10}))
+
+	emails, err := client.SyncEmails(context.Background(), "in:inbox", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"m1"}, emailIDs(emails), "falls back to a full list")
+	assert.Equal(t, 1, mailbox.historyCalls)
+	assert.Equal(t, 1, mailbox.listCalls)
+	assert.Equal(t, uint64(100), readSyncState(t, client.config.SyncStateFile).HistoryID)
+}
+
+func TestSyncEmailsHistoryError(t *testing.T) {
+	mailbox := newFakeMailbox()
+	mailbox.historyStatus = http.StatusForbidden
+	server := httptest.NewServer(mailbox)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	client.config.SyncStateFile = filepath.Join(t.TempDir(), "sync.json")
+	require.NoError(t, saveSyncState(client.config.SyncStateFile, &SyncState{HistoryID: 100}))
+
+	_, err := client.SyncEmails(context.Background(), "in:inbox", 10)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to list history")
+	assert.Equal(t, 0, mailbox.listCalls, "only expired history IDs fall back")
+	assert.Equal(t, uint64(100), readSyncState(t, client.config.SyncStateFile).HistoryID)
+}
+
+func TestSyncEmailsSkipsDeletedMessages(t *testing.T) {
+	mailbox := newFakeMailbox()
+	mailbox.messageStatus = map[string]int{"m2": http.StatusNotFound}
+	server := httptest.NewServer(mailbox)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	client.config.SyncStateFile = filepath.Join(t.TempDir(), "sync.json")
+	require.NoError(t, saveSyncState(client.config.SyncStateFile, &SyncState{HistoryID: 100}))
+
+	emails, err := client.SyncEmails(context.Background(), "in:inbox", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"m3"}, emailIDs(emails))
+	assert.Equal(t, uint64(120), readSyncState(t, client.config.SyncStateFile).HistoryID)
+}
+
+func TestSyncEmailsMessageError(t *testing.T) {
+	mailbox := newFakeMailbox()
+	mailbox.messageStatus = map[string]int{"m2": http.StatusForbidden}
+	server := httptest.NewServer(mailbox)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	client.config.SyncStateFile = filepath.Join(t.TempDir(), "sync.json")
+	require.NoError(t, saveSyncState(client.config.SyncStateFile, &SyncState{HistoryID: 100}))
+
+	_, err := client.SyncEmails(context.Background(), "in:inbox", 10)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to get message")
+	assert.Equal(t, uint64(100), readSyncState(t, client.config.SyncStateFile).HistoryID, "the sync is retried from the same history ID")
+}
+
+// Helper functions
+
+// fakeMailbox serves the Gmail endpoints used by SyncEmails and Watch. The
+// mailbox is at history ID 100 with message m1; history after it adds m2,
+// moves m3 into the inbox and labels m1, reaching history ID 120. History
+// IDs below 50 have expired. Messages in messageStatus fail with their
+// status. Watches last a week.
+type fakeMailbox struct {
+	mutex              sync.Mutex
+	historyStatus      int
+	messageStatus      map[string]int
+	historyCalls       int
+	listCalls          int
+	lastStartHistoryID string
+	watchRequests      []*gmail.WatchRequest
+	stopCalls          int
+}
+
+func newFakeMailbox() *fakeMailbox {
+	return &fakeMailbox{historyStatus: http.StatusOK}
+}
+
+func (f *fakeMailbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/me/")
+
+	switch {
+	case path == "watch":
+		var request gmail.WatchRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		f.watchRequests = append(f.watchRequests, &request)
+		json.NewEncoder(w).Encode(&gmail.WatchResponse{
+			HistoryId:  100,
+			Expiration: time.Now().Add(7 * 24 * time.Hour).UnixMilli(),
+		})
+	case path == "stop":
+		f.stopCalls++
+		w.WriteHeader(http.StatusNoContent)
+	case path == "profile":
+		json.NewEncoder(w).Encode(&gmail.Profile{HistoryId: 100})
+	case path == "messages":
+		f.listCalls++
+		json.NewEncoder(w).Encode(&gmail.ListMessagesResponse{Messages: []*gmail.Message{{Id: "m1"}}})
+	case strings.HasPrefix(path, "messages/"):
+		id := strings.TrimPrefix(path, "messages/")
+		if status, failed := f.messageStatus[id]; failed {
+			http.Error(w, fmt.Sprintf(`{"error": {"code": %d, "message": %q}}`, status, http.StatusText(status)), status)
+			return
+		}
+		json.NewEncoder(w).Encode(&gmail.Message{Id: id, Payload: &gmail.MessagePart{}})
+	case path == "history":
+		f.historyCalls++
+		f.lastStartHistoryID = r.URL.Query().Get("startHistoryId")
+		if start, _ := strconv.Atoi(f.lastStartHistoryID); start < 50 {
+			http.Error(w, `{"error": {"code": 404, "message": "Requested entity was not found."}}`, http.StatusNotFound)
+			return
+		}
+		if f.historyStatus != http.StatusOK {
+			http.Error(w, `{"error": {"code": 403, "message": "Forbidden"}}`, f.historyStatus)
+			return
+		}
+		json.NewEncoder(w).Encode(&gmail.ListHistoryResponse{
+			HistoryId: 120,
+			History: []*gmail.History{
+				{Id: 110, MessagesAdded: []*gmail.HistoryMessageAdded{{Message: &gmail.Message{Id: "m2", LabelIds: []string{"INBOX"}}}}},
+				{Id: 111, MessagesAdded: []*gmail.HistoryMessageAdded{{Message: &gmail.Message{Id: "d1", LabelIds: []string{"DRAFT"}}}}},
+				{Id: 115, LabelsAdded: []*gmail.HistoryLabelAdded{{LabelIds: []string{"INBOX"}, Message: &gmail.Message{Id: "m3"}}}},
+				{Id: 118, LabelsAdded: []*gmail.HistoryLabelAdded{{LabelIds: []string{"Label_1"}, Message: &gmail.Message{Id: "m1"}}}},
+				{Id: 119, MessagesAdded: []*gmail.HistoryMessageAdded{{Message: &gmail.Message{Id: "m2", LabelIds: []string{"INBOX"}}}}},
+			},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeMailbox) watchCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.watchRequests)
+}
+
+func emailIDs(emails []*types.Email) []string {
+	ids := make([]string, 0, len(emails))
+	for _, email := range emails {
+		ids = append(ids, email.ID)
+	}
+	return ids
+}
+
+func readSyncState(t *testing.T, path string) *SyncState {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var state SyncState
+	require.NoError(t, json.Unmarshal(data, &state))
+	return &state
+}
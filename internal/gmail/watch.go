@@ -0,0 +1,256 @@
+package gmail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// EmailProcessor handles the emails fetched by a sync, typically by
+// classifying them
+type EmailProcessor func(ctx context.Context, emails []*types.Email) error
+
+// watchRenewInterval is how often an active watch is renewed. Gmail expires
+// watches after seven days and recommends renewing them daily.
+var watchRenewInterval = 24 * time.Hour
+
+// watchRetryInterval is how soon a failed renewal is retried
+var watchRetryInterval = 5 * time.Minute
+
+// watchState tracks the active push notification watch
+type watchState struct {
+	topic      string
+	expiration time.Time
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+// Watch asks Gmail to publish inbox changes to a Pub/Sub topic, given as
+// projects/PROJECT/topics/TOPIC, and keeps the watch renewed in the
+// background until ctx is cancelled or StopWatch is called. If no sync has
+// run yet, the watch's history ID becomes the starting point for
+// incremental syncs, so only mail arriving after the watch is processed.
+func (c *Client) Watch(ctx context.Context, topic string) error {
+	c.watchMutex.Lock()
+	defer c.watchMutex.Unlock()
+
+	if c.watch != nil {
+		return fmt.Errorf("a Gmail watch is already active on topic %s", c.watch.topic)
+	}
+
+	response, err := c.registerWatch(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	if err := c.seedSyncState(response.HistoryId); err != nil {
+		return err
+	}
+
+	renewCtx, cancel := context.WithCancel(ctx)
+	state := &watchState{
+		topic:      topic,
+		expiration: time.UnixMilli(response.Expiration),
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	c.watch = state
+	go c.renewWatch(renewCtx, state)
+
+	c.logger.WithFields(logrus.Fields{
+		"topic":      topic,
+		"history_id": response.HistoryId,
+		"expiration": state.expiration,
+	}).Info("Started Gmail push notification watch")
+
+	return nil
+}
+
+// StopWatch stops renewing the active watch and tells Gmail to stop sending
+// push notifications. It is a no-op when no watch is active.
+func (c *Client) StopWatch(ctx context.Context) error {
+	c.watchMutex.Lock()
+	state := c.watch
+	c.watch = nil
+	c.watchMutex.Unlock()
+
+	if state == nil {
+		return nil
+	}
+
+	state.cancel()
+	<-state.done
+
+	if err := c.service.Users.Stop("me").Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to stop Gmail watch: %w", err)
+	}
+
+	c.logger.WithField("topic", state.topic).Info("Stopped Gmail push notification watch")
+	return nil
+}
+
+// Close stops the active push notification watch, if any, so Gmail stops
+// publishing changes nobody will process
+func (c *Client) Close(ctx context.Context) error {
+	return c.StopWatch(ctx)
+}
+
+// WatchExpiration returns when the active watch expires unless renewed, and
+// false when no watch is active
+func (c *Client) WatchExpiration() (time.Time, bool) {
+	c.watchMutex.Lock()
+	defer c.watchMutex.Unlock()
+
+	if c.watch == nil {
+		return time.Time{}, false
+	}
+	return c.watch.expiration, true
+}
+
+// registerWatch creates or renews the watch on the inbox
+func (c *Client) registerWatch(ctx context.Context, topic string) (*gmail.WatchResponse, error) {
+	request := &gmail.WatchRequest{
+		TopicName:           topic,
+		LabelIds:            []string{"INBOX"},
+		LabelFilterBehavior: "include",
+	}
+
+	response, err := c.service.Users.Watch("me", request).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch Gmail mailbox: %w", err)
+	}
+	return response, nil
+}
+
+// renewWatch re-registers the watch before it expires until ctx is cancelled
+func (c *Client) renewWatch(ctx context.Context, state *watchState) {
+	defer close(state.done)
+	defer c.clearWatch(state)
+
+	delay := renewalDelay(state.expiration, time.Now())
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		response, err := c.registerWatch(ctx, state.topic)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.WithError(err).WithField("topic", state.topic).Warn("Failed to renew Gmail watch, retrying")
+			delay = watchRetryInterval
+			continue
+		}
+
+		expiration := time.UnixMilli(response.Expiration)
+		c.watchMutex.Lock()
+		state.expiration = expiration
+		c.watchMutex.Unlock()
+
+		c.logger.WithField("expiration", expiration).Debug("Renewed Gmail watch")
+		delay = renewalDelay(expiration, time.Now())
+	}
+}
+
+// clearWatch forgets state if it is still the active watch, so Watch can be
+// called again after its context is cancelled
+func (c *Client) clearWatch(state *watchState) {
+	c.watchMutex.Lock()
+	defer c.watchMutex.Unlock()
+
+	if c.watch == state {
+		c.watch = nil
+	}
+}
+
+// renewalDelay returns how long to wait before renewing a watch: the renew
+// interval, or half the remaining lifetime if that is sooner
+func renewalDelay(expiration, now time.Time) time.Duration {
+	delay := watchRenewInterval
+	if half := expiration.Sub(now) / 2; half < delay {
+		delay = half
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
+
+// seedSyncState records historyID as the sync starting point when no sync
+// has run yet
+func (c *Client) seedSyncState(historyID uint64) error {
+	c.syncMutex.Lock()
+	defer c.syncMutex.Unlock()
+
+	state, err := loadSyncState(c.config.SyncStateFile)
+	if err != nil || state.HistoryID != 0 {
+		return err
+	}
+	return saveSyncState(c.config.SyncStateFile, &SyncState{HistoryID: historyID, UpdatedAt: time.Now()})
+}
+
+// pushRequest is the body Pub/Sub POSTs to a push subscription endpoint
+type pushRequest struct {
+	Message struct {
+		Data      []byte `json:"data"`
+		MessageID string `json:"messageId"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// mailboxNotification is the Gmail payload carried in a push message
+type mailboxNotification struct {
+	EmailAddress string `json:"emailAddress"`
+	HistoryID    uint64 `json:"historyId"`
+}
+
+// PushHandler returns the HTTP handler for a Pub/Sub push subscription on
+// the watch topic. Each notification triggers an incremental sync whose new
+// emails are passed to process; query and maxResults bound the full sync
+// used when the stored history ID has expired. The handler responds with an
+// error status when the sync or process fails so that Pub/Sub redelivers the
+// notification, and acknowledges malformed messages so they are not retried.
+func (c *Client) PushHandler(query string, maxResults int64, process EmailProcessor) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := logging.WithCorrelationID(r.Context(), logging.NewCorrelationID())
+		logger := logging.FromContext(ctx, c.logger)
+
+		var push pushRequest
+		var notification mailboxNotification
+		if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+			logger.WithError(err).Warn("Ignoring malformed Pub/Sub push request")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err := json.Unmarshal(push.Message.Data, &notification); err != nil {
+			logger.WithError(err).WithField("message_id", push.Message.MessageID).Warn("Ignoring malformed Gmail notification")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		logger.WithFields(logrus.Fields{
+			"message_id": push.Message.MessageID,
+			"history_id": notification.HistoryID,
+		}).Debug("Received Gmail push notification")
+
+		if err := c.syncEmails(ctx, query, maxResults, process); err != nil {
+			logger.WithError(err).WithField("message_id", push.Message.MessageID).Error("Failed to handle Gmail push notification")
+			http.Error(w, "sync failed", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
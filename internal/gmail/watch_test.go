@@ -0,0 +1,147 @@
+package gmail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestWatchAndStop(t *testing.T) {
+	mailbox := newFakeMailbox()
+	server := httptest.NewServer(mailbox)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	client.config.SyncStateFile = filepath.Join(t.TempDir(), "sync.json")
+	ctx := context.Background()
+
+	require.NoError(t, client.Watch(ctx, "projects/test/topics/mail"))
+	require.Len(t, mailbox.watchRequests, 1)
+	assert.Equal(t, "projects/test/topics/mail", mailbox.watchRequests[0].TopicName)
+	assert.Equal(t, []string{"INBOX"}, mailbox.watchRequests[0].LabelIds)
+
+	expiration, active := client.WatchExpiration()
+	assert.True(t, active)
+	assert.WithinDuration(t, time.Now().Add(7*24*time.Hour), expiration, time.Minute)
+
+	// The watch seeds incremental sync so nothing before it is processed
+	assert.Equal(t, uint64(100), readSyncState(t, client.config.SyncStateFile).HistoryID)
+
+	err := client.Watch(ctx, "projects/test/topics/other")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already active")
+
+	require.NoError(t, client.StopWatch(ctx))
+	assert.Equal(t, 1, mailbox.stopCalls)
+	_, active = client.WatchExpiration()
+	assert.False(t, active)
+
+	require.NoError(t, client.StopWatch(ctx), "stopping twice is a no-op")
+	assert.Equal(t, 1, mailbox.stopCalls)
+}
+
+func TestWatchRenewal(t *testing.T) {
+	previous := watchRenewInterval
+	watchRenewInterval = 10 * time.Millisecond
+	t.Cleanup(func() { watchRenewInterval = previous })
+
+	mailbox := newFakeMailbox()
+	server := httptest.NewServer(mailbox)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, client.Watch(ctx, "projects/test/topics/mail"))
+
+	assert.Eventually(t, func() bool { return mailbox.watchCount() >= 3 }, 5*time.Second, 5*time.Millisecond)
+
+	// Cancelling the context ends the watch so it can be started again
+	cancel()
+	assert.Eventually(t, func() bool {
+		_, active := client.WatchExpiration()
+		return !active
+	}, 5*time.Second, 5*time.Millisecond)
+	require.NoError(t, client.Watch(context.Background(), "projects/test/topics/mail"))
+	require.NoError(t, client.StopWatch(context.Background()))
+}
+
+func TestRenewalDelay(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, watchRenewInterval, renewalDelay(now.Add(7*24*time.Hour), now))
+	assert.Equal(t, time.Hour, renewalDelay(now.Add(2*time.Hour), now))
+	assert.Equal(t, time.Duration(0), renewalDelay(now.Add(-time.Hour), now))
+}
+
+func TestPushHandler(t *testing.T) {
+	mailbox := newFakeMailbox()
+	server := httptest.NewServer(mailbox)
+	defer server.Close()
+
+	client := testClient(t, server.URL)
+	client.config.SyncStateFile = filepath.Join(t.TempDir(), "sync.json")
+	require.NoError(t, saveSyncState(client.config.SyncStateFile, &SyncState{HistoryID: 100}))
+
+	var processed []string
+	failures := 1
+	handler := client.PushHandler("in:inbox", 10, func(ctx context.Context, emails []*types.Email) error {
+		if failures > 0 {
+			failures--
+			return fmt.Errorf("classifier unavailable")
+		}
+		processed = append(processed, emailIDs(emails)...)
+		return nil
+	})
+
+	// A failed classification is not acknowledged and the sync is retried
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, pushNotificationRequest(t, 120))
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, uint64(100), readSyncState(t, client.config.SyncStateFile).HistoryID)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, pushNotificationRequest(t, 120))
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, []string{"m2", "m3"}, processed)
+	assert.Equal(t, uint64(120), readSyncState(t, client.config.SyncStateFile).HistoryID)
+}
+
+func TestPushHandlerAcknowledgesMalformedMessages(t *testing.T) {
+	client := testClient(t, "http://127.0.0.1:0")
+	handler := client.PushHandler("in:inbox", 10, func(ctx context.Context, emails []*types.Email) error {
+		t.Fatal("malformed notifications must not trigger a sync")
+		return nil
+	})
+
+	for _, body := range []string{"not json", `{"message": {"data": "bm90IGpzb24="}}`} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/push", bytes.NewBufferString(body)))
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+	}
+}
+
+// Helper functions
+
+func pushNotificationRequest(t *testing.T, historyID uint64) *http.Request {
+	data, err := json.Marshal(&mailboxNotification{EmailAddress: "user@example.com", HistoryID: historyID})
+	require.NoError(t, err)
+
+	var push pushRequest
+	push.Message.Data = data
+	push.Message.MessageID = "msg-1"
+	push.Subscription = "projects/test/subscriptions/mail-push"
+
+	body, err := json.Marshal(&push)
+	require.NoError(t, err)
+	return httptest.NewRequest(http.MethodPost, "/push", bytes.NewReader(body))
+}
@@ -0,0 +1,131 @@
+// Package homoglyph normalizes sender domains and links so that lookalike
+// domains, such as "аmazon.com" spelled with a Cyrillic "а", compare equal
+// to the domains they imitate. Text is normalized to NFKC, lowercased,
+// stripped of diacritics, and its confusable characters are mapped to the
+// ASCII letters they resemble; Punycode labels are decoded first.
+//
+// A normalized domain that differs from the raw one is a signal, not a
+// verdict: legitimate internationalized domains differ too.
+package homoglyph
+
+import (
+	"net/mail"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/mailsentinel/core/internal/links"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// confusables maps characters that render like an ASCII letter to it. Only
+// lowercase forms are listed, since text is lowercased first.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'ь': 'b', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'ё': 'e', 'ҽ': 'e',
+	'һ': 'h', 'і': 'i', 'ї': 'i', 'ј': 'j', 'к': 'k', 'ӏ': 'l', 'м': 'm', 'п': 'n',
+	'о': 'o', 'р': 'p', 'ԛ': 'q', 'г': 'r', 'ѕ': 's', 'т': 't', 'у': 'y', 'ү': 'y',
+	'ԝ': 'w', 'х': 'x',
+	// Greek
+	'α': 'a', 'β': 'b', 'ϲ': 'c', 'ε': 'e', 'η': 'n', 'ι': 'i', 'ϳ': 'j', 'κ': 'k',
+	'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'γ': 'y',
+	// Armenian
+	'ց': 'g', 'հ': 'h', 'ո': 'n', 'օ': 'o', 'ս': 'u',
+	// Latin letters outside ASCII
+	'ı': 'i', 'ȷ': 'j', 'ɑ': 'a', 'ɡ': 'g', 'ɩ': 'i', 'ɪ': 'i', 'ʟ': 'l', 'ɴ': 'n',
+	'ø': 'o', 'ð': 'd', 'đ': 'd', 'ħ': 'h', 'ł': 'l', 'ŀ': 'l', 'ß': 's',
+}
+
+// Normalize returns the normalized form of text
+func Normalize(text string) string {
+	text = strings.ToLower(norm.NFKC.String(text))
+
+	var normalized strings.Builder
+	normalized.Grow(len(text))
+	for _, r := range norm.NFD.String(text) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if ascii, confusable := confusables[r]; confusable {
+			r = ascii
+		}
+		normalized.WriteRune(r)
+	}
+	return norm.NFC.String(normalized.String())
+}
+
+// NormalizeDomain returns the normalized form of a domain, decoding its
+// Punycode labels first
+func NormalizeDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if decoded, err := idna.ToUnicode(domain); err == nil {
+		domain = decoded
+	}
+	return Normalize(domain)
+}
+
+// SenderDomain returns the lowercased domain of a From header's address, or
+// "" when it has none
+func SenderDomain(from string) string {
+	address := strings.TrimSpace(from)
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		address = parsed.Address
+	}
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.Trim(address[at+1:], "<> ")), ".")
+}
+
+// NormalizeURL returns a link with its host normalized. Links whose host
+// cannot be parsed are returned unchanged, with an empty host.
+func NormalizeURL(raw string) types.NormalizedURL {
+	normalized := types.NormalizedURL{Raw: raw, Normalized: raw}
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Hostname() == "" {
+		return normalized
+	}
+
+	normalized.Host = strings.ToLower(parsed.Hostname())
+	normalized.NormalizedHost = NormalizeDomain(normalized.Host)
+	if normalized.NormalizedHost != normalized.Host {
+		normalized.Suspicious = true
+		normalized.Normalized = strings.Replace(raw, parsed.Hostname(), normalized.NormalizedHost, 1)
+	}
+	return normalized
+}
+
+// Analyze normalizes the sender domain of an email and the links in its
+// body, using the links the email carries or, when it carries none,
+// extracting them
+func Analyze(email *types.Email) *types.HomoglyphAnalysis {
+	domain := SenderDomain(email.From)
+	analysis := &types.HomoglyphAnalysis{
+		SenderDomain:           domain,
+		NormalizedSenderDomain: NormalizeDomain(domain),
+	}
+	analysis.Suspicious = analysis.NormalizedSenderDomain != domain
+
+	found := email.URLs
+	if found == nil {
+		found = links.Extract(email)
+	}
+	seen := make(map[string]bool, len(found))
+	for _, link := range found {
+		if !seen[link.URL] {
+			seen[link.URL] = true
+			analysis.URLs = append(analysis.URLs, NormalizeURL(link.URL))
+		}
+	}
+	return analysis
+}
+
+// Annotate sets the homoglyph analysis of an email, replacing any it
+// carries
+func Annotate(email *types.Email) {
+	email.Homoglyphs = Analyze(email)
+}
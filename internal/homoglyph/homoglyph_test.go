@@ -0,0 +1,101 @@
+package homoglyph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		name     string
+		domain   string
+		expected string
+	}{
+		{"ascii", "example.com", "example.com"},
+		{"uppercase", "Example.COM.", "example.com"},
+		{"cyrillic a", "аmazon.com", "amazon.com"},
+		{"cyrillic o", "gооgle.com", "google.com"},
+		{"cyrillic paypal", "pаypаl.com", "paypal.com"},
+		{"cyrillic apple", "аррӏе.com", "apple.com"},
+		{"greek omicron", "micrοsοft.com", "microsoft.com"},
+		{"armenian o", "facebօօk.com", "facebook.com"},
+		{"dotless i", "lınkedın.com", "linkedin.com"},
+		{"fullwidth", "ｎｅｔｆｌｉｘ.com", "netflix.com"},
+		{"diacritic", "bücher.de", "bucher.de"},
+		{"punycode", "xn--80ak6aa92e.com", "apple.com"},
+		{"mixed case cyrillic", "АMAZON.com", "amazon.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, NormalizeDomain(tt.domain))
+		})
+	}
+}
+
+func TestSenderDomain(t *testing.T) {
+	tests := []struct {
+		from     string
+		expected string
+	}{
+		{"deals@shop.example.com", "shop.example.com"},
+		{"Amazon <no-reply@аmazon.com>", "аmazon.com"},
+		{"\"Support\" <Help@Example.COM>", "example.com"},
+		{"<broken@example.org", "example.org"},
+		{"no address", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			assert.Equal(t, tt.expected, SenderDomain(tt.from))
+		})
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	normalized := NormalizeURL("https://аmazon.com:8443/login?next=/orders")
+	assert.Equal(t, types.NormalizedURL{
+		Raw:            "https://аmazon.com:8443/login?next=/orders",
+		Normalized:     "https://amazon.com:8443/login?next=/orders",
+		Host:           "аmazon.com",
+		NormalizedHost: "amazon.com",
+		Suspicious:     true,
+	}, normalized)
+
+	plain := NormalizeURL("https://example.com/a")
+	assert.False(t, plain.Suspicious)
+	assert.Equal(t, plain.Raw, plain.Normalized)
+}
+
+func TestAnalyze(t *testing.T) {
+	email := &types.Email{
+		From: "Amazon Security <security@аmazon.com>",
+		Body: "Verify your account at https://аmazon.com/verify or https://www.amazon.com/help",
+	}
+	Annotate(email)
+
+	require.NotNil(t, email.Homoglyphs)
+	assert.Equal(t, "аmazon.com", email.Homoglyphs.SenderDomain)
+	assert.Equal(t, "amazon.com", email.Homoglyphs.NormalizedSenderDomain)
+	assert.True(t, email.Homoglyphs.Suspicious)
+	require.Len(t, email.Homoglyphs.URLs, 2)
+	assert.True(t, email.Homoglyphs.URLs[0].Suspicious)
+	assert.False(t, email.Homoglyphs.URLs[1].Suspicious)
+
+	carried := Analyze(&types.Email{
+		From: "billing@example.com",
+		Body: "https://pаypаl.com/not-extracted",
+		URLs: []types.Link{{URL: "https://pаypаl.com/a"}, {URL: "https://pаypаl.com/a", Text: "again"}},
+	})
+	require.Len(t, carried.URLs, 1, "the links the email carries are used, once each")
+	assert.Equal(t, "https://paypal.com/a", carried.URLs[0].Normalized)
+
+	genuine := Analyze(&types.Email{From: "Amazon <no-reply@amazon.com>"})
+	assert.False(t, genuine.Suspicious)
+	assert.Equal(t, "amazon.com", genuine.NormalizedSenderDomain)
+	assert.Empty(t, genuine.URLs)
+}
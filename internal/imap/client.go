@@ -0,0 +1,679 @@
+// Package imap reads and acts on emails in a generic IMAP mailbox, offering
+// the same surface as the Gmail client so that classification, actions and
+// auditing work unchanged.
+//
+// Emails are identified by their UID in the configured mailbox and parsed
+// with the shared rfc822 parser. Gmail's labels have no IMAP equivalent, so
+// label changes are mapped onto flags and folders:
+//
+//   - UNREAD and STARRED clear or set the \Seen and \Flagged flags, and
+//     IMPORTANT the $Important keyword
+//   - adding SPAM moves the email to the spam folder, and removing INBOX
+//     (archiving) to the archive folder
+//   - other labels are folders, so adding one copies the email into it
+//
+// The trash operation moves the email to the trash folder and the delete
+// operation marks it \Deleted and expunges it.
+package imap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	goimap "github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/rfc822"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// ErrPermanentDeleteDisabled is returned by DeleteMessage unless
+// mail.imap.allow_permanent_delete is set
+var ErrPermanentDeleteDisabled = errors.New("permanent delete is not enabled")
+
+// flagImportant is the keyword standing in for Gmail's IMPORTANT label
+const flagImportant goimap.Flag = "$Important"
+
+// flagLabels maps Gmail system labels to the IMAP flag each stands for. A
+// negated flag is set when the label is absent.
+var flagLabels = map[string]struct {
+	flag    goimap.Flag
+	negated bool
+}{
+	"UNREAD":    {flag: goimap.FlagSeen, negated: true},
+	"STARRED":   {flag: goimap.FlagFlagged},
+	"IMPORTANT": {flag: flagImportant},
+}
+
+// location is where an email moved out of the mailbox now lives
+type location struct {
+	mailbox string
+	uid     goimap.UID
+}
+
+// Client is an IMAP client holding one connection to the server, which is
+// opened on first use and reopened after it drops
+type Client struct {
+	config *config.IMAPConfig
+	logger *logrus.Logger
+	audit  *audit.Logger
+
+	mutex  sync.Mutex
+	client *imapclient.Client
+	// moved tracks the emails moved to another folder by a label change, so
+	// that a following trash or delete operation still finds them
+	moved map[goimap.UID]location
+
+	// folders caches lower-cased folder names to folder names; nil until
+	// first use
+	folders     map[string]string
+	folderMutex sync.Mutex
+}
+
+// NewClient creates an IMAP client. It does not connect until first used.
+func NewClient(cfg *config.IMAPConfig, logger *logrus.Logger) *Client {
+	return &Client{
+		config: cfg,
+		logger: logger,
+		moved:  make(map[goimap.UID]location),
+	}
+}
+
+// SetAuditLogger makes the client record label changes and removals in the
+// audit log. A nil logger disables auditing.
+func (c *Client) SetAuditLogger(auditLogger *audit.Logger) {
+	c.audit = auditLogger
+}
+
+// Close logs out and closes the connection, if one is open
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.client == nil {
+		return nil
+	}
+	client := c.client
+	c.client = nil
+	if err := client.Logout().Wait(); err != nil {
+		c.logger.WithError(err).Warn("Failed to log out of IMAP server")
+	}
+	return client.Close()
+}
+
+// connection returns the open connection with the mailbox selected,
+// connecting first when needed. The caller must hold the mutex.
+func (c *Client) connection(ctx context.Context) (*imapclient.Client, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.client != nil {
+		select {
+		case <-c.client.Closed():
+			c.logger.Warn("IMAP connection closed, reconnecting")
+			c.client = nil
+		default:
+			return c.client, nil
+		}
+	}
+
+	client, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to IMAP server: %w", err)
+	}
+	if err := client.Login(c.config.Username, c.config.Password).Wait(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to log in to IMAP server: %w", err)
+	}
+	if _, err := client.Select(c.mailbox(), nil).Wait(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to select mailbox %s: %w", c.mailbox(), err)
+	}
+
+	c.client = client
+	return client, nil
+}
+
+// dial opens a connection with the configured security
+func (c *Client) dial() (*imapclient.Client, error) {
+	timeout := c.config.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	options := &imapclient.Options{Dialer: &net.Dialer{Timeout: timeout}}
+
+	switch c.config.TLS {
+	case config.IMAPTLSStartTLS:
+		return imapclient.DialStartTLS(c.config.Address, options)
+	case config.IMAPTLSNone:
+		return imapclient.DialInsecure(c.config.Address, options)
+	default:
+		return imapclient.DialTLS(c.config.Address, options)
+	}
+}
+
+// mailbox is the folder emails are read from
+func (c *Client) mailbox() string {
+	if c.config.Mailbox == "" {
+		return "INBOX"
+	}
+	return c.config.Mailbox
+}
+
+// ListEmails retrieves the newest emails matching a query, such as
+// "is:unread from:billing@vendor.example". See ParseQuery for the syntax.
+func (c *Client) ListEmails(ctx context.Context, query string, maxResults int64) ([]*types.Email, error) {
+	c.logger.WithFields(logrus.Fields{
+		"query":       query,
+		"max_results": maxResults,
+	}).Info("Listing emails from IMAP")
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	client, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := client.UIDSearch(ParseQuery(query, time.Now()), nil).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	uids := data.AllUIDs()
+	sort.Slice(uids, func(i, j int) bool { return uids[i] > uids[j] })
+	if maxResults > 0 && int64(len(uids)) > maxResults {
+		uids = uids[:maxResults]
+	}
+	if len(uids) == 0 {
+		return nil, nil
+	}
+
+	messages, err := client.Fetch(goimap.UIDSetNum(uids...), fetchOptions).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].UID > messages[j].UID })
+
+	emails := make([]*types.Email, 0, len(messages))
+	for _, message := range messages {
+		email, err := c.toEmail(message)
+		if err != nil {
+			c.logger.WithError(err).WithField("message_id", message.UID).Warn("Failed to get email")
+			continue
+		}
+		emails = append(emails, email)
+	}
+	return emails, nil
+}
+
+// GetEmail retrieves a single email by ID
+func (c *Client) GetEmail(ctx context.Context, messageID string) (*types.Email, error) {
+	uid, err := parseID(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	client, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := client.Fetch(goimap.UIDSetNum(uid), fetchOptions).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("failed to get message: no message %s in %s", messageID, c.mailbox())
+	}
+	return c.toEmail(messages[0])
+}
+
+// fetchOptions fetch what an email is built from, without marking it read
+var fetchOptions = &goimap.FetchOptions{
+	UID:         true,
+	Flags:       true,
+	RFC822Size:  true,
+	BodySection: []*goimap.FetchItemBodySection{{Peek: true}},
+}
+
+// toEmail parses a fetched message
+func (c *Client) toEmail(message *imapclient.FetchMessageBuffer) (*types.Email, error) {
+	if len(message.BodySection) == 0 {
+		return nil, fmt.Errorf("message %d has no body", message.UID)
+	}
+	email, err := rfc822.Parse(message.BodySection[0].Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	email.ID = formatID(message.UID)
+	if message.RFC822Size > 0 {
+		email.Size = message.RFC822Size
+	}
+	email.Labels = c.labels(message.Flags)
+	return email, nil
+}
+
+// labels returns the Gmail labels an email's flags stand for, along with
+// its mailbox
+func (c *Client) labels(flags []goimap.Flag) []string {
+	set := make(map[goimap.Flag]bool, len(flags))
+	for _, flag := range flags {
+		set[goimap.Flag(strings.ToLower(string(flag)))] = true
+	}
+
+	labels := []string{strings.ToUpper(c.mailbox())}
+	names := make([]string, 0, len(flagLabels))
+	for name := range flagLabels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mapping := flagLabels[name]
+		if set[goimap.Flag(strings.ToLower(string(mapping.flag)))] != mapping.negated {
+			labels = append(labels, name)
+		}
+	}
+	return labels
+}
+
+// ModifyLabels applies a label change as flag changes, copies and a move.
+// Flags are changed first, since moving an email changes its UID.
+func (c *Client) ModifyLabels(ctx context.Context, messageID string, addLabels, removeLabels []string) error {
+	c.logger.WithFields(logrus.Fields{
+		"message_id":    messageID,
+		"add_labels":    addLabels,
+		"remove_labels": removeLabels,
+	}).Info("Modifying email labels")
+
+	uid, err := parseID(messageID)
+	if err != nil {
+		return err
+	}
+	plan := planLabelChange(c.config, addLabels, removeLabels)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	client, err := c.connection(ctx)
+	if err != nil {
+		return err
+	}
+	uids := goimap.UIDSetNum(uid)
+	for _, store := range []struct {
+		op    goimap.StoreFlagsOp
+		flags []goimap.Flag
+	}{
+		{goimap.StoreFlagsAdd, plan.setFlags},
+		{goimap.StoreFlagsDel, plan.clearFlags},
+	} {
+		if len(store.flags) == 0 {
+			continue
+		}
+		if err := client.Store(uids, &goimap.StoreFlags{Op: store.op, Silent: true, Flags: store.flags}, nil).Close(); err != nil {
+			return fmt.Errorf("failed to modify flags: %w", err)
+		}
+	}
+	for _, folder := range plan.copyTo {
+		if err := c.ensureFolder(client, folder); err != nil {
+			return err
+		}
+		if _, err := client.Copy(uids, folder).Wait(); err != nil {
+			return fmt.Errorf("failed to copy message to %s: %w", folder, err)
+		}
+	}
+	if plan.moveTo != "" {
+		if err := c.move(client, uid, plan.moveTo); err != nil {
+			return err
+		}
+	}
+
+	c.auditLabelChanges(ctx, messageID, addLabels, removeLabels)
+	return nil
+}
+
+// labelPlan is the IMAP operations a label change maps to
+type labelPlan struct {
+	setFlags   []goimap.Flag
+	clearFlags []goimap.Flag
+	copyTo     []string
+	moveTo     string
+}
+
+// planLabelChange maps Gmail label changes onto IMAP flags and folders.
+// Reporting spam wins over archiving, and removing a folder label is not
+// possible without knowing the copy, so it is ignored.
+func planLabelChange(cfg *config.IMAPConfig, addLabels, removeLabels []string) labelPlan {
+	var plan labelPlan
+	for _, label := range addLabels {
+		name := strings.ToUpper(label)
+		if mapping, exists := flagLabels[name]; exists {
+			if mapping.negated {
+				plan.clearFlags = append(plan.clearFlags, mapping.flag)
+			} else {
+				plan.setFlags = append(plan.setFlags, mapping.flag)
+			}
+			continue
+		}
+		switch {
+		case name == "SPAM":
+			plan.moveTo = cfg.SpamFolder
+		case name == "INBOX", name == "TRASH", strings.HasPrefix(name, "CATEGORY_"):
+			// The email is read from the inbox, and trashing is an operation
+		default:
+			plan.copyTo = append(plan.copyTo, label)
+		}
+	}
+	for _, label := range removeLabels {
+		name := strings.ToUpper(label)
+		if mapping, exists := flagLabels[name]; exists {
+			if mapping.negated {
+				plan.setFlags = append(plan.setFlags, mapping.flag)
+			} else {
+				plan.clearFlags = append(plan.clearFlags, mapping.flag)
+			}
+			continue
+		}
+		if name == "INBOX" && plan.moveTo == "" {
+			plan.moveTo = cfg.ArchiveFolder
+		}
+	}
+	return plan
+}
+
+// auditLabelChanges records each applied label change in the audit log, if
+// one is configured. Audit failures are logged but do not fail the request.
+func (c *Client) auditLabelChanges(ctx context.Context, messageID string, addLabels, removeLabels []string) {
+	if c.audit == nil {
+		return
+	}
+
+	email := &types.Email{ID: messageID}
+	changes := make([]string, 0, len(addLabels)+len(removeLabels))
+	for _, label := range addLabels {
+		changes = append(changes, "+"+label)
+	}
+	for _, label := range removeLabels {
+		changes = append(changes, "-"+label)
+	}
+
+	for _, change := range changes {
+		if err := c.audit.LogAction(ctx, email, "modify_labels", change); err != nil {
+			c.logger.WithError(err).WithField("message_id", messageID).Error("Failed to audit label change")
+		}
+	}
+}
+
+// TrashMessage moves an email to the trash folder
+func (c *Client) TrashMessage(ctx context.Context, messageID string) error {
+	c.logger.WithField("message_id", messageID).Info("Moving email to trash")
+
+	uid, err := parseID(messageID)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	client, err := c.connection(ctx)
+	if err != nil {
+		return err
+	}
+	err = c.inMailbox(client, uid, func(mailbox string, uid goimap.UID) error {
+		if mailbox == c.config.TrashFolder {
+			return nil
+		}
+		if err := c.ensureFolder(client, c.config.TrashFolder); err != nil {
+			return err
+		}
+		if _, err := client.Move(goimap.UIDSetNum(uid), c.config.TrashFolder).Wait(); err != nil {
+			return fmt.Errorf("failed to trash message: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.auditRemoval(ctx, messageID, false)
+	return nil
+}
+
+// DeleteMessage marks an email \Deleted and expunges it, which cannot be
+// undone, so it fails with ErrPermanentDeleteDisabled unless
+// mail.imap.allow_permanent_delete is set
+func (c *Client) DeleteMessage(ctx context.Context, messageID string) error {
+	if !c.config.AllowPermanentDelete {
+		return fmt.Errorf("failed to delete message %s: %w", messageID, ErrPermanentDeleteDisabled)
+	}
+
+	c.logger.WithField("message_id", messageID).Warn("Permanently deleting email")
+
+	uid, err := parseID(messageID)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	client, err := c.connection(ctx)
+	if err != nil {
+		return err
+	}
+	err = c.inMailbox(client, uid, func(mailbox string, uid goimap.UID) error {
+		uids := goimap.UIDSetNum(uid)
+		store := &goimap.StoreFlags{Op: goimap.StoreFlagsAdd, Silent: true, Flags: []goimap.Flag{goimap.FlagDeleted}}
+		if err := client.Store(uids, store, nil).Close(); err != nil {
+			return fmt.Errorf("failed to delete message: %w", err)
+		}
+		// UID EXPUNGE leaves other emails marked \Deleted alone
+		expunge := client.Expunge
+		if client.Caps().Has(goimap.CapUIDPlus) {
+			expunge = func() *imapclient.ExpungeCommand { return client.UIDExpunge(uids) }
+		}
+		if err := expunge().Close(); err != nil {
+			return fmt.Errorf("failed to delete message: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.auditRemoval(ctx, messageID, true)
+	return nil
+}
+
+// auditRemoval records a trashed or deleted email in the audit log, if one
+// is configured. Audit failures are logged but do not fail the request.
+func (c *Client) auditRemoval(ctx context.Context, messageID string, permanent bool) {
+	if c.audit == nil {
+		return
+	}
+
+	if err := c.audit.LogMessageRemoval(ctx, messageID, permanent); err != nil {
+		c.logger.WithError(err).WithField("message_id", messageID).Error("Failed to audit message removal")
+	}
+}
+
+// move moves an email out of the mailbox, remembering where it went. The
+// caller must hold the mutex.
+func (c *Client) move(client *imapclient.Client, uid goimap.UID, folder string) error {
+	if err := c.ensureFolder(client, folder); err != nil {
+		return err
+	}
+	data, err := client.Move(goimap.UIDSetNum(uid), folder).Wait()
+	if err != nil {
+		return fmt.Errorf("failed to move message to %s: %w", folder, err)
+	}
+
+	// Servers without UIDPLUS do not say where the email went
+	destination := location{mailbox: folder}
+	if dest, ok := data.DestUIDs.(goimap.UIDSet); ok {
+		if uids, ok := dest.Nums(); ok && len(uids) == 1 {
+			destination.uid = uids[0]
+		}
+	}
+	c.moved[uid] = destination
+	return nil
+}
+
+// inMailbox runs f on an email where it now lives, selecting the folder it
+// was moved to for the duration. The caller must hold the mutex.
+func (c *Client) inMailbox(client *imapclient.Client, uid goimap.UID, f func(mailbox string, uid goimap.UID) error) error {
+	destination, moved := c.moved[uid]
+	if !moved {
+		return f(c.mailbox(), uid)
+	}
+	if destination.uid == 0 {
+		return fmt.Errorf("message %d was moved to %s and cannot be found there", uid, destination.mailbox)
+	}
+
+	if _, err := client.Select(destination.mailbox, nil).Wait(); err != nil {
+		return fmt.Errorf("failed to select mailbox %s: %w", destination.mailbox, err)
+	}
+	err := f(destination.mailbox, destination.uid)
+	if _, selectErr := client.Select(c.mailbox(), nil).Wait(); selectErr != nil {
+		// Leave the connection to be reopened with the mailbox selected
+		client.Close()
+		c.client = nil
+	}
+	if err == nil {
+		delete(c.moved, uid)
+	}
+	return err
+}
+
+// ensureFolder creates a folder unless it exists. The caller must hold the
+// mutex.
+func (c *Client) ensureFolder(client *imapclient.Client, folder string) error {
+	mailboxes, err := client.List("", folder, nil).Collect()
+	if err != nil {
+		return fmt.Errorf("failed to list folders: %w", err)
+	}
+	if len(mailboxes) > 0 {
+		return nil
+	}
+	if err := client.Create(folder, nil).Wait(); err != nil {
+		return fmt.Errorf("failed to create folder %s: %w", folder, err)
+	}
+	return nil
+}
+
+// ListLabels lists the folders, which stand in for labels
+func (c *Client) ListLabels(ctx context.Context) ([]*gmail.Label, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	client, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mailboxes, err := client.List("", "*", nil).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+
+	labels := make([]*gmail.Label, len(mailboxes))
+	for i, mailbox := range mailboxes {
+		labels[i] = &gmail.Label{Id: mailbox.Mailbox, Name: mailbox.Mailbox, Type: "user"}
+	}
+	return labels, nil
+}
+
+// LabelIDForName returns the folder standing in for the label with the given
+// name, creating it if it does not exist. Names match folders ignoring case,
+// like Gmail labels. Folders are listed once and cached.
+func (c *Client) LabelIDForName(ctx context.Context, name string) (string, error) {
+	c.folderMutex.Lock()
+	defer c.folderMutex.Unlock()
+
+	if c.folders == nil {
+		labels, err := c.ListLabels(ctx)
+		if err != nil {
+			return "", err
+		}
+		c.folders = make(map[string]string, len(labels))
+		for _, label := range labels {
+			c.folders[strings.ToLower(label.Name)] = label.Id
+		}
+	}
+
+	if folder, exists := c.folders[strings.ToLower(name)]; exists {
+		return folder, nil
+	}
+
+	label, err := c.CreateLabel(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	c.folders[strings.ToLower(name)] = label.Id
+	return label.Id, nil
+}
+
+// LabelNameForID returns the ID unchanged, as folder names are their IDs
+func (c *Client) LabelNameForID(ctx context.Context, id string) (string, error) {
+	return id, nil
+}
+
+// CreateLabel creates the folder standing in for a label
+func (c *Client) CreateLabel(ctx context.Context, name string) (*gmail.Label, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	client, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ensureFolder(client, name); err != nil {
+		return nil, err
+	}
+
+	c.logger.WithField("label", name).Info("Created IMAP folder")
+	return &gmail.Label{Id: name, Name: name, Type: "user"}, nil
+}
+
+// HealthCheck verifies IMAP connectivity
+func (c *Client) HealthCheck(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	client, err := c.connection(ctx)
+	if err == nil {
+		err = client.Noop().Wait()
+	}
+	if err != nil {
+		return fmt.Errorf("IMAP health check failed: %w", err)
+	}
+	return nil
+}
+
+// formatID returns the email ID of a UID
+func formatID(uid goimap.UID) string {
+	return strconv.FormatUint(uint64(uid), 10)
+}
+
+// parseID returns the UID of an email ID
+func parseID(messageID string) (goimap.UID, error) {
+	uid, err := strconv.ParseUint(messageID, 10, 32)
+	if err != nil || uid == 0 {
+		return 0, fmt.Errorf("invalid IMAP message ID %q", messageID)
+	}
+	return goimap.UID(uid), nil
+}
@@ -0,0 +1,274 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	goimap "github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-imap/v2/imapserver"
+	"github.com/emersion/go-imap/v2/imapserver/imapmemserver"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+func TestListEmails(t *testing.T) {
+	server := newMockServer(t)
+	invoice := server.appendMessage(t, "INBOX", invoiceMessage)
+	server.appendMessage(t, "INBOX", newsletterMessage, goimap.FlagSeen)
+
+	client := NewClient(server.config(), testLogger())
+	defer client.Close()
+
+	emails, err := client.ListEmails(context.Background(), "", 10)
+	require.NoError(t, err)
+	require.Len(t, emails, 2)
+	assert.Equal(t, "Weekly digest", emails[0].Subject, "the newest email comes first")
+	assert.Equal(t, []string{"INBOX"}, emails[0].Labels)
+	assert.Equal(t, []string{"INBOX", "UNREAD"}, emails[1].Labels)
+	assert.Equal(t, "Invoice overdue", emails[1].Subject)
+	assert.Equal(t, "Billing <billing@vendor.example>", emails[1].From)
+
+	unread, err := client.ListEmails(context.Background(), "is:unread", 10)
+	require.NoError(t, err)
+	require.Len(t, unread, 1)
+	assert.Equal(t, formatID(invoice), unread[0].ID)
+
+	limited, err := client.ListEmails(context.Background(), "", 1)
+	require.NoError(t, err)
+	assert.Len(t, limited, 1)
+
+	email, err := client.GetEmail(context.Background(), formatID(invoice))
+	require.NoError(t, err)
+	assert.Equal(t, "Your invoice is overdue.\r\n", email.Body)
+	assert.Contains(t, email.Labels, "UNREAD", "fetching does not mark the email read")
+
+	_, err = client.GetEmail(context.Background(), "not-a-uid")
+	assert.ErrorContains(t, err, "invalid IMAP message ID")
+}
+
+func TestModifyLabelsMapsToFlagsAndFolders(t *testing.T) {
+	server := newMockServer(t)
+	uid := server.appendMessage(t, "INBOX", invoiceMessage)
+	id := formatID(uid)
+
+	client := NewClient(server.config(), testLogger())
+	defer client.Close()
+	ctx := context.Background()
+
+	require.NoError(t, client.ModifyLabels(ctx, id, []string{"STARRED", "MailSentinel/Invoices"}, []string{"UNREAD"}))
+	assert.ElementsMatch(t, []goimap.Flag{goimap.FlagSeen, goimap.FlagFlagged}, server.flags(t, "INBOX", uid))
+	assert.Len(t, server.uids(t, "MailSentinel/Invoices"), 1, "a label is a folder the email is copied into")
+
+	require.NoError(t, client.ModifyLabels(ctx, id, nil, []string{"INBOX"}))
+	assert.Empty(t, server.uids(t, "INBOX"), "archiving moves the email out of the inbox")
+	assert.Len(t, server.uids(t, "Archive"), 1)
+
+	require.NoError(t, client.TrashMessage(ctx, id), "the archived email is still found")
+	assert.Empty(t, server.uids(t, "Archive"))
+	assert.Len(t, server.uids(t, "Trash"), 1)
+
+	labels, err := client.ListLabels(ctx)
+	require.NoError(t, err)
+	var names []string
+	for _, label := range labels {
+		names = append(names, label.Name)
+	}
+	assert.Subset(t, names, []string{"INBOX", "Archive", "Trash", "MailSentinel/Invoices"})
+}
+
+func TestLabelIDForName(t *testing.T) {
+	server := newMockServer(t)
+	client := NewClient(server.config(), testLogger())
+	defer client.Close()
+	ctx := context.Background()
+
+	id, err := client.LabelIDForName(ctx, "MailSentinel/Review")
+	require.NoError(t, err)
+	assert.Equal(t, "MailSentinel/Review", id, "a missing label is created as a folder")
+
+	id, err = client.LabelIDForName(ctx, "mailsentinel/review")
+	require.NoError(t, err)
+	assert.Equal(t, "MailSentinel/Review", id, "folders match ignoring case")
+
+	labels, err := client.ListLabels(ctx)
+	require.NoError(t, err)
+	var names []string
+	for _, label := range labels {
+		names = append(names, label.Name)
+	}
+	assert.Contains(t, names, "MailSentinel/Review")
+	assert.NotContains(t, names, "mailsentinel/review")
+}
+
+func TestDeleteMessage(t *testing.T) {
+	server := newMockServer(t)
+	uid := server.appendMessage(t, "INBOX", invoiceMessage)
+	kept := server.appendMessage(t, "INBOX", newsletterMessage)
+
+	client := NewClient(server.config(), testLogger())
+	defer client.Close()
+
+	err := client.DeleteMessage(context.Background(), formatID(uid))
+	assert.ErrorIs(t, err, ErrPermanentDeleteDisabled)
+	assert.Len(t, server.uids(t, "INBOX"), 2)
+
+	client.config.AllowPermanentDelete = true
+	require.NoError(t, client.DeleteMessage(context.Background(), formatID(uid)))
+	assert.Equal(t, []goimap.UID{kept}, server.uids(t, "INBOX"))
+}
+
+func TestPlanLabelChange(t *testing.T) {
+	cfg := &config.IMAPConfig{ArchiveFolder: "Archive", SpamFolder: "Junk"}
+
+	plan := planLabelChange(cfg, []string{"SPAM", "IMPORTANT"}, []string{"INBOX", "STARRED"})
+	assert.Equal(t, "Junk", plan.moveTo, "reporting spam wins over archiving")
+	assert.Equal(t, []goimap.Flag{flagImportant}, plan.setFlags)
+	assert.Equal(t, []goimap.Flag{goimap.FlagFlagged}, plan.clearFlags)
+	assert.Empty(t, plan.copyTo)
+
+	plan = planLabelChange(cfg, []string{"UNREAD", "CATEGORY_PROMOTIONS"}, []string{"Invoices"})
+	assert.Equal(t, []goimap.Flag{goimap.FlagSeen}, plan.clearFlags)
+	assert.Empty(t, plan.moveTo)
+	assert.Empty(t, plan.copyTo, "categories have no folder")
+}
+
+func TestParseQuery(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	criteria := ParseQuery("is:unread from:billing@vendor.example newer_than:7d overdue", now)
+	assert.Equal(t, []goimap.Flag{goimap.FlagDeleted, goimap.FlagSeen}, criteria.NotFlag)
+	assert.Equal(t, []goimap.SearchCriteriaHeaderField{{Key: "from", Value: "billing@vendor.example"}}, criteria.Header)
+	assert.Equal(t, now.AddDate(0, 0, -7), criteria.Since)
+	assert.Equal(t, []string{"overdue"}, criteria.Text)
+
+	criteria = ParseQuery("", now)
+	assert.Equal(t, []goimap.Flag{goimap.FlagDeleted}, criteria.NotFlag)
+	assert.Empty(t, criteria.Text)
+
+	criteria = ParseQuery("is:starred older_than:soon label:work", now)
+	assert.Equal(t, []goimap.Flag{goimap.FlagFlagged}, criteria.Flag)
+	assert.True(t, criteria.Before.IsZero())
+	assert.Equal(t, []string{"older_than:soon", "label:work"}, criteria.Text)
+}
+
+// Helper functions
+
+const invoiceMessage = "From: Billing <billing@vendor.example>\r\n" +
+	"To: me@example.com\r\n" +
+	"Subject: Invoice overdue\r\n" +
+	"Date: Fri, 01 Mar 2024 09:30:00 +0000\r\n" +
+	"Message-ID: <invoice@vendor.example>\r\n" +
+	"\r\n" +
+	"Your invoice is overdue.\r\n"
+
+const newsletterMessage = "From: News <news@shop.example>\r\n" +
+	"To: me@example.com\r\n" +
+	"Subject: Weekly digest\r\n" +
+	"Date: Sat, 02 Mar 2024 09:30:00 +0000\r\n" +
+	"\r\n" +
+	"This week's deals.\r\n"
+
+// mockServer is an in-memory IMAP server with one user and an inbox
+type mockServer struct {
+	address string
+	user    *imapmemserver.User
+}
+
+func newMockServer(t *testing.T) *mockServer {
+	memServer := imapmemserver.New()
+	user := imapmemserver.NewUser("me@example.com", "secret")
+	require.NoError(t, user.Create("INBOX", nil))
+	memServer.AddUser(user)
+
+	server := imapserver.New(&imapserver.Options{
+		NewSession: func(*imapserver.Conn) (imapserver.Session, *imapserver.GreetingData, error) {
+			return memServer.NewSession(), nil, nil
+		},
+		Caps:         goimap.CapSet{goimap.CapIMAP4rev1: {}, goimap.CapMove: {}, goimap.CapUIDPlus: {}},
+		InsecureAuth: true,
+		Logger:       discardLogger{},
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return &mockServer{address: listener.Addr().String(), user: user}
+}
+
+func (m *mockServer) config() *config.IMAPConfig {
+	return &config.IMAPConfig{
+		Address:       m.address,
+		Username:      "me@example.com",
+		Password:      "secret",
+		TLS:           config.IMAPTLSNone,
+		Mailbox:       "INBOX",
+		ArchiveFolder: "Archive",
+		SpamFolder:    "Junk",
+		TrashFolder:   "Trash",
+		Timeout:       5 * time.Second,
+	}
+}
+
+// appendMessage adds a message to a mailbox, returning its UID
+func (m *mockServer) appendMessage(t *testing.T, mailbox, message string, flags ...goimap.Flag) goimap.UID {
+	data, err := m.user.Append(mailbox, literal{bytes.NewReader([]byte(message))}, &goimap.AppendOptions{Flags: flags})
+	require.NoError(t, err)
+	return data.UID
+}
+
+// uids lists the UIDs in a mailbox, or none when it does not exist
+func (m *mockServer) uids(t *testing.T, mailbox string) []goimap.UID {
+	client := m.dial(t)
+	if _, err := client.Select(mailbox, nil).Wait(); err != nil {
+		return nil
+	}
+	data, err := client.UIDSearch(&goimap.SearchCriteria{}, nil).Wait()
+	require.NoError(t, err)
+	return data.AllUIDs()
+}
+
+// flags returns the flags of a message
+func (m *mockServer) flags(t *testing.T, mailbox string, uid goimap.UID) []goimap.Flag {
+	client := m.dial(t)
+	_, err := client.Select(mailbox, nil).Wait()
+	require.NoError(t, err)
+	messages, err := client.Fetch(goimap.UIDSetNum(uid), &goimap.FetchOptions{Flags: true}).Collect()
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	return messages[0].Flags
+}
+
+func (m *mockServer) dial(t *testing.T) *imapclient.Client {
+	client, err := imapclient.DialInsecure(m.address, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	require.NoError(t, client.Login("me@example.com", "secret").Wait())
+	return client
+}
+
+// literal is a message appended to the mock server
+type literal struct {
+	*bytes.Reader
+}
+
+func (l literal) Size() int64 {
+	return int64(l.Reader.Len())
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Printf(format string, args ...interface{}) {}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
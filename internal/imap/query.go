@@ -0,0 +1,62 @@
+package imap
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	goimap "github.com/emersion/go-imap/v2"
+)
+
+// ParseQuery translates the subset of Gmail's search syntax that makes
+// sense over IMAP into search criteria, relative to now:
+//
+//   - is:unread, is:read, is:starred and is:important
+//   - from:, to: and subject: followed by a value
+//   - newer_than: and older_than: followed by a number of days, such as 7d
+//   - any other word, which must appear in the headers or body
+//
+// An empty query matches every email not marked \Deleted.
+func ParseQuery(query string, now time.Time) *goimap.SearchCriteria {
+	criteria := &goimap.SearchCriteria{NotFlag: []goimap.Flag{goimap.FlagDeleted}}
+	for _, term := range strings.Fields(query) {
+		key, value, found := strings.Cut(term, ":")
+		if !found || value == "" {
+			criteria.Text = append(criteria.Text, term)
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "is":
+			switch strings.ToLower(value) {
+			case "unread":
+				criteria.NotFlag = append(criteria.NotFlag, goimap.FlagSeen)
+			case "read":
+				criteria.Flag = append(criteria.Flag, goimap.FlagSeen)
+			case "starred":
+				criteria.Flag = append(criteria.Flag, goimap.FlagFlagged)
+			case "important":
+				criteria.Flag = append(criteria.Flag, flagImportant)
+			default:
+				criteria.Text = append(criteria.Text, term)
+			}
+		case "from", "to", "subject":
+			criteria.Header = append(criteria.Header, goimap.SearchCriteriaHeaderField{Key: key, Value: value})
+		case "newer_than", "older_than":
+			days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+			if err != nil || days < 0 {
+				criteria.Text = append(criteria.Text, term)
+				continue
+			}
+			date := now.AddDate(0, 0, -days)
+			if strings.EqualFold(key, "newer_than") {
+				criteria.Since = date
+			} else {
+				criteria.Before = date
+			}
+		default:
+			criteria.Text = append(criteria.Text, term)
+		}
+	}
+	return criteria
+}
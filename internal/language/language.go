@@ -0,0 +1,181 @@
+// Package language detects the language an email is written in, so that
+// routes and conditions can pick a profile tuned for it and the prompt can
+// tell the model. Emails in a script used by a single language, such as
+// Hangul or Greek, are detected by their script; emails in the Latin or
+// Cyrillic script by counting their most common words in each language.
+//
+// Detection is a heuristic: bodies too short to tell, and bodies mixing
+// languages evenly, are types.LanguageUnknown.
+package language
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// minLetters is the fewest letters a body needs for its language to be
+// detected
+const minLetters = 20
+
+// minWords is the fewest words a Latin or Cyrillic body needs for its
+// language to be detected, and minHits the fewest of them that must be
+// common words of the detected language
+const (
+	minWords = 5
+	minHits  = 2
+)
+
+// names are the English names of the languages Detect reports, by ISO
+// 639-1 code
+var names = map[string]string{
+	"ar": "Arabic",
+	"de": "German",
+	"el": "Greek",
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"he": "Hebrew",
+	"it": "Italian",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"nl": "Dutch",
+	"pt": "Portuguese",
+	"ru": "Russian",
+	"th": "Thai",
+	"uk": "Ukrainian",
+	"zh": "Chinese",
+}
+
+// scripts are the scripts written in a single language of names
+var scripts = []struct {
+	table    *unicode.RangeTable
+	language string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Arabic, "ar"},
+	{unicode.Thai, "th"},
+}
+
+// stopwords are the most common words of each language written in the
+// Latin or Cyrillic script. Words common to several languages are listed
+// in each, so that only the words telling them apart decide.
+var stopwords = map[string]map[string]bool{
+	"en": set("the", "and", "is", "are", "of", "to", "you", "your", "this", "that", "with", "for", "have", "we", "will", "please", "not", "be", "our", "in", "it", "on"),
+	"de": set("der", "die", "das", "und", "ist", "sie", "nicht", "mit", "ich", "wir", "ihr", "ihre", "sind", "auf", "für", "bitte", "ein", "eine", "zu", "den", "dem", "werden", "wurde", "in", "es", "um"),
+	"fr": set("le", "la", "les", "et", "est", "vous", "votre", "vos", "des", "une", "un", "pour", "avec", "nous", "pas", "que", "qui", "dans", "sur", "ce", "de", "du", "en", "il"),
+	"es": set("el", "la", "los", "las", "y", "es", "usted", "su", "sus", "una", "un", "para", "con", "por", "que", "del", "de", "en", "no", "está", "se", "lo"),
+	"it": set("il", "la", "e", "è", "di", "che", "non", "per", "una", "un", "sono", "della", "con", "gli", "questo", "suo", "vostro", "grazie", "in", "si", "del"),
+	"nl": set("de", "het", "en", "van", "een", "is", "niet", "u", "uw", "wij", "we", "met", "voor", "op", "dat", "zijn", "te", "in", "ik", "wordt"),
+	"pt": set("o", "os", "as", "e", "é", "não", "você", "seu", "sua", "uma", "um", "para", "com", "por", "que", "do", "da", "de", "em", "se", "no", "na"),
+	"ru": set("и", "в", "не", "на", "что", "это", "как", "вы", "ваш", "ваша", "мы", "с", "по", "для", "от", "я", "он", "вас"),
+	"uk": set("і", "та", "не", "на", "що", "це", "як", "ви", "ваш", "ваша", "ми", "з", "по", "для", "від", "у", "в", "вас"),
+}
+
+// Detect returns the ISO 639-1 code of the language of text, or
+// types.LanguageUnknown when it is too short or too evenly mixed to tell
+func Detect(text string) string {
+	letters, counts := 0, make(map[*unicode.RangeTable]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, table := range []*unicode.RangeTable{unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Greek, unicode.Hebrew, unicode.Arabic, unicode.Thai} {
+			if unicode.Is(table, r) {
+				counts[table]++
+				break
+			}
+		}
+	}
+	if letters < minLetters {
+		return types.LanguageUnknown
+	}
+
+	// Japanese mixes kana with Chinese characters, which it shares with
+	// Chinese
+	if kana := counts[unicode.Hiragana] + counts[unicode.Katakana]; kana > 0 && 2*(kana+counts[unicode.Han]) > letters {
+		return "ja"
+	}
+	if 2*counts[unicode.Han] > letters {
+		return "zh"
+	}
+	for _, script := range scripts {
+		if 2*counts[script.table] > letters {
+			return script.language
+		}
+	}
+	return byStopwords(text)
+}
+
+// Annotate sets the language of an email from its plain text body, or from
+// its snippet when only a preview was fetched, replacing any it carries.
+// Quoted lines are skipped, since a reply may quote another language.
+func Annotate(email *types.Email) {
+	body := email.Body
+	if email.Preview {
+		body = email.Snippet
+	}
+	var text strings.Builder
+	for _, line := range strings.Split(body, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), ">") {
+			text.WriteString(line)
+			text.WriteString("\n")
+		}
+	}
+	email.Language = Detect(text.String())
+}
+
+// Name returns the English name of a language code Detect reports, or the
+// code itself when it has none
+func Name(code string) string {
+	if name, ok := names[code]; ok {
+		return name
+	}
+	return code
+}
+
+// byStopwords returns the language whose common words text uses most,
+// types.LanguageUnknown when it has too few words or no language leads
+func byStopwords(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if len(words) < minWords {
+		return types.LanguageUnknown
+	}
+
+	hits := make(map[string]int)
+	for _, word := range words {
+		for language, common := range stopwords {
+			if common[word] {
+				hits[language]++
+			}
+		}
+	}
+	best, first, second := types.LanguageUnknown, 0, 0
+	for language, count := range hits {
+		switch {
+		case count > first:
+			best, first, second = language, count, first
+		case count > second:
+			second = count
+		}
+	}
+	if first < minHits || first == second {
+		return types.LanguageUnknown
+	}
+	return best
+}
+
+// set returns the set of words
+func set(words ...string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, word := range words {
+		set[word] = true
+	}
+	return set
+}
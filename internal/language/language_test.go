@@ -0,0 +1,71 @@
+package language
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestDetectFixtures(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
+
+	for _, fixture := range fixtures {
+		expected := strings.TrimSuffix(filepath.Base(fixture), ".txt")
+		t.Run(expected, func(t *testing.T) {
+			body, err := os.ReadFile(fixture)
+			require.NoError(t, err)
+			assert.Equal(t, expected, Detect(string(body)))
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"empty", "", types.LanguageUnknown},
+		{"too short", "Danke!", types.LanguageUnknown},
+		{"no common words", "Invoice INV-2024-0042 attached, EUR 1.250,00 payable upon receipt", types.LanguageUnknown},
+		{"greek", "Αγαπητέ πελάτη, ο λογαριασμός σας έχει ανασταλεί προσωρινά.", "el"},
+		{"ukrainian", "Шановний клієнте, ваш рахунок заблоковано. Щоб відновити доступ, перейдіть за посиланням та підтвердіть дані.", "uk"},
+		{"evenly mixed", "Please call this number. Bitte rufen Sie an.", types.LanguageUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Detect(tt.text))
+		})
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	email := &types.Email{
+		Body:     "Ja, das passt mir gut. Wir sehen uns am Montag um zehn Uhr im Büro.\n\n> Does Monday at ten work for you? Let me know and I will book the room.",
+		Language: "en",
+	}
+	Annotate(email)
+	assert.Equal(t, "de", email.Language, "quoted lines are skipped")
+
+	preview := &types.Email{Preview: true, Snippet: "Votre facture est disponible dans votre espace client, merci de la régler avant le 15."}
+	Annotate(preview)
+	assert.Equal(t, "fr", preview.Language)
+
+	empty := &types.Email{}
+	Annotate(empty)
+	assert.Equal(t, types.LanguageUnknown, empty.Language)
+}
+
+func TestName(t *testing.T) {
+	assert.Equal(t, "German", Name("de"))
+	assert.Equal(t, "xx", Name("xx"), "codes without a name are returned as they are")
+}
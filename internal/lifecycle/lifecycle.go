@@ -0,0 +1,169 @@
+// Package lifecycle coordinates graceful shutdown. Work such as a
+// classification or a Gmail modification is bracketed by Begin and its done
+// function; Shutdown stops new work from starting, waits for the work in
+// flight, then releases the registered resources and closes the audit log.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/audit"
+)
+
+// ErrShuttingDown is returned by Begin once shutdown has started
+var ErrShuttingDown = errors.New("shutting down")
+
+// Closer releases a resource during shutdown
+type Closer func(ctx context.Context) error
+
+// namedCloser is a registered Closer
+type namedCloser struct {
+	name    string
+	release Closer
+}
+
+// Coordinator tracks in-flight work and shuts it down in order. A nil
+// Coordinator tracks nothing and never refuses work.
+type Coordinator struct {
+	audit  *audit.Logger
+	logger *logrus.Logger
+
+	mutex    sync.Mutex
+	stopping bool
+	inFlight int
+	drained  chan struct{} // closed once stopping with nothing in flight
+	closers  []namedCloser
+
+	once sync.Once
+	err  error
+}
+
+// NewCoordinator creates a coordinator that closes auditLogger, which may
+// be nil, once in-flight work has drained
+func NewCoordinator(auditLogger *audit.Logger, logger *logrus.Logger) *Coordinator {
+	return &Coordinator{
+		audit:   auditLogger,
+		logger:  logger,
+		drained: make(chan struct{}),
+	}
+}
+
+// OnShutdown registers a resource to release after in-flight work has
+// drained. Resources are released in reverse registration order.
+func (c *Coordinator) OnShutdown(name string, closer Closer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closers = append(c.closers, namedCloser{name: name, release: closer})
+}
+
+// Begin records the start of a unit of work and returns the function to
+// call when it finishes. Once shutdown has started it returns
+// ErrShuttingDown instead.
+func (c *Coordinator) Begin() (func(), error) {
+	if c == nil {
+		return func() {}, nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stopping {
+		return nil, ErrShuttingDown
+	}
+	c.inFlight++
+
+	var once sync.Once
+	return func() {
+		once.Do(c.finish)
+	}, nil
+}
+
+// finish records the end of a unit of work
+func (c *Coordinator) finish() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.inFlight--
+	if c.stopping && c.inFlight == 0 {
+		close(c.drained)
+	}
+}
+
+// Stopping reports whether shutdown has started
+func (c *Coordinator) Stopping() bool {
+	if c == nil {
+		return false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.stopping
+}
+
+// Shutdown stops new work from starting and waits for the work in flight
+// to finish or ctx to expire. It then flushes buffered audit entries,
+// releases the registered resources and closes the audit log, which logs
+// the system stop event. Work still running after ctx expires is abandoned:
+// its audit writes fail with audit.ErrClosed rather than following the stop
+// event. Later calls return the result of the first.
+func (c *Coordinator) Shutdown(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+
+	c.once.Do(func() {
+		c.err = c.shutdown(ctx)
+	})
+	return c.err
+}
+
+// shutdown performs Shutdown once
+func (c *Coordinator) shutdown(ctx context.Context) error {
+	c.mutex.Lock()
+	c.stopping = true
+	inFlight := c.inFlight
+	if inFlight == 0 {
+		close(c.drained)
+	}
+	closers := c.closers
+	c.mutex.Unlock()
+
+	c.logger.WithField("in_flight", inFlight).Info("Shutting down, waiting for in-flight work")
+
+	var errs []error
+	select {
+	case <-c.drained:
+	case <-ctx.Done():
+		c.mutex.Lock()
+		abandoned := c.inFlight
+		c.mutex.Unlock()
+
+		c.logger.WithField("in_flight", abandoned).Warn("Shutdown deadline passed with work still in flight")
+		errs = append(errs, fmt.Errorf("%d operations still in flight: %w", abandoned, ctx.Err()))
+	}
+
+	if c.audit != nil {
+		if err := c.audit.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush audit log: %w", err))
+		}
+	}
+
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].release(ctx); err != nil {
+			c.logger.WithError(err).WithField("resource", closers[i].name).Error("Failed to release resource")
+			errs = append(errs, fmt.Errorf("failed to close %s: %w", closers[i].name, err))
+		}
+	}
+
+	if c.audit != nil {
+		if err := c.audit.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close audit log: %w", err))
+		}
+	}
+
+	c.logger.Info("Shutdown complete")
+	return errors.Join(errs...)
+}
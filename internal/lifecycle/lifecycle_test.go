@@ -0,0 +1,195 @@
+package lifecycle
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestShutdownMidBatchDrainsInFlightWork(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	auditLogger, err := audit.NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+
+	coordinator := NewCoordinator(auditLogger, testLogger())
+	var order []string
+	var orderMutex sync.Mutex
+	coordinator.OnShutdown("ollama", func(ctx context.Context) error {
+		orderMutex.Lock()
+		defer orderMutex.Unlock()
+		order = append(order, "ollama")
+		return nil
+	})
+	coordinator.OnShutdown("gmail", func(ctx context.Context) error {
+		orderMutex.Lock()
+		defer orderMutex.Unlock()
+		order = append(order, "gmail")
+		return nil
+	})
+
+	// Start three emails of a batch; each logs a classification and an
+	// action once released
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		done, err := coordinator.Begin()
+		require.NoError(t, err)
+
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			defer done()
+			<-release
+			email := &types.Email{ID: id}
+			assert.NoError(t, auditLogger.LogClassification(context.Background(), email, &types.ClassificationResponse{EmailID: id, Action: "archive", Confidence: 0.8}))
+			assert.NoError(t, auditLogger.LogAction(context.Background(), email, "archive", "-INBOX"))
+		}(string(rune('a' + i)))
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- coordinator.Shutdown(context.Background())
+	}()
+
+	require.Eventually(t, coordinator.Stopping, time.Second, time.Millisecond)
+	_, err = coordinator.Begin()
+	assert.ErrorIs(t, err, ErrShuttingDown, "the rest of the batch must not start")
+
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown returned with work in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	wg.Wait()
+	require.NoError(t, <-shutdown)
+
+	assert.Equal(t, []string{"gmail", "ollama"}, order)
+	assert.NoError(t, coordinator.Shutdown(context.Background()), "shutdown is idempotent")
+
+	// Every entry of the drained work precedes the stop event and the chain
+	// verifies
+	entries := readAuditEntries(t, cfg.Directory)
+	require.Len(t, entries, 8, "genesis, three classifications, three actions and system stop")
+	assert.Equal(t, audit.EventSystemStop, entries[len(entries)-1].EventType)
+	assertChainVerifies(t, cfg)
+}
+
+func TestShutdownDeadlineAbandonsWork(t *testing.T) {
+	cfg := testAuditConfig(t.TempDir())
+	cfg.BufferedWrites = true
+	cfg.FlushInterval = time.Hour
+	auditLogger, err := audit.NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+
+	coordinator := NewCoordinator(auditLogger, testLogger())
+	closed := false
+	coordinator.OnShutdown("gmail", func(ctx context.Context) error {
+		closed = true
+		return nil
+	})
+
+	// A buffered entry from finished work and a stuck unit of work
+	require.NoError(t, auditLogger.LogAction(context.Background(), &types.Email{ID: "finished"}, "archive", "-INBOX"))
+	done, err := coordinator.Begin()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = coordinator.Shutdown(ctx)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Contains(t, err.Error(), "1 operations still in flight")
+	assert.True(t, closed, "resources are released even when work is abandoned")
+
+	// The abandoned work cannot append after the stop event
+	assert.ErrorIs(t, auditLogger.LogAction(context.Background(), &types.Email{ID: "late"}, "archive", "-INBOX"), audit.ErrClosed)
+	done()
+
+	entries := readAuditEntries(t, cfg.Directory)
+	require.Len(t, entries, 3, "genesis, the buffered action and system stop")
+	assert.Equal(t, "finished", entries[1].EmailID)
+	assert.Equal(t, audit.EventSystemStop, entries[2].EventType)
+	assertChainVerifies(t, cfg)
+}
+
+func TestShutdownReportsCloserErrors(t *testing.T) {
+	coordinator := NewCoordinator(nil, testLogger())
+	coordinator.OnShutdown("ollama", func(ctx context.Context) error {
+		return errors.New("connection reset")
+	})
+
+	err := coordinator.Shutdown(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to close ollama: connection reset")
+}
+
+func TestNilCoordinator(t *testing.T) {
+	var coordinator *Coordinator
+
+	done, err := coordinator.Begin()
+	require.NoError(t, err)
+	done()
+	assert.False(t, coordinator.Stopping())
+	assert.NoError(t, coordinator.Shutdown(context.Background()))
+}
+
+// Helper functions
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
+
+func testAuditConfig(dir string) *config.AuditConfig {
+	return &config.AuditConfig{
+		Enabled:        true,
+		Directory:      dir,
+		IntegrityCheck: true,
+	}
+}
+
+// readAuditEntries reads every entry of the single audit file in dir
+func readAuditEntries(t *testing.T, dir string) []audit.AuditEntry {
+	files, err := filepath.Glob(filepath.Join(dir, "audit_*.log"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	file, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer file.Close()
+
+	var entries []audit.AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry audit.AuditEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry), "every line is a whole entry")
+		entries = append(entries, entry)
+	}
+	require.NoError(t, scanner.Err())
+	return entries
+}
+
+// assertChainVerifies reopens the audit log and verifies its chain
+func assertChainVerifies(t *testing.T, cfg *config.AuditConfig) {
+	reopened, err := audit.NewLogger(cfg, testLogger())
+	require.NoError(t, err)
+	defer reopened.Close()
+	assert.NoError(t, reopened.VerifyAllChains())
+}
@@ -0,0 +1,125 @@
+// Package limit caps the number of operations a process runs at once
+// against a shared resource, such as inference on the LLM backend or
+// requests to the Gmail API. The caps are process-wide: every batch, worker
+// and client holding the same Limiter shares its permits.
+package limit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+// Limiter is a counting semaphore of permits. A nil Limiter imposes no cap.
+type Limiter struct {
+	permits chan struct{}
+}
+
+// New creates a limiter allowing capacity operations at once, or nil, for
+// no cap, when capacity is not positive
+func New(capacity int) *Limiter {
+	if capacity <= 0 {
+		return nil
+	}
+	return &Limiter{permits: make(chan struct{}, capacity)}
+}
+
+// Acquire blocks until a permit is free or ctx is done. Every successful
+// Acquire must be paired with a Release.
+func (l *Limiter) Acquire(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	select {
+	case l.permits <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release returns a permit taken by Acquire
+func (l *Limiter) Release() {
+	if l != nil {
+		<-l.permits
+	}
+}
+
+// Capacity returns the number of permits, zero when there is no cap
+func (l *Limiter) Capacity() int {
+	if l == nil {
+		return 0
+	}
+	return cap(l.permits)
+}
+
+// InFlight returns the number of permits currently held
+func (l *Limiter) InFlight() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.permits)
+}
+
+// Limits are the caps of a process, one per resource, configured under
+// concurrency
+type Limits struct {
+	// LLM caps classifications and embeddings running on the LLM backend
+	LLM *Limiter
+	// Gmail caps requests in flight to the Gmail API
+	Gmail *Limiter
+}
+
+// NewLimits creates the limiters of cfg
+func NewLimits(cfg config.ConcurrencyConfig) *Limits {
+	return &Limits{
+		LLM:   New(cfg.LLM),
+		Gmail: New(cfg.Gmail),
+	}
+}
+
+// Transport is an http.RoundTripper holding a permit of Limiter for each
+// request, from before it is sent until its response body is closed
+type Transport struct {
+	Base    http.RoundTripper
+	Limiter *Limiter
+}
+
+// RoundTrip sends the request once a permit is free
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if t.Limiter == nil {
+		return base.RoundTrip(req)
+	}
+
+	if err := t.Limiter.Acquire(req.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		t.Limiter.Release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: t.Limiter.Release}
+	return resp, nil
+}
+
+// releasingBody releases a permit when the response body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+// Close closes the body and releases its permit, once
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
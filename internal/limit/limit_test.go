@@ -0,0 +1,127 @@
+package limit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+func TestLimitsCapEachResourceUnderLoad(t *testing.T) {
+	limits := NewLimits(config.ConcurrencyConfig{LLM: 4, Gmail: 10})
+	llm := &concurrencyGauge{}
+	gmail := &concurrencyGauge{}
+
+	// Each batch classifies and fetches concurrently, as many batches do
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, limits.LLM.Acquire(context.Background()))
+			defer limits.LLM.Release()
+			llm.run(5 * time.Millisecond)
+		}()
+		go func() {
+			defer wg.Done()
+			require.NoError(t, limits.Gmail.Acquire(context.Background()))
+			defer limits.Gmail.Release()
+			gmail.run(5 * time.Millisecond)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 4, llm.peak(), "classifications never exceed their cap")
+	assert.Equal(t, 10, gmail.peak(), "fetches never exceed their cap, independently of classifications")
+	assert.Zero(t, limits.LLM.InFlight())
+	assert.Zero(t, limits.Gmail.InFlight())
+}
+
+func TestLimiterAcquireHonorsContext(t *testing.T) {
+	limiter := New(1)
+	require.NoError(t, limiter.Acquire(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, limiter.Acquire(ctx), context.DeadlineExceeded)
+
+	limiter.Release()
+	assert.NoError(t, limiter.Acquire(context.Background()))
+	limiter.Release()
+}
+
+func TestUncappedLimiter(t *testing.T) {
+	limiter := New(0)
+	assert.Nil(t, limiter)
+	assert.Zero(t, limiter.Capacity())
+	for i := 0; i < 100; i++ {
+		require.NoError(t, limiter.Acquire(context.Background()))
+	}
+	limiter.Release()
+	assert.Zero(t, limiter.InFlight())
+}
+
+func TestTransportHoldsPermitUntilBodyClosed(t *testing.T) {
+	gauge := &concurrencyGauge{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gauge.run(5 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	limiter := New(3)
+	client := &http.Client{Transport: &Transport{Limiter: limiter}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(server.URL)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, limiter.InFlight(), 3)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, "ok", string(body))
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, gauge.peak(), 3)
+	assert.Zero(t, limiter.InFlight(), "closing each body releases its permit")
+}
+
+// Helper functions
+
+// concurrencyGauge records the most operations it has seen running at once
+type concurrencyGauge struct {
+	current atomic.Int32
+	max     atomic.Int32
+}
+
+// run counts an operation in flight for duration
+func (g *concurrencyGauge) run(duration time.Duration) {
+	current := g.current.Add(1)
+	for {
+		max := g.max.Load()
+		if current <= max || g.max.CompareAndSwap(max, current) {
+			break
+		}
+	}
+	time.Sleep(duration)
+	g.current.Add(-1)
+}
+
+func (g *concurrencyGauge) peak() int {
+	return int(g.max.Load())
+}
@@ -0,0 +1,206 @@
+// Package links extracts the links of an email's plain text and HTML
+// bodies, so that conditions and the prompt can reason about where an
+// email leads instead of leaving the model to find URLs in raw text.
+//
+// Links in the HTML body keep their anchor text. An anchor text that reads
+// as a URL or a domain but names another host than the href leads to, such
+// as <a href="https://evil.example">https://paypal.com</a>, is flagged as a
+// mismatch, and links through URL shorteners are flagged since they hide
+// their destination.
+package links
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// MaxLinks is the number of links extracted per email
+const MaxLinks = 50
+
+// Shorteners are the hosts of common URL shortening services
+var Shorteners = []string{
+	"bit.ly", "bitly.com", "buff.ly", "cutt.ly", "goo.gl", "is.gd", "lnkd.in",
+	"ow.ly", "rb.gy", "rebrand.ly", "s.id", "shorturl.at", "t.co", "t.ly",
+	"tiny.cc", "tinyurl.com", "v.gd",
+}
+
+// urlPattern matches the http and https links of plain text
+var urlPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'()\[\]{}]+`)
+
+// domainPattern matches anchor text reading as a bare domain, optionally
+// followed by a path, such as "www.paypal.com/signin"
+var domainPattern = regexp.MustCompile(`(?i)^(?:www\.)?[\p{L}\p{N}-]+(?:\.[\p{L}\p{N}-]+)*\.[\p{L}]{2,}(?:[/?#]\S*)?$`)
+
+// Extract returns the distinct links of an email's HTML body, with their
+// anchor text, followed by the links of its plain text body not already
+// found, up to MaxLinks
+func Extract(email *types.Email) []types.Link {
+	var links []types.Link
+	seen := make(map[string]bool)
+	add := func(link types.Link) bool {
+		key := link.URL + "\x00" + link.Text
+		if seen[key] {
+			return true
+		}
+		if len(links) == MaxLinks {
+			return false
+		}
+		seen[key] = true
+		seen[link.URL] = true
+		links = append(links, link)
+		return true
+	}
+
+	for _, link := range anchors(email.BodyHTML) {
+		if !add(link) {
+			return links
+		}
+	}
+	for _, raw := range URLs(email.Body) {
+		if seen[raw] {
+			continue
+		}
+		if !add(NewLink(raw, "")) {
+			return links
+		}
+	}
+	return links
+}
+
+// Annotate sets the links of an email, replacing any it carries
+func Annotate(email *types.Email) {
+	email.URLs = Extract(email)
+}
+
+// URLs returns the http and https links of plain text, in order
+func URLs(text string) []string {
+	matches := urlPattern.FindAllString(text, -1)
+	for i, match := range matches {
+		matches[i] = strings.TrimRight(match, ".,;:!?")
+	}
+	return matches
+}
+
+// NewLink describes the link to raw with anchor text, which is "" outside
+// HTML
+func NewLink(raw, text string) types.Link {
+	link := types.Link{URL: raw, Host: Host(raw), Text: text}
+	link.Shortener = IsShortener(link.Host)
+	link.TextHost = textHost(text)
+	link.Mismatch = link.TextHost != "" && link.Host != "" && !sameSite(link.Host, link.TextHost)
+	return link
+}
+
+// Host returns the lowercased host of a URL, or "" when it has none
+func Host(raw string) string {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+}
+
+// IsShortener reports whether host is one of the Shorteners
+func IsShortener(host string) bool {
+	host = strings.TrimPrefix(host, "www.")
+	for _, shortener := range Shorteners {
+		if host == shortener {
+			return true
+		}
+	}
+	return false
+}
+
+// anchors returns the http and https links of an HTML body with their
+// anchor text
+func anchors(body string) []types.Link {
+	if body == "" {
+		return nil
+	}
+
+	var links []types.Link
+	var href string
+	var text strings.Builder
+	inAnchor := false
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if inAnchor {
+				links = appendAnchor(links, href, text.String())
+			}
+			return links
+		case html.StartTagToken:
+			token := tokenizer.Token()
+			if token.Data != "a" {
+				continue
+			}
+			if inAnchor {
+				links = appendAnchor(links, href, text.String())
+			}
+			inAnchor = true
+			href = attribute(token, "href")
+			text.Reset()
+		case html.EndTagToken:
+			if inAnchor && tokenizer.Token().Data == "a" {
+				links = appendAnchor(links, href, text.String())
+				inAnchor = false
+			}
+		case html.TextToken:
+			if inAnchor {
+				text.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+// appendAnchor adds the link of an anchor element to links when its href
+// is an http or https URL
+func appendAnchor(links []types.Link, href, text string) []types.Link {
+	href = strings.TrimSpace(href)
+	lower := strings.ToLower(href)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return links
+	}
+	return append(links, NewLink(href, strings.Join(strings.Fields(text), " ")))
+}
+
+// attribute returns the value of a token's attribute, or ""
+func attribute(token html.Token, name string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// textHost returns the host anchor text names when the whole text reads
+// as a URL or a domain, or ""
+func textHost(text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.ContainsAny(text, " \t\n") {
+		return ""
+	}
+	lower := strings.ToLower(text)
+	if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") {
+		return Host(text)
+	}
+	if domainPattern.MatchString(text) {
+		return Host("http://" + text)
+	}
+	return ""
+}
+
+// sameSite reports whether two hosts are the same site: equal once a www.
+// prefix is dropped, or one a subdomain of the other
+func sameSite(a, b string) bool {
+	a = strings.TrimPrefix(a, "www.")
+	b = strings.TrimPrefix(b, "www.")
+	return a == b || strings.HasSuffix(a, "."+b) || strings.HasSuffix(b, "."+a)
+}
@@ -0,0 +1,99 @@
+package links
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/testutil"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestExtractMismatchedAnchor(t *testing.T) {
+	email := testutil.LoadTestData(t).GetTestEmail("test-email-011")
+	require.NotNil(t, email)
+
+	links := Extract(email)
+	require.Len(t, links, 3)
+	assert.Equal(t, types.Link{
+		URL:      "https://paypal.com.account-verify.example/signin?id=8812",
+		Host:     "paypal.com.account-verify.example",
+		Text:     "https://www.paypal.com/signin",
+		TextHost: "www.paypal.com",
+		Mismatch: true,
+	}, links[0])
+	assert.Equal(t, "www.paypal.com", links[1].Host)
+	assert.Equal(t, "Help Center", links[1].Text)
+	assert.False(t, links[1].Mismatch, "anchor text that is not a URL cannot mismatch")
+	assert.Equal(t, types.Link{URL: "https://www.paypal.com/signin", Host: "www.paypal.com"}, links[2], "the plain text body shows the URL the anchor text claims")
+
+	Annotate(email)
+	assert.True(t, email.HasLinkTextMismatch())
+}
+
+func TestExtractShorteners(t *testing.T) {
+	email := testutil.LoadTestData(t).GetTestEmail("test-email-012")
+	require.NotNil(t, email)
+
+	links := Extract(email)
+	require.Len(t, links, 2)
+	assert.Equal(t, types.Link{URL: "https://bit.ly/3Pq7Xz", Host: "bit.ly", Shortener: true}, links[0])
+	assert.Equal(t, "https://tinyurl.com/parcel-8812", links[1].URL, "trailing punctuation is not part of the link")
+	assert.True(t, links[1].Shortener)
+	assert.False(t, email.HasLinkTextMismatch())
+}
+
+func TestAnchorText(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		textHost string
+		mismatch bool
+	}{
+		{"url text", `<a href="https://evil.example/x">https://paypal.com/login</a>`, "paypal.com", true},
+		{"domain text", `<a href="https://evil.example/x">PayPal.com</a>`, "paypal.com", true},
+		{"domain text with path", `<a href="https://evil.example/x">www.paypal.com/signin</a>`, "www.paypal.com", true},
+		{"lookalike suffix", `<a href="https://paypal.com.evil.example/">paypal.com</a>`, "paypal.com", true},
+		{"same host", `<a href="https://www.paypal.com/signin">paypal.com</a>`, "paypal.com", false},
+		{"subdomain", `<a href="https://login.paypal.com/">paypal.com</a>`, "paypal.com", false},
+		{"nested markup", `<a href="https://evil.example/"><b>paypal</b>.com</a>`, "paypal.com", true},
+		{"plain words", `<a href="https://evil.example/">Sign in now</a>`, "", false},
+		{"image", `<a href="https://evil.example/"><img src="logo.png"></a>`, "", false},
+		{"unclosed", `<a href="https://evil.example/">paypal.com`, "paypal.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			links := Extract(&types.Email{BodyHTML: tt.html})
+			require.Len(t, links, 1)
+			assert.Equal(t, tt.textHost, links[0].TextHost)
+			assert.Equal(t, tt.mismatch, links[0].Mismatch)
+		})
+	}
+}
+
+func TestExtractSkipsOtherSchemes(t *testing.T) {
+	email := &types.Email{
+		Body:     "Write to ftp://files.example or mailto:help@example.com",
+		BodyHTML: `<a href="mailto:help@example.com">help@example.com</a><a href="javascript:run()">https://paypal.com</a><a>no href</a>`,
+	}
+	assert.Empty(t, Extract(email))
+}
+
+func TestExtractLimit(t *testing.T) {
+	var body strings.Builder
+	for i := 0; i < MaxLinks+5; i++ {
+		fmt.Fprintf(&body, "https://example.com/%d ", i)
+	}
+	assert.Len(t, Extract(&types.Email{Body: body.String()}), MaxLinks)
+}
+
+func TestIsShortener(t *testing.T) {
+	assert.True(t, IsShortener("bit.ly"))
+	assert.True(t, IsShortener("www.tinyurl.com"))
+	assert.False(t, IsShortener("bit.ly.example"))
+	assert.False(t, IsShortener("example.com"))
+}
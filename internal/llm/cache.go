@@ -0,0 +1,203 @@
+package llm
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// MetadataCached is the response metadata key set on a result served from
+// the classification cache
+const MetadataCached = "cached"
+
+// DefaultCacheEntries is used when CacheConfig.MaxEntries is unset
+const DefaultCacheEntries = 10000
+
+// Cache stores classification results by CacheKey. Implementations must be
+// safe for concurrent use and may evict entries at any time.
+type Cache interface {
+	Get(key string) (*types.ClassificationResponse, bool)
+	Set(key string, result *types.ClassificationResponse)
+}
+
+// CacheKey identifies a classification by the profile ID and version and a
+// hash of the prompt rendered for the email, so that the same email under
+// the same profile version maps to the same key, and anything the prompt
+// shows of it, from its attachments and links to its thread, changes the
+// key. A profile whose version or prompt template changes gets new keys,
+// leaving its old entries to be evicted. It fails when the prompt cannot be
+// rendered.
+func CacheKey(profile *types.Profile, email *types.Email) (string, error) {
+	prompt, err := RenderPrompt(profile, email)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	for _, part := range []string{profile.ID, profile.Version, prompt} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// MemoryCache is an in-memory Cache holding at most a fixed number of
+// entries, evicting the least recently used
+type MemoryCache struct {
+	mutex      sync.Mutex
+	maxEntries int
+	order      *list.List // most recently used first
+	entries    map[string]*list.Element
+}
+
+// memoryEntry is the value of a MemoryCache list element
+type memoryEntry struct {
+	key    string
+	result *types.ClassificationResponse
+}
+
+// NewMemoryCache creates an in-memory cache of at most maxEntries results,
+// or DefaultCacheEntries when maxEntries is not positive
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheEntries
+	}
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the result cached under key, marking it recently used
+func (m *MemoryCache) Get(key string) (*types.ClassificationResponse, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	element, exists := m.entries[key]
+	if !exists {
+		return nil, false
+	}
+	m.order.MoveToFront(element)
+	return element.Value.(*memoryEntry).result, true
+}
+
+// Set caches result under key, evicting the least recently used entry when
+// the cache is full
+func (m *MemoryCache) Set(key string, result *types.ClassificationResponse) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if element, exists := m.entries[key]; exists {
+		element.Value.(*memoryEntry).result = result
+		m.order.MoveToFront(element)
+		return
+	}
+
+	m.entries[key] = m.order.PushFront(&memoryEntry{key: key, result: result})
+	if m.order.Len() > m.maxEntries {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+// Len returns the number of cached results
+func (m *MemoryCache) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.order.Len()
+}
+
+// CachingClassifier serves repeated classifications of an unchanged email
+// under an unchanged profile from a cache instead of the backend. Failed
+// classifications are never cached, and profiles setting cache: false are
+// always sent to the backend.
+type CachingClassifier struct {
+	Classifier
+	cache  Cache
+	audit  *audit.Logger
+	logger *logrus.Logger
+}
+
+// NewCachingClassifier wraps classifier with cache
+func NewCachingClassifier(classifier Classifier, cache Cache, logger *logrus.Logger) *CachingClassifier {
+	return &CachingClassifier{
+		Classifier: classifier,
+		cache:      cache,
+		logger:     logger,
+	}
+}
+
+// SetAuditLogger records results served from the cache in the audit log,
+// as the backend records the ones it classifies. A nil logger disables
+// auditing.
+func (c *CachingClassifier) SetAuditLogger(auditLogger *audit.Logger) {
+	c.audit = auditLogger
+}
+
+// ClassifyEmail returns the cached result for the email and profile, marked
+// with MetadataCached, or classifies it with the backend and caches the
+// result
+func (c *CachingClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	if profile.Cache != nil && !*profile.Cache {
+		return c.Classifier.ClassifyEmail(ctx, profile, email)
+	}
+
+	key, err := CacheKey(profile, email)
+	if err != nil {
+		// The backend renders the same prompt and reports the error
+		return c.Classifier.ClassifyEmail(ctx, profile, email)
+	}
+	if cached, exists := c.cache.Get(key); exists {
+		result := copyResult(cached)
+		result.EmailID = email.ID
+		result.Metadata[MetadataCached] = true
+
+		logging.FromContext(ctx, c.logger).WithFields(logrus.Fields{
+			"email_id":   email.ID,
+			"profile_id": profile.ID,
+		}).Debug("Serving classification from cache")
+		if c.audit != nil {
+			if err := c.audit.LogEmailClassification(ctx, email, result); err != nil {
+				logging.FromContext(ctx, c.logger).WithError(err).WithField("email_id", email.ID).Error("Failed to audit classification")
+			}
+		}
+		return result, nil
+	}
+
+	result, err := c.Classifier.ClassifyEmail(ctx, profile, email)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.Set(key, copyResult(result))
+	return result, nil
+}
+
+// Close closes the wrapped backend, if it can be closed
+func (c *CachingClassifier) Close() error {
+	if closer, ok := c.Classifier.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// copyResult copies a result and its metadata so that neither the cache nor
+// its callers see each other's changes
+func copyResult(result *types.ClassificationResponse) *types.ClassificationResponse {
+	copied := *result
+	copied.Labels = append([]string(nil), result.Labels...)
+	copied.Metadata = make(map[string]interface{}, len(result.Metadata)+1)
+	for key, value := range result.Metadata {
+		copied.Metadata[key] = value
+	}
+	return &copied
+}
@@ -0,0 +1,227 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestCachingClassifierServesUnchangedEmails(t *testing.T) {
+	backend := &countingClassifier{}
+	classifier := NewCachingClassifier(backend, NewMemoryCache(10), testLogger())
+	profile := &types.Profile{ID: "spam", Version: "1.0.0"}
+
+	first, err := classifier.ClassifyEmail(context.Background(), profile, cacheEmail("email-1"))
+	require.NoError(t, err)
+	assert.Nil(t, first.Metadata[MetadataCached])
+
+	// Same content under another ID, as when triage re-runs over an inbox
+	second, err := classifier.ClassifyEmail(context.Background(), profile, cacheEmail("email-2"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, backend.count(), "served from the cache")
+	assert.Equal(t, "email-2", second.EmailID)
+	assert.Equal(t, true, second.Metadata[MetadataCached])
+	assert.Equal(t, first.Action, second.Action)
+
+	// Callers changing a result do not change the cached one
+	second.Metadata["resolution"] = "changed"
+	third, err := classifier.ClassifyEmail(context.Background(), profile, cacheEmail("email-3"))
+	require.NoError(t, err)
+	assert.Nil(t, third.Metadata["resolution"])
+
+	changed := cacheEmail("email-4")
+	changed.Body = "A different body"
+	_, err = classifier.ClassifyEmail(context.Background(), profile, changed)
+	require.NoError(t, err)
+	assert.Equal(t, 2, backend.count(), "changed content is classified")
+}
+
+func TestCachingClassifierInvalidatesOnProfileVersion(t *testing.T) {
+	backend := &countingClassifier{}
+	classifier := NewCachingClassifier(backend, NewMemoryCache(10), testLogger())
+
+	for _, version := range []string{"1.0.0", "1.0.0", "1.1.0"} {
+		_, err := classifier.ClassifyEmail(context.Background(), &types.Profile{ID: "spam", Version: version}, cacheEmail("email-1"))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, backend.count())
+
+	_, err := classifier.ClassifyEmail(context.Background(), &types.Profile{ID: "newsletter", Version: "1.1.0"}, cacheEmail("email-1"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, backend.count(), "each profile has its own entries")
+}
+
+func TestCacheKeyCoversPrompt(t *testing.T) {
+	profile := &types.Profile{ID: "phishing", Version: "1.0.0"}
+	key := func(edit func(*types.Email)) string {
+		email := cacheEmail("email-1")
+		edit(email)
+		key, err := CacheKey(profile, email)
+		require.NoError(t, err)
+		return key
+	}
+	unchanged := key(func(*types.Email) {})
+
+	changes := map[string]func(*types.Email){
+		"attachment": func(email *types.Email) {
+			email.Attachments = []types.Attachment{{Filename: "invoice.pdf.exe", MimeType: "application/octet-stream"}}
+		},
+		"link": func(email *types.Email) {
+			email.URLs = []types.Link{{URL: "https://bit.ly/x", Host: "bit.ly", Shortener: true}}
+		},
+		"mismatched link": func(email *types.Email) {
+			email.URLs = []types.Link{{URL: "https://evil.example/", Host: "evil.example"}}
+			email.URLs[0].TextHost, email.URLs[0].Mismatch = "bank.example", true
+		},
+		"spoofed reply": func(email *types.Email) {
+			email.Reply = &types.ReplyAnalysis{Kind: types.ReplyKindReply, Spoofed: true, Reasons: []string{"it has no In-Reply-To or References header"}}
+		},
+	}
+	for name, change := range changes {
+		assert.NotEqual(t, unchanged, key(change), name)
+	}
+	assert.Equal(t, unchanged, key(func(email *types.Email) { email.ID = "email-2" }), "the ID is not in the prompt")
+	assert.Equal(t, unchanged, key(func(email *types.Email) {
+		email.ThreadID, email.InReplyTo = "thread-1", "<original@shop.example>"
+	}), "threading headers change the key through the reply analysis and thread they produce")
+
+	_, err := CacheKey(&types.Profile{ID: "broken", PromptTemplate: "{{.Email.Missing}}"}, cacheEmail("email-1"))
+	assert.Error(t, err)
+}
+
+func TestCachingClassifierSkipsFailuresAndOptedOutProfiles(t *testing.T) {
+	backend := &countingClassifier{err: fmt.Errorf("model unavailable")}
+	classifier := NewCachingClassifier(backend, NewMemoryCache(10), testLogger())
+	profile := &types.Profile{ID: "spam", Version: "1.0.0"}
+
+	for i := 0; i < 2; i++ {
+		_, err := classifier.ClassifyEmail(context.Background(), profile, cacheEmail("email-1"))
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 2, backend.count(), "failures are not cached")
+
+	backend.err = nil
+	disabled := false
+	profile.Cache = &disabled
+	for i := 0; i < 2; i++ {
+		_, err := classifier.ClassifyEmail(context.Background(), profile, cacheEmail("email-1"))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 4, backend.count(), "cache: false always classifies")
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewMemoryCache(2)
+	cache.Set("a", &types.ClassificationResponse{Action: "archive"})
+	cache.Set("b", &types.ClassificationResponse{Action: "keep"})
+	_, _ = cache.Get("a")
+	cache.Set("c", &types.ClassificationResponse{Action: "delete"})
+
+	assert.Equal(t, 2, cache.Len())
+	_, exists := cache.Get("b")
+	assert.False(t, exists, "b was least recently used")
+	result, exists := cache.Get("a")
+	require.True(t, exists)
+	assert.Equal(t, "archive", result.Action)
+
+	assert.Equal(t, DefaultCacheEntries, NewMemoryCache(0).maxEntries)
+}
+
+func BenchmarkCachingClassifierHit(b *testing.B) {
+	classifier := NewCachingClassifier(&countingClassifier{}, NewMemoryCache(0), testLogger())
+	profile := &types.Profile{ID: "spam", Version: "1.0.0"}
+	email := cacheEmail("email-1")
+	if _, err := classifier.ClassifyEmail(context.Background(), profile, email); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := classifier.ClassifyEmail(context.Background(), profile, email); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCachingClassifierMiss classifies a new email each iteration
+// against a backend answering in a millisecond, for comparison with the hit
+// path
+func BenchmarkCachingClassifierMiss(b *testing.B) {
+	classifier := NewCachingClassifier(&countingClassifier{latency: time.Millisecond}, NewMemoryCache(0), testLogger())
+	profile := &types.Profile{ID: "spam", Version: "1.0.0"}
+
+	for i := 0; i < b.N; i++ {
+		email := cacheEmail(fmt.Sprintf("email-%d", i))
+		email.Body = email.ID
+		if _, err := classifier.ClassifyEmail(context.Background(), profile, email); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Helper functions
+
+// countingClassifier archives every email after the configured latency,
+// counting the calls it receives
+type countingClassifier struct {
+	mutex   sync.Mutex
+	calls   int
+	err     error
+	latency time.Duration
+}
+
+func (c *countingClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	c.mutex.Lock()
+	c.calls++
+	c.mutex.Unlock()
+
+	time.Sleep(c.latency)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &types.ClassificationResponse{
+		EmailID:    email.ID,
+		ProfileID:  profile.ID,
+		Action:     "archive",
+		Confidence: 0.8,
+		Metadata:   map[string]interface{}{MetadataServedByModel: "qwen2.5:7b"},
+	}, nil
+}
+
+func (c *countingClassifier) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
+func (c *countingClassifier) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	return nil, nil
+}
+
+func (c *countingClassifier) count() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.calls
+}
+
+func cacheEmail(id string) *types.Email {
+	return &types.Email{
+		ID:      id,
+		Subject: "Flash sale",
+		From:    "deals@shop.example",
+		To:      []string{"me@example.com"},
+		Body:    "Everything half price today only.",
+	}
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
@@ -0,0 +1,106 @@
+package llm
+
+import (
+	"context"
+	"io"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// MetadataSystemMail is the response metadata key set to true on the
+// results of emails with an empty body handled as system mail
+const MetadataSystemMail = "system_mail"
+
+// MarkEmptyBody sets types.MetadataEmptyBody on the result of an email
+// whose body is empty or nearly so, which the model classified from its
+// subject and headers alone, and caps its confidence at the limit of a
+// profile setting empty_body
+func MarkEmptyBody(profile *types.Profile, email *types.Email, result *types.ClassificationResponse) {
+	if !email.EmptyBody {
+		return
+	}
+	if result.Metadata == nil {
+		result.Metadata = make(map[string]interface{})
+	}
+	result.Metadata[types.MetadataEmptyBody] = true
+	if limit, capped := profile.EmptyBody.ConfidenceLimit(); capped && result.Confidence > limit {
+		result.Confidence = limit
+	}
+}
+
+// SystemMailClassifier handles the emails with an empty body of profiles
+// whose empty_body sets an action as system mail: they are given the
+// action without prompting the model, flagged with types.MetadataEmptyBody
+// and MetadataSystemMail. Other emails are classified unchanged.
+type SystemMailClassifier struct {
+	Classifier
+	audit  *audit.Logger
+	clock  clock.Clock
+	logger *logrus.Logger
+}
+
+// NewSystemMailClassifier wraps classifier
+func NewSystemMailClassifier(classifier Classifier, logger *logrus.Logger) *SystemMailClassifier {
+	return &SystemMailClassifier{
+		Classifier: classifier,
+		clock:      clock.Real{},
+		logger:     logger,
+	}
+}
+
+// SetClock sets the clock system mail results' ProcessedAt is read from
+func (s *SystemMailClassifier) SetClock(clk clock.Clock) {
+	s.clock = clk
+}
+
+// SetAuditLogger records system mail results in the audit log, which the
+// backend did not record. A nil logger disables auditing.
+func (s *SystemMailClassifier) SetAuditLogger(auditLogger *audit.Logger) {
+	s.audit = auditLogger
+}
+
+// ClassifyEmail classifies the email, or handles it as system mail when
+// its body is empty and the profile says so
+func (s *SystemMailClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	if !email.EmptyBody || !profile.EmptyBody.SystemMail() {
+		return s.Classifier.ClassifyEmail(ctx, profile, email)
+	}
+
+	result := &types.ClassificationResponse{
+		EmailID:     email.ID,
+		ProfileID:   profile.ID,
+		Action:      profile.EmptyBody.Action,
+		Confidence:  profile.EmptyBody.SystemMailConfidence(),
+		Reasoning:   "Empty body, handled as system mail",
+		ProcessedAt: s.clock.Now(),
+		Metadata: map[string]interface{}{
+			types.MetadataEmptyBody: true,
+			MetadataSystemMail:      true,
+		},
+	}
+
+	logging.FromContext(ctx, s.logger).WithFields(logrus.Fields{
+		"email_id":   email.ID,
+		"profile_id": profile.ID,
+		"action":     result.Action,
+	}).Debug("Handled email with an empty body as system mail")
+	if s.audit != nil {
+		if err := s.audit.LogEmailClassification(ctx, email, result); err != nil {
+			logging.FromContext(ctx, s.logger).WithError(err).WithField("email_id", email.ID).Error("Failed to audit classification")
+		}
+	}
+	return result, nil
+}
+
+// Close closes the wrapped backend, if it can be closed
+func (s *SystemMailClassifier) Close() error {
+	if closer, ok := s.Classifier.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestMarkEmptyBody(t *testing.T) {
+	email := &types.Email{Subject: "Delivery Status Notification (Failure)", Headers: map[string]string{"Auto-Submitted": "auto-replied"}}
+	result := &types.ClassificationResponse{Action: "archive", Confidence: 0.9}
+	MarkEmptyBody(&types.Profile{}, email, result)
+	assert.Nil(t, result.Metadata, "emails not flagged are not marked")
+	assert.Equal(t, 0.9, result.Confidence)
+	assert.NotContains(t, BuildPrompt(&types.Profile{}, email), "Header:")
+
+	email.EmptyBody = true
+	MarkEmptyBody(&types.Profile{}, email, result)
+	assert.Equal(t, true, result.Metadata[types.MetadataEmptyBody])
+	assert.Equal(t, 0.9, result.Confidence, "profiles without empty_body leave the confidence uncapped")
+	assert.Contains(t, BuildPrompt(&types.Profile{}, email), "Header: \"Auto-Submitted: auto-replied\"\nBody (empty or nearly so, classify from the subject, headers and attachments): \n")
+	assert.True(t, NewPromptData(&types.Profile{}, email).Email.EmptyBody)
+
+	defaulted := &types.ClassificationResponse{Action: "archive", Confidence: 0.9}
+	MarkEmptyBody(&types.Profile{EmptyBody: &types.EmptyBodyConfig{}}, email, defaulted)
+	assert.Equal(t, types.DefaultEmptyBodyMaxConfidence, defaulted.Confidence)
+
+	capped := &types.ClassificationResponse{Action: "archive", Confidence: 0.9}
+	MarkEmptyBody(&types.Profile{EmptyBody: &types.EmptyBodyConfig{MaxConfidence: 0.3}}, email, capped)
+	assert.Equal(t, 0.3, capped.Confidence)
+
+	low := &types.ClassificationResponse{Action: "archive", Confidence: 0.2}
+	MarkEmptyBody(&types.Profile{EmptyBody: &types.EmptyBodyConfig{}}, email, low)
+	assert.Equal(t, 0.2, low.Confidence, "lower confidences are kept")
+}
+
+func TestSystemMailClassifier(t *testing.T) {
+	backend := &countingClassifier{}
+	classifier := NewSystemMailClassifier(backend, testLogger())
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	classifier.SetClock(clock.NewFake(now))
+	systemMail := &types.Profile{ID: "system", Version: "1.0.0", EmptyBody: &types.EmptyBodyConfig{Action: "archive", Confidence: 0.7}}
+
+	empty := cacheEmail("email-1")
+	empty.Body = ""
+	empty.EmptyBody = true
+	result, err := classifier.ClassifyEmail(context.Background(), systemMail, empty)
+	require.NoError(t, err)
+	assert.Zero(t, backend.calls, "system mail is not sent to the backend")
+	assert.Equal(t, "email-1", result.EmailID)
+	assert.Equal(t, "system", result.ProfileID)
+	assert.Equal(t, "archive", result.Action)
+	assert.Equal(t, 0.7, result.Confidence)
+	assert.Equal(t, now, result.ProcessedAt)
+	assert.Equal(t, true, result.Metadata[types.MetadataEmptyBody])
+	assert.Equal(t, true, result.Metadata[MetadataSystemMail])
+
+	_, err = classifier.ClassifyEmail(context.Background(), systemMail, cacheEmail("email-2"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, backend.calls, "emails with a body are classified")
+
+	_, err = classifier.ClassifyEmail(context.Background(), &types.Profile{ID: "phishing", EmptyBody: &types.EmptyBodyConfig{MaxConfidence: 0.4}}, empty)
+	require.NoError(t, err)
+	assert.Equal(t, 2, backend.calls, "profiles without an action classify empty emails")
+
+	assert.Equal(t, 1.0, (&types.EmptyBodyConfig{Action: "archive"}).SystemMailConfidence())
+}
@@ -0,0 +1,124 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/sony/gobreaker"
+)
+
+// Sentinel errors returned (wrapped) by every backend so callers can decide
+// whether to retry with errors.Is
+var (
+	// ErrCircuitOpen is returned when the circuit breaker rejects a request
+	ErrCircuitOpen = errors.New("circuit breaker open")
+
+	// ErrModelNotFound is returned when the backend does not have the
+	// requested model
+	ErrModelNotFound = errors.New("model not found")
+
+	// ErrInvalidResponse is returned when the model output cannot be parsed
+	// into a valid classification
+	ErrInvalidResponse = errors.New("invalid model response")
+
+	// ErrTruncatedResponse is returned, along with ErrInvalidResponse, when
+	// the model output was cut off before its JSON was complete, usually
+	// because the profile's max_tokens is too small
+	ErrTruncatedResponse = errors.New("truncated model response")
+
+	// ErrEmptyResponse is returned, along with ErrInvalidResponse, when the
+	// model produced no output at all without reaching the token limit
+	ErrEmptyResponse = errors.New("empty model response")
+
+	// ErrTimeout is returned when a request exceeds its deadline
+	ErrTimeout = errors.New("request timed out")
+
+	// ErrRetryQueueFull is returned, along with ErrCircuitOpen, when a
+	// request rejected by the circuit breaker cannot wait for it because
+	// the retry queue is full
+	ErrRetryQueueFull = errors.New("retry queue full")
+
+	// ErrPromptTooLarge is returned when the assembled prompt exceeds the
+	// configured size limit, before anything is sent to the backend
+	ErrPromptTooLarge = errors.New("prompt too large")
+)
+
+// APIError is returned when a backend responds with an unexpected HTTP status
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Body)
+}
+
+// ResponseError is returned, wrapping ErrInvalidResponse, when the model
+// output cannot be parsed into a classification. It carries the exact
+// output, so that prompt and parsing problems of a flaky model can be
+// diagnosed from the error alone.
+type ResponseError struct {
+	Err      error
+	Response string
+}
+
+// maxErrorResponse caps the bytes of the output quoted in a ResponseError
+// message, which is logged and audited; Response keeps all of it
+const maxErrorResponse = 256
+
+// Error implements the error interface
+func (e *ResponseError) Error() string {
+	if len(e.Response) > maxErrorResponse {
+		quoted := truncateUTF8(e.Response, maxErrorResponse)
+		return fmt.Sprintf("%v (raw response: %q, %d more bytes)", e.Err, quoted, len(e.Response)-len(quoted))
+	}
+	return fmt.Sprintf("%v (raw response: %q)", e.Err, e.Response)
+}
+
+// Unwrap returns the parse failure
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether a failed request may succeed if retried later.
+// Timeouts, an open circuit breaker and server-side errors are transient;
+// missing models and unparseable responses are not.
+func IsRetryable(err error) bool {
+	if errors.Is(err, ErrTimeout) || errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	return false
+}
+
+// IsModelUnavailable reports whether err is an infrastructure failure that
+// another model may be able to serve
+func IsModelUnavailable(err error) bool {
+	return errors.Is(err, ErrModelNotFound) || errors.Is(err, ErrCircuitOpen)
+}
+
+// WrapBreakerError maps circuit breaker rejections to ErrCircuitOpen
+func WrapBreakerError(err error) error {
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		return fmt.Errorf("%w: %w", ErrCircuitOpen, err)
+	}
+	return err
+}
+
+// WrapTransportError maps deadline and network timeouts to ErrTimeout
+func WrapTransportError(err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
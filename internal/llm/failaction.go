@@ -0,0 +1,98 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// FailActionClassifier stands in for classifications that failed for good
+// with a zero-confidence result carrying the configured fail action,
+// flagged with MetadataClassificationFailed, so that an email the backend
+// could not classify, possibly phishing, is still routed, typically to a
+// human, rather than skipped. Classifications cut short by their context
+// still fail, as do those of profiles without a fail action when it is not
+// enabled globally.
+type FailActionClassifier struct {
+	Classifier
+	config config.FailActionConfig
+	audit  *audit.Logger
+	clock  clock.Clock
+	logger *logrus.Logger
+}
+
+// NewFailActionClassifier wraps classifier with the fail action of cfg
+func NewFailActionClassifier(classifier Classifier, cfg config.FailActionConfig, logger *logrus.Logger) *FailActionClassifier {
+	return &FailActionClassifier{
+		Classifier: classifier,
+		config:     cfg,
+		clock:      clock.Real{},
+		logger:     logger,
+	}
+}
+
+// SetClock sets the clock the stand-in results' ProcessedAt is read from
+func (f *FailActionClassifier) SetClock(clk clock.Clock) {
+	f.clock = clk
+}
+
+// SetAuditLogger records the results standing in for failed
+// classifications in the audit log, which the backend did not record. A nil
+// logger disables auditing.
+func (f *FailActionClassifier) SetAuditLogger(auditLogger *audit.Logger) {
+	f.audit = auditLogger
+}
+
+// ClassifyEmail classifies the email, returning the fail action's result
+// when the classification fails and the profile has a fail action
+func (f *FailActionClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	result, err := f.Classifier.ClassifyEmail(ctx, profile, email)
+	if err == nil || ctx.Err() != nil {
+		return result, err
+	}
+	action := f.config.ActionFor(profile.FailAction)
+	if action == "" {
+		return nil, err
+	}
+
+	result = &types.ClassificationResponse{
+		EmailID:     email.ID,
+		ProfileID:   profile.ID,
+		Action:      action,
+		Confidence:  0,
+		Reasoning:   fmt.Sprintf("Classification failed, applying fail action: %v", err),
+		ProcessedAt: f.clock.Now(),
+		Metadata: map[string]interface{}{
+			types.MetadataClassificationFailed: true,
+			types.MetadataClassificationError:  err.Error(),
+		},
+	}
+
+	logging.FromContext(ctx, f.logger).WithError(err).WithFields(logrus.Fields{
+		"email_id":    email.ID,
+		"profile_id":  profile.ID,
+		"fail_action": action,
+	}).Warn("Classification failed, applying fail action")
+	if f.audit != nil {
+		if err := f.audit.LogEmailClassification(ctx, email, result); err != nil {
+			logging.FromContext(ctx, f.logger).WithError(err).WithField("email_id", email.ID).Error("Failed to audit classification")
+		}
+	}
+	return result, nil
+}
+
+// Close closes the wrapped backend, if it can be closed
+func (f *FailActionClassifier) Close() error {
+	if closer, ok := f.Classifier.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
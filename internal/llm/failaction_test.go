@@ -0,0 +1,81 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestFailActionClassifier(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        config.FailActionConfig
+		profileAction string
+		wantAction    string
+	}{
+		{name: "disabled", config: config.FailActionConfig{Action: "review"}},
+		{name: "enabled globally", config: config.FailActionConfig{Enabled: true, Action: "quarantine"}, wantAction: "quarantine"},
+		{name: "enabled without action", config: config.FailActionConfig{Enabled: true}, wantAction: config.DefaultFailAction},
+		{name: "profile opts in", profileAction: "review", wantAction: "review"},
+		{name: "profile overrides global", config: config.FailActionConfig{Enabled: true, Action: "quarantine"}, profileAction: "none", wantAction: "none"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &countingClassifier{err: fmt.Errorf("classification request failed: %w", ErrModelNotFound)}
+			classifier := NewFailActionClassifier(backend, tt.config, testLogger())
+			now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+			classifier.SetClock(clock.NewFake(now))
+			profile := &types.Profile{ID: "phishing", Version: "1.0.0", FailAction: tt.profileAction}
+
+			result, err := classifier.ClassifyEmail(context.Background(), profile, cacheEmail("email-1"))
+			if tt.wantAction == "" {
+				assert.ErrorIs(t, err, ErrModelNotFound)
+				assert.Nil(t, result)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantAction, result.Action)
+			assert.Equal(t, "email-1", result.EmailID)
+			assert.Equal(t, "phishing", result.ProfileID)
+			assert.Zero(t, result.Confidence)
+			assert.Equal(t, now, result.ProcessedAt)
+			assert.Equal(t, true, result.Metadata[types.MetadataClassificationFailed])
+			assert.Contains(t, result.Metadata[types.MetadataClassificationError], "model not found")
+		})
+	}
+}
+
+func TestFailActionClassifierPassesThrough(t *testing.T) {
+	enabled := config.FailActionConfig{Enabled: true, Action: "review"}
+	profile := &types.Profile{ID: "phishing", Version: "1.0.0"}
+
+	t.Run("successful classifications", func(t *testing.T) {
+		classifier := NewFailActionClassifier(&countingClassifier{}, enabled, testLogger())
+
+		result, err := classifier.ClassifyEmail(context.Background(), profile, cacheEmail("email-1"))
+		require.NoError(t, err)
+		assert.Equal(t, "archive", result.Action)
+		assert.Nil(t, result.Metadata[types.MetadataClassificationFailed])
+	})
+
+	t.Run("cancelled classifications", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		classifier := NewFailActionClassifier(&countingClassifier{err: context.Canceled}, enabled, testLogger())
+
+		result, err := classifier.ClassifyEmail(ctx, profile, cacheEmail("email-1"))
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Nil(t, result)
+	})
+}
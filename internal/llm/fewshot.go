@@ -0,0 +1,243 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/expr"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// MetadataFewShotSelected is the response metadata key listing the names of
+// the few-shot examples selected for the prompt
+const MetadataFewShotSelected = "fewshot_selected"
+
+// Embedder is a backend able to embed text for similarity search
+type Embedder interface {
+	// Embed returns one embedding per text, in order
+	Embed(ctx context.Context, model string, texts []string) ([][]float64, error)
+}
+
+// FewShotClassifier includes only the few-shot examples most similar to the
+// email in the prompt of profiles setting fewshot_selection. Example
+// embeddings are computed once per set of examples; when embedding fails,
+// every example is included.
+type FewShotClassifier struct {
+	Classifier
+	embedder Embedder
+	logger   *logrus.Logger
+
+	mutex    sync.Mutex
+	examples map[string][][]float64
+}
+
+// NewFewShotClassifier wraps classifier with few-shot selection using
+// embedder
+func NewFewShotClassifier(classifier Classifier, embedder Embedder, logger *logrus.Logger) *FewShotClassifier {
+	return &FewShotClassifier{
+		Classifier: classifier,
+		embedder:   embedder,
+		logger:     logger,
+		examples:   make(map[string][][]float64),
+	}
+}
+
+// ClassifyEmail classifies the email with the profile's few-shot examples
+// narrowed to the selected ones, which are listed under
+// MetadataFewShotSelected. Only the examples that apply to the email are
+// candidates.
+func (f *FewShotClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	selection := profile.FewShotSelection
+	if selection == nil || len(profile.FewShot) <= selection.TopK {
+		return f.Classifier.ClassifyEmail(ctx, profile, email)
+	}
+	if applicable := ApplicableExamples(profile, email); len(applicable) < len(profile.FewShot) {
+		if len(applicable) <= selection.TopK {
+			return f.Classifier.ClassifyEmail(ctx, profile, email)
+		}
+		candidates := *profile
+		candidates.FewShot = applicable
+		profile = &candidates
+	}
+
+	selected, err := f.SelectExamples(ctx, profile, email)
+	if err != nil {
+		logging.FromContext(ctx, f.logger).WithError(err).WithFields(logrus.Fields{
+			"email_id":   email.ID,
+			"profile_id": profile.ID,
+		}).Warn("Few-shot selection failed, including every example")
+		return f.Classifier.ClassifyEmail(ctx, profile, email)
+	}
+
+	narrowed := *profile
+	narrowed.FewShot = selected
+	result, err := f.Classifier.ClassifyEmail(ctx, &narrowed, email)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(selected))
+	for i, example := range selected {
+		names[i] = example.Name
+	}
+	if result.Metadata == nil {
+		result.Metadata = make(map[string]interface{})
+	}
+	result.Metadata[MetadataFewShotSelected] = names
+	return result, nil
+}
+
+// SelectExamples returns the profile's top_k few-shot examples most similar
+// to the email, in their order in the profile
+func (f *FewShotClassifier) SelectExamples(ctx context.Context, profile *types.Profile, email *types.Email) ([]types.FewShotExample, error) {
+	selection := profile.FewShotSelection
+	examples, err := f.exampleEmbeddings(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	embedded, err := f.embedder.Embed(ctx, selection.Model, []string{emailText(email)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed email: %w", err)
+	}
+	if len(embedded) != 1 {
+		return nil, fmt.Errorf("failed to embed email: got %d embeddings", len(embedded))
+	}
+
+	ranked := make([]int, len(examples))
+	similarities := make([]float64, len(examples))
+	for i, example := range examples {
+		ranked[i] = i
+		similarities[i] = cosineSimilarity(embedded[0], example)
+	}
+	sort.SliceStable(ranked, func(a, b int) bool {
+		return similarities[ranked[a]] > similarities[ranked[b]]
+	})
+
+	top := ranked[:selection.TopK]
+	sort.Ints(top)
+	selected := make([]types.FewShotExample, len(top))
+	for i, index := range top {
+		selected[i] = profile.FewShot[index]
+	}
+	return selected, nil
+}
+
+// fewShotPrograms caches compiled few-shot example conditions by source
+var fewShotPrograms sync.Map
+
+// ApplicableExamples returns the profile's few-shot examples without a
+// condition or whose condition holds for the email, in their order in the
+// profile. Conditions are evaluated against the email, as email; one that
+// fails to evaluate excludes its example.
+func ApplicableExamples(profile *types.Profile, email *types.Email) []types.FewShotExample {
+	conditional := false
+	for _, example := range profile.FewShot {
+		if example.When != "" {
+			conditional = true
+			break
+		}
+	}
+	if !conditional {
+		return profile.FewShot
+	}
+
+	env := expr.Env{"email": email}
+	var applicable []types.FewShotExample
+	for _, example := range profile.FewShot {
+		if example.When == "" || exampleApplies(example.When, env) {
+			applicable = append(applicable, example)
+		}
+	}
+	return applicable
+}
+
+// exampleApplies evaluates a few-shot example condition, compiling it on
+// first use
+func exampleApplies(source string, env expr.Env) bool {
+	cached, exists := fewShotPrograms.Load(source)
+	if !exists {
+		program, err := expr.Compile(source)
+		if err != nil {
+			return false
+		}
+		cached, _ = fewShotPrograms.LoadOrStore(source, program)
+	}
+	applies, err := cached.(*expr.Program).EvalBool(env)
+	return err == nil && applies
+}
+
+// Close closes the wrapped backend, if it can be closed
+func (f *FewShotClassifier) Close() error {
+	if closer, ok := f.Classifier.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// exampleEmbeddings returns the embeddings of a profile's few-shot example
+// inputs, embedding them on first use. They are keyed by the model and the
+// examples themselves, so that edited examples are embedded again.
+func (f *FewShotClassifier) exampleEmbeddings(ctx context.Context, profile *types.Profile) ([][]float64, error) {
+	hash := sha256.New()
+	hash.Write([]byte(profile.FewShotSelection.Model))
+	texts := make([]string, len(profile.FewShot))
+	for i, example := range profile.FewShot {
+		texts[i] = example.Input
+		hash.Write([]byte{0})
+		hash.Write([]byte(example.Input))
+	}
+	key := hex.EncodeToString(hash.Sum(nil))
+
+	f.mutex.Lock()
+	cached, exists := f.examples[key]
+	f.mutex.Unlock()
+	if exists {
+		return cached, nil
+	}
+
+	embeddings, err := f.embedder.Embed(ctx, profile.FewShotSelection.Model, texts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed few-shot examples: %w", err)
+	}
+	if len(embeddings) != len(texts) {
+		return nil, fmt.Errorf("failed to embed few-shot examples: got %d embeddings for %d examples", len(embeddings), len(texts))
+	}
+
+	f.mutex.Lock()
+	f.examples[key] = embeddings
+	f.mutex.Unlock()
+	return embeddings, nil
+}
+
+// emailText is the text of an email compared with the example inputs
+func emailText(email *types.Email) string {
+	return "Subject: " + email.Subject + "\nFrom: " + email.From + "\nBody: " + email.Body
+}
+
+// cosineSimilarity returns the cosine of the angle between two vectors, or 0
+// when either is zero or their lengths differ
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
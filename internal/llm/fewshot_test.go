@@ -0,0 +1,160 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestFewShotClassifierSelectsTopK(t *testing.T) {
+	backend := &recordingClassifier{}
+	embedder := &keywordEmbedder{}
+	classifier := NewFewShotClassifier(backend, embedder, testLogger())
+
+	profile := fewShotProfile(2)
+	email := &types.Email{ID: "email-1", Subject: "Invoice overdue", From: "billing@vendor.example", Body: "Your invoice payment is overdue."}
+
+	result, err := classifier.ClassifyEmail(context.Background(), profile, email)
+	require.NoError(t, err)
+
+	assert.Equal(t, [][]string{{"invoice", "payment"}}, backend.examples(), "the two billing examples are chosen, in profile order")
+	assert.Equal(t, []string{"invoice", "payment"}, result.Metadata[MetadataFewShotSelected])
+	assert.Len(t, profile.FewShot, 4, "the profile itself is not narrowed")
+
+	_, err = classifier.ClassifyEmail(context.Background(), profile, email)
+	require.NoError(t, err)
+	assert.Equal(t, 3, embedder.count(), "example embeddings are computed once")
+}
+
+func TestFewShotClassifierSelectsAmongApplicableExamples(t *testing.T) {
+	backend := &recordingClassifier{}
+	classifier := NewFewShotClassifier(backend, &keywordEmbedder{}, testLogger())
+
+	profile := fewShotProfile(1)
+	profile.FewShot[0].When = "email.from contains 'shop.example'"
+	email := &types.Email{ID: "email-1", Subject: "Invoice overdue", From: "billing@vendor.example", Body: "Your invoice payment is overdue."}
+
+	result, err := classifier.ClassifyEmail(context.Background(), profile, email)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"payment"}}, backend.examples(), "the inapplicable invoice example is never a candidate")
+	assert.Equal(t, []string{"payment"}, result.Metadata[MetadataFewShotSelected])
+}
+
+func TestFewShotClassifierFallsBackToAllExamples(t *testing.T) {
+	tests := []struct {
+		name     string
+		profile  *types.Profile
+		embedder *keywordEmbedder
+	}{
+		{"embeddings unavailable", fewShotProfile(2), &keywordEmbedder{err: errors.New("connection refused")}},
+		{"selection not configured", fewShotProfile(0), &keywordEmbedder{}},
+		{"top_k covers every example", fewShotProfile(4), &keywordEmbedder{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &recordingClassifier{}
+			classifier := NewFewShotClassifier(backend, tt.embedder, testLogger())
+
+			result, err := classifier.ClassifyEmail(context.Background(), tt.profile, &types.Email{ID: "email-1", Subject: "Invoice"})
+			require.NoError(t, err)
+
+			assert.Equal(t, [][]string{{"invoice", "newsletter", "payment", "sale"}}, backend.examples())
+			assert.NotContains(t, result.Metadata, MetadataFewShotSelected)
+		})
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	assert.InDelta(t, 1.0, cosineSimilarity([]float64{1, 2}, []float64{2, 4}), 1e-9)
+	assert.InDelta(t, 0.0, cosineSimilarity([]float64{1, 0}, []float64{0, 1}), 1e-9)
+	assert.Zero(t, cosineSimilarity([]float64{0, 0}, []float64{1, 1}), "a zero vector is similar to nothing")
+	assert.Zero(t, cosineSimilarity([]float64{1}, []float64{1, 1}), "lengths differ")
+}
+
+// Helper functions
+
+// fewShotKeywords are the dimensions of keywordEmbedder's embeddings
+var fewShotKeywords = []string{"invoice", "payment", "newsletter", "sale"}
+
+// keywordEmbedder embeds a text as the count of each of fewShotKeywords in
+// it, counting its calls
+type keywordEmbedder struct {
+	mutex sync.Mutex
+	calls int
+	err   error
+}
+
+func (k *keywordEmbedder) Embed(ctx context.Context, model string, texts []string) ([][]float64, error) {
+	k.mutex.Lock()
+	k.calls++
+	k.mutex.Unlock()
+
+	if k.err != nil {
+		return nil, k.err
+	}
+	embeddings := make([][]float64, len(texts))
+	for i, text := range texts {
+		embeddings[i] = make([]float64, len(fewShotKeywords))
+		for j, keyword := range fewShotKeywords {
+			embeddings[i][j] = float64(strings.Count(strings.ToLower(text), keyword))
+		}
+	}
+	return embeddings, nil
+}
+
+func (k *keywordEmbedder) count() int {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.calls
+}
+
+// recordingClassifier archives every email, recording the names of the
+// few-shot examples in each profile it classifies with
+type recordingClassifier struct {
+	countingClassifier
+	mutex sync.Mutex
+	names [][]string
+}
+
+func (r *recordingClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	names := make([]string, len(profile.FewShot))
+	for i, example := range profile.FewShot {
+		names[i] = example.Name
+	}
+	r.mutex.Lock()
+	r.names = append(r.names, names)
+	r.mutex.Unlock()
+	return r.countingClassifier.ClassifyEmail(ctx, profile, email)
+}
+
+func (r *recordingClassifier) examples() [][]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([][]string(nil), r.names...)
+}
+
+// fewShotProfile returns a profile with four examples selecting the topK
+// most similar, or none when topK is zero
+func fewShotProfile(topK int) *types.Profile {
+	profile := &types.Profile{
+		ID: "billing",
+		FewShot: []types.FewShotExample{
+			{Name: "invoice", Input: "Subject: Invoice attached", Output: `{"action": "label"}`},
+			{Name: "newsletter", Input: "Subject: Weekly newsletter", Output: `{"action": "archive"}`},
+			{Name: "payment", Input: "Subject: Payment received for invoice", Output: `{"action": "label"}`},
+			{Name: "sale", Input: "Subject: Flash sale", Output: `{"action": "archive"}`},
+		},
+	}
+	if topK > 0 {
+		profile.FewShotSelection = &types.FewShotSelection{TopK: topK, Model: "nomic-embed-text"}
+	}
+	return profile
+}
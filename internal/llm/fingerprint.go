@@ -0,0 +1,87 @@
+package llm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// NewFingerprint returns the fingerprint of a classification of profile,
+// as sent to the backend, by model with the given digest
+func NewFingerprint(profile *types.Profile, model, digest string) *types.Fingerprint {
+	fingerprint := &types.Fingerprint{
+		Model:          model,
+		ModelDigest:    digest,
+		ProfileID:      profile.ID,
+		ProfileVersion: profile.Version,
+		PromptHash:     PromptHash(profile),
+	}
+	fingerprint.ID = hashFields(fingerprint.Model, fingerprint.ModelDigest, fingerprint.ProfileID, fingerprint.ProfileVersion, fingerprint.PromptHash)[:16]
+	return fingerprint
+}
+
+// PromptHash returns the SHA-256 of the system prompt and few-shot examples
+// RenderPrompt sends for profile, along with the conditions of conditional
+// examples and the profile's prompt template
+func PromptHash(profile *types.Profile) string {
+	fields := []string{profile.System}
+	if profile.PromptTemplate != "" {
+		fields = append(fields, "template", profile.PromptTemplate)
+	}
+	for _, example := range profile.FewShot {
+		fields = append(fields, example.Name, example.Input, example.Output)
+		if example.When != "" {
+			fields = append(fields, "when", example.When)
+		}
+	}
+	return hashFields(fields...)
+}
+
+// hashFields returns the hex SHA-256 of fields, separated so that moving
+// text from one field to the next changes the hash
+func hashFields(fields ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// ModelDigests remembers the model digests of a backend's latest model
+// listing, so that classifications can be fingerprinted without listing the
+// models on every request. Backends record each listing, such as the ones
+// readiness checks make. A name matches a listed model of the same name or,
+// as Ollama tags it by default, the same name with a ":latest" tag. The
+// digest is unknown until the models were listed.
+type ModelDigests struct {
+	mutex   sync.RWMutex
+	digests map[string]string
+}
+
+// Record replaces the known digests with the ones of a model listing
+func (m *ModelDigests) Record(models []ModelInfo) {
+	digests := make(map[string]string, len(models))
+	for _, info := range models {
+		if info.Digest != "" {
+			digests[info.Name] = info.Digest
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.digests = digests
+}
+
+// Digest returns the digest of model, or "" when it is not known
+func (m *ModelDigests) Digest(model string) string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if digest, exists := m.digests[model]; exists {
+		return digest
+	}
+	if !strings.Contains(model, ":") {
+		return m.digests[model+":latest"]
+	}
+	return ""
+}
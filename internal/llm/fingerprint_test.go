@@ -0,0 +1,105 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestFingerprintStableForIdenticalInputs(t *testing.T) {
+	first := NewFingerprint(fingerprintProfile(), "qwen2.5:7b", "sha256:abc")
+	second := NewFingerprint(fingerprintProfile(), "qwen2.5:7b", "sha256:abc")
+
+	assert.Equal(t, first, second)
+	assert.Len(t, first.ID, 16)
+	assert.Equal(t, "spam_detector", first.ProfileID)
+	assert.Equal(t, "1.2.0", first.ProfileVersion)
+}
+
+func TestFingerprintChangesWithInputs(t *testing.T) {
+	baseline := NewFingerprint(fingerprintProfile(), "qwen2.5:7b", "sha256:abc")
+
+	tests := []struct {
+		name   string
+		model  string
+		digest string
+		modify func(profile *types.Profile)
+	}{
+		{name: "profile version", modify: func(profile *types.Profile) { profile.Version = "1.3.0" }},
+		{name: "profile ID", modify: func(profile *types.Profile) { profile.ID = "phishing_detector" }},
+		{name: "system prompt", modify: func(profile *types.Profile) { profile.System += " Be strict." }},
+		{name: "few-shot output", modify: func(profile *types.Profile) { profile.FewShot[0].Output = `{"action":"keep"}` }},
+		{name: "few-shot dropped", modify: func(profile *types.Profile) { profile.FewShot = profile.FewShot[:1] }},
+		{name: "prompt template", modify: func(profile *types.Profile) { profile.PromptTemplate = "{{.System}} {{.Email.Body}}" }},
+		{name: "text moved between fields", modify: func(profile *types.Profile) {
+			profile.FewShot[0].Input += profile.FewShot[0].Output
+			profile.FewShot[0].Output = ""
+		}},
+		{name: "model", model: "llama3:8b"},
+		{name: "model digest", digest: "sha256:def"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := fingerprintProfile()
+			if tt.modify != nil {
+				tt.modify(profile)
+			}
+			model, digest := "qwen2.5:7b", "sha256:abc"
+			if tt.model != "" {
+				model = tt.model
+			}
+			if tt.digest != "" {
+				digest = tt.digest
+			}
+
+			assert.NotEqual(t, baseline.ID, NewFingerprint(profile, model, digest).ID)
+		})
+	}
+}
+
+func TestFingerprintIgnoresOtherProfileSettings(t *testing.T) {
+	baseline := NewFingerprint(fingerprintProfile(), "qwen2.5:7b", "")
+
+	profile := fingerprintProfile()
+	profile.FallbackModels = []string{"llama3:8b"}
+	profile.ShadowOf = "spam_detector_v1"
+
+	assert.Equal(t, baseline, NewFingerprint(profile, "qwen2.5:7b", ""))
+}
+
+func TestModelDigests(t *testing.T) {
+	var digests ModelDigests
+	assert.Empty(t, digests.Digest("qwen2.5:7b"), "nothing is known before a listing")
+
+	digests.Record([]ModelInfo{
+		{Name: "qwen2.5:7b", Digest: "sha256:qwen"},
+		{Name: "llama3:latest", Digest: "sha256:llama"},
+	})
+	assert.Equal(t, "sha256:qwen", digests.Digest("qwen2.5:7b"))
+	assert.Equal(t, "sha256:llama", digests.Digest("llama3"), "an untagged name matches its :latest tag")
+	assert.Equal(t, "sha256:llama", digests.Digest("llama3:latest"))
+	assert.Empty(t, digests.Digest("qwen2.5"), "only :latest is implied")
+	assert.Empty(t, digests.Digest("llama3:8b"))
+
+	digests.Record([]ModelInfo{{Name: "qwen2.5:7b", Digest: "sha256:pulled-again"}})
+	assert.Equal(t, "sha256:pulled-again", digests.Digest("qwen2.5:7b"), "a new listing replaces the digests")
+	assert.Empty(t, digests.Digest("llama3"))
+}
+
+// Helper functions
+
+func fingerprintProfile() *types.Profile {
+	return &types.Profile{
+		ID:      "spam_detector",
+		Version: "1.2.0",
+		Model:   "qwen2.5:7b",
+		System:  "You classify emails as spam or not.",
+		FewShot: []types.FewShotExample{
+			{Name: "promo", Input: "Buy now!", Output: `{"action":"archive"}`},
+			{Name: "invoice", Input: "Your invoice", Output: `{"action":"keep"}`},
+		},
+	}
+}
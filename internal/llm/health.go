@@ -0,0 +1,32 @@
+package llm
+
+import (
+	"context"
+	"time"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// HealthStatus is a backend's health as reported by its HealthCheck
+type HealthStatus struct {
+	State     types.HealthState `json:"status"`
+	CheckedAt time.Time         `json:"checked_at"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// HealthState returns the overall health state
+func (s HealthStatus) HealthState() types.HealthState {
+	return s.State
+}
+
+// CheckHealth runs the classifier's HealthCheck, reporting any failure as
+// unhealthy. Backends with richer status reporting, such as the breaker
+// state, should be checked through that instead.
+func CheckHealth(ctx context.Context, classifier Classifier) HealthStatus {
+	status := HealthStatus{State: types.HealthHealthy, CheckedAt: time.Now()}
+	if err := classifier.HealthCheck(ctx); err != nil {
+		status.State = types.HealthUnhealthy
+		status.Error = err.Error()
+	}
+	return status
+}
@@ -0,0 +1,18 @@
+package llm
+
+import (
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// MarkHomoglyphs sets types.MetadataHomoglyphSender on a result when the
+// email's normalized sender domain differs from the raw one, so that the
+// lookalike sender is a signal resolver rules and post-processing can use
+func MarkHomoglyphs(email *types.Email, result *types.ClassificationResponse) {
+	if email.Homoglyphs == nil || !email.Homoglyphs.Suspicious {
+		return
+	}
+	if result.Metadata == nil {
+		result.Metadata = make(map[string]interface{})
+	}
+	result.Metadata[types.MetadataHomoglyphSender] = email.Homoglyphs.NormalizedSenderDomain
+}
@@ -0,0 +1,23 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestMarkHomoglyphs(t *testing.T) {
+	result := &types.ClassificationResponse{Action: "keep"}
+	MarkHomoglyphs(&types.Email{}, result)
+	assert.Nil(t, result.Metadata, "emails without an analysis are not marked")
+
+	email := &types.Email{Homoglyphs: &types.HomoglyphAnalysis{SenderDomain: "example.com", NormalizedSenderDomain: "example.com"}}
+	MarkHomoglyphs(email, result)
+	assert.Nil(t, result.Metadata)
+
+	email.Homoglyphs = &types.HomoglyphAnalysis{SenderDomain: "аmazon.com", NormalizedSenderDomain: "amazon.com", Suspicious: true}
+	MarkHomoglyphs(email, result)
+	assert.Equal(t, "amazon.com", result.Metadata[types.MetadataHomoglyphSender])
+}
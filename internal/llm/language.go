@@ -0,0 +1,17 @@
+package llm
+
+import (
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// MarkLanguage sets types.MetadataLanguage on a result to the email's
+// language when it is known, for resolver rules and post-processing
+func MarkLanguage(email *types.Email, result *types.ClassificationResponse) {
+	if email.Language == "" || email.Language == types.LanguageUnknown {
+		return
+	}
+	if result.Metadata == nil {
+		result.Metadata = make(map[string]interface{})
+	}
+	result.Metadata[types.MetadataLanguage] = email.Language
+}
@@ -0,0 +1,23 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestMarkLanguage(t *testing.T) {
+	result := &types.ClassificationResponse{Action: "keep"}
+	email := &types.Email{Body: "Kurz.", Language: types.LanguageUnknown}
+	MarkLanguage(email, result)
+	assert.Nil(t, result.Metadata, "unknown languages are not marked")
+	assert.NotContains(t, BuildPrompt(&types.Profile{}, email), "Language:")
+
+	email.Language = "de"
+	MarkLanguage(email, result)
+	assert.Equal(t, "de", result.Metadata[types.MetadataLanguage])
+	assert.Contains(t, BuildPrompt(&types.Profile{}, email), "Language: German\nBody: Kurz.")
+	assert.Equal(t, "de", NewPromptData(&types.Profile{}, email).Email.Language)
+}
@@ -0,0 +1,68 @@
+package llm
+
+import (
+	"context"
+	"io"
+
+	"github.com/mailsentinel/core/internal/limit"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// LimitedClassifier holds a permit of a limiter for each classification, so
+// that the classifications in flight on the backend never exceed its cap
+// across every batch and worker sharing it
+type LimitedClassifier struct {
+	Classifier
+	limiter *limit.Limiter
+}
+
+// NewLimitedClassifier wraps classifier with limiter; a nil limiter imposes
+// no cap
+func NewLimitedClassifier(classifier Classifier, limiter *limit.Limiter) *LimitedClassifier {
+	return &LimitedClassifier{
+		Classifier: classifier,
+		limiter:    limiter,
+	}
+}
+
+// ClassifyEmail classifies the email once a permit is free
+func (l *LimitedClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	if err := l.limiter.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.limiter.Release()
+	return l.Classifier.ClassifyEmail(ctx, profile, email)
+}
+
+// Close closes the wrapped backend, if it can be closed
+func (l *LimitedClassifier) Close() error {
+	if closer, ok := l.Classifier.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// LimitedEmbedder holds a permit of a limiter for each embedding request,
+// sharing the cap of the classifications running on the same backend
+type LimitedEmbedder struct {
+	embedder Embedder
+	limiter  *limit.Limiter
+}
+
+// NewLimitedEmbedder wraps embedder with limiter; a nil limiter imposes no
+// cap
+func NewLimitedEmbedder(embedder Embedder, limiter *limit.Limiter) *LimitedEmbedder {
+	return &LimitedEmbedder{
+		embedder: embedder,
+		limiter:  limiter,
+	}
+}
+
+// Embed embeds the texts once a permit is free
+func (l *LimitedEmbedder) Embed(ctx context.Context, model string, texts []string) ([][]float64, error) {
+	if err := l.limiter.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.limiter.Release()
+	return l.embedder.Embed(ctx, model, texts)
+}
@@ -0,0 +1,76 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/limit"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestLimitedClassifierCapsClassificationsInFlight(t *testing.T) {
+	backend := &inFlightClassifier{countingClassifier: countingClassifier{latency: 5 * time.Millisecond}}
+	limiter := limit.New(4)
+	// Two wrappers share the limiter, as chains serving several batches do
+	first := NewLimitedClassifier(backend, limiter)
+	second := NewLimitedClassifier(backend, limiter)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		classifier := first
+		if i%2 == 1 {
+			classifier = second
+		}
+		wg.Add(1)
+		go func(classifier *LimitedClassifier, id string) {
+			defer wg.Done()
+			_, err := classifier.ClassifyEmail(context.Background(), &types.Profile{ID: "billing"}, &types.Email{ID: id})
+			require.NoError(t, err)
+		}(classifier, fmt.Sprintf("email-%d", i))
+	}
+	wg.Wait()
+
+	assert.Equal(t, 40, backend.count())
+	assert.Equal(t, int32(4), backend.max.Load())
+}
+
+func TestLimitedClassifierHonorsContext(t *testing.T) {
+	limiter := limit.New(1)
+	require.NoError(t, limiter.Acquire(context.Background()))
+	defer limiter.Release()
+
+	backend := &countingClassifier{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := NewLimitedClassifier(backend, limiter).ClassifyEmail(ctx, &types.Profile{ID: "billing"}, &types.Email{ID: "email-1"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Zero(t, backend.count(), "waiting for a permit never reaches the backend")
+}
+
+// Helper functions
+
+// inFlightClassifier records the most classifications it has run at once
+type inFlightClassifier struct {
+	countingClassifier
+	current atomic.Int32
+	max     atomic.Int32
+}
+
+func (c *inFlightClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	current := c.current.Add(1)
+	defer c.current.Add(-1)
+	for {
+		max := c.max.Load()
+		if current <= max || c.max.CompareAndSwap(max, current) {
+			break
+		}
+	}
+	return c.countingClassifier.ClassifyEmail(ctx, profile, email)
+}
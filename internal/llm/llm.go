@@ -0,0 +1,249 @@
+// Package llm defines the interface between MailSentinel and the language
+// model backends that classify emails, along with the prompt, parsing and
+// sampling logic every backend shares.
+package llm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sony/gobreaker"
+
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Classifier is a language model backend able to classify emails
+type Classifier interface {
+	// ClassifyEmail classifies an email using the specified profile
+	ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error)
+
+	// HealthCheck verifies connectivity and that the default model is available
+	HealthCheck(ctx context.Context) error
+
+	// ListModels returns the models the backend can serve
+	ListModels(ctx context.Context) ([]ModelInfo, error)
+}
+
+// ModelInfo represents model information
+type ModelInfo struct {
+	Name       string    `json:"name"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Size       int64     `json:"size"`
+	Digest     string    `json:"digest"`
+}
+
+// Response metadata keys set by every backend
+const (
+	MetadataServedByModel = "served_by_model"
+	MetadataFallbackFrom  = "fallback_from"
+	MetadataSeed          = "seed"
+	MetadataTemperature   = "temperature"
+)
+
+// MetadataRawResponse is the response metadata key holding the exact text
+// the model returned, set by backends with raw responses enabled
+const MetadataRawResponse = "raw_response"
+
+// MetadataEmptyRetry is the response metadata key set to true on results
+// served by the retry of a model response with no output
+const MetadataEmptyRetry = "empty_response_retried"
+
+// ClassifyOptions overrides sampling for a single classification. Nil
+// fields use the profile's settings.
+type ClassifyOptions struct {
+	Seed        *int64
+	Temperature *float64
+}
+
+// Sampling is the model options sent with a classification. Zero MaxTokens,
+// TopP and TopK leave them to the backend.
+type Sampling struct {
+	Temperature float64
+	Seed        int64
+	MaxTokens   int
+	TopP        float64
+	TopK        int
+}
+
+// ResolveSampling resolves the sampling for a classification. Every request
+// gets an explicit seed, random unless the request or the profile sets one,
+// so it can be replayed. Deterministic mode forces a zero temperature and,
+// unless the request or the profile sets one, deterministicSeed. An unset
+// temperature is zero.
+func ResolveSampling(profile *types.Profile, opts ClassifyOptions, deterministic bool, deterministicSeed int64) Sampling {
+	sampling := Sampling{
+		Temperature: profile.ModelParams.TemperatureOrZero(),
+		MaxTokens:   profile.ModelParams.MaxTokens,
+		TopP:        profile.ModelParams.TopP,
+		TopK:        profile.ModelParams.TopK,
+	}
+	if opts.Temperature != nil {
+		sampling.Temperature = *opts.Temperature
+	}
+
+	switch {
+	case opts.Seed != nil:
+		sampling.Seed = *opts.Seed
+	case profile.ModelParams.Seed != nil:
+		sampling.Seed = *profile.ModelParams.Seed
+	case deterministic:
+		sampling.Seed = deterministicSeed
+	default:
+		sampling.Seed = rand.Int63n(math.MaxInt32)
+	}
+
+	if deterministic {
+		sampling.Temperature = 0
+	}
+	return sampling
+}
+
+// BreakerObservable is a backend reporting the state of its circuit
+// breakers, one per model
+type BreakerObservable interface {
+	// GetCircuitBreakerState returns the current state of the circuit
+	// breaker of model
+	GetCircuitBreakerState(model string) gobreaker.State
+
+	// OnBreakerStateChange calls observer on every state change of any of
+	// the circuit breakers
+	OnBreakerStateChange(observer func(model string, from, to gobreaker.State))
+}
+
+// BreakerObservers are the observers of circuit breakers' state changes.
+// They are called while the breaker holds its lock, so they must not call
+// into the breaker.
+type BreakerObservers struct {
+	mutex     sync.Mutex
+	observers []func(model string, from, to gobreaker.State)
+}
+
+// Add registers an observer
+func (b *BreakerObservers) Add(observer func(model string, from, to gobreaker.State)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.observers = append(b.observers, observer)
+}
+
+// notify calls every observer with a state change
+func (b *BreakerObservers) notify(model string, from, to gobreaker.State) {
+	b.mutex.Lock()
+	observers := append([]func(model string, from, to gobreaker.State){}, b.observers...)
+	b.mutex.Unlock()
+	for _, observer := range observers {
+		observer(model, from, to)
+	}
+}
+
+// Breakers are the circuit breakers guarding a backend's requests, one per
+// model so that a failing model does not reject requests to the others.
+// Each is created with the same settings on the first request to its model.
+type Breakers struct {
+	name      string
+	config    config.CircuitBreakerConfig
+	observers BreakerObservers
+	logger    *logrus.Logger
+
+	mutex    sync.Mutex
+	breakers map[string]*gobreaker.CircuitBreaker
+}
+
+// NewBreakers creates the circuit breakers of the backend named name
+func NewBreakers(name string, cfg config.CircuitBreakerConfig, logger *logrus.Logger) *Breakers {
+	return &Breakers{
+		name:     name,
+		config:   cfg,
+		logger:   logger,
+		breakers: make(map[string]*gobreaker.CircuitBreaker),
+	}
+}
+
+// Execute runs request through the circuit breaker of model
+func (b *Breakers) Execute(model string, request func() (interface{}, error)) (interface{}, error) {
+	return b.breaker(model).Execute(request)
+}
+
+// State returns the state of the circuit breaker of model. A model that was
+// never requested is closed.
+func (b *Breakers) State(model string) gobreaker.State {
+	if breaker := b.lookup(model); breaker != nil {
+		return breaker.State()
+	}
+	return gobreaker.StateClosed
+}
+
+// Counts returns the counts of the circuit breaker of model, zero for a
+// model that was never requested
+func (b *Breakers) Counts(model string) gobreaker.Counts {
+	if breaker := b.lookup(model); breaker != nil {
+		return breaker.Counts()
+	}
+	return gobreaker.Counts{}
+}
+
+// Models returns the models that have a circuit breaker, sorted
+func (b *Breakers) Models() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	models := make([]string, 0, len(b.breakers))
+	for model := range b.breakers {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// OnStateChange calls observer on every state change of any of the breakers
+func (b *Breakers) OnStateChange(observer func(model string, from, to gobreaker.State)) {
+	b.observers.Add(observer)
+}
+
+// lookup returns the circuit breaker of model, or nil when it has none
+func (b *Breakers) lookup(model string) *gobreaker.CircuitBreaker {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.breakers[model]
+}
+
+// breaker returns the circuit breaker of model, creating it on first use.
+// A breaker logs every state change and reports it to the observers. A
+// missing model does not count as a failure: the backend answered, and its
+// other models may be healthy.
+func (b *Breakers) breaker(model string) *gobreaker.CircuitBreaker {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if breaker, ok := b.breakers[model]; ok {
+		return breaker
+	}
+
+	breaker := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        b.name + ":" + model,
+		MaxRequests: b.config.MaxRequests,
+		Interval:    b.config.Interval,
+		Timeout:     b.config.Timeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= uint32(b.config.ReadyToTrip)
+		},
+		IsSuccessful: func(err error) bool {
+			return err == nil || errors.Is(err, ErrModelNotFound)
+		},
+		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			b.logger.WithFields(logrus.Fields{
+				"circuit_breaker": name,
+				"model":           model,
+				"from_state":      from,
+				"to_state":        to,
+			}).Info("Circuit breaker state changed")
+			b.observers.notify(model, from, to)
+		},
+	})
+	b.breakers[model] = breaker
+	return breaker
+}
@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/mailsentinel/core/internal/expr"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// MetadataPostProcessed is the response metadata key listing the names of
+// the post-processing rules applied to a result
+const MetadataPostProcessed = "post_processed"
+
+// postProcessPrograms caches compiled post-processing conditions by source
+var postProcessPrograms sync.Map
+
+// PostProcess applies the profile's post-processing rules to a parsed
+// result in order, each seeing the changes of the rules before it, and lists
+// the applied rules under MetadataPostProcessed. Rules can only change the
+// action, the labels and the metadata; their conditions are evaluated with
+// no access to anything but the result and the email. A rule whose
+// condition fails to evaluate is skipped and reported in the returned
+// error, which should not fail the classification.
+func PostProcess(profile *types.Profile, email *types.Email, result *types.ClassificationResponse) error {
+	var errs []error
+	var applied []string
+	for i, rule := range profile.Response.PostProcess {
+		program, err := compilePostProcess(rule.When)
+		var matched bool
+		if err == nil {
+			matched, err = program.EvalBool(postProcessEnv(email, result))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", rule.RuleName(i), err))
+			continue
+		}
+		if !matched {
+			continue
+		}
+
+		applyRule(rule, result)
+		applied = append(applied, rule.RuleName(i))
+	}
+
+	if len(applied) > 0 {
+		if result.Metadata == nil {
+			result.Metadata = make(map[string]interface{})
+		}
+		result.Metadata[MetadataPostProcessed] = applied
+	}
+	return errors.Join(errs...)
+}
+
+// compilePostProcess returns the compiled program for a post-processing
+// condition, compiling it on first use
+func compilePostProcess(source string) (*expr.Program, error) {
+	if cached, exists := postProcessPrograms.Load(source); exists {
+		return cached.(*expr.Program), nil
+	}
+
+	program, err := expr.Compile(source)
+	if err != nil {
+		return nil, err
+	}
+	postProcessPrograms.Store(source, program)
+	return program, nil
+}
+
+// postProcessEnv builds the environment post-processing conditions are
+// evaluated against: the result's fields, also under result, and the email
+func postProcessEnv(email *types.Email, result *types.ClassificationResponse) expr.Env {
+	fields := result.Fields()
+	env := make(expr.Env, len(fields)+2)
+	for key, value := range fields {
+		env[key] = value
+	}
+	env["result"] = fields
+	env["email"] = email
+	return env
+}
+
+// applyRule makes a matched rule's changes to a result
+func applyRule(rule types.PostProcessRule, result *types.ClassificationResponse) {
+	if rule.Action != "" {
+		result.Action = rule.Action
+	}
+
+	for _, label := range rule.AddLabels {
+		if !containsLabel(result.Labels, label) {
+			result.Labels = append(result.Labels, label)
+		}
+	}
+	if len(rule.RemoveLabels) > 0 {
+		kept := result.Labels[:0]
+		for _, label := range result.Labels {
+			if !containsLabel(rule.RemoveLabels, label) {
+				kept = append(kept, label)
+			}
+		}
+		result.Labels = kept
+	}
+
+	if len(rule.Metadata) > 0 && result.Metadata == nil {
+		result.Metadata = make(map[string]interface{}, len(rule.Metadata))
+	}
+	for key, value := range rule.Metadata {
+		result.Metadata[key] = value
+	}
+}
+
+// containsLabel reports whether labels contains label
+func containsLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestPostProcessGrayBand(t *testing.T) {
+	profile := &types.Profile{ID: "spam", Response: types.ResponseConfig{PostProcess: []types.PostProcessRule{{
+		Name:     "gray_band",
+		When:     "action == 'delete' && confidence >= 0.5 && confidence < 0.7",
+		Action:   "review",
+		Metadata: map[string]interface{}{"gray_band": true},
+	}}}}
+
+	tests := []struct {
+		name       string
+		confidence float64
+		action     string
+	}{
+		{"below the band", 0.4, "delete"},
+		{"in the band", 0.6, "review"},
+		{"above the band", 0.9, "delete"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &types.ClassificationResponse{ProfileID: "spam", Action: "delete", Confidence: tt.confidence}
+			require.NoError(t, PostProcess(profile, &types.Email{ID: "email-1"}, result))
+
+			assert.Equal(t, tt.action, result.Action)
+			if tt.action == "review" {
+				assert.Equal(t, true, result.Metadata["gray_band"])
+				assert.Equal(t, []string{"gray_band"}, result.Metadata[MetadataPostProcessed])
+			} else {
+				assert.NotContains(t, result.Metadata, MetadataPostProcessed)
+			}
+		})
+	}
+}
+
+func TestPostProcessLabelInjection(t *testing.T) {
+	profile := &types.Profile{ID: "billing", Response: types.ResponseConfig{PostProcess: []types.PostProcessRule{
+		{When: "email.from contains '@vendor.example'", AddLabels: []string{"Vendors", "Finance"}},
+		// Sees the labels added by the rule before it
+		{When: "labels contains 'Vendors' && metadata.amount > 1000", AddLabels: []string{"Approval"}, RemoveLabels: []string{"Finance"}},
+	}}}
+
+	result := &types.ClassificationResponse{
+		ProfileID: "billing",
+		Action:    "label",
+		Labels:    []string{"Finance"},
+		Metadata:  map[string]interface{}{"amount": 2500.0},
+	}
+	email := &types.Email{ID: "email-1", From: "Billing <billing@vendor.example>"}
+	require.NoError(t, PostProcess(profile, email, result))
+
+	assert.Equal(t, []string{"Vendors", "Approval"}, result.Labels, "labels are not duplicated")
+	assert.Equal(t, "label", result.Action)
+	assert.Equal(t, []string{"post_process[0]", "post_process[1]"}, result.Metadata[MetadataPostProcessed])
+
+	other := &types.ClassificationResponse{ProfileID: "billing", Action: "label"}
+	require.NoError(t, PostProcess(profile, &types.Email{ID: "email-2", From: "friend@example.com"}, other))
+	assert.Empty(t, other.Labels)
+}
+
+func TestPostProcessSkipsFailingRule(t *testing.T) {
+	profile := &types.Profile{ID: "spam", Response: types.ResponseConfig{PostProcess: []types.PostProcessRule{
+		{Name: "broken", When: "confidence <", Action: "keep"},
+		{Name: "low", When: "confidence < 0.5", Action: "review"},
+	}}}
+
+	result := &types.ClassificationResponse{ProfileID: "spam", Action: "delete", Confidence: 0.3}
+	err := PostProcess(profile, &types.Email{ID: "email-1"}, result)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "broken")
+	assert.Equal(t, "review", result.Action, "the remaining rules still apply")
+}
@@ -0,0 +1,124 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/annotate"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// MetadataTriage is the response metadata key recording what a preview
+// email was classified from: TriageSnippet or TriageFull
+const MetadataTriage = "triage"
+
+// MetadataPreviewMessage is the response metadata key recording the ID of
+// the message fetched to classify a preview email in full
+const MetadataPreviewMessage = "preview_message"
+
+// Values of MetadataTriage
+const (
+	TriageSnippet = "snippet"
+	TriageFull    = "full"
+)
+
+// PreviewClassifier classifies preview emails, listed with their snippet
+// only. A profile with triage classifies the snippet first and fetches the
+// full message, the latest of the preview's thread, only when the result is
+// ambiguous; a profile without triage always classifies the full message.
+// Results keep the preview's email ID. Other emails are classified
+// unchanged.
+type PreviewClassifier struct {
+	Classifier
+	fetcher ThreadFetcher
+	logger  *logrus.Logger
+}
+
+// NewPreviewClassifier wraps classifier, fetching the messages previews
+// stand for from fetcher
+func NewPreviewClassifier(classifier Classifier, fetcher ThreadFetcher, logger *logrus.Logger) *PreviewClassifier {
+	return &PreviewClassifier{
+		Classifier: classifier,
+		fetcher:    fetcher,
+		logger:     logger,
+	}
+}
+
+// ClassifyEmail classifies the email, triaging previews on their snippet
+// when the profile allows it. When the full message of a triaged preview
+// cannot be fetched, its snippet result is returned.
+func (p *PreviewClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	if !email.Preview {
+		return p.Classifier.ClassifyEmail(ctx, profile, email)
+	}
+
+	var triaged *types.ClassificationResponse
+	if profile.Triage != nil {
+		result, err := p.Classifier.ClassifyEmail(ctx, profile, email)
+		if err != nil {
+			return nil, err
+		}
+		setMetadata(result, MetadataTriage, TriageSnippet)
+		if !profile.Triage.Escalates(result) {
+			return result, nil
+		}
+		triaged = result
+	}
+
+	full, err := p.expand(ctx, email)
+	if err != nil {
+		if triaged == nil || ctx.Err() != nil {
+			return nil, err
+		}
+		logging.FromContext(ctx, p.logger).WithError(err).WithFields(logrus.Fields{
+			"email_id":   email.ID,
+			"profile_id": profile.ID,
+		}).Warn("Failed to fetch previewed message, keeping the snippet result")
+		return triaged, nil
+	}
+
+	result, err := p.Classifier.ClassifyEmail(ctx, profile, full)
+	if err != nil {
+		return nil, err
+	}
+	result.EmailID = email.ID
+	setMetadata(result, MetadataTriage, TriageFull)
+	setMetadata(result, MetadataPreviewMessage, full.ID)
+	return result, nil
+}
+
+// expand fetches the latest message of a preview's thread, annotated as
+// the server annotates the emails it receives
+func (p *PreviewClassifier) expand(ctx context.Context, email *types.Email) (*types.Email, error) {
+	messages, err := p.fetcher.GetThread(ctx, email.ThreadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch previewed thread %s: %w", email.ThreadID, err)
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("previewed thread %s has no messages", email.ThreadID)
+	}
+
+	full := *messages[len(messages)-1]
+	annotate.Email(&full)
+	return &full, nil
+}
+
+// Close closes the wrapped backend, if it can be closed
+func (p *PreviewClassifier) Close() error {
+	if closer, ok := p.Classifier.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// setMetadata sets a response metadata key, creating the map when needed
+func setMetadata(result *types.ClassificationResponse, key string, value interface{}) {
+	if result.Metadata == nil {
+		result.Metadata = make(map[string]interface{})
+	}
+	result.Metadata[key] = value
+}
@@ -0,0 +1,103 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestPreviewClassifierTriage(t *testing.T) {
+	original, reply := twoMessageThread()
+	tests := []struct {
+		name       string
+		triage     *types.TriageConfig
+		classified []string
+		triaged    string
+	}{
+		{"confident snippet", &types.TriageConfig{EscalateBelow: 0.7}, []string{"thread-1"}, TriageSnippet},
+		{"ambiguous snippet", &types.TriageConfig{EscalateBelow: 0.9}, []string{"thread-1", "msg-2"}, TriageFull},
+		{"escalated action", &types.TriageConfig{EscalateBelow: 0.5, EscalateActions: []string{"archive"}}, []string{"thread-1", "msg-2"}, TriageFull},
+		{"profile without triage", nil, []string{"msg-2"}, TriageFull},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &emailRecordingClassifier{}
+			fetcher := &staticThreadFetcher{threads: map[string][]*types.Email{"thread-1": {original, reply}}}
+			classifier := NewPreviewClassifier(backend, fetcher, testLogger())
+
+			result, err := classifier.ClassifyEmail(context.Background(), &types.Profile{ID: "billing", Triage: tt.triage}, previewEmail())
+			require.NoError(t, err)
+
+			var classified []string
+			for _, email := range backend.emails {
+				classified = append(classified, email.ID)
+			}
+			assert.Equal(t, tt.classified, classified)
+			assert.Equal(t, "thread-1", result.EmailID, "results keep the preview's ID")
+			assert.Equal(t, tt.triaged, result.Metadata[MetadataTriage])
+			if tt.triaged == TriageFull {
+				assert.Equal(t, "msg-2", result.Metadata[MetadataPreviewMessage])
+				assert.False(t, backend.last().Preview)
+			}
+		})
+	}
+}
+
+func TestPreviewClassifierFetchFailure(t *testing.T) {
+	fetcher := &staticThreadFetcher{err: errors.New("connection refused")}
+
+	t.Run("triaged previews keep the snippet result", func(t *testing.T) {
+		classifier := NewPreviewClassifier(&emailRecordingClassifier{}, fetcher, testLogger())
+		profile := &types.Profile{ID: "billing", Triage: &types.TriageConfig{EscalateBelow: 0.9}}
+
+		result, err := classifier.ClassifyEmail(context.Background(), profile, previewEmail())
+		require.NoError(t, err)
+		assert.Equal(t, TriageSnippet, result.Metadata[MetadataTriage])
+	})
+
+	t.Run("previews without triage fail", func(t *testing.T) {
+		backend := &emailRecordingClassifier{}
+		classifier := NewPreviewClassifier(backend, fetcher, testLogger())
+
+		result, err := classifier.ClassifyEmail(context.Background(), &types.Profile{ID: "billing"}, previewEmail())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "connection refused")
+		assert.Nil(t, result)
+		assert.Empty(t, backend.emails)
+	})
+}
+
+func TestPreviewClassifierPassesThroughFullEmails(t *testing.T) {
+	_, reply := twoMessageThread()
+	backend := &emailRecordingClassifier{}
+	fetcher := &staticThreadFetcher{err: errors.New("unexpected fetch")}
+	classifier := NewPreviewClassifier(backend, fetcher, testLogger())
+
+	result, err := classifier.ClassifyEmail(context.Background(), &types.Profile{ID: "billing", Triage: &types.TriageConfig{EscalateBelow: 0.9}}, reply)
+	require.NoError(t, err)
+	assert.Same(t, reply, backend.last())
+	assert.NotContains(t, result.Metadata, MetadataTriage)
+}
+
+func TestBuildPromptPreview(t *testing.T) {
+	prompt := BuildPrompt(&types.Profile{ID: "billing"}, previewEmail())
+	assert.Contains(t, prompt, "Body (preview only): Just following up")
+}
+
+// Helper functions
+
+// previewEmail returns the preview of the thread of twoMessageThread
+func previewEmail() *types.Email {
+	return &types.Email{
+		ID:       "thread-1",
+		ThreadID: "thread-1",
+		Snippet:  "Just following up on this.",
+		Preview:  true,
+	}
+}
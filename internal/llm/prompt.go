@@ -0,0 +1,413 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mailsentinel/core/internal/emptybody"
+	"github.com/mailsentinel/core/internal/language"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// BuildPrompt constructs the built-in prompt for email classification,
+// which RenderPrompt falls back to for profiles without a prompt template.
+// The same prompt is sent to every backend so their results are comparable.
+func BuildPrompt(profile *types.Profile, email *types.Email) string {
+	var prompt strings.Builder
+
+	// Add system prompt with strict JSON enforcement
+	prompt.WriteString("System: ")
+	prompt.WriteString(profile.System)
+	prompt.WriteString(" You must respond with valid JSON only, no markdown, no explanations, no code blocks.")
+	prompt.WriteString("\n\n")
+
+	// Add the few-shot examples that apply to the email
+	for _, example := range ApplicableExamples(profile, email) {
+		prompt.WriteString("Example: ")
+		prompt.WriteString(example.Name)
+		prompt.WriteString("\n")
+		prompt.WriteString("Input: ")
+		prompt.WriteString(example.Input)
+		prompt.WriteString("\n")
+		prompt.WriteString("Output: ")
+		prompt.WriteString(example.Output)
+		prompt.WriteString("\n\n")
+	}
+
+	// Add the earlier messages of the thread as context
+	if len(email.Thread) > 0 {
+		prompt.WriteString("Earlier messages in this thread, oldest first, for context only:\n")
+		for _, message := range email.Thread {
+			prompt.WriteString("From: ")
+			prompt.WriteString(message.From)
+			prompt.WriteString("\n")
+			if !message.Date.IsZero() {
+				prompt.WriteString("Date: ")
+				prompt.WriteString(message.Date.UTC().Format(time.RFC1123Z))
+				prompt.WriteString("\n")
+			}
+			prompt.WriteString("Body: ")
+			prompt.WriteString(message.Body)
+			if message.Truncated {
+				prompt.WriteString(" [truncated]")
+			}
+			prompt.WriteString("\n\n")
+		}
+	}
+
+	// Add the caller's context hints, quoted so that one cannot break out
+	// of its line
+	if len(email.Context) > 0 {
+		prompt.WriteString("Context provided with this email:\n")
+		for _, key := range email.ContextKeys() {
+			prompt.WriteString(fmt.Sprintf("%q: %q\n", key, email.Context[key]))
+		}
+		prompt.WriteString("\n")
+	}
+
+	// Add the email to classify
+	prompt.WriteString("Classify this email:\n")
+	prompt.WriteString("Subject: ")
+	prompt.WriteString(email.Subject)
+	prompt.WriteString("\n")
+	prompt.WriteString("From: ")
+	prompt.WriteString(email.From)
+	prompt.WriteString("\n")
+	if h := email.Homoglyphs; h != nil {
+		// Lookalike domains are listed with the domain they normalize to
+		if h.Suspicious {
+			prompt.WriteString(fmt.Sprintf("Sender domain: %q normalizes to %q, it may imitate that domain\n", h.SenderDomain, h.NormalizedSenderDomain))
+		}
+		for _, link := range h.URLs {
+			if link.Suspicious {
+				prompt.WriteString(fmt.Sprintf("Link host: %q normalizes to %q\n", link.Host, link.NormalizedHost))
+			}
+		}
+	}
+	if email.IsSpoofedReply() {
+		prompt.WriteString(fmt.Sprintf("Threading: the subject claims a %s, but %s\n", email.Reply.Kind, strings.Join(email.Reply.Reasons, ", ")))
+	}
+	prompt.WriteString("To: ")
+	prompt.WriteString(strings.Join(email.To, ", "))
+	prompt.WriteString("\n")
+	if email.Auth != nil {
+		prompt.WriteString(fmt.Sprintf("Authentication: SPF=%s, DKIM=%s, DMARC=%s\n", email.Auth.SPF, email.Auth.DKIM, email.Auth.DMARC))
+	}
+	if email.IsEncrypted() {
+		prompt.WriteString(fmt.Sprintf("Security: %s encrypted, the content cannot be read\n", strings.ToUpper(email.Security.Protocol)))
+	} else if email.Security != nil && email.Security.Signed {
+		prompt.WriteString(fmt.Sprintf("Security: %s signed\n", strings.ToUpper(email.Security.Protocol)))
+	}
+	if len(email.Attachments) > 0 {
+		// Filenames are quoted so that one cannot break out of its line
+		attachments := make([]string, len(email.Attachments))
+		for i, attachment := range email.Attachments {
+			attachments[i] = fmt.Sprintf("%q (%s)", attachment.Filename, attachment.MimeType)
+		}
+		prompt.WriteString("Attachments: ")
+		prompt.WriteString(strings.Join(attachments, ", "))
+		prompt.WriteString("\n")
+	}
+	if len(email.URLs) > 0 {
+		// Links are quoted like filenames, with what makes them suspicious
+		prompt.WriteString("Links:\n")
+		for _, link := range email.URLs {
+			prompt.WriteString(fmt.Sprintf("- %q", link.URL))
+			if link.Mismatch {
+				prompt.WriteString(fmt.Sprintf(" (its text shows %q)", link.TextHost))
+			}
+			if link.Shortener {
+				prompt.WriteString(" (URL shortener)")
+			}
+			prompt.WriteString("\n")
+		}
+	}
+	if email.Language != "" && email.Language != types.LanguageUnknown {
+		prompt.WriteString(fmt.Sprintf("Language: %s\n", language.Name(email.Language)))
+	}
+	if email.EmptyBody {
+		// The headers telling system mail apart stand in for the body, which
+		// gives the model nothing to classify on
+		for _, header := range emptybody.Headers(email) {
+			prompt.WriteString(fmt.Sprintf("Header: %q\n", header))
+		}
+	}
+	switch {
+	case email.Preview:
+		// Only the provider's preview was fetched, so the model should not
+		// take the body for complete
+		prompt.WriteString("Body (preview only): ")
+		prompt.WriteString(email.Snippet)
+	case email.EmptyBody:
+		prompt.WriteString("Body (empty or nearly so, classify from the subject, headers and attachments): ")
+		prompt.WriteString(email.Body)
+	default:
+		prompt.WriteString("Body: ")
+		prompt.WriteString(email.Body)
+	}
+	prompt.WriteString("\n\n")
+
+	// Add strict response format instruction
+	prompt.WriteString("\n\nIMPORTANT: You MUST respond with ONLY valid JSON in this exact format:\n")
+	prompt.WriteString(ResponseSchema)
+	prompt.WriteString("\n\nDo NOT include any markdown formatting, explanations, or additional text.")
+	prompt.WriteString("\nDo NOT wrap the JSON in code blocks or backticks.")
+	prompt.WriteString("\nRespond with raw JSON only.")
+
+	return prompt.String()
+}
+
+// ParseResponse parses the model output into a classification result,
+// applying the profile's confidence calibration. Its errors are a
+// *ResponseError carrying the output, wrapping ErrEmptyResponse when the
+// output is blank.
+func ParseResponse(response string, profile *types.Profile) (*types.ClassificationResponse, error) {
+	if strings.TrimSpace(response) == "" {
+		return nil, &ResponseError{Err: fmt.Errorf("%w: %w: the model produced no output", ErrEmptyResponse, ErrInvalidResponse), Response: response}
+	}
+	classification, err := parseResponse(response, profile)
+	if err != nil {
+		return nil, &ResponseError{Err: err, Response: response}
+	}
+	return classification, nil
+}
+
+// parseResponse is ParseResponse without the raw response in its errors
+func parseResponse(response string, profile *types.Profile) (*types.ClassificationResponse, error) {
+	// Try to extract JSON from the response
+	var result map[string]interface{}
+
+	// First try to extract from markdown code blocks
+	jsonStr := ""
+	if strings.Contains(response, "```json") {
+		start := strings.Index(response, "```json")
+		if start != -1 {
+			start += 7 // Skip "```json"
+			end := strings.Index(response[start:], "```")
+			if end != -1 {
+				jsonStr = strings.TrimSpace(response[start : start+end])
+			} else {
+				// Handle case where closing ``` is missing (truncated response)
+				jsonStr = strings.TrimSpace(response[start:])
+			}
+		}
+	}
+
+	// If no markdown block found, find JSON in the response
+	if jsonStr == "" {
+		start := strings.Index(response, "{")
+		end := strings.LastIndex(response, "}")
+
+		if start == -1 || end == -1 || start >= end {
+			if start != -1 && unbalancedJSON(response[start:]) {
+				return nil, truncatedError(profile, errors.New("no complete JSON found in response"))
+			}
+			return nil, fmt.Errorf("%w: no valid JSON found in response", ErrInvalidResponse)
+		}
+
+		jsonStr = response[start : end+1]
+	}
+	if err := json.Unmarshal([]byte(jsonStr), &result); err != nil {
+		if unbalancedJSON(jsonStr) {
+			return nil, truncatedError(profile, err)
+		}
+		return nil, fmt.Errorf("%w: failed to parse JSON response: %w", ErrInvalidResponse, err)
+	}
+
+	// Rename the fields of models that answer in their own vocabulary
+	for field, source := range profile.Response.FieldMapping {
+		if value, exists := result[source]; exists {
+			result[field] = value
+		}
+	}
+
+	// Extract required fields
+	action, ok := result["action"].(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing or invalid 'action' field in response", ErrInvalidResponse)
+	}
+
+	confidence, ok := parseConfidence(result["confidence"])
+	if !ok {
+		return nil, fmt.Errorf("%w: missing or invalid 'confidence' field in response", ErrInvalidResponse)
+	}
+
+	reasoning, truncated, err := parseReasoning(result["reasoning"], profile.Response.Validation)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate confidence range before calibration, whose clamping would
+	// otherwise pass an out-of-range confidence off as certain
+	if confidence < 0.0 || confidence > 1.0 {
+		return nil, fmt.Errorf("%w: confidence must be between 0.0 and 1.0, got %f", ErrInvalidResponse, confidence)
+	}
+
+	// Calibrate systematically over- or under-confident profiles
+	rawConfidence := confidence
+	confidence = profile.Calibration.Apply(rawConfidence)
+
+	// Create classification response
+	classification := &types.ClassificationResponse{
+		ProfileID:   profile.ID,
+		Action:      action,
+		Confidence:  confidence,
+		Reasoning:   reasoning,
+		ProcessedAt: time.Now(),
+	}
+
+	// Add metadata if present
+	if metadata, exists := result["metadata"]; exists {
+		if metadataMap, ok := metadata.(map[string]interface{}); ok {
+			classification.Metadata = metadataMap
+		}
+	}
+
+	// Add labels if present
+	if labels, exists := result["labels"]; exists {
+		if labelsList, ok := labels.([]interface{}); ok {
+			var stringLabels []string
+			for _, label := range labelsList {
+				if labelStr, ok := label.(string); ok {
+					stringLabels = append(stringLabels, labelStr)
+				}
+			}
+			classification.Labels = stringLabels
+		}
+	}
+
+	if truncated {
+		if classification.Metadata == nil {
+			classification.Metadata = make(map[string]interface{})
+		}
+		classification.Metadata[types.MetadataReasoningTruncated] = true
+	}
+	if profile.Calibration != nil {
+		if classification.Metadata == nil {
+			classification.Metadata = make(map[string]interface{})
+		}
+		classification.Metadata["raw_confidence"] = rawConfidence
+		classification.Metadata["calibrated_confidence"] = confidence
+	}
+	if profile.IsShadow() {
+		if classification.Metadata == nil {
+			classification.Metadata = make(map[string]interface{})
+		}
+		classification.Metadata[types.MetadataShadow] = true
+		classification.Metadata[types.MetadataShadowOf] = profile.ShadowOf
+	}
+
+	return classification, nil
+}
+
+// parseReasoning reads the reasoning of a response, truncating it to the
+// validation's reasoning limit with an ellipsis. A missing or blank reasoning
+// is an error when the validation requires it, and is otherwise replaced by a
+// placeholder.
+func parseReasoning(value interface{}, validation types.ValidationConfig) (string, bool, error) {
+	reasoning, _ := value.(string)
+	reasoning = strings.TrimSpace(reasoning)
+	if reasoning == "" {
+		if validation.Requires("reasoning") {
+			return "", false, fmt.Errorf("%w: missing or empty 'reasoning' field in response", ErrInvalidResponse)
+		}
+		return "No reasoning provided", false, nil
+	}
+
+	limit := validation.ReasoningLimit()
+	runes := []rune(reasoning)
+	if len(runes) <= limit {
+		return reasoning, false, nil
+	}
+	return string(runes[:limit-1]) + "…", true, nil
+}
+
+// parseConfidence reads a confidence given as a JSON number or, as some
+// models answer, a numeric string such as "0.92"
+func parseConfidence(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		confidence, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || math.IsNaN(confidence) || math.IsInf(confidence, 0) {
+			return 0, false
+		}
+		return confidence, true
+	}
+	return 0, false
+}
+
+// CheckTruncation turns a ParseResponse error into ErrTruncatedResponse when
+// the backend reports that generation stopped at the token limit, since the
+// output was then cut off even if what remains looks balanced
+func CheckTruncation(err error, stoppedAtLimit bool, profile *types.Profile) error {
+	if err == nil || !stoppedAtLimit || errors.Is(err, ErrTruncatedResponse) {
+		return err
+	}
+	return truncatedError(profile, err)
+}
+
+// RetrySampling returns the sampling to retry a truncated classification
+// with once: the same seed and temperature with twice the token limit. It
+// reports false when no limit is set, as doubling it would not help.
+func RetrySampling(sampling Sampling) (Sampling, bool) {
+	if sampling.MaxTokens <= 0 {
+		return sampling, false
+	}
+	sampling.MaxTokens *= 2
+	return sampling, true
+}
+
+// EmptyRetrySampling returns the sampling to retry a classification whose
+// model produced no output with once: the same seed and token limit with
+// the temperature raised by the configured step, up to
+// config.MaxRetryTemperature. It reports false when retrying is off.
+func EmptyRetrySampling(sampling Sampling, cfg config.EmptyResponseConfig) (Sampling, bool) {
+	if !cfg.Retry {
+		return sampling, false
+	}
+	sampling.Temperature = math.Min(sampling.Temperature+cfg.TemperatureStep, math.Max(sampling.Temperature, config.MaxRetryTemperature))
+	return sampling, true
+}
+
+// IsEmptyResponse reports whether err is an empty model output that did not
+// stop at the token limit, which a retry with the same limit may fix
+func IsEmptyResponse(err error) bool {
+	return errors.Is(err, ErrEmptyResponse) && !errors.Is(err, ErrTruncatedResponse)
+}
+
+// truncatedError reports output cut off before its JSON was complete
+func truncatedError(profile *types.Profile, err error) error {
+	return fmt.Errorf("%w: %w: output ended before the JSON was complete, try a higher model_params.max_tokens than %d: %w",
+		ErrTruncatedResponse, ErrInvalidResponse, profile.ModelParams.MaxTokens, err)
+}
+
+// unbalancedJSON reports whether s opens more objects, arrays or strings
+// than it closes, as JSON cut off mid-value does
+func unbalancedJSON(s string) bool {
+	depth := 0
+	inString, escaped := false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && r == '\\':
+			escaped = true
+		case r == '"':
+			inString = !inString
+		case inString:
+		case r == '{' || r == '[':
+			depth++
+		case r == '}' || r == ']':
+			depth--
+		}
+	}
+	return inString || depth > 0
+}
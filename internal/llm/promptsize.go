@@ -0,0 +1,125 @@
+package llm
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Response metadata keys recording the size of the prompt a classification
+// was made from, and whether the email was cut to fit the model's context
+const (
+	MetadataPromptBytes     = "prompt_bytes"
+	MetadataPromptTokens    = "prompt_tokens"
+	MetadataPromptTruncated = "prompt_truncated"
+)
+
+// PromptSizeBuckets are the upper bounds, in bytes, of the prompt size
+// histogram buckets; larger prompts are only counted in the total
+var PromptSizeBuckets = []int{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20}
+
+// EstimateTokens roughly estimates the number of tokens in text
+func EstimateTokens(text string) int {
+	return (len(text) + charsPerToken - 1) / charsPerToken
+}
+
+// FitPrompt renders the prompt for email within an estimated maxTokens,
+// dropping the earliest thread messages first and then cutting the body
+// until it fits. It fails with ErrPromptTooLarge when the prompt does not
+// fit even without them.
+func FitPrompt(profile *types.Profile, email *types.Email, maxTokens int) (string, error) {
+	fitted := *email
+	for {
+		prompt, err := RenderPrompt(profile, &fitted)
+		if err != nil {
+			return "", err
+		}
+		tokens := EstimateTokens(prompt)
+		if tokens <= maxTokens {
+			return prompt, nil
+		}
+
+		switch excess := (tokens - maxTokens) * charsPerToken; {
+		case len(fitted.Thread) > 0:
+			fitted.Thread = fitted.Thread[1:]
+		case fitted.Body != "":
+			fitted.Body = truncateUTF8(fitted.Body, max(len(fitted.Body)-excess, 0))
+		default:
+			return "", fmt.Errorf("%w: an estimated %d tokens without the body and thread exceeds %d", ErrPromptTooLarge, tokens, maxTokens)
+		}
+	}
+}
+
+// PromptSizes records the sizes of the prompts a backend builds, so that
+// unusually large prompts can be noticed before they exhaust memory. It is
+// safe for concurrent use.
+type PromptSizes struct {
+	mutex      sync.Mutex
+	count      int
+	totalBytes int64
+	maxBytes   int
+	rejected   int
+	buckets    []int
+}
+
+// PromptSizeSnapshot is the recorded prompt sizes at one point in time
+type PromptSizeSnapshot struct {
+	Count      int                `json:"count"`
+	TotalBytes int64              `json:"total_bytes"`
+	MaxBytes   int                `json:"max_bytes"`
+	Rejected   int                `json:"rejected"`
+	Buckets    []PromptSizeBucket `json:"buckets"`
+}
+
+// PromptSizeBucket counts the prompts of at most LessOrEqual bytes
+type PromptSizeBucket struct {
+	LessOrEqual int `json:"le_bytes"`
+	Count       int `json:"count"`
+}
+
+// Observe records the size of a built prompt
+func (p *PromptSizes) Observe(bytes int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.buckets == nil {
+		p.buckets = make([]int, len(PromptSizeBuckets))
+	}
+	p.count++
+	p.totalBytes += int64(bytes)
+	if bytes > p.maxBytes {
+		p.maxBytes = bytes
+	}
+	for i, bound := range PromptSizeBuckets {
+		if bytes <= bound {
+			p.buckets[i]++
+		}
+	}
+}
+
+// Reject records a prompt refused for being too large
+func (p *PromptSizes) Reject() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.rejected++
+}
+
+// Snapshot returns the sizes recorded so far, with cumulative bucket counts
+func (p *PromptSizes) Snapshot() PromptSizeSnapshot {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	snapshot := PromptSizeSnapshot{
+		Count:      p.count,
+		TotalBytes: p.totalBytes,
+		MaxBytes:   p.maxBytes,
+		Rejected:   p.rejected,
+		Buckets:    make([]PromptSizeBucket, len(PromptSizeBuckets)),
+	}
+	for i, bound := range PromptSizeBuckets {
+		snapshot.Buckets[i].LessOrEqual = bound
+		if p.buckets != nil {
+			snapshot.Buckets[i].Count = p.buckets[i]
+		}
+	}
+	return snapshot
+}
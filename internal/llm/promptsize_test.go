@@ -0,0 +1,85 @@
+package llm
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestPromptSizes(t *testing.T) {
+	var sizes PromptSizes
+	empty := sizes.Snapshot()
+	assert.Zero(t, empty.Count)
+	assert.Len(t, empty.Buckets, len(PromptSizeBuckets))
+
+	var wg sync.WaitGroup
+	for _, bytes := range []int{512, 2000, 2 << 20, 8 << 20} {
+		wg.Add(1)
+		go func(bytes int) {
+			defer wg.Done()
+			sizes.Observe(bytes)
+		}(bytes)
+	}
+	wg.Wait()
+	sizes.Reject()
+
+	snapshot := sizes.Snapshot()
+	assert.Equal(t, 4, snapshot.Count)
+	assert.Equal(t, int64(512+2000+(2<<20)+(8<<20)), snapshot.TotalBytes)
+	assert.Equal(t, 8<<20, snapshot.MaxBytes)
+	assert.Equal(t, 1, snapshot.Rejected)
+
+	counts := make(map[int]int, len(snapshot.Buckets))
+	for _, bucket := range snapshot.Buckets {
+		counts[bucket.LessOrEqual] = bucket.Count
+	}
+	assert.Equal(t, 1, counts[1<<10])
+	assert.Equal(t, 2, counts[4<<10], "buckets are cumulative")
+	assert.Equal(t, 2, counts[1<<20])
+	assert.Equal(t, 3, counts[4<<20], "prompts over the largest bucket are only in the totals")
+}
+
+func TestFitPrompt(t *testing.T) {
+	profile := &types.Profile{ID: "spam", System: "Classify spam."}
+	email := &types.Email{
+		ID:      "email-1",
+		Subject: "Statement",
+		From:    "billing@example.com",
+		Body:    strings.Repeat("Please review the attached statement. ", 50),
+		Thread:  []types.ThreadMessage{{ID: "earlier", From: "billing@example.com", Body: strings.Repeat("An earlier message. ", 50)}},
+	}
+	full := EstimateTokens(BuildPrompt(profile, email))
+	bare := *email
+	bare.Body, bare.Thread = "", nil
+	headers := EstimateTokens(BuildPrompt(profile, &bare))
+
+	prompt, err := FitPrompt(profile, email, full)
+	require.NoError(t, err)
+	assert.Equal(t, BuildPrompt(profile, email), prompt, "a prompt that fits is left alone")
+
+	prompt, err = FitPrompt(profile, email, full-10)
+	require.NoError(t, err)
+	assert.NotContains(t, prompt, "An earlier message", "the thread is dropped first")
+	assert.Contains(t, prompt, email.Body)
+
+	prompt, err = FitPrompt(profile, email, headers+20)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, EstimateTokens(prompt), headers+20)
+	assert.Contains(t, prompt, "Please review the attached statement.", "the body is cut rather than dropped")
+	assert.NotContains(t, prompt, email.Body)
+	assert.Len(t, email.Thread, 1, "the email itself is left alone")
+
+	_, err = FitPrompt(profile, email, headers-1)
+	assert.ErrorIs(t, err, ErrPromptTooLarge)
+}
+
+func TestEstimateTokens(t *testing.T) {
+	assert.Equal(t, 0, EstimateTokens(""))
+	assert.Equal(t, 1, EstimateTokens("abc"))
+	assert.Equal(t, 3, EstimateTokens("twelve chars"))
+}
@@ -0,0 +1,117 @@
+package llm
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	"github.com/mailsentinel/core/internal/redact"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// MetadataRedactions is the response metadata key counting the personal
+// data matches replaced with placeholders before classification
+const MetadataRedactions = "redactions"
+
+// RedactingClassifier replaces personal data in every part of an email the
+// prompt shows, from its subject, bodies and snippet to its links, caller
+// context, attachment names, headers and thread context, with placeholders
+// before the wrapped classifier sees it, so that neither the prompt nor the
+// audit log carries it. The sender and recipients are kept, as profiles
+// rely on them.
+type RedactingClassifier struct {
+	Classifier
+	redactor *redact.Redactor
+	restore  bool
+}
+
+// NewRedactingClassifier wraps classifier with redactor. With restore, the
+// original values are put back into the returned reasoning.
+func NewRedactingClassifier(classifier Classifier, redactor *redact.Redactor, restore bool) *RedactingClassifier {
+	return &RedactingClassifier{
+		Classifier: classifier,
+		redactor:   redactor,
+		restore:    restore,
+	}
+}
+
+// ClassifyEmail classifies a redacted copy of the email, counting the
+// redacted matches under MetadataRedactions
+func (r *RedactingClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	session := r.redactor.NewSession()
+	result, err := r.Classifier.ClassifyEmail(ctx, profile, redactEmail(session, email))
+	if err != nil {
+		return nil, err
+	}
+
+	if r.restore {
+		result.Reasoning = session.Restore(result.Reasoning)
+	}
+	if result.Metadata == nil {
+		result.Metadata = make(map[string]interface{})
+	}
+	result.Metadata[MetadataRedactions] = session.Count()
+	return result, nil
+}
+
+// redactEmail returns a copy of email with the personal data in every field
+// the prompt reads, other than the sender and recipients, replaced
+func redactEmail(session *redact.Session, email *types.Email) *types.Email {
+	redacted := *email
+	redacted.Subject = session.Redact(email.Subject)
+	redacted.Body = session.Redact(email.Body)
+	redacted.BodyHTML = session.Redact(email.BodyHTML)
+	redacted.Snippet = session.Redact(email.Snippet)
+	redacted.Context = redactValues(session, email.Context)
+	redacted.Headers = redactValues(session, email.Headers)
+	if len(email.URLs) > 0 {
+		redacted.URLs = make([]types.Link, len(email.URLs))
+		for i, link := range email.URLs {
+			link.URL = session.Redact(link.URL)
+			link.Text = session.Redact(link.Text)
+			redacted.URLs[i] = link
+		}
+	}
+	if len(email.Attachments) > 0 {
+		redacted.Attachments = make([]types.Attachment, len(email.Attachments))
+		for i, attachment := range email.Attachments {
+			attachment.Filename = session.Redact(attachment.Filename)
+			redacted.Attachments[i] = attachment
+		}
+	}
+	if len(email.Thread) > 0 {
+		redacted.Thread = make([]types.ThreadMessage, len(email.Thread))
+		for i, message := range email.Thread {
+			message.Subject = session.Redact(message.Subject)
+			message.Body = session.Redact(message.Body)
+			redacted.Thread[i] = message
+		}
+	}
+	return &redacted
+}
+
+// redactValues returns a copy of values with their personal data replaced,
+// in key order so that the same values always get the same placeholders
+func redactValues(session *redact.Session, values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	redacted := make(map[string]string, len(values))
+	for _, key := range keys {
+		redacted[key] = session.Redact(values[key])
+	}
+	return redacted
+}
+
+// Close closes the wrapped backend, if it can be closed
+func (r *RedactingClassifier) Close() error {
+	if closer, ok := r.Classifier.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
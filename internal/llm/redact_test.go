@@ -0,0 +1,106 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/redact"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestRedactingClassifier(t *testing.T) {
+	redactor, err := redact.New(config.RedactionConfig{Enabled: true})
+	require.NoError(t, err)
+
+	email := &types.Email{
+		ID:      "email-1",
+		From:    "billing@vendor.example",
+		Subject: "Card 4111 1111 1111 1111 declined",
+		Body:    "Call 555-123-4567 about card 4111 1111 1111 1111.",
+		Thread:  []types.ThreadMessage{{ID: "email-0", Body: "My SSN is 123-45-6789"}},
+	}
+
+	tests := []struct {
+		name      string
+		restore   bool
+		reasoning string
+	}{
+		{"placeholders kept", false, "Mentions [CARD_1]"},
+		{"values restored", true, "Mentions 4111 1111 1111 1111"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &reasoningClassifier{reasoning: "Mentions [CARD_1]"}
+			classifier := NewRedactingClassifier(backend, redactor, tt.restore)
+
+			result, err := classifier.ClassifyEmail(context.Background(), &types.Profile{ID: "billing"}, email)
+			require.NoError(t, err)
+
+			prompt := BuildPrompt(&types.Profile{ID: "billing"}, backend.last())
+			assert.NotContains(t, prompt, "4111")
+			assert.NotContains(t, prompt, "555-123-4567")
+			assert.NotContains(t, prompt, "123-45-6789")
+			assert.Contains(t, prompt, "Subject: Card [CARD_1] declined")
+			assert.Contains(t, prompt, "From: billing@vendor.example", "the sender is kept")
+
+			assert.Equal(t, tt.reasoning, result.Reasoning)
+			assert.Equal(t, 4, result.Metadata[MetadataRedactions])
+			assert.Equal(t, "Card 4111 1111 1111 1111 declined", email.Subject, "the email itself is not modified")
+			assert.Equal(t, "My SSN is 123-45-6789", email.Thread[0].Body)
+		})
+	}
+}
+
+func TestRedactingClassifierCoversEveryPromptField(t *testing.T) {
+	redactor, err := redact.New(config.RedactionConfig{Enabled: true})
+	require.NoError(t, err)
+
+	email := &types.Email{
+		ID:          "email-1",
+		From:        "billing@vendor.example",
+		Subject:     "Invoice",
+		Body:        "See attached.",
+		Snippet:     "Call 555-123-4567",
+		Context:     map[string]string{"caller": "555-987-6543"},
+		Headers:     map[string]string{"X-Customer": "123-45-6789"},
+		URLs:        []types.Link{{URL: "https://pay.example/4111111111111111", Text: "card 4111 1111 1111 1111"}},
+		Attachments: []types.Attachment{{Filename: "statement-123-45-6789.pdf"}},
+	}
+
+	backend := &reasoningClassifier{}
+	classifier := NewRedactingClassifier(backend, redactor, false)
+	_, err = classifier.ClassifyEmail(context.Background(), &types.Profile{ID: "billing"}, email)
+	require.NoError(t, err)
+
+	seen := backend.last()
+	for _, raw := range []string{"555-123-4567", "555-987-6543", "123-45-6789", "4111"} {
+		assert.NotContains(t, fmt.Sprintf("%+v", *seen), raw)
+		assert.NotContains(t, BuildPrompt(&types.Profile{ID: "billing"}, seen), raw)
+	}
+	assert.Equal(t, "Call 555-123-4567", email.Snippet, "the email itself is not modified")
+	assert.Equal(t, "555-987-6543", email.Context["caller"])
+}
+
+// Helper functions
+
+// reasoningClassifier records the emails it classifies, answering with a
+// fixed reasoning
+type reasoningClassifier struct {
+	emailRecordingClassifier
+	reasoning string
+}
+
+func (r *reasoningClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	result, err := r.emailRecordingClassifier.ClassifyEmail(ctx, profile, email)
+	if err != nil {
+		return nil, err
+	}
+	result.Reasoning = r.reasoning
+	return result, nil
+}
@@ -0,0 +1,18 @@
+package llm
+
+import (
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// MarkSpoofedReply sets types.MetadataSpoofedReply on a result when the
+// email's subject claims a reply or a forward that the email contradicts,
+// with the reasons as its value, for resolver rules and post-processing
+func MarkSpoofedReply(email *types.Email, result *types.ClassificationResponse) {
+	if !email.IsSpoofedReply() {
+		return
+	}
+	if result.Metadata == nil {
+		result.Metadata = make(map[string]interface{})
+	}
+	result.Metadata[types.MetadataSpoofedReply] = email.Reply.Reasons
+}
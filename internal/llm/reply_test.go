@@ -0,0 +1,25 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestMarkSpoofedReply(t *testing.T) {
+	result := &types.ClassificationResponse{Action: "keep"}
+	MarkSpoofedReply(&types.Email{}, result)
+	assert.Nil(t, result.Metadata, "emails without an analysis are not marked")
+
+	email := &types.Email{Subject: "Re: Invoice", Reply: &types.ReplyAnalysis{Kind: types.ReplyKindReply, Threaded: true}}
+	MarkSpoofedReply(email, result)
+	assert.Nil(t, result.Metadata)
+	assert.NotContains(t, BuildPrompt(&types.Profile{}, email), "Threading:")
+
+	email.Reply = &types.ReplyAnalysis{Kind: types.ReplyKindReply, Spoofed: true, Reasons: []string{types.ReplyMissingHeaders}}
+	MarkSpoofedReply(email, result)
+	assert.Equal(t, []string{types.ReplyMissingHeaders}, result.Metadata[types.MetadataSpoofedReply])
+	assert.Contains(t, BuildPrompt(&types.Profile{}, email), "Threading: the subject claims a reply, but missing_headers\n")
+}
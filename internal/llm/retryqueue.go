@@ -0,0 +1,174 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sony/gobreaker"
+
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// retryQueuePoll is how often a waiting classification checks the breaker,
+// which only moves from open to half-open when its state is read
+const retryQueuePoll = time.Second
+
+// RetryQueue holds classifications rejected by the backend's open circuit
+// breakers and submits them again once the breaker of one of the profile's
+// models turns half-open or closed, so that a brief outage delays them
+// instead of failing them. At most MaxSize classifications wait at once;
+// the rest fail with ErrRetryQueueFull. A waiting classification gives up
+// with the breaker's error after MaxWait or when its context ends.
+type RetryQueue struct {
+	Classifier
+	breaker BreakerObservable
+	config  config.RetryQueueConfig
+	logger  *logrus.Logger
+
+	mutex     sync.Mutex
+	waiting   int
+	overflows int
+	// recovered is closed, and replaced, when a breaker lets requests
+	// through again
+	recovered chan struct{}
+}
+
+// NewRetryQueue wraps classifier with a retry queue watching breaker
+func NewRetryQueue(classifier Classifier, breaker BreakerObservable, cfg config.RetryQueueConfig, logger *logrus.Logger) *RetryQueue {
+	queue := &RetryQueue{
+		Classifier: classifier,
+		breaker:    breaker,
+		config:     cfg,
+		logger:     logger,
+		recovered:  make(chan struct{}),
+	}
+	breaker.OnBreakerStateChange(queue.onStateChange)
+	return queue
+}
+
+// ClassifyEmail classifies the email, waiting for the circuit breaker to
+// recover when it rejects the classification
+func (q *RetryQueue) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	result, err := q.Classifier.ClassifyEmail(ctx, profile, email)
+	if !errors.Is(err, ErrCircuitOpen) {
+		return result, err
+	}
+
+	log := logging.FromContext(ctx, q.logger).WithFields(logrus.Fields{
+		"email_id":   email.ID,
+		"profile_id": profile.ID,
+	})
+	recovered, queued := q.enqueue()
+	if !queued {
+		log.WithField("max_size", q.config.MaxSize).Warn("Retry queue full, failing classification")
+		return nil, fmt.Errorf("%w: %w", ErrRetryQueueFull, err)
+	}
+	defer q.dequeue()
+	log.Info("Circuit breaker open, queueing classification")
+
+	deadline := time.NewTimer(q.config.MaxWait)
+	defer deadline.Stop()
+	poll := time.NewTicker(retryQueuePoll)
+	defer poll.Stop()
+	for {
+		if q.available(profile) {
+			result, err = q.Classifier.ClassifyEmail(ctx, profile, email)
+			if !errors.Is(err, ErrCircuitOpen) {
+				return result, err
+			}
+		}
+
+		select {
+		case <-recovered:
+		case <-poll.C:
+		case <-deadline.C:
+			log.WithField("max_wait", q.config.MaxWait).Warn("Circuit breaker did not recover, failing queued classification")
+			return nil, err
+		case <-ctx.Done():
+			return nil, err
+		}
+		// Taken before the state is checked again, so that no change is
+		// missed
+		recovered = q.signal()
+	}
+}
+
+// Len returns the number of classifications waiting for the breaker
+func (q *RetryQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.waiting
+}
+
+// Overflows returns the number of classifications failed because the queue
+// was full
+func (q *RetryQueue) Overflows() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.overflows
+}
+
+// Close closes the wrapped backend, if it can be closed
+func (q *RetryQueue) Close() error {
+	if closer, ok := q.Classifier.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// enqueue counts a waiting classification, returning the channel signaling
+// recovery, or false when the queue is full
+func (q *RetryQueue) enqueue() (<-chan struct{}, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.waiting >= q.config.MaxSize {
+		q.overflows++
+		return nil, false
+	}
+	q.waiting++
+	return q.recovered, true
+}
+
+// dequeue counts a classification that stopped waiting
+func (q *RetryQueue) dequeue() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.waiting--
+}
+
+// signal returns the channel signaling the next recovery
+func (q *RetryQueue) signal() <-chan struct{} {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.recovered
+}
+
+// available reports whether the breaker of the profile's model or of one of
+// its fallback models lets requests through
+func (q *RetryQueue) available(profile *types.Profile) bool {
+	for _, model := range append([]string{profile.Model}, profile.FallbackModels...) {
+		if q.breaker.GetCircuitBreakerState(model) != gobreaker.StateOpen {
+			return true
+		}
+	}
+	return false
+}
+
+// onStateChange wakes the waiting classifications when a breaker turns
+// half-open or closed
+func (q *RetryQueue) onStateChange(model string, from, to gobreaker.State) {
+	if to == gobreaker.StateOpen {
+		return
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	close(q.recovered)
+	q.recovered = make(chan struct{})
+}
@@ -0,0 +1,118 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestRetryQueueRecovers(t *testing.T) {
+	backend := &breakerBackend{state: gobreaker.StateOpen}
+	queue := NewRetryQueue(backend, backend, config.RetryQueueConfig{Enabled: true, MaxSize: 10, MaxWait: time.Minute}, testLogger())
+
+	results := make(chan error, 1)
+	go func() {
+		_, err := queue.ClassifyEmail(context.Background(), &types.Profile{ID: "spam"}, &types.Email{ID: "email-1"})
+		results <- err
+	}()
+	require.Eventually(t, func() bool { return queue.Len() == 1 }, time.Second, time.Millisecond, "the rejected email is queued")
+
+	backend.setState(gobreaker.StateHalfOpen)
+	select {
+	case err := <-results:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the queued email was not submitted again")
+	}
+	assert.Equal(t, 2, backend.count(), "rejected once, then classified")
+	assert.Equal(t, 0, queue.Len())
+}
+
+func TestRetryQueueOverflow(t *testing.T) {
+	backend := &breakerBackend{state: gobreaker.StateOpen}
+	queue := NewRetryQueue(backend, backend, config.RetryQueueConfig{Enabled: true, MaxSize: 1, MaxWait: time.Minute}, testLogger())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := queue.ClassifyEmail(ctx, &types.Profile{ID: "spam"}, &types.Email{ID: "email-1"})
+		waiting <- err
+	}()
+	require.Eventually(t, func() bool { return queue.Len() == 1 }, time.Second, time.Millisecond)
+
+	_, err := queue.ClassifyEmail(context.Background(), &types.Profile{ID: "spam"}, &types.Email{ID: "email-2"})
+	assert.ErrorIs(t, err, ErrRetryQueueFull)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 1, queue.Overflows())
+
+	cancel()
+	assert.ErrorIs(t, <-waiting, ErrCircuitOpen, "a cancelled request stops waiting")
+	assert.Equal(t, 0, queue.Len())
+}
+
+func TestRetryQueueMaxWait(t *testing.T) {
+	backend := &breakerBackend{state: gobreaker.StateOpen}
+	queue := NewRetryQueue(backend, backend, config.RetryQueueConfig{Enabled: true, MaxSize: 10, MaxWait: 20 * time.Millisecond}, testLogger())
+
+	_, err := queue.ClassifyEmail(context.Background(), &types.Profile{ID: "spam"}, &types.Email{ID: "email-1"})
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 1, backend.count())
+}
+
+func TestRetryQueuePassesOtherErrors(t *testing.T) {
+	backend := &breakerBackend{state: gobreaker.StateClosed, countingClassifier: countingClassifier{err: ErrModelNotFound}}
+	queue := NewRetryQueue(backend, backend, config.RetryQueueConfig{Enabled: true, MaxSize: 10, MaxWait: time.Minute}, testLogger())
+
+	_, err := queue.ClassifyEmail(context.Background(), &types.Profile{ID: "spam"}, &types.Email{ID: "email-1"})
+	assert.ErrorIs(t, err, ErrModelNotFound)
+	assert.Equal(t, 1, backend.count(), "only breaker rejections are queued")
+}
+
+// Helper functions
+
+// breakerBackend is a backend behind a circuit breaker whose state the test
+// sets, rejecting classifications while it is open
+type breakerBackend struct {
+	countingClassifier
+	mutex     sync.Mutex
+	state     gobreaker.State
+	observers BreakerObservers
+}
+
+func (b *breakerBackend) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	if b.GetCircuitBreakerState(profile.Model) == gobreaker.StateOpen {
+		b.countingClassifier.mutex.Lock()
+		b.calls++
+		b.countingClassifier.mutex.Unlock()
+		return nil, WrapBreakerError(fmt.Errorf("ollama: %w", gobreaker.ErrOpenState))
+	}
+	return b.countingClassifier.ClassifyEmail(ctx, profile, email)
+}
+
+func (b *breakerBackend) GetCircuitBreakerState(model string) gobreaker.State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
+
+func (b *breakerBackend) OnBreakerStateChange(observer func(model string, from, to gobreaker.State)) {
+	b.observers.Add(observer)
+}
+
+// setState changes the breaker's state, notifying its observers
+func (b *breakerBackend) setState(state gobreaker.State) {
+	b.mutex.Lock()
+	from := b.state
+	b.state = state
+	b.mutex.Unlock()
+	b.observers.notify("", from, state)
+}
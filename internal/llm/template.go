@@ -0,0 +1,396 @@
+package llm
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// ResponseSchema is the JSON format every prompt asks the model to answer in
+const ResponseSchema = `{"action": "string", "confidence": number, "reasoning": "string"}`
+
+// PromptData is what a prompt template renders: the profile's system
+// prompt, the few-shot examples that apply to the email, the email and the
+// response schema. Templates can only reference these fields.
+type PromptData struct {
+	System   string
+	Examples []PromptExample
+	Email    PromptEmail
+	Schema   string
+}
+
+// PromptExample is a few-shot example of PromptData
+type PromptExample struct {
+	Name   string
+	Input  string
+	Output string
+}
+
+// PromptEmail is the email of PromptData. Unknown authentication results
+// are empty, so templates never dereference a missing value. SpoofedReply
+// is set when the subject claims a reply or a forward that the email
+// contradicts, Language is the ISO 639-1 code of the body's language or
+// "unknown", and EmptyBody is set when the body is empty or nearly so.
+type PromptEmail struct {
+	Subject      string
+	From         string
+	To           []string
+	Date         time.Time
+	Labels       []string
+	Body         string
+	Snippet      string
+	Preview      bool
+	Encrypted    bool
+	SpoofedReply bool
+	Language     string
+	EmptyBody    bool
+	Context      map[string]string
+	Auth         PromptAuth
+	Attachments  []PromptAttachment
+	Links        []PromptLink
+	Thread       []PromptMessage
+}
+
+// PromptAuth is the sender authentication of PromptEmail
+type PromptAuth struct {
+	SPF   string
+	DKIM  string
+	DMARC string
+}
+
+// PromptAttachment is an attachment of PromptEmail
+type PromptAttachment struct {
+	Filename string
+	MimeType string
+}
+
+// PromptLink is a link of PromptEmail with what makes it suspicious
+type PromptLink struct {
+	URL       string
+	TextHost  string
+	Mismatch  bool
+	Shortener bool
+}
+
+// PromptMessage is an earlier message of the thread of PromptEmail
+type PromptMessage struct {
+	From      string
+	Date      time.Time
+	Body      string
+	Truncated bool
+}
+
+// promptFuncs are the functions prompt templates can call besides the
+// text/template builtins
+var promptFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"quote": strconv.Quote,
+}
+
+// promptTemplates caches parsed prompt templates by their text
+var promptTemplates sync.Map
+
+// ParsePromptTemplate parses a prompt template, checking that it only
+// references fields of PromptData, even in branches a given email would not
+// take, so that a broken template fails when it is loaded
+func ParsePromptTemplate(text string) (*template.Template, error) {
+	if cached, ok := promptTemplates.Load(text); ok {
+		return cached.(*template.Template), nil
+	}
+
+	tmpl, err := template.New("prompt").Funcs(promptFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt template: %w", err)
+	}
+	checker := &templateChecker{
+		template: tmpl,
+		root:     reflect.TypeOf(PromptData{}),
+		vars:     make(map[string]reflect.Type),
+		checked:  make(map[string]bool),
+	}
+	if err := checker.walk(tmpl.Tree.Root, checker.root); err != nil {
+		return nil, fmt.Errorf("invalid prompt template: %w", err)
+	}
+
+	promptTemplates.Store(text, tmpl)
+	return tmpl, nil
+}
+
+// RenderPrompt builds the prompt for email with the profile's
+// prompt_template, or with BuildPrompt when it sets none
+func RenderPrompt(profile *types.Profile, email *types.Email) (string, error) {
+	if profile.PromptTemplate == "" {
+		return BuildPrompt(profile, email), nil
+	}
+
+	tmpl, err := ParsePromptTemplate(profile.PromptTemplate)
+	if err != nil {
+		return "", fmt.Errorf("profile %s: %w", profile.ID, err)
+	}
+	var prompt strings.Builder
+	if err := tmpl.Execute(&prompt, NewPromptData(profile, email)); err != nil {
+		return "", fmt.Errorf("profile %s: failed to render prompt template: %w", profile.ID, err)
+	}
+	return prompt.String(), nil
+}
+
+// NewPromptData returns the data a prompt template renders for email
+func NewPromptData(profile *types.Profile, email *types.Email) PromptData {
+	data := PromptData{
+		System: profile.System,
+		Schema: ResponseSchema,
+		Email: PromptEmail{
+			Subject:      email.Subject,
+			From:         email.From,
+			To:           email.To,
+			Date:         email.Date,
+			Labels:       email.Labels,
+			Body:         email.Body,
+			Snippet:      email.Snippet,
+			Preview:      email.Preview,
+			Encrypted:    email.IsEncrypted(),
+			SpoofedReply: email.IsSpoofedReply(),
+			Language:     email.Language,
+			EmptyBody:    email.EmptyBody,
+			Context:      email.Context,
+		},
+	}
+	for _, example := range ApplicableExamples(profile, email) {
+		data.Examples = append(data.Examples, PromptExample{Name: example.Name, Input: example.Input, Output: example.Output})
+	}
+	if email.Auth != nil {
+		data.Email.Auth = PromptAuth{SPF: email.Auth.SPF, DKIM: email.Auth.DKIM, DMARC: email.Auth.DMARC}
+	}
+	for _, attachment := range email.Attachments {
+		data.Email.Attachments = append(data.Email.Attachments, PromptAttachment{Filename: attachment.Filename, MimeType: attachment.MimeType})
+	}
+	for _, link := range email.URLs {
+		data.Email.Links = append(data.Email.Links, PromptLink{URL: link.URL, TextHost: link.TextHost, Mismatch: link.Mismatch, Shortener: link.Shortener})
+	}
+	for _, message := range email.Thread {
+		data.Email.Thread = append(data.Email.Thread, PromptMessage{From: message.From, Date: message.Date, Body: message.Body, Truncated: message.Truncated})
+	}
+	return data
+}
+
+// templateChecker follows the type of dot through a template's actions,
+// failing on any field the type does not have. A type it cannot know, such
+// as the result of a function, is not checked further.
+type templateChecker struct {
+	template *template.Template
+	root     reflect.Type
+	vars     map[string]reflect.Type
+	checked  map[string]bool
+}
+
+// walk checks a node with dot of type dot
+func (c *templateChecker) walk(node parse.Node, dot reflect.Type) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := c.walk(child, dot); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		_, err := c.pipe(n.Pipe, dot)
+		return err
+	case *parse.IfNode:
+		return c.branch(&n.BranchNode, dot, dot)
+	case *parse.WithNode:
+		typ, err := c.pipe(n.Pipe, dot)
+		if err != nil {
+			return err
+		}
+		return c.branch(&n.BranchNode, typ, dot)
+	case *parse.RangeNode:
+		typ, err := c.pipe(n.Pipe, dot)
+		if err != nil {
+			return err
+		}
+		elem := elemType(typ)
+		if decl := n.Pipe.Decl; len(decl) > 0 {
+			// One variable is the element; two are the key and the element
+			c.vars[decl[len(decl)-1].Ident[0]] = elem
+			if len(decl) == 2 {
+				c.vars[decl[0].Ident[0]] = keyType(typ)
+			}
+		}
+		return c.branch(&n.BranchNode, elem, dot)
+	case *parse.TemplateNode:
+		// Without a pipeline the invoked template's dot is nil
+		var typ reflect.Type
+		if n.Pipe != nil {
+			var err error
+			if typ, err = c.pipe(n.Pipe, dot); err != nil {
+				return err
+			}
+		}
+		return c.invoke(n.Name, typ)
+	}
+	return nil
+}
+
+// branch checks the body of if, with or range with dot of type inner and
+// its else branch with dot of type outer, pipe already being checked
+func (c *templateChecker) branch(n *parse.BranchNode, inner, outer reflect.Type) error {
+	if err := c.walk(n.List, inner); err != nil {
+		return err
+	}
+	return c.walk(n.ElseList, outer)
+}
+
+// invoke checks the template named name once per type of dot
+func (c *templateChecker) invoke(name string, dot reflect.Type) error {
+	key := fmt.Sprintf("%s\x00%v", name, dot)
+	if c.checked[key] {
+		return nil
+	}
+	c.checked[key] = true
+	invoked := c.template.Lookup(name)
+	if invoked == nil || invoked.Tree == nil {
+		return fmt.Errorf("template %q is not defined", name)
+	}
+	return c.walk(invoked.Tree.Root, dot)
+}
+
+// pipe checks a pipeline and returns the type of its result
+func (c *templateChecker) pipe(pipe *parse.PipeNode, dot reflect.Type) (reflect.Type, error) {
+	var typ reflect.Type
+	for _, cmd := range pipe.Cmds {
+		var err error
+		if typ, err = c.command(cmd, dot); err != nil {
+			return nil, err
+		}
+	}
+	if len(pipe.Decl) == 1 {
+		c.vars[pipe.Decl[0].Ident[0]] = typ
+	}
+	return typ, nil
+}
+
+// command checks every argument of a command and returns the type of its
+// result, unknown when it calls a function
+func (c *templateChecker) command(cmd *parse.CommandNode, dot reflect.Type) (reflect.Type, error) {
+	var result reflect.Type
+	for i, arg := range cmd.Args {
+		typ, err := c.arg(arg, dot)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			result = typ
+		}
+	}
+	return result, nil
+}
+
+// arg checks an argument and returns its type
+func (c *templateChecker) arg(node parse.Node, dot reflect.Type) (reflect.Type, error) {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return dot, nil
+	case *parse.FieldNode:
+		return c.fields(dot, n.Ident)
+	case *parse.ChainNode:
+		typ, err := c.arg(n.Node, dot)
+		if err != nil {
+			return nil, err
+		}
+		return c.fields(typ, n.Field)
+	case *parse.VariableNode:
+		typ := c.root
+		if n.Ident[0] != "$" {
+			typ = c.vars[n.Ident[0]]
+		}
+		return c.fields(typ, n.Ident[1:])
+	case *parse.PipeNode:
+		return c.pipe(n, dot)
+	}
+	return nil, nil
+}
+
+// fields follows a chain of field names from typ, returning the type of the
+// last one
+func (c *templateChecker) fields(typ reflect.Type, names []string) (reflect.Type, error) {
+	for _, name := range names {
+		if typ == nil {
+			return nil, nil
+		}
+		if method, ok := reflect.PointerTo(typ).MethodByName(name); ok {
+			typ = nil
+			if method.Type.NumOut() > 0 {
+				typ = method.Type.Out(0)
+			}
+			continue
+		}
+
+		switch typ.Kind() {
+		case reflect.Struct:
+			field, ok := typ.FieldByName(name)
+			if !ok || !field.IsExported() {
+				return nil, fmt.Errorf("%s has no field %s, use one of %s", typeName(typ), name, strings.Join(fieldNames(typ), ", "))
+			}
+			typ = field.Type
+		case reflect.Map:
+			typ = typ.Elem()
+		case reflect.Interface:
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("%s has no field %s", typeName(typ), name)
+		}
+	}
+	return typ, nil
+}
+
+// elemType returns the type range yields for elements of typ
+func elemType(typ reflect.Type) reflect.Type {
+	if typ == nil {
+		return nil
+	}
+	switch typ.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return typ.Elem()
+	}
+	return nil
+}
+
+// keyType returns the type range yields for keys of typ
+func keyType(typ reflect.Type) reflect.Type {
+	if typ != nil && typ.Kind() == reflect.Map {
+		return typ.Key()
+	}
+	return reflect.TypeOf(0)
+}
+
+// typeName names a prompt data type as templates see it
+func typeName(typ reflect.Type) string {
+	if typ == reflect.TypeOf(PromptData{}) {
+		return "the prompt data"
+	}
+	if typ.Name() == "" {
+		return typ.String()
+	}
+	return strings.TrimPrefix(typ.Name(), "Prompt")
+}
+
+// fieldNames returns the exported fields of a struct type
+func fieldNames(typ reflect.Type) []string {
+	var names []string
+	for i := 0; i < typ.NumField(); i++ {
+		if field := typ.Field(i); field.IsExported() {
+			names = append(names, field.Name)
+		}
+	}
+	return names
+}
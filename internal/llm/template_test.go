@@ -0,0 +1,101 @@
+package llm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestRenderPromptCustomTemplate(t *testing.T) {
+	profile := &types.Profile{
+		ID:     "billing",
+		System: "You triage invoices.",
+		FewShot: []types.FewShotExample{
+			{Name: "overdue", Input: "Invoice overdue", Output: `{"action": "star"}`},
+			{Name: "receipts", Input: "Your receipt", Output: `{"action": "archive"}`, When: "email.from endsWith '@shop.example'"},
+		},
+		PromptTemplate: `{{.System}}
+{{range $i, $example := .Examples}}Example {{$i}}: {{$example.Name}} -> {{$example.Output}}
+{{end}}Subject: {{.Email.Subject}}
+To: {{join .Email.To "; "}}
+Date: {{.Email.Date.Format "2006-01-02"}}
+{{with .Email.Auth}}{{if .DMARC}}DMARC: {{.DMARC}}
+{{end}}{{end}}{{range .Email.Links}}Link: {{quote .URL}}{{if .Mismatch}} shows {{.TextHost}}{{end}}
+{{end}}Context: {{.Email.Context.source}}
+Answer with {{.Schema}}`,
+	}
+	email := &types.Email{
+		ID:      "email-1",
+		Subject: "Invoice INV-1 overdue",
+		From:    "billing@vendor.example",
+		To:      []string{"ap@company.example", "cfo@company.example"},
+		Date:    time.Date(2026, 3, 4, 9, 0, 0, 0, time.UTC),
+		Auth:    &types.AuthResults{DMARC: types.AuthFail},
+		URLs:    []types.Link{{URL: "https://pay.example/x", TextHost: "vendor.example", Mismatch: true}},
+		Context: map[string]string{"source": "ap-inbox"},
+	}
+
+	prompt, err := RenderPrompt(profile, email)
+	require.NoError(t, err)
+	assert.Equal(t, `You triage invoices.
+Example 0: overdue -> {"action": "star"}
+Subject: Invoice INV-1 overdue
+To: ap@company.example; cfo@company.example
+Date: 2026-03-04
+DMARC: fail
+Link: "https://pay.example/x" shows vendor.example
+Context: ap-inbox
+Answer with `+ResponseSchema, prompt)
+
+	// Missing context keys and authentication results render empty
+	prompt, err = RenderPrompt(profile, &types.Email{Subject: "Hello"})
+	require.NoError(t, err)
+	assert.Contains(t, prompt, "Context: \nAnswer with")
+	assert.NotContains(t, prompt, "DMARC")
+}
+
+func TestRenderPromptFallsBackToBuiltIn(t *testing.T) {
+	profile := &types.Profile{ID: "billing", System: "You triage invoices."}
+	email := &types.Email{Subject: "Invoice overdue", Body: "Please pay."}
+
+	prompt, err := RenderPrompt(profile, email)
+	require.NoError(t, err)
+	assert.Equal(t, BuildPrompt(profile, email), prompt)
+}
+
+func TestParsePromptTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		errMsg   string
+	}{
+		{"valid", `{{.System}}{{range .Email.Thread}}{{.From}}: {{.Body}}{{end}}{{$.Email.Subject}}`, ""},
+		{"defined template", `{{define "link"}}{{.URL}}{{end}}{{range .Email.Links}}{{template "link" .}}{{end}}`, ""},
+		{"syntax error", `{{.Email.Subject`, "invalid prompt template"},
+		{"unknown root field", `{{.Sender}}`, "the prompt data has no field Sender"},
+		{"unknown email field", `{{.Email.Headers}}`, "Email has no field Headers, use one of Subject"},
+		{"untaken branch", `{{if .Email.Preview}}{{.Email.Snippet}}{{else}}{{.Email.Raw}}{{end}}`, "Email has no field Raw"},
+		{"range element", `{{range .Email.Attachments}}{{.Size}}{{end}}`, "Attachment has no field Size"},
+		{"range variable", `{{range $link := .Email.Links}}{{$link.Host}}{{end}}`, "Link has no field Host"},
+		{"root variable", `{{range .Examples}}{{$.Email.Sender}}{{end}}`, "Email has no field Sender"},
+		{"field of a string", `{{.Email.Subject.Value}}`, "string has no field Value"},
+		{"defined template body", `{{define "link"}}{{.Host}}{{end}}{{range .Email.Links}}{{template "link" .}}{{end}}`, "Link has no field Host"},
+		{"undefined template", `{{template "missing" .}}`, `template "missing" is not defined`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePromptTemplate(tt.template)
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package llm
+
+import (
+	"context"
+	"io"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// MetadataThreadContext is the response metadata key listing the IDs of the
+// earlier thread messages included in the prompt
+const MetadataThreadContext = "thread_context"
+
+// charsPerToken is the rough number of characters per token used to keep
+// thread context within its budget
+const charsPerToken = 4
+
+// ThreadFetcher is a mail provider able to list the messages of a thread
+type ThreadFetcher interface {
+	// GetThread returns the messages of a thread, oldest first
+	GetThread(ctx context.Context, threadID string) ([]*types.Email, error)
+}
+
+// ThreadClassifier includes the earlier messages of an email's thread in
+// its prompt, so that a reply is not classified in isolation. The most
+// recent messages are kept within the configured token budget. When the
+// thread cannot be fetched, the email is classified on its own.
+type ThreadClassifier struct {
+	Classifier
+	fetcher ThreadFetcher
+	config  config.ThreadContextConfig
+	logger  *logrus.Logger
+}
+
+// NewThreadClassifier wraps classifier with thread context fetched from
+// fetcher
+func NewThreadClassifier(classifier Classifier, fetcher ThreadFetcher, cfg config.ThreadContextConfig, logger *logrus.Logger) *ThreadClassifier {
+	return &ThreadClassifier{
+		Classifier: classifier,
+		fetcher:    fetcher,
+		config:     cfg,
+		logger:     logger,
+	}
+}
+
+// ClassifyEmail classifies the email with its thread context, listing the
+// included messages under MetadataThreadContext. Emails without a thread
+// ID, the first message of a thread, emails that already carry their
+// thread and previews, whose snippet pass should stay cheap, are classified
+// unchanged.
+func (t *ThreadClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	if email.ThreadID == "" || len(email.Thread) > 0 || email.Preview {
+		return t.Classifier.ClassifyEmail(ctx, profile, email)
+	}
+
+	messages, err := t.fetcher.GetThread(ctx, email.ThreadID)
+	if err != nil {
+		logging.FromContext(ctx, t.logger).WithError(err).WithFields(logrus.Fields{
+			"email_id":  email.ID,
+			"thread_id": email.ThreadID,
+		}).Warn("Failed to fetch thread, classifying without context")
+		return t.Classifier.ClassifyEmail(ctx, profile, email)
+	}
+
+	thread := t.SelectContext(email, messages)
+	if len(thread) == 0 {
+		return t.Classifier.ClassifyEmail(ctx, profile, email)
+	}
+
+	withThread := *email
+	withThread.Thread = thread
+	result, err := t.Classifier.ClassifyEmail(ctx, profile, &withThread)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(thread))
+	for i, message := range thread {
+		ids[i] = message.ID
+	}
+	if result.Metadata == nil {
+		result.Metadata = make(map[string]interface{})
+	}
+	result.Metadata[MetadataThreadContext] = ids
+	return result, nil
+}
+
+// SelectContext returns the messages of a thread before the email, oldest
+// first, keeping the most recent ones that fit the token budget and the
+// message limit. When even the most recent message does not fit, its body
+// is truncated to the budget.
+func (t *ThreadClassifier) SelectContext(email *types.Email, messages []*types.Email) []types.ThreadMessage {
+	earlier := messages
+	for i, message := range messages {
+		if message.ID == email.ID {
+			earlier = messages[:i]
+			break
+		}
+	}
+
+	budget := t.config.MaxTokens * charsPerToken
+	var selected []types.ThreadMessage
+	for i := len(earlier) - 1; i >= 0; i-- {
+		if t.config.MaxMessages > 0 && len(selected) == t.config.MaxMessages {
+			break
+		}
+
+		message := types.ThreadMessage{
+			ID:      earlier[i].ID,
+			From:    earlier[i].From,
+			Subject: earlier[i].Subject,
+			Date:    earlier[i].Date,
+			Body:    earlier[i].Body,
+		}
+		size := len(message.From) + len(message.Body)
+		if size > budget {
+			if len(selected) > 0 || budget <= len(message.From) {
+				break
+			}
+			message.Body = truncateUTF8(message.Body, budget-len(message.From))
+			message.Truncated = true
+			size = budget
+		}
+		budget -= size
+		selected = append(selected, message)
+	}
+
+	// Restore chronological order
+	for i, j := 0, len(selected)-1; i < j; i, j = i+1, j-1 {
+		selected[i], selected[j] = selected[j], selected[i]
+	}
+	return selected
+}
+
+// Close closes the wrapped backend, if it can be closed
+func (t *ThreadClassifier) Close() error {
+	if closer, ok := t.Classifier.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// truncateUTF8 cuts text to at most n bytes without splitting a character
+func truncateUTF8(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[:n]
+}
@@ -0,0 +1,160 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestThreadClassifierIncludesEarlierMessage(t *testing.T) {
+	original, reply := twoMessageThread()
+	fetcher := &staticThreadFetcher{threads: map[string][]*types.Email{"thread-1": {original, reply}}}
+	backend := &emailRecordingClassifier{}
+	classifier := NewThreadClassifier(backend, fetcher, config.ThreadContextConfig{Enabled: true, MaxTokens: 1000}, testLogger())
+
+	result, err := classifier.ClassifyEmail(context.Background(), &types.Profile{ID: "billing"}, reply)
+	require.NoError(t, err)
+
+	classified := backend.last()
+	require.Len(t, classified.Thread, 1)
+	assert.Equal(t, "msg-1", classified.Thread[0].ID)
+	assert.Equal(t, original.Body, classified.Thread[0].Body)
+	assert.False(t, classified.Thread[0].Truncated)
+	assert.Empty(t, reply.Thread, "the email itself is not modified")
+	assert.Equal(t, []string{"msg-1"}, result.Metadata[MetadataThreadContext])
+
+	prompt := BuildPrompt(&types.Profile{ID: "billing"}, classified)
+	assert.Contains(t, prompt, "Earlier messages in this thread")
+	assert.Less(t, strings.Index(prompt, "Please find the invoice attached."), strings.Index(prompt, "Classify this email:"))
+}
+
+func TestThreadClassifierWithoutContext(t *testing.T) {
+	original, reply := twoMessageThread()
+	tests := []struct {
+		name    string
+		email   *types.Email
+		fetcher *staticThreadFetcher
+	}{
+		{"no thread", &types.Email{ID: "msg-3", Subject: "Hello"}, &staticThreadFetcher{}},
+		{"single message", original, &staticThreadFetcher{threads: map[string][]*types.Email{"thread-1": {original}}}},
+		{"first message of the thread", original, &staticThreadFetcher{threads: map[string][]*types.Email{"thread-1": {original, reply}}}},
+		{"thread unavailable", reply, &staticThreadFetcher{err: errors.New("connection refused")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &emailRecordingClassifier{}
+			classifier := NewThreadClassifier(backend, tt.fetcher, config.ThreadContextConfig{Enabled: true, MaxTokens: 1000}, testLogger())
+
+			result, err := classifier.ClassifyEmail(context.Background(), &types.Profile{ID: "billing"}, tt.email)
+			require.NoError(t, err)
+			assert.Empty(t, backend.last().Thread)
+			assert.NotContains(t, result.Metadata, MetadataThreadContext)
+		})
+	}
+}
+
+func TestSelectContextBudget(t *testing.T) {
+	base := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	var messages []*types.Email
+	for i, id := range []string{"msg-1", "msg-2", "msg-3", "msg-4"} {
+		messages = append(messages, &types.Email{ID: id, From: "a@example.com", Date: base.Add(time.Duration(i) * time.Hour), Body: strings.Repeat("x", 100)})
+	}
+	email := messages[3]
+
+	tests := []struct {
+		name      string
+		config    config.ThreadContextConfig
+		expected  []string
+		truncated bool
+	}{
+		{"every message fits", config.ThreadContextConfig{MaxTokens: 1000}, []string{"msg-1", "msg-2", "msg-3"}, false},
+		{"most recent within budget", config.ThreadContextConfig{MaxTokens: 60}, []string{"msg-2", "msg-3"}, false},
+		{"message limit", config.ThreadContextConfig{MaxTokens: 1000, MaxMessages: 1}, []string{"msg-3"}, false},
+		{"newest message truncated", config.ThreadContextConfig{MaxTokens: 10}, []string{"msg-3"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			classifier := NewThreadClassifier(&countingClassifier{}, &staticThreadFetcher{}, tt.config, testLogger())
+			selected := classifier.SelectContext(email, messages)
+
+			var ids []string
+			for _, message := range selected {
+				ids = append(ids, message.ID)
+			}
+			assert.Equal(t, tt.expected, ids)
+			last := selected[len(selected)-1]
+			assert.Equal(t, tt.truncated, last.Truncated)
+			if tt.truncated {
+				assert.Len(t, last.Body, 10*charsPerToken-len(last.From))
+			}
+		})
+	}
+}
+
+// Helper functions
+
+// staticThreadFetcher returns fixed threads, or err when set
+type staticThreadFetcher struct {
+	threads map[string][]*types.Email
+	err     error
+}
+
+func (s *staticThreadFetcher) GetThread(ctx context.Context, threadID string) ([]*types.Email, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.threads[threadID], nil
+}
+
+// emailRecordingClassifier archives every email, recording the emails it
+// classifies
+type emailRecordingClassifier struct {
+	countingClassifier
+	mutex  sync.Mutex
+	emails []*types.Email
+}
+
+func (e *emailRecordingClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	e.mutex.Lock()
+	e.emails = append(e.emails, email)
+	e.mutex.Unlock()
+	return e.countingClassifier.ClassifyEmail(ctx, profile, email)
+}
+
+func (e *emailRecordingClassifier) last() *types.Email {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.emails[len(e.emails)-1]
+}
+
+// twoMessageThread returns an invoice and the reply to it
+func twoMessageThread() (*types.Email, *types.Email) {
+	original := &types.Email{
+		ID:       "msg-1",
+		ThreadID: "thread-1",
+		Subject:  "Invoice INV-1042",
+		From:     "billing@vendor.example",
+		Date:     time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC),
+		Body:     "Please find the invoice attached.",
+	}
+	reply := &types.Email{
+		ID:       "msg-2",
+		ThreadID: "thread-1",
+		Subject:  "Re: Invoice INV-1042",
+		From:     "billing@vendor.example",
+		Date:     time.Date(2026, 3, 3, 9, 0, 0, 0, time.UTC),
+		Body:     "Just following up on this.",
+	}
+	return original, reply
+}
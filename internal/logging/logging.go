@@ -0,0 +1,115 @@
+// Package logging configures the logrus output format and threads
+// correlation IDs through contexts, so that every log line and audit entry
+// of one email's journey (list, classify, resolve, action, audit) can be
+// joined in a log pipeline.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+// FieldCorrelationID is the log field and audit metadata key holding a
+// correlation ID
+const FieldCorrelationID = "correlation_id"
+
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+type correlationKey struct{}
+
+// Configure applies the configured format and, when set, level to logger
+func Configure(logger *logrus.Logger, cfg config.LoggingConfig) error {
+	switch cfg.Format {
+	case "", FormatText:
+		logger.SetFormatter(&logrus.TextFormatter{})
+	case FormatJSON:
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	if cfg.Level != "" {
+		level, err := logrus.ParseLevel(cfg.Level)
+		if err != nil {
+			return err
+		}
+		logger.SetLevel(level)
+	}
+	return nil
+}
+
+// NewCorrelationID returns a random correlation ID
+func NewCorrelationID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// MaxCorrelationIDLength caps the length of a correlation ID taken from a
+// client
+const MaxCorrelationIDLength = 64
+
+// ValidCorrelationID reports whether a client-supplied correlation ID is
+// safe to log and audit: 1 to MaxCorrelationIDLength letters, digits and
+// any of "-_.:". A slash is refused, as it joins a batch's ID to an email's.
+func ValidCorrelationID(id string) bool {
+	if id == "" || len(id) > MaxCorrelationIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WithCorrelationID returns a context carrying the correlation ID
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the context's correlation ID, or "" when it has none
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// ForEmail returns a context carrying the correlation ID of one email of a
+// batch: the batch's correlation ID, or a new one, followed by the email ID.
+// Deriving it the same way at each stage lets separate steps handling the
+// same batch, such as classification and applying actions, share it.
+func ForEmail(ctx context.Context, emailID string) context.Context {
+	batchID := CorrelationID(ctx)
+	if batchID == "" {
+		batchID = NewCorrelationID()
+	}
+	return WithCorrelationID(ctx, batchID+"/"+emailID)
+}
+
+// Bind returns a child logger adding the correlation ID to every entry
+func Bind(logger *logrus.Logger, id string) *logrus.Entry {
+	return logger.WithField(FieldCorrelationID, id)
+}
+
+// FromContext returns a child logger bound to the context's correlation ID,
+// or logging without one when the context has none
+func FromContext(ctx context.Context, logger *logrus.Logger) *logrus.Entry {
+	if id := CorrelationID(ctx); id != "" {
+		return Bind(logger, id)
+	}
+	return logrus.NewEntry(logger)
+}
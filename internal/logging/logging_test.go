@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+func TestConfigureJSON(t *testing.T) {
+	var output bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&output)
+	require.NoError(t, Configure(logger, config.LoggingConfig{Format: FormatJSON, Level: "debug"}))
+	assert.Equal(t, logrus.DebugLevel, logger.GetLevel())
+
+	ctx := ForEmail(WithCorrelationID(context.Background(), "batch-1"), "email-1")
+	FromContext(ctx, logger).WithField("email_id", "email-1").Debug("Classifying email")
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(output.Bytes(), &line))
+	assert.Equal(t, "batch-1/email-1", line[FieldCorrelationID])
+	assert.Equal(t, "email-1", line["email_id"])
+	assert.Equal(t, "Classifying email", line["msg"])
+}
+
+func TestConfigureRejectsUnknownSettings(t *testing.T) {
+	logger := logrus.New()
+	assert.Error(t, Configure(logger, config.LoggingConfig{Format: "xml"}))
+	assert.Error(t, Configure(logger, config.LoggingConfig{Level: "loud"}))
+}
+
+func TestForEmailGeneratesBatchID(t *testing.T) {
+	first := CorrelationID(ForEmail(context.Background(), "email-1"))
+	second := CorrelationID(ForEmail(context.Background(), "email-1"))
+
+	assert.True(t, strings.HasSuffix(first, "/email-1"))
+	assert.NotEqual(t, first, second, "each context without an ID gets a new one")
+}
+
+func TestFromContextWithoutID(t *testing.T) {
+	entry := FromContext(context.Background(), logrus.New())
+	assert.NotContains(t, entry.Data, FieldCorrelationID)
+	assert.Equal(t, "abc", Bind(logrus.New(), "abc").Data[FieldCorrelationID])
+}
+
+func TestValidCorrelationID(t *testing.T) {
+	assert.True(t, ValidCorrelationID("req-1"))
+	assert.True(t, ValidCorrelationID("trace:4bf92f35.span_1"))
+	assert.True(t, ValidCorrelationID(strings.Repeat("a", MaxCorrelationIDLength)))
+
+	assert.False(t, ValidCorrelationID(""))
+	assert.False(t, ValidCorrelationID(strings.Repeat("a", MaxCorrelationIDLength+1)))
+	assert.False(t, ValidCorrelationID("req 1"))
+	assert.False(t, ValidCorrelationID("req-1/email-1"), "a slash joins batch and email IDs")
+	assert.False(t, ValidCorrelationID("reqé"))
+}
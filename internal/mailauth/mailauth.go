@@ -0,0 +1,171 @@
+// Package mailauth extracts SPF, DKIM and DMARC verdicts from the
+// Authentication-Results (RFC 8601) and Received-SPF (RFC 7208) headers of
+// an email.
+package mailauth
+
+import (
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Header names carrying authentication results
+const (
+	HeaderAuthenticationResults = "Authentication-Results"
+	HeaderReceivedSPF           = "Received-SPF"
+)
+
+// Parse combines Authentication-Results and Received-SPF header values, in
+// the order they appear in the message, into a single set of verdicts.
+// Headers are prepended as a message is relayed, so the topmost
+// Authentication-Results header comes from the receiving server. Only
+// headers with its authserv-id are trusted; results added by earlier hops,
+// or forged by the sender, are ignored. When the receiving server adds
+// several lines, the first to report a method wins. The topmost
+// Received-SPF header is only consulted when no trusted header reports SPF.
+// Methods no header reports are types.AuthNone.
+func Parse(authenticationResults, receivedSPF []string) *types.AuthResults {
+	results := &types.AuthResults{}
+	trusted := ""
+	for i, value := range authenticationResults {
+		server, verdicts := parseAuthenticationResults(value)
+		if i == 0 {
+			trusted = server
+		} else if server != trusted {
+			continue
+		}
+		fill(&results.SPF, verdicts["spf"])
+		fill(&results.DKIM, verdicts["dkim"])
+		fill(&results.DMARC, verdicts["dmarc"])
+	}
+
+	if results.SPF == "" && len(receivedSPF) > 0 {
+		if fields := strings.Fields(receivedSPF[0]); len(fields) > 0 && isResult(fields[0]) {
+			results.SPF = strings.ToLower(fields[0])
+		}
+	}
+
+	fill(&results.SPF, types.AuthNone)
+	fill(&results.DKIM, types.AuthNone)
+	fill(&results.DMARC, types.AuthNone)
+	return results
+}
+
+// FromHeader parses the authentication headers of an RFC 5322 message read
+// with net/mail
+func FromHeader(header mail.Header) *types.AuthResults {
+	mime := textproto.MIMEHeader(header)
+	return Parse(mime.Values(HeaderAuthenticationResults), mime.Values(HeaderReceivedSPF))
+}
+
+// parseAuthenticationResults returns the authserv-id and the verdict for
+// each method in one Authentication-Results value, such as
+//
+//	mx.google.com; dkim=pass header.i=@example.com;
+//	       spf=pass (google.com: domain of a@example.com designates ...) smtp.mailfrom=a@example.com;
+//	       dmarc=pass (p=REJECT sp=REJECT dis=NONE) header.from=example.com
+//
+// A message may carry several DKIM signatures; one passing signature is
+// enough for the method to pass.
+func parseAuthenticationResults(value string) (string, map[string]string) {
+	verdicts := make(map[string]string)
+
+	// The first statement is the authserv-id of the reporting server,
+	// optionally followed by a version
+	statements := splitStatements(value)
+	server := ""
+	if fields := strings.Fields(statements[0]); len(fields) > 0 {
+		server = strings.ToLower(fields[0])
+	}
+
+	for _, statement := range statements[1:] {
+		fields := strings.Fields(statement)
+		if len(fields) == 0 {
+			continue
+		}
+
+		method, result, found := strings.Cut(fields[0], "=")
+		if !found {
+			continue
+		}
+		// Methods may carry a version, as in dkim/1=pass
+		method, _, _ = strings.Cut(strings.ToLower(method), "/")
+		result = strings.ToLower(result)
+		if !isResult(result) {
+			continue
+		}
+
+		if existing, exists := verdicts[method]; !exists || (existing != types.AuthPass && result == types.AuthPass) {
+			verdicts[method] = result
+		}
+	}
+
+	return server, verdicts
+}
+
+// splitStatements splits a header value on semicolons, dropping
+// parenthesized comments (which may nest and contain semicolons) and
+// ignoring semicolons inside quoted strings
+func splitStatements(value string) []string {
+	var statements []string
+	var current strings.Builder
+	depth := 0
+	quoted := false
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && (quoted || depth > 0) && i+1 < len(value):
+			if depth == 0 {
+				current.WriteByte(c)
+				current.WriteByte(value[i+1])
+			}
+			i++
+		case depth > 0:
+			switch c {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+		case c == '"':
+			quoted = !quoted
+			current.WriteByte(c)
+		case quoted:
+			current.WriteByte(c)
+		case c == '(':
+			depth++
+			current.WriteByte(' ')
+		case c == ';':
+			statements = append(statements, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(c)
+		}
+	}
+
+	return append(statements, current.String())
+}
+
+// isResult reports whether s looks like a result keyword rather than a
+// stray token
+func isResult(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// fill sets *verdict to result unless it already holds one
+func fill(verdict *string, result string) {
+	if *verdict == "" && result != "" {
+		*verdict = result
+	}
+}
@@ -0,0 +1,112 @@
+package mailauth
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+const gmailPass = "mx.google.com; dkim=pass header.i=@example.com header.s=s1 header.b=abc; " +
+	"spf=pass (google.com: domain of a@example.com designates 203.0.113.1 as permitted sender; ok) smtp.mailfrom=a@example.com; " +
+	"dmarc=pass (p=REJECT sp=REJECT dis=NONE) header.from=example.com"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name                  string
+		authenticationResults []string
+		receivedSPF           []string
+		expected              types.AuthResults
+	}{
+		{
+			name:                  "all pass",
+			authenticationResults: []string{gmailPass},
+			expected:              types.AuthResults{SPF: "pass", DKIM: "pass", DMARC: "pass"},
+		},
+		{
+			name:                  "failures",
+			authenticationResults: []string{"mx.google.com; spf=softfail smtp.mailfrom=a@example.com; dkim=fail (bad signature) header.i=@example.com; dmarc=fail header.from=example.com"},
+			expected:              types.AuthResults{SPF: "softfail", DKIM: "fail", DMARC: "fail"},
+		},
+		{
+			name:     "missing headers",
+			expected: types.AuthResults{SPF: types.AuthNone, DKIM: types.AuthNone, DMARC: types.AuthNone},
+		},
+		{
+			name:                  "no results",
+			authenticationResults: []string{"mx.example.com; none"},
+			expected:              types.AuthResults{SPF: types.AuthNone, DKIM: types.AuthNone, DMARC: types.AuthNone},
+		},
+		{
+			name:                  "one passing dkim signature is enough",
+			authenticationResults: []string{"mx.google.com; dkim=fail header.i=@esp.example; dkim=pass header.i=@example.com"},
+			expected:              types.AuthResults{SPF: types.AuthNone, DKIM: "pass", DMARC: types.AuthNone},
+		},
+		{
+			name: "headers from other servers are ignored",
+			authenticationResults: []string{
+				"mx.google.com; spf=fail smtp.mailfrom=a@example.com; dmarc=fail header.from=example.com",
+				"relay.attacker.example; spf=pass; dkim=pass; dmarc=pass",
+			},
+			expected: types.AuthResults{SPF: "fail", DKIM: types.AuthNone, DMARC: "fail"},
+		},
+		{
+			name: "lines from the receiving server are merged",
+			authenticationResults: []string{
+				"mx.google.com; dmarc=fail header.from=example.com",
+				"MX.google.com; spf=softfail smtp.mailfrom=a@example.com; dmarc=pass",
+				"mx.google.com; dkim=pass header.i=@example.com",
+			},
+			expected: types.AuthResults{SPF: "softfail", DKIM: "pass", DMARC: "fail"},
+		},
+		{
+			name:                  "received-spf fallback",
+			authenticationResults: []string{"mx.google.com; dkim=pass header.i=@example.com"},
+			receivedSPF:           []string{"Neutral (google.com: 198.51.100.7 is neither permitted nor denied) client-ip=198.51.100.7;"},
+			expected:              types.AuthResults{SPF: "neutral", DKIM: "pass", DMARC: types.AuthNone},
+		},
+		{
+			name:                  "authentication-results spf beats received-spf",
+			authenticationResults: []string{"mx.google.com; spf=fail smtp.mailfrom=a@example.com"},
+			receivedSPF:           []string{"pass (spoofed) client-ip=198.51.100.7;"},
+			expected:              types.AuthResults{SPF: "fail", DKIM: types.AuthNone, DMARC: types.AuthNone},
+		},
+		{
+			name:                  "versions, case and quoted values",
+			authenticationResults: []string{`example.org 1; DKIM/1=PASS reason="good; signature" header.d=example.com; SPF=Pass`},
+			expected:              types.AuthResults{SPF: "pass", DKIM: "pass", DMARC: types.AuthNone},
+		},
+		{
+			name:                  "nested comments",
+			authenticationResults: []string{"mx.example.com; spf=pass (sender (via relay; a) ok) smtp.mailfrom=a@example.com; dmarc=fail"},
+			expected:              types.AuthResults{SPF: "pass", DKIM: types.AuthNone, DMARC: "fail"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, &tt.expected, Parse(tt.authenticationResults, tt.receivedSPF))
+		})
+	}
+}
+
+func TestFromHeader(t *testing.T) {
+	raw := "Authentication-Results: mx.example.com; dmarc=fail header.from=example.com\r\n" +
+		"Authentication-Results: " + gmailPass + "\r\n" +
+		"Received-SPF: softfail (transitioning) client-ip=198.51.100.7;\r\n" +
+		"From: Alice <alice@example.com>\r\n" +
+		"Subject: Hello\r\n" +
+		"\r\n" +
+		"Body\r\n"
+
+	message, err := mail.ReadMessage(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	// The topmost header is from mx.example.com, so the mx.google.com
+	// results below it are not trusted
+	assert.Equal(t, &types.AuthResults{SPF: "softfail", DKIM: types.AuthNone, DMARC: "fail"}, FromHeader(message.Header))
+}
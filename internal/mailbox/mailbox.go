@@ -0,0 +1,48 @@
+// Package mailbox creates the client of the mail provider selected by
+// mail.provider, so that callers read and act on emails the same way over
+// Gmail and IMAP.
+package mailbox
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/gmail"
+	"github.com/mailsentinel/core/internal/imap"
+	"github.com/mailsentinel/core/internal/processor"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Client is the surface the Gmail and IMAP clients share
+type Client interface {
+	processor.MailClient
+	ListEmails(ctx context.Context, query string, maxResults int64) ([]*types.Email, error)
+	GetEmail(ctx context.Context, messageID string) (*types.Email, error)
+	HealthCheck(ctx context.Context) error
+	SetAuditLogger(auditLogger *audit.Logger)
+}
+
+var (
+	_ Client = (*gmail.Client)(nil)
+	_ Client = (*imap.Client)(nil)
+)
+
+// New creates the client of the configured mail provider
+func New(cfg *config.Config, logger *logrus.Logger) (Client, error) {
+	switch cfg.Mail.Provider {
+	case "", config.MailProviderGmail:
+		client, err := gmail.NewClient(&cfg.Gmail, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Gmail client: %w", err)
+		}
+		return client, nil
+	case config.MailProviderIMAP:
+		return imap.NewClient(&cfg.Mail.IMAP, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown mail provider %q", cfg.Mail.Provider)
+	}
+}
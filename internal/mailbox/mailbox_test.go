@@ -0,0 +1,34 @@
+package mailbox
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/imap"
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+func TestNewSelectsProvider(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Mail.Provider = config.MailProviderIMAP
+	cfg.Mail.IMAP.Address = "imap.example.com:993"
+
+	client, err := New(cfg, testLogger())
+	require.NoError(t, err)
+	assert.IsType(t, &imap.Client{}, client)
+
+	cfg.Mail.Provider = "exchange"
+	_, err = New(cfg, testLogger())
+	assert.ErrorContains(t, err, `unknown mail provider "exchange"`)
+}
+
+// Helper functions
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
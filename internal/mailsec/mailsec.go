@@ -0,0 +1,136 @@
+// Package mailsec recognizes PGP (RFC 3156, RFC 4880) and S/MIME (RFC 8551)
+// signed and encrypted emails, so that their signatures and ciphertext are
+// not classified as if they were plain text.
+package mailsec
+
+import (
+	"strings"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Armor lines delimiting inline PGP blocks
+const (
+	armorSignedMessage = "-----BEGIN PGP SIGNED MESSAGE-----"
+	armorSignature     = "-----BEGIN PGP SIGNATURE-----"
+	armorMessage       = "-----BEGIN PGP MESSAGE-----"
+	armorMessageEnd    = "-----END PGP MESSAGE-----"
+)
+
+// FromMediaType returns the protection a MIME entity's media type and
+// parameters declare, or nil for an unprotected entity. The first part of a
+// multipart/signed entity is its signed payload and the second its
+// signature; the content of an encrypted entity cannot be read.
+func FromMediaType(mediaType string, params map[string]string) *types.MessageSecurity {
+	switch strings.ToLower(mediaType) {
+	case "multipart/signed":
+		return &types.MessageSecurity{Signed: true, Protocol: protocol(params["protocol"])}
+	case "multipart/encrypted":
+		return &types.MessageSecurity{Encrypted: true, Protocol: protocol(params["protocol"])}
+	case "application/pgp-encrypted":
+		return &types.MessageSecurity{Encrypted: true, Protocol: types.SecurityPGP}
+	case "application/pkcs7-mime", "application/x-pkcs7-mime":
+		// Opaque signed data is as unreadable as enveloped data without a
+		// PKCS #7 parser, but it is not encrypted
+		switch strings.ToLower(params["smime-type"]) {
+		case "signed-data", "certs-only":
+			return &types.MessageSecurity{Signed: true, Protocol: types.SecuritySMIME}
+		default:
+			return &types.MessageSecurity{Encrypted: true, Protocol: types.SecuritySMIME}
+		}
+	}
+	return nil
+}
+
+// IsSignature reports whether a MIME type is a detached PGP or S/MIME
+// signature
+func IsSignature(mediaType string) bool {
+	switch strings.ToLower(mediaType) {
+	case "application/pgp-signature", "application/pkcs7-signature", "application/x-pkcs7-signature":
+		return true
+	}
+	return false
+}
+
+// Inline recognizes inline PGP in a plain text body. A clear-signed body is
+// replaced by the text it signs; encrypted blocks are removed, keeping any
+// text around them. Bodies without PGP armor are returned unchanged, with a
+// nil protection.
+func Inline(body string) (string, *types.MessageSecurity) {
+	if strings.Contains(body, armorMessage) {
+		return stripEncrypted(body), &types.MessageSecurity{Encrypted: true, Protocol: types.SecurityPGP}
+	}
+	if strings.Contains(body, armorSignedMessage) {
+		return clearText(body), &types.MessageSecurity{Signed: true, Protocol: types.SecurityPGP}
+	}
+	return body, nil
+}
+
+// Mark records the protection of a MIME entity in an email. An email can
+// combine several protections, such as a signed payload inside an
+// encrypted one; the first protocol found is kept.
+func Mark(email *types.Email, security *types.MessageSecurity) {
+	if security == nil {
+		return
+	}
+	if email.Security == nil {
+		email.Security = &types.MessageSecurity{Protocol: security.Protocol}
+	}
+	email.Security.Signed = email.Security.Signed || security.Signed
+	email.Security.Encrypted = email.Security.Encrypted || security.Encrypted
+}
+
+// protocol maps the protocol parameter of a multipart/signed or
+// multipart/encrypted entity to types.SecurityPGP or types.SecuritySMIME
+func protocol(value string) string {
+	value = strings.ToLower(value)
+	switch {
+	case strings.Contains(value, "pgp"):
+		return types.SecurityPGP
+	case strings.Contains(value, "pkcs7"):
+		return types.SecuritySMIME
+	}
+	return value
+}
+
+// stripEncrypted removes the PGP message blocks from a body
+func stripEncrypted(body string) string {
+	var text strings.Builder
+	for {
+		start := strings.Index(body, armorMessage)
+		if start < 0 {
+			text.WriteString(body)
+			break
+		}
+		text.WriteString(body[:start])
+		end := strings.Index(body[start:], armorMessageEnd)
+		if end < 0 {
+			break
+		}
+		body = body[start+end+len(armorMessageEnd):]
+	}
+	return strings.TrimSpace(text.String())
+}
+
+// clearText returns the text of a clear-signed body: the lines between the
+// armor headers and the signature, with their dash escaping undone. A body
+// whose signature is missing is returned unchanged.
+func clearText(body string) string {
+	_, signed, _ := strings.Cut(body, armorSignedMessage)
+	signed, _, found := strings.Cut(signed, armorSignature)
+	if !found {
+		return body
+	}
+
+	lines := strings.Split(strings.ReplaceAll(signed, "\r\n", "\n"), "\n")
+	// The armor line is followed by Hash headers up to an empty line
+	start := 1
+	for start < len(lines) && lines[start] != "" {
+		start++
+	}
+	var text []string
+	for _, line := range lines[min(start+1, len(lines)):] {
+		text = append(text, strings.TrimPrefix(line, "- "))
+	}
+	return strings.TrimSpace(strings.Join(text, "\n"))
+}
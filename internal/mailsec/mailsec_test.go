@@ -0,0 +1,75 @@
+package mailsec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestFromMediaType(t *testing.T) {
+	tests := []struct {
+		name      string
+		mediaType string
+		params    map[string]string
+		expected  *types.MessageSecurity
+	}{
+		{"pgp signed", "multipart/signed", map[string]string{"protocol": "application/pgp-signature"}, &types.MessageSecurity{Signed: true, Protocol: types.SecurityPGP}},
+		{"smime signed", "multipart/signed", map[string]string{"protocol": "application/pkcs7-signature"}, &types.MessageSecurity{Signed: true, Protocol: types.SecuritySMIME}},
+		{"pgp encrypted", "multipart/encrypted", map[string]string{"protocol": "application/pgp-encrypted"}, &types.MessageSecurity{Encrypted: true, Protocol: types.SecurityPGP}},
+		{"smime enveloped", "application/pkcs7-mime", map[string]string{"smime-type": "enveloped-data"}, &types.MessageSecurity{Encrypted: true, Protocol: types.SecuritySMIME}},
+		{"smime opaque signed", "application/x-pkcs7-mime", map[string]string{"smime-type": "signed-data"}, &types.MessageSecurity{Signed: true, Protocol: types.SecuritySMIME}},
+		{"plain text", "text/plain", nil, nil},
+		{"mixed", "multipart/mixed", map[string]string{"boundary": "b"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, FromMediaType(tt.mediaType, tt.params))
+		})
+	}
+}
+
+func TestIsSignature(t *testing.T) {
+	assert.True(t, IsSignature("application/pgp-signature"))
+	assert.True(t, IsSignature("application/PKCS7-Signature"))
+	assert.False(t, IsSignature("application/pdf"))
+}
+
+func TestInline(t *testing.T) {
+	t.Run("encrypted", func(t *testing.T) {
+		body, security := Inline("Sent from my phone\n-----BEGIN PGP MESSAGE-----\n\nhF4DdGhp\n=ab1C\n-----END PGP MESSAGE-----\n")
+		assert.Equal(t, "Sent from my phone", body)
+		assert.Equal(t, &types.MessageSecurity{Encrypted: true, Protocol: types.SecurityPGP}, security)
+	})
+
+	t.Run("clear signed", func(t *testing.T) {
+		body, security := Inline("-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA512\n\nShip it.\n-----BEGIN PGP SIGNATURE-----\n\niHUE\n-----END PGP SIGNATURE-----\n")
+		assert.Equal(t, "Ship it.", body)
+		assert.Equal(t, &types.MessageSecurity{Signed: true, Protocol: types.SecurityPGP}, security)
+	})
+
+	t.Run("signature missing", func(t *testing.T) {
+		raw := "-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA512\n\nShip it.\n"
+		body, security := Inline(raw)
+		assert.Equal(t, raw, body)
+		assert.True(t, security.Signed)
+	})
+
+	t.Run("plain", func(t *testing.T) {
+		body, security := Inline("Hello")
+		assert.Equal(t, "Hello", body)
+		assert.Nil(t, security)
+	})
+}
+
+func TestMark(t *testing.T) {
+	email := &types.Email{}
+	Mark(email, nil)
+	assert.Nil(t, email.Security)
+
+	Mark(email, &types.MessageSecurity{Encrypted: true, Protocol: types.SecuritySMIME})
+	Mark(email, &types.MessageSecurity{Signed: true, Protocol: types.SecurityPGP})
+	assert.Equal(t, &types.MessageSecurity{Signed: true, Encrypted: true, Protocol: types.SecuritySMIME}, email.Security)
+}
@@ -0,0 +1,262 @@
+// Package notify posts webhook notifications for classifications matching
+// configured rules, such as a phishing profile deleting an email with very
+// high confidence.
+//
+// Each rule names a URL and a text/template rendering the request body, so
+// Slack, Teams and generic HTTP receivers are all configured the same way.
+// The template is executed with a Notification; its json function encodes a
+// value as a JSON literal, for embedding subjects and reasoning safely. Every
+// delivery, successful or not, is recorded in the audit log.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Notification is the data a rule's template renders
+type Notification struct {
+	Rule       string    `json:"rule"`
+	EmailID    string    `json:"email_id"`
+	ProfileID  string    `json:"profile_id"`
+	Action     string    `json:"action"`
+	Confidence float64   `json:"confidence"`
+	Reasoning  string    `json:"reasoning,omitempty"`
+	Subject    string    `json:"subject,omitempty"`
+	From       string    `json:"from,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// rule is a configured rule with its parsed template
+type rule struct {
+	config.NotificationRule
+	template *template.Template
+}
+
+// matches reports whether a classification entry falls under the rule
+func (r *rule) matches(entry *audit.AuditEntry) bool {
+	return contains(r.Profiles, entry.ProfileID) &&
+		contains(r.Actions, entry.Action) &&
+		entry.Confidence >= r.MinConfidence
+}
+
+// contains reports whether values holds value, or is empty
+func contains(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// deliveryError is a failed delivery, retried unless permanent
+type deliveryError struct {
+	err       error
+	permanent bool
+}
+
+func (e *deliveryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *deliveryError) Unwrap() error {
+	return e.err
+}
+
+// Notifier delivers notifications for the audited classifications matching
+// its rules
+type Notifier struct {
+	config     config.NotificationsConfig
+	rules      []*rule
+	audit      *audit.Logger
+	logger     *logrus.Logger
+	httpClient *http.Client
+	deliveries sync.WaitGroup
+}
+
+// NewNotifier creates a notifier, parsing every rule's template. Register
+// its Observe method with the audit logger to feed it classifications.
+func NewNotifier(cfg config.NotificationsConfig, auditLogger *audit.Logger, logger *logrus.Logger) (*Notifier, error) {
+	funcs := template.FuncMap{"json": encodeJSON}
+
+	rules := make([]*rule, len(cfg.Rules))
+	for i, ruleConfig := range cfg.Rules {
+		r := &rule{NotificationRule: ruleConfig}
+		if ruleConfig.Template != "" {
+			parsed, err := template.New(ruleConfig.Name).Funcs(funcs).Parse(ruleConfig.Template)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse template of notification rule %q: %w", ruleConfig.Name, err)
+			}
+			r.template = parsed
+		}
+		rules[i] = r
+	}
+
+	return &Notifier{
+		config:     cfg,
+		rules:      rules,
+		audit:      auditLogger,
+		logger:     logger,
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+// Observe notifies every rule matching a classification entry. Deliveries
+// run in the background so that they never hold up classification. It is
+// an audit.Observer.
+func (n *Notifier) Observe(entry audit.AuditEntry) {
+	if entry.EventType != audit.EventEmailClassified {
+		return
+	}
+	if shadow, _ := entry.Metadata[types.MetadataShadow].(bool); shadow {
+		return
+	}
+
+	ctx := context.Background()
+	if id, ok := entry.Metadata[logging.FieldCorrelationID].(string); ok {
+		ctx = logging.WithCorrelationID(ctx, id)
+	}
+
+	for _, r := range n.rules {
+		if !r.matches(&entry) {
+			continue
+		}
+		notification := newNotification(r.Name, &entry)
+		n.deliveries.Add(1)
+		go func(r *rule) {
+			defer n.deliveries.Done()
+			n.deliver(ctx, r, notification)
+		}(r)
+	}
+}
+
+// Close waits for deliveries in flight, including their retries
+func (n *Notifier) Close() error {
+	n.deliveries.Wait()
+	return nil
+}
+
+// newNotification builds the notification for a classification entry
+func newNotification(ruleName string, entry *audit.AuditEntry) Notification {
+	subject, _ := entry.Metadata["email_subject"].(string)
+	from, _ := entry.Metadata["email_from"].(string)
+	return Notification{
+		Rule:       ruleName,
+		EmailID:    entry.EmailID,
+		ProfileID:  entry.ProfileID,
+		Action:     entry.Action,
+		Confidence: entry.Confidence,
+		Reasoning:  entry.Reasoning,
+		Subject:    subject,
+		From:       from,
+		Timestamp:  entry.Timestamp,
+	}
+}
+
+// deliver posts a notification, retrying failures after the retry delay,
+// and audits the outcome
+func (n *Notifier) deliver(ctx context.Context, r *rule, notification Notification) {
+	logger := logging.FromContext(ctx, n.logger).WithFields(logrus.Fields{
+		"rule":     r.Name,
+		"email_id": notification.EmailID,
+	})
+
+	attempts := 0
+	body, err := n.render(r, notification)
+	if err == nil {
+		for {
+			attempts++
+			err = n.post(r, body)
+
+			var failed *deliveryError
+			if err == nil || (errors.As(err, &failed) && failed.permanent) || attempts > n.config.Retries {
+				break
+			}
+			logger.WithError(err).WithField("attempt", attempts).Warn("Notification delivery failed, retrying")
+			time.Sleep(n.config.RetryDelay)
+		}
+	}
+
+	if err != nil {
+		logger.WithError(err).Error("Failed to deliver notification")
+	} else {
+		logger.Info("Delivered notification")
+	}
+	if auditErr := n.audit.LogNotification(ctx, notification.EmailID, notification.ProfileID, notification.Action, r.Name, attempts, err); auditErr != nil {
+		logger.WithError(auditErr).Error("Failed to audit notification")
+	}
+}
+
+// render executes the rule's template, or encodes the notification as JSON
+// when the rule has none
+func (n *Notifier) render(r *rule, notification Notification) ([]byte, error) {
+	if r.template == nil {
+		return json.Marshal(notification)
+	}
+	var body bytes.Buffer
+	if err := r.template.Execute(&body, notification); err != nil {
+		return nil, fmt.Errorf("failed to render notification: %w", err)
+	}
+	return body.Bytes(), nil
+}
+
+// post sends a rendered notification to the rule's URL. Client errors are
+// permanent; server errors and transport failures may be retried.
+func (n *Notifier) post(r *rule, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, r.URL, bytes.NewReader(body))
+	if err != nil {
+		return &deliveryError{err: fmt.Errorf("failed to create notification request: %w", err), permanent: true}
+	}
+	contentType := r.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range r.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		// The URL error names the URL, which for Slack and Teams is a secret
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return &deliveryError{err: fmt.Errorf("failed to post notification: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &deliveryError{
+			err:       fmt.Errorf("notification webhook returned status %d", resp.StatusCode),
+			permanent: resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests,
+		}
+	}
+	return nil
+}
+
+// encodeJSON is the json template function
+func encodeJSON(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	return string(data), err
+}
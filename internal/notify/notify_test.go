@@ -0,0 +1,219 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestNotifierPostsMatchingClassifications(t *testing.T) {
+	receiver := newMockReceiver()
+	defer receiver.Close()
+
+	auditLogger := newTestAudit(t)
+	notifier := newTestNotifier(t, auditLogger, config.NotificationRule{
+		Name:          "phishing-delete",
+		Profiles:      []string{"phishing"},
+		Actions:       []string{"delete"},
+		MinConfidence: 0.95,
+		URL:           receiver.URL,
+	})
+
+	ctx := logging.WithCorrelationID(context.Background(), "batch-1/email-1")
+	classify(t, ctx, auditLogger, "email-1", "phishing", "delete", 0.98)
+	classify(t, context.Background(), auditLogger, "email-2", "phishing", "delete", 0.9)
+	classify(t, context.Background(), auditLogger, "email-3", "phishing", "archive", 0.99)
+	classify(t, context.Background(), auditLogger, "email-4", "newsletter", "delete", 0.99)
+	require.NoError(t, notifier.Close())
+
+	requests := receiver.received()
+	require.Len(t, requests, 1, "only the high-confidence phishing delete notifies")
+	assert.Equal(t, "application/json", requests[0].contentType)
+
+	var notification Notification
+	require.NoError(t, json.Unmarshal(requests[0].body, &notification))
+	assert.Equal(t, "phishing-delete", notification.Rule)
+	assert.Equal(t, "email-1", notification.EmailID)
+	assert.Equal(t, "Urgent: verify your account", notification.Subject)
+	assert.Equal(t, 0.98, notification.Confidence)
+
+	entries := notifications(t, auditLogger)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "email-1", entries[0].EmailID)
+	assert.Equal(t, true, entries[0].Metadata["delivered"])
+	assert.Equal(t, float64(1), entries[0].Metadata["attempts"])
+	assert.Equal(t, "batch-1/email-1", entries[0].Metadata[logging.FieldCorrelationID])
+}
+
+func TestNotifierRendersTemplate(t *testing.T) {
+	receiver := newMockReceiver()
+	defer receiver.Close()
+
+	auditLogger := newTestAudit(t)
+	notifier := newTestNotifier(t, auditLogger, config.NotificationRule{
+		Name:     "slack",
+		URL:      receiver.URL,
+		Template: `{"text": {{json (printf "%s deleted %q (%.2f)" .ProfileID .Subject .Confidence)}}}`,
+		Headers:  map[string]string{"Authorization": "Bearer secret"},
+	})
+
+	classify(t, context.Background(), auditLogger, "email-1", "phishing", "delete", 0.98)
+	require.NoError(t, notifier.Close())
+
+	requests := receiver.received()
+	require.Len(t, requests, 1)
+	assert.Equal(t, "Bearer secret", requests[0].authorization)
+
+	var payload map[string]string
+	require.NoError(t, json.Unmarshal(requests[0].body, &payload), "quotes in the subject stay valid JSON")
+	assert.Equal(t, `phishing deleted "Urgent: verify your account" (0.98)`, payload["text"])
+}
+
+func TestNotifierRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		requests  int
+		delivered bool
+	}{
+		{"recovers after a server error", []int{http.StatusBadGateway, http.StatusOK}, 2, true},
+		{"gives up after the retries", []int{http.StatusServiceUnavailable}, 3, false},
+		{"does not retry a client error", []int{http.StatusNotFound}, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := newMockReceiver(tt.statuses...)
+			defer receiver.Close()
+
+			auditLogger := newTestAudit(t)
+			notifier := newTestNotifier(t, auditLogger, config.NotificationRule{Name: "all", URL: receiver.URL})
+
+			classify(t, context.Background(), auditLogger, "email-1", "phishing", "delete", 0.98)
+			require.NoError(t, notifier.Close())
+
+			assert.Len(t, receiver.received(), tt.requests)
+			entries := notifications(t, auditLogger)
+			require.Len(t, entries, 1)
+			assert.Equal(t, tt.delivered, entries[0].Metadata["delivered"])
+			assert.Equal(t, float64(tt.requests), entries[0].Metadata["attempts"])
+			if !tt.delivered {
+				assert.Contains(t, entries[0].Metadata["error"], "notification webhook returned status")
+			}
+		})
+	}
+}
+
+func TestNewNotifierRejectsInvalidTemplate(t *testing.T) {
+	_, err := NewNotifier(config.NotificationsConfig{
+		Rules: []config.NotificationRule{{Name: "broken", URL: "http://localhost", Template: "{{.Subject"}},
+	}, nil, testLogger())
+	assert.ErrorContains(t, err, `notification rule "broken"`)
+}
+
+// Helper functions
+
+// mockReceiver records webhook requests, answering each with the next of
+// its statuses and repeating the last one
+type mockReceiver struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	body          []byte
+	contentType   string
+	authorization string
+}
+
+func newMockReceiver(statuses ...int) *mockReceiver {
+	receiver := &mockReceiver{statuses: statuses}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		receiver.mutex.Lock()
+		receiver.requests = append(receiver.requests, receivedRequest{
+			body:          body,
+			contentType:   r.Header.Get("Content-Type"),
+			authorization: r.Header.Get("Authorization"),
+		})
+		status := http.StatusOK
+		if len(receiver.statuses) > 0 {
+			status = receiver.statuses[0]
+			if len(receiver.statuses) > 1 {
+				receiver.statuses = receiver.statuses[1:]
+			}
+		}
+		receiver.mutex.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	return receiver
+}
+
+func (m *mockReceiver) received() []receivedRequest {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]receivedRequest(nil), m.requests...)
+}
+
+func newTestAudit(t *testing.T) *audit.Logger {
+	auditLogger, err := audit.NewLogger(&config.AuditConfig{
+		Enabled:     true,
+		Directory:   t.TempDir(),
+		MaxFileSize: 1024 * 1024,
+		MaxFiles:    5,
+	}, testLogger())
+	require.NoError(t, err)
+	t.Cleanup(func() { auditLogger.Close() })
+	return auditLogger
+}
+
+func newTestNotifier(t *testing.T, auditLogger *audit.Logger, rule config.NotificationRule) *Notifier {
+	notifier, err := NewNotifier(config.NotificationsConfig{
+		Timeout:    time.Second,
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+		Rules:      []config.NotificationRule{rule},
+	}, auditLogger, testLogger())
+	require.NoError(t, err)
+	auditLogger.AddObserver(notifier.Observe)
+	return notifier
+}
+
+func classify(t *testing.T, ctx context.Context, auditLogger *audit.Logger, emailID, profileID, action string, confidence float64) {
+	email := &types.Email{ID: emailID, Subject: "Urgent: verify your account", From: "security@bank-example.net"}
+	require.NoError(t, auditLogger.LogEmailClassification(ctx, email, &types.ClassificationResponse{
+		ProfileID:  profileID,
+		Action:     action,
+		Confidence: confidence,
+	}))
+}
+
+func notifications(t *testing.T, auditLogger *audit.Logger) []audit.AuditEntry {
+	entries, err := auditLogger.Query(audit.Query{EventTypes: []string{audit.EventNotification}})
+	require.NoError(t, err)
+	return entries
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
@@ -4,15 +4,21 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/sony/gobreaker"
 
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/internal/llm"
+	"github.com/mailsentinel/core/internal/logging"
 	"github.com/mailsentinel/core/pkg/config"
 	"github.com/mailsentinel/core/pkg/types"
 )
@@ -21,9 +27,23 @@ import (
 type Client struct {
 	baseURL        string
 	httpClient     *http.Client
-	circuitBreaker *gobreaker.CircuitBreaker
+	breakers       *llm.Breakers
+	promptSizes    llm.PromptSizes
 	logger         *logrus.Logger
 	config         *config.OllamaConfig
+	audit          *audit.Logger
+	rawResponse    bool
+	emptyResponse  config.EmptyResponseConfig
+	digests        llm.ModelDigests
+	contexts       modelContexts
+	lastModelCheck modelCheck
+	lastProbe      healthProbe
+	healthMutex    sync.Mutex
+	clock          clock.Clock
+
+	loopMutex  sync.Mutex
+	stopHealth context.CancelFunc
+	healthDone chan struct{}
 }
 
 // GenerateRequest represents a request to Ollama's generate API
@@ -35,6 +55,9 @@ type GenerateRequest struct {
 	Format   string                 `json:"format,omitempty"`
 	Options  map[string]interface{} `json:"options,omitempty"`
 	Stream   bool                   `json:"stream"`
+	// KeepAlive is how long the model stays loaded after the request, such
+	// as "30m0s"; empty leaves Ollama's default
+	KeepAlive string `json:"keep_alive,omitempty"`
 }
 
 // Message represents a chat message
@@ -49,6 +72,7 @@ type GenerateResponse struct {
 	CreatedAt          time.Time `json:"created_at"`
 	Response           string    `json:"response"`
 	Done               bool      `json:"done"`
+	DoneReason         string    `json:"done_reason,omitempty"`
 	Context            []int     `json:"context,omitempty"`
 	TotalDuration      int64     `json:"total_duration,omitempty"`
 	LoadDuration       int64     `json:"load_duration,omitempty"`
@@ -59,49 +83,78 @@ type GenerateResponse struct {
 }
 
 // ModelInfo represents model information
-type ModelInfo struct {
-	Name       string    `json:"name"`
-	ModifiedAt time.Time `json:"modified_at"`
-	Size       int64     `json:"size"`
-	Digest     string    `json:"digest"`
-}
+type ModelInfo = llm.ModelInfo
 
 // ListModelsResponse represents the response from /api/tags
 type ListModelsResponse struct {
 	Models []ModelInfo `json:"models"`
 }
 
+// EmbedRequest represents a request to Ollama's embed API
+type EmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// EmbedResponse represents Ollama's embed response
+type EmbedResponse struct {
+	Model      string      `json:"model"`
+	Embeddings [][]float64 `json:"embeddings"`
+}
+
+// PullRequest represents a request to Ollama's pull API
+type PullRequest struct {
+	Model  string `json:"model"`
+	Stream bool   `json:"stream"`
+}
+
+// Client implements llm.Classifier, llm.Embedder and llm.BreakerObservable
+var (
+	_ llm.Classifier        = (*Client)(nil)
+	_ llm.Embedder          = (*Client)(nil)
+	_ llm.BreakerObservable = (*Client)(nil)
+)
+
 // NewClient creates a new Ollama client with circuit breaker
 func NewClient(cfg *config.OllamaConfig, logger *logrus.Logger) *Client {
-	// Configure circuit breaker
-	cbSettings := gobreaker.Settings{
-		Name:        "ollama-client",
-		MaxRequests: cfg.CircuitBreaker.MaxRequests,
-		Interval:    cfg.CircuitBreaker.Interval,
-		Timeout:     cfg.CircuitBreaker.Timeout,
-		ReadyToTrip: func(counts gobreaker.Counts) bool {
-			return counts.ConsecutiveFailures >= uint32(cfg.CircuitBreaker.ReadyToTrip)
-		},
-		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
-			logger.WithFields(logrus.Fields{
-				"circuit_breaker": name,
-				"from_state":      from,
-				"to_state":        to,
-			}).Info("Circuit breaker state changed")
-		},
-	}
-
 	return &Client{
 		baseURL: cfg.BaseURL,
 		httpClient: &http.Client{
 			Timeout: cfg.RequestTimeout,
 		},
-		circuitBreaker: gobreaker.NewCircuitBreaker(cbSettings),
-		logger:         logger,
-		config:         cfg,
+		breakers: llm.NewBreakers("ollama-client", cfg.CircuitBreaker, logger),
+		logger:   logger,
+		config:   cfg,
+		clock:    clock.Real{},
 	}
 }
 
+// SetAuditLogger makes the client record every successful classification in
+// the audit log. A nil logger disables auditing.
+func (c *Client) SetAuditLogger(auditLogger *audit.Logger) {
+	c.audit = auditLogger
+}
+
+// SetRawResponse makes the client include the exact text the model returned
+// in the metadata of every result, under llm.MetadataRawResponse
+func (c *Client) SetRawResponse(enabled bool) {
+	c.rawResponse = enabled
+}
+
+// SetEmptyResponse sets how the client retries a classification whose
+// model produced no output; without it such classifications fail at once
+func (c *Client) SetEmptyResponse(cfg config.EmptyResponseConfig) {
+	c.emptyResponse = cfg
+}
+
+// Close stops the health loop and releases the idle connections to Ollama.
+// Requests still in flight are not interrupted.
+func (c *Client) Close() error {
+	c.Stop()
+	c.httpClient.CloseIdleConnections()
+	return nil
+}
+
 // ClassifyEmailOld sends an email to Ollama for classification (old implementation)
 func (c *Client) ClassifyEmailOld(ctx context.Context, email *types.Email, profile *types.Profile) (*types.ClassificationResponse, error) {
 	startTime := time.Now()
@@ -142,19 +195,19 @@ func (c *Client) ClassifyEmailOld(ctx context.Context, email *types.Email, profi
 		Messages: messages,
 		Format:   "json",
 		Options: map[string]interface{}{
-			"temperature": profile.ModelParams.Temperature,
+			"temperature": profile.ModelParams.TemperatureOrZero(),
 			"num_predict": profile.ModelParams.MaxTokens,
 		},
 		Stream: false,
 	}
 
 	// Execute with circuit breaker
-	result, err := c.circuitBreaker.Execute(func() (interface{}, error) {
+	result, err := c.breakers.Execute(profile.Model, func() (interface{}, error) {
 		return c.generate(ctx, &request)
 	})
 	
 	if err != nil {
-		return nil, fmt.Errorf("classification failed: %w", err)
+		return nil, fmt.Errorf("classification failed: %w", llm.WrapBreakerError(err))
 	}
 	
 	response := result.(*GenerateResponse)
@@ -163,7 +216,7 @@ func (c *Client) ClassifyEmailOld(ctx context.Context, email *types.Email, profi
 	var classificationResult types.ClassificationResponse
 	if err := json.Unmarshal([]byte(response.Response), &classificationResult); err != nil {
 		c.logger.WithError(err).WithField("response", response.Response).Error("Failed to parse classification response")
-		return nil, fmt.Errorf("failed to parse classification response: %w", err)
+		return nil, fmt.Errorf("failed to parse classification response: %w: %w", ErrInvalidResponse, err)
 	}
 	
 	// Set metadata
@@ -203,23 +256,58 @@ func (c *Client) generate(ctx context.Context, request *GenerateRequest) (*Gener
 	
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, fmt.Errorf("request failed: %w", llm.WrapTransportError(err))
 	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		return nil, modelError(request.Model, &APIError{StatusCode: resp.StatusCode, Body: string(body)})
 	}
 	
 	var response GenerateResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+		return nil, fmt.Errorf("failed to decode response: %w: %w", ErrInvalidResponse, llm.WrapTransportError(err))
 	}
 	
 	return &response, nil
 }
 
+// Embed embeds texts with an embedding model using Ollama's embed API.
+// Requests bypass the circuit breaker, so that embedding failures never
+// stop classification.
+func (c *Client) Embed(ctx context.Context, model string, texts []string) ([][]float64, error) {
+	jsonData, err := json.Marshal(&EmbedRequest{Model: model, Input: texts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	
+	url := fmt.Sprintf("%s/api/embed", c.baseURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", llm.WrapTransportError(err))
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, modelError(model, &APIError{StatusCode: resp.StatusCode, Body: string(body)})
+	}
+	
+	var response EmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w: %w", ErrInvalidResponse, llm.WrapTransportError(err))
+	}
+	
+	return response.Embeddings, nil
+}
+
 // ListModels retrieves available models from Ollama
 func (c *Client) ListModels(ctx context.Context) ([]ModelInfo, error) {
 	url := fmt.Sprintf("%s/api/tags", c.baseURL)
@@ -230,13 +318,13 @@ func (c *Client) ListModels(ctx context.Context) ([]ModelInfo, error) {
 	
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, fmt.Errorf("request failed: %w", llm.WrapTransportError(err))
 	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 	
 	var response ListModelsResponse
@@ -244,208 +332,398 @@ func (c *Client) ListModels(ctx context.Context) ([]ModelInfo, error) {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 	
+	c.digests.Record(response.Models)
 	return response.Models, nil
 }
 
-// HealthCheck verifies Ollama connectivity and model availability
+// HealthCheck verifies Ollama connectivity and model availability. Within
+// HealthCheckPeriod of the last check its result is reused.
 func (c *Client) HealthCheck(ctx context.Context) error {
-	// Check if service is responding
-	models, err := c.ListModels(ctx)
-	if err != nil {
-		return fmt.Errorf("Ollama health check failed: %w", err)
-	}
-	
-	// Check if default model is available
-	defaultModel := c.config.DefaultModel
-	for _, model := range models {
-		if model.Name == defaultModel {
-			c.logger.WithField("model", defaultModel).Info("Default model is available")
-			return nil
-		}
-	}
-	
-	return fmt.Errorf("default model %s not found in available models", defaultModel)
+	return c.probeError(c.probe(ctx))
+}
+
+// GetCircuitBreakerState returns the current state of the circuit breaker
+// of model. Each model has its own breaker, so a failing model does not
+// reject requests to the others.
+func (c *Client) GetCircuitBreakerState(model string) gobreaker.State {
+	return c.breakers.State(model)
 }
 
-// GetCircuitBreakerState returns the current circuit breaker state
-func (c *Client) GetCircuitBreakerState() gobreaker.State {
-	return c.circuitBreaker.State()
+// OnBreakerStateChange calls observer on every state change of the circuit
+// breaker of any model
+func (c *Client) OnBreakerStateChange(observer func(model string, from, to gobreaker.State)) {
+	c.breakers.OnStateChange(observer)
 }
 
-// GetCircuitBreakerCounts returns the current circuit breaker counts
-func (c *Client) GetCircuitBreakerCounts() gobreaker.Counts {
-	return c.circuitBreaker.Counts()
+// GetCircuitBreakerCounts returns the current counts of the circuit breaker
+// of model
+func (c *Client) GetCircuitBreakerCounts(model string) gobreaker.Counts {
+	return c.breakers.Counts(model)
 }
 
-// ClassifyEmail classifies an email using the specified profile
+// ClassifyOptions overrides sampling for a single classification. Nil
+// fields use the profile's settings.
+type ClassifyOptions = llm.ClassifyOptions
+
+// ClassifyEmail classifies an email using the specified profile. If the
+// profile's primary model is unavailable, each of its fallback models is
+// tried in order; the model that served the request is recorded in the
+// response metadata.
 func (c *Client) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	return c.ClassifyEmailWithOptions(ctx, profile, email, ClassifyOptions{})
+}
+
+// ClassifyEmailWithOptions is ClassifyEmail with per-request sampling
+// overrides. Every request is sent with an explicit seed, random unless one
+// is given, and the seed and temperature used are recorded in the response
+// metadata so the classification can be replayed exactly.
+func (c *Client) ClassifyEmailWithOptions(ctx context.Context, profile *types.Profile, email *types.Email, opts ClassifyOptions) (*types.ClassificationResponse, error) {
+	profile = c.withDefaults(profile)
+	
 	// Build the prompt from profile and email
-	prompt := c.buildClassificationPrompt(profile, email)
+	prompt, err := c.buildClassificationPrompt(ctx, profile, email)
+	if err != nil {
+		return nil, err
+	}
+	params := llm.ResolveSampling(profile, opts, c.config.Deterministic, c.config.DeterministicSeed)
+	keepAlive := c.keepAlive(profile)
+	
+	models := append([]string{profile.Model}, profile.FallbackModels...)
+	
+	var lastErr error
+	for i, model := range models {
+		prompt, truncated, err := c.fitContext(ctx, model, profile, email, prompt, params)
+		if err != nil {
+			lastErr = err
+			if i < len(models)-1 {
+				logging.FromContext(ctx, c.logger).WithError(err).WithFields(logrus.Fields{
+					"profile_id":     profile.ID,
+					"model":          model,
+					"fallback_model": models[i+1],
+				}).Warn("Prompt exceeds the model's context, trying fallback model")
+				continue
+			}
+			return nil, err
+		}
+		response, err := c.generateForModel(ctx, model, prompt, params, keepAlive)
+		if err != nil {
+			lastErr = err
+			if llm.IsModelUnavailable(err) && i < len(models)-1 {
+				logging.FromContext(ctx, c.logger).WithError(err).WithFields(logrus.Fields{
+					"profile_id":     profile.ID,
+					"model":          model,
+					"fallback_model": models[i+1],
+				}).Warn("Model unavailable, trying fallback model")
+				continue
+			}
+			return nil, fmt.Errorf("classification request failed: %w", err)
+		}
+		
+		// Parse the response into classification result. Parse failures are
+		// genuine classification errors and never trigger a fallback; no
+		// output at all is retried once with a higher temperature, and output
+		// cut off at the token limit once with a higher limit.
+		classification, err := parseGeneration(response, profile, params)
+		retriedEmpty := false
+		if retry, ok := llm.EmptyRetrySampling(params, c.emptyResponse); ok && llm.IsEmptyResponse(err) {
+			logging.FromContext(ctx, c.logger).WithFields(logrus.Fields{
+				"profile_id":  profile.ID,
+				"model":       model,
+				"temperature": retry.Temperature,
+			}).Warn("Model produced no output, retrying with a higher temperature")
+
+			params, retriedEmpty = retry, true
+			response, err = c.generateForModel(ctx, model, prompt, params, keepAlive)
+			if err != nil {
+				return nil, fmt.Errorf("classification request failed: %w", err)
+			}
+			classification, err = parseGeneration(response, profile, params)
+		}
+		if retry, ok := llm.RetrySampling(params); ok && errors.Is(err, llm.ErrTruncatedResponse) {
+			logging.FromContext(ctx, c.logger).WithFields(logrus.Fields{
+				"profile_id": profile.ID,
+				"model":      model,
+				"max_tokens": retry.MaxTokens,
+			}).Warn("Model output truncated, retrying with a higher token limit")
+			
+			response, err = c.generateForModel(ctx, model, prompt, retry, keepAlive)
+			if err != nil {
+				return nil, fmt.Errorf("classification request failed: %w", err)
+			}
+			classification, err = parseGeneration(response, profile, retry)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse classification response: %w", err)
+		}
+		llm.MarkHomoglyphs(email, classification)
+		llm.MarkSpoofedReply(email, classification)
+		llm.MarkLanguage(email, classification)
+		llm.MarkEmptyBody(profile, email, classification)
+		if err := llm.PostProcess(profile, email, classification); err != nil {
+			logging.FromContext(ctx, c.logger).WithError(err).WithFields(logrus.Fields{
+				"email_id":   email.ID,
+				"profile_id": profile.ID,
+			}).Warn("Failed to evaluate post-processing rule")
+		}
+		
+		if classification.Metadata == nil {
+			classification.Metadata = make(map[string]interface{})
+		}
+		classification.EmailID = email.ID
+		classification.Metadata[llm.MetadataServedByModel] = model
+		classification.Metadata[MetadataSeed] = params.Seed
+		classification.Metadata[MetadataTemperature] = params.Temperature
+		classification.Metadata[MetadataLoadDuration] = time.Duration(response.LoadDuration).Milliseconds()
+		classification.Metadata[llm.MetadataPromptBytes] = len(prompt)
+		classification.Metadata[llm.MetadataPromptTokens] = llm.EstimateTokens(prompt)
+		if c.rawResponse {
+			classification.Metadata[llm.MetadataRawResponse] = response.Response
+		}
+		if retriedEmpty {
+			classification.Metadata[llm.MetadataEmptyRetry] = true
+		}
+		if truncated {
+			classification.Metadata[llm.MetadataPromptTruncated] = true
+		}
+		if i > 0 {
+			classification.Metadata[llm.MetadataFallbackFrom] = profile.Model
+		}
+		classification.Fingerprint = llm.NewFingerprint(profile, model, c.digests.Digest(model))
+		
+		c.auditClassification(ctx, email, classification)
+		return classification, nil
+	}
 	
-	// Create generate request
-	request := GenerateRequest{
-		Model:  profile.Model,
-		Prompt: prompt,
-		Stream: false,
-		Options: map[string]interface{}{
-			"temperature": profile.ModelParams.Temperature,
-			"num_predict": profile.ModelParams.MaxTokens,
-		},
+	return nil, fmt.Errorf("classification request failed: %w", lastErr)
+}
+
+// buildClassificationPrompt builds the prompt for an email and records its
+// size, failing with llm.ErrPromptTooLarge when it exceeds MaxPromptBytes
+func (c *Client) buildClassificationPrompt(ctx context.Context, profile *types.Profile, email *types.Email) (string, error) {
+	prompt, err := llm.RenderPrompt(profile, email)
+	if err != nil {
+		return "", err
 	}
+	c.promptSizes.Observe(len(prompt))
 	
-	// Make the request through circuit breaker
-	result, err := c.circuitBreaker.Execute(func() (interface{}, error) {
-		return c.generate(ctx, &request)
+	log := logging.FromContext(ctx, c.logger).WithFields(logrus.Fields{
+		"email_id":      email.ID,
+		"profile_id":    profile.ID,
+		"prompt_bytes":  len(prompt),
+		"prompt_tokens": llm.EstimateTokens(prompt),
 	})
-	
+	if limit := c.config.MaxPromptBytes; limit > 0 && len(prompt) > limit {
+		c.promptSizes.Reject()
+		log.WithField("max_prompt_bytes", limit).Warn("Prompt too large, refusing classification")
+		return "", fmt.Errorf("%w: %d bytes exceeds ollama.max_prompt_bytes of %d", llm.ErrPromptTooLarge, len(prompt), limit)
+	}
+	log.Debug("Built classification prompt")
+	return prompt, nil
+}
+
+// fitContext returns the prompt to send to model, past whose context length
+// Ollama would silently cut it: prompt itself when its estimated tokens and
+// the output token limit fit, else the email's prompt with its thread and
+// body cut to fit, reporting the cut. It fails with llm.ErrPromptTooLarge
+// when even that does not fit. Models reporting no context length are not
+// checked.
+func (c *Client) fitContext(ctx context.Context, model string, profile *types.Profile, email *types.Email, prompt string, params llm.Sampling) (string, bool, error) {
+	length := c.contextLength(ctx, model)
+	tokens := llm.EstimateTokens(prompt)
+	if length == 0 || tokens+params.MaxTokens <= length {
+		return prompt, false, nil
+	}
+
+	fitted, err := llm.FitPrompt(profile, email, length-params.MaxTokens)
 	if err != nil {
-		return nil, fmt.Errorf("classification request failed: %w", err)
+		c.promptSizes.Reject()
+		return "", false, fmt.Errorf("%w: an estimated %d prompt tokens and %d output tokens exceed the %d token context of %s", llm.ErrPromptTooLarge, tokens, params.MaxTokens, length, model)
+	}
+	logging.FromContext(ctx, c.logger).WithFields(logrus.Fields{
+		"email_id":      email.ID,
+		"profile_id":    profile.ID,
+		"model":         model,
+		"prompt_tokens": tokens,
+		"context":       length,
+	}).Info("Prompt exceeds the model's context, cutting the email to fit")
+	return fitted, true, nil
+}
+
+// PromptSizes returns the sizes of the prompts built so far
+func (c *Client) PromptSizes() llm.PromptSizeSnapshot {
+	return c.promptSizes.Snapshot()
+}
+
+// parseGeneration parses a generation into a classification, treating a
+// parse failure as truncation when Ollama stopped at the token limit
+func parseGeneration(response *GenerateResponse, profile *types.Profile, params llm.Sampling) (*types.ClassificationResponse, error) {
+	classification, err := llm.ParseResponse(response.Response, profile)
+	stoppedAtLimit := response.DoneReason == "length" || (params.MaxTokens > 0 && response.EvalCount >= params.MaxTokens)
+	return classification, llm.CheckTruncation(err, stoppedAtLimit, profile)
+}
+
+// auditClassification records a classification in the audit log, if one is
+// configured. Audit failures are logged but do not fail the classification.
+func (c *Client) auditClassification(ctx context.Context, email *types.Email, classification *types.ClassificationResponse) {
+	if c.audit == nil {
+		return
 	}
 	
-	response := result.(*GenerateResponse)
+	if err := c.audit.LogEmailClassification(ctx, email, classification); err != nil {
+		logging.FromContext(ctx, c.logger).WithError(err).WithField("email_id", email.ID).Error("Failed to audit classification")
+	}
+}
+
+// Response metadata keys recording the sampling used for a classification
+const (
+	MetadataSeed        = llm.MetadataSeed
+	MetadataTemperature = llm.MetadataTemperature
+)
+
+// MetadataLoadDuration is the response metadata key recording how many
+// milliseconds Ollama spent loading the model, which is zero when the model
+// was already loaded
+const MetadataLoadDuration = "load_duration_ms"
+
+// DefaultPreloadKeepAlive is how long Preload keeps a model loaded when no
+// keep-alive is configured
+const DefaultPreloadKeepAlive = 30 * time.Minute
+
+// withDefaults returns a copy of the profile whose unset model parameters
+// are taken from the configured default model parameters
+func (c *Client) withDefaults(profile *types.Profile) *types.Profile {
+	merged := *profile
+	merged.ModelParams = profile.ModelParams.WithDefaults(c.config.DefaultModelParams)
+	return &merged
+}
+
+// samplingOptions returns the Ollama request options for a sampling. The
+// temperature and seed are always sent; the token limit, top_p and top_k
+// only when set, leaving Ollama's defaults otherwise.
+func samplingOptions(params llm.Sampling) map[string]interface{} {
+	options := map[string]interface{}{
+		"temperature": params.Temperature,
+		"seed":        params.Seed,
+	}
+	if params.MaxTokens > 0 {
+		options["num_predict"] = params.MaxTokens
+	}
+	if params.TopP > 0 {
+		options["top_p"] = params.TopP
+	}
+	if params.TopK > 0 {
+		options["top_k"] = params.TopK
+	}
+	return options
+}
+
+// keepAlive returns the keep-alive sent with the profile's requests: the
+// profile's own, else the configured one, else none
+func (c *Client) keepAlive(profile *types.Profile) string {
+	if profile.ModelParams.KeepAlive != "" {
+		return profile.ModelParams.KeepAlive
+	}
+	if c.config.KeepAlive != 0 {
+		return c.config.KeepAlive.String()
+	}
+	return ""
+}
+
+// Preload loads a model into memory ahead of a batch with an empty
+// generate request, keeping it loaded for the configured keep-alive or
+// DefaultPreloadKeepAlive. Preloading bypasses the circuit breaker, so that
+// a failed warm-up never stops classification.
+func (c *Client) Preload(ctx context.Context, model string) error {
+	keepAlive := DefaultPreloadKeepAlive.String()
+	if c.config.KeepAlive != 0 {
+		keepAlive = c.config.KeepAlive.String()
+	}
 	
-	// Parse the response into classification result
-	classification, err := c.parseClassificationResponse(response.Response, profile)
+	response, err := c.generate(ctx, &GenerateRequest{Model: model, KeepAlive: keepAlive})
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse classification response: %w", err)
+		return fmt.Errorf("failed to preload model %s: %w", model, err)
 	}
 	
-	return classification, nil
+	logging.FromContext(ctx, c.logger).WithFields(logrus.Fields{
+		"model":            model,
+		"keep_alive":       keepAlive,
+		"load_duration_ms": time.Duration(response.LoadDuration).Milliseconds(),
+	}).Info("Model preloaded")
+	return nil
 }
 
-// buildClassificationPrompt constructs the prompt for email classification
-func (c *Client) buildClassificationPrompt(profile *types.Profile, email *types.Email) string {
-	var prompt strings.Builder
-	
-	// Add system prompt with strict JSON enforcement
-	prompt.WriteString("System: ")
-	prompt.WriteString(profile.System)
-	prompt.WriteString(" You must respond with valid JSON only, no markdown, no explanations, no code blocks.")
-	prompt.WriteString("\n\n")
-	
-	// Add few-shot examples if available
-	for _, example := range profile.FewShot {
-		prompt.WriteString("Example: ")
-		prompt.WriteString(example.Name)
-		prompt.WriteString("\n")
-		prompt.WriteString("Input: ")
-		prompt.WriteString(example.Input)
-		prompt.WriteString("\n")
-		prompt.WriteString("Output: ")
-		prompt.WriteString(example.Output)
-		prompt.WriteString("\n\n")
-	}
-	
-	// Add the email to classify
-	prompt.WriteString("Classify this email:\n")
-	prompt.WriteString("Subject: ")
-	prompt.WriteString(email.Subject)
-	prompt.WriteString("\n")
-	prompt.WriteString("From: ")
-	prompt.WriteString(email.From)
-	prompt.WriteString("\n")
-	prompt.WriteString("To: ")
-	prompt.WriteString(strings.Join(email.To, ", "))
-	prompt.WriteString("\n")
-	prompt.WriteString("Body: ")
-	prompt.WriteString(email.Body)
-	prompt.WriteString("\n\n")
-	
-	// Add strict response format instruction
-	prompt.WriteString("\n\nIMPORTANT: You MUST respond with ONLY valid JSON in this exact format:\n")
-	prompt.WriteString(`{"action": "string", "confidence": number, "reasoning": "string"}`)
-	prompt.WriteString("\n\nDo NOT include any markdown formatting, explanations, or additional text.")
-	prompt.WriteString("\nDo NOT wrap the JSON in code blocks or backticks.")
-	prompt.WriteString("\nRespond with raw JSON only.")
-	
-	return prompt.String()
-}
-
-// parseClassificationResponse parses the LLM response into a classification result
-func (c *Client) parseClassificationResponse(response string, profile *types.Profile) (*types.ClassificationResponse, error) {
-	// Try to extract JSON from the response
-	var result map[string]interface{}
-	
-	// First try to extract from markdown code blocks
-	jsonStr := ""
-	if strings.Contains(response, "```json") {
-		start := strings.Index(response, "```json")
-		if start != -1 {
-			start += 7 // Skip "```json"
-			end := strings.Index(response[start:], "```")
-			if end != -1 {
-				jsonStr = strings.TrimSpace(response[start : start+end])
-			} else {
-				// Handle case where closing ``` is missing (truncated response)
-				jsonStr = strings.TrimSpace(response[start:])
-			}
-		}
+// EnsureModel pulls a model Ollama does not have yet, waiting until the
+// pull completes. Models already listed, by name or with Ollama's default
+// ":latest" tag, are left alone. Pulling bypasses the circuit breaker.
+func (c *Client) EnsureModel(ctx context.Context, model string) error {
+	models, err := c.ListModels(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list models: %w", err)
 	}
-	
-	// If no markdown block found, find JSON in the response
-	if jsonStr == "" {
-		start := strings.Index(response, "{")
-		end := strings.LastIndex(response, "}")
-		
-		if start == -1 || end == -1 || start >= end {
-			return nil, fmt.Errorf("no valid JSON found in response: %s", response)
+	for _, info := range models {
+		if info.Name == model || !strings.Contains(model, ":") && info.Name == model+":latest" {
+			return nil
 		}
-		
-		jsonStr = response[start : end+1]
-	}
-	if err := json.Unmarshal([]byte(jsonStr), &result); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
 	}
 	
-	// Extract required fields
-	action, ok := result["action"].(string)
-	if !ok {
-		return nil, fmt.Errorf("missing or invalid 'action' field in response")
+	logging.FromContext(ctx, c.logger).WithField("model", model).Info("Pulling model")
+	jsonData, err := json.Marshal(&PullRequest{Model: model})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 	
-	confidence, ok := result["confidence"].(float64)
-	if !ok {
-		return nil, fmt.Errorf("missing or invalid 'confidence' field in response")
+	url := fmt.Sprintf("%s/api/pull", c.baseURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	
-	reasoning, ok := result["reasoning"].(string)
-	if !ok {
-		reasoning = "No reasoning provided"
+	// A pull can take far longer than a classification request
+	resp, err := (&http.Client{Transport: c.httpClient.Transport}).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to pull model %s: %w", model, llm.WrapTransportError(err))
 	}
+	defer resp.Body.Close()
 	
-	// Validate confidence range
-	if confidence < 0.0 || confidence > 1.0 {
-		return nil, fmt.Errorf("confidence must be between 0.0 and 1.0, got %f", confidence)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to pull model %s: %w", model, &APIError{StatusCode: resp.StatusCode, Body: string(body)})
 	}
 	
-	// Create classification response
-	classification := &types.ClassificationResponse{
-		ProfileID:   profile.ID,
-		Action:      action,
-		Confidence:  confidence,
-		Reasoning:   reasoning,
-		ProcessedAt: time.Now(),
+	logging.FromContext(ctx, c.logger).WithField("model", model).Info("Model pulled")
+	return nil
+}
+
+// modelError turns an unexpected response to a request for model into an
+// error, wrapping ErrModelNotFound when the model is not pulled. Ollama
+// answers 404 both for a model it does not have and for an API path it
+// lacks, such as /api/embed on older servers; the latter's body is the
+// router's "404 page not found" and stays a plain APIError.
+func modelError(model string, apiErr *APIError) error {
+	if apiErr.StatusCode != http.StatusNotFound || strings.Contains(apiErr.Body, "page not found") {
+		return apiErr
 	}
-	
-	// Add metadata if present
-	if metadata, exists := result["metadata"]; exists {
-		if metadataMap, ok := metadata.(map[string]interface{}); ok {
-			classification.Metadata = metadataMap
-		}
+	return fmt.Errorf("%w: %s is not pulled, run `ollama pull %s`: %w", ErrModelNotFound, model, model, apiErr)
+}
+
+// generateForModel sends a classification prompt for a single model through
+// the model's circuit breaker
+func (c *Client) generateForModel(ctx context.Context, model, prompt string, params llm.Sampling, keepAlive string) (*GenerateResponse, error) {
+	request := GenerateRequest{
+		Model:  model,
+		Prompt: prompt,
+		Stream: false,
+		Options:   samplingOptions(params),
+		KeepAlive: keepAlive,
 	}
 	
-	// Add labels if present
-	if labels, exists := result["labels"]; exists {
-		if labelsList, ok := labels.([]interface{}); ok {
-			var stringLabels []string
-			for _, label := range labelsList {
-				if labelStr, ok := label.(string); ok {
-					stringLabels = append(stringLabels, labelStr)
-				}
-			}
-			classification.Labels = stringLabels
-		}
+	result, err := c.breakers.Execute(model, func() (interface{}, error) {
+		return c.generate(ctx, &request)
+	})
+	if err != nil {
+		return nil, llm.WrapBreakerError(err)
 	}
 	
-	return classification, nil
+	return result.(*GenerateResponse), nil
 }
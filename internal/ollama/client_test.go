@@ -0,0 +1,738 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/llm"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/testutil"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+const validClassification = `{"action": "archive", "confidence": 0.85, "reasoning": "Promotional content"}`
+
+func TestClassifyEmailFallsBackOnMissingModel(t *testing.T) {
+	server := newMockGenerateServer(t, map[string]string{
+		"backup:7b": validClassification,
+	})
+	defer server.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	profile := testProfile("missing:7b", "backup:7b")
+
+	result, err := client.ClassifyEmail(context.Background(), profile, testEmail())
+	require.NoError(t, err)
+
+	assert.Equal(t, "archive", result.Action)
+	assert.Equal(t, "backup:7b", result.Metadata["served_by_model"])
+	assert.Equal(t, "missing:7b", result.Metadata["fallback_from"])
+	assert.Equal(t, []string{"missing:7b", "backup:7b"}, server.requestedModels())
+}
+
+func TestClassifyEmailPrimaryModelRecorded(t *testing.T) {
+	server := newMockGenerateServer(t, map[string]string{
+		"primary:7b": validClassification,
+		"backup:7b":  validClassification,
+	})
+	defer server.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	profile := testProfile("primary:7b", "backup:7b")
+
+	result, err := client.ClassifyEmail(context.Background(), profile, testEmail())
+	require.NoError(t, err)
+
+	assert.Equal(t, "primary:7b", result.Metadata["served_by_model"])
+	assert.NotContains(t, result.Metadata, "fallback_from")
+	assert.Equal(t, []string{"primary:7b"}, server.requestedModels())
+}
+
+func TestClassifyEmailAllModelsMissing(t *testing.T) {
+	server := newMockGenerateServer(t, map[string]string{})
+	defer server.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	profile := testProfile("missing:7b", "also-missing:7b")
+
+	_, err := client.ClassifyEmail(context.Background(), profile, testEmail())
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrModelNotFound)
+	assert.Equal(t, []string{"missing:7b", "also-missing:7b"}, server.requestedModels())
+}
+
+func TestClassifyEmailParseErrorDoesNotFallBack(t *testing.T) {
+	server := newMockGenerateServer(t, map[string]string{
+		"primary:7b": "this is not json",
+		"backup:7b":  validClassification,
+	})
+	defer server.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	profile := testProfile("primary:7b", "backup:7b")
+
+	_, err := client.ClassifyEmail(context.Background(), profile, testEmail())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse classification response")
+	assert.Equal(t, []string{"primary:7b"}, server.requestedModels())
+}
+
+func TestClassifyEmailRawResponse(t *testing.T) {
+	server := newMockGenerateServer(t, map[string]string{
+		"primary:7b": validClassification,
+		"broken:7b":  "Sure! Here is the classification: action=archive",
+	})
+	defer server.Close()
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+
+	result, err := client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+	require.NoError(t, err)
+	assert.NotContains(t, result.Metadata, llm.MetadataRawResponse, "raw responses are off by default")
+
+	client.SetRawResponse(true)
+	result, err = client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+	require.NoError(t, err)
+	assert.Equal(t, validClassification, result.Metadata[llm.MetadataRawResponse])
+
+	// Parse failures carry the output whether or not raw responses are on
+	client.SetRawResponse(false)
+	_, err = client.ClassifyEmail(context.Background(), testProfile("broken:7b"), testEmail())
+	var responseErr *llm.ResponseError
+	require.ErrorAs(t, err, &responseErr)
+	assert.Equal(t, "Sure! Here is the classification: action=archive", responseErr.Response)
+	assert.Contains(t, err.Error(), `"Sure! Here is the classification: action=archive"`)
+}
+
+func TestClassifyEmailRetriesTruncatedOutput(t *testing.T) {
+	truncated := truncatedFixture(t)
+	var limits []float64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req GenerateRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		limit := req.Options["num_predict"].(float64)
+		limits = append(limits, limit)
+
+		if limit < 400 {
+			json.NewEncoder(w).Encode(truncated)
+			return
+		}
+		json.NewEncoder(w).Encode(GenerateResponse{Model: req.Model, Response: validClassification, Done: true})
+	}))
+	defer server.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	result, err := client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+	require.NoError(t, err)
+	assert.Equal(t, "archive", result.Action)
+	assert.Equal(t, []float64{200, 400}, limits, "retried once with twice the token limit")
+
+	profile := testProfile("primary:7b")
+	profile.ModelParams.MaxTokens = 50
+	limits = nil
+	_, err = client.ClassifyEmail(context.Background(), profile, testEmail())
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrTruncatedResponse)
+	assert.ErrorIs(t, err, ErrInvalidResponse)
+	assert.Contains(t, err.Error(), "model_params.max_tokens than 50")
+	assert.Equal(t, []float64{50, 100}, limits, "a second truncation is not retried")
+}
+
+func TestClassifyEmailRetriesEmptyOutput(t *testing.T) {
+	var temperatures []float64
+	responses := []GenerateResponse{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req GenerateRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		temperatures = append(temperatures, req.Options["temperature"].(float64))
+
+		response := responses[0]
+		responses = responses[1:]
+		response.Model = req.Model
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	client.SetEmptyResponse(config.EmptyResponseConfig{Retry: true, TemperatureStep: 0.2})
+
+	responses = []GenerateResponse{{Done: true}, {Response: validClassification, Done: true}}
+	result, err := client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+	require.NoError(t, err)
+	assert.Equal(t, "archive", result.Action)
+	require.Len(t, temperatures, 2)
+	assert.InDelta(t, 0.3, temperatures[1], 1e-9, "retried once with a higher temperature")
+	assert.Equal(t, true, result.Metadata[llm.MetadataEmptyRetry])
+	assert.InDelta(t, 0.3, result.Metadata[MetadataTemperature], 1e-9, "the retry's temperature is recorded")
+
+	temperatures = nil
+	responses = []GenerateResponse{{Done: true}, {Response: " ", Done: true}}
+	_, err = client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+	assert.ErrorIs(t, err, ErrEmptyResponse)
+	assert.Len(t, temperatures, 2, "a second empty output is not retried")
+
+	temperatures = nil
+	responses = []GenerateResponse{{Done: true, DoneReason: "length"}, {Response: validClassification, Done: true}}
+	_, err = client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0.1, 0.1}, temperatures, "no output at the token limit is retried as truncated")
+
+	client.SetEmptyResponse(config.EmptyResponseConfig{})
+	temperatures = nil
+	responses = []GenerateResponse{{Done: true}}
+	_, err = client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+	assert.ErrorIs(t, err, ErrEmptyResponse)
+	assert.NotErrorIs(t, err, ErrTruncatedResponse)
+	assert.Len(t, temperatures, 1, "not retried when retrying is off")
+}
+
+func TestClassifyEmailAudited(t *testing.T) {
+	server := newMockGenerateServer(t, map[string]string{
+		"primary:7b": validClassification,
+	})
+	defer server.Close()
+
+	dir := t.TempDir()
+	auditLogger, err := audit.NewLogger(&config.AuditConfig{Enabled: true, Directory: dir}, testLogger())
+	require.NoError(t, err)
+	defer auditLogger.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	client.SetAuditLogger(auditLogger)
+
+	_, err = client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+	require.NoError(t, err)
+
+	contents := readAuditFiles(t, dir)
+	assert.Contains(t, contents, `"event_type":"email_classified"`)
+	assert.Contains(t, contents, `"action":"archive"`)
+	assert.Contains(t, contents, `"fingerprint":{"id":`)
+}
+
+func TestClassifyEmailFingerprint(t *testing.T) {
+	server := newMockGenerateServer(t, map[string]string{
+		"primary:7b": validClassification,
+	})
+	defer server.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	profile := testProfile("primary:7b")
+
+	result, err := client.ClassifyEmail(context.Background(), profile, testEmail())
+	require.NoError(t, err)
+	require.NotNil(t, result.Fingerprint)
+	assert.Equal(t, "primary:7b", result.Fingerprint.Model)
+	assert.Empty(t, result.Fingerprint.ModelDigest, "the digest is unknown before the models are listed")
+	assert.Equal(t, profile.ID, result.Fingerprint.ProfileID)
+	assert.Equal(t, profile.Version, result.Fingerprint.ProfileVersion)
+	assert.Equal(t, llm.PromptHash(profile), result.Fingerprint.PromptHash)
+
+	_, err = client.ListModels(context.Background())
+	require.NoError(t, err)
+
+	result, err = client.ClassifyEmail(context.Background(), profile, testEmail())
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:primary:7b", result.Fingerprint.ModelDigest)
+	assert.Equal(t, llm.NewFingerprint(profile, "primary:7b", "sha256:primary:7b"), result.Fingerprint)
+}
+
+func TestClassifyEmailFailActionOnTotalFailure(t *testing.T) {
+	// No model is known, so the primary and the fallback both fail
+	server := newMockGenerateServer(t, map[string]string{})
+	defer server.Close()
+
+	dir := t.TempDir()
+	auditLogger, err := audit.NewLogger(&config.AuditConfig{Enabled: true, Directory: dir}, testLogger())
+	require.NoError(t, err)
+	defer auditLogger.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	client.SetAuditLogger(auditLogger)
+	classifier := llm.NewFailActionClassifier(client, config.FailActionConfig{Enabled: true, Action: "review"}, testLogger())
+	classifier.SetAuditLogger(auditLogger)
+
+	result, err := classifier.ClassifyEmail(context.Background(), testProfile("missing:7b", "gone:7b"), testEmail())
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"missing:7b", "gone:7b"}, server.requestedModels())
+	assert.Equal(t, "review", result.Action)
+	assert.Zero(t, result.Confidence)
+	assert.Equal(t, true, result.Metadata[types.MetadataClassificationFailed])
+	assert.Nil(t, result.Fingerprint)
+
+	contents := readAuditFiles(t, dir)
+	assert.Contains(t, contents, `"event_type":"email_classified"`)
+	assert.Contains(t, contents, `"action":"review"`)
+	assert.Contains(t, contents, `"classification_failed":true`)
+}
+
+func TestClassifyEmailWithoutAuditLogger(t *testing.T) {
+	server := newMockGenerateServer(t, map[string]string{
+		"primary:7b": validClassification,
+	})
+	defer server.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	client.SetAuditLogger(nil)
+
+	_, err := client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+	assert.NoError(t, err)
+}
+
+func TestClassifyEmailSampling(t *testing.T) {
+	seed := int64(7)
+	temperature := 0.9
+
+	tests := []struct {
+		name          string
+		deterministic bool
+		opts          ClassifyOptions
+		seed          int64
+		temperature   float64
+	}{
+		{"request overrides", false, ClassifyOptions{Seed: &seed, Temperature: &temperature}, 7, 0.9},
+		{"deterministic mode", true, ClassifyOptions{}, 42, 0},
+		{"deterministic mode keeps request seed", true, ClassifyOptions{Seed: &seed, Temperature: &temperature}, 7, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockGenerateServer(t, map[string]string{"primary:7b": validClassification})
+			defer server.Close()
+
+			cfg := testOllamaConfig(server.URL)
+			cfg.Deterministic = tt.deterministic
+			cfg.DeterministicSeed = 42
+			client := NewClient(cfg, testLogger())
+
+			result, err := client.ClassifyEmailWithOptions(context.Background(), testProfile("primary:7b"), testEmail(), tt.opts)
+			require.NoError(t, err)
+
+			options := server.requestedOptions()
+			require.Len(t, options, 1)
+			assert.Equal(t, float64(tt.seed), options[0]["seed"])
+			assert.Equal(t, tt.temperature, options[0]["temperature"])
+			assert.Equal(t, tt.seed, result.Metadata[MetadataSeed])
+			assert.Equal(t, tt.temperature, result.Metadata[MetadataTemperature])
+		})
+	}
+}
+
+func TestClassifyEmailDefaultModelParams(t *testing.T) {
+	seed := int64(11)
+	defaults := types.ModelParams{Temperature: float64Ptr(0.3), MaxTokens: 300, TopP: 0.9, TopK: 40, Seed: &seed}
+
+	tests := []struct {
+		name     string
+		params   types.ModelParams
+		expected map[string]interface{}
+	}{
+		{
+			name:     "unset params use the defaults",
+			params:   types.ModelParams{},
+			expected: map[string]interface{}{"temperature": 0.3, "num_predict": 300.0, "top_p": 0.9, "top_k": 40.0, "seed": 11.0},
+		},
+		{
+			name:     "explicit zero temperature is kept",
+			params:   types.ModelParams{Temperature: float64Ptr(0), MaxTokens: 120, TopK: 5},
+			expected: map[string]interface{}{"temperature": 0.0, "num_predict": 120.0, "top_p": 0.9, "top_k": 5.0, "seed": 11.0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockGenerateServer(t, map[string]string{"primary:7b": validClassification})
+			defer server.Close()
+
+			cfg := testOllamaConfig(server.URL)
+			cfg.DefaultModelParams = defaults
+			client := NewClient(cfg, testLogger())
+
+			profile := testProfile("primary:7b")
+			profile.ModelParams = tt.params
+			_, err := client.ClassifyEmail(context.Background(), profile, testEmail())
+			require.NoError(t, err)
+
+			options := server.requestedOptions()
+			require.Len(t, options, 1)
+			assert.Equal(t, tt.expected, options[0])
+			assert.Nil(t, profile.ModelParams.Seed, "the caller's profile is not modified")
+		})
+	}
+}
+
+func TestClassifyEmailOmitsUnsetOptions(t *testing.T) {
+	server := newMockGenerateServer(t, map[string]string{"primary:7b": validClassification})
+	defer server.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	profile := testProfile("primary:7b")
+	profile.ModelParams = types.ModelParams{}
+	_, err := client.ClassifyEmail(context.Background(), profile, testEmail())
+	require.NoError(t, err)
+
+	options := server.requestedOptions()
+	require.Len(t, options, 1)
+	assert.Equal(t, 0.0, options[0]["temperature"], "an unset temperature is zero")
+	assert.Contains(t, options[0], "seed")
+	assert.NotContains(t, options[0], "num_predict")
+	assert.NotContains(t, options[0], "top_p")
+	assert.NotContains(t, options[0], "top_k")
+}
+
+func TestClassifyEmailRecordsSeedForReplay(t *testing.T) {
+	server := newMockGenerateServer(t, map[string]string{"primary:7b": validClassification})
+	defer server.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	profile := testProfile("primary:7b")
+
+	result, err := client.ClassifyEmail(context.Background(), profile, testEmail())
+	require.NoError(t, err)
+	seed, ok := result.Metadata[MetadataSeed].(int64)
+	require.True(t, ok, "a seed is chosen even when none is requested")
+	assert.Equal(t, *profile.ModelParams.Temperature, result.Metadata[MetadataTemperature])
+
+	_, err = client.ClassifyEmailWithOptions(context.Background(), profile, testEmail(), ClassifyOptions{Seed: &seed})
+	require.NoError(t, err)
+
+	options := server.requestedOptions()
+	require.Len(t, options, 2)
+	assert.Equal(t, options[0], options[1], "replaying with the recorded seed sends identical options")
+}
+
+func TestClassifyEmailKeepAlive(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured time.Duration
+		profile    string
+		expected   string
+	}{
+		{"ollama default", 0, "", ""},
+		{"configured", 30 * time.Minute, "", "30m0s"},
+		{"profile overrides", 30 * time.Minute, "-1s", "-1s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockGenerateServer(t, map[string]string{"primary:7b": validClassification})
+			defer server.Close()
+
+			cfg := testOllamaConfig(server.URL)
+			cfg.KeepAlive = tt.configured
+			client := NewClient(cfg, testLogger())
+			profile := testProfile("primary:7b")
+			profile.ModelParams.KeepAlive = tt.profile
+
+			result, err := client.ClassifyEmail(context.Background(), profile, testEmail())
+			require.NoError(t, err)
+			assert.Equal(t, []string{tt.expected}, server.requestedKeepAlives())
+			assert.Equal(t, int64(1500), result.Metadata[MetadataLoadDuration])
+		})
+	}
+}
+
+func TestClassifyEmailPromptSizeLimit(t *testing.T) {
+	server := newMockGenerateServer(t, map[string]string{"primary:7b": validClassification})
+	defer server.Close()
+
+	cfg := testOllamaConfig(server.URL)
+	cfg.MaxPromptBytes = 4096
+	client := NewClient(cfg, testLogger())
+
+	t.Run("within limit", func(t *testing.T) {
+		result, err := client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+		require.NoError(t, err)
+		assert.Greater(t, result.Metadata[llm.MetadataPromptBytes], 0)
+		assert.Greater(t, result.Metadata[llm.MetadataPromptTokens], 0)
+	})
+
+	t.Run("assembled prompt over limit", func(t *testing.T) {
+		// Neither the body nor the system prompt reaches the limit, and the
+		// body is far under the maximum email size, but together they do
+		email := testEmail()
+		email.Body = strings.Repeat("Save big this week. ", 150)
+		profile := testProfile("primary:7b")
+		profile.System = strings.Repeat("Classify promotional email carefully. ", 80)
+		require.Less(t, len(email.Body), cfg.MaxPromptBytes)
+		require.Less(t, len(profile.System), cfg.MaxPromptBytes)
+		require.Less(t, int64(len(email.Body)), config.DefaultConfig().Security.MaxEmailSize)
+
+		_, err := client.ClassifyEmail(context.Background(), profile, email)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, llm.ErrPromptTooLarge)
+		assert.False(t, llm.IsRetryable(err))
+		assert.Len(t, server.requestedModels(), 1, "the oversized prompt is never sent")
+	})
+
+	sizes := client.PromptSizes()
+	assert.Equal(t, 2, sizes.Count)
+	assert.Equal(t, 1, sizes.Rejected)
+	assert.Greater(t, sizes.MaxBytes, cfg.MaxPromptBytes)
+}
+
+func TestPreload(t *testing.T) {
+	var requests []GenerateRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req GenerateRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests = append(requests, req)
+		if req.Model != "primary:7b" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(GenerateResponse{Model: req.Model, Done: true, DoneReason: "load"})
+	}))
+	defer server.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	require.NoError(t, client.Preload(context.Background(), "primary:7b"))
+	require.Len(t, requests, 1)
+	assert.Empty(t, requests[0].Prompt, "an empty prompt only loads the model")
+	assert.Equal(t, DefaultPreloadKeepAlive.String(), requests[0].KeepAlive)
+
+	err := client.Preload(context.Background(), "missing:7b")
+	assert.ErrorIs(t, err, ErrModelNotFound)
+	assert.Equal(t, gobreaker.StateClosed, client.GetCircuitBreakerState("missing:7b"))
+}
+
+func TestEnsureModel(t *testing.T) {
+	var pulls []PullRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			json.NewEncoder(w).Encode(ListModelsResponse{Models: []ModelInfo{{Name: "primary:7b"}, {Name: "nomic-embed-text:latest"}}})
+		case "/api/pull":
+			var req PullRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			pulls = append(pulls, req)
+			if req.Model == "unknown:1b" {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "pull model manifest: file does not exist"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+		default:
+			t.Fatalf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	ctx := context.Background()
+
+	require.NoError(t, client.EnsureModel(ctx, "primary:7b"))
+	require.NoError(t, client.EnsureModel(ctx, "nomic-embed-text"))
+	assert.Empty(t, pulls, "listed models are not pulled")
+
+	require.NoError(t, client.EnsureModel(ctx, "missing:7b"))
+	require.Len(t, pulls, 1)
+	assert.Equal(t, PullRequest{Model: "missing:7b"}, pulls[0], "pulls wait for completion")
+
+	err := client.EnsureModel(ctx, "unknown:1b")
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusInternalServerError, apiErr.StatusCode)
+}
+
+func TestEmbed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/embed", r.URL.Path)
+		var req EmbedRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req.Model != "nomic-embed-text" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		response := EmbedResponse{Model: req.Model}
+		for i := range req.Input {
+			response.Embeddings = append(response.Embeddings, []float64{float64(i), 1})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	embeddings, err := client.Embed(context.Background(), "nomic-embed-text", []string{"first", "second"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{0, 1}, {1, 1}}, embeddings)
+
+	_, err = client.Embed(context.Background(), "missing", []string{"first"})
+	assert.ErrorIs(t, err, llm.ErrModelNotFound)
+}
+
+// Helper functions
+
+func readAuditFiles(t *testing.T, dir string) string {
+	paths, err := filepath.Glob(filepath.Join(dir, "audit_*.log"))
+	require.NoError(t, err)
+
+	var contents strings.Builder
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		contents.Write(data)
+	}
+	return contents.String()
+}
+
+// mockGenerateServer serves /api/generate, answering with the configured
+// response for known models and a 404 for everything else, and /api/show,
+// reporting the models' contextLengths, if any
+type mockGenerateServer struct {
+	*httptest.Server
+	mutex          sync.Mutex
+	models         []string
+	options        []map[string]interface{}
+	keepAlives     []string
+	contextLengths map[string]int
+	shown          []string
+}
+
+// truncatedFixture returns the generation cut off at the token limit
+func truncatedFixture(t *testing.T) GenerateResponse {
+	td := testutil.LoadTestData(t)
+	data, err := json.Marshal(td.OllamaResponses["classification_responses"].(map[string]interface{})["truncated_response"])
+	require.NoError(t, err)
+
+	var response GenerateResponse
+	require.NoError(t, json.Unmarshal(data, &response))
+	require.Equal(t, "length", response.DoneReason)
+	return response
+}
+
+func newMockGenerateServer(t *testing.T, responses map[string]string) *mockGenerateServer {
+	mock := &mockGenerateServer{}
+	mock.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			var models []ModelInfo
+			for model := range responses {
+				models = append(models, ModelInfo{Name: model, Digest: "sha256:" + model})
+			}
+			json.NewEncoder(w).Encode(ListModelsResponse{Models: models})
+			return
+		}
+		if r.URL.Path == "/api/show" {
+			var req ShowRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			mock.mutex.Lock()
+			defer mock.mutex.Unlock()
+			mock.shown = append(mock.shown, req.Model)
+			details := ModelDetails{}
+			if length, ok := mock.contextLengths[req.Model]; ok {
+				details.Parameters = fmt.Sprintf("num_ctx %d", length)
+			}
+			json.NewEncoder(w).Encode(details)
+			return
+		}
+
+		var req GenerateRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		mock.mutex.Lock()
+		mock.models = append(mock.models, req.Model)
+		mock.options = append(mock.options, req.Options)
+		mock.keepAlives = append(mock.keepAlives, req.KeepAlive)
+		mock.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		response, exists := responses[req.Model]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": fmt.Sprintf("model %q not found, try pulling it first", req.Model),
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(GenerateResponse{
+			Model:        req.Model,
+			Response:     response,
+			Done:         true,
+			LoadDuration: int64(1500 * time.Millisecond),
+		})
+	}))
+	return mock
+}
+
+func (m *mockGenerateServer) requestedModels() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]string(nil), m.models...)
+}
+
+func (m *mockGenerateServer) requestedOptions() []map[string]interface{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]map[string]interface{}(nil), m.options...)
+}
+
+func (m *mockGenerateServer) requestedKeepAlives() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]string(nil), m.keepAlives...)
+}
+
+func testOllamaConfig(baseURL string) *config.OllamaConfig {
+	return &config.OllamaConfig{
+		BaseURL:        baseURL,
+		DefaultModel:   "qwen2.5:7b",
+		RequestTimeout: 5 * time.Second,
+		CircuitBreaker: config.CircuitBreakerConfig{
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     60 * time.Second,
+			ReadyToTrip: 5,
+		},
+	}
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
+
+func testProfile(model string, fallbacks ...string) *types.Profile {
+	return &types.Profile{
+		ID:             "test",
+		Version:        "1.0.0",
+		Model:          model,
+		FallbackModels: fallbacks,
+		System:         "Test system prompt",
+		ModelParams: types.ModelParams{
+			Temperature:    float64Ptr(0.1),
+			MaxTokens:      200,
+			TimeoutSeconds: 30,
+		},
+	}
+}
+
+func testEmail() *types.Email {
+	return &types.Email{
+		ID:      "test-email",
+		Subject: "Weekly deals",
+		From:    "deals@shop.example.com",
+		To:      []string{"user@example.com"},
+		Body:    "Save 20% on everything this week.",
+	}
+}
+
+func float64Ptr(value float64) *float64 {
+	return &value
+}
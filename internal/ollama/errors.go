@@ -0,0 +1,24 @@
+package ollama
+
+import (
+	"github.com/mailsentinel/core/internal/llm"
+)
+
+// Sentinel errors returned (wrapped) by the client. They are the shared llm
+// errors, so errors.Is works the same against either package.
+var (
+	ErrCircuitOpen       = llm.ErrCircuitOpen
+	ErrModelNotFound     = llm.ErrModelNotFound
+	ErrInvalidResponse   = llm.ErrInvalidResponse
+	ErrTruncatedResponse = llm.ErrTruncatedResponse
+	ErrEmptyResponse     = llm.ErrEmptyResponse
+	ErrTimeout           = llm.ErrTimeout
+)
+
+// APIError is returned when Ollama responds with an unexpected HTTP status
+type APIError = llm.APIError
+
+// IsRetryable reports whether a failed request may succeed if retried later
+func IsRetryable(err error) bool {
+	return llm.IsRetryable(err)
+}
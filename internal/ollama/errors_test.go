@@ -0,0 +1,198 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/llm"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestClassifyEmailErrorTypes(t *testing.T) {
+	t.Run("model_not_found", func(t *testing.T) {
+		server := newMockGenerateServer(t, map[string]string{})
+		defer server.Close()
+
+		client := NewClient(testOllamaConfig(server.URL), testLogger())
+		_, err := client.ClassifyEmail(context.Background(), testProfile("missing:7b"), testEmail())
+
+		assert.ErrorIs(t, err, ErrModelNotFound)
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+		assert.False(t, IsRetryable(err))
+		assert.Contains(t, err.Error(), "run `ollama pull missing:7b`")
+	})
+
+	t.Run("missing_endpoint", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		defer server.Close()
+
+		client := NewClient(testOllamaConfig(server.URL), testLogger())
+		_, err := client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+
+		assert.NotErrorIs(t, err, ErrModelNotFound)
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	})
+
+	t.Run("invalid_response", func(t *testing.T) {
+		server := newMockGenerateServer(t, map[string]string{"primary:7b": `{"action": "archive"}`})
+		defer server.Close()
+
+		client := NewClient(testOllamaConfig(server.URL), testLogger())
+		_, err := client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+
+		assert.ErrorIs(t, err, ErrInvalidResponse)
+		assert.Contains(t, err.Error(), "missing or invalid 'confidence' field")
+		assert.False(t, IsRetryable(err))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}))
+		defer server.Close()
+
+		cfg := testOllamaConfig(server.URL)
+		cfg.RequestTimeout = 20 * time.Millisecond
+		client := NewClient(cfg, testLogger())
+		_, err := client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+
+		assert.ErrorIs(t, err, ErrTimeout)
+		assert.True(t, IsRetryable(err))
+	})
+
+	t.Run("circuit_open", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "model runner crashed", http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		cfg := testOllamaConfig(server.URL)
+		cfg.CircuitBreaker.ReadyToTrip = 1
+		client := NewClient(cfg, testLogger())
+
+		_, err := client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, http.StatusInternalServerError, apiErr.StatusCode)
+		assert.True(t, IsRetryable(err))
+
+		_, err = client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+		assert.ErrorIs(t, err, ErrCircuitOpen)
+		assert.True(t, IsRetryable(err))
+	})
+}
+
+func TestModelNotFoundDoesNotTripBreaker(t *testing.T) {
+	server := newMockGenerateServer(t, map[string]string{"primary:7b": validClassification})
+	defer server.Close()
+
+	cfg := testOllamaConfig(server.URL)
+	cfg.CircuitBreaker.ReadyToTrip = 1
+	client := NewClient(cfg, testLogger())
+
+	for i := 0; i < 3; i++ {
+		_, err := client.ClassifyEmail(context.Background(), testProfile("missing:7b"), testEmail())
+		require.ErrorIs(t, err, ErrModelNotFound)
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+	assert.Equal(t, gobreaker.StateClosed, client.GetCircuitBreakerState("missing:7b"))
+	assert.Zero(t, client.GetCircuitBreakerCounts("missing:7b").TotalFailures)
+
+	result, err := client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+	require.NoError(t, err, "a missing model leaves healthy models available")
+	assert.Equal(t, "archive", result.Action)
+}
+
+func TestBreakerIsPerModel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			json.NewEncoder(w).Encode(ListModelsResponse{Models: []ModelInfo{{Name: "qwen2.5:7b"}}})
+			return
+		}
+		var req GenerateRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req.Model == "experimental:7b" {
+			http.Error(w, "model crashed", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(GenerateResponse{Model: req.Model, Response: validClassification, Done: true})
+	}))
+	defer server.Close()
+
+	cfg := testOllamaConfig(server.URL)
+	cfg.CircuitBreaker.ReadyToTrip = 1
+	client := NewClient(cfg, testLogger())
+
+	_, err := client.ClassifyEmail(context.Background(), testProfile("experimental:7b"), testEmail())
+	require.Error(t, err)
+	require.Equal(t, gobreaker.StateOpen, client.GetCircuitBreakerState("experimental:7b"))
+	_, err = client.ClassifyEmail(context.Background(), testProfile("experimental:7b"), testEmail())
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	result, err := client.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+	require.NoError(t, err, "a tripped model leaves the others available")
+	assert.Equal(t, "archive", result.Action)
+	assert.Equal(t, gobreaker.StateClosed, client.GetCircuitBreakerState("primary:7b"))
+
+	result, err = client.ClassifyEmail(context.Background(), testProfile("experimental:7b", "primary:7b"), testEmail())
+	require.NoError(t, err, "a tripped model falls back to the next")
+	assert.Equal(t, "primary:7b", result.Metadata[llm.MetadataServedByModel])
+
+	status := client.Status(context.Background())
+	assert.Equal(t, types.HealthDegraded, status.State)
+	assert.Equal(t, "closed", status.CircuitBreaker.State, "the default model was never requested")
+	assert.Equal(t, "open", status.CircuitBreakers["experimental:7b"].State)
+	assert.Equal(t, "closed", status.CircuitBreakers["primary:7b"].State)
+}
+
+func TestRetryQueueRecoversFromOutage(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "ollama restarting", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(GenerateResponse{Model: "primary:7b", Response: validClassification, Done: true})
+	}))
+	defer server.Close()
+
+	cfg := testOllamaConfig(server.URL)
+	cfg.CircuitBreaker.ReadyToTrip = 1
+	cfg.CircuitBreaker.Timeout = 50 * time.Millisecond
+	cfg.CircuitBreaker.MaxRequests = 1
+	client := NewClient(cfg, testLogger())
+	queue := llm.NewRetryQueue(client, client, config.RetryQueueConfig{Enabled: true, MaxSize: 10, MaxWait: 10 * time.Second}, testLogger())
+
+	// The first failure trips the breaker
+	_, err := queue.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+	require.Error(t, err)
+	require.Equal(t, gobreaker.StateOpen, client.GetCircuitBreakerState("primary:7b"))
+
+	down.Store(false)
+	result, err := queue.ClassifyEmail(context.Background(), testProfile("primary:7b"), testEmail())
+	require.NoError(t, err, "the rejected email waits for the breaker instead of failing")
+	assert.Equal(t, "archive", result.Action)
+	assert.Equal(t, gobreaker.StateClosed, client.GetCircuitBreakerState("primary:7b"))
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.False(t, IsRetryable(errors.New("something else")))
+	assert.False(t, IsRetryable(&APIError{StatusCode: http.StatusBadRequest}))
+	assert.True(t, IsRetryable(&APIError{StatusCode: http.StatusServiceUnavailable}))
+}
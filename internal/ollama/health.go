@@ -0,0 +1,228 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sony/gobreaker"
+
+	"github.com/mailsentinel/core/internal/llm"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// HealthStatus describes the client's ability to classify emails.
+// CircuitBreaker is the breaker of the default model, and CircuitBreakers
+// that of every model requested so far.
+type HealthStatus struct {
+	State           types.HealthState        `json:"status"`
+	CircuitBreaker  BreakerStatus            `json:"circuit_breaker"`
+	CircuitBreakers map[string]BreakerStatus `json:"circuit_breakers,omitempty"`
+	DefaultModel    string                   `json:"default_model"`
+	ModelAvailable  bool                     `json:"model_available"`
+	ModelCheckedAt  time.Time                `json:"model_checked_at,omitempty"`
+	PromptSizes     llm.PromptSizeSnapshot   `json:"prompt_sizes"`
+	Error           string                   `json:"error,omitempty"`
+}
+
+// BreakerStatus is a snapshot of a circuit breaker
+type BreakerStatus struct {
+	State                string `json:"state"`
+	Requests             uint32 `json:"requests"`
+	TotalSuccesses       uint32 `json:"total_successes"`
+	TotalFailures        uint32 `json:"total_failures"`
+	ConsecutiveSuccesses uint32 `json:"consecutive_successes"`
+	ConsecutiveFailures  uint32 `json:"consecutive_failures"`
+}
+
+// HealthState returns the overall health state
+func (s HealthStatus) HealthState() types.HealthState {
+	return s.State
+}
+
+// modelCheck is the outcome of the last successful model listing
+type modelCheck struct {
+	available bool
+	checkedAt time.Time
+}
+
+// healthProbe is the outcome of the last model listing, successful or not
+type healthProbe struct {
+	err       error
+	available bool
+	checkedAt time.Time
+}
+
+// Status probes Ollama and reports the client's health. Ollama being
+// unreachable or missing the default model is unhealthy; a reachable Ollama
+// with the circuit breaker of any model open or half-open is degraded, since
+// the breaker will recover on its own. When the probe fails, the last known
+// model availability is reported. Within HealthCheckPeriod of the last probe
+// its result is reused, while the breakers are always reported as they are
+// now.
+func (c *Client) Status(ctx context.Context) HealthStatus {
+	status := HealthStatus{
+		State:          types.HealthHealthy,
+		DefaultModel:   c.config.DefaultModel,
+		PromptSizes:    c.promptSizes.Snapshot(),
+		CircuitBreaker: c.breakerStatus(c.config.DefaultModel),
+	}
+	tripped := status.CircuitBreaker.State != gobreaker.StateClosed.String()
+	models := c.breakers.Models()
+	if len(models) > 0 {
+		status.CircuitBreakers = make(map[string]BreakerStatus, len(models))
+	}
+	for _, model := range models {
+		breaker := c.breakerStatus(model)
+		status.CircuitBreakers[model] = breaker
+		tripped = tripped || breaker.State != gobreaker.StateClosed.String()
+	}
+
+	err := c.probe(ctx).err
+
+	c.healthMutex.Lock()
+	status.ModelAvailable = c.lastModelCheck.available
+	status.ModelCheckedAt = c.lastModelCheck.checkedAt
+	c.healthMutex.Unlock()
+
+	switch {
+	case err != nil:
+		status.State = types.HealthUnhealthy
+		status.Error = err.Error()
+	case !status.ModelAvailable:
+		status.State = types.HealthUnhealthy
+		status.Error = ErrModelNotFound.Error()
+	case tripped:
+		status.State = types.HealthDegraded
+	}
+	return status
+}
+
+// breakerStatus returns a snapshot of the circuit breaker of model
+func (c *Client) breakerStatus(model string) BreakerStatus {
+	counts := c.breakers.Counts(model)
+	return BreakerStatus{
+		State:                c.breakers.State(model).String(),
+		Requests:             counts.Requests,
+		TotalSuccesses:       counts.TotalSuccesses,
+		TotalFailures:        counts.TotalFailures,
+		ConsecutiveSuccesses: counts.ConsecutiveSuccesses,
+		ConsecutiveFailures:  counts.ConsecutiveFailures,
+	}
+}
+
+// Start checks Ollama's health, failing when it is unhealthy so that a dead
+// Ollama is noticed at startup, then refreshes it in the background every
+// HealthCheckPeriod until Stop. Health checks are then served from the last
+// refresh instead of each reaching Ollama. A zero period runs no loop.
+func (c *Client) Start() error {
+	if err := c.probeError(c.refreshHealth(context.Background())); err != nil {
+		return err
+	}
+
+	period := c.config.HealthCheckPeriod
+	c.loopMutex.Lock()
+	defer c.loopMutex.Unlock()
+	if period <= 0 || c.stopHealth != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopHealth = cancel
+	c.healthDone = make(chan struct{})
+	go c.healthLoop(ctx, period, c.healthDone)
+	return nil
+}
+
+// Stop ends the health loop started by Start, waiting for a refresh in
+// progress to be cancelled
+func (c *Client) Stop() {
+	c.loopMutex.Lock()
+	defer c.loopMutex.Unlock()
+	if c.stopHealth == nil {
+		return
+	}
+	c.stopHealth()
+	<-c.healthDone
+	c.stopHealth, c.healthDone = nil, nil
+}
+
+// healthLoop refreshes the health every period, logging when Ollama becomes
+// unhealthy or recovers
+func (c *Client) healthLoop(ctx context.Context, period time.Duration, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	healthy := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := c.probeError(c.refreshHealth(ctx))
+		if ctx.Err() != nil {
+			return
+		}
+		switch {
+		case err != nil && healthy:
+			c.logger.WithError(err).Warn("Ollama became unhealthy")
+		case err == nil && !healthy:
+			c.logger.WithField("model", c.config.DefaultModel).Info("Ollama recovered")
+		}
+		healthy = err == nil
+	}
+}
+
+// probe returns the last model listing while it is younger than
+// HealthCheckPeriod, and lists the models again otherwise
+func (c *Client) probe(ctx context.Context) healthProbe {
+	c.healthMutex.Lock()
+	last := c.lastProbe
+	c.healthMutex.Unlock()
+
+	if period := c.config.HealthCheckPeriod; period > 0 && !last.checkedAt.IsZero() && c.clock.Now().Sub(last.checkedAt) < period {
+		return last
+	}
+	return c.refreshHealth(ctx)
+}
+
+// refreshHealth lists Ollama's models, recording whether the default model
+// is available
+func (c *Client) refreshHealth(ctx context.Context) healthProbe {
+	models, err := c.ListModels(ctx)
+	result := healthProbe{err: err, checkedAt: c.clock.Now()}
+	for _, model := range models {
+		if model.Name == c.config.DefaultModel {
+			result.available = true
+			break
+		}
+	}
+
+	c.healthMutex.Lock()
+	c.lastProbe = result
+	if err == nil {
+		c.lastModelCheck = modelCheck{available: result.available, checkedAt: result.checkedAt}
+	}
+	c.healthMutex.Unlock()
+	return result
+}
+
+// probeError is the HealthCheck error for a probe, or nil when Ollama is
+// reachable and has the default model
+func (c *Client) probeError(result healthProbe) error {
+	if result.err != nil {
+		return fmt.Errorf("Ollama health check failed: %w", result.err)
+	}
+	if !result.available {
+		return fmt.Errorf("%w: default model %s not found in available models", ErrModelNotFound, c.config.DefaultModel)
+	}
+	c.logger.WithFields(logrus.Fields{
+		"model":      c.config.DefaultModel,
+		"checked_at": result.checkedAt,
+	}).Debug("Default model is available")
+	return nil
+}
@@ -0,0 +1,174 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		models      []ModelInfo
+		tagStatus   int
+		failures    int
+		expected    types.HealthState
+		available   bool
+		breaker     string
+		consecutive uint32
+	}{
+		{"healthy", []ModelInfo{{Name: "qwen2.5:7b"}}, http.StatusOK, 0, types.HealthHealthy, true, "closed", 0},
+		{"failures below threshold", []ModelInfo{{Name: "qwen2.5:7b"}}, http.StatusOK, 1, types.HealthHealthy, true, "closed", 1},
+		{"breaker open", []ModelInfo{{Name: "qwen2.5:7b"}}, http.StatusOK, 2, types.HealthDegraded, true, "open", 0},
+		{"default model missing", []ModelInfo{{Name: "llama3:8b"}}, http.StatusOK, 0, types.HealthUnhealthy, false, "closed", 0},
+		{"ollama failing", nil, http.StatusInternalServerError, 0, types.HealthUnhealthy, false, "closed", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockTagsServer(tt.models, tt.tagStatus)
+			defer server.Close()
+
+			cfg := testOllamaConfig(server.URL)
+			cfg.CircuitBreaker.ReadyToTrip = 2
+			client := NewClient(cfg, testLogger())
+			for i := 0; i < tt.failures; i++ {
+				// Generate requests fail against the tags-only server
+				_, err := client.ClassifyEmail(context.Background(), testProfile("qwen2.5:7b"), testEmail())
+				require.Error(t, err)
+			}
+
+			status := client.Status(context.Background())
+			assert.Equal(t, tt.expected, status.State)
+			assert.Equal(t, tt.expected, status.HealthState())
+			assert.Equal(t, tt.available, status.ModelAvailable)
+			assert.Equal(t, "qwen2.5:7b", status.DefaultModel)
+			assert.Equal(t, tt.breaker, status.CircuitBreaker.State)
+			assert.Equal(t, tt.consecutive, status.CircuitBreaker.ConsecutiveFailures)
+		})
+	}
+}
+
+func TestStatusKeepsLastKnownModelAvailability(t *testing.T) {
+	server := newMockTagsServer([]ModelInfo{{Name: "qwen2.5:7b"}}, http.StatusOK)
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+
+	status := client.Status(context.Background())
+	require.Equal(t, types.HealthHealthy, status.State)
+	checkedAt := status.ModelCheckedAt
+
+	server.Close()
+	status = client.Status(context.Background())
+	assert.Equal(t, types.HealthUnhealthy, status.State)
+	assert.NotEmpty(t, status.Error)
+	assert.True(t, status.ModelAvailable, "the last successful check is reported")
+	assert.Equal(t, checkedAt, status.ModelCheckedAt)
+}
+
+func TestHealthCachedWithinPeriod(t *testing.T) {
+	server, requests := newCountingTagsServer([]ModelInfo{{Name: "qwen2.5:7b"}})
+	defer server.Close()
+
+	cfg := testOllamaConfig(server.URL)
+	cfg.HealthCheckPeriod = time.Minute
+	client := NewClient(cfg, testLogger())
+	clk := clock.NewFake(time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC))
+	client.clock = clk
+
+	require.Equal(t, types.HealthHealthy, client.Status(context.Background()).State)
+	require.NoError(t, client.HealthCheck(context.Background()))
+	clk.Advance(59 * time.Second)
+	status := client.Status(context.Background())
+	assert.Equal(t, types.HealthHealthy, status.State)
+	assert.Equal(t, int32(1), requests.Load(), "checks within the period are served from the cache")
+
+	clk.Advance(time.Second)
+	status = client.Status(context.Background())
+	assert.Equal(t, int32(2), requests.Load(), "an expired result is checked again")
+	assert.Equal(t, clk.Now(), status.ModelCheckedAt)
+}
+
+func TestStartFailsOnUnhealthyOllama(t *testing.T) {
+	tests := []struct {
+		name   string
+		models []ModelInfo
+		status int
+	}{
+		{"ollama failing", nil, http.StatusInternalServerError},
+		{"default model missing", []ModelInfo{{Name: "llama3:8b"}}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMockTagsServer(tt.models, tt.status)
+			defer server.Close()
+
+			cfg := testOllamaConfig(server.URL)
+			cfg.HealthCheckPeriod = time.Minute
+			client := NewClient(cfg, testLogger())
+			assert.Error(t, client.Start())
+			assert.Nil(t, client.stopHealth, "no health loop runs after a failed start")
+		})
+	}
+}
+
+func TestHealthLoopRefreshesUntilStopped(t *testing.T) {
+	server, requests := newCountingTagsServer([]ModelInfo{{Name: "qwen2.5:7b"}})
+	defer server.Close()
+
+	cfg := testOllamaConfig(server.URL)
+	cfg.HealthCheckPeriod = 10 * time.Millisecond
+	client := NewClient(cfg, testLogger())
+	clk := clock.NewFake(time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC))
+	client.clock = clk
+
+	require.NoError(t, client.Start())
+	assert.Equal(t, int32(1), requests.Load(), "the first check is synchronous")
+	require.Eventually(t, func() bool { return requests.Load() >= 3 }, 5*time.Second, time.Millisecond)
+
+	// The clock never advances, so health checks are served by the loop
+	before := requests.Load()
+	require.NoError(t, client.HealthCheck(context.Background()))
+	assert.LessOrEqual(t, requests.Load()-before, int32(1))
+
+	require.NoError(t, client.Close())
+	stopped := requests.Load()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, stopped, requests.Load(), "no checks run after Stop")
+}
+
+// Helper functions
+
+// newCountingTagsServer serves /api/tags with the given models, counting the
+// requests
+func newCountingTagsServer(models []ModelInfo) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		json.NewEncoder(w).Encode(ListModelsResponse{Models: models})
+	}))
+	return server, &requests
+}
+
+// newMockTagsServer serves /api/tags with the given models and status, and
+// fails every other request
+func newMockTagsServer(models []ModelInfo, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ListModelsResponse{Models: models})
+	}))
+}
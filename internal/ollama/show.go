@@ -0,0 +1,166 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mailsentinel/core/internal/llm"
+	"github.com/mailsentinel/core/internal/logging"
+)
+
+// ShowRequest represents a request to Ollama's show API
+type ShowRequest struct {
+	Model string `json:"model"`
+}
+
+// ModelDetails is what Ollama's show API reports about a model: its prompt
+// template, the parameters of its Modelfile, one per line as in
+// "num_ctx 8192", its family and size, the metadata of its weights and,
+// on recent servers, its capabilities such as "completion" and "tools"
+type ModelDetails struct {
+	Template     string                 `json:"template"`
+	Parameters   string                 `json:"parameters"`
+	Details      ModelFamily            `json:"details"`
+	ModelInfo    map[string]interface{} `json:"model_info"`
+	Capabilities []string               `json:"capabilities,omitempty"`
+}
+
+// ModelFamily is the family and size of a model
+type ModelFamily struct {
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// Parameter returns the values the Modelfile sets for a parameter, such as
+// the stop sequences of "stop", in order
+func (d *ModelDetails) Parameter(name string) []string {
+	var values []string
+	for _, line := range strings.Split(d.Parameters, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), " ")
+		if found && key == name {
+			values = append(values, strings.Trim(strings.TrimSpace(value), `"`))
+		}
+	}
+	return values
+}
+
+// ContextLength returns the context window of the model in tokens: the
+// num_ctx its Modelfile sets, or else the context length it was trained
+// with, from the "<architecture>.context_length" model info. It is zero
+// when neither is reported.
+func (d *ModelDetails) ContextLength() int {
+	if values := d.Parameter("num_ctx"); len(values) > 0 {
+		if n, err := strconv.Atoi(values[len(values)-1]); err == nil && n > 0 {
+			return n
+		}
+	}
+	if architecture, ok := d.ModelInfo["general.architecture"].(string); ok {
+		if n, ok := d.ModelInfo[architecture+".context_length"].(float64); ok {
+			return int(n)
+		}
+	}
+	return 0
+}
+
+// SupportsChat reports whether the model's template renders a list of chat
+// messages
+func (d *ModelDetails) SupportsChat() bool {
+	return strings.Contains(d.Template, ".Messages")
+}
+
+// SupportsTools reports whether the model can call tools, by its reported
+// capabilities or, on servers reporting none, by its template
+func (d *ModelDetails) SupportsTools() bool {
+	if len(d.Capabilities) > 0 {
+		return slices.Contains(d.Capabilities, "tools")
+	}
+	return strings.Contains(d.Template, ".Tools")
+}
+
+// ShowModel retrieves the details of a model with Ollama's show API and
+// records its context length, which then bounds the prompts sent to it.
+// Classification shows each model it sends prompts to the first time, so
+// calling it up front is only needed to fail early. Showing bypasses the
+// circuit breaker.
+func (c *Client) ShowModel(ctx context.Context, name string) (*ModelDetails, error) {
+	jsonData, err := json.Marshal(&ShowRequest{Model: name})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/api/show", c.baseURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to show model %s: %w", name, llm.WrapTransportError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to show model %s: %w", name, modelError(name, &APIError{StatusCode: resp.StatusCode, Body: string(body)}))
+	}
+
+	var details ModelDetails
+	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w: %w", ErrInvalidResponse, err)
+	}
+	c.contexts.record(name, details.ContextLength())
+	return &details, nil
+}
+
+// contextLength returns the context length of model, showing the model
+// the first time it is asked for. It is zero when the model reports none or
+// cannot be shown, which is retried the next time.
+func (c *Client) contextLength(ctx context.Context, model string) int {
+	if length, shown := c.contexts.lookup(model); shown {
+		return length
+	}
+	if _, err := c.ShowModel(ctx, model); err != nil {
+		logging.FromContext(ctx, c.logger).WithError(err).WithField("model", model).Warn("Failed to read the model's context length")
+		return 0
+	}
+	length, _ := c.contexts.lookup(model)
+	return length
+}
+
+// modelContexts remembers the context lengths of the models shown so far,
+// zero for a model reporting none
+type modelContexts struct {
+	mutex   sync.RWMutex
+	lengths map[string]int
+}
+
+// record remembers the context length of model
+func (m *modelContexts) record(model string, length int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.lengths == nil {
+		m.lengths = make(map[string]int)
+	}
+	m.lengths[model] = max(length, 0)
+}
+
+// lookup returns the context length of model and whether it was shown
+func (m *modelContexts) lookup(model string) (int, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	length, shown := m.lengths[model]
+	return length, shown
+}
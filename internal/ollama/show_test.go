@@ -0,0 +1,132 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/llm"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// showResponse is an /api/show response of Ollama 0.5 for qwen2.5:7b,
+// shortened
+const showResponse = `{
+	"modelfile": "FROM qwen2.5:7b",
+	"parameters": "stop                           \"<|im_start|>\"\nstop                           \"<|im_end|>\"\nnum_ctx                        8192",
+	"template": "{{- if .Messages }}{{- if or .System .Tools }}<|im_start|>system{{ end }}{{ range .Messages }}{{ .Content }}{{ end }}{{ end }}",
+	"details": {
+		"parent_model": "",
+		"format": "gguf",
+		"family": "qwen2",
+		"families": ["qwen2"],
+		"parameter_size": "7.6B",
+		"quantization_level": "Q4_K_M"
+	},
+	"model_info": {
+		"general.architecture": "qwen2",
+		"general.parameter_count": 7615616512,
+		"qwen2.context_length": 32768,
+		"qwen2.embedding_length": 3584
+	},
+	"capabilities": ["completion", "tools"]
+}`
+
+func TestShowModel(t *testing.T) {
+	var requests []ShowRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/show", r.URL.Path)
+		var req ShowRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests = append(requests, req)
+		if req.Model != "qwen2.5:7b" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"model 'missing:7b' not found"}`))
+			return
+		}
+		w.Write([]byte(showResponse))
+	}))
+	defer server.Close()
+
+	client := NewClient(testOllamaConfig(server.URL), testLogger())
+	details, err := client.ShowModel(context.Background(), "qwen2.5:7b")
+	require.NoError(t, err)
+	assert.Equal(t, []ShowRequest{{Model: "qwen2.5:7b"}}, requests)
+
+	assert.Equal(t, "7.6B", details.Details.ParameterSize)
+	assert.Equal(t, "Q4_K_M", details.Details.QuantizationLevel)
+	assert.Equal(t, []string{"<|im_start|>", "<|im_end|>"}, details.Parameter("stop"))
+	assert.Equal(t, 8192, details.ContextLength(), "num_ctx takes precedence over the trained context length")
+	assert.True(t, details.SupportsChat())
+	assert.True(t, details.SupportsTools())
+	length, shown := client.contexts.lookup("qwen2.5:7b")
+	assert.True(t, shown)
+	assert.Equal(t, 8192, length)
+
+	_, err = client.ShowModel(context.Background(), "missing:7b")
+	assert.ErrorIs(t, err, ErrModelNotFound)
+}
+
+func TestModelDetailsFallbacks(t *testing.T) {
+	details := &ModelDetails{
+		Template:  "{{ .System }} {{ .Prompt }}",
+		ModelInfo: map[string]interface{}{"general.architecture": "llama", "llama.context_length": float64(4096)},
+	}
+	assert.Equal(t, 4096, details.ContextLength(), "the trained context length without num_ctx")
+	assert.False(t, details.SupportsChat())
+	assert.False(t, details.SupportsTools())
+
+	assert.Zero(t, (&ModelDetails{}).ContextLength())
+	assert.True(t, (&ModelDetails{Template: "{{ if .Tools }}{{ end }}"}).SupportsTools(), "the template tells when no capabilities are reported")
+}
+
+func TestClassifyEmailRespectsContextLength(t *testing.T) {
+	mock := newMockGenerateServer(t, map[string]string{"small:1b": validClassification, "large:7b": validClassification})
+	defer mock.Close()
+
+	mock.contextLengths = map[string]int{"small:1b": 64}
+
+	client := NewClient(testOllamaConfig(mock.URL), testLogger())
+	email := testEmail()
+	email.Body = strings.Repeat("Please review the attached statement. ", 20)
+
+	_, err := client.ClassifyEmail(context.Background(), testProfile("small:1b"), email)
+	require.ErrorIs(t, err, llm.ErrPromptTooLarge)
+	assert.Contains(t, err.Error(), "64 token context of small:1b")
+	assert.Empty(t, mock.models, "the prompt is not sent to a model it does not fit even without the body")
+
+	result, err := client.ClassifyEmail(context.Background(), testProfile("small:1b", "large:7b"), email)
+	require.NoError(t, err, "a fallback model with a larger context serves the prompt")
+	assert.Equal(t, "large:7b", result.Metadata[llm.MetadataServedByModel])
+	assert.Nil(t, result.Metadata[llm.MetadataPromptTruncated])
+	assert.Equal(t, []string{"small:1b", "large:7b"}, mock.shown, "each model is shown once, when first used")
+}
+
+func TestClassifyEmailCutsEmailToFitContext(t *testing.T) {
+	mock := newMockGenerateServer(t, map[string]string{"small:1b": validClassification})
+	defer mock.Close()
+
+	profile := testProfile("small:1b")
+	email := testEmail()
+	email.Thread = []types.ThreadMessage{{ID: "earlier", From: "billing@example.com", Body: strings.Repeat("An earlier message. ", 100)}}
+	bare := *email
+	bare.Thread = nil
+	prompt, err := llm.RenderPrompt(profile, &bare)
+	require.NoError(t, err)
+	// The context fits the prompt without the thread and a long body
+	mock.contextLengths = map[string]int{"small:1b": llm.EstimateTokens(prompt) + profile.ModelParams.MaxTokens + 50}
+	email.Body = strings.Repeat("Please review the attached statement. ", 100)
+
+	client := NewClient(testOllamaConfig(mock.URL), testLogger())
+	result, err := client.ClassifyEmail(context.Background(), profile, email)
+	require.NoError(t, err)
+	assert.Equal(t, true, result.Metadata[llm.MetadataPromptTruncated])
+	assert.LessOrEqual(t, result.Metadata[llm.MetadataPromptTokens], mock.contextLengths["small:1b"]-profile.ModelParams.MaxTokens)
+	assert.Equal(t, []string{"small:1b"}, mock.models)
+}
@@ -0,0 +1,463 @@
+// Package openai classifies emails with any server implementing the OpenAI
+// chat completions API, such as vLLM or the llama.cpp server.
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sony/gobreaker"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/llm"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Client is an OpenAI-compatible API client with circuit breaker
+type Client struct {
+	baseURL       string
+	httpClient    *http.Client
+	breakers      *llm.Breakers
+	logger        *logrus.Logger
+	config        *config.OpenAIConfig
+	audit         *audit.Logger
+	rawResponse   bool
+	emptyResponse config.EmptyResponseConfig
+}
+
+// Client implements llm.Classifier, llm.Embedder and llm.BreakerObservable
+var (
+	_ llm.Classifier        = (*Client)(nil)
+	_ llm.Embedder          = (*Client)(nil)
+	_ llm.BreakerObservable = (*Client)(nil)
+)
+
+// ChatCompletionRequest is the body of POST /v1/chat/completions
+type ChatCompletionRequest struct {
+	Model       string        `json:"model"`
+	Messages    []ChatMessage `json:"messages"`
+	Temperature float64       `json:"temperature"`
+	MaxTokens   int           `json:"max_tokens,omitempty"`
+	TopP        float64       `json:"top_p,omitempty"`
+	Seed        int64         `json:"seed"`
+	Stream      bool          `json:"stream"`
+}
+
+// ChatMessage is one message of a chat completion
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatCompletionResponse is the response to a chat completion
+type ChatCompletionResponse struct {
+	ID      string       `json:"id"`
+	Model   string       `json:"model"`
+	Choices []ChatChoice `json:"choices"`
+	Usage   Usage        `json:"usage"`
+}
+
+// ChatChoice is one completion returned by the server
+type ChatChoice struct {
+	Index        int         `json:"index"`
+	Message      ChatMessage `json:"message"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+// Usage reports the tokens consumed by a completion
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Model is one model listed by /v1/models
+type Model struct {
+	ID      string `json:"id"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// ListModelsResponse is the response from /v1/models
+type ListModelsResponse struct {
+	Data []Model `json:"data"`
+}
+
+// EmbeddingRequest is the body of POST /v1/embeddings
+type EmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// EmbeddingResponse is the response to an embedding request
+type EmbeddingResponse struct {
+	Data []Embedding `json:"data"`
+}
+
+// Embedding is the embedding of one input
+type Embedding struct {
+	Index     int       `json:"index"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// NewClient creates a new OpenAI-compatible client with circuit breaker
+func NewClient(cfg *config.OpenAIConfig, logger *logrus.Logger) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+		httpClient: &http.Client{
+			Timeout: cfg.RequestTimeout,
+		},
+		breakers: llm.NewBreakers("openai-client", cfg.CircuitBreaker, logger),
+		logger:   logger,
+		config:   cfg,
+	}
+}
+
+// SetAuditLogger makes the client record every successful classification in
+// the audit log. A nil logger disables auditing.
+func (c *Client) SetAuditLogger(auditLogger *audit.Logger) {
+	c.audit = auditLogger
+}
+
+// SetRawResponse makes the client include the exact text the model returned
+// in the metadata of every result, under llm.MetadataRawResponse
+func (c *Client) SetRawResponse(enabled bool) {
+	c.rawResponse = enabled
+}
+
+// SetEmptyResponse sets how the client retries a classification whose
+// model produced no output; without it such classifications fail at once
+func (c *Client) SetEmptyResponse(cfg config.EmptyResponseConfig) {
+	c.emptyResponse = cfg
+}
+
+// Close releases the idle connections to the server. Requests still in
+// flight are not interrupted.
+func (c *Client) Close() error {
+	c.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// ClassifyEmail classifies an email using the specified profile. If the
+// profile's primary model is unavailable, each of its fallback models is
+// tried in order; the model that served the request is recorded in the
+// response metadata.
+func (c *Client) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	return c.ClassifyEmailWithOptions(ctx, profile, email, llm.ClassifyOptions{})
+}
+
+// ClassifyEmailWithOptions is ClassifyEmail with per-request sampling
+// overrides. The seed and temperature used are recorded in the response
+// metadata; whether the seed makes the completion reproducible depends on
+// the server.
+func (c *Client) ClassifyEmailWithOptions(ctx context.Context, profile *types.Profile, email *types.Email, opts llm.ClassifyOptions) (*types.ClassificationResponse, error) {
+	prompt, err := llm.RenderPrompt(profile, email)
+	if err != nil {
+		return nil, err
+	}
+	sampling := llm.ResolveSampling(profile, opts, c.config.Deterministic, c.config.DeterministicSeed)
+
+	models := append([]string{profile.Model}, profile.FallbackModels...)
+
+	var lastErr error
+	for i, model := range models {
+		response, stoppedAtLimit, err := c.completeForModel(ctx, model, prompt, sampling)
+		if err != nil {
+			lastErr = err
+			if llm.IsModelUnavailable(err) && i < len(models)-1 {
+				logging.FromContext(ctx, c.logger).WithError(err).WithFields(logrus.Fields{
+					"profile_id":     profile.ID,
+					"model":          model,
+					"fallback_model": models[i+1],
+				}).Warn("Model unavailable, trying fallback model")
+				continue
+			}
+			return nil, fmt.Errorf("classification request failed: %w", err)
+		}
+
+		// Parse failures are genuine classification errors and never
+		// trigger a fallback; no output at all is retried once with a higher
+		// temperature, and output cut off at the token limit once with a
+		// higher limit
+		classification, err := llm.ParseResponse(response, profile)
+		err = llm.CheckTruncation(err, stoppedAtLimit, profile)
+		retriedEmpty := false
+		if retry, ok := llm.EmptyRetrySampling(sampling, c.emptyResponse); ok && llm.IsEmptyResponse(err) {
+			logging.FromContext(ctx, c.logger).WithFields(logrus.Fields{
+				"profile_id":  profile.ID,
+				"model":       model,
+				"temperature": retry.Temperature,
+			}).Warn("Model produced no output, retrying with a higher temperature")
+
+			sampling, retriedEmpty = retry, true
+			response, stoppedAtLimit, err = c.completeForModel(ctx, model, prompt, sampling)
+			if err != nil {
+				return nil, fmt.Errorf("classification request failed: %w", err)
+			}
+			classification, err = llm.ParseResponse(response, profile)
+			err = llm.CheckTruncation(err, stoppedAtLimit, profile)
+		}
+		if retry, ok := llm.RetrySampling(sampling); ok && errors.Is(err, llm.ErrTruncatedResponse) {
+			logging.FromContext(ctx, c.logger).WithFields(logrus.Fields{
+				"profile_id": profile.ID,
+				"model":      model,
+				"max_tokens": retry.MaxTokens,
+			}).Warn("Model output truncated, retrying with a higher token limit")
+
+			response, stoppedAtLimit, err = c.completeForModel(ctx, model, prompt, retry)
+			if err != nil {
+				return nil, fmt.Errorf("classification request failed: %w", err)
+			}
+			classification, err = llm.ParseResponse(response, profile)
+			err = llm.CheckTruncation(err, stoppedAtLimit, profile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse classification response: %w", err)
+		}
+		llm.MarkHomoglyphs(email, classification)
+		llm.MarkSpoofedReply(email, classification)
+		llm.MarkLanguage(email, classification)
+		llm.MarkEmptyBody(profile, email, classification)
+		if err := llm.PostProcess(profile, email, classification); err != nil {
+			logging.FromContext(ctx, c.logger).WithError(err).WithFields(logrus.Fields{
+				"email_id":   email.ID,
+				"profile_id": profile.ID,
+			}).Warn("Failed to evaluate post-processing rule")
+		}
+
+		if classification.Metadata == nil {
+			classification.Metadata = make(map[string]interface{})
+		}
+		classification.EmailID = email.ID
+		classification.Metadata[llm.MetadataServedByModel] = model
+		classification.Metadata[llm.MetadataSeed] = sampling.Seed
+		classification.Metadata[llm.MetadataTemperature] = sampling.Temperature
+		if c.rawResponse {
+			classification.Metadata[llm.MetadataRawResponse] = response
+		}
+		if retriedEmpty {
+			classification.Metadata[llm.MetadataEmptyRetry] = true
+		}
+		if i > 0 {
+			classification.Metadata[llm.MetadataFallbackFrom] = profile.Model
+		}
+		// OpenAI-compatible model listings carry no digest
+		classification.Fingerprint = llm.NewFingerprint(profile, model, "")
+
+		c.auditClassification(ctx, email, classification)
+		return classification, nil
+	}
+
+	return nil, fmt.Errorf("classification request failed: %w", lastErr)
+}
+
+// auditClassification records a classification in the audit log, if one is
+// configured. Audit failures are logged but do not fail the classification.
+func (c *Client) auditClassification(ctx context.Context, email *types.Email, classification *types.ClassificationResponse) {
+	if c.audit == nil {
+		return
+	}
+
+	if err := c.audit.LogEmailClassification(ctx, email, classification); err != nil {
+		logging.FromContext(ctx, c.logger).WithError(err).WithField("email_id", email.ID).Error("Failed to audit classification")
+	}
+}
+
+// completeForModel sends a classification prompt for a single model through
+// the model's circuit breaker and returns the completion text, reporting whether the
+// server stopped it at the token limit
+func (c *Client) completeForModel(ctx context.Context, model, prompt string, sampling llm.Sampling) (string, bool, error) {
+	request := ChatCompletionRequest{
+		Model:       model,
+		Messages:    []ChatMessage{{Role: "user", Content: prompt}},
+		Temperature: sampling.Temperature,
+		MaxTokens:   sampling.MaxTokens,
+		TopP:        sampling.TopP,
+		Seed:        sampling.Seed,
+		Stream:      false,
+	}
+
+	result, err := c.breakers.Execute(model, func() (interface{}, error) {
+		return c.complete(ctx, &request)
+	})
+	if err != nil {
+		return "", false, llm.WrapBreakerError(err)
+	}
+
+	response := result.(*ChatCompletionResponse)
+	if len(response.Choices) == 0 {
+		return "", false, fmt.Errorf("%w: completion has no choices", llm.ErrInvalidResponse)
+	}
+	choice := response.Choices[0]
+	stoppedAtLimit := choice.FinishReason == "length" || (sampling.MaxTokens > 0 && response.Usage.CompletionTokens >= sampling.MaxTokens)
+	return choice.Message.Content, stoppedAtLimit, nil
+}
+
+// complete sends a request to the chat completions API
+func (c *Client) complete(ctx context.Context, request *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/v1/chat/completions", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", llm.WrapTransportError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		apiErr := &llm.APIError{StatusCode: resp.StatusCode, Body: string(body)}
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s: %w", llm.ErrModelNotFound, request.Model, apiErr)
+		}
+		return nil, apiErr
+	}
+
+	var response ChatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w: %w", llm.ErrInvalidResponse, llm.WrapTransportError(err))
+	}
+
+	return &response, nil
+}
+
+// Embed embeds texts with an embedding model using the embeddings API.
+// Requests bypass the circuit breaker, so that embedding failures never
+// stop classification.
+func (c *Client) Embed(ctx context.Context, model string, texts []string) ([][]float64, error) {
+	jsonData, err := json.Marshal(&EmbeddingRequest{Model: model, Input: texts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/v1/embeddings", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", llm.WrapTransportError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		apiErr := &llm.APIError{StatusCode: resp.StatusCode, Body: string(body)}
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s: %w", llm.ErrModelNotFound, model, apiErr)
+		}
+		return nil, apiErr
+	}
+
+	var response EmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w: %w", llm.ErrInvalidResponse, llm.WrapTransportError(err))
+	}
+
+	// The data is not guaranteed to be in input order
+	embeddings := make([][]float64, len(texts))
+	for _, embedding := range response.Data {
+		if embedding.Index < 0 || embedding.Index >= len(texts) {
+			return nil, fmt.Errorf("%w: embedding index %d out of range", llm.ErrInvalidResponse, embedding.Index)
+		}
+		embeddings[embedding.Index] = embedding.Embedding
+	}
+	return embeddings, nil
+}
+
+// ListModels retrieves the models served by the server
+func (c *Client) ListModels(ctx context.Context) ([]llm.ModelInfo, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/v1/models", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", llm.WrapTransportError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &llm.APIError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var response ListModelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	models := make([]llm.ModelInfo, 0, len(response.Data))
+	for _, model := range response.Data {
+		models = append(models, llm.ModelInfo{
+			Name:       model.ID,
+			ModifiedAt: time.Unix(model.Created, 0),
+		})
+	}
+	return models, nil
+}
+
+// HealthCheck verifies server connectivity and model availability
+func (c *Client) HealthCheck(ctx context.Context) error {
+	models, err := c.ListModels(ctx)
+	if err != nil {
+		return fmt.Errorf("OpenAI-compatible health check failed: %w", err)
+	}
+
+	defaultModel := c.config.DefaultModel
+	for _, model := range models {
+		if model.Name == defaultModel {
+			c.logger.WithField("model", defaultModel).Info("Default model is available")
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: default model %s not found in available models", llm.ErrModelNotFound, defaultModel)
+}
+
+// GetCircuitBreakerState returns the current state of the circuit breaker
+// of model. Each model has its own breaker, so a failing model does not
+// reject requests to the others.
+func (c *Client) GetCircuitBreakerState(model string) gobreaker.State {
+	return c.breakers.State(model)
+}
+
+// OnBreakerStateChange calls observer on every state change of the circuit
+// breaker of any model
+func (c *Client) OnBreakerStateChange(observer func(model string, from, to gobreaker.State)) {
+	c.breakers.OnStateChange(observer)
+}
+
+// newRequest builds a request to path, authenticated with the API key when
+// one is configured
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.APIKey)
+	}
+	return req, nil
+}
@@ -0,0 +1,319 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/llm"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+const validClassification = `{"action": "archive", "confidence": 0.85, "reasoning": "Promotional content"}`
+
+func TestClassifyEmail(t *testing.T) {
+	server := newMockCompletionServer(t, map[string]string{"qwen2.5-7b": validClassification})
+	defer server.Close()
+
+	cfg := testOpenAIConfig(server.URL)
+	cfg.APIKey = "test-key"
+	client := NewClient(cfg, testLogger())
+
+	result, err := client.ClassifyEmail(context.Background(), testProfile("qwen2.5-7b"), testEmail())
+	require.NoError(t, err)
+	assert.Equal(t, "archive", result.Action)
+	assert.Equal(t, 0.85, result.Confidence)
+	assert.Equal(t, "test-email", result.EmailID)
+	assert.Equal(t, "qwen2.5-7b", result.Metadata[llm.MetadataServedByModel])
+	assert.NotContains(t, result.Metadata, llm.MetadataRawResponse, "raw responses are off by default")
+
+	requests := server.requests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, "Bearer test-key", requests[0].authorization)
+	assert.Equal(t, 0.1, requests[0].body.Temperature)
+	assert.Equal(t, 200, requests[0].body.MaxTokens)
+	require.Len(t, requests[0].body.Messages, 1)
+	assert.Equal(t, llm.BuildPrompt(testProfile("qwen2.5-7b"), testEmail()), requests[0].body.Messages[0].Content)
+
+	client.SetRawResponse(true)
+	result, err = client.ClassifyEmail(context.Background(), testProfile("qwen2.5-7b"), testEmail())
+	require.NoError(t, err)
+	assert.Equal(t, validClassification, result.Metadata[llm.MetadataRawResponse])
+}
+
+func TestClassifyEmailFallsBackOnMissingModel(t *testing.T) {
+	server := newMockCompletionServer(t, map[string]string{"backup-7b": validClassification})
+	defer server.Close()
+
+	client := NewClient(testOpenAIConfig(server.URL), testLogger())
+
+	result, err := client.ClassifyEmail(context.Background(), testProfile("primary-7b", "backup-7b"), testEmail())
+	require.NoError(t, err)
+	assert.Equal(t, "backup-7b", result.Metadata[llm.MetadataServedByModel])
+	assert.Equal(t, "primary-7b", result.Metadata[llm.MetadataFallbackFrom])
+}
+
+func TestClassifyEmailErrors(t *testing.T) {
+	t.Run("model not found", func(t *testing.T) {
+		server := newMockCompletionServer(t, nil)
+		defer server.Close()
+
+		_, err := NewClient(testOpenAIConfig(server.URL), testLogger()).ClassifyEmail(context.Background(), testProfile("missing"), testEmail())
+		require.Error(t, err)
+		assert.ErrorIs(t, err, llm.ErrModelNotFound)
+		assert.False(t, llm.IsRetryable(err))
+	})
+
+	t.Run("invalid response", func(t *testing.T) {
+		server := newMockCompletionServer(t, map[string]string{"qwen2.5-7b": "I cannot classify this"})
+		defer server.Close()
+
+		_, err := NewClient(testOpenAIConfig(server.URL), testLogger()).ClassifyEmail(context.Background(), testProfile("qwen2.5-7b"), testEmail())
+		require.Error(t, err)
+		assert.ErrorIs(t, err, llm.ErrInvalidResponse)
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		_, err := NewClient(testOpenAIConfig(server.URL), testLogger()).ClassifyEmail(context.Background(), testProfile("qwen2.5-7b"), testEmail())
+		require.Error(t, err)
+		assert.True(t, llm.IsRetryable(err))
+	})
+}
+
+func TestClassifyEmailRetriesTruncatedOutput(t *testing.T) {
+	truncated := `{"action": "archive", "confidence": 0.8, "reasoning": "Promotional newsletter from`
+	tests := []struct {
+		name      string
+		limit     int // completions below this limit are cut off
+		wantLimit []int
+		wantErr   bool
+	}{
+		{"retry succeeds", 400, []int{200, 400}, false},
+		{"retry truncated again", 1000, []int{200, 400}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var limits []int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req ChatCompletionRequest
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+				limits = append(limits, req.MaxTokens)
+
+				choice := ChatChoice{Message: ChatMessage{Role: "assistant", Content: validClassification}, FinishReason: "stop"}
+				if req.MaxTokens < tt.limit {
+					choice = ChatChoice{Message: ChatMessage{Role: "assistant", Content: truncated}, FinishReason: "length"}
+				}
+				json.NewEncoder(w).Encode(ChatCompletionResponse{Model: req.Model, Choices: []ChatChoice{choice}})
+			}))
+			defer server.Close()
+
+			result, err := NewClient(testOpenAIConfig(server.URL), testLogger()).ClassifyEmail(context.Background(), testProfile("qwen2.5-7b"), testEmail())
+			assert.Equal(t, tt.wantLimit, limits)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, llm.ErrTruncatedResponse)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "archive", result.Action)
+		})
+	}
+}
+
+func TestClassifyEmailDeterministic(t *testing.T) {
+	server := newMockCompletionServer(t, map[string]string{"qwen2.5-7b": validClassification})
+	defer server.Close()
+
+	cfg := testOpenAIConfig(server.URL)
+	cfg.Deterministic = true
+	cfg.DeterministicSeed = 42
+	client := NewClient(cfg, testLogger())
+
+	result, err := client.ClassifyEmail(context.Background(), testProfile("qwen2.5-7b"), testEmail())
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), result.Metadata[llm.MetadataSeed])
+
+	requests := server.requests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, int64(42), requests[0].body.Seed)
+	assert.Equal(t, 0.0, requests[0].body.Temperature)
+}
+
+func TestHealthCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		models  []string
+		wantErr error
+	}{
+		{"default model served", []string{"other", "qwen2.5-7b"}, nil},
+		{"default model missing", []string{"other"}, llm.ErrModelNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/v1/models", r.URL.Path)
+				var response ListModelsResponse
+				for _, model := range tt.models {
+					response.Data = append(response.Data, Model{ID: model, Created: 1700000000})
+				}
+				json.NewEncoder(w).Encode(response)
+			}))
+			defer server.Close()
+
+			client := NewClient(testOpenAIConfig(server.URL), testLogger())
+			models, err := client.ListModels(context.Background())
+			require.NoError(t, err)
+			assert.Len(t, models, len(tt.models))
+
+			err = client.HealthCheck(context.Background())
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				assert.Equal(t, types.HealthHealthy, llm.CheckHealth(context.Background(), client).State)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, types.HealthUnhealthy, llm.CheckHealth(context.Background(), client).State)
+		})
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/embeddings", r.URL.Path)
+		var req EmbeddingRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		// Answered in reverse, as the order of the data is not guaranteed
+		var response EmbeddingResponse
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			response.Data = append(response.Data, Embedding{Index: i, Embedding: []float64{float64(i), 1}})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	client := NewClient(testOpenAIConfig(server.URL), testLogger())
+	embeddings, err := client.Embed(context.Background(), "text-embedding-3-small", []string{"first", "second"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{0, 1}, {1, 1}}, embeddings)
+}
+
+// Helper functions
+
+// recordedRequest is a chat completion request received by the mock server
+type recordedRequest struct {
+	authorization string
+	body          ChatCompletionRequest
+}
+
+// mockCompletionServer serves /v1/chat/completions, answering with the
+// configured content for known models and a 404 for everything else
+type mockCompletionServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	received []recordedRequest
+}
+
+func newMockCompletionServer(t *testing.T, responses map[string]string) *mockCompletionServer {
+	mock := &mockCompletionServer{}
+	mock.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/chat/completions", r.URL.Path)
+
+		var req ChatCompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		mock.mutex.Lock()
+		mock.received = append(mock.received, recordedRequest{authorization: r.Header.Get("Authorization"), body: req})
+		mock.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		content, exists := responses[req.Model]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]string{"message": fmt.Sprintf("The model `%s` does not exist.", req.Model)},
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(ChatCompletionResponse{
+			Model: req.Model,
+			Choices: []ChatChoice{{
+				Message:      ChatMessage{Role: "assistant", Content: content},
+				FinishReason: "stop",
+			}},
+		})
+	}))
+	return mock
+}
+
+func (m *mockCompletionServer) requests() []recordedRequest {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]recordedRequest(nil), m.received...)
+}
+
+func testOpenAIConfig(baseURL string) *config.OpenAIConfig {
+	return &config.OpenAIConfig{
+		BaseURL:        baseURL,
+		DefaultModel:   "qwen2.5-7b",
+		RequestTimeout: 5 * time.Second,
+		CircuitBreaker: config.CircuitBreakerConfig{
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     60 * time.Second,
+			ReadyToTrip: 5,
+		},
+	}
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
+
+func testProfile(model string, fallbacks ...string) *types.Profile {
+	return &types.Profile{
+		ID:             "test",
+		Version:        "1.0.0",
+		Model:          model,
+		FallbackModels: fallbacks,
+		System:         "Test system prompt",
+		ModelParams: types.ModelParams{
+			Temperature:    float64Ptr(0.1),
+			MaxTokens:      200,
+			TimeoutSeconds: 30,
+		},
+	}
+}
+
+func testEmail() *types.Email {
+	return &types.Email{
+		ID:      "test-email",
+		Subject: "Weekly deals",
+		From:    "deals@shop.example.com",
+		To:      []string{"user@example.com"},
+		Body:    "Save 20% on everything this week.",
+	}
+}
+
+func float64Ptr(value float64) *float64 {
+	return &value
+}
@@ -0,0 +1,407 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// ErrUnknownAction is returned when a classification action has no label mapping
+var ErrUnknownAction = errors.New("unknown action")
+
+// ErrSafeMode is returned for a message trashed or deleted in safe mode
+var ErrSafeMode = errors.New("messages cannot be trashed or deleted in safe mode")
+
+// ActionExecutor translates classification actions into Gmail label changes
+// and message operations
+type ActionExecutor struct {
+	config *config.ActionsConfig
+	gmail  MailClient
+	owned  map[string]bool
+	audit  *audit.Logger
+	logger *logrus.Logger
+
+	// applied indexes the user labels applied to each email by email ID, as
+	// AppliedLabels reads them from the audit log; nil until first used by
+	// reconciling, then kept up to date with the label changes applied
+	applied      map[string]map[string]bool
+	appliedMutex sync.Mutex
+}
+
+// NewActionExecutor creates a new action executor
+func NewActionExecutor(cfg *config.ActionsConfig, gmail MailClient, auditLogger *audit.Logger, logger *logrus.Logger) *ActionExecutor {
+	return &ActionExecutor{
+		config: cfg,
+		gmail:  &safeModeClient{MailClient: gmail, config: cfg},
+		owned:  OwnedLabels(cfg),
+		audit:  auditLogger,
+		logger: logger,
+	}
+}
+
+// Plan returns the label change configured for a classification action,
+// with the labels of its system action, if any, ahead of its own. In safe
+// mode a change with a message operation archives the email and adds the
+// safe-mode label instead.
+func (e *ActionExecutor) Plan(result *types.ClassificationResponse) (*config.LabelChange, error) {
+	change, _, err := e.plan(result)
+	return change, err
+}
+
+// plan returns the label change for a classification action and the
+// message operation safe mode replaced, if any
+func (e *ActionExecutor) plan(result *types.ClassificationResponse) (*config.LabelChange, string, error) {
+	change, exists := e.config.LabelMapping[result.Action]
+	if !exists {
+		return nil, "", fmt.Errorf("%w: no label mapping for action %q", ErrUnknownAction, result.Action)
+	}
+
+	if change.System != "" {
+		add, remove, err := SystemLabelChange(SystemAction(change.System))
+		if err != nil {
+			return nil, "", fmt.Errorf("label mapping for action %q: %w", result.Action, err)
+		}
+		change.Add = append(add, change.Add...)
+		change.Remove = append(remove, change.Remove...)
+	}
+
+	var intercepted string
+	if e.config.SafeMode && change.Operation != "" {
+		intercepted = change.Operation
+		change = safeModeChange(change, e.config.InterceptLabel())
+	}
+	if err := ValidateLabelChange(change.Add, change.Remove); err != nil {
+		return nil, "", fmt.Errorf("label mapping for action %q: %w", result.Action, err)
+	}
+	return &change, intercepted, nil
+}
+
+// safeModeChange replaces a change's message operation with archiving the
+// email and adding label
+func safeModeChange(change config.LabelChange, label string) config.LabelChange {
+	change.Operation = ""
+	change.Add = append(append([]string{}, change.Add...), label)
+	for _, name := range change.Remove {
+		if labelKey(name) == string(LabelInbox) {
+			return change
+		}
+	}
+	change.Remove = append(append([]string{}, change.Remove...), string(LabelInbox))
+	return change
+}
+
+// planAction plans the label change for a classification result,
+// reconciled with the email's labels when reconciling, logging and
+// auditing the message operation safe mode intercepted, if any
+func (e *ActionExecutor) planAction(ctx context.Context, email *types.Email, result *types.ClassificationResponse, dryRun bool) (*config.LabelChange, string, error) {
+	change, intercepted, err := e.plan(result)
+	if err == nil && e.config.Reconcile {
+		change, err = e.reconcile(ctx, email, change)
+	}
+	if err != nil || intercepted == "" {
+		return change, intercepted, err
+	}
+
+	label := e.config.InterceptLabel()
+	logging.FromContext(ctx, e.logger).WithFields(logrus.Fields{
+		"email_id":   email.ID,
+		"action":     result.Action,
+		"operation":  intercepted,
+		"label":      label,
+		"confidence": result.Confidence,
+		"dry_run":    dryRun,
+	}).Warn("Safe mode: archiving and labelling instead of removing the email")
+
+	if err := e.audit.LogActionIntercept(ctx, email, result.Action, intercepted, label, result.Confidence, dryRun); err != nil {
+		return nil, "", fmt.Errorf("failed to audit safe mode interception of action %s: %w", result.Action, err)
+	}
+	return change, intercepted, nil
+}
+
+// reconcile returns the label change with the additions the email already
+// carries dropped and the stale owned labels it carries removed: the
+// configured owned labels and the user labels the audit log shows were
+// applied to the email
+func (e *ActionExecutor) reconcile(ctx context.Context, email *types.Email, change *config.LabelChange) (*config.LabelChange, error) {
+	current, err := e.labelNames(ctx, email.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list labels to reconcile: %w", err)
+	}
+	applied, err := e.appliedLabels(email.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the labels applied to reconcile: %w", err)
+	}
+	names, err := e.labelNames(ctx, applied)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list labels to reconcile: %w", err)
+	}
+	owned := make(map[string]bool, len(names)+len(e.owned))
+	for _, name := range names {
+		owned[name] = true
+	}
+	for name := range e.owned {
+		owned[name] = true
+	}
+	delta := Reconcile(current, *change, owned)
+
+	reconciled := *change
+	reconciled.Add = delta.Add
+	reconciled.Remove = delta.Remove
+	if stale := len(delta.Remove) - len(change.Remove); stale > 0 || len(delta.Keep) > 0 {
+		logging.FromContext(ctx, e.logger).WithFields(logrus.Fields{
+			"email_id":     email.ID,
+			"stale_labels": delta.Remove[len(change.Remove):],
+			"kept_labels":  delta.Keep,
+		}).Debug("Reconciled labels with the email's labels")
+	}
+	return &reconciled, nil
+}
+
+// labelNames maps label IDs to label names through the mail client's label
+// cache. IDs of labels that do not exist are kept as they are.
+func (e *ActionExecutor) labelNames(ctx context.Context, ids []string) ([]string, error) {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if label, system := systemLabel(id); system {
+			names = append(names, string(label))
+			continue
+		}
+		name, err := e.gmail.LabelNameForID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// appliedLabels returns the user labels applied to an email, reading the
+// applied-label index from the audit log with a single query on first use
+func (e *ActionExecutor) appliedLabels(emailID string) ([]string, error) {
+	e.appliedMutex.Lock()
+	defer e.appliedMutex.Unlock()
+
+	if e.applied == nil {
+		entries, err := e.audit.Query(audit.Query{EventTypes: []string{audit.EventAction}})
+		if err != nil {
+			return nil, err
+		}
+		byEmail := make(map[string][]audit.AuditEntry)
+		for _, entry := range entries {
+			byEmail[entry.EmailID] = append(byEmail[entry.EmailID], entry)
+		}
+		e.applied = make(map[string]map[string]bool, len(byEmail))
+		for id, emailEntries := range byEmail {
+			if labels := AppliedLabels(emailEntries); len(labels) > 0 {
+				e.applied[id] = labels
+			}
+		}
+	}
+
+	labels := make([]string, 0, len(e.applied[emailID]))
+	for label := range e.applied[emailID] {
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
+// recordApplied updates the applied-label index, once read, with a label
+// change applied to an email, as the mail client audits it. Without
+// auditing nothing is recorded, as nothing is audited.
+func (e *ActionExecutor) recordApplied(emailID string, add, remove []string) {
+	e.appliedMutex.Lock()
+	defer e.appliedMutex.Unlock()
+
+	if e.applied == nil || !e.audit.Enabled() {
+		return
+	}
+	labels := e.applied[emailID]
+	for _, label := range add {
+		if _, system := systemLabel(label); system {
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]bool)
+			e.applied[emailID] = labels
+		}
+		labels[label] = true
+	}
+	for _, label := range remove {
+		delete(labels, label)
+	}
+}
+
+// Gate returns the result unchanged when its confidence meets its action's
+// minimum confidence. Otherwise it returns a copy carrying the downgrade
+// action, flagged in metadata with the action it replaced, and records the
+// downgrade in the audit log.
+func (e *ActionExecutor) Gate(ctx context.Context, email *types.Email, result *types.ClassificationResponse, dryRun bool) (*types.ClassificationResponse, error) {
+	threshold, gated := e.config.MinConfidence[result.Action]
+	if !gated || result.Confidence >= threshold {
+		return result, nil
+	}
+
+	action := e.config.DowngradeAction()
+	logging.FromContext(ctx, e.logger).WithFields(logrus.Fields{
+		"email_id":        email.ID,
+		"proposed_action": result.Action,
+		"action":          action,
+		"confidence":      result.Confidence,
+		"min_confidence":  threshold,
+	}).Info("Confidence below the action's minimum, downgrading")
+
+	if err := e.audit.LogActionDowngrade(ctx, email, result.Action, action, result.Confidence, threshold, dryRun); err != nil {
+		return nil, fmt.Errorf("failed to audit downgrade of action %s: %w", result.Action, err)
+	}
+
+	downgraded := *result
+	downgraded.Action = action
+	downgraded.Metadata = make(map[string]interface{}, len(result.Metadata)+2)
+	for key, value := range result.Metadata {
+		downgraded.Metadata[key] = value
+	}
+	downgraded.Metadata[types.MetadataDowngraded] = true
+	downgraded.Metadata[types.MetadataProposedAction] = result.Action
+	return &downgraded, nil
+}
+
+// Execute applies the label change for a classification result to an email,
+// creating any user labels that don't exist yet, and then its message
+// operation: moving the email to the trash or deleting it permanently. A
+// result below its action's minimum confidence is downgraded first, and in
+// safe mode the operation is replaced by archiving and labelling the email.
+func (e *ActionExecutor) Execute(ctx context.Context, result *types.ClassificationResponse, email *types.Email) (*types.AppliedAction, error) {
+	result, err := e.Gate(ctx, email, result, false)
+	if err != nil {
+		return nil, err
+	}
+	change, intercepted, err := e.planAction(ctx, email, result, false)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := &types.AppliedAction{
+		EmailID:              email.ID,
+		Action:               result.Action,
+		Operation:            change.Operation,
+		ProposedAction:       proposedAction(result),
+		InterceptedOperation: intercepted,
+	}
+
+	if len(change.Add) > 0 || len(change.Remove) > 0 {
+		labelIDs, err := e.resolveLabelIDs(ctx, append(append([]string{}, change.Add...), change.Remove...))
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve labels for action %s: %w", result.Action, err)
+		}
+
+		applied.AddLabels = lookupAll(labelIDs, change.Add)
+		applied.RemoveLabels = lookupAll(labelIDs, change.Remove)
+
+		if err := e.gmail.ModifyLabels(ctx, email.ID, applied.AddLabels, applied.RemoveLabels); err != nil {
+			return nil, fmt.Errorf("failed to apply action %s: %w", result.Action, err)
+		}
+		e.recordApplied(email.ID, applied.AddLabels, applied.RemoveLabels)
+	}
+
+	switch change.Operation {
+	case config.OperationTrash:
+		err = e.gmail.TrashMessage(ctx, email.ID)
+	case config.OperationDelete:
+		err = e.gmail.DeleteMessage(ctx, email.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply action %s: %w", result.Action, err)
+	}
+
+	logging.FromContext(ctx, e.logger).WithFields(logrus.Fields{
+		"email_id":      email.ID,
+		"action":        result.Action,
+		"add_labels":    change.Add,
+		"remove_labels": change.Remove,
+		"operation":     change.Operation,
+	}).Info("Executed classification action")
+
+	// The mail client audits each label change it applies
+	if err := e.audit.LogAction(ctx, email, result.Action, ""); err != nil {
+		return nil, fmt.Errorf("failed to audit action %s: %w", result.Action, err)
+	}
+
+	return applied, nil
+}
+
+// safeModeClient refuses to trash or delete messages while safe mode is on,
+// so that no path through the executor can remove an email
+type safeModeClient struct {
+	MailClient
+	config *config.ActionsConfig
+}
+
+// TrashMessage moves an email to the trash unless safe mode is on
+func (c *safeModeClient) TrashMessage(ctx context.Context, messageID string) error {
+	if c.config.SafeMode {
+		return ErrSafeMode
+	}
+	return c.MailClient.TrashMessage(ctx, messageID)
+}
+
+// DeleteMessage permanently deletes an email unless safe mode is on
+func (c *safeModeClient) DeleteMessage(ctx context.Context, messageID string) error {
+	if c.config.SafeMode {
+		return ErrSafeMode
+	}
+	return c.MailClient.DeleteMessage(ctx, messageID)
+}
+
+// proposedAction returns the action a downgraded result replaced, or ""
+func proposedAction(result *types.ClassificationResponse) string {
+	if downgraded, _ := result.Metadata[types.MetadataDowngraded].(bool); !downgraded {
+		return ""
+	}
+	proposed, _ := result.Metadata[types.MetadataProposedAction].(string)
+	return proposed
+}
+
+// resolveLabelIDs maps label names to label IDs through the mail client's
+// label cache, which creates missing labels and matches names ignoring case
+func (e *ActionExecutor) resolveLabelIDs(ctx context.Context, names []string) (map[string]string, error) {
+	ids := make(map[string]string, len(names))
+	for _, name := range names {
+		if _, done := ids[name]; done {
+			continue
+		}
+
+		if label, system := systemLabel(name); system {
+			ids[name] = string(label)
+			continue
+		}
+
+		id, err := e.gmail.LabelIDForName(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		ids[name] = id
+	}
+
+	return ids, nil
+}
+
+// lookupAll maps each name to its resolved ID
+func lookupAll(ids map[string]string, names []string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+
+	resolved := make([]string, 0, len(names))
+	for _, name := range names {
+		resolved = append(resolved, ids[name])
+	}
+	return resolved
+}
@@ -0,0 +1,305 @@
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestExecuteSystemLabelActions(t *testing.T) {
+	tests := []struct {
+		action string
+		add    []string
+		remove []string
+	}{
+		{action: "archive", remove: []string{"INBOX"}},
+		{action: "prioritize", add: []string{"IMPORTANT", "STARRED"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			gmail := &fakeMailClient{}
+			executor := NewActionExecutor(testActionsConfig(), gmail, testAuditLogger(t, t.TempDir()), testLogger())
+
+			applied, err := executor.Execute(context.Background(), &types.ClassificationResponse{Action: tt.action}, testEmail())
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.add, applied.AddLabels)
+			assert.Equal(t, tt.remove, applied.RemoveLabels)
+			require.Len(t, gmail.calls, 1)
+			assert.Equal(t, modifyCall{"email-1", tt.add, tt.remove}, gmail.calls[0])
+			assert.Empty(t, gmail.created, "system labels must never be created")
+		})
+	}
+}
+
+func TestExecuteMessageOperations(t *testing.T) {
+	gmail := &fakeMailClient{}
+	cfg := testActionsConfig()
+	cfg.LabelMapping["purge"] = config.LabelChange{Add: []string{"SPAM"}, Operation: config.OperationDelete}
+	executor := NewActionExecutor(cfg, gmail, testAuditLogger(t, t.TempDir()), testLogger())
+
+	applied, err := executor.Execute(context.Background(), &types.ClassificationResponse{Action: "delete"}, testEmail())
+	require.NoError(t, err)
+	assert.Equal(t, config.OperationTrash, applied.Operation)
+	assert.Equal(t, []string{"email-1"}, gmail.trashed, "delete moves the email to the trash by default")
+	assert.Empty(t, gmail.calls)
+	assert.Empty(t, gmail.deleted)
+
+	applied, err = executor.Execute(context.Background(), &types.ClassificationResponse{Action: "purge"}, testEmail())
+	require.NoError(t, err)
+	assert.Equal(t, config.OperationDelete, applied.Operation)
+	assert.Equal(t, []string{"email-1"}, gmail.deleted)
+	assert.Equal(t, []modifyCall{{"email-1", []string{"SPAM"}, nil}}, gmail.calls, "labels are applied before the operation")
+}
+
+func TestExecuteCreatesMissingLabels(t *testing.T) {
+	gmail := &fakeMailClient{
+		labels: []*gmail.Label{{Id: "Label_1", Name: "Newsletter"}},
+	}
+	cfg := &config.ActionsConfig{
+		LabelMapping: map[string]config.LabelChange{
+			"label": {Add: []string{"Newsletter", "MailSentinel/Review"}, Remove: []string{"INBOX"}},
+		},
+	}
+	executor := NewActionExecutor(cfg, gmail, testAuditLogger(t, t.TempDir()), testLogger())
+
+	applied, err := executor.Execute(context.Background(), &types.ClassificationResponse{Action: "label"}, testEmail())
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"MailSentinel/Review"}, gmail.created)
+	assert.Equal(t, []string{"Label_1", "Label_2"}, applied.AddLabels)
+	assert.Equal(t, []string{"INBOX"}, applied.RemoveLabels)
+}
+
+func TestExecuteMatchesLabelsIgnoringCase(t *testing.T) {
+	gmail := &fakeMailClient{labels: []*gmail.Label{{Id: "Label_1", Name: "Receipts"}}}
+	cfg := &config.ActionsConfig{
+		LabelMapping: map[string]config.LabelChange{"receipt": {Add: []string{"receipts"}}},
+	}
+	executor := NewActionExecutor(cfg, gmail, testAuditLogger(t, t.TempDir()), testLogger())
+
+	applied, err := executor.Execute(context.Background(), &types.ClassificationResponse{Action: "receipt"}, testEmail())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Label_1"}, applied.AddLabels)
+	assert.Empty(t, gmail.created, "a label differing only in case is not created again")
+}
+
+func TestExecuteAuditsEachLabelChangeOnce(t *testing.T) {
+	auditLogger := testAuditLogger(t, t.TempDir())
+	gmail := &fakeMailClient{labels: []*gmail.Label{{Id: "Label_1", Name: "MailSentinel/Review"}}, audit: auditLogger}
+	executor := NewActionExecutor(testActionsConfig(), gmail, auditLogger, testLogger())
+
+	_, err := executor.Execute(context.Background(), &types.ClassificationResponse{Action: "review"}, testEmail())
+	require.NoError(t, err)
+
+	entries, err := auditLogger.Query(audit.Query{EventTypes: []string{audit.EventAction}, EmailID: "email-1"})
+	require.NoError(t, err)
+	var labels []string
+	for _, entry := range entries {
+		labels = append(labels, entry.Metadata["label"].(string))
+	}
+	assert.Equal(t, []string{"+Label_1", ""}, labels, "the client audits the label, the executor the action")
+}
+
+func TestExecuteUnknownAction(t *testing.T) {
+	gmail := &fakeMailClient{}
+	executor := NewActionExecutor(testActionsConfig(), gmail, testAuditLogger(t, t.TempDir()), testLogger())
+
+	_, err := executor.Execute(context.Background(), &types.ClassificationResponse{Action: "teleport"}, testEmail())
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrUnknownAction)
+	assert.Empty(t, gmail.calls)
+}
+
+func TestExecuteNoOpAction(t *testing.T) {
+	gmail := &fakeMailClient{}
+	executor := NewActionExecutor(testActionsConfig(), gmail, testAuditLogger(t, t.TempDir()), testLogger())
+
+	applied, err := executor.Execute(context.Background(), &types.ClassificationResponse{Action: "keep"}, testEmail())
+	require.NoError(t, err)
+
+	assert.Equal(t, "keep", applied.Action)
+	assert.Empty(t, gmail.calls)
+}
+
+func TestExecuteMinConfidence(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     string
+		confidence float64
+		downgrade  string
+		applied    string
+		trashed    bool
+		calls      []modifyCall
+	}{
+		{name: "delete above threshold", action: "delete", confidence: 0.97, applied: "delete", trashed: true},
+		{name: "delete at threshold", action: "delete", confidence: 0.95, applied: "delete", trashed: true},
+		{name: "delete below threshold", action: "delete", confidence: 0.9, applied: "review", calls: []modifyCall{{"email-1", []string{"Label_1"}, nil}}},
+		{name: "archive above threshold", action: "archive", confidence: 0.85, applied: "archive", calls: []modifyCall{{"email-1", nil, []string{"INBOX"}}}},
+		{name: "archive below threshold", action: "archive", confidence: 0.5, applied: "review", calls: []modifyCall{{"email-1", []string{"Label_1"}, nil}}},
+		{name: "below threshold to no-op", action: "archive", confidence: 0.5, downgrade: "none", applied: "none"},
+		{name: "action without threshold", action: "star", confidence: 0.1, applied: "star", calls: []modifyCall{{"email-1", []string{"STARRED"}, nil}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gmail := &fakeMailClient{}
+			auditDir := t.TempDir()
+			cfg := testActionsConfig()
+			cfg.MinConfidence = map[string]float64{"delete": 0.95, "archive": 0.8}
+			cfg.BelowThresholdAction = tt.downgrade
+			executor := NewActionExecutor(cfg, gmail, testAuditLogger(t, auditDir), testLogger())
+
+			applied, err := executor.Execute(context.Background(), &types.ClassificationResponse{Action: tt.action, Confidence: tt.confidence}, testEmail())
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.applied, applied.Action)
+			assert.Equal(t, tt.calls, gmail.calls)
+			assert.Equal(t, tt.trashed, len(gmail.trashed) == 1)
+			events := auditEventTypes(t, auditDir)
+			if tt.applied == tt.action {
+				assert.Empty(t, applied.ProposedAction)
+				assert.NotContains(t, events, audit.EventActionDowngraded)
+			} else {
+				assert.Equal(t, tt.action, applied.ProposedAction)
+				assert.Equal(t, audit.EventActionDowngraded, events[0], "the downgrade is audited before the action")
+			}
+		})
+	}
+}
+
+func TestExecuteSafeMode(t *testing.T) {
+	tests := []struct {
+		name      string
+		action    string
+		operation string
+		calls     []modifyCall
+	}{
+		{name: "trash", action: "delete", operation: config.OperationTrash, calls: []modifyCall{{"email-1", []string{"Label_1"}, []string{"INBOX"}}}},
+		{name: "permanent delete", action: "purge", operation: config.OperationDelete, calls: []modifyCall{{"email-1", []string{"SPAM", "Label_1"}, []string{"INBOX"}}}},
+		{name: "no operation", action: "archive", calls: []modifyCall{{"email-1", nil, []string{"INBOX"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gmail := &fakeMailClient{}
+			auditDir := t.TempDir()
+			cfg := testActionsConfig()
+			cfg.SafeMode = true
+			cfg.MinConfidence = map[string]float64{"delete": 0.95}
+			cfg.LabelMapping["purge"] = config.LabelChange{Add: []string{"SPAM"}, Operation: config.OperationDelete}
+			executor := NewActionExecutor(cfg, gmail, testAuditLogger(t, auditDir), testLogger())
+
+			applied, err := executor.Execute(context.Background(), &types.ClassificationResponse{Action: tt.action, Confidence: 0.99}, testEmail())
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.action, applied.Action, "the decision itself is kept")
+			assert.Empty(t, applied.Operation)
+			assert.Equal(t, tt.operation, applied.InterceptedOperation)
+			assert.Equal(t, tt.calls, gmail.calls)
+			assert.Empty(t, gmail.trashed)
+			assert.Empty(t, gmail.deleted)
+			events := auditEventTypes(t, auditDir)
+			if tt.operation == "" {
+				assert.Empty(t, gmail.created)
+				assert.NotContains(t, events, audit.EventActionIntercepted)
+			} else {
+				assert.Equal(t, []string{config.DefaultSafeModeLabel}, gmail.created)
+				assert.Equal(t, audit.EventActionIntercepted, events[0], "the interception is audited before the action")
+			}
+		})
+	}
+}
+
+func TestSafeModeRefusesMessageRemoval(t *testing.T) {
+	gmail := &fakeMailClient{}
+	cfg := testActionsConfig()
+	cfg.SafeMode = true
+	cfg.SafeModeLabel = "Quarantine/WouldDelete"
+	executor := NewActionExecutor(cfg, gmail, testAuditLogger(t, t.TempDir()), testLogger())
+
+	change, err := executor.Plan(&types.ClassificationResponse{Action: "delete"})
+	require.NoError(t, err)
+	assert.Equal(t, &config.LabelChange{Add: []string{"Quarantine/WouldDelete"}, Remove: []string{"INBOX"}}, change)
+
+	assert.ErrorIs(t, executor.gmail.TrashMessage(context.Background(), "email-1"), ErrSafeMode)
+	assert.ErrorIs(t, executor.gmail.DeleteMessage(context.Background(), "email-1"), ErrSafeMode)
+	assert.Empty(t, gmail.trashed)
+	assert.Empty(t, gmail.deleted)
+
+	cfg.SafeMode = false
+	require.NoError(t, executor.gmail.TrashMessage(context.Background(), "email-1"))
+	assert.Equal(t, []string{"email-1"}, gmail.trashed)
+}
+
+func TestExecuteReconcilesLabels(t *testing.T) {
+	auditLogger := testAuditLogger(t, t.TempDir())
+	gmail := &fakeMailClient{labels: []*gmail.Label{
+		{Id: "Label_1", Name: "MailSentinel/Review"},
+		{Id: "Label_2", Name: "Family"},
+		{Id: "Label_3", Name: "MailSentinel/Spam"},
+	}, audit: auditLogger}
+	cfg := testActionsConfig()
+	cfg.Reconcile = true
+	cfg.OwnedLabels = []string{"MailSentinel/Spam"}
+	executor := NewActionExecutor(cfg, gmail, auditLogger, testLogger())
+
+	// An email reviewed by an earlier profile version and flagged as spam
+	// with a retired label
+	email := testEmail()
+	email.Labels = []string{"INBOX"}
+	_, err := executor.Execute(context.Background(), &types.ClassificationResponse{Action: "review"}, email)
+	require.NoError(t, err)
+	gmail.calls = nil
+
+	email.Labels = []string{"INBOX", "Label_1", "Label_2", "Label_3"}
+	applied, err := executor.Execute(context.Background(), &types.ClassificationResponse{Action: "archive"}, email)
+	require.NoError(t, err)
+	assert.Nil(t, applied.AddLabels)
+	assert.Equal(t, []string{"INBOX", "Label_1", "Label_3"}, applied.RemoveLabels, "the user's Family label is kept")
+	assert.Equal(t, []modifyCall{{"email-1", nil, []string{"INBOX", "Label_1", "Label_3"}}}, gmail.calls)
+
+	email.Labels = []string{"INBOX", "Label_1"}
+	applied, err = executor.Execute(context.Background(), &types.ClassificationResponse{Action: "review"}, email)
+	require.NoError(t, err)
+	assert.Nil(t, applied.AddLabels)
+	assert.Nil(t, applied.RemoveLabels)
+	assert.Len(t, gmail.calls, 1, "an email already carrying its labels is not modified")
+
+	// A new executor reads the labels applied so far from the audit log
+	reviewed := testEmail()
+	reviewed.ID = "email-3"
+	reviewed.Labels = []string{"INBOX"}
+	_, err = executor.Execute(context.Background(), &types.ClassificationResponse{Action: "review"}, reviewed)
+	require.NoError(t, err)
+	reviewed.Labels = []string{"INBOX", "Label_1"}
+	fresh := NewActionExecutor(cfg, gmail, auditLogger, testLogger())
+	applied, err = fresh.Execute(context.Background(), &types.ClassificationResponse{Action: "archive"}, reviewed)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"INBOX", "Label_1"}, applied.RemoveLabels)
+
+	// The same label applied by the user rather than the executor
+	other := testEmail()
+	other.ID = "email-2"
+	other.Labels = []string{"INBOX", "Label_1"}
+	applied, err = executor.Execute(context.Background(), &types.ClassificationResponse{Action: "archive"}, other)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"INBOX"}, applied.RemoveLabels, "labels the executor did not apply are kept")
+
+	cfg.Reconcile = false
+	applied, err = executor.Execute(context.Background(), &types.ClassificationResponse{Action: "archive"}, email)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"INBOX"}, applied.RemoveLabels, "without reconciling only the action's labels change")
+}
+
+func testEmail() *types.Email {
+	return &types.Email{ID: "email-1", Subject: "Weekly newsletter", From: "news@example.com"}
+}
@@ -0,0 +1,243 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+// ErrForbiddenLabel is returned for a label change the Gmail API does not
+// allow, such as adding SENT
+var ErrForbiddenLabel = errors.New("forbidden label change")
+
+// ErrUnknownSystemAction is returned for a system action that does not exist
+var ErrUnknownSystemAction = errors.New("unknown system action")
+
+// SystemLabel is one of Gmail's built-in labels, whose ID equals its name
+type SystemLabel string
+
+// Gmail system labels
+const (
+	LabelInbox      SystemLabel = "INBOX"
+	LabelSpam       SystemLabel = "SPAM"
+	LabelTrash      SystemLabel = "TRASH"
+	LabelUnread     SystemLabel = "UNREAD"
+	LabelStarred    SystemLabel = "STARRED"
+	LabelImportant  SystemLabel = "IMPORTANT"
+	LabelSent       SystemLabel = "SENT"
+	LabelDraft      SystemLabel = "DRAFT"
+	LabelChat       SystemLabel = "CHAT"
+	LabelPersonal   SystemLabel = "CATEGORY_PERSONAL"
+	LabelSocial     SystemLabel = "CATEGORY_SOCIAL"
+	LabelPromotions SystemLabel = "CATEGORY_PROMOTIONS"
+	LabelUpdates    SystemLabel = "CATEGORY_UPDATES"
+	LabelForums     SystemLabel = "CATEGORY_FORUMS"
+)
+
+// systemLabels maps each system label to whether messages.modify may add or
+// remove it. SENT, DRAFT and CHAT follow from how a message was created, and
+// TRASH is managed by the trash operation instead.
+var systemLabels = map[SystemLabel]bool{
+	LabelInbox:      true,
+	LabelSpam:       true,
+	LabelTrash:      false,
+	LabelUnread:     true,
+	LabelStarred:    true,
+	LabelImportant:  true,
+	LabelSent:       false,
+	LabelDraft:      false,
+	LabelChat:       false,
+	LabelPersonal:   true,
+	LabelSocial:     true,
+	LabelPromotions: true,
+	LabelUpdates:    true,
+	LabelForums:     true,
+}
+
+// systemLabel returns the system label a label name refers to, ignoring case
+func systemLabel(name string) (SystemLabel, bool) {
+	label := SystemLabel(strings.ToUpper(name))
+	_, exists := systemLabels[label]
+	return label, exists
+}
+
+// Modifiable reports whether the Gmail API lets messages.modify add or
+// remove the label
+func (l SystemLabel) Modifiable() bool {
+	return systemLabels[l]
+}
+
+// SystemAction is a high-level Gmail action expressed in system labels,
+// named by a label_mapping entry's system field
+type SystemAction string
+
+// System actions
+const (
+	ActionArchive         SystemAction = "archive"
+	ActionMoveToInbox     SystemAction = "move_to_inbox"
+	ActionMarkImportant   SystemAction = "mark_important"
+	ActionMarkUnimportant SystemAction = "mark_unimportant"
+	ActionStar            SystemAction = "star"
+	ActionUnstar          SystemAction = "unstar"
+	ActionMarkRead        SystemAction = "mark_read"
+	ActionMarkUnread      SystemAction = "mark_unread"
+	ActionReportSpam      SystemAction = "report_spam"
+	ActionNotSpam         SystemAction = "not_spam"
+)
+
+// systemActions are the labels each system action adds and removes
+var systemActions = map[SystemAction]struct{ add, remove []SystemLabel }{
+	ActionArchive:         {remove: []SystemLabel{LabelInbox}},
+	ActionMoveToInbox:     {add: []SystemLabel{LabelInbox}},
+	ActionMarkImportant:   {add: []SystemLabel{LabelImportant}},
+	ActionMarkUnimportant: {remove: []SystemLabel{LabelImportant}},
+	ActionStar:            {add: []SystemLabel{LabelStarred}},
+	ActionUnstar:          {remove: []SystemLabel{LabelStarred}},
+	ActionMarkRead:        {remove: []SystemLabel{LabelUnread}},
+	ActionMarkUnread:      {add: []SystemLabel{LabelUnread}},
+	ActionReportSpam:      {add: []SystemLabel{LabelSpam}, remove: []SystemLabel{LabelInbox}},
+	ActionNotSpam:         {add: []SystemLabel{LabelInbox}, remove: []SystemLabel{LabelSpam}},
+}
+
+// SystemLabelChange returns the label IDs a system action adds and removes
+func SystemLabelChange(action SystemAction) (add, remove []string, err error) {
+	labels, exists := systemActions[action]
+	if !exists {
+		return nil, nil, fmt.Errorf("%w %q", ErrUnknownSystemAction, action)
+	}
+	return labelIDs(labels.add), labelIDs(labels.remove), nil
+}
+
+// labelIDs returns the IDs of system labels
+func labelIDs(labels []SystemLabel) []string {
+	if len(labels) == 0 {
+		return nil
+	}
+	ids := make([]string, len(labels))
+	for i, label := range labels {
+		ids[i] = string(label)
+	}
+	return ids
+}
+
+// ValidateLabelChange checks that a label change modifies no system label
+// the Gmail API forbids modifying and does not both add and remove a label
+func ValidateLabelChange(add, remove []string) error {
+	added := make(map[string]bool, len(add))
+	for _, name := range add {
+		if label, system := systemLabel(name); system && !label.Modifiable() {
+			return fmt.Errorf("%w: %s cannot be added", ErrForbiddenLabel, label)
+		}
+		added[labelKey(name)] = true
+	}
+	for _, name := range remove {
+		if label, system := systemLabel(name); system && !label.Modifiable() {
+			return fmt.Errorf("%w: %s cannot be removed", ErrForbiddenLabel, label)
+		}
+		if added[labelKey(name)] {
+			return fmt.Errorf("%w: %s is both added and removed", ErrForbiddenLabel, name)
+		}
+	}
+	return nil
+}
+
+// LabelDelta is the change reconciling the labels of an email with its new
+// classification: the labels to add, those to remove, and the labels the
+// change adds that the email already carries
+type LabelDelta struct {
+	Add    []string
+	Remove []string
+	Keep   []string
+}
+
+// Reconcile returns the delta between the labels an email carries, by name,
+// and the label change of its new classification. The change's additions
+// the email already carries are kept rather than added again, its removals
+// are kept as they are, and the owned labels the email carries that the
+// change does not add are removed as stale. Labels that are not owned are
+// never removed unless the change removes them.
+func Reconcile(current []string, change config.LabelChange, owned map[string]bool) LabelDelta {
+	carried := make(map[string]bool, len(current))
+	for _, name := range current {
+		carried[labelKey(name)] = true
+	}
+
+	var delta LabelDelta
+	added := make(map[string]bool, len(change.Add))
+	for _, name := range change.Add {
+		added[labelKey(name)] = true
+		if carried[labelKey(name)] {
+			delta.Keep = append(delta.Keep, name)
+		} else {
+			delta.Add = append(delta.Add, name)
+		}
+	}
+
+	removed := make(map[string]bool, len(change.Remove))
+	for _, name := range change.Remove {
+		removed[labelKey(name)] = true
+		delta.Remove = append(delta.Remove, name)
+	}
+	for _, name := range current {
+		key := labelKey(name)
+		if owned[key] && !added[key] && !removed[key] {
+			removed[key] = true
+			delta.Remove = append(delta.Remove, name)
+		}
+	}
+	return delta
+}
+
+// OwnedLabels returns the configured owned labels reconciling may remove
+// from any email, keyed by labelKey, leaving out the system labels
+// messages.modify cannot remove
+func OwnedLabels(cfg *config.ActionsConfig) map[string]bool {
+	owned := make(map[string]bool, len(cfg.OwnedLabels))
+	for _, name := range cfg.OwnedLabels {
+		if label, system := systemLabel(name); system && !label.Modifiable() {
+			continue
+		}
+		owned[labelKey(name)] = true
+	}
+	return owned
+}
+
+// AppliedLabels returns the user labels still applied to an email by the
+// action audit entries the mail client writes for each label change, oldest
+// first: each label added by a "+label" entry, unless a later "-label" entry
+// removed it. Gmail records labels by ID. System labels are left out, as
+// users and Gmail change them too.
+func AppliedLabels(entries []audit.AuditEntry) map[string]bool {
+	applied := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.EventType != audit.EventAction {
+			continue
+		}
+		label, _ := entry.Metadata["label"].(string)
+		if len(label) < 2 {
+			continue
+		}
+		name := label[1:]
+		if _, system := systemLabel(name); system {
+			continue
+		}
+		switch label[0] {
+		case '+':
+			applied[name] = true
+		case '-':
+			delete(applied, name)
+		}
+	}
+	return applied
+}
+
+// labelKey identifies a label by name, ignoring case for system labels
+func labelKey(name string) string {
+	if label, system := systemLabel(name); system {
+		return string(label)
+	}
+	return name
+}
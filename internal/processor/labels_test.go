@@ -0,0 +1,180 @@
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestSystemLabelChange(t *testing.T) {
+	tests := []struct {
+		action SystemAction
+		add    []string
+		remove []string
+	}{
+		{ActionArchive, nil, []string{"INBOX"}},
+		{ActionMoveToInbox, []string{"INBOX"}, nil},
+		{ActionMarkImportant, []string{"IMPORTANT"}, nil},
+		{ActionMarkUnimportant, nil, []string{"IMPORTANT"}},
+		{ActionStar, []string{"STARRED"}, nil},
+		{ActionUnstar, nil, []string{"STARRED"}},
+		{ActionMarkRead, nil, []string{"UNREAD"}},
+		{ActionMarkUnread, []string{"UNREAD"}, nil},
+		{ActionReportSpam, []string{"SPAM"}, []string{"INBOX"}},
+		{ActionNotSpam, []string{"INBOX"}, []string{"SPAM"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.action), func(t *testing.T) {
+			add, remove, err := SystemLabelChange(tt.action)
+			require.NoError(t, err)
+			assert.Equal(t, tt.add, add)
+			assert.Equal(t, tt.remove, remove)
+			assert.NoError(t, ValidateLabelChange(add, remove))
+		})
+	}
+
+	_, _, err := SystemLabelChange("teleport")
+	assert.ErrorIs(t, err, ErrUnknownSystemAction)
+}
+
+func TestValidateLabelChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		add    []string
+		remove []string
+		errMsg string
+	}{
+		{"user and system labels", []string{"Newsletter", "starred"}, []string{"INBOX"}, ""},
+		{"category labels", []string{"CATEGORY_PROMOTIONS"}, []string{"CATEGORY_UPDATES"}, ""},
+		{"adding sent", []string{"SENT"}, nil, "SENT cannot be added"},
+		{"removing draft", nil, []string{"draft"}, "DRAFT cannot be removed"},
+		{"adding trash", []string{"TRASH"}, nil, "TRASH cannot be added"},
+		{"adding and removing a system label", []string{"inbox"}, []string{"INBOX"}, "INBOX is both added and removed"},
+		{"adding and removing a user label", []string{"Review"}, []string{"Review"}, "Review is both added and removed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateLabelChange(tt.add, tt.remove)
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrForbiddenLabel)
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	owned := map[string]bool{"MailSentinel/Review": true, "MailSentinel/Spam": true, "Newsletter": true}
+	tests := []struct {
+		name     string
+		current  []string
+		change   config.LabelChange
+		expected LabelDelta
+	}{
+		{
+			name:     "add",
+			current:  []string{"INBOX"},
+			change:   config.LabelChange{Add: []string{"Newsletter"}, Remove: []string{"INBOX"}},
+			expected: LabelDelta{Add: []string{"Newsletter"}, Remove: []string{"INBOX"}},
+		},
+		{
+			name:     "remove stale",
+			current:  []string{"INBOX", "MailSentinel/Spam", "Newsletter"},
+			change:   config.LabelChange{Add: []string{"MailSentinel/Review"}},
+			expected: LabelDelta{Add: []string{"MailSentinel/Review"}, Remove: []string{"MailSentinel/Spam", "Newsletter"}},
+		},
+		{
+			name:     "keep",
+			current:  []string{"INBOX", "MailSentinel/Review", "MailSentinel/Spam"},
+			change:   config.LabelChange{Add: []string{"MailSentinel/Review"}},
+			expected: LabelDelta{Remove: []string{"MailSentinel/Spam"}, Keep: []string{"MailSentinel/Review"}},
+		},
+		{
+			name:     "labels not owned",
+			current:  []string{"INBOX", "STARRED", "Family", "Newsletter"},
+			change:   config.LabelChange{},
+			expected: LabelDelta{Remove: []string{"Newsletter"}},
+		},
+		{
+			name:     "stale label the change removes",
+			current:  []string{"Newsletter", "INBOX"},
+			change:   config.LabelChange{Remove: []string{"Newsletter", "INBOX"}},
+			expected: LabelDelta{Remove: []string{"Newsletter", "INBOX"}},
+		},
+		{
+			name:     "system labels ignore case",
+			current:  []string{"INBOX", "IMPORTANT"},
+			change:   config.LabelChange{Add: []string{"important", "starred"}},
+			expected: LabelDelta{Add: []string{"starred"}, Keep: []string{"important"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Reconcile(tt.current, tt.change, owned))
+		})
+	}
+}
+
+func TestOwnedLabels(t *testing.T) {
+	cfg := testActionsConfig()
+	cfg.LabelMapping["newsletter"] = config.LabelChange{Add: []string{"Newsletter", "CATEGORY_PROMOTIONS"}}
+	cfg.OwnedLabels = []string{"MailSentinel/Old", "starred", "SENT"}
+	assert.Equal(t, map[string]bool{
+		"MailSentinel/Old": true,
+		"STARRED":          true,
+	}, OwnedLabels(cfg), "label_mapping labels are not owned, and never the system labels Gmail forbids removing")
+}
+
+func TestAppliedLabels(t *testing.T) {
+	action := func(emailID, label string) audit.AuditEntry {
+		return audit.AuditEntry{EventType: audit.EventAction, EmailID: emailID, Action: "archive", Metadata: map[string]interface{}{"label": label}}
+	}
+	entries := []audit.AuditEntry{
+		action("email-1", "+MailSentinel/Review"),
+		action("email-1", "+MailSentinel/Newsletter"),
+		action("email-1", "-INBOX"),
+		action("email-1", "+STARRED"),
+		action("email-1", ""),
+		{EventType: audit.EventActionIntercepted, EmailID: "email-1", Metadata: map[string]interface{}{"label": "+Other"}},
+		action("email-1", "-MailSentinel/Review"),
+	}
+	assert.Equal(t, map[string]bool{"MailSentinel/Newsletter": true}, AppliedLabels(entries), "removed and system labels are not applied")
+}
+
+func TestExecuteSystemActions(t *testing.T) {
+	gmail := &fakeMailClient{}
+	cfg := testActionsConfig()
+	cfg.LabelMapping["important"] = config.LabelChange{System: string(ActionMarkImportant), Add: []string{"STARRED"}}
+	cfg.LabelMapping["sent"] = config.LabelChange{Add: []string{"SENT"}}
+	cfg.LabelMapping["bogus"] = config.LabelChange{System: "teleport"}
+	executor := NewActionExecutor(cfg, gmail, testAuditLogger(t, t.TempDir()), testLogger())
+
+	applied, err := executor.Execute(context.Background(), &types.ClassificationResponse{Action: "spam"}, testEmail())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"SPAM"}, applied.AddLabels)
+	assert.Equal(t, []string{"INBOX"}, applied.RemoveLabels)
+
+	applied, err = executor.Execute(context.Background(), &types.ClassificationResponse{Action: "important"}, testEmail())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"IMPORTANT", "STARRED"}, applied.AddLabels, "system labels come first")
+	assert.Equal(t, []string{"STARRED"}, cfg.LabelMapping["important"].Add, "the configured mapping is not modified")
+
+	_, err = executor.Execute(context.Background(), &types.ClassificationResponse{Action: "sent"}, testEmail())
+	assert.ErrorIs(t, err, ErrForbiddenLabel)
+	_, err = executor.Execute(context.Background(), &types.ClassificationResponse{Action: "bogus"}, testEmail())
+	assert.ErrorIs(t, err, ErrUnknownSystemAction)
+
+	require.Len(t, gmail.calls, 2, "invalid changes never reach Gmail")
+	assert.Empty(t, gmail.created, "system labels must never be created")
+}
@@ -0,0 +1,289 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/cursor"
+	"github.com/mailsentinel/core/internal/lifecycle"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// MailClient is the subset of the Gmail client used to apply actions
+type MailClient interface {
+	ModifyLabels(ctx context.Context, messageID string, addLabels, removeLabels []string) error
+	LabelIDForName(ctx context.Context, name string) (string, error)
+	LabelNameForID(ctx context.Context, id string) (string, error)
+	TrashMessage(ctx context.Context, messageID string) error
+	DeleteMessage(ctx context.Context, messageID string) error
+}
+
+// errNoResult is recorded for emails that have no classification result
+var errNoResult = errors.New("no classification result")
+
+// Processor applies classification results to Gmail
+type Processor struct {
+	executor  *ActionExecutor
+	audit     *audit.Logger
+	lifecycle *lifecycle.Coordinator
+	cursor    *cursor.Cursor
+	logger    *logrus.Logger
+}
+
+// NewProcessor creates a new processor
+func NewProcessor(cfg *config.ActionsConfig, gmail MailClient, auditLogger *audit.Logger, logger *logrus.Logger) *Processor {
+	return &Processor{
+		executor: NewActionExecutor(cfg, gmail, auditLogger, logger),
+		audit:    auditLogger,
+		logger:   logger,
+	}
+}
+
+// SetLifecycle makes the processor track each email's actions with a
+// shutdown coordinator, so shutdown waits for Gmail modifications in flight.
+// Emails not yet started when shutdown begins fail with
+// lifecycle.ErrShuttingDown. A nil coordinator disables tracking.
+func (p *Processor) SetLifecycle(coordinator *lifecycle.Coordinator) {
+	p.lifecycle = coordinator
+}
+
+// SetCursor records each email's progress in a processing cursor, so that
+// applying a batch again after a crash skips the emails already completed
+// and acts again only on the ones interrupted mid-action. Progress is kept
+// per batch: Apply and ApplyDecisions use the context's correlation ID as
+// the batch ID, or none when it has none, and ApplyDecision is given it.
+// Dry runs neither read nor record progress. A nil cursor disables progress
+// tracking.
+func (p *Processor) SetCursor(c *cursor.Cursor) {
+	p.cursor = c
+}
+
+// Apply applies the classification results for the emails in a batch request.
+// When the request is a dry run, the label changes are recorded in the audit
+// log and summary but Gmail is never modified.
+func (p *Processor) Apply(ctx context.Context, req *types.BatchRequest, results []*types.ClassificationResponse) *types.BatchResponse {
+	return p.apply(ctx, req, results, nil)
+}
+
+// ApplyDecisions applies the resolution of each decision to its email like
+// Apply, records on the decision the action taken or, in a dry run,
+// intended, and audits every decision acted on as a single entry. Decisions
+// whose action failed are not audited; their failure is in the summary.
+func (p *Processor) ApplyDecisions(ctx context.Context, req *types.BatchRequest, decisions []*types.Decision) *types.BatchResponse {
+	results := make([]*types.ClassificationResponse, 0, len(decisions))
+	decisionsByEmail := make(map[string]*types.Decision, len(decisions))
+	for _, decision := range decisions {
+		results = append(results, decision.Resolution)
+		decisionsByEmail[decision.EmailID] = decision
+	}
+	return p.apply(ctx, req, results, func(ctx context.Context, email *types.Email, applied *types.AppliedAction) {
+		p.recordDecision(ctx, email, decisionsByEmail[email.ID], applied)
+	})
+}
+
+// ApplyDecision applies the resolution of one email's decision, records on
+// the decision the action taken or, in a dry run, intended, and audits the
+// decision as a single entry, as ApplyDecisions does for a batch. Its
+// progress is recorded under batchID. It does not read the cursor: callers
+// skip the emails it reports Completed, before classifying them, and call
+// FinishBatch once the batch is done.
+func (p *Processor) ApplyDecision(ctx context.Context, batchID string, email *types.Email, decision *types.Decision, dryRun bool) (*types.AppliedAction, error) {
+	return p.applyEmail(ctx, batchID, email, decision.Resolution, dryRun, func(ctx context.Context, email *types.Email, applied *types.AppliedAction) {
+		p.recordDecision(ctx, email, decision, applied)
+	})
+}
+
+// Completed reports whether the cursor recorded the email as completed in
+// the batch, in which case acting on it again is skipped. It is false
+// without a cursor.
+func (p *Processor) Completed(batchID, emailID string) bool {
+	return p.cursor != nil && p.cursor.Completed(batchID, emailID)
+}
+
+// FinishBatch drops the cursor's progress of a batch whose emails were all
+// processed, so that a later batch under the same ID acts on them again.
+// It does nothing without a cursor.
+func (p *Processor) FinishBatch(batchID string) error {
+	if p.cursor == nil {
+		return nil
+	}
+	return p.cursor.FinishBatch(batchID)
+}
+
+// appliedFunc is called with each action applied, before the email is
+// recorded as completed
+type appliedFunc func(ctx context.Context, email *types.Email, applied *types.AppliedAction)
+
+// apply applies the results for the emails of a batch request, calling
+// onApplied, if set, with each action applied
+func (p *Processor) apply(ctx context.Context, req *types.BatchRequest, results []*types.ClassificationResponse, onApplied appliedFunc) *types.BatchResponse {
+	startTime := time.Now()
+	batchID := logging.CorrelationID(ctx)
+	if batchID == "" {
+		ctx = logging.WithCorrelationID(ctx, logging.NewCorrelationID())
+	}
+
+	logging.FromContext(ctx, p.logger).WithFields(logrus.Fields{
+		"email_count":  len(req.Emails),
+		"result_count": len(results),
+		"dry_run":      req.DryRun,
+	}).Info("Applying classification results")
+
+	resultsByEmail := make(map[string]*types.ClassificationResponse, len(results))
+	for _, result := range results {
+		// Shadow profiles are evaluated without ever acting on Gmail
+		if result.IsShadow() {
+			continue
+		}
+		resultsByEmail[result.EmailID] = result
+	}
+
+	response := &types.BatchResponse{
+		DryRun: req.DryRun,
+		Summary: types.BatchSummary{
+			TotalEmails:  len(req.Emails),
+			ActionCounts: make(map[string]int),
+		},
+	}
+
+	var totalConfidence float64
+	for i := range req.Emails {
+		email := &req.Emails[i]
+
+		if !req.DryRun && p.Completed(batchID, email.ID) {
+			logging.FromContext(ctx, p.logger).WithField("email_id", email.ID).Debug("Skipping email completed before the run was interrupted")
+			response.Summary.SkippedEmails++
+			continue
+		}
+
+		result, exists := resultsByEmail[email.ID]
+		if !exists {
+			response.Summary.AddFailure(email.ID, types.StageClassify, errNoResult)
+			continue
+		}
+
+		emailCtx := logging.ForEmail(ctx, email.ID)
+		applied, err := p.applyEmail(emailCtx, batchID, email, result, req.DryRun, onApplied)
+		if err != nil {
+			logging.FromContext(emailCtx, p.logger).WithError(err).WithField("email_id", email.ID).Error("Failed to apply classification result")
+			response.Summary.AddFailure(email.ID, types.StageAction, err)
+			continue
+		}
+
+		response.Results = append(response.Results, *result)
+		response.Summary.Actions = append(response.Summary.Actions, *applied)
+		response.Summary.ProcessedEmails++
+		response.Summary.ActionCounts[applied.Action]++
+		response.Summary.RecordConfidence(applied.Action, result.Confidence)
+		totalConfidence += result.Confidence
+	}
+
+	if response.Summary.ProcessedEmails > 0 {
+		response.Summary.AvgConfidence = totalConfidence / float64(response.Summary.ProcessedEmails)
+	}
+	response.Summary.ProcessingTime = time.Since(startTime)
+	response.ProcessedAt = time.Now()
+
+	logging.FromContext(ctx, p.logger).WithFields(logrus.Fields{
+		"processed": response.Summary.ProcessedEmails,
+		"failed":    response.Summary.FailedEmails,
+		"dry_run":   req.DryRun,
+	}).Info("Finished applying classification results")
+
+	return response
+}
+
+// applyEmail applies a result to its email as a unit of in-flight work,
+// recording its progress in the cursor under batchID outside dry runs.
+// onApplied, if set, is called once the action is applied, before the email
+// is recorded as completed.
+func (p *Processor) applyEmail(ctx context.Context, batchID string, email *types.Email, result *types.ClassificationResponse, dryRun bool, onApplied appliedFunc) (*types.AppliedAction, error) {
+	done, err := p.lifecycle.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	tracked := p.cursor != nil && !dryRun
+	if tracked {
+		// The start is recorded before acting, so that an action never
+		// goes unrecorded
+		if p.cursor.Interrupted(batchID, email.ID) {
+			logging.FromContext(ctx, p.logger).WithField("email_id", email.ID).Warn("Applying again the action interrupted by the previous run")
+		}
+		if err := p.cursor.Start(batchID, email.ID); err != nil {
+			return nil, fmt.Errorf("failed to record progress, not acting on email: %w", err)
+		}
+	}
+
+	applied, err := p.applyResult(ctx, email, result, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	if onApplied != nil {
+		onApplied(ctx, email, applied)
+	}
+	if tracked {
+		if err := p.cursor.Complete(batchID, email.ID); err != nil {
+			// The email stays interrupted and is acted on again on resume
+			logging.FromContext(ctx, p.logger).WithError(err).WithField("email_id", email.ID).Error("Failed to record completed email")
+		}
+	}
+	return applied, nil
+}
+
+// recordDecision records the action applied on a decision and audits the
+// decision. Audit failures are logged but do not fail the email, whose
+// action is already applied.
+func (p *Processor) recordDecision(ctx context.Context, email *types.Email, decision *types.Decision, applied *types.AppliedAction) {
+	decision.RecordAction(applied, time.Now())
+	if err := p.audit.LogDecision(ctx, email, decision); err != nil {
+		logging.FromContext(ctx, p.logger).WithError(err).WithField("email_id", email.ID).Error("Failed to audit decision")
+	}
+}
+
+// applyResult applies a single classification result to its email
+func (p *Processor) applyResult(ctx context.Context, email *types.Email, result *types.ClassificationResponse, dryRun bool) (*types.AppliedAction, error) {
+	if !dryRun {
+		return p.executor.Execute(ctx, result, email)
+	}
+
+	result, err := p.executor.Gate(ctx, email, result, true)
+	if err != nil {
+		return nil, err
+	}
+	change, intercepted, err := p.executor.planAction(ctx, email, result, true)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx, p.logger).WithFields(logrus.Fields{
+		"email_id":      email.ID,
+		"action":        result.Action,
+		"add_labels":    change.Add,
+		"remove_labels": change.Remove,
+		"operation":     change.Operation,
+	}).Info("Dry run: skipping Gmail modification")
+
+	if err := p.audit.LogLabelChange(ctx, email, result.Action, change.Add, change.Remove, change.Operation, true); err != nil {
+		return nil, fmt.Errorf("failed to audit action %s: %w", result.Action, err)
+	}
+
+	return &types.AppliedAction{
+		EmailID:              email.ID,
+		Action:               result.Action,
+		AddLabels:            change.Add,
+		RemoveLabels:         change.Remove,
+		Operation:            change.Operation,
+		DryRun:               true,
+		ProposedAction:       proposedAction(result),
+		InterceptedOperation: intercepted,
+	}, nil
+}
@@ -0,0 +1,507 @@
+package processor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/gmail/v1"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/cursor"
+	"github.com/mailsentinel/core/internal/lifecycle"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/internal/resolver"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestApplyModifiesLabels(t *testing.T) {
+	gmail := &fakeMailClient{}
+	auditDir := t.TempDir()
+	processor := NewProcessor(testActionsConfig(), gmail, testAuditLogger(t, auditDir), testLogger())
+
+	response := processor.Apply(context.Background(), testBatchRequest(false), testResults())
+
+	assert.False(t, response.DryRun)
+	assert.Equal(t, 2, response.Summary.ProcessedEmails)
+	assert.Equal(t, 0, response.Summary.FailedEmails)
+	assert.Equal(t, map[string]int{"archive": 1, "delete": 1}, response.Summary.ActionCounts)
+
+	require.Len(t, gmail.calls, 1)
+	assert.Equal(t, modifyCall{"email-1", nil, []string{"INBOX"}}, gmail.calls[0])
+	assert.Equal(t, []string{"email-2"}, gmail.trashed)
+
+	assert.Equal(t, []string{audit.EventAction, audit.EventAction}, auditEventTypes(t, auditDir))
+}
+
+func TestApplyDryRunDoesNotTouchGmail(t *testing.T) {
+	gmail := &fakeMailClient{}
+	auditDir := t.TempDir()
+	processor := NewProcessor(testActionsConfig(), gmail, testAuditLogger(t, auditDir), testLogger())
+
+	response := processor.Apply(context.Background(), testBatchRequest(true), testResults())
+
+	assert.True(t, response.DryRun)
+	assert.Empty(t, gmail.calls)
+	assert.Equal(t, 2, response.Summary.ProcessedEmails)
+
+	require.Len(t, response.Summary.Actions, 2)
+	for _, action := range response.Summary.Actions {
+		assert.True(t, action.DryRun)
+	}
+	assert.Equal(t, []string{"INBOX"}, response.Summary.Actions[0].RemoveLabels)
+	assert.Equal(t, config.OperationTrash, response.Summary.Actions[1].Operation)
+	assert.Empty(t, gmail.trashed)
+
+	assert.Equal(t, []string{audit.EventActionPlanned, audit.EventActionPlanned}, auditEventTypes(t, auditDir))
+}
+
+func TestApplyDryRunDowngradesBelowMinConfidence(t *testing.T) {
+	gmail := &fakeMailClient{}
+	auditDir := t.TempDir()
+	cfg := testActionsConfig()
+	cfg.MinConfidence = map[string]float64{"delete": 0.95}
+	processor := NewProcessor(cfg, gmail, testAuditLogger(t, auditDir), testLogger())
+
+	response := processor.Apply(context.Background(), testBatchRequest(true), testResults())
+
+	require.Len(t, response.Summary.Actions, 2)
+	downgraded := response.Summary.Actions[1]
+	assert.Equal(t, "review", downgraded.Action)
+	assert.Equal(t, "delete", downgraded.ProposedAction)
+	assert.Empty(t, downgraded.Operation, "the email is not trashed")
+	assert.Equal(t, map[string]int{"archive": 1, "review": 1}, response.Summary.ActionCounts)
+	assert.Empty(t, gmail.calls)
+	assert.Equal(t, []string{audit.EventActionPlanned, audit.EventActionDowngraded, audit.EventActionPlanned}, auditEventTypes(t, auditDir))
+}
+
+func TestApplyDryRunInSafeMode(t *testing.T) {
+	gmail := &fakeMailClient{}
+	auditDir := t.TempDir()
+	cfg := testActionsConfig()
+	cfg.SafeMode = true
+	processor := NewProcessor(cfg, gmail, testAuditLogger(t, auditDir), testLogger())
+
+	response := processor.Apply(context.Background(), testBatchRequest(true), testResults())
+
+	require.Len(t, response.Summary.Actions, 2)
+	intercepted := response.Summary.Actions[1]
+	assert.Equal(t, "delete", intercepted.Action)
+	assert.Empty(t, intercepted.Operation)
+	assert.Equal(t, config.OperationTrash, intercepted.InterceptedOperation)
+	assert.Equal(t, []string{config.DefaultSafeModeLabel}, intercepted.AddLabels)
+	assert.Equal(t, []string{"INBOX"}, intercepted.RemoveLabels)
+	assert.Empty(t, gmail.calls)
+	assert.Equal(t, []string{audit.EventActionPlanned, audit.EventActionIntercepted, audit.EventActionPlanned}, auditEventTypes(t, auditDir))
+}
+
+func TestApplyIgnoresShadowResults(t *testing.T) {
+	gmail := &fakeMailClient{}
+	processor := NewProcessor(testActionsConfig(), gmail, testAuditLogger(t, t.TempDir()), testLogger())
+
+	results := append(testResults(), &types.ClassificationResponse{
+		EmailID:   "email-1",
+		ProfileID: "newsletter_v2",
+		Action:    "delete",
+		Metadata:  map[string]interface{}{types.MetadataShadow: true, types.MetadataShadowOf: "newsletter"},
+	})
+	response := processor.Apply(context.Background(), testBatchRequest(false), results)
+
+	assert.Equal(t, 2, response.Summary.ProcessedEmails)
+	assert.Equal(t, map[string]int{"archive": 1, "delete": 1}, response.Summary.ActionCounts)
+	require.Len(t, gmail.calls, 1)
+	assert.Equal(t, modifyCall{"email-1", nil, []string{"INBOX"}}, gmail.calls[0], "the shadow delete is not applied")
+	assert.Equal(t, []string{"email-2"}, gmail.trashed)
+}
+
+func TestApplyUnmappedActionFails(t *testing.T) {
+	gmail := &fakeMailClient{}
+	processor := NewProcessor(testActionsConfig(), gmail, testAuditLogger(t, t.TempDir()), testLogger())
+
+	results := testResults()
+	results[1].Action = "teleport"
+
+	response := processor.Apply(context.Background(), testBatchRequest(false), results)
+
+	assert.Equal(t, 1, response.Summary.ProcessedEmails)
+	assert.Equal(t, 1, response.Summary.FailedEmails)
+	require.Len(t, response.Summary.Errors, 1)
+	assert.Contains(t, response.Summary.Errors[0], `no label mapping for action "teleport"`)
+	assert.Len(t, gmail.calls, 1)
+}
+
+func TestApplySummaryMixedResults(t *testing.T) {
+	gmail := &fakeMailClient{}
+	processor := NewProcessor(testActionsConfig(), gmail, testAuditLogger(t, t.TempDir()), testLogger())
+
+	req := testBatchRequest(false)
+	req.Emails = append(req.Emails,
+		types.Email{ID: "email-3", Subject: "Unmapped"},
+		types.Email{ID: "email-4", Subject: "Never classified"},
+	)
+	results := append(testResults(),
+		&types.ClassificationResponse{EmailID: "email-3", Action: "teleport", Confidence: 0.1},
+	)
+
+	response := processor.Apply(context.Background(), req, results)
+	summary := response.Summary
+
+	assert.Equal(t, 4, summary.TotalEmails)
+	assert.Equal(t, 2, summary.ProcessedEmails)
+	assert.Equal(t, 2, summary.FailedEmails)
+	assert.InDelta(t, 0.85, summary.AvgConfidence, 1e-9, "average covers successful emails only")
+	assert.Equal(t, map[string]int{"archive": 1, "delete": 1}, summary.ActionCounts)
+	assert.Len(t, response.Results, 2)
+
+	require.Len(t, summary.Failures, 2)
+	assert.Equal(t, "email-3", summary.Failures[0].EmailID)
+	assert.Equal(t, types.StageAction, summary.Failures[0].Stage)
+	assert.Contains(t, summary.Failures[0].Err, "teleport")
+	assert.Equal(t, "email-4", summary.Failures[1].EmailID)
+	assert.Equal(t, types.StageClassify, summary.Failures[1].Stage)
+	assert.Len(t, summary.Errors, 2)
+}
+
+func TestApplyAfterShutdownLeavesGmailUntouched(t *testing.T) {
+	gmail := &fakeMailClient{}
+	auditDir := t.TempDir()
+	auditLogger := testAuditLogger(t, auditDir)
+	coordinator := lifecycle.NewCoordinator(auditLogger, testLogger())
+	processor := NewProcessor(testActionsConfig(), gmail, auditLogger, testLogger())
+	processor.SetLifecycle(coordinator)
+	require.NoError(t, coordinator.Shutdown(context.Background()))
+
+	response := processor.Apply(context.Background(), testBatchRequest(false), testResults())
+
+	assert.Empty(t, gmail.calls)
+	assert.Equal(t, 0, response.Summary.ProcessedEmails)
+	require.Len(t, response.Summary.Failures, 2)
+	for _, failure := range response.Summary.Failures {
+		assert.Equal(t, types.StageAction, failure.Stage)
+		assert.Equal(t, lifecycle.ErrShuttingDown.Error(), failure.Err)
+	}
+	assert.Equal(t, []string{audit.EventSystemStop}, auditEventTypes(t, auditDir))
+}
+
+func TestApplyResumesFromCursorAfterCrash(t *testing.T) {
+	cursorPath := filepath.Join(t.TempDir(), "run.cursor")
+	auditDir := t.TempDir()
+	auditLogger := testAuditLogger(t, auditDir)
+	ctx := logging.WithCorrelationID(context.Background(), "batch-1")
+
+	// The first run crashes while trashing email-2, after archiving email-1
+	progress, err := cursor.Open(cursorPath, testLogger())
+	require.NoError(t, err)
+	crashing := &crashingMailClient{crashOn: "email-2"}
+	processor := NewProcessor(testActionsConfig(), crashing, auditLogger, testLogger())
+	processor.SetCursor(progress)
+	assert.Panics(t, func() {
+		processor.Apply(ctx, testBatchRequest(false), testResults())
+	})
+	assert.Equal(t, []string{"email-1"}, crashing.modified())
+	require.NoError(t, progress.Close())
+
+	// The restarted run skips email-1 and acts on the interrupted email-2
+	resumed, err := cursor.Open(cursorPath, testLogger())
+	require.NoError(t, err)
+	assert.True(t, resumed.Completed("batch-1", "email-1"))
+	assert.True(t, resumed.Interrupted("batch-1", "email-2"))
+
+	gmail := &fakeMailClient{}
+	processor = NewProcessor(testActionsConfig(), gmail, auditLogger, testLogger())
+	processor.SetCursor(resumed)
+	response := processor.Apply(ctx, testBatchRequest(false), testResults())
+
+	assert.Empty(t, gmail.calls, "the completed email is not acted on again")
+	assert.Equal(t, []string{"email-2"}, gmail.trashed)
+	assert.Equal(t, 1, response.Summary.ProcessedEmails)
+	assert.Equal(t, 1, response.Summary.SkippedEmails)
+	assert.Equal(t, 0, response.Summary.FailedEmails)
+	assert.True(t, resumed.Completed("batch-1", "email-2"))
+	assert.False(t, resumed.Completed("batch-2", "email-2"), "progress is kept per batch")
+	assert.Equal(t, []string{audit.EventAction, audit.EventAction}, auditEventTypes(t, auditDir))
+
+	// Once finished, the batch starts afresh
+	require.NoError(t, processor.FinishBatch("batch-1"))
+	require.NoError(t, resumed.Close())
+	fresh, err := cursor.Open(cursorPath, testLogger())
+	require.NoError(t, err)
+	defer fresh.Close()
+	assert.False(t, fresh.Completed("batch-1", "email-1"))
+}
+
+func TestApplyDryRunIgnoresCursor(t *testing.T) {
+	progress, err := cursor.Open(filepath.Join(t.TempDir(), "run.cursor"), testLogger())
+	require.NoError(t, err)
+	defer progress.Close()
+	require.NoError(t, progress.Complete("", "email-1"))
+
+	processor := NewProcessor(testActionsConfig(), &fakeMailClient{}, testAuditLogger(t, t.TempDir()), testLogger())
+	processor.SetCursor(progress)
+	response := processor.Apply(context.Background(), testBatchRequest(true), testResults())
+
+	assert.Equal(t, 2, response.Summary.ProcessedEmails)
+	assert.False(t, progress.Completed("", "email-2"), "dry runs record no progress")
+}
+
+func TestApplyDecisionsEndToEnd(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "resolver.yaml"), []byte(`
+confidence_weighting:
+  method: "weighted_average"
+`), 0644))
+	policyResolver, err := resolver.NewPolicyResolver(filepath.Join(dir, "resolver.yaml"), testLogger())
+	require.NoError(t, err)
+
+	req := testBatchRequest(false)
+	newsletter := &types.ClassificationResponse{EmailID: "email-1", ProfileID: "newsletter", Action: "archive", Confidence: 0.9, Fingerprint: &types.Fingerprint{ID: "fp-newsletter"}}
+	spam := &types.ClassificationResponse{EmailID: "email-1", ProfileID: "spam", Action: "delete", Confidence: 0.6, Fingerprint: &types.Fingerprint{ID: "fp-spam"}}
+	decided, err := policyResolver.Decide(&req.Emails[0], []*types.ClassificationResponse{newsletter, spam})
+	require.NoError(t, err)
+	unmapped := &types.ClassificationResponse{EmailID: "email-2", ProfileID: "spam", Action: "teleport", Confidence: 0.9}
+	decisions := []*types.Decision{
+		decided,
+		types.NewDecision("email-2", []*types.ClassificationResponse{unmapped}, unmapped),
+	}
+
+	gmail := &fakeMailClient{}
+	auditDir := t.TempDir()
+	auditLogger := testAuditLogger(t, auditDir)
+	processor := NewProcessor(testActionsConfig(), gmail, auditLogger, testLogger())
+	response := processor.ApplyDecisions(context.Background(), req, decisions)
+
+	assert.Equal(t, 1, response.Summary.ProcessedEmails)
+	assert.Equal(t, 1, response.Summary.FailedEmails)
+	assert.Equal(t, []modifyCall{{"email-1", nil, []string{"INBOX"}}}, gmail.calls)
+
+	decision := decisions[0]
+	require.NotNil(t, decision.Action)
+	assert.Equal(t, "archive", decision.Action.Action)
+	assert.Equal(t, []string{"INBOX"}, decision.Action.RemoveLabels)
+	assert.NotNil(t, decision.ActedAt)
+	assert.Equal(t, []*types.Fingerprint{{ID: "fp-newsletter"}, {ID: "fp-spam"}}, decision.Fingerprints)
+	explanation, ok := decision.Explanation.(*resolver.Explanation)
+	require.True(t, ok, "the decision carries the resolver's explanation")
+	assert.Equal(t, resolver.MethodWeightedAverage, explanation.Method)
+	assert.Equal(t, "archive", explanation.Action)
+	assert.Nil(t, decisions[1].Action, "a failed action is not recorded")
+
+	assert.Equal(t, []string{audit.EventAction, audit.EventDecision}, auditEventTypes(t, auditDir), "only the decision acted on is audited")
+	entries, err := auditLogger.Query(audit.Query{EventTypes: []string{audit.EventDecision}})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "email-1", entries[0].EmailID)
+	assert.Equal(t, "archive", entries[0].Action)
+	assert.Equal(t, true, entries[0].Metadata["acted"])
+
+	var audited types.Decision
+	data, err := json.Marshal(entries[0].Metadata[audit.MetadataDecision])
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &audited))
+	assert.Len(t, audited.Classifications, 2)
+	assert.Equal(t, "archive", audited.Resolution.Action)
+	assert.Equal(t, "archive", audited.FinalAction())
+	assert.Len(t, audited.Fingerprints, 2)
+	require.NoError(t, auditLogger.VerifyChain())
+}
+
+func TestApplyDecisionsDryRun(t *testing.T) {
+	auditDir := t.TempDir()
+	gmail := &fakeMailClient{}
+	processor := NewProcessor(testActionsConfig(), gmail, testAuditLogger(t, auditDir), testLogger())
+
+	var decisions []*types.Decision
+	for _, result := range testResults() {
+		decisions = append(decisions, types.NewDecision(result.EmailID, []*types.ClassificationResponse{result}, result))
+	}
+	processor.ApplyDecisions(context.Background(), testBatchRequest(true), decisions)
+
+	assert.Empty(t, gmail.calls)
+	for _, decision := range decisions {
+		require.NotNil(t, decision.Action, "the intended action is recorded")
+		assert.True(t, decision.Action.DryRun)
+	}
+	assert.Equal(t, []string{audit.EventActionPlanned, audit.EventDecision, audit.EventActionPlanned, audit.EventDecision}, auditEventTypes(t, auditDir), "each decision is audited with its action")
+}
+
+// Helper functions
+
+// crashingMailClient panics, as a process dying mid-action would stop,
+// when acting on crashOn
+type crashingMailClient struct {
+	fakeMailClient
+	crashOn string
+}
+
+func (c *crashingMailClient) ModifyLabels(ctx context.Context, messageID string, addLabels, removeLabels []string) error {
+	if messageID == c.crashOn {
+		panic("crash")
+	}
+	return c.fakeMailClient.ModifyLabels(ctx, messageID, addLabels, removeLabels)
+}
+
+func (c *crashingMailClient) TrashMessage(ctx context.Context, messageID string) error {
+	if messageID == c.crashOn {
+		panic("crash")
+	}
+	return c.fakeMailClient.TrashMessage(ctx, messageID)
+}
+
+func (c *crashingMailClient) modified() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var ids []string
+	for _, call := range c.calls {
+		ids = append(ids, call.messageID)
+	}
+	return append(ids, c.trashed...)
+}
+
+type modifyCall struct {
+	messageID string
+	add       []string
+	remove    []string
+}
+
+// fakeMailClient records the changes made to emails and, with an audit
+// logger, audits label changes as the Gmail and IMAP clients do
+type fakeMailClient struct {
+	mutex   sync.Mutex
+	calls   []modifyCall
+	labels  []*gmail.Label
+	created []string
+	trashed []string
+	deleted []string
+	audit   *audit.Logger
+}
+
+func (f *fakeMailClient) ModifyLabels(ctx context.Context, messageID string, addLabels, removeLabels []string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls = append(f.calls, modifyCall{messageID, addLabels, removeLabels})
+	if f.audit == nil {
+		return nil
+	}
+	email := &types.Email{ID: messageID}
+	for _, label := range addLabels {
+		if err := f.audit.LogAction(ctx, email, "modify_labels", "+"+label); err != nil {
+			return err
+		}
+	}
+	for _, label := range removeLabels {
+		if err := f.audit.LogAction(ctx, email, "modify_labels", "-"+label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeMailClient) LabelIDForName(ctx context.Context, name string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, label := range f.labels {
+		if strings.EqualFold(label.Name, name) {
+			return label.Id, nil
+		}
+	}
+	label := &gmail.Label{Id: fmt.Sprintf("Label_%d", len(f.labels)+1), Name: name}
+	f.labels = append(f.labels, label)
+	f.created = append(f.created, name)
+	return label.Id, nil
+}
+
+func (f *fakeMailClient) LabelNameForID(ctx context.Context, id string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, label := range f.labels {
+		if label.Id == id {
+			return label.Name, nil
+		}
+	}
+	return id, nil
+}
+
+func (f *fakeMailClient) TrashMessage(ctx context.Context, messageID string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.trashed = append(f.trashed, messageID)
+	return nil
+}
+
+func (f *fakeMailClient) DeleteMessage(ctx context.Context, messageID string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.deleted = append(f.deleted, messageID)
+	return nil
+}
+
+func testActionsConfig() *config.ActionsConfig {
+	return &config.DefaultConfig().Actions
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
+
+func testAuditLogger(t *testing.T, dir string) *audit.Logger {
+	logger, err := audit.NewLogger(&config.AuditConfig{
+		Enabled:   true,
+		Directory: dir,
+	}, testLogger())
+	require.NoError(t, err)
+	return logger
+}
+
+// auditEventTypes returns the event types written to the audit log, excluding
+// the chain genesis entry
+func auditEventTypes(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "audit_*.log"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	file, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer file.Close()
+
+	var eventTypes []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry audit.AuditEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		if entry.EventType != "chain_genesis" {
+			eventTypes = append(eventTypes, entry.EventType)
+		}
+	}
+	require.NoError(t, scanner.Err())
+	return eventTypes
+}
+
+func testBatchRequest(dryRun bool) *types.BatchRequest {
+	return &types.BatchRequest{
+		Emails: []types.Email{
+			{ID: "email-1", Subject: "Weekly newsletter", From: "news@example.com"},
+			{ID: "email-2", Subject: "You won a prize", From: "prize@spam.example"},
+		},
+		DryRun: dryRun,
+	}
+}
+
+func testResults() []*types.ClassificationResponse {
+	return []*types.ClassificationResponse{
+		{EmailID: "email-1", ProfileID: "newsletter", Action: "archive", Confidence: 0.8, ProcessedAt: time.Now()},
+		{EmailID: "email-2", ProfileID: "spam", Action: "delete", Confidence: 0.9, ProcessedAt: time.Now()},
+	}
+}
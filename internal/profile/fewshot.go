@@ -0,0 +1,73 @@
+package profile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// fileStamp identifies the version of a file a cached profile was built from
+type fileStamp struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// statFile records the current modification time and size of path
+func statFile(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{path: path, modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// unchanged reports whether the file still matches the stamp
+func (s fileStamp) unchanged() bool {
+	current, err := statFile(s.path)
+	return err == nil && current.modTime.Equal(s.modTime) && current.size == s.size
+}
+
+// inlineFewShotFiles replaces every few-shot input_file and output_file
+// reference with the referenced file's contents, read relative to dir, and
+// returns the paths read. An example may set input or input_file (and output
+// or output_file) but not both. References must stay inside dir; use a
+// non-YAML extension so the files are not mistaken for profiles.
+func inlineFewShotFiles(profile *types.Profile, dir string) ([]string, error) {
+	var files []string
+	inline := func(i int, field, reference string, value *string) error {
+		if reference == "" {
+			return nil
+		}
+		if *value != "" {
+			return fmt.Errorf("fewshot[%d] sets both %s and %s_file", i, field, field)
+		}
+		if !filepath.IsLocal(reference) {
+			return fmt.Errorf("fewshot[%d].%s_file %q must be a relative path inside the profile directory", i, field, reference)
+		}
+
+		path := filepath.Join(dir, reference)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("fewshot[%d].%s_file: %w", i, field, err)
+		}
+		*value = strings.TrimRight(string(data), "\r\n")
+		files = append(files, path)
+		return nil
+	}
+
+	for i := range profile.FewShot {
+		example := &profile.FewShot[i]
+		if err := inline(i, "input", example.InputFile, &example.Input); err != nil {
+			return nil, err
+		}
+		if err := inline(i, "output", example.OutputFile, &example.Output); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,146 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadProfileFileInlinesFewShotFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tempDir, "examples"), 0755))
+	writeLintFixture(t, tempDir, "examples/phish.json", `{"subject": "Verify your account"}`+"\n")
+	writeLintFixture(t, tempDir, "examples/phish.out.json", `{"action": "delete", "confidence": 0.95}`+"\n")
+	path := writeFewShotProfile(t, tempDir, `
+  - name: "from_files"
+    input_file: "examples/phish.json"
+    output_file: "examples/phish.out.json"
+  - name: "inline"
+    input: "inline input"
+    output: "inline output"
+`)
+
+	profile, err := NewLoader(tempDir, fewShotLogger()).loadProfileFile(path)
+	require.NoError(t, err)
+	require.Len(t, profile.FewShot, 2)
+	assert.Equal(t, `{"subject": "Verify your account"}`, profile.FewShot[0].Input)
+	assert.Equal(t, `{"action": "delete", "confidence": 0.95}`, profile.FewShot[0].Output)
+	assert.Equal(t, "inline input", profile.FewShot[1].Input)
+	assert.Equal(t, "inline output", profile.FewShot[1].Output)
+}
+
+func TestLoadProfileFileFewShotErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		examples string
+		errMsg   string
+	}{
+		{
+			name: "missing_file",
+			examples: `
+  - name: "missing"
+    input_file: "examples/missing.json"
+    output: "{}"
+`,
+			errMsg: "fewshot[0].input_file",
+		},
+		{
+			name: "inline_and_file",
+			examples: `
+  - name: "both"
+    input: "inline"
+    input_file: "examples/input.json"
+    output: "{}"
+`,
+			errMsg: "sets both input and input_file",
+		},
+		{
+			name: "outside_profile_directory",
+			examples: `
+  - name: "escape"
+    input: "{}"
+    output_file: "../secret.txt"
+`,
+			errMsg: "must be a relative path inside the profile directory",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			path := writeFewShotProfile(t, tempDir, tt.examples)
+
+			_, err := NewLoader(tempDir, fewShotLogger()).loadProfileFile(path)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+
+			issues, err := lintTestLoader(tempDir).Lint()
+			require.NoError(t, err)
+			require.Len(t, issues, 1)
+			assert.Equal(t, "fewshot", issues[0].Field)
+			assert.Contains(t, issues[0].Message, tt.errMsg)
+		})
+	}
+}
+
+func TestLoadAllReparsesChangedFewShotFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	examplePath := writeLintFixture(t, tempDir, "example.txt", "first version")
+	writeFewShotProfile(t, tempDir, `
+  - name: "from_file"
+    input_file: "example.txt"
+    output: "{}"
+`)
+
+	loader := NewLoader(tempDir, fewShotLogger())
+	loader.SetCacheEnabled(true)
+	require.NoError(t, loader.LoadAll())
+	assert.Equal(t, "first version", fewShotInput(t, loader))
+
+	// Reloading with nothing changed reuses the cached profile
+	require.NoError(t, loader.Reload())
+	assert.Equal(t, "first version", fewShotInput(t, loader))
+
+	// Changing only the example file invalidates the cached profile
+	require.NoError(t, os.WriteFile(examplePath, []byte("second version"), 0644))
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(examplePath, future, future))
+	require.NoError(t, loader.Reload())
+	assert.Equal(t, "second version", fewShotInput(t, loader))
+}
+
+// Helper functions
+
+func fewShotLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
+
+// writeFewShotProfile writes a valid profile whose fewshot list is examples
+func writeFewShotProfile(t *testing.T, dir, examples string) string {
+	return writeLintFixture(t, dir, "fewshot.yaml", `
+id: "fewshot"
+version: "1.0.0"
+model: "qwen2.5:7b"
+system: "Prompt"
+model_params:
+  max_tokens: 100
+  timeout_seconds: 30
+response:
+  validation:
+    confidence_range: [0.0, 1.0]
+fewshot:`+examples)
+}
+
+func fewShotInput(t *testing.T, loader *Loader) string {
+	profile, err := loader.GetProfile("fewshot")
+	require.NoError(t, err)
+	require.Len(t, profile.FewShot, 1)
+	return profile.FewShot[0].Input
+}
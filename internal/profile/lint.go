@@ -0,0 +1,311 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mailsentinel/core/internal/expr"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Issue is a single problem found while linting profiles
+type Issue struct {
+	File      string `json:"file"`
+	ProfileID string `json:"profile_id,omitempty"`
+	Field     string `json:"field,omitempty"`
+	Message   string `json:"message"`
+}
+
+// String formats the issue as "file: [profile] field: message"
+func (i Issue) String() string {
+	var b strings.Builder
+	if i.File != "" {
+		b.WriteString(i.File)
+		b.WriteString(": ")
+	}
+	if i.ProfileID != "" {
+		fmt.Fprintf(&b, "[%s] ", i.ProfileID)
+	}
+	if i.Field != "" {
+		b.WriteString(i.Field)
+		b.WriteString(": ")
+	}
+	b.WriteString(i.Message)
+	return b.String()
+}
+
+// Lint loads every profile file in the directory and reports all problems
+// found: schema and field validation, expression syntax, and inheritance or
+// dependency errors. Unlike LoadAll it does not stop at the first problem and
+// leaves the loaded registry untouched. Files listed in exclude, such as the
+// resolver configuration, are skipped.
+func (l *Loader) Lint(exclude ...string) ([]Issue, error) {
+	return l.LintWithRules(nil, exclude...)
+}
+
+// LintWithRules is like Lint, but also reports resolver priority rules whose
+// action is not in the allowed_actions of a profile the rule targets
+func (l *Loader) LintWithRules(rules []types.PriorityRule, exclude ...string) ([]Issue, error) {
+	directory, err := l.source.Sync(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sync profile source: %w", err)
+	}
+
+	files, err := findProfileFiles(directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find profile files: %w", err)
+	}
+
+	skip := make(map[string]bool, len(exclude))
+	for _, file := range exclude {
+		skip[filepath.Clean(file)] = true
+	}
+
+	var issues []Issue
+	profiles := make(map[string]*types.Profile)
+	fileByID := make(map[string]string)
+	for _, file := range files {
+		if skip[filepath.Clean(file)] {
+			continue
+		}
+
+		profile, fileIssues := lintProfileFile(file)
+		issues = append(issues, fileIssues...)
+		if profile == nil || profile.ID == "" {
+			continue
+		}
+
+		if existing, exists := fileByID[profile.ID]; exists {
+			issues = append(issues, Issue{
+				File:      file,
+				ProfileID: profile.ID,
+				Field:     "id",
+				Message:   duplicateIDError(profile.ID, existing, file).Error(),
+			})
+			continue
+		}
+
+		profiles[profile.ID] = profile
+		fileByID[profile.ID] = file
+	}
+
+	issues = append(issues, lintDependencies(profiles, fileByID)...)
+	issues = append(issues, ruleActionIssues(rules, profiles, fileByID)...)
+
+	if len(issues) == 0 {
+		registry := newRegistry()
+		if err := l.buildDependencyGraph(registry, profiles); err != nil {
+			issues = append(issues, Issue{Message: err.Error()})
+		} else if err := l.resolveInheritance(profiles, registry.LoadOrder); err != nil {
+			issues = append(issues, Issue{Message: err.Error()})
+		}
+	}
+
+	return issues, nil
+}
+
+// lintProfileFile parses and checks a single profile file
+func lintProfileFile(file string) (*types.Profile, []Issue) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, []Issue{{File: file, Message: fmt.Sprintf("failed to read file: %v", err)}}
+	}
+
+	var profile types.Profile
+	if err := yaml.Unmarshal(data, &profile); err != nil {
+		return nil, []Issue{{File: file, Message: fmt.Sprintf("failed to parse YAML: %v", err)}}
+	}
+
+	issues := validationIssues(&profile)
+	issues = append(issues, expressionIssues(&profile)...)
+
+	if _, err := inlineFewShotFiles(&profile, filepath.Dir(file)); err != nil {
+		issues = append(issues, Issue{ProfileID: profile.ID, Field: "fewshot", Message: err.Error()})
+	}
+
+	if schema := strings.TrimSpace(profile.Response.Schema); schema != "" && !json.Valid([]byte(schema)) {
+		issues = append(issues, Issue{
+			ProfileID: profile.ID,
+			Field:     "response.schema",
+			Message:   "schema is not valid JSON",
+		})
+	}
+
+	for i := range issues {
+		issues[i].File = file
+	}
+	return &profile, issues
+}
+
+// expressionIssues compiles every expression in a profile
+func expressionIssues(profile *types.Profile) []Issue {
+	var issues []Issue
+	check := func(field, source string) {
+		if _, err := expr.Compile(source); err != nil {
+			issues = append(issues, Issue{ProfileID: profile.ID, Field: field, Message: err.Error()})
+		}
+	}
+
+	for i, condition := range profile.Policy.Conditions {
+		check(fmt.Sprintf("policy.conditions[%d].expression", i), condition.Expression)
+	}
+
+	if profile.ConditionalExecution != nil {
+		check("conditional_execution.when", profile.ConditionalExecution.When)
+	}
+
+	return issues
+}
+
+// ruleActionIssues reports priority rules that would apply an action a
+// targeted profile does not allow. A rule targets a profile when its
+// condition mentions the profile ID, for example
+// any(profile.profile_id == 'spam' && ...). Rules that fail to compile are
+// reported by the resolver lint and skipped here.
+func ruleActionIssues(rules []types.PriorityRule, profiles map[string]*types.Profile, files map[string]string) []Issue {
+	ids := make([]string, 0, len(profiles))
+	for id := range profiles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var issues []Issue
+	for _, rule := range rules {
+		if rule.Action == "" {
+			continue
+		}
+		program, err := expr.Compile(rule.Condition)
+		if err != nil {
+			continue
+		}
+
+		for _, id := range ids {
+			allowed := profiles[id].Response.Validation.AllowedActions
+			if len(allowed) == 0 || containsString(allowed, rule.Action) || !program.Mentions(id) {
+				continue
+			}
+			issues = append(issues, Issue{
+				File:      files[id],
+				ProfileID: id,
+				Field:     "response.validation.allowed_actions",
+				Message:   fmt.Sprintf("priority rule %q targets this profile with action %q not in allowed_actions", rule.Name, rule.Action),
+			})
+		}
+	}
+	return issues
+}
+
+// lintDependencies reports references to profiles that do not exist and
+// dependency cycles
+func lintDependencies(profiles map[string]*types.Profile, files map[string]string) []Issue {
+	ids := make([]string, 0, len(profiles))
+	for id := range profiles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var issues []Issue
+	for _, id := range ids {
+		profile := profiles[id]
+		if profile.InheritsFrom != "" {
+			if _, exists := profiles[profile.InheritsFrom]; !exists {
+				issues = append(issues, Issue{
+					File:      files[id],
+					ProfileID: id,
+					Field:     "inherits_from",
+					Message:   fmt.Sprintf("parent profile %s not found", profile.InheritsFrom),
+				})
+			}
+		}
+		for i, dep := range profile.DependsOn {
+			if _, exists := profiles[dep]; !exists {
+				issues = append(issues, Issue{
+					File:      files[id],
+					ProfileID: id,
+					Field:     fmt.Sprintf("depends_on[%d]", i),
+					Message:   fmt.Sprintf("dependency %s not found", dep),
+				})
+			}
+		}
+		if problem := shadowProblem(profile, profiles); problem != "" {
+			issues = append(issues, Issue{
+				File:      files[id],
+				ProfileID: id,
+				Field:     "shadow_of",
+				Message:   problem,
+			})
+		}
+	}
+
+	reported := make(map[string]bool)
+	for _, id := range ids {
+		cycle := findCycle(id, profiles)
+		if cycle == nil {
+			continue
+		}
+		members := append([]string(nil), cycle[:len(cycle)-1]...)
+		sort.Strings(members)
+		key := strings.Join(members, ",")
+		if reported[key] {
+			continue
+		}
+		reported[key] = true
+		issues = append(issues, Issue{
+			File:      files[id],
+			ProfileID: id,
+			Message:   fmt.Sprintf("circular dependency: %s", strings.Join(cycle, " -> ")),
+		})
+	}
+
+	return issues
+}
+
+// findCycle returns the dependency path leading from start back to itself,
+// or nil if start is not part of a cycle
+func findCycle(start string, profiles map[string]*types.Profile) []string {
+	visited := make(map[string]bool)
+	var path []string
+
+	var visit func(id string) bool
+	visit = func(id string) bool {
+		path = append(path, id)
+		profile, exists := profiles[id]
+		if exists {
+			for _, dep := range profileDependencies(profile) {
+				if dep == start {
+					path = append(path, dep)
+					return true
+				}
+				if !visited[dep] {
+					visited[dep] = true
+					if visit(dep) {
+						return true
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+
+	if visit(start) {
+		return path
+	}
+	return nil
+}
+
+// profileDependencies returns the parent and explicit dependencies of a profile
+func profileDependencies(profile *types.Profile) []string {
+	var deps []string
+	if profile.InheritsFrom != "" {
+		deps = append(deps, profile.InheritsFrom)
+	}
+	return append(deps, profile.DependsOn...)
+}
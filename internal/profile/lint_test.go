@@ -0,0 +1,166 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestLintValidProfiles(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestProfile(t, tempDir, "alpha")
+	writeTestProfile(t, tempDir, "beta")
+
+	issues, err := lintTestLoader(tempDir).Lint()
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestLintReportsEveryProblem(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestProfile(t, tempDir, "base")
+	brokenPath := writeLintFixture(t, tempDir, "broken.yaml", `
+id: "broken"
+version: "1.0.0"
+inherits_from: "missing_parent"
+model: ""
+system: "Broken prompt"
+model_params:
+  temperature: 5
+  max_tokens: 100
+  timeout_seconds: 30
+conditional_execution:
+  when: 'spam_detection.category != '
+response:
+  schema: '{"action": '
+  validation:
+    confidence_range: [0.0, 1.0]
+policy:
+  conditions:
+    - name: "ok"
+      expression: 'confidence >= 0.8'
+    - name: "bad"
+      expression: 'confidence >= 0.8 &&'
+`)
+
+	issues, err := lintTestLoader(tempDir).Lint()
+	require.NoError(t, err)
+
+	fields := make(map[string]Issue)
+	for _, issue := range issues {
+		assert.Equal(t, brokenPath, issue.File)
+		assert.Equal(t, "broken", issue.ProfileID)
+		fields[issue.Field] = issue
+	}
+
+	assert.Len(t, issues, 6)
+	assert.Contains(t, fields, "model")
+	assert.Contains(t, fields, "model_params.temperature")
+	assert.Contains(t, fields, "conditional_execution.when")
+	assert.Contains(t, fields, "response.schema")
+	assert.Contains(t, fields, "policy.conditions[1].expression")
+	assert.Contains(t, fields["inherits_from"].Message, "missing_parent")
+}
+
+func TestLintDetectsCycles(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, fixture := range []struct{ id, parent string }{{"a", "b"}, {"b", "a"}} {
+		writeLintFixture(t, tempDir, fixture.id+".yaml", `
+id: "`+fixture.id+`"
+version: "1.0.0"
+inherits_from: "`+fixture.parent+`"
+model: "qwen2.5:7b"
+system: "Prompt"
+model_params:
+  max_tokens: 100
+  timeout_seconds: 30
+response:
+  validation:
+    confidence_range: [0.0, 1.0]
+`)
+	}
+
+	issues, err := lintTestLoader(tempDir).Lint()
+	require.NoError(t, err)
+	require.Len(t, issues, 1, "a cycle is reported once")
+	assert.Contains(t, issues[0].Message, "circular dependency: a -> b -> a")
+}
+
+func TestLintSkipsExcludedFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestProfile(t, tempDir, "alpha")
+	resolverPath := writeLintFixture(t, tempDir, "resolver.yaml", `
+version: "1.0.0"
+priority_rules: []
+`)
+
+	loader := lintTestLoader(tempDir)
+
+	issues, err := loader.Lint()
+	require.NoError(t, err)
+	require.NotEmpty(t, issues)
+	assert.Equal(t, resolverPath, issues[0].File)
+	assert.Equal(t, "profile ID is required", issues[0].Message)
+
+	issues, err = loader.Lint(resolverPath)
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestLintRuleActionsAgainstAllowedActions(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestProfile(t, tempDir, "alpha")
+	spamPath := writeLintFixture(t, tempDir, "spam.yaml", `
+id: "spam"
+version: "1.0.0"
+model: "qwen2.5:7b"
+system: "Prompt"
+model_params:
+  max_tokens: 100
+  timeout_seconds: 30
+response:
+  validation:
+    confidence_range: [0.0, 1.0]
+    allowed_actions: ["archive", "none"]
+`)
+
+	rules := []types.PriorityRule{
+		{Name: "spam_delete", Condition: "any(profile.profile_id == 'spam' && profile.confidence >= 0.9)", Action: "delete"},
+		{Name: "spam_archive", Condition: "any(profile.profile_id == 'spam')", Action: "archive"},
+		{Name: "alpha_delete", Condition: "any(profile.profile_id == 'alpha')", Action: "delete"},
+		{Name: "spam_boost", Condition: "any(profile.profile_id == 'spam')", ConfidenceBoost: 0.1},
+	}
+
+	issues, err := lintTestLoader(tempDir).LintWithRules(rules)
+	require.NoError(t, err)
+	require.Len(t, issues, 1, "only rules targeting a profile with allowed_actions are checked")
+	assert.Equal(t, spamPath, issues[0].File)
+	assert.Equal(t, "spam", issues[0].ProfileID)
+	assert.Contains(t, issues[0].Message, `priority rule "spam_delete"`)
+	assert.Contains(t, issues[0].Message, `"delete"`)
+}
+
+func TestIssueString(t *testing.T) {
+	issue := Issue{File: "profiles/spam.yaml", ProfileID: "spam", Field: "model", Message: "profile model is required"}
+	assert.Equal(t, "profiles/spam.yaml: [spam] model: profile model is required", issue.String())
+}
+
+// Helper functions
+
+func lintTestLoader(dir string) *Loader {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return NewLoader(dir, logger)
+}
+
+func writeLintFixture(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
@@ -1,95 +1,245 @@
 package profile
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/yaml.v3"
 	"github.com/sirupsen/logrus"
 
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/internal/expr"
+	"github.com/mailsentinel/core/internal/llm"
+	"github.com/mailsentinel/core/pkg/config"
 	"github.com/mailsentinel/core/pkg/types"
 )
 
-// Loader handles loading and managing email classification profiles
+// Loader handles loading and managing email classification profiles.
+// It is safe for concurrent use: reads are served from an immutable registry
+// snapshot which LoadAll replaces atomically.
 type Loader struct {
-	directory string
-	registry  *types.ProfileRegistry
-	logger    *logrus.Logger
-	cache     map[string]*types.Profile
+	directory    string
+	source       Source
+	registry     *types.ProfileRegistry
+	logger       *logrus.Logger
+	cache        map[string]*cacheEntry
+	cacheEnabled bool
+	clock        clock.Clock
+	mutex        sync.RWMutex
+	loadMutex    sync.Mutex
+	
+	// promptTemplate is the prompt template of profiles that neither set
+	// nor inherit one
+	promptTemplate string
+}
+
+// cacheEntry holds a parsed profile file, before inheritance is applied,
+// together with the file state it was parsed from
+type cacheEntry struct {
+	profile  *types.Profile
+	modTime  time.Time
+	size     int64
+	includes []fileStamp
+}
+
+// includesUnchanged reports whether every file inlined into the cached
+// profile is unchanged
+func (e *cacheEntry) includesUnchanged() bool {
+	for _, include := range e.includes {
+		if !include.unchanged() {
+			return false
+		}
+	}
+	return true
 }
 
 // NewLoader creates a new profile loader
 func NewLoader(directory string, logger *logrus.Logger) *Loader {
 	return &Loader{
 		directory: directory,
-		registry: &types.ProfileRegistry{
-			Profiles:     make(map[string]*types.Profile),
-			Dependencies: make(map[string][]string),
-			LoadOrder:    make([]string, 0),
-		},
-		logger: logger,
-		cache:  make(map[string]*types.Profile),
+		source:    DirectorySource(directory),
+		registry:  newRegistry(),
+		logger:    logger,
+		cache:     make(map[string]*cacheEntry),
+		clock:     clock.Real{},
+	}
+}
+
+// NewLoaderFromConfig creates a profile loader from the profiles configuration
+func NewLoaderFromConfig(cfg *config.ProfilesConfig, logger *logrus.Logger) (*Loader, error) {
+	source, err := NewSource(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	
+	if cfg.PromptTemplate != "" {
+		if _, err := llm.ParsePromptTemplate(cfg.PromptTemplate); err != nil {
+			return nil, fmt.Errorf("profiles.prompt_template: %w", err)
+		}
+	}
+	
+	loader := NewLoader(cfg.Directory, logger)
+	loader.source = source
+	loader.cacheEnabled = cfg.CacheEnabled
+	loader.promptTemplate = cfg.PromptTemplate
+	return loader, nil
+}
+
+// SetCacheEnabled enables or disables reuse of unchanged profile files across loads
+func (l *Loader) SetCacheEnabled(enabled bool) {
+	l.loadMutex.Lock()
+	defer l.loadMutex.Unlock()
+	l.cacheEnabled = enabled
+}
+
+// SetClock sets the clock the loaded profiles' timestamps are read from
+func (l *Loader) SetClock(clk clock.Clock) {
+	l.loadMutex.Lock()
+	defer l.loadMutex.Unlock()
+	l.clock = clk
+}
+
+// newRegistry creates an empty profile registry
+func newRegistry() *types.ProfileRegistry {
+	return &types.ProfileRegistry{
+		Profiles:     make(map[string]*types.Profile),
+		Dependencies: make(map[string][]string),
+		LoadOrder:    make([]string, 0),
 	}
 }
 
-// LoadAll loads all profiles from the directory and resolves dependencies
+// LoadAll loads all profiles from the directory and resolves dependencies.
+// The new registry is built off to the side and swapped in only once it is
+// complete, so concurrent readers never observe a partially loaded state.
 func (l *Loader) LoadAll() error {
-	l.logger.WithField("directory", l.directory).Info("Loading all profiles")
+	l.loadMutex.Lock()
+	defer l.loadMutex.Unlock()
+	
+	directory, err := l.source.Sync(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to sync profile source: %w", err)
+	}
 	
-	// Clear existing data
-	l.registry.Profiles = make(map[string]*types.Profile)
-	l.registry.Dependencies = make(map[string][]string)
-	l.registry.LoadOrder = make([]string, 0)
-	l.cache = make(map[string]*types.Profile)
+	l.logger.WithField("directory", directory).Info("Loading all profiles")
+	
+	registry := newRegistry()
+	cache := make(map[string]*cacheEntry)
 	
 	// Find all YAML files
-	files, err := l.findProfileFiles()
+	files, err := findProfileFiles(directory)
 	if err != nil {
 		return fmt.Errorf("failed to find profile files: %w", err)
 	}
 	
 	// Load profiles without inheritance first
 	profiles := make(map[string]*types.Profile)
+	sources := make(map[string]string)
+	var duplicates []error
 	for _, file := range files {
-		profile, err := l.loadProfileFile(file)
+		profile, err := l.loadProfileFileCached(file, cache)
 		if err != nil {
 			l.logger.WithError(err).WithField("file", file).Error("Failed to load profile")
 			continue
 		}
+		if existing, exists := sources[profile.ID]; exists {
+			duplicates = append(duplicates, duplicateIDError(profile.ID, existing, file))
+			continue
+		}
 		profiles[profile.ID] = profile
+		sources[profile.ID] = file
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("failed to load profiles: %w", errors.Join(duplicates...))
 	}
 	
 	// Build dependency graph
-	if err := l.buildDependencyGraph(profiles); err != nil {
+	if err := l.buildDependencyGraph(registry, profiles); err != nil {
 		return fmt.Errorf("failed to build dependency graph: %w", err)
 	}
 	
 	// Resolve inheritance and dependencies
-	if err := l.resolveInheritance(profiles); err != nil {
+	if err := l.resolveInheritance(profiles, registry.LoadOrder); err != nil {
 		return fmt.Errorf("failed to resolve inheritance: %w", err)
 	}
+	if l.promptTemplate != "" {
+		for _, profile := range profiles {
+			if profile.PromptTemplate == "" {
+				profile.PromptTemplate = l.promptTemplate
+			}
+		}
+	}
+	if err := validateShadows(profiles); err != nil {
+		return fmt.Errorf("failed to load profiles: %w", err)
+	}
 	
-	// Store in registry
-	l.registry.Profiles = profiles
+	// Swap in the new registry
+	registry.Profiles = profiles
+	l.mutex.Lock()
+	l.registry = registry
+	l.cache = cache
+	l.mutex.Unlock()
 	
 	l.logger.WithField("profile_count", len(profiles)).Info("Successfully loaded all profiles")
 	return nil
 }
 
-// findProfileFiles finds all YAML profile files in the directory
-func (l *Loader) findProfileFiles() ([]string, error) {
+// validateShadows checks that every shadow profile runs alongside an active
+// profile that exists and is not itself a shadow
+func validateShadows(profiles map[string]*types.Profile) error {
+	var errs []error
+	for id, profile := range profiles {
+		if problem := shadowProblem(profile, profiles); problem != "" {
+			errs = append(errs, fmt.Errorf("profile %s: %s", id, problem))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// shadowProblem describes what is wrong with a profile's shadow_of, or
+// returns "" when it is unset or valid
+func shadowProblem(profile *types.Profile, profiles map[string]*types.Profile) string {
+	if !profile.IsShadow() {
+		return ""
+	}
+	active, exists := profiles[profile.ShadowOf]
+	switch {
+	case profile.ShadowOf == profile.ID:
+		return "a profile cannot shadow itself"
+	case !exists:
+		return fmt.Sprintf("shadowed profile %s not found", profile.ShadowOf)
+	case active.IsShadow():
+		return fmt.Sprintf("shadowed profile %s is itself a shadow profile", profile.ShadowOf)
+	}
+	return ""
+}
+
+// duplicateIDError reports a profile ID defined by more than one file
+func duplicateIDError(id, firstFile, secondFile string) error {
+	return fmt.Errorf("duplicate profile ID %s defined in %s and %s", id, firstFile, secondFile)
+}
+
+// findProfileFiles finds all YAML profile files in the directory, skipping
+// hidden directories such as .git
+func findProfileFiles(directory string) ([]string, error) {
 	var files []string
 	
-	err := filepath.Walk(l.directory, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		
-		if !info.IsDir() && (strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml")) {
+		if info.IsDir() && path != directory && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		
+		if !info.IsDir() && isProfileFile(path) {
 			files = append(files, path)
 		}
 		
@@ -99,26 +249,119 @@ func (l *Loader) findProfileFiles() ([]string, error) {
 	return files, err
 }
 
+// loadProfileFileCached loads a profile file, reusing the previously parsed
+// profile when caching is enabled and the file is unchanged since it was
+// parsed. The entry for the file is recorded in next; files missing from
+// next after a load are thereby evicted.
+func (l *Loader) loadProfileFileCached(filename string, next map[string]*cacheEntry) (*types.Profile, error) {
+	if !l.cacheEnabled {
+		return l.loadProfileFile(filename)
+	}
+	
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file %s: %w", filename, err)
+	}
+	
+	l.mutex.RLock()
+	cached, exists := l.cache[filename]
+	l.mutex.RUnlock()
+	
+	if exists && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() && cached.includesUnchanged() {
+		next[filename] = cached
+		return cloneProfile(cached.profile), nil
+	}
+	
+	profile, includes, err := l.parseProfileFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		profile.CreatedAt = cached.profile.CreatedAt
+	}
+	
+	entry := &cacheEntry{
+		profile: cloneProfile(profile),
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+	for _, include := range includes {
+		stamp, err := statFile(include)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat file %s: %w", include, err)
+		}
+		entry.includes = append(entry.includes, stamp)
+	}
+	next[filename] = entry
+	
+	return profile, nil
+}
+
+// cloneProfile copies a profile deeply enough that inheritance merging on the
+// copy leaves the original untouched
+func cloneProfile(profile *types.Profile) *types.Profile {
+	clone := *profile
+	clone.DependsOn = append([]string(nil), profile.DependsOn...)
+	clone.FallbackModels = append([]string(nil), profile.FallbackModels...)
+	clone.FewShot = append([]types.FewShotExample(nil), profile.FewShot...)
+	clone.Policy.Conditions = append([]types.PolicyCondition(nil), profile.Policy.Conditions...)
+	clone.Response.Validation.RequiredFields = append([]string(nil), profile.Response.Validation.RequiredFields...)
+	clone.Response.Validation.AllowedActions = append([]string(nil), profile.Response.Validation.AllowedActions...)
+	if profile.Response.FieldMapping != nil {
+		clone.Response.FieldMapping = make(map[string]string, len(profile.Response.FieldMapping))
+		for field, source := range profile.Response.FieldMapping {
+			clone.Response.FieldMapping[field] = source
+		}
+	}
+	clone.Response.PostProcess = append([]types.PostProcessRule(nil), profile.Response.PostProcess...)
+	if profile.ConditionalExecution != nil {
+		conditional := *profile.ConditionalExecution
+		clone.ConditionalExecution = &conditional
+	}
+	if profile.FewShotSelection != nil {
+		selection := *profile.FewShotSelection
+		clone.FewShotSelection = &selection
+	}
+	if profile.Calibration != nil {
+		calibration := *profile.Calibration
+		calibration.Points = append([]types.CalibrationPoint(nil), profile.Calibration.Points...)
+		clone.Calibration = &calibration
+	}
+	return &clone
+}
+
 // loadProfileFile loads a single profile from a YAML file
 func (l *Loader) loadProfileFile(filename string) (*types.Profile, error) {
+	profile, _, err := l.parseProfileFile(filename)
+	return profile, err
+}
+
+// parseProfileFile loads a single profile from a YAML file, inlining its
+// few-shot example files, and returns the inlined files' paths
+func (l *Loader) parseProfileFile(filename string) (*types.Profile, []string, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file %s: %w", filename, err)
+		return nil, nil, fmt.Errorf("failed to read file %s: %w", filename, err)
 	}
 	
 	var profile types.Profile
 	if err := yaml.Unmarshal(data, &profile); err != nil {
-		return nil, fmt.Errorf("failed to parse YAML in %s: %w", filename, err)
+		return nil, nil, fmt.Errorf("failed to parse YAML in %s: %w", filename, err)
+	}
+	
+	includes, err := inlineFewShotFiles(&profile, filepath.Dir(filename))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load few-shot examples for %s: %w", filename, err)
 	}
 	
 	// Set timestamps
-	now := time.Now()
+	now := l.clock.Now()
 	profile.CreatedAt = now
 	profile.UpdatedAt = now
 	
 	// Validate profile
 	if err := l.validateProfile(&profile); err != nil {
-		return nil, fmt.Errorf("profile validation failed for %s: %w", filename, err)
+		return nil, nil, fmt.Errorf("profile validation failed for %s: %w", filename, err)
 	}
 	
 	l.logger.WithFields(logrus.Fields{
@@ -127,58 +370,214 @@ func (l *Loader) loadProfileFile(filename string) (*types.Profile, error) {
 		"file":       filename,
 	}).Info("Loaded profile")
 	
-	return &profile, nil
+	return &profile, includes, nil
 }
 
-// validateProfile validates a profile's structure and content
+// validateProfile validates a profile's structure and content, returning the
+// first problem found
 func (l *Loader) validateProfile(profile *types.Profile) error {
+	if issues := validationIssues(profile); len(issues) > 0 {
+		return fmt.Errorf("%s", issues[0].Message)
+	}
+	return nil
+}
+
+// validationIssues checks a profile's required fields and value ranges and
+// returns every problem found
+func validationIssues(profile *types.Profile) []Issue {
+	var issues []Issue
+	add := func(field, message string) {
+		issues = append(issues, Issue{ProfileID: profile.ID, Field: field, Message: message})
+	}
+	
 	if profile.ID == "" {
-		return fmt.Errorf("profile ID is required")
+		add("id", "profile ID is required")
 	}
 	
 	if profile.Version == "" {
-		return fmt.Errorf("profile version is required")
+		add("version", "profile version is required")
 	}
 	
 	if profile.Model == "" {
-		return fmt.Errorf("profile model is required")
+		add("model", "profile model is required")
 	}
 	
 	if profile.System == "" {
-		return fmt.Errorf("profile system prompt is required")
+		add("system", "profile system prompt is required")
 	}
 	
 	// Validate confidence range
-	if len(profile.Response.Validation.ConfidenceRange) != 2 {
-		return fmt.Errorf("confidence range must have exactly 2 values")
+	confidenceRange := profile.Response.Validation.ConfidenceRange
+	if len(confidenceRange) != 2 {
+		add("response.validation.confidence_range", "confidence range must have exactly 2 values")
+	} else if confidenceRange[0] < 0 || confidenceRange[1] > 1 {
+		add("response.validation.confidence_range", "confidence range must be between 0 and 1")
+	} else if confidenceRange[0] >= confidenceRange[1] {
+		add("response.validation.confidence_range", "confidence range minimum must be less than maximum")
 	}
 	
-	if profile.Response.Validation.ConfidenceRange[0] < 0 || profile.Response.Validation.ConfidenceRange[1] > 1 {
-		return fmt.Errorf("confidence range must be between 0 and 1")
+	if profile.Response.Validation.MaxReasoningLength < 0 {
+		add("response.validation.max_reasoning_length", "max reasoning length must not be negative")
 	}
 	
-	if profile.Response.Validation.ConfidenceRange[0] >= profile.Response.Validation.ConfidenceRange[1] {
-		return fmt.Errorf("confidence range minimum must be less than maximum")
+	if profile.PromptTemplate != "" {
+		if _, err := llm.ParsePromptTemplate(profile.PromptTemplate); err != nil {
+			add("prompt_template", err.Error())
+		}
+	}
+	
+	if triage := profile.Triage; triage != nil && (triage.EscalateBelow < 0 || triage.EscalateBelow > 1) {
+		add("triage.escalate_below", "escalate_below must be between 0 and 1")
+	}
+	
+	if allowed := profile.Response.Validation.AllowedActions; profile.FailAction != "" && len(allowed) > 0 && !containsString(allowed, profile.FailAction) {
+		add("fail_action", fmt.Sprintf("fail_action %q not in allowed_actions", profile.FailAction))
+	}
+
+	if emptyBody := profile.EmptyBody; emptyBody != nil {
+		if emptyBody.Confidence < 0 || emptyBody.Confidence > 1 {
+			add("empty_body.confidence", "empty_body confidence must be between 0 and 1")
+		}
+		if emptyBody.MaxConfidence < 0 || emptyBody.MaxConfidence > 1 {
+			add("empty_body.max_confidence", "empty_body max_confidence must be between 0 and 1")
+		}
+		if allowed := profile.Response.Validation.AllowedActions; emptyBody.Action != "" && len(allowed) > 0 && !containsString(allowed, emptyBody.Action) {
+			add("empty_body.action", fmt.Sprintf("empty_body action %q not in allowed_actions", emptyBody.Action))
+		}
+	}
+
+	// Field mappings may only rename the fields the parser reads
+	for _, field := range sortedMappingFields(profile.Response.FieldMapping) {
+		if !containsString(types.MappableResponseFields, field) {
+			add("response.field_mapping", fmt.Sprintf("unknown response field %q, must be one of %s", field, strings.Join(types.MappableResponseFields, ", ")))
+		} else if strings.TrimSpace(profile.Response.FieldMapping[field]) == "" {
+			add("response.field_mapping", fmt.Sprintf("response field %q must map to a model field", field))
+		}
 	}
 	
 	// Validate model parameters
-	if profile.ModelParams.Temperature < 0 || profile.ModelParams.Temperature > 2 {
-		return fmt.Errorf("temperature must be between 0 and 2")
+	if temperature := profile.ModelParams.Temperature; temperature != nil && (*temperature < 0 || *temperature > 2) {
+		add("model_params.temperature", "temperature must be between 0 and 2")
+	}
+	
+	// Zero leaves max_tokens to the backend's default model parameters
+	if profile.ModelParams.MaxTokens < 0 {
+		add("model_params.max_tokens", "max_tokens must not be negative")
+	}
+	
+	if profile.ModelParams.TopP < 0 || profile.ModelParams.TopP > 1 {
+		add("model_params.top_p", "top_p must be between 0 and 1")
 	}
 	
-	if profile.ModelParams.MaxTokens <= 0 {
-		return fmt.Errorf("max_tokens must be positive")
+	if profile.ModelParams.TopK < 0 {
+		add("model_params.top_k", "top_k must not be negative")
 	}
 	
 	if profile.ModelParams.TimeoutSeconds <= 0 {
-		return fmt.Errorf("timeout_seconds must be positive")
+		add("model_params.timeout_seconds", "timeout_seconds must be positive")
 	}
 	
-	return nil
+	if keepAlive := profile.ModelParams.KeepAlive; keepAlive != "" {
+		if _, err := time.ParseDuration(keepAlive); err != nil {
+			add("model_params.keep_alive", fmt.Sprintf("keep_alive must be a duration such as \"30m\", got %q", keepAlive))
+		}
+	}
+	
+	// Policy conditions may only take actions the response validation allows
+	if allowed := profile.Response.Validation.AllowedActions; len(allowed) > 0 {
+		for i, condition := range profile.Policy.Conditions {
+			for _, action := range condition.Actions {
+				if !containsString(allowed, action) {
+					add(fmt.Sprintf("policy.conditions[%d].actions", i),
+						fmt.Sprintf("policy condition %q references action %q not in allowed_actions", condition.Name, action))
+				}
+			}
+		}
+	}
+	
+	// Post-processing conditions are compiled now so that a broken rule
+	// fails the load rather than every classification
+	for i, rule := range profile.Response.PostProcess {
+		field := fmt.Sprintf("response.post_process[%d]", i)
+		if _, err := expr.Compile(rule.When); err != nil {
+			add(field+".when", err.Error())
+		}
+		if rule.Action == "" && len(rule.AddLabels) == 0 && len(rule.RemoveLabels) == 0 && len(rule.Metadata) == 0 {
+			add(field, fmt.Sprintf("post-processing rule %q changes nothing", rule.RuleName(i)))
+		}
+		if allowed := profile.Response.Validation.AllowedActions; rule.Action != "" && len(allowed) > 0 && !containsString(allowed, rule.Action) {
+			add(field+".action", fmt.Sprintf("post-processing rule %q sets action %q not in allowed_actions", rule.RuleName(i), rule.Action))
+		}
+	}
+	
+	// Few-shot conditions are compiled now, like post-processing conditions
+	for i, example := range profile.FewShot {
+		if example.When == "" {
+			continue
+		}
+		if _, err := expr.Compile(example.When); err != nil {
+			add(fmt.Sprintf("fewshot[%d].when", i), err.Error())
+		}
+	}
+	
+	// Validate few-shot selection
+	if selection := profile.FewShotSelection; selection != nil {
+		if selection.TopK <= 0 {
+			add("fewshot_selection.top_k", "few-shot selection top_k must be positive")
+		}
+		if selection.Model == "" {
+			add("fewshot_selection.model", "few-shot selection requires an embedding model")
+		}
+	}
+	
+	// Validate confidence calibration
+	if calibration := profile.Calibration; calibration != nil {
+		switch calibration.Method {
+		case types.CalibrationLinear:
+			if calibration.Scale <= 0 {
+				add("calibration.scale", "linear calibration scale must be positive")
+			}
+		case types.CalibrationPiecewise:
+			if len(calibration.Points) < 2 {
+				add("calibration.points", "piecewise calibration requires at least 2 points")
+			}
+			for i := 1; i < len(calibration.Points); i++ {
+				if calibration.Points[i].Raw <= calibration.Points[i-1].Raw {
+					add("calibration.points", "piecewise calibration points must have increasing raw values")
+					break
+				}
+			}
+		default:
+			add("calibration.method", fmt.Sprintf("unknown calibration method %q", calibration.Method))
+		}
+	}
+	
+	return issues
 }
 
-// buildDependencyGraph builds the dependency graph for profiles
-func (l *Loader) buildDependencyGraph(profiles map[string]*types.Profile) error {
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedMappingFields returns a field mapping's response fields in order, so
+// that validation reports them deterministically
+func sortedMappingFields(mapping map[string]string) []string {
+	fields := make([]string, 0, len(mapping))
+	for field := range mapping {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// buildDependencyGraph builds the dependency graph for profiles into registry
+func (l *Loader) buildDependencyGraph(registry *types.ProfileRegistry, profiles map[string]*types.Profile) error {
 	// Build dependency map
 	for id, profile := range profiles {
 		var deps []string
@@ -191,21 +590,21 @@ func (l *Loader) buildDependencyGraph(profiles map[string]*types.Profile) error
 		// Add explicit dependencies
 		deps = append(deps, profile.DependsOn...)
 		
-		l.registry.Dependencies[id] = deps
+		registry.Dependencies[id] = deps
 	}
 	
 	// Topological sort to determine load order
-	loadOrder, err := l.topologicalSort(profiles)
+	loadOrder, err := l.topologicalSort(profiles, registry.Dependencies)
 	if err != nil {
 		return err
 	}
 	
-	l.registry.LoadOrder = loadOrder
+	registry.LoadOrder = loadOrder
 	return nil
 }
 
 // topologicalSort performs topological sorting to determine profile load order
-func (l *Loader) topologicalSort(profiles map[string]*types.Profile) ([]string, error) {
+func (l *Loader) topologicalSort(profiles map[string]*types.Profile, dependencies map[string][]string) ([]string, error) {
 	// Kahn's algorithm for topological sorting
 	inDegree := make(map[string]int)
 	adjList := make(map[string][]string)
@@ -217,7 +616,7 @@ func (l *Loader) topologicalSort(profiles map[string]*types.Profile) ([]string,
 	}
 	
 	// Build graph
-	for id, deps := range l.registry.Dependencies {
+	for id, deps := range dependencies {
 		for _, dep := range deps {
 			if _, exists := profiles[dep]; !exists {
 				return nil, fmt.Errorf("dependency %s not found for profile %s", dep, id)
@@ -260,8 +659,8 @@ func (l *Loader) topologicalSort(profiles map[string]*types.Profile) ([]string,
 }
 
 // resolveInheritance resolves profile inheritance in dependency order
-func (l *Loader) resolveInheritance(profiles map[string]*types.Profile) error {
-	for _, id := range l.registry.LoadOrder {
+func (l *Loader) resolveInheritance(profiles map[string]*types.Profile, loadOrder []string) error {
+	for _, id := range loadOrder {
 		profile := profiles[id]
 		
 		if profile.InheritsFrom != "" {
@@ -295,14 +694,16 @@ func (l *Loader) mergeWithParent(child, parent *types.Profile) error {
 	}
 	
 	// Merge model parameters (child overrides parent)
-	if child.ModelParams.Temperature == 0 {
-		child.ModelParams.Temperature = parent.ModelParams.Temperature
-	}
-	if child.ModelParams.MaxTokens == 0 {
-		child.ModelParams.MaxTokens = parent.ModelParams.MaxTokens
+	child.ModelParams = child.ModelParams.WithDefaults(parent.ModelParams)
+	
+	// Merge fallback models (child overrides parent)
+	if len(child.FallbackModels) == 0 {
+		child.FallbackModels = parent.FallbackModels
 	}
-	if child.ModelParams.TimeoutSeconds == 0 {
-		child.ModelParams.TimeoutSeconds = parent.ModelParams.TimeoutSeconds
+	
+	// Merge prompt template (child overrides parent)
+	if child.PromptTemplate == "" {
+		child.PromptTemplate = parent.PromptTemplate
 	}
 	
 	// Merge few-shot examples (parent first, then child)
@@ -322,13 +723,54 @@ func (l *Loader) mergeWithParent(child, parent *types.Profile) error {
 	if child.Response.Validation.ConfidenceRange[0] == 0 && child.Response.Validation.ConfidenceRange[1] == 0 {
 		child.Response.Validation.ConfidenceRange = parent.Response.Validation.ConfidenceRange
 	}
+	if child.Response.Validation.MaxReasoningLength == 0 {
+		child.Response.Validation.MaxReasoningLength = parent.Response.Validation.MaxReasoningLength
+	}
+	if child.Response.FieldMapping == nil {
+		child.Response.FieldMapping = parent.Response.FieldMapping
+	}
+	if child.Response.PostProcess == nil {
+		child.Response.PostProcess = parent.Response.PostProcess
+	}
+	
+	// Merge few-shot selection (child overrides parent)
+	if child.FewShotSelection == nil {
+		child.FewShotSelection = parent.FewShotSelection
+	}
+	
+	// Merge confidence calibration (child overrides parent)
+	if child.Calibration == nil {
+		child.Calibration = parent.Calibration
+	}
 	
+	// Merge caching (child overrides parent)
+	if child.Cache == nil {
+		child.Cache = parent.Cache
+	}
+	
+	// Merge fail action (child overrides parent)
+	if child.FailAction == "" {
+		child.FailAction = parent.FailAction
+	}
+
+	// Merge triage (child overrides parent)
+	if child.Triage == nil {
+		child.Triage = parent.Triage
+	}
+	
+	// Merge empty body handling (child overrides parent)
+	if child.EmptyBody == nil {
+		child.EmptyBody = parent.EmptyBody
+	}
+
 	return nil
 }
 
 // GetProfile retrieves a profile by ID
 func (l *Loader) GetProfile(id string) (*types.Profile, error) {
+	l.mutex.RLock()
 	profile, exists := l.registry.Profiles[id]
+	l.mutex.RUnlock()
 	if !exists {
 		return nil, fmt.Errorf("profile %s not found", id)
 	}
@@ -338,6 +780,9 @@ func (l *Loader) GetProfile(id string) (*types.Profile, error) {
 
 // ListProfiles returns all loaded profile IDs
 func (l *Loader) ListProfiles() []string {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	
 	var ids []string
 	for id := range l.registry.Profiles {
 		ids = append(ids, id)
@@ -346,8 +791,11 @@ func (l *Loader) ListProfiles() []string {
 	return ids
 }
 
-// GetRegistry returns the profile registry
+// GetRegistry returns the current profile registry snapshot. The snapshot is
+// never modified after it is published, but a later LoadAll replaces it.
 func (l *Loader) GetRegistry() *types.ProfileRegistry {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
 	return l.registry
 }
 
@@ -1,14 +1,19 @@
 package profile
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/pkg/config"
 	"github.com/mailsentinel/core/pkg/types"
 )
 
@@ -26,6 +31,8 @@ func TestLoadProfileFile(t *testing.T) {
 	tempDir := t.TempDir()
 	logger := logrus.New()
 	loader := NewLoader(tempDir, logger)
+	loadedAt := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	loader.SetClock(clock.NewFake(loadedAt))
 
 	// Create test profile
 	profileContent := `
@@ -65,13 +72,15 @@ policy:
 	assert.Equal(t, "1.0.0", profile.Version)
 	assert.Equal(t, "qwen2.5:7b", profile.Model)
 	assert.Equal(t, "Test system prompt", profile.System)
-	assert.Equal(t, 0.1, profile.ModelParams.Temperature)
+	assert.Equal(t, 0.1, *profile.ModelParams.Temperature)
 	assert.Equal(t, 1000, profile.ModelParams.MaxTokens)
 	assert.Equal(t, 30, profile.ModelParams.TimeoutSeconds)
 	assert.Len(t, profile.FewShot, 1)
 	assert.Equal(t, "test_example", profile.FewShot[0].Name)
 	assert.Len(t, profile.Policy.Conditions, 1)
 	assert.Equal(t, "test_condition", profile.Policy.Conditions[0].Name)
+	assert.Equal(t, loadedAt, profile.CreatedAt)
+	assert.Equal(t, loadedAt, profile.UpdatedAt)
 }
 
 func TestValidateProfile(t *testing.T) {
@@ -143,12 +152,209 @@ func TestValidateProfile(t *testing.T) {
 			name: "invalid_temperature",
 			profile: func() *types.Profile {
 				p := validTestProfile()
-				p.ModelParams.Temperature = 3.0
+				p.ModelParams.Temperature = float64Ptr(3.0)
 				return p
 			}(),
 			wantErr: true,
 			errMsg:  "temperature must be between 0 and 2",
 		},
+		{
+			name: "invalid_top_p",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.ModelParams.TopP = 1.2
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  "top_p must be between 0 and 1",
+		},
+		{
+			name: "valid_post_process",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.Response.PostProcess = []types.PostProcessRule{{When: "confidence < 0.7", Action: "review"}}
+				return p
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "invalid_post_process_condition",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.Response.PostProcess = []types.PostProcessRule{{When: "confidence <", Action: "review"}}
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  `failed to compile "confidence <"`,
+		},
+		{
+			name: "invalid_fewshot_condition",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.FewShot = []types.FewShotExample{{Name: "invoice", Input: "Subject: Invoice", Output: `{"action": "label"}`, When: "email.subject contains"}}
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  `failed to compile "email.subject contains"`,
+		},
+		{
+			name: "post_process_changes_nothing",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.Response.PostProcess = []types.PostProcessRule{{Name: "noop", When: "true"}}
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  `post-processing rule "noop" changes nothing`,
+		},
+		{
+			name: "post_process_action_not_allowed",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.Response.Validation.AllowedActions = []string{"archive", "keep"}
+				p.Response.PostProcess = []types.PostProcessRule{{When: "confidence < 0.7", Action: "review"}}
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  `post-processing rule "post_process[0]" sets action "review" not in allowed_actions`,
+		},
+		{
+			name: "valid_calibration",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.Calibration = &types.ConfidenceCalibration{Method: types.CalibrationLinear, Scale: 0.8}
+				return p
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "unknown_calibration_method",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.Calibration = &types.ConfidenceCalibration{Method: "sigmoid"}
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  `unknown calibration method "sigmoid"`,
+		},
+		{
+			name: "unordered_calibration_points",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.Calibration = &types.ConfidenceCalibration{Method: types.CalibrationPiecewise, Points: []types.CalibrationPoint{
+					{Raw: 0.8, Calibrated: 0.7}, {Raw: 0.5, Calibrated: 0.4},
+				}}
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  "piecewise calibration points must have increasing raw values",
+		},
+		{
+			name: "policy_action_not_allowed",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.Response.Validation.AllowedActions = []string{"archive", "none"}
+				p.Policy.Conditions = []types.PolicyCondition{
+					{Name: "archive_low_value", Expression: "confidence >= 0.8", Actions: []string{"archive"}},
+					{Name: "delete_spam", Expression: "confidence >= 0.9", Actions: []string{"delete"}},
+				}
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  `policy condition "delete_spam" references action "delete" not in allowed_actions`,
+		},
+		{
+			name: "field_mapping_unknown_field",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.Response.FieldMapping = map[string]string{"action": "category", "score": "confidence"}
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  `unknown response field "score"`,
+		},
+		{
+			name: "negative_max_reasoning_length",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.Response.Validation.MaxReasoningLength = -1
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  "max reasoning length must not be negative",
+		},
+		{
+			name: "triage_escalate_below_out_of_range",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.Triage = &types.TriageConfig{EscalateBelow: 1.5}
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  "escalate_below must be between 0 and 1",
+		},
+		{
+			name: "fail_action_not_allowed",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.Response.Validation.AllowedActions = []string{"keep", "archive"}
+				p.FailAction = "review"
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  `fail_action "review" not in allowed_actions`,
+		},
+		{
+			name: "empty_body_action_not_allowed",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.Response.Validation.AllowedActions = []string{"keep", "archive"}
+				p.EmptyBody = &types.EmptyBodyConfig{Action: "system"}
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  `empty_body action "system" not in allowed_actions`,
+		},
+		{
+			name: "empty_body_max_confidence_out_of_range",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.EmptyBody = &types.EmptyBodyConfig{MaxConfidence: 1.2}
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  "empty_body max_confidence must be between 0 and 1",
+		},
+		{
+			name: "invalid_keep_alive",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.ModelParams.KeepAlive = "forever"
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  "keep_alive must be a duration",
+		},
+		{
+			name: "fewshot_selection_without_top_k",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.FewShotSelection = &types.FewShotSelection{Model: "nomic-embed-text"}
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  "few-shot selection top_k must be positive",
+		},
+		{
+			name: "prompt_template_unknown_field",
+			profile: func() *types.Profile {
+				p := validTestProfile()
+				p.PromptTemplate = "{{.System}}\n{{if .Email.Preview}}{{.Email.Sender}}{{end}}"
+				return p
+			}(),
+			wantErr: true,
+			errMsg:  "Email has no field Sender",
+		},
 	}
 
 	for _, tt := range tests {
@@ -187,13 +393,13 @@ func TestTopologicalSort(t *testing.T) {
 	}
 
 	// Set up dependencies
-	loader.registry.Dependencies = map[string][]string{
+	dependencies := map[string][]string{
 		"base":   {},
 		"child1": {"base"},
 		"child2": {"child1"},
 	}
 
-	order, err := loader.topologicalSort(profiles)
+	order, err := loader.topologicalSort(profiles, dependencies)
 	require.NoError(t, err)
 
 	// Verify order: base should come before child1, child1 before child2
@@ -216,12 +422,12 @@ func TestTopologicalSortCircularDependency(t *testing.T) {
 	}
 
 	// Set up circular dependencies
-	loader.registry.Dependencies = map[string][]string{
+	dependencies := map[string][]string{
 		"a": {"b"},
 		"b": {"a"},
 	}
 
-	_, err := loader.topologicalSort(profiles)
+	_, err := loader.topologicalSort(profiles, dependencies)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "circular dependency detected")
 }
@@ -233,9 +439,11 @@ func TestMergeWithParent(t *testing.T) {
 	parent := &types.Profile{
 		System: "Parent system prompt",
 		ModelParams: types.ModelParams{
-			Temperature:    0.2,
+			Temperature:    float64Ptr(0.2),
 			MaxTokens:      500,
 			TimeoutSeconds: 20,
+			TopP:           0.9,
+			TopK:           40,
 		},
 		FewShot: []types.FewShotExample{
 			{Name: "parent_example", Input: "parent input", Output: "parent output"},
@@ -256,7 +464,8 @@ func TestMergeWithParent(t *testing.T) {
 	child := &types.Profile{
 		System: "Child system prompt",
 		ModelParams: types.ModelParams{
-			Temperature: 0.1, // Override parent
+			Temperature: float64Ptr(0.1), // Override parent
+			TopK:        20,
 			// MaxTokens and TimeoutSeconds should inherit from parent
 		},
 		FewShot: []types.FewShotExample{
@@ -277,9 +486,11 @@ func TestMergeWithParent(t *testing.T) {
 	assert.Equal(t, expectedSystem, child.System)
 
 	// Verify model params are merged (child overrides, parent fills gaps)
-	assert.Equal(t, 0.1, child.ModelParams.Temperature)     // Child override
-	assert.Equal(t, 500, child.ModelParams.MaxTokens)       // Inherited from parent
-	assert.Equal(t, 20, child.ModelParams.TimeoutSeconds)   // Inherited from parent
+	assert.Equal(t, 0.1, *child.ModelParams.Temperature)  // Child override
+	assert.Equal(t, 500, child.ModelParams.MaxTokens)     // Inherited from parent
+	assert.Equal(t, 20, child.ModelParams.TimeoutSeconds) // Inherited from parent
+	assert.Equal(t, 0.9, child.ModelParams.TopP)          // Inherited from parent
+	assert.Equal(t, 20, child.ModelParams.TopK)           // Child override
 
 	// Verify few-shot examples are merged (parent first)
 	assert.Len(t, child.FewShot, 2)
@@ -338,8 +549,238 @@ func TestListProfiles(t *testing.T) {
 	assert.Equal(t, []string{"alerts", "meetings", "spam"}, profiles)
 }
 
+func TestConcurrentReloadAndRead(t *testing.T) {
+	tempDir := t.TempDir()
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	loader := NewLoader(tempDir, logger)
+
+	for i := 0; i < 5; i++ {
+		writeTestProfile(t, tempDir, fmt.Sprintf("profile_%d", i))
+	}
+	require.NoError(t, loader.LoadAll())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				assert.NoError(t, loader.Reload())
+			}
+		}()
+	}
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				profile, err := loader.GetProfile("profile_0")
+				if assert.NoError(t, err) {
+					assert.Equal(t, "profile_0", profile.ID)
+				}
+				assert.Len(t, loader.ListProfiles(), 5)
+				assert.Len(t, loader.GetRegistry().LoadOrder, 5)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestLoadAllReparsesOnlyChangedFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	loader := NewLoader(tempDir, logger)
+	loader.SetCacheEnabled(true)
+
+	writeTestProfile(t, tempDir, "alpha")
+	betaPath := writeTestProfile(t, tempDir, "beta")
+	gammaPath := writeTestProfile(t, tempDir, "gamma")
+	require.NoError(t, loader.LoadAll())
+
+	parsedAt := make(map[string]time.Time)
+	for _, id := range loader.ListProfiles() {
+		profile, err := loader.GetProfile(id)
+		require.NoError(t, err)
+		parsedAt[id] = profile.UpdatedAt
+	}
+
+	// Touch beta and delete gamma
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(betaPath, future, future))
+	require.NoError(t, os.Remove(gammaPath))
+	require.NoError(t, loader.Reload())
+
+	alpha, err := loader.GetProfile("alpha")
+	require.NoError(t, err)
+	assert.Equal(t, parsedAt["alpha"], alpha.UpdatedAt, "unchanged file should not be re-parsed")
+
+	beta, err := loader.GetProfile("beta")
+	require.NoError(t, err)
+	assert.True(t, beta.UpdatedAt.After(parsedAt["beta"]), "touched file should be re-parsed")
+	assert.Equal(t, parsedAt["beta"], beta.CreatedAt, "re-parsed profile keeps its creation time")
+
+	_, err = loader.GetProfile("gamma")
+	assert.Error(t, err)
+	assert.NotContains(t, loader.cache, gammaPath)
+	assert.Len(t, loader.cache, 2)
+}
+
+func TestLoadAllCachedProfilesUnaffectedByInheritance(t *testing.T) {
+	tempDir := t.TempDir()
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	loader := NewLoader(tempDir, logger)
+	loader.SetCacheEnabled(true)
+
+	writeTestProfile(t, tempDir, "base")
+	childPath := filepath.Join(tempDir, "child.yaml")
+	require.NoError(t, os.WriteFile(childPath, []byte(`
+id: "child"
+version: "1.0.0"
+inherits_from: "base"
+model: "qwen2.5:7b"
+system: "Child prompt"
+model_params:
+  max_tokens: 500
+  timeout_seconds: 30
+response:
+  validation:
+    confidence_range: [0.0, 1.0]
+`), 0644))
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, loader.LoadAll())
+	}
+
+	child, err := loader.GetProfile("child")
+	require.NoError(t, err)
+	assert.Equal(t, "Test system prompt\n\nChild prompt", child.System, "inheritance must be applied exactly once")
+}
+
+func TestLoadAllRejectsDuplicateIDs(t *testing.T) {
+	tempDir := t.TempDir()
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	loader := NewLoader(tempDir, logger)
+
+	firstPath := writeTestProfile(t, tempDir, "spam")
+	secondPath := filepath.Join(tempDir, "spam_copy.yaml")
+	data, err := os.ReadFile(firstPath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(secondPath, data, 0644))
+
+	err = loader.LoadAll()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "duplicate profile ID spam")
+	assert.Contains(t, err.Error(), firstPath)
+	assert.Contains(t, err.Error(), secondPath)
+	assert.Empty(t, loader.ListProfiles(), "registry is not replaced on a failed load")
+
+	issues, err := loader.Lint()
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Equal(t, secondPath, issues[0].File)
+	assert.Contains(t, issues[0].Message, firstPath)
+}
+
+func TestLoadAllValidatesShadowProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		shadowOf string
+		errMsg   string
+	}{
+		{"valid", "spam", ""},
+		{"unknown", "missing", "shadowed profile missing not found"},
+		{"itself", "candidate", "cannot shadow itself"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			logger := logrus.New()
+			logger.SetLevel(logrus.ErrorLevel)
+			loader := NewLoader(tempDir, logger)
+
+			writeTestProfile(t, tempDir, "spam")
+			candidatePath := writeTestProfile(t, tempDir, "candidate")
+			data, err := os.ReadFile(candidatePath)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(candidatePath, append(data, fmt.Sprintf("shadow_of: %q\n", tt.shadowOf)...), 0644))
+
+			err = loader.LoadAll()
+			issues, lintErr := loader.Lint()
+			require.NoError(t, lintErr)
+			if tt.errMsg == "" {
+				require.NoError(t, err)
+				candidate, err := loader.GetProfile("candidate")
+				require.NoError(t, err)
+				assert.True(t, candidate.IsShadow())
+				assert.Empty(t, issues)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+			require.Len(t, issues, 1)
+			assert.Equal(t, "shadow_of", issues[0].Field)
+			assert.Contains(t, issues[0].Message, tt.errMsg)
+		})
+	}
+}
+
+func TestLoadAllAppliesDefaultPromptTemplate(t *testing.T) {
+	tempDir := t.TempDir()
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	writeTestProfile(t, tempDir, "spam")
+	customPath := writeTestProfile(t, tempDir, "custom")
+	data, err := os.ReadFile(customPath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(customPath, append(data, "prompt_template: \"Own: {{.Email.Subject}}\"\n"...), 0644))
+
+	_, err = NewLoaderFromConfig(&config.ProfilesConfig{Directory: tempDir, PromptTemplate: "{{.Email.Sender}}"}, logger)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "profiles.prompt_template")
+
+	loader, err := NewLoaderFromConfig(&config.ProfilesConfig{Directory: tempDir, PromptTemplate: "Default: {{.Email.Subject}}"}, logger)
+	require.NoError(t, err)
+	require.NoError(t, loader.LoadAll())
+
+	spam, err := loader.GetProfile("spam")
+	require.NoError(t, err)
+	assert.Equal(t, "Default: {{.Email.Subject}}", spam.PromptTemplate)
+	custom, err := loader.GetProfile("custom")
+	require.NoError(t, err)
+	assert.Equal(t, "Own: {{.Email.Subject}}", custom.PromptTemplate)
+}
+
 // Helper functions
 
+// writeTestProfile writes a minimal valid profile with the given ID to dir
+func writeTestProfile(t *testing.T, dir, id string) string {
+	content := fmt.Sprintf(`
+id: %q
+version: "1.0.0"
+model: "qwen2.5:7b"
+system: "Test system prompt"
+model_params:
+  temperature: 0.1
+  max_tokens: 1000
+  timeout_seconds: 30
+response:
+  validation:
+    confidence_range: [0.0, 1.0]
+`, id)
+
+	path := filepath.Join(dir, id+".yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
 func validTestProfile() *types.Profile {
 	return &types.Profile{
 		ID:      "test",
@@ -347,7 +788,7 @@ func validTestProfile() *types.Profile {
 		Model:   "qwen2.5:7b",
 		System:  "Test system prompt",
 		ModelParams: types.ModelParams{
-			Temperature:    0.1,
+			Temperature:    float64Ptr(0.1),
 			MaxTokens:      1000,
 			TimeoutSeconds: 30,
 		},
@@ -368,3 +809,7 @@ func findIndex(slice []string, item string) int {
 	}
 	return -1
 }
+
+func float64Ptr(value float64) *float64 {
+	return &value
+}
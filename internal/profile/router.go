@@ -0,0 +1,177 @@
+package profile
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/expr"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Router selects the profiles to run on an email. Route narrows the loaded
+// profiles before any model call; ShouldExecute then applies each profile's
+// conditional_execution.when as the email is classified. Both evaluate
+// expressions against email, the email being routed, for example
+// 'CATEGORY_PROMOTIONS' in email.labels or email.auth.dmarc != 'pass'.
+// Conditional execution conditions can also read the results of profiles
+// classified earlier by their ID, as in spam_detection.action != 'delete',
+// and the constant always.
+type Router struct {
+	routes []route
+	gated  map[string]bool
+	when   sync.Map // conditional execution source -> *expr.Program
+	logger *logrus.Logger
+}
+
+// route is a compiled routing rule
+type route struct {
+	name     string
+	labels   map[string]bool
+	when     *expr.Program
+	profiles []string
+}
+
+// NewRouter compiles a routing configuration
+func NewRouter(cfg config.RoutingConfig, logger *logrus.Logger) (*Router, error) {
+	r := &Router{
+		gated:  make(map[string]bool),
+		logger: logger,
+	}
+
+	for i, rc := range cfg.Routes {
+		compiled := route{
+			name:     rc.Name,
+			labels:   make(map[string]bool, len(rc.Labels)),
+			profiles: rc.Profiles,
+		}
+		if compiled.name == "" {
+			compiled.name = fmt.Sprintf("routes[%d]", i)
+		}
+		for _, label := range rc.Labels {
+			compiled.labels[strings.ToUpper(label)] = true
+		}
+		if rc.When != "" {
+			program, err := expr.Compile(rc.When)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %w", compiled.name, err)
+			}
+			compiled.when = program
+		}
+
+		for _, id := range rc.Profiles {
+			r.gated[id] = true
+		}
+		r.routes = append(r.routes, compiled)
+	}
+
+	return r, nil
+}
+
+// Route returns the profiles, in their given order, that the routes allow
+// to classify an email. Route conditions that fail to evaluate do not match.
+func (r *Router) Route(email *types.Email, profiles []*types.Profile) []*types.Profile {
+	env := expr.Env{"email": email}
+
+	routed := make(map[string]bool)
+	for _, rt := range r.routes {
+		if r.matches(rt, email, env) {
+			for _, id := range rt.profiles {
+				routed[id] = true
+			}
+		}
+	}
+
+	var selected []*types.Profile
+	for _, profile := range profiles {
+		if !r.gated[profile.ID] || routed[profile.ID] {
+			selected = append(selected, profile)
+		}
+	}
+
+	r.logger.WithFields(logrus.Fields{
+		"email_id": email.ID,
+		"profiles": len(selected),
+		"skipped":  len(profiles) - len(selected),
+	}).Debug("Routed email to profiles")
+
+	return selected
+}
+
+// matches reports whether an email satisfies a route
+func (r *Router) matches(rt route, email *types.Email, env expr.Env) bool {
+	if len(rt.labels) > 0 && !hasAnyLabel(email, rt.labels) {
+		return false
+	}
+	if rt.when == nil {
+		return true
+	}
+
+	matched, err := rt.when.EvalBool(env)
+	if err != nil {
+		r.logger.WithError(err).WithFields(logrus.Fields{
+			"email_id": email.ID,
+			"route":    rt.name,
+		}).Warn("Failed to evaluate route condition")
+		return false
+	}
+	return matched
+}
+
+// ShouldExecute evaluates a profile's conditional execution condition
+// against the email and the results of the profiles already classified. A
+// condition that fails to evaluate skips the profile.
+func (r *Router) ShouldExecute(profile *types.Profile, email *types.Email, prior []*types.ClassificationResponse) bool {
+	if profile.ConditionalExecution == nil || profile.ConditionalExecution.When == "" {
+		return true
+	}
+
+	env := expr.Env{}
+	for _, result := range prior {
+		env[result.ProfileID] = result.Fields()
+	}
+	env["email"] = email
+	env["always"] = true
+
+	program, err := r.compileWhen(profile.ConditionalExecution.When)
+	if err == nil {
+		var execute bool
+		if execute, err = program.EvalBool(env); err == nil {
+			return execute
+		}
+	}
+
+	r.logger.WithError(err).WithFields(logrus.Fields{
+		"email_id":   email.ID,
+		"profile_id": profile.ID,
+	}).Warn("Failed to evaluate conditional execution, skipping profile")
+	return false
+}
+
+// compileWhen returns the compiled conditional execution condition,
+// compiling it on first use
+func (r *Router) compileWhen(source string) (*expr.Program, error) {
+	if cached, exists := r.when.Load(source); exists {
+		return cached.(*expr.Program), nil
+	}
+
+	program, err := expr.Compile(source)
+	if err != nil {
+		return nil, err
+	}
+	r.when.Store(source, program)
+	return program, nil
+}
+
+// hasAnyLabel reports whether an email carries one of the labels
+func hasAnyLabel(email *types.Email, labels map[string]bool) bool {
+	for _, label := range email.Labels {
+		if labels[strings.ToUpper(label)] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package profile
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/emptybody"
+	"github.com/mailsentinel/core/internal/language"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestRouteSelectsProfilesByLabel(t *testing.T) {
+	router, err := NewRouter(config.RoutingConfig{Routes: []config.Route{
+		{Name: "promotions", Labels: []string{"category_promotions", "CATEGORY_UPDATES"}, Profiles: []string{"newsletter", "commerce"}},
+		{Name: "social", Labels: []string{"CATEGORY_SOCIAL"}, Profiles: []string{"commerce"}},
+	}}, routerTestLogger())
+	require.NoError(t, err)
+
+	profiles := routerTestProfiles("spam_detection", "newsletter", "commerce", "importance")
+
+	tests := []struct {
+		name     string
+		labels   []string
+		expected []string
+	}{
+		{"matching label selects its profiles", []string{"INBOX", "CATEGORY_PROMOTIONS"}, []string{"spam_detection", "newsletter", "commerce", "importance"}},
+		{"profile named by several routes", []string{"CATEGORY_SOCIAL"}, []string{"spam_detection", "commerce", "importance"}},
+		{"no matching label", []string{"INBOX"}, []string{"spam_detection", "importance"}},
+		{"no labels", nil, []string{"spam_detection", "importance"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected := router.Route(&types.Email{ID: "email-1", Labels: tt.labels}, profiles)
+			assert.Equal(t, tt.expected, profileIDs(selected))
+		})
+	}
+}
+
+func TestRouteWhen(t *testing.T) {
+	router, err := NewRouter(config.RoutingConfig{Routes: []config.Route{
+		{Name: "unauthenticated", When: "email.auth.dmarc != 'pass'", Profiles: []string{"phishing"}},
+		{Name: "inbox invoices", Labels: []string{"INBOX"}, When: "email.subject.contains('invoice') && !(email.from contains '@example.com')", Profiles: []string{"finance"}},
+		{Name: "quarantine", When: "email.context.source == 'quarantine'", Profiles: []string{"quarantine"}},
+	}}, routerTestLogger())
+	require.NoError(t, err)
+
+	profiles := routerTestProfiles("phishing", "finance", "quarantine")
+
+	tests := []struct {
+		name     string
+		email    *types.Email
+		expected []string
+	}{
+		{"failed dmarc", &types.Email{Auth: &types.AuthResults{DMARC: types.AuthFail}}, []string{"phishing"}},
+		{"passed dmarc", &types.Email{Auth: &types.AuthResults{DMARC: types.AuthPass}}, nil},
+		{"labels and when both hold", &types.Email{Subject: "Your invoice", Labels: []string{"INBOX"}, Auth: &types.AuthResults{DMARC: types.AuthPass}}, []string{"finance"}},
+		{"internal sender", &types.Email{Subject: "Your invoice", From: "billing@example.com", Labels: []string{"INBOX"}, Auth: &types.AuthResults{DMARC: types.AuthPass}}, nil},
+		{"when holds without the label", &types.Email{Subject: "Your invoice", Auth: &types.AuthResults{DMARC: types.AuthPass}}, nil},
+		{"caller context", &types.Email{Auth: &types.AuthResults{DMARC: types.AuthPass}, Context: map[string]string{"source": "quarantine"}}, []string{"quarantine"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, profileIDs(router.Route(tt.email, profiles)))
+		})
+	}
+}
+
+func TestRouteByLanguage(t *testing.T) {
+	router, err := NewRouter(config.RoutingConfig{Routes: []config.Route{
+		{Name: "german", When: "email.language == 'de'", Profiles: []string{"spam-de"}},
+		{Name: "other languages", When: "email.language != 'de'", Profiles: []string{"spam"}},
+	}}, routerTestLogger())
+	require.NoError(t, err)
+	profiles := routerTestProfiles("spam", "spam-de")
+
+	german := &types.Email{Body: "Herzlichen Glückwunsch! Sie haben einen Gutschein gewonnen. Bitte klicken Sie auf den Link, um ihn einzulösen."}
+	language.Annotate(german)
+	assert.Equal(t, []string{"spam-de"}, profileIDs(router.Route(german, profiles)))
+
+	short := &types.Email{Body: "Gewonnen!"}
+	language.Annotate(short)
+	assert.Equal(t, []string{"spam"}, profileIDs(router.Route(short, profiles)), "a body too short to tell is unknown")
+}
+
+func TestRouteEmptyBodies(t *testing.T) {
+	router, err := NewRouter(config.RoutingConfig{Routes: []config.Route{
+		{Name: "system mail", When: "email.empty_body", Profiles: []string{"system"}},
+		{Name: "messages", When: "!email.empty_body", Profiles: []string{"spam"}},
+	}}, routerTestLogger())
+	require.NoError(t, err)
+	profiles := routerTestProfiles("spam", "system")
+
+	invite := &types.Email{Subject: "Invitation: Q1 planning", Attachments: []types.Attachment{{Filename: "invite.ics", MimeType: "application/ics"}}}
+	emptybody.Annotate(invite)
+	assert.Equal(t, []string{"system"}, profileIDs(router.Route(invite, profiles)))
+
+	message := &types.Email{Subject: "Q1 planning", Body: "Can we move the planning meeting to Tuesday afternoon?"}
+	emptybody.Annotate(message)
+	assert.Equal(t, []string{"spam"}, profileIDs(router.Route(message, profiles)))
+}
+
+func TestNewRouterRejectsInvalidConditions(t *testing.T) {
+	_, err := NewRouter(config.RoutingConfig{Routes: []config.Route{
+		{Name: "broken", When: "email.labels ==", Profiles: []string{"newsletter"}},
+	}}, routerTestLogger())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "route broken")
+}
+
+func TestShouldExecute(t *testing.T) {
+	router, err := NewRouter(config.RoutingConfig{}, routerTestLogger())
+	require.NoError(t, err)
+
+	prior := []*types.ClassificationResponse{{
+		ProfileID:  "spam_detection",
+		Action:     "keep",
+		Confidence: 0.9,
+		Metadata:   map[string]interface{}{"category": "legitimate"},
+	}}
+	email := &types.Email{ID: "email-1", Labels: []string{"INBOX"}, Context: map[string]string{"prior_action": "archive"}}
+
+	tests := []struct {
+		name     string
+		when     string
+		expected bool
+	}{
+		{"no condition", "", true},
+		{"always", "always", true},
+		{"prior result metadata", "spam_detection.category == 'legitimate'", true},
+		{"prior result action", "spam_detection.action == 'delete'", false},
+		{"profile not yet classified", "phishing.phishing_score >= 0.5", false},
+		{"email fields", "'INBOX' in email.labels", true},
+		{"caller context", "email.context.prior_action == 'archive'", true},
+		{"missing context key", "email.context.source == 'quarantine'", false},
+		{"invalid condition skips the profile", "spam_detection.category ==", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := &types.Profile{ID: "importance"}
+			if tt.when != "" {
+				profile.ConditionalExecution = &types.ConditionalExecution{When: tt.when}
+			}
+			assert.Equal(t, tt.expected, router.ShouldExecute(profile, email, prior))
+		})
+	}
+}
+
+// Helper functions
+
+func routerTestLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
+
+func routerTestProfiles(ids ...string) []*types.Profile {
+	profiles := make([]*types.Profile, len(ids))
+	for i, id := range ids {
+		profiles[i] = &types.Profile{ID: id}
+	}
+	return profiles
+}
+
+func profileIDs(profiles []*types.Profile) []string {
+	var ids []string
+	for _, profile := range profiles {
+		ids = append(ids, profile.ID)
+	}
+	return ids
+}
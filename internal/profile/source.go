@@ -0,0 +1,389 @@
+package profile
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+// Source makes profile files available on the local filesystem
+type Source interface {
+	// Sync fetches the latest profiles if needed and returns the local
+	// directory holding them
+	Sync(ctx context.Context) (string, error)
+}
+
+// NewSource creates the profile source described by the profiles configuration
+func NewSource(cfg *config.ProfilesConfig, logger *logrus.Logger) (Source, error) {
+	switch cfg.Source.Type {
+	case "", config.ProfileSourceDirectory:
+		return DirectorySource(cfg.Directory), nil
+	case config.ProfileSourceHTTP:
+		return NewHTTPSource(&cfg.Source, logger), nil
+	case config.ProfileSourceGit:
+		return NewGitSource(&cfg.Source, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown profile source type %q", cfg.Source.Type)
+	}
+}
+
+// DirectorySource serves profiles from a local directory
+type DirectorySource string
+
+// Sync returns the directory unchanged
+func (d DirectorySource) Sync(ctx context.Context) (string, error) {
+	return string(d), nil
+}
+
+// MaxBundleSize caps both the download of a profile bundle and the files
+// extracted from it
+const MaxBundleSize = 32 << 20 // 32MB
+
+// ErrBundleTooLarge is returned for a profile bundle over MaxBundleSize
+var ErrBundleTooLarge = errors.New("profile bundle too large")
+
+// HTTPSource fetches a gzip-compressed tar bundle of profiles over HTTP.
+// The bundle is revalidated with its ETag so unchanged profiles are not
+// downloaded again.
+type HTTPSource struct {
+	url      string
+	cacheDir string
+	maxSize  int64
+	client   *http.Client
+	logger   *logrus.Logger
+}
+
+// NewHTTPSource creates a new HTTP profile source
+func NewHTTPSource(cfg *config.ProfileSource, logger *logrus.Logger) *HTTPSource {
+	return &HTTPSource{
+		url:      cfg.URL,
+		cacheDir: cfg.CacheDir,
+		maxSize:  MaxBundleSize,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		logger:   logger,
+	}
+}
+
+// Sync downloads the bundle if it changed and returns the cached profiles
+// directory. If the download fails, the last good copy is returned.
+func (s *HTTPSource) Sync(ctx context.Context) (string, error) {
+	profilesDir := filepath.Join(s.cacheDir, "profiles")
+	etagFile := filepath.Join(s.cacheDir, "etag")
+
+	if err := s.fetch(ctx, profilesDir, etagFile); err != nil {
+		return fallbackToCache(profilesDir, s.url, err, s.logger)
+	}
+	return profilesDir, nil
+}
+
+func (s *HTTPSource) fetch(ctx context.Context, profilesDir, etagFile string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if etag, err := os.ReadFile(etagFile); err == nil && dirExists(profilesDir) {
+		req.Header.Set("If-None-Match", strings.TrimSpace(string(etag)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch profiles: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		s.logger.WithField("url", s.url).Debug("Remote profiles unchanged")
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("failed to fetch profiles: unexpected status %d", resp.StatusCode)
+	}
+
+	if err := os.MkdirAll(s.cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	stagingDir, err := os.MkdirTemp(s.cacheDir, "profiles-")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(stagingDir)
+
+	if err := extractBundle(newCappedReader(resp.Body, s.maxSize), stagingDir, s.maxSize); err != nil {
+		return err
+	}
+
+	if err := replaceDir(stagingDir, profilesDir); err != nil {
+		return err
+	}
+
+	etag := resp.Header.Get("ETag")
+	if err := os.WriteFile(etagFile, []byte(etag), 0644); err != nil {
+		return fmt.Errorf("failed to write etag: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"url":  s.url,
+		"etag": etag,
+	}).Info("Fetched remote profiles")
+
+	return nil
+}
+
+// extractBundle extracts the YAML files of a tar.gz bundle into dir, failing
+// once more than maxSize bytes are decompressed
+func extractBundle(r io.Reader, dir string, maxSize int64) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read profile bundle: %w", err)
+	}
+	defer gz.Close()
+
+	archive := tar.NewReader(newCappedReader(gz, maxSize))
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read profile bundle: %w", err)
+		}
+
+		if header.Typeflag != tar.TypeReg || !isProfileFile(header.Name) {
+			continue
+		}
+
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("profile bundle contains unsafe path %q", header.Name)
+		}
+
+		target := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", name, err)
+		}
+
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", name, err)
+		}
+		_, copyErr := io.Copy(file, archive)
+		closeErr := file.Close()
+		if copyErr != nil {
+			return fmt.Errorf("failed to extract %s: %w", name, copyErr)
+		}
+		if closeErr != nil {
+			return fmt.Errorf("failed to extract %s: %w", name, closeErr)
+		}
+		os.Chtimes(target, header.ModTime, header.ModTime)
+	}
+}
+
+// cappedReader reads at most max bytes from r, failing with
+// ErrBundleTooLarge rather than ending early when r holds more
+type cappedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+// newCappedReader caps r at max bytes
+func newCappedReader(r io.Reader, max int64) *cappedReader {
+	return &cappedReader{r: io.LimitReader(r, max+1), remaining: max}
+}
+
+// Read implements io.Reader
+func (c *cappedReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if c.remaining < 0 {
+		return n, ErrBundleTooLarge
+	}
+	return n, err
+}
+
+// GitSource fetches profiles from a Git repository using the git command.
+// The checked-out commit hash is compared with the remote ref so the
+// working copy is only updated when the ref moves.
+type GitSource struct {
+	url      string
+	ref      string
+	path     string
+	cacheDir string
+	timeout  time.Duration
+	logger   *logrus.Logger
+}
+
+// NewGitSource creates a new Git profile source
+func NewGitSource(cfg *config.ProfileSource, logger *logrus.Logger) *GitSource {
+	return &GitSource{
+		url:      cfg.URL,
+		ref:      cfg.Ref,
+		path:     cfg.Path,
+		cacheDir: cfg.CacheDir,
+		timeout:  cfg.Timeout,
+		logger:   logger,
+	}
+}
+
+// Sync updates the local clone if the remote ref moved and returns the
+// profiles directory within it. If the update fails, the last good
+// checkout is returned.
+func (s *GitSource) Sync(ctx context.Context) (string, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	repoDir := filepath.Join(s.cacheDir, "repo")
+	profilesDir := filepath.Join(repoDir, s.path)
+
+	if err := s.update(ctx, repoDir); err != nil {
+		return fallbackToCache(profilesDir, s.url, err, s.logger)
+	}
+	return profilesDir, nil
+}
+
+func (s *GitSource) update(ctx context.Context, repoDir string) error {
+	ref := s.ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	remoteCommit, err := s.remoteCommit(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	if dirExists(repoDir) {
+		localCommit, err := git(ctx, repoDir, "rev-parse", "HEAD")
+		if err == nil && localCommit == remoteCommit {
+			s.logger.WithField("commit", localCommit).Debug("Remote profiles unchanged")
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(s.cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	stagingDir, err := os.MkdirTemp(s.cacheDir, "repo-")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(stagingDir)
+
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if s.ref != "" {
+		args = append(args, "--branch", s.ref)
+	}
+	if _, err := git(ctx, "", append(args, s.url, stagingDir)...); err != nil {
+		return err
+	}
+
+	commit, err := git(ctx, stagingDir, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	if commit != remoteCommit {
+		return fmt.Errorf("cloned commit %s does not match remote %s %s", commit, ref, remoteCommit)
+	}
+
+	if err := replaceDir(stagingDir, repoDir); err != nil {
+		return err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"url":    s.url,
+		"ref":    ref,
+		"commit": commit,
+	}).Info("Fetched remote profiles")
+
+	return nil
+}
+
+// remoteCommit resolves ref on the remote to a commit hash
+func (s *GitSource) remoteCommit(ctx context.Context, ref string) (string, error) {
+	output, err := git(ctx, "", "ls-remote", s.url, ref)
+	if err != nil {
+		return "", err
+	}
+
+	// Annotated tags are listed twice; the peeled "^{}" entry is the commit
+	var commit string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		name := fields[1]
+		if strings.HasSuffix(name, "^{}") {
+			return fields[0], nil
+		}
+		if commit == "" && (name == ref || strings.HasSuffix(name, "/"+ref)) {
+			commit = fields[0]
+		}
+	}
+	if commit == "" {
+		return "", fmt.Errorf("ref %s not found in %s", ref, s.url)
+	}
+	return commit, nil
+}
+
+// git runs a git command and returns its trimmed output
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// fallbackToCache returns the cached profiles directory after a failed
+// fetch, or the fetch error when nothing has been cached yet
+func fallbackToCache(dir, url string, fetchErr error, logger *logrus.Logger) (string, error) {
+	if !dirExists(dir) {
+		return "", fmt.Errorf("failed to sync profiles from %s: %w", url, fetchErr)
+	}
+
+	logger.WithError(fetchErr).WithField("url", url).Warn("Failed to sync remote profiles, using cached copy")
+	return dir, nil
+}
+
+// replaceDir moves src to dst, replacing any existing dst
+func replaceDir(src, dst string) error {
+	if err := os.RemoveAll(dst); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", dst, err)
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", src, dst, err)
+	}
+	return nil
+}
+
+func dirExists(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+func isProfileFile(name string) bool {
+	return strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml")
+}
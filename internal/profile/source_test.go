@@ -0,0 +1,220 @@
+package profile
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+func TestHTTPSourceRevalidatesWithETag(t *testing.T) {
+	bundle := testBundle(t, map[string]string{
+		"spam.yaml":     testProfileYAML("spam"),
+		"README.md":     "ignored",
+		"nested/a.yaml": testProfileYAML("nested_a"),
+	})
+
+	var requests, notModified int32
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(bundle)
+	}))
+	defer server.Close()
+
+	cfg := testSourceConfig(config.ProfileSourceHTTP, server.URL, t.TempDir())
+	loader, err := NewLoaderFromConfig(cfg, sourceTestLogger())
+	require.NoError(t, err)
+
+	require.NoError(t, loader.LoadAll())
+	assert.Equal(t, []string{"nested_a", "spam"}, loader.ListProfiles())
+
+	require.NoError(t, loader.Reload())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&notModified))
+
+	// A failing endpoint falls back to the last good bundle
+	failing.Store(true)
+	require.NoError(t, loader.Reload())
+	assert.Equal(t, []string{"nested_a", "spam"}, loader.ListProfiles())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestHTTPSourceFailsWithoutCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	source := NewHTTPSource(&testSourceConfig(config.ProfileSourceHTTP, server.URL, t.TempDir()).Source, sourceTestLogger())
+	_, err := source.Sync(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected status 502")
+}
+
+func TestHTTPSourceRejectsUnsafePaths(t *testing.T) {
+	bundle := testBundle(t, map[string]string{"../escape.yaml": testProfileYAML("escape")})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bundle)
+	}))
+	defer server.Close()
+
+	source := NewHTTPSource(&testSourceConfig(config.ProfileSourceHTTP, server.URL, t.TempDir()).Source, sourceTestLogger())
+	_, err := source.Sync(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsafe path")
+}
+
+func TestHTTPSourceRejectsOversizedBundles(t *testing.T) {
+	bundle := testBundle(t, map[string]string{"spam.yaml": testProfileYAML("spam")})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bundle)
+	}))
+	defer server.Close()
+
+	source := NewHTTPSource(&testSourceConfig(config.ProfileSourceHTTP, server.URL, t.TempDir()).Source, sourceTestLogger())
+	source.maxSize = int64(len(bundle)) - 1
+	_, err := source.Sync(context.Background())
+	assert.ErrorIs(t, err, ErrBundleTooLarge, "the download is capped")
+
+	// A small download may still decompress into more than the cap
+	largeBundle := testBundle(t, map[string]string{"spam.yaml": testProfileYAML("spam") + strings.Repeat("# padding\n", 1000)})
+	require.Less(t, len(largeBundle), 2048)
+	bundle = largeBundle
+	source.maxSize = 4096
+	_, err = source.Sync(context.Background())
+	assert.ErrorIs(t, err, ErrBundleTooLarge, "the extracted files are capped")
+
+	source.maxSize = MaxBundleSize
+	_, err = source.Sync(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestGitSourceTracksRemoteCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	remote := t.TempDir()
+	runGit(t, remote, "init", "--quiet", "--initial-branch=main")
+	require.NoError(t, os.MkdirAll(filepath.Join(remote, "profiles"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(remote, "profiles", "spam.yaml"), []byte(testProfileYAML("spam")), 0644))
+	runGit(t, remote, "add", ".")
+	runGit(t, remote, "commit", "--quiet", "-m", "add spam")
+
+	cfg := testSourceConfig(config.ProfileSourceGit, "file://"+remote, t.TempDir())
+	cfg.Source.Ref = "main"
+	cfg.Source.Path = "profiles"
+	loader, err := NewLoaderFromConfig(cfg, sourceTestLogger())
+	require.NoError(t, err)
+
+	require.NoError(t, loader.LoadAll())
+	assert.Equal(t, []string{"spam"}, loader.ListProfiles())
+
+	require.NoError(t, os.WriteFile(filepath.Join(remote, "profiles", "work.yaml"), []byte(testProfileYAML("work")), 0644))
+	runGit(t, remote, "add", ".")
+	runGit(t, remote, "commit", "--quiet", "-m", "add work")
+
+	require.NoError(t, loader.Reload())
+	assert.Equal(t, []string{"spam", "work"}, loader.ListProfiles())
+
+	// An unreachable remote falls back to the last good checkout
+	require.NoError(t, os.RemoveAll(remote))
+	require.NoError(t, loader.Reload())
+	assert.Equal(t, []string{"spam", "work"}, loader.ListProfiles())
+}
+
+func TestNewSourceUnknownType(t *testing.T) {
+	_, err := NewSource(testSourceConfig("ftp", "ftp://example.com", t.TempDir()), sourceTestLogger())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown profile source type "ftp"`)
+}
+
+// Helper functions
+
+func sourceTestLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
+
+func testSourceConfig(sourceType, url, cacheDir string) *config.ProfilesConfig {
+	return &config.ProfilesConfig{
+		Source: config.ProfileSource{
+			Type:     sourceType,
+			URL:      url,
+			CacheDir: cacheDir,
+			Timeout:  10 * time.Second,
+		},
+	}
+}
+
+func testProfileYAML(id string) string {
+	return `
+id: "` + id + `"
+version: "1.0.0"
+model: "qwen2.5:7b"
+system: "Test system prompt"
+model_params:
+  max_tokens: 100
+  timeout_seconds: 30
+response:
+  validation:
+    confidence_range: [0.0, 1.0]
+`
+}
+
+func testBundle(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gz)
+	for name, content := range files {
+		require.NoError(t, archive.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+			ModTime:  time.Now(),
+		}))
+		_, err := archive.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+}
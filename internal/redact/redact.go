@@ -0,0 +1,190 @@
+// Package redact replaces personal data in text, such as email addresses,
+// phone numbers, US social security numbers and payment card numbers, with
+// typed placeholders like [EMAIL_1], so that deployments can keep it out of
+// model prompts and the audit log.
+//
+// Within a session, which covers one request, each distinct value gets its
+// own placeholder and keeps it wherever it appears, so the model can still
+// tell that two mentions are the same. Only the session can map the
+// placeholders back to the values.
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+// Built-in pattern names
+const (
+	PatternEmail = "email"
+	PatternPhone = "phone"
+	PatternSSN   = "ssn"
+	PatternCard  = "card"
+)
+
+// pattern is a kind of personal data and the expression matching it
+type pattern struct {
+	name   string
+	regexp *regexp.Regexp
+	// valid rejects matches that only look like the data, when set
+	valid func(match string) bool
+}
+
+// builtinPatterns are applied in this order, so that card numbers are
+// replaced before their digits can pass for phone numbers
+var builtinPatterns = []pattern{
+	{name: PatternEmail, regexp: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)},
+	{name: PatternCard, regexp: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), valid: luhn},
+	{name: PatternSSN, regexp: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), valid: validSSN},
+	{name: PatternPhone, regexp: regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{3}\) ?|\b\d{3}[ .-]?)\d{3}[ .-]?\d{4}\b`)},
+}
+
+// Redactor holds the patterns of personal data to redact. It is safe for
+// concurrent use; each request redacts through its own Session.
+type Redactor struct {
+	patterns []pattern
+}
+
+// New creates a redactor from the configured built-in and custom patterns
+func New(cfg config.RedactionConfig) (*Redactor, error) {
+	redactor := &Redactor{}
+	if len(cfg.Builtin) == 0 {
+		redactor.patterns = append(redactor.patterns, builtinPatterns...)
+	} else {
+		selected := make(map[string]bool, len(cfg.Builtin))
+		for _, name := range cfg.Builtin {
+			if !isBuiltin(name) {
+				return nil, fmt.Errorf("unknown built-in pattern %q", name)
+			}
+			selected[name] = true
+		}
+		for _, builtin := range builtinPatterns {
+			if selected[builtin.name] {
+				redactor.patterns = append(redactor.patterns, builtin)
+			}
+		}
+	}
+
+	for i, custom := range cfg.Patterns {
+		if custom.Name == "" {
+			return nil, fmt.Errorf("patterns[%d]: name is required", i)
+		}
+		if isBuiltin(custom.Name) {
+			return nil, fmt.Errorf("patterns[%d]: %q is a built-in pattern", i, custom.Name)
+		}
+		compiled, err := regexp.Compile(custom.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("patterns[%d] %s: %w", i, custom.Name, err)
+		}
+		redactor.patterns = append(redactor.patterns, pattern{name: custom.Name, regexp: compiled})
+	}
+	return redactor, nil
+}
+
+// NewSession starts redacting the texts of one request
+func (r *Redactor) NewSession() *Session {
+	return &Session{
+		redactor:     r,
+		placeholders: make(map[string]string),
+		originals:    make(map[string]string),
+		numbers:      make(map[string]int),
+	}
+}
+
+// Session redacts the texts of one request and can restore them
+type Session struct {
+	redactor *Redactor
+	// placeholders maps a pattern name and value to its placeholder
+	placeholders map[string]string
+	// originals maps a placeholder back to its value
+	originals map[string]string
+	// numbers is the last placeholder number given per pattern
+	numbers map[string]int
+	count   int
+}
+
+// Redact replaces the personal data in text with placeholders
+func (s *Session) Redact(text string) string {
+	for _, p := range s.redactor.patterns {
+		text = p.regexp.ReplaceAllStringFunc(text, func(match string) string {
+			if p.valid != nil && !p.valid(match) {
+				return match
+			}
+			s.count++
+			return s.placeholder(p.name, match)
+		})
+	}
+	return text
+}
+
+// Restore replaces the placeholders in text with the values they stand for
+func (s *Session) Restore(text string) string {
+	if len(s.originals) == 0 {
+		return text
+	}
+	pairs := make([]string, 0, 2*len(s.originals))
+	for placeholder, value := range s.originals {
+		pairs = append(pairs, placeholder, value)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// Count returns the number of matches redacted so far
+func (s *Session) Count() int {
+	return s.count
+}
+
+// placeholder returns the placeholder of a value, giving it the next number
+// of its pattern when it is new
+func (s *Session) placeholder(name, value string) string {
+	key := name + "\x00" + value
+	if placeholder, exists := s.placeholders[key]; exists {
+		return placeholder
+	}
+	s.numbers[name]++
+	placeholder := "[" + strings.ToUpper(name) + "_" + strconv.Itoa(s.numbers[name]) + "]"
+	s.placeholders[key] = placeholder
+	s.originals[placeholder] = value
+	return placeholder
+}
+
+// isBuiltin reports whether name is a built-in pattern
+func isBuiltin(name string) bool {
+	for _, builtin := range builtinPatterns {
+		if builtin.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// luhn reports whether the digits of a card number pass the Luhn checksum,
+// which tells card numbers from other long numbers such as order IDs
+func luhn(match string) bool {
+	sum, double := 0, false
+	for i := len(match) - 1; i >= 0; i-- {
+		if match[i] < '0' || match[i] > '9' {
+			continue
+		}
+		digit := int(match[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// validSSN rejects the area, group and serial numbers never assigned
+func validSSN(match string) bool {
+	area, group, serial := match[:3], match[4:6], match[7:]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
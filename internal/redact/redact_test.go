@@ -0,0 +1,83 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/config"
+)
+
+func TestBuiltinPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+		count    int
+	}{
+		{"email", "Write to jane.doe+billing@mail.example.co.uk today", "Write to [EMAIL_1] today", 1},
+		{"phone", "Call (555) 123-4567 or +1 555.987.6543", "Call [PHONE_1] or [PHONE_2]", 2},
+		{"ssn", "SSN: 123-45-6789", "SSN: [SSN_1]", 1},
+		{"unassigned ssn", "Ref 000-12-3456 and 666-12-3456", "Ref 000-12-3456 and 666-12-3456", 0},
+		{"card", "Card 4111 1111 1111 1111 was charged", "Card [CARD_1] was charged", 1},
+		{"card without separators", "Card 5500005555555559", "Card [CARD_1]", 1},
+		{"number failing luhn", "Order 4111111111111112 shipped", "Order 4111111111111112 shipped", 0},
+		{"repeated value", "a@example.com wrote to b@example.com, cc a@example.com", "[EMAIL_1] wrote to [EMAIL_2], cc [EMAIL_1]", 3},
+		{"no personal data", "Your order has shipped", "Your order has shipped", 0},
+	}
+
+	redactor, err := New(config.RedactionConfig{Enabled: true})
+	require.NoError(t, err)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := redactor.NewSession()
+			assert.Equal(t, tt.expected, session.Redact(tt.text))
+			assert.Equal(t, tt.count, session.Count())
+		})
+	}
+}
+
+func TestSessionRestore(t *testing.T) {
+	redactor, err := New(config.RedactionConfig{})
+	require.NoError(t, err)
+
+	session := redactor.NewSession()
+	body := session.Redact("Pay with 4111-1111-1111-1111, questions to help@bank.example")
+	subject := session.Redact("Receipt for help@bank.example")
+	assert.Equal(t, "Receipt for [EMAIL_1]", subject, "a session keeps a value's placeholder across texts")
+	assert.NotContains(t, body, "4111")
+
+	assert.Equal(t, "The sender help@bank.example asks for card 4111-1111-1111-1111", session.Restore("The sender [EMAIL_1] asks for card [CARD_1]"))
+	assert.Equal(t, "[EMAIL_1]", redactor.NewSession().Restore("[EMAIL_1]"), "another session cannot restore the value")
+}
+
+func TestNewSelectsPatterns(t *testing.T) {
+	redactor, err := New(config.RedactionConfig{
+		Builtin:  []string{PatternSSN},
+		Patterns: []config.RedactionPattern{{Name: "account", Pattern: `\bACCT-\d{6}\b`}},
+	})
+	require.NoError(t, err)
+
+	session := redactor.NewSession()
+	assert.Equal(t, "[SSN_1], [ACCOUNT_1], jane@example.com", session.Redact("123-45-6789, ACCT-204918, jane@example.com"))
+
+	tests := []struct {
+		name   string
+		config config.RedactionConfig
+		errMsg string
+	}{
+		{"unknown built-in", config.RedactionConfig{Builtin: []string{"passport"}}, `unknown built-in pattern "passport"`},
+		{"unnamed pattern", config.RedactionConfig{Patterns: []config.RedactionPattern{{Pattern: `\d+`}}}, "patterns[0]: name is required"},
+		{"built-in name", config.RedactionConfig{Patterns: []config.RedactionPattern{{Name: "email", Pattern: `\d+`}}}, `"email" is a built-in pattern`},
+		{"invalid expression", config.RedactionConfig{Patterns: []config.RedactionPattern{{Name: "account", Pattern: `(`}}}, "patterns[0] account"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.config)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
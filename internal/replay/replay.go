@@ -0,0 +1,305 @@
+// Package replay re-classifies emails recorded in the audit log and compares
+// the new decisions with the recorded ones, so that a model or profile change
+// can be validated offline against historical traffic before it is deployed.
+//
+// The audit log keeps only an email's subject, sender and size, so replayed
+// emails are classified on that minimal context. Agreement is therefore a
+// conservative estimate when the recorded decisions also saw the body.
+package replay
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/homoglyph"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Audit metadata keys holding the email context of a classification entry
+const (
+	MetadataSubject = "email_subject"
+	MetadataFrom    = "email_from"
+	MetadataSize    = "email_size"
+	MetadataContext = "email_context"
+)
+
+// Classifier classifies a reconstructed email
+type Classifier interface {
+	ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error)
+}
+
+// Outcome compares the recorded and replayed decisions for one email
+type Outcome struct {
+	EmailID            string  `json:"email_id"`
+	Subject            string  `json:"subject,omitempty"`
+	From               string  `json:"from,omitempty"`
+	RecordedAction     string  `json:"recorded_action"`
+	RecordedConfidence float64 `json:"recorded_confidence"`
+	ReplayedAction     string  `json:"replayed_action,omitempty"`
+	ReplayedConfidence float64 `json:"replayed_confidence,omitempty"`
+	Agreed             bool    `json:"agreed"`
+	Skipped            bool    `json:"skipped,omitempty"`
+	Error              string  `json:"error,omitempty"`
+}
+
+// Report is the agreement report of a replay
+type Report struct {
+	ProfileID       string `json:"profile_id"`
+	RecordedProfile string `json:"recorded_profile"`
+	Emails          int    `json:"emails"`
+	Replayed        int    `json:"replayed"`
+	Skipped         int    `json:"skipped"`
+	Failed          int    `json:"failed"`
+	Agreed          int    `json:"agreed"`
+
+	// AgreementRate is Agreed over Replayed
+	AgreementRate float64 `json:"agreement_rate"`
+	// MeanConfidenceDelta is the mean of replayed minus recorded confidence
+	// over the replayed emails
+	MeanConfidenceDelta float64 `json:"mean_confidence_delta"`
+	// Transitions counts replayed actions by recorded action
+	Transitions map[string]map[string]int `json:"transitions"`
+
+	Outcomes []Outcome `json:"outcomes"`
+}
+
+// Disagreements returns the replayed outcomes whose action changed
+func (r *Report) Disagreements() []Outcome {
+	var disagreements []Outcome
+	for _, outcome := range r.Outcomes {
+		if !outcome.Agreed && !outcome.Skipped && outcome.Error == "" {
+			disagreements = append(disagreements, outcome)
+		}
+	}
+	return disagreements
+}
+
+// String summarises the report in one line
+func (r *Report) String() string {
+	return fmt.Sprintf("%s vs %s: %d/%d agreed (%.1f%%), %d skipped, %d failed, mean confidence delta %+.3f",
+		r.ProfileID, r.RecordedProfile, r.Agreed, r.Replayed, r.AgreementRate*100, r.Skipped, r.Failed, r.MeanConfidenceDelta)
+}
+
+// Replayer re-classifies recorded emails
+type Replayer struct {
+	classifier Classifier
+	logger     *logrus.Logger
+}
+
+// NewReplayer creates a replayer classifying with classifier, which should
+// not write to the audit log being replayed
+func NewReplayer(classifier Classifier, logger *logrus.Logger) *Replayer {
+	return &Replayer{
+		classifier: classifier,
+		logger:     logger,
+	}
+}
+
+// RecordedQuery selects the classification entries of a profile, as recorded
+// decisions to replay
+func RecordedQuery(profileID string) audit.Query {
+	return audit.Query{
+		EventTypes: []string{audit.EventEmailClassified},
+		ProfileID:  profileID,
+	}
+}
+
+// Replay re-classifies the emails of the classification entries with
+// profile and compares each new decision with the latest recorded one. Only
+// entries recorded by recordedProfile are compared; an empty recordedProfile
+// compares against the replayed profile's own history. Emails without a
+// recorded subject or sender are skipped. Replay stops early when ctx is
+// cancelled, reporting the emails replayed so far.
+func (r *Replayer) Replay(ctx context.Context, profile *types.Profile, entries []audit.AuditEntry, recordedProfile string) *Report {
+	if recordedProfile == "" {
+		recordedProfile = profile.ID
+	}
+
+	report := &Report{
+		ProfileID:       profile.ID,
+		RecordedProfile: recordedProfile,
+		Transitions:     make(map[string]map[string]int),
+	}
+
+	var totalDelta float64
+	for _, recorded := range latestDecisions(entries, recordedProfile) {
+		if ctx.Err() != nil {
+			break
+		}
+		report.Emails++
+
+		outcome := Outcome{
+			EmailID:            recorded.EmailID,
+			RecordedAction:     recorded.Action,
+			RecordedConfidence: recorded.Confidence,
+		}
+
+		email, ok := EmailFromEntry(recorded)
+		if !ok {
+			outcome.Skipped = true
+			report.Skipped++
+			report.Outcomes = append(report.Outcomes, outcome)
+			continue
+		}
+		outcome.Subject = email.Subject
+		outcome.From = email.From
+
+		result, err := r.classifier.ClassifyEmail(ctx, profile, email)
+		if err != nil {
+			r.logger.WithError(err).WithField("email_id", email.ID).Warn("Failed to replay classification")
+			outcome.Error = err.Error()
+			report.Failed++
+			report.Outcomes = append(report.Outcomes, outcome)
+			continue
+		}
+
+		outcome.ReplayedAction = result.Action
+		outcome.ReplayedConfidence = result.Confidence
+		totalDelta += report.compared(outcome)
+	}
+	report.summarize(totalDelta)
+
+	r.logger.WithFields(logrus.Fields{
+		"profile_id":     profile.ID,
+		"emails":         report.Emails,
+		"replayed":       report.Replayed,
+		"agreement_rate": report.AgreementRate,
+	}).Info("Replay completed")
+
+	return report
+}
+
+// CompareShadow reports how often the decisions recorded for a shadow
+// profile agree with those of the active profile it runs alongside, without
+// classifying anything. The latest decision of each profile is compared for
+// every email the active profile classified; emails the shadow did not
+// classify are skipped. An empty activeProfile is taken from the shadow_of
+// recorded with the shadow's decisions. In the report the active decisions
+// are the recorded ones and the shadow decisions the replayed ones.
+func CompareShadow(entries []audit.AuditEntry, shadowProfile, activeProfile string) *Report {
+	shadowDecisions := make(map[string]*audit.AuditEntry)
+	for _, decision := range latestDecisions(entries, shadowProfile) {
+		shadowDecisions[decision.EmailID] = decision
+		if activeProfile == "" {
+			activeProfile, _ = decision.Metadata[types.MetadataShadowOf].(string)
+		}
+	}
+
+	report := &Report{
+		ProfileID:       shadowProfile,
+		RecordedProfile: activeProfile,
+		Transitions:     make(map[string]map[string]int),
+	}
+
+	var totalDelta float64
+	for _, active := range latestDecisions(entries, activeProfile) {
+		report.Emails++
+		outcome := Outcome{
+			EmailID:            active.EmailID,
+			RecordedAction:     active.Action,
+			RecordedConfidence: active.Confidence,
+		}
+		outcome.Subject, _ = active.Metadata[MetadataSubject].(string)
+		outcome.From, _ = active.Metadata[MetadataFrom].(string)
+
+		shadow, exists := shadowDecisions[active.EmailID]
+		if !exists {
+			outcome.Skipped = true
+			report.Skipped++
+			report.Outcomes = append(report.Outcomes, outcome)
+			continue
+		}
+		outcome.ReplayedAction = shadow.Action
+		outcome.ReplayedConfidence = shadow.Confidence
+		totalDelta += report.compared(outcome)
+	}
+	report.summarize(totalDelta)
+
+	return report
+}
+
+// compared adds an outcome whose decisions were both made, returning its
+// confidence delta
+func (r *Report) compared(outcome Outcome) float64 {
+	outcome.Agreed = outcome.ReplayedAction == outcome.RecordedAction
+	r.Outcomes = append(r.Outcomes, outcome)
+
+	r.Replayed++
+	if outcome.Agreed {
+		r.Agreed++
+	}
+	if r.Transitions[outcome.RecordedAction] == nil {
+		r.Transitions[outcome.RecordedAction] = make(map[string]int)
+	}
+	r.Transitions[outcome.RecordedAction][outcome.ReplayedAction]++
+	return outcome.ReplayedConfidence - outcome.RecordedConfidence
+}
+
+// summarize computes the rates once every outcome is added
+func (r *Report) summarize(totalDelta float64) {
+	if r.Replayed > 0 {
+		r.AgreementRate = float64(r.Agreed) / float64(r.Replayed)
+		r.MeanConfidenceDelta = totalDelta / float64(r.Replayed)
+	}
+}
+
+// EmailFromEntry reconstructs the email context recorded with a
+// classification entry. It reports false when the entry holds neither a
+// subject nor a sender to classify on.
+func EmailFromEntry(entry *audit.AuditEntry) (*types.Email, bool) {
+	email := &types.Email{ID: entry.EmailID}
+	email.Subject, _ = entry.Metadata[MetadataSubject].(string)
+	email.From, _ = entry.Metadata[MetadataFrom].(string)
+
+	// Sizes decode from JSON as float64
+	switch size := entry.Metadata[MetadataSize].(type) {
+	case float64:
+		email.Size = int64(size)
+	case int64:
+		email.Size = size
+	case int:
+		email.Size = int64(size)
+	}
+
+	// The caller's context decodes from JSON as map[string]interface{}
+	switch recorded := entry.Metadata[MetadataContext].(type) {
+	case map[string]string:
+		email.Context = recorded
+	case map[string]interface{}:
+		email.Context = make(map[string]string, len(recorded))
+		for key, value := range recorded {
+			if s, ok := value.(string); ok {
+				email.Context[key] = s
+			}
+		}
+	}
+	homoglyph.Annotate(email)
+
+	return email, email.Subject != "" || email.From != ""
+}
+
+// latestDecisions returns the latest classification entry of profileID for
+// each email, in order of each email's first appearance
+func latestDecisions(entries []audit.AuditEntry, profileID string) []*audit.AuditEntry {
+	latest := make(map[string]*audit.AuditEntry)
+	var order []string
+	for i := range entries {
+		entry := &entries[i]
+		if entry.EventType != audit.EventEmailClassified || entry.ProfileID != profileID || entry.EmailID == "" {
+			continue
+		}
+		if _, seen := latest[entry.EmailID]; !seen {
+			order = append(order, entry.EmailID)
+		}
+		latest[entry.EmailID] = entry
+	}
+
+	decisions := make([]*audit.AuditEntry, len(order))
+	for i, emailID := range order {
+		decisions[i] = latest[emailID]
+	}
+	return decisions
+}
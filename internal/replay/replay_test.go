@@ -0,0 +1,185 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestReplayAgreementReport(t *testing.T) {
+	dir := t.TempDir()
+	auditLogger, err := audit.NewLogger(&config.AuditConfig{Enabled: true, Directory: dir}, testLogger())
+	require.NoError(t, err)
+
+	record := func(id, subject, profileID, action string, confidence float64) {
+		email := &types.Email{ID: id, Subject: subject, From: "sender@example.com", Size: 2048, Context: map[string]string{"source": "inbox"}}
+		require.NoError(t, auditLogger.LogEmailClassification(context.Background(), email, &types.ClassificationResponse{
+			EmailID:    id,
+			ProfileID:  profileID,
+			Action:     action,
+			Confidence: confidence,
+		}))
+	}
+	record("email-1", "Weekly newsletter", "newsletter", "archive", 0.9)
+	record("email-2", "Flash sale", "newsletter", "keep", 0.6)
+	record("email-2", "Flash sale", "newsletter", "archive", 0.7) // reclassified later
+	record("email-3", "Invoice overdue", "newsletter", "keep", 0.8)
+	record("email-4", "Team lunch", "newsletter", "keep", 0.9)
+	record("email-5", "Weekly digest", "spam", "delete", 0.9) // another profile
+	require.NoError(t, auditLogger.LogClassification(context.Background(), &types.Email{ID: "email-6"}, &types.ClassificationResponse{
+		ProfileID: "newsletter",
+		Action:    "archive",
+	}))
+	require.NoError(t, auditLogger.Close())
+
+	entries, err := audit.QueryDirectory(dir, RecordedQuery("newsletter"))
+	require.NoError(t, err)
+
+	classifier := &fakeClassifier{
+		actions:  map[string]string{"Weekly newsletter": "archive", "Flash sale": "archive", "Invoice overdue": "archive"},
+		failures: map[string]bool{"Team lunch": true},
+	}
+	report := NewReplayer(classifier, testLogger()).Replay(context.Background(), &types.Profile{ID: "newsletter"}, entries, "")
+
+	assert.Equal(t, "newsletter", report.RecordedProfile)
+	assert.Equal(t, 5, report.Emails)
+	assert.Equal(t, 3, report.Replayed)
+	assert.Equal(t, 1, report.Skipped, "email-6 has no recorded subject or sender")
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, 2, report.Agreed)
+	assert.InDelta(t, 2.0/3.0, report.AgreementRate, 1e-9)
+	assert.InDelta(t, ((0.8-0.9)+(0.8-0.7)+(0.8-0.8))/3, report.MeanConfidenceDelta, 1e-9)
+	assert.Equal(t, map[string]map[string]int{
+		"archive": {"archive": 2},
+		"keep":    {"archive": 1},
+	}, report.Transitions)
+
+	// The latest recorded decision is compared and the reconstructed email
+	// carries the recorded context
+	require.Len(t, report.Outcomes, 5)
+	assert.Equal(t, "archive", report.Outcomes[1].RecordedAction)
+	assert.Equal(t, []string{"email-1", "email-2", "email-3", "email-4"}, classifier.emailIDs)
+	assert.Equal(t, "sender@example.com", classifier.emails[0].From)
+	assert.Equal(t, int64(2048), classifier.emails[0].Size)
+	assert.Equal(t, map[string]string{"source": "inbox"}, classifier.emails[0].Context)
+
+	disagreements := report.Disagreements()
+	require.Len(t, disagreements, 1)
+	assert.Equal(t, "email-3", disagreements[0].EmailID)
+	assert.Equal(t, "keep", disagreements[0].RecordedAction)
+	assert.Equal(t, "archive", disagreements[0].ReplayedAction)
+
+	assert.True(t, strings.HasPrefix(report.String(), "newsletter vs newsletter: 2/3 agreed (66.7%)"), report.String())
+}
+
+func TestReplayAgainstAnotherProfile(t *testing.T) {
+	entries := []audit.AuditEntry{
+		{EventType: audit.EventEmailClassified, EmailID: "email-1", ProfileID: "spam", Action: "delete", Confidence: 0.9, Metadata: map[string]interface{}{MetadataSubject: "You won"}},
+		{EventType: audit.EventEmailClassified, EmailID: "email-2", ProfileID: "newsletter", Action: "archive", Metadata: map[string]interface{}{MetadataSubject: "Digest"}},
+	}
+
+	classifier := &fakeClassifier{actions: map[string]string{"You won": "delete"}}
+	report := NewReplayer(classifier, testLogger()).Replay(context.Background(), &types.Profile{ID: "spam_v2"}, entries, "spam")
+
+	assert.Equal(t, "spam_v2", report.ProfileID)
+	assert.Equal(t, 1, report.Replayed)
+	assert.Equal(t, 1, report.Agreed)
+	assert.Equal(t, []string{"email-1"}, classifier.emailIDs)
+}
+
+func TestCompareShadow(t *testing.T) {
+	dir := t.TempDir()
+	auditLogger, err := audit.NewLogger(&config.AuditConfig{Enabled: true, Directory: dir}, testLogger())
+	require.NoError(t, err)
+
+	record := func(id, profileID, action string, confidence float64) {
+		result := &types.ClassificationResponse{ProfileID: profileID, Action: action, Confidence: confidence}
+		if profileID == "spam_v2" {
+			result.Metadata = map[string]interface{}{types.MetadataShadow: true, types.MetadataShadowOf: "spam"}
+		}
+		email := &types.Email{ID: id, Subject: "Subject " + id, From: "sender@example.com"}
+		require.NoError(t, auditLogger.LogEmailClassification(context.Background(), email, result))
+	}
+	record("email-1", "spam", "delete", 0.9)
+	record("email-1", "spam_v2", "delete", 0.95)
+	record("email-2", "spam", "keep", 0.8)
+	record("email-2", "spam_v2", "delete", 0.6)
+	record("email-3", "spam", "keep", 0.7) // shadow deployed after this email
+	record("email-4", "newsletter", "archive", 0.9)
+	require.NoError(t, auditLogger.Close())
+
+	entries, err := audit.QueryDirectory(dir, audit.Query{EventTypes: []string{audit.EventEmailClassified}})
+	require.NoError(t, err)
+	assert.Equal(t, true, entries[1].Metadata[types.MetadataShadow], "the shadow tag is audited")
+
+	report := CompareShadow(entries, "spam_v2", "")
+	assert.Equal(t, "spam", report.RecordedProfile, "the active profile is taken from shadow_of")
+	assert.Equal(t, 3, report.Emails)
+	assert.Equal(t, 2, report.Replayed)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, 1, report.Agreed)
+	assert.InDelta(t, ((0.95-0.9)+(0.6-0.8))/2, report.MeanConfidenceDelta, 1e-9)
+	assert.Equal(t, map[string]map[string]int{
+		"delete": {"delete": 1},
+		"keep":   {"delete": 1},
+	}, report.Transitions)
+
+	disagreements := report.Disagreements()
+	require.Len(t, disagreements, 1)
+	assert.Equal(t, "email-2", disagreements[0].EmailID)
+	assert.Equal(t, "Subject email-2", disagreements[0].Subject)
+}
+
+func TestReplayStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	entries := []audit.AuditEntry{
+		{EventType: audit.EventEmailClassified, EmailID: "email-1", ProfileID: "spam", Metadata: map[string]interface{}{MetadataSubject: "Hello"}},
+	}
+	classifier := &fakeClassifier{}
+	report := NewReplayer(classifier, testLogger()).Replay(ctx, &types.Profile{ID: "spam"}, entries, "")
+
+	assert.Zero(t, report.Emails)
+	assert.Empty(t, classifier.emailIDs)
+}
+
+// Helper functions
+
+// fakeClassifier returns the configured action for each subject with
+// confidence 0.8, failing for the configured subjects
+type fakeClassifier struct {
+	actions  map[string]string
+	failures map[string]bool
+	emails   []*types.Email
+	emailIDs []string
+}
+
+func (f *fakeClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	f.emails = append(f.emails, email)
+	f.emailIDs = append(f.emailIDs, email.ID)
+	if f.failures[email.Subject] {
+		return nil, fmt.Errorf("model unavailable")
+	}
+	return &types.ClassificationResponse{
+		EmailID:    email.ID,
+		ProfileID:  profile.ID,
+		Action:     f.actions[email.Subject],
+		Confidence: 0.8,
+	}, nil
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
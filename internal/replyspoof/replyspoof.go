@@ -0,0 +1,223 @@
+// Package replyspoof flags emails whose subject claims a reply or a
+// forward, such as "Re: Wire transfer", that the email itself contradicts.
+// Business email compromise often fakes a thread this way, pasting a made
+// up conversation below a "Re:" to borrow the trust of an earlier exchange.
+//
+// A reply must carry an In-Reply-To or References header, and a forward
+// either those headers or the forwarded message. A threaded email the mail
+// provider threads alone references messages the mailbox does not have.
+// The quoted original must not be attributed to the sender's own name at
+// another address, and the original a reply quotes must be from its sender
+// or one of its recipients, except on mailing lists.
+//
+// A flag is a signal, not a verdict: replies to deleted messages and some
+// clients trip it too.
+package replyspoof
+
+import (
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// subjectPrefix matches a reply or forward prefix at the start of a
+// subject, after any tag such as "[EXTERNAL]", in English and the most
+// common localized forms
+var subjectPrefix = regexp.MustCompile(`(?i)^\s*(?:\[[^\]]*\]\s*)?(re|aw|sv|antw|ynt|fwd?|wg|tr|rv|enc|doorst)\s*(?:\[\d+\]|\(\d+\))?\s*:`)
+
+// replyPrefixes are the subjectPrefix forms claiming a reply rather than a
+// forward
+var replyPrefixes = map[string]bool{"re": true, "aw": true, "sv": true, "antw": true, "ynt": true}
+
+// attribution matches the line introducing a quoted reply, such as "On Mon,
+// Jan 15, 2024 at 5:00 PM Jane Doe <jane@example.com> wrote:"
+var attribution = regexp.MustCompile(`(?i)^on\s(.+)\swrote:$`)
+
+// forwardMarkers introduce a forwarded or quoted original whose headers
+// follow, as Gmail, Apple Mail and Outlook write them
+var forwardMarkers = []string{
+	"---------- forwarded message ---------",
+	"begin forwarded message:",
+	"-----original message-----",
+	"________________________________",
+}
+
+// maxHeaderLines bounds how far below a forward marker its From line is
+// looked for
+const maxHeaderLines = 5
+
+// Analyze checks an email whose subject claims a reply or a forward, and
+// returns nil for any other email
+func Analyze(email *types.Email) *types.ReplyAnalysis {
+	match := subjectPrefix.FindStringSubmatch(email.Subject)
+	if match == nil {
+		return nil
+	}
+
+	analysis := &types.ReplyAnalysis{
+		Kind:     types.ReplyKindForward,
+		Threaded: email.InReplyTo != "" || len(email.References) > 0,
+	}
+	if replyPrefixes[strings.ToLower(match[1])] {
+		analysis.Kind = types.ReplyKindReply
+	}
+	quoted, forwarded := quotedSender(email.Body)
+	if quoted != nil {
+		analysis.QuotedFrom = quoted.Address
+	}
+
+	switch {
+	case analysis.Threaded:
+		if alone(email) {
+			analysis.Reasons = append(analysis.Reasons, types.ReplyNoThread)
+		}
+	case analysis.Kind == types.ReplyKindReply || !forwarded:
+		analysis.Reasons = append(analysis.Reasons, types.ReplyMissingHeaders)
+	}
+	if quoted != nil && quotedMismatch(email, analysis.Kind, quoted) {
+		analysis.Reasons = append(analysis.Reasons, types.ReplyQuotedSender)
+	}
+	analysis.Spoofed = len(analysis.Reasons) > 0
+	return analysis
+}
+
+// Annotate sets the reply analysis of an email, replacing any it carries
+func Annotate(email *types.Email) {
+	email.Reply = Analyze(email)
+}
+
+// alone reports whether the mail provider started a thread with the email,
+// which it identifies by the email's own ID or Message-ID. An email whose
+// thread is unknown is not alone.
+func alone(email *types.Email) bool {
+	if email.ThreadID == "" {
+		return false
+	}
+	return email.ThreadID == email.ID || email.ThreadID == email.MessageID
+}
+
+// quotedSender returns the sender of the first original quoted in body,
+// when its attribution names an address, and whether body holds a forwarded
+// or quoted message below a forward marker
+func quotedSender(body string) (*mail.Address, bool) {
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	for i := range lines {
+		line := unquote(lines[i])
+		// Attributions too long for one line are wrapped onto the next
+		if match := attribution.FindStringSubmatch(line); match != nil {
+			return attributed(match[1]), false
+		}
+		if i+1 < len(lines) {
+			if match := attribution.FindStringSubmatch(line + " " + unquote(lines[i+1])); match != nil {
+				return attributed(match[1]), false
+			}
+		}
+
+		lower := strings.ToLower(line)
+		for _, marker := range forwardMarkers {
+			if !strings.HasPrefix(lower, marker) {
+				continue
+			}
+			for _, header := range lines[i+1 : min(len(lines), i+1+maxHeaderLines)] {
+				header = strings.Trim(unquote(header), "*")
+				if value, ok := cutPrefixFold(header, "from:"); ok {
+					value = strings.TrimPrefix(strings.TrimSpace(value), "*")
+					if address, err := mail.ParseAddress(strings.TrimSpace(value)); err == nil {
+						return address, true
+					}
+					return nil, true
+				}
+			}
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+// attributed parses the sender named by the text of an attribution between
+// "On" and "wrote:". The date before the name ends with a comma or with AM
+// or PM, as in "Jan 15, 2024, at 17:00, Jane Doe <jane@example.com>" or
+// "Mon, Jan 15, 2024 at 5:00 PM Jane Doe <jane@example.com>".
+func attributed(text string) *mail.Address {
+	open := strings.LastIndexByte(text, '<')
+	end := strings.LastIndexByte(text, '>')
+	if open < 0 || end < open {
+		return nil
+	}
+	address := &mail.Address{Address: strings.TrimSpace(text[open+1 : end])}
+	if !strings.Contains(address.Address, "@") {
+		return nil
+	}
+
+	name := strings.TrimSpace(text[:open])
+	if strings.HasSuffix(name, `"`) {
+		// A quoted name may hold commas of its own
+		if quote := strings.LastIndexByte(name[:len(name)-1], '"'); quote >= 0 {
+			address.Name = name[quote+1 : len(name)-1]
+			return address
+		}
+	}
+	for _, separator := range []string{",", " AM ", " PM "} {
+		if i := strings.LastIndex(name, separator); i >= 0 {
+			name = name[i+len(separator):]
+		}
+	}
+	address.Name = strings.TrimSpace(name)
+	return address
+}
+
+// quotedMismatch reports whether the quoted original is attributed to the
+// sender's name at another address or, for a reply that is not from a
+// mailing list, to someone who is neither its sender nor a recipient
+func quotedMismatch(email *types.Email, kind string, quoted *mail.Address) bool {
+	sender, err := mail.ParseAddress(email.From)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(sender.Address, quoted.Address) {
+		return false
+	}
+	if sender.Name != "" && strings.EqualFold(strings.Join(strings.Fields(sender.Name), " "), strings.Join(strings.Fields(quoted.Name), " ")) {
+		return true
+	}
+	if kind != types.ReplyKindReply || listMail(email) {
+		return false
+	}
+
+	for _, recipient := range append(append([]string{}, email.To...), email.CC...) {
+		address := recipient
+		if parsed, err := mail.ParseAddress(recipient); err == nil {
+			address = parsed.Address
+		}
+		if strings.EqualFold(address, quoted.Address) {
+			return false
+		}
+	}
+	return true
+}
+
+// listMail reports whether the email was sent through a mailing list, whose
+// replies quote members who are not recipients
+func listMail(email *types.Email) bool {
+	for name := range email.Headers {
+		if strings.EqualFold(name, "List-Id") || strings.EqualFold(name, "List-Post") {
+			return true
+		}
+	}
+	return false
+}
+
+// unquote strips the leading ">" quote markers and spaces of a line
+func unquote(line string) string {
+	return strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "> "))
+}
+
+// cutPrefixFold is strings.CutPrefix ignoring case
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
@@ -0,0 +1,119 @@
+package replyspoof
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/testutil"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestAnalyzeFixtures(t *testing.T) {
+	td := testutil.LoadTestData(t)
+
+	genuine := Analyze(td.GetTestEmail("test-email-013"))
+	require.NotNil(t, genuine)
+	assert.Equal(t, &types.ReplyAnalysis{Kind: types.ReplyKindReply, Threaded: true, QuotedFrom: "sam.ortiz@company.example"}, genuine)
+
+	spoofed := Analyze(td.GetTestEmail("test-email-014"))
+	require.NotNil(t, spoofed)
+	assert.True(t, spoofed.Spoofed)
+	assert.False(t, spoofed.Threaded)
+	assert.Equal(t, "michael.grant@company.example", spoofed.QuotedFrom)
+	assert.Equal(t, []string{types.ReplyMissingHeaders, types.ReplyQuotedSender}, spoofed.Reasons)
+
+	assert.Nil(t, Analyze(td.GetTestEmail("test-email-001")), "emails not claiming a reply are not analyzed")
+}
+
+func TestAnalyze(t *testing.T) {
+	const quote = "Sounds good.\n\nOn Mon, Jan 15, 2024 at 5:00 PM Jane Doe <jane@example.com> wrote:\n> Shall we meet?"
+	tests := []struct {
+		name    string
+		email   types.Email
+		kind    string
+		reasons []string
+	}{
+		{
+			name:  "threaded reply",
+			email: types.Email{Subject: "Re: Meeting", From: "bob@example.com", To: []string{"Jane Doe <jane@example.com>"}, InReplyTo: "m1@example.com", Body: quote},
+			kind:  types.ReplyKindReply,
+		},
+		{
+			name:    "reply without headers",
+			email:   types.Email{Subject: "Re: Meeting", From: "bob@example.com", To: []string{"jane@example.com"}, Body: quote},
+			kind:    types.ReplyKindReply,
+			reasons: []string{types.ReplyMissingHeaders},
+		},
+		{
+			name:    "reply alone in its thread",
+			email:   types.Email{ID: "msg-9", ThreadID: "msg-9", Subject: "RE: Meeting", From: "bob@example.com", References: []string{"m1@example.com"}},
+			kind:    types.ReplyKindReply,
+			reasons: []string{types.ReplyNoThread},
+		},
+		{
+			name:  "localized prefix after a tag",
+			email: types.Email{Subject: "[EXTERNAL] AW: Meeting", From: "bob@example.com", InReplyTo: "m1@example.com"},
+			kind:  types.ReplyKindReply,
+		},
+		{
+			name:    "reply quoting someone outside the conversation",
+			email:   types.Email{Subject: "Re: Meeting", From: "bob@example.com", To: []string{"ap@example.com"}, InReplyTo: "m1@example.com", Body: quote},
+			kind:    types.ReplyKindReply,
+			reasons: []string{types.ReplyQuotedSender},
+		},
+		{
+			name: "mailing list reply",
+			email: types.Email{Subject: "Re: Meeting", From: "bob@example.com", To: []string{"team@lists.example.com"}, InReplyTo: "m1@example.com", Body: quote,
+				Headers: map[string]string{"List-Id": "<team.lists.example.com>"}},
+			kind: types.ReplyKindReply,
+		},
+		{
+			name:  "wrapped attribution",
+			email: types.Email{Subject: "Re: Meeting", From: "bob@example.com", To: []string{"jane@example.com"}, InReplyTo: "m1@example.com", Body: "OK\n\nOn Jan 15, 2024, at 17:00, Jane Doe <jane@example.com>\nwrote:\n> Shall we meet?"},
+			kind:  types.ReplyKindReply,
+		},
+		{
+			name: "quoted name with a comma",
+			email: types.Email{Subject: "Re: Meeting", From: `"Doe, Jane" <jane.doe@example.net>`, To: []string{"bob@example.com"}, InReplyTo: "m1@example.com",
+				Body: "Yes\n\nOn Jan 15, 2024, at 17:00, \"Doe, Jane\" <jane@example.com> wrote:\n> Shall we meet?"},
+			kind:    types.ReplyKindReply,
+			reasons: []string{types.ReplyQuotedSender},
+		},
+		{
+			name:  "forward with the forwarded message",
+			email: types.Email{Subject: "Fwd: Invoice", From: "bob@example.com", Body: "FYI\n\n---------- Forwarded message ---------\nFrom: Billing <billing@vendor.example>\nDate: Mon, Jan 15, 2024\nSubject: Invoice"},
+			kind:  types.ReplyKindForward,
+		},
+		{
+			name:    "forward without the forwarded message",
+			email:   types.Email{Subject: "FW: Invoice", From: "bob@example.com", Body: "Please pay the attached invoice today."},
+			kind:    types.ReplyKindForward,
+			reasons: []string{types.ReplyMissingHeaders},
+		},
+		{
+			name:    "forward impersonating the original sender",
+			email:   types.Email{Subject: "Fwd: Invoice", From: "Billing <billing@vendor-payments.example>", Body: "-----Original Message-----\nFrom: Billing <billing@vendor.example>\nSent: Monday"},
+			kind:    types.ReplyKindForward,
+			reasons: []string{types.ReplyQuotedSender},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis := Analyze(&tt.email)
+			require.NotNil(t, analysis)
+			assert.Equal(t, tt.kind, analysis.Kind)
+			assert.Equal(t, tt.reasons, analysis.Reasons)
+			assert.Equal(t, len(tt.reasons) > 0, analysis.Spoofed)
+		})
+	}
+}
+
+func TestAnnotateReplacesAnalysis(t *testing.T) {
+	email := &types.Email{Subject: "Quarterly report", Reply: &types.ReplyAnalysis{Spoofed: true}}
+	Annotate(email)
+	assert.Nil(t, email.Reply)
+	assert.False(t, email.IsSpoofedReply())
+}
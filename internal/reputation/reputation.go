@@ -0,0 +1,204 @@
+// Package reputation scores email senders from static allowlists, blocklists
+// and an optional file of per-address and per-domain trust scores.
+package reputation
+
+import (
+	"fmt"
+	"net/mail"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Trust scores assigned by list membership and to unknown senders
+const (
+	AllowlistedScore = 1.0
+	BlockedScore     = 0.0
+	NeutralScore     = 0.5
+)
+
+// Sender is the reputation of a single sender address
+type Sender struct {
+	Address     string  `json:"address"`
+	Domain      string  `json:"domain"`
+	TrustScore  float64 `json:"trust_score"`
+	Allowlisted bool    `json:"allowlisted"`
+	Blocked     bool    `json:"blocked"`
+	Known       bool    `json:"known"`
+}
+
+// Reputation looks up sender reputations. A nil Reputation treats every
+// sender as unknown.
+type Reputation struct {
+	allowlist map[string]bool
+	blocklist map[string]bool
+	scores    map[string]float64
+}
+
+// New builds a reputation source from configuration, loading the scores
+// file if one is configured
+func New(cfg types.ReputationConfig) (*Reputation, error) {
+	r := &Reputation{
+		allowlist: normalizeEntries(cfg.Allowlist),
+		blocklist: normalizeEntries(cfg.Blocklist),
+		scores:    make(map[string]float64),
+	}
+
+	if cfg.ScoresFile != "" {
+		scores, err := LoadScores(cfg.ScoresFile)
+		if err != nil {
+			return nil, err
+		}
+		for entry, score := range scores {
+			r.scores[normalizeEntry(entry)] = score
+		}
+	}
+
+	return r, nil
+}
+
+// LoadScores reads a YAML or JSON map of addresses and domains to trust
+// scores between 0 and 1
+func LoadScores(path string) (map[string]float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scores file: %w", err)
+	}
+
+	var scores map[string]float64
+	if err := yaml.Unmarshal(data, &scores); err != nil {
+		return nil, fmt.Errorf("failed to parse scores file %s: %w", path, err)
+	}
+
+	for entry, score := range scores {
+		if score < 0 || score > 1 {
+			return nil, fmt.Errorf("score for %s in %s must be between 0 and 1, got %g", entry, path, score)
+		}
+	}
+	return scores, nil
+}
+
+// Lookup returns the reputation of the sender in a From header. The most
+// specific matching entry wins: an exact address over its domain, and a
+// subdomain over its parent. When an allowlist and a blocklist entry are
+// equally specific the sender is blocked.
+func (r *Reputation) Lookup(from string) Sender {
+	sender := Sender{Address: NormalizeAddress(from), TrustScore: NeutralScore}
+	if at := strings.LastIndex(sender.Address, "@"); at >= 0 {
+		sender.Domain = sender.Address[at+1:]
+	}
+	if r == nil || sender.Address == "" {
+		return sender
+	}
+
+	keys := lookupKeys(sender.Address, sender.Domain)
+	allowed := matchIndex(r.allowlist, keys)
+	blocked := matchIndex(r.blocklist, keys)
+
+	switch {
+	case blocked >= 0 && (allowed < 0 || blocked <= allowed):
+		sender.Blocked = true
+		sender.Known = true
+		sender.TrustScore = BlockedScore
+		return sender
+	case allowed >= 0:
+		sender.Allowlisted = true
+		sender.Known = true
+		sender.TrustScore = AllowlistedScore
+		return sender
+	}
+
+	for _, key := range keys {
+		if score, exists := r.scores[key]; exists {
+			sender.Known = true
+			sender.TrustScore = score
+			break
+		}
+	}
+	return sender
+}
+
+// Allowlisted reports whether the sender in a From header is allowlisted
+func (r *Reputation) Allowlisted(from string) bool {
+	return r.Lookup(from).Allowlisted
+}
+
+// BlockedHost reports whether a host, such as that of a link, or one of its
+// parent domains is on the blocklist
+func (r *Reputation) BlockedHost(host string) bool {
+	host = normalizeEntry(host)
+	if r == nil || host == "" {
+		return false
+	}
+	return matchIndex(r.blocklist, lookupKeys(host, host)[1:]) >= 0
+}
+
+// Blocklist returns the entries of the blocklist, sorted
+func (r *Reputation) Blocklist() []string {
+	if r == nil {
+		return nil
+	}
+	entries := make([]string, 0, len(r.blocklist))
+	for entry := range r.blocklist {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// NormalizeAddress returns the lowercased bare address of a From header,
+// without its display name
+func NormalizeAddress(from string) string {
+	address := strings.TrimSpace(from)
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		address = parsed.Address
+	}
+	return strings.TrimSuffix(strings.ToLower(address), ".")
+}
+
+// lookupKeys lists the entries that could match an address, most specific
+// first: the address, then its domain and each parent domain
+func lookupKeys(address, domain string) []string {
+	keys := []string{address}
+	labels := strings.Split(domain, ".")
+	for i := 0; i < len(labels)-1; i++ {
+		keys = append(keys, strings.Join(labels[i:], "."))
+	}
+	return keys
+}
+
+// matchIndex returns the position in keys of the first key in the list, or
+// -1 when none is
+func matchIndex(list map[string]bool, keys []string) int {
+	for i, key := range keys {
+		if list[key] {
+			return i
+		}
+	}
+	return -1
+}
+
+func normalizeEntries(entries []string) map[string]bool {
+	normalized := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if entry = normalizeEntry(entry); entry != "" {
+			normalized[entry] = true
+		}
+	}
+	return normalized
+}
+
+// normalizeEntry lowercases an address or domain entry, accepting domains
+// written with a leading "@" or "*."
+func normalizeEntry(entry string) string {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	entry = strings.TrimPrefix(entry, "*.")
+	if strings.HasPrefix(entry, "@") {
+		entry = entry[1:]
+	}
+	return strings.TrimSuffix(entry, ".")
+}
@@ -0,0 +1,153 @@
+package reputation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		from     string
+		expected string
+	}{
+		{"alice@example.com", "alice@example.com"},
+		{"Alice Smith <Alice@Example.COM>", "alice@example.com"},
+		{`"Smith, Alice" <alice@example.com>`, "alice@example.com"},
+		{"  ALICE@example.com.  ", "alice@example.com"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			assert.Equal(t, tt.expected, NormalizeAddress(tt.from))
+		})
+	}
+}
+
+func TestLookupMatching(t *testing.T) {
+	senders, err := New(types.ReputationConfig{
+		Allowlist: []string{"boss@corp.example.com", "@partner.example", "*.trusted.example"},
+		Blocklist: []string{"example.net", "intern@partner.example"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		from        string
+		allowlisted bool
+		blocked     bool
+		score       float64
+	}{
+		{"exact address", "The Boss <BOSS@corp.example.com>", true, false, AllowlistedScore},
+		{"exact address does not cover its domain", "colleague@corp.example.com", false, false, NeutralScore},
+		{"domain entry", "sales@partner.example", true, false, AllowlistedScore},
+		{"domain entry covers subdomains", "noreply@mail.partner.example", true, false, AllowlistedScore},
+		{"wildcard domain entry", "it@trusted.example", true, false, AllowlistedScore},
+		{"domain entry needs a label boundary", "bob@notpartner.example", false, false, NeutralScore},
+		{"blocked domain", "spammer@bulk.example.net", false, true, BlockedScore},
+		{"blocked address beats allowlisted domain", "Intern <intern@partner.example>", false, true, BlockedScore},
+		{"unknown sender", "stranger@elsewhere.example", false, false, NeutralScore},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := senders.Lookup(tt.from)
+			assert.Equal(t, tt.allowlisted, sender.Allowlisted)
+			assert.Equal(t, tt.blocked, sender.Blocked)
+			assert.Equal(t, tt.score, sender.TrustScore)
+			assert.Equal(t, tt.allowlisted || tt.blocked, sender.Known)
+		})
+	}
+}
+
+func TestLookupBlocklistWinsTies(t *testing.T) {
+	senders, err := New(types.ReputationConfig{
+		Allowlist: []string{"example.com"},
+		Blocklist: []string{"EXAMPLE.com"},
+	})
+	require.NoError(t, err)
+
+	sender := senders.Lookup("a@example.com")
+	assert.True(t, sender.Blocked)
+	assert.False(t, sender.Allowlisted)
+}
+
+func TestLookupScoresFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scores.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+example.com: 0.6
+mail.example.com: 0.7
+ceo@example.com: 0.95
+`), 0644))
+
+	senders, err := New(types.ReputationConfig{
+		Blocklist:  []string{"spam@mail.example.com"},
+		ScoresFile: path,
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		from  string
+		score float64
+		known bool
+	}{
+		{"CEO <ceo@example.com>", 0.95, true},
+		{"news@mail.example.com", 0.7, true},
+		{"ops@example.com", 0.6, true},
+		{"ops@deep.sub.example.com", 0.6, true},
+		{"spam@mail.example.com", BlockedScore, true},
+		{"someone@other.example", NeutralScore, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			sender := senders.Lookup(tt.from)
+			assert.Equal(t, tt.score, sender.TrustScore)
+			assert.Equal(t, tt.known, sender.Known)
+		})
+	}
+}
+
+func TestLoadScoresErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := LoadScores(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+
+	path := filepath.Join(dir, "scores.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("example.com: 1.5\n"), 0644))
+	_, err = LoadScores(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must be between 0 and 1")
+}
+
+func TestNilReputation(t *testing.T) {
+	var senders *Reputation
+
+	sender := senders.Lookup("Alice <alice@example.com>")
+	assert.Equal(t, "alice@example.com", sender.Address)
+	assert.Equal(t, "example.com", sender.Domain)
+	assert.Equal(t, NeutralScore, sender.TrustScore)
+	assert.False(t, senders.Allowlisted("alice@example.com"))
+	assert.False(t, senders.BlockedHost("example.com"))
+	assert.Empty(t, senders.Blocklist())
+}
+
+func TestBlockedHost(t *testing.T) {
+	senders, err := New(types.ReputationConfig{Blocklist: []string{"*.Evil.example", "@spam.example", "bad@corp.example"}})
+	require.NoError(t, err)
+
+	assert.True(t, senders.BlockedHost("evil.example"))
+	assert.True(t, senders.BlockedHost("login.EVIL.example."))
+	assert.True(t, senders.BlockedHost("spam.example"))
+	assert.False(t, senders.BlockedHost("notevil.example"))
+	assert.False(t, senders.BlockedHost("corp.example"), "address entries do not block their domain")
+	assert.False(t, senders.BlockedHost(""))
+	assert.Equal(t, []string{"bad@corp.example", "evil.example", "spam.example"}, senders.Blocklist())
+}
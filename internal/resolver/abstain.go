@@ -0,0 +1,48 @@
+package resolver
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// applyConfidenceFloor returns result unchanged when its confidence meets the
+// configured floor. Otherwise the resolver abstains: it returns a copy
+// carrying the abstain action, flagged in metadata with the action it would
+// have taken, so the email is routed to human review instead of being acted
+// on automatically.
+func (r *PolicyResolver) applyConfidenceFloor(email *types.Email, result *types.ClassificationResponse, trace *Explanation) *types.ClassificationResponse {
+	floor := r.config.ConfidenceFloor
+	if floor <= 0 || result.Confidence >= floor {
+		return result
+	}
+
+	action := r.config.AbstainAction
+	if action == "" {
+		action = types.ActionReview
+	}
+
+	r.logger.WithFields(logrus.Fields{
+		"email_id":        email.ID,
+		"proposed_action": result.Action,
+		"confidence":      result.Confidence,
+		"floor":           floor,
+	}).Info("Confidence below floor, abstaining")
+
+	trace.Abstained = true
+	trace.ProposedAction = result.Action
+
+	abstained := *result
+	abstained.Action = action
+	abstained.Reasoning = fmt.Sprintf("Abstained: confidence %.2f is below the %.2f floor (proposed %s: %s)",
+		result.Confidence, floor, result.Action, result.Reasoning)
+	abstained.Metadata = make(map[string]interface{}, len(result.Metadata)+2)
+	for key, value := range result.Metadata {
+		abstained.Metadata[key] = value
+	}
+	abstained.Metadata[types.MetadataAbstained] = true
+	abstained.Metadata[types.MetadataProposedAction] = result.Action
+	return &abstained
+}
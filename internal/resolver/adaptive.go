@@ -0,0 +1,286 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Feedback signals adaptive weighting learns from
+const (
+	// FeedbackAgreement scores each profile by whether its action agrees
+	// with the decision the resolver reaches
+	FeedbackAgreement = "agreement"
+	// FeedbackExternal only learns from RecordFeedback, such as the
+	// corrections of a human reviewer
+	FeedbackExternal = "external"
+)
+
+// Adaptive weighting defaults, used when the configuration leaves them unset
+const (
+	DefaultLearningRate = 0.1
+	DefaultMinWeight    = 0.5
+	DefaultMaxWeight    = 1.5
+)
+
+// AdaptiveState is the persisted form of the adaptive weights: each
+// profile's current weight and the number of feedback signals it received
+type AdaptiveState struct {
+	Weights  map[string]float64 `json:"weights"`
+	Feedback map[string]int     `json:"feedback"`
+}
+
+// AdaptiveWeights tracks profile weights that move with feedback. A profile
+// starts at its static weight, or 1.0, clamped into [min, max]. Each signal
+// then moves its weight a learning rate's share of the way toward max when
+// the profile was right and toward min when it was wrong:
+//
+//	weight += rate * (target - weight)
+//
+// so the weights stay within bounds and the same feedback in the same order
+// always yields the same weights.
+type AdaptiveWeights struct {
+	mutex     sync.Mutex
+	agreement bool
+	rate      float64
+	minWeight float64
+	maxWeight float64
+	baseline  map[string]float64
+	stateFile string
+	state     AdaptiveState
+}
+
+// NewAdaptiveWeights creates adaptive weights starting from the static
+// profile weights of cfg, resuming from the state file when one exists
+func NewAdaptiveWeights(cfg types.ConfidenceWeighting) (*AdaptiveWeights, error) {
+	adaptive := cfg.Adaptive
+	a := &AdaptiveWeights{
+		agreement: adaptive.Feedback == "" || adaptive.Feedback == FeedbackAgreement,
+		rate:      adaptive.LearningRate,
+		minWeight: adaptive.MinWeight,
+		maxWeight: adaptive.MaxWeight,
+		baseline:  cfg.ProfileWeights,
+		stateFile: adaptive.StateFile,
+		state:     AdaptiveState{Weights: make(map[string]float64), Feedback: make(map[string]int)},
+	}
+	if a.rate == 0 {
+		a.rate = DefaultLearningRate
+	}
+	if a.minWeight == 0 {
+		a.minWeight = DefaultMinWeight
+	}
+	if a.maxWeight == 0 {
+		a.maxWeight = DefaultMaxWeight
+	}
+
+	switch adaptive.Feedback {
+	case "", FeedbackAgreement, FeedbackExternal:
+	default:
+		return nil, fmt.Errorf("unknown adaptive weighting feedback %q", adaptive.Feedback)
+	}
+	if a.rate < 0 || a.rate > 1 {
+		return nil, fmt.Errorf("adaptive weighting learning_rate must be between 0 and 1, got %g", a.rate)
+	}
+	if a.minWeight < 0 || a.minWeight > a.maxWeight {
+		return nil, fmt.Errorf("adaptive weighting needs 0 <= min_weight <= max_weight, got %g and %g", a.minWeight, a.maxWeight)
+	}
+
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Weight returns the current weight of a profile
+func (a *AdaptiveWeights) Weight(profileID string) float64 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.weight(profileID)
+}
+
+// Weights returns a copy of the state: the weights of the profiles that
+// received feedback and their feedback counts
+func (a *AdaptiveWeights) Weights() AdaptiveState {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	snapshot := AdaptiveState{
+		Weights:  make(map[string]float64, len(a.state.Weights)),
+		Feedback: make(map[string]int, len(a.state.Feedback)),
+	}
+	for profileID, weight := range a.state.Weights {
+		snapshot.Weights[profileID] = weight
+	}
+	for profileID, count := range a.state.Feedback {
+		snapshot.Feedback[profileID] = count
+	}
+	return snapshot
+}
+
+// LearnsFromAgreement reports whether the weights learn from agreement with
+// resolved decisions
+func (a *AdaptiveWeights) LearnsFromAgreement() bool {
+	return a.agreement
+}
+
+// Record applies one feedback signal per profile, in profile order, and
+// persists the new weights
+func (a *AdaptiveWeights) Record(correct map[string]bool) error {
+	if len(correct) == 0 {
+		return nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	profileIDs := make([]string, 0, len(correct))
+	for profileID := range correct {
+		profileIDs = append(profileIDs, profileID)
+	}
+	sort.Strings(profileIDs)
+
+	for _, profileID := range profileIDs {
+		target := a.minWeight
+		if correct[profileID] {
+			target = a.maxWeight
+		}
+		weight := a.weight(profileID)
+		a.state.Weights[profileID] = clamp(weight+a.rate*(target-weight), a.minWeight, a.maxWeight)
+		a.state.Feedback[profileID]++
+	}
+	return a.save()
+}
+
+// weight returns the current weight of a profile; the mutex must be held
+func (a *AdaptiveWeights) weight(profileID string) float64 {
+	if weight, exists := a.state.Weights[profileID]; exists {
+		return weight
+	}
+	baseline, exists := a.baseline[profileID]
+	if !exists {
+		baseline = 1.0
+	}
+	return clamp(baseline, a.minWeight, a.maxWeight)
+}
+
+// load reads the state file, if any. Persisted weights are clamped into
+// the current bounds, which may have changed since they were saved.
+func (a *AdaptiveWeights) load() error {
+	if a.stateFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(a.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read adaptive weights: %w", err)
+	}
+
+	var state AdaptiveState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse adaptive weights %s: %w", a.stateFile, err)
+	}
+	for profileID, weight := range state.Weights {
+		a.state.Weights[profileID] = clamp(weight, a.minWeight, a.maxWeight)
+	}
+	for profileID, count := range state.Feedback {
+		a.state.Feedback[profileID] = count
+	}
+	return nil
+}
+
+// save atomically replaces the state file; the mutex must be held
+func (a *AdaptiveWeights) save() error {
+	if a.stateFile == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(a.stateFile), 0755); err != nil {
+		return fmt.Errorf("failed to create adaptive weights directory: %w", err)
+	}
+
+	data, err := json.Marshal(a.state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal adaptive weights: %w", err)
+	}
+
+	tmp := a.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write adaptive weights: %w", err)
+	}
+	if err := os.Rename(tmp, a.stateFile); err != nil {
+		return fmt.Errorf("failed to replace adaptive weights: %w", err)
+	}
+	return nil
+}
+
+// clamp limits value to [low, high]
+func clamp(value, low, high float64) float64 {
+	if value < low {
+		return low
+	}
+	if value > high {
+		return high
+	}
+	return value
+}
+
+// profileWeight returns the weight of a profile's results: its adaptive
+// weight when adaptive weighting is enabled, otherwise its static weight. It
+// reports false when the profile has neither.
+func (r *PolicyResolver) profileWeight(profileID string) (float64, bool) {
+	if r.adaptive != nil {
+		return r.adaptive.Weight(profileID), true
+	}
+	weight, exists := r.config.ConfidenceWeighting.ProfileWeights[profileID]
+	return weight, exists
+}
+
+// AdaptiveEnabled reports whether profile weights move with feedback
+func (r *PolicyResolver) AdaptiveEnabled() bool {
+	return r.adaptive != nil
+}
+
+// RecordFeedback moves the adaptive weights of profiles by whether their
+// results were correct, keyed by profile ID, and returns the new state
+func (r *PolicyResolver) RecordFeedback(correct map[string]bool) (AdaptiveState, error) {
+	if r.adaptive == nil {
+		return AdaptiveState{}, fmt.Errorf("adaptive weighting is not enabled")
+	}
+	if err := r.adaptive.Record(correct); err != nil {
+		return AdaptiveState{}, err
+	}
+	return r.adaptive.Weights(), nil
+}
+
+// learnFromAgreement records, for each profile with a result, whether its
+// action agrees with a decision reached by confidence weighting. Single
+// results, priority rules and abstentions do not reflect on the profiles
+// and teach nothing.
+func (r *PolicyResolver) learnFromAgreement(email *types.Email, results []*types.ClassificationResponse, trace *Explanation) {
+	if r.adaptive == nil || !r.adaptive.LearnsFromAgreement() {
+		return
+	}
+	if trace.Method == MethodSingleResult || trace.Method == MethodPriorityRule || trace.Abstained {
+		return
+	}
+
+	correct := make(map[string]bool, len(results))
+	for _, result := range results {
+		if result.ProfileID == "" {
+			continue
+		}
+		// A profile is right when any of its results agrees
+		correct[result.ProfileID] = correct[result.ProfileID] || result.Action == trace.Action
+	}
+	if err := r.adaptive.Record(correct); err != nil {
+		r.logger.WithError(err).WithField("email_id", email.ID).Warn("Failed to record adaptive weight feedback")
+	}
+}
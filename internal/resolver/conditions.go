@@ -0,0 +1,178 @@
+package resolver
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/expr"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Priority rule conditions are expr programs evaluated against:
+//
+//	results            every classification result, each with profile_id,
+//	                   action, confidence, reasoning, labels and metadata,
+//	                   plus its metadata keys at the top level
+//	email              the email's id, subject, from, sender (the bare
+//	                   address), to, cc, labels, headers, auth (its spf,
+//	                   dkim and dmarc results), security, context,
+//	                   homoglyphs, reply, language (an ISO 639-1 code
+//	                   or "unknown"), empty_body, attachments (each with
+//	                   filename, mime_type, extension, size and dangerous)
+//	                   and urls (each with url, host, text, text_host,
+//	                   mismatch, shortener and blocked), with the helpers
+//	                   email.has_attachment_type(types...),
+//	                   email.has_attachment_extension(extensions...),
+//	                   email.has_dangerous_attachment(),
+//	                   email.has_link_text_mismatch() and
+//	                   email.is_spoofed_reply()
+//	sender             the reputation of the email's sender: address, domain,
+//	                   trust_score, allowlisted, blocked and known
+//	sender_reputation  an alias of sender
+//	allowlist          allowlist.contains(address) reports whether an address
+//	                   is covered by the sender_reputation allowlist
+//	blocklist          the sender_reputation blocklist entries, as in
+//	                   email.urls.any(host in blocklist)
+//
+// together with the expr macros any/all/count, which iterate results with
+// each element bound as profile, and max/min, for example
+// max(results, confidence) < 0.6.
+
+// evaluateCondition compiles and evaluates a priority rule condition. A
+// condition that fails to compile or evaluate does not match; profile lint
+// reports compile errors ahead of time.
+func (r *PolicyResolver) evaluateCondition(condition string, email *types.Email, results []*types.ClassificationResponse) bool {
+	program, err := r.compileCondition(condition)
+	if err == nil {
+		var matched bool
+		if matched, err = program.EvalBool(r.conditionEnv(email, results)); err == nil {
+			return matched
+		}
+	}
+
+	r.logger.WithError(err).WithFields(logrus.Fields{
+		"email_id":  email.ID,
+		"condition": condition,
+	}).Warn("Failed to evaluate priority rule condition")
+	return false
+}
+
+// compileCondition returns the compiled program for a condition, compiling
+// it on first use
+func (r *PolicyResolver) compileCondition(condition string) (*expr.Program, error) {
+	if cached, exists := r.programs.Load(condition); exists {
+		return cached.(*expr.Program), nil
+	}
+
+	program, err := expr.Compile(condition)
+	if err != nil {
+		return nil, err
+	}
+	r.programs.Store(condition, program)
+	return program, nil
+}
+
+// conditionEnv builds the environment priority rule conditions are
+// evaluated against
+func (r *PolicyResolver) conditionEnv(email *types.Email, results []*types.ClassificationResponse) expr.Env {
+	items := make([]interface{}, len(results))
+	for i, result := range results {
+		items[i] = result.Fields()
+	}
+
+	attachments := make([]interface{}, len(email.Attachments))
+	for i, attachment := range email.Attachments {
+		attachments[i] = map[string]interface{}{
+			"filename":  attachment.Filename,
+			"mime_type": attachment.MimeType,
+			"extension": attachment.Extension(),
+			"size":      float64(attachment.Size),
+			"dangerous": attachment.IsDangerous(),
+		}
+	}
+
+	urls := make([]interface{}, len(email.URLs))
+	for i, link := range email.URLs {
+		urls[i] = map[string]interface{}{
+			"url":       link.URL,
+			"host":      link.Host,
+			"text":      link.Text,
+			"text_host": link.TextHost,
+			"mismatch":  link.Mismatch,
+			"shortener": link.Shortener,
+			"blocked":   r.reputation.BlockedHost(link.Host),
+		}
+	}
+	blocklist := r.reputation.Blocklist()
+	blocked := make([]interface{}, len(blocklist))
+	for i, entry := range blocklist {
+		blocked[i] = entry
+	}
+
+	sender := r.reputation.Lookup(email.From)
+	return expr.Env{
+		expr.DefaultCollection: items,
+		"email": map[string]interface{}{
+			"id":          email.ID,
+			"subject":     email.Subject,
+			"from":        email.From,
+			"sender":      sender.Address,
+			"to":          email.To,
+			"cc":          email.CC,
+			"labels":      email.Labels,
+			"headers":     email.Headers,
+			"auth":        email.Auth,
+			"security":    email.Security,
+			"context":     email.Context,
+			"homoglyphs":  email.Homoglyphs,
+			"reply":       email.Reply,
+			"language":    email.Language,
+			"empty_body":  email.EmptyBody,
+			"attachments": attachments,
+			"urls":        urls,
+			"has_attachment_type": expr.Func(func(args ...interface{}) (interface{}, error) {
+				return email.HasAttachmentType(stringArgs(args)...), nil
+			}),
+			"has_attachment_extension": expr.Func(func(args ...interface{}) (interface{}, error) {
+				return email.HasAttachmentExtension(stringArgs(args)...), nil
+			}),
+			"has_dangerous_attachment": expr.Func(func(args ...interface{}) (interface{}, error) {
+				return email.HasDangerousAttachment(), nil
+			}),
+			"has_link_text_mismatch": expr.Func(func(args ...interface{}) (interface{}, error) {
+				return email.HasLinkTextMismatch(), nil
+			}),
+			"is_spoofed_reply": expr.Func(func(args ...interface{}) (interface{}, error) {
+				return email.IsSpoofedReply(), nil
+			}),
+		},
+		"allowlist": map[string]interface{}{
+			"contains": expr.Func(func(args ...interface{}) (interface{}, error) {
+				for _, arg := range args {
+					if address, ok := arg.(string); ok && r.reputation.Allowlisted(address) {
+						return true, nil
+					}
+				}
+				return false, nil
+			}),
+		},
+		"blocklist":         blocked,
+		"sender":            sender,
+		"sender_reputation": sender,
+	}
+}
+
+// stringArgs returns the string arguments of a helper call, flattening
+// lists so that a helper can be given a list literal such as
+// ['.exe', '.scr']
+func stringArgs(args []interface{}) []string {
+	var values []string
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case string:
+			values = append(values, arg)
+		case []interface{}:
+			values = append(values, stringArgs(arg)...)
+		}
+	}
+	return values
+}
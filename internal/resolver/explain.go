@@ -0,0 +1,55 @@
+package resolver
+
+// Resolution methods recorded in an Explanation
+const (
+	MethodSingleResult      = "single_result"
+	MethodPriorityRule      = "priority_rule"
+	MethodHighestConfidence = "highest_confidence"
+	MethodConsensus         = "consensus"
+	MethodWeightedAverage   = "weighted_average"
+)
+
+// Explanation is a structured trace of how ResolveDecision reached its
+// result. It contains no timestamps or map-ordered data, so the same inputs
+// always produce the same explanation.
+type Explanation struct {
+	Method         string               `json:"method"`
+	Rule           string               `json:"rule,omitempty"`
+	PriorityRules  []RuleEvaluation     `json:"priority_rules"`
+	Weighted       []WeightedConfidence `json:"weighted_confidences,omitempty"`
+	Candidates     []ActionCandidate    `json:"candidates,omitempty"`
+	Action         string               `json:"action"`
+	Confidence     float64              `json:"confidence"`
+	Abstained      bool                 `json:"abstained,omitempty"`
+	ProposedAction string               `json:"proposed_action,omitempty"`
+}
+
+// RuleEvaluation records the outcome of one priority rule, in evaluation order
+type RuleEvaluation struct {
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+	Matched  bool   `json:"matched"`
+}
+
+// WeightedConfidence records the profile weight applied to one result
+type WeightedConfidence struct {
+	ProfileID  string  `json:"profile_id"`
+	Action     string  `json:"action"`
+	Confidence float64 `json:"confidence"`
+	Weight     float64 `json:"weight"`
+	Weighted   float64 `json:"weighted"`
+}
+
+// ActionCandidate records how an action scored under the resolution method,
+// ordered by action name
+type ActionCandidate struct {
+	Action string  `json:"action"`
+	Count  int     `json:"count"`
+	Score  float64 `json:"score"`
+}
+
+// SetExplainEnabled attaches an Explanation to every resolved result's
+// metadata under types.MetadataResolution
+func (r *PolicyResolver) SetExplainEnabled(enabled bool) {
+	r.explain = enabled
+}
@@ -3,37 +3,66 @@ package resolver
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/internal/reputation"
 	"github.com/mailsentinel/core/pkg/types"
 )
 
 // PolicyResolver handles conflict resolution between multiple profile results
 type PolicyResolver struct {
-	config *types.ResolverConfig
-	logger *logrus.Logger
+	config     *types.ResolverConfig
+	logger     *logrus.Logger
+	explain    bool
+	reputation *reputation.Reputation
+	adaptive   *AdaptiveWeights
+	clock      clock.Clock
+	programs   sync.Map // condition source -> *expr.Program
 }
 
 // NewPolicyResolver creates a new policy resolver
 func NewPolicyResolver(configPath string, logger *logrus.Logger) (*PolicyResolver, error) {
-	config, err := loadResolverConfig(configPath)
+	config, err := LoadConfig(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load resolver config: %w", err)
 	}
 
+	senders, err := reputation.New(config.SenderReputation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sender reputation: %w", err)
+	}
+
+	var adaptive *AdaptiveWeights
+	if config.ConfidenceWeighting.Adaptive.Enabled {
+		adaptive, err = NewAdaptiveWeights(config.ConfidenceWeighting)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load adaptive weights: %w", err)
+		}
+	}
+
 	return &PolicyResolver{
-		config: config,
-		logger: logger,
+		config:     config,
+		logger:     logger,
+		reputation: senders,
+		adaptive:   adaptive,
+		clock:      clock.Real{},
 	}, nil
 }
 
-// loadResolverConfig loads resolver configuration from YAML file
-func loadResolverConfig(path string) (*types.ResolverConfig, error) {
+// SetClock sets the clock the resolved results' ProcessedAt is read from
+func (r *PolicyResolver) SetClock(clk clock.Clock) {
+	r.clock = clk
+}
+
+// LoadConfig loads resolver configuration from a YAML file
+func LoadConfig(path string) (*types.ResolverConfig, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
@@ -44,17 +73,59 @@ func loadResolverConfig(path string) (*types.ResolverConfig, error) {
 		return nil, fmt.Errorf("failed to parse YAML: %w", err)
 	}
 
+	// The scores and adaptive weights files are relative to the resolver configuration
+	if scores := config.SenderReputation.ScoresFile; scores != "" && !filepath.IsAbs(scores) {
+		config.SenderReputation.ScoresFile = filepath.Join(filepath.Dir(path), scores)
+	}
+	if state := config.ConfidenceWeighting.Adaptive.StateFile; state != "" && !filepath.IsAbs(state) {
+		config.ConfidenceWeighting.Adaptive.StateFile = filepath.Join(filepath.Dir(path), state)
+	}
+
 	return &config, nil
 }
 
-// ResolveDecision resolves conflicts between multiple classification results
+// ResolveDecision resolves conflicts between multiple classification results.
+// With adaptive weighting learning from agreement, the decision is fed back
+// to the weights of the profiles that produced the results.
 func (r *PolicyResolver) ResolveDecision(email *types.Email, results []*types.ClassificationResponse) (*types.ClassificationResponse, error) {
+	result, trace, err := r.resolve(email, results)
+	if err != nil {
+		return nil, err
+	}
+	r.learnFromAgreement(email, results, trace)
+	return r.explainResult(result, trace), nil
+}
+
+// ExplainDecision resolves like ResolveDecision and returns the explanation
+// of the decision whether or not explain mode is enabled
+func (r *PolicyResolver) ExplainDecision(email *types.Email, results []*types.ClassificationResponse) (*types.ClassificationResponse, *Explanation, error) {
+	return r.resolve(email, results)
+}
+
+// Decide resolves like ResolveDecision into a decision recording the
+// results, the resolved result and, whether or not explain mode is enabled,
+// the explanation of how it was reached
+func (r *PolicyResolver) Decide(email *types.Email, results []*types.ClassificationResponse) (*types.Decision, error) {
+	result, trace, err := r.resolve(email, results)
+	if err != nil {
+		return nil, err
+	}
+	r.learnFromAgreement(email, results, trace)
+	decision := types.NewDecision(email.ID, results, r.explainResult(result, trace))
+	decision.Explanation = trace
+	return decision, nil
+}
+
+// resolve resolves the results, tracing the decision
+func (r *PolicyResolver) resolve(email *types.Email, results []*types.ClassificationResponse) (*types.ClassificationResponse, *Explanation, error) {
 	if len(results) == 0 {
-		return nil, fmt.Errorf("no classification results provided")
+		return nil, nil, fmt.Errorf("no classification results provided")
 	}
 
+	trace := &Explanation{PriorityRules: []RuleEvaluation{}}
 	if len(results) == 1 {
-		return results[0], nil
+		trace.Method = MethodSingleResult
+		return completeTrace(r.applyConfidenceFloor(email, results[0], trace), trace), trace, nil
 	}
 
 	r.logger.WithFields(logrus.Fields{
@@ -63,20 +134,27 @@ func (r *PolicyResolver) ResolveDecision(email *types.Email, results []*types.Cl
 	}).Info("Resolving classification conflicts")
 
 	// Apply priority rules first
-	if priorityResult := r.applyPriorityRules(email, results); priorityResult != nil {
+	if priorityResult, rule := r.applyPriorityRules(email, results, trace); priorityResult != nil {
+		priorityResult.EmailID = email.ID
 		r.logger.WithFields(logrus.Fields{
 			"email_id": email.ID,
 			"action":   priorityResult.Action,
 			"reason":   "priority_rule_override",
 		}).Info("Applied priority rule override")
-		return priorityResult, nil
+		trace.Method = MethodPriorityRule
+		if !rule.BypassConfidenceFloor {
+			priorityResult = r.applyConfidenceFloor(email, priorityResult, trace)
+		}
+		return completeTrace(priorityResult, trace), trace, nil
 	}
 
 	// Apply confidence weighting
-	weightedResults := r.applyConfidenceWeighting(results)
+	weightedResults := r.applyConfidenceWeighting(results, trace)
 
 	// Resolve conflicts using conflict resolution matrix
-	finalResult := r.resolveConflicts(weightedResults)
+	finalResult := r.resolveConflicts(weightedResults, trace)
+	finalResult.EmailID = email.ID
+	finalResult = r.applyConfidenceFloor(email, finalResult, trace)
 
 	r.logger.WithFields(logrus.Fields{
 		"email_id":   email.ID,
@@ -84,22 +162,55 @@ func (r *PolicyResolver) ResolveDecision(email *types.Email, results []*types.Cl
 		"confidence": finalResult.Confidence,
 	}).Info("Resolved classification decision")
 
-	return finalResult, nil
+	return completeTrace(finalResult, trace), trace, nil
 }
 
-// applyPriorityRules checks if any priority rules should override normal resolution
-func (r *PolicyResolver) applyPriorityRules(email *types.Email, results []*types.ClassificationResponse) *types.ClassificationResponse {
+// completeTrace records the resolved action and confidence in the
+// explanation and returns the result
+func completeTrace(result *types.ClassificationResponse, trace *Explanation) *types.ClassificationResponse {
+	trace.Action = result.Action
+	trace.Confidence = result.Confidence
+	return result
+}
+
+// explainResult returns, when explain mode is enabled, a copy of the result
+// carrying its explanation in its metadata
+func (r *PolicyResolver) explainResult(result *types.ClassificationResponse, trace *Explanation) *types.ClassificationResponse {
+	if !r.explain {
+		return result
+	}
+
+	explained := *result
+	explained.Metadata = make(map[string]interface{}, len(result.Metadata)+1)
+	for key, value := range result.Metadata {
+		explained.Metadata[key] = value
+	}
+	explained.Metadata[types.MetadataResolution] = trace
+	return &explained
+}
+
+// applyPriorityRules checks if any priority rules should override normal
+// resolution, returning the override and the rule that produced it
+func (r *PolicyResolver) applyPriorityRules(email *types.Email, results []*types.ClassificationResponse, trace *Explanation) (*types.ClassificationResponse, *types.PriorityRule) {
 	// Sort priority rules by priority (highest first)
 	rules := make([]*types.PriorityRule, len(r.config.PriorityRules))
 	for i := range r.config.PriorityRules {
 		rules[i] = &r.config.PriorityRules[i]
 	}
-	sort.Slice(rules, func(i, j int) bool {
+	sort.SliceStable(rules, func(i, j int) bool {
 		return rules[i].Priority > rules[j].Priority
 	})
 
 	for _, rule := range rules {
-		if r.evaluateCondition(rule.Condition, email, results) {
+		matched := r.evaluateCondition(rule.Condition, email, results)
+		trace.PriorityRules = append(trace.PriorityRules, RuleEvaluation{
+			Name:     rule.Name,
+			Priority: rule.Priority,
+			Matched:  matched,
+		})
+
+		if matched {
+			trace.Rule = rule.Name
 			r.logger.WithFields(logrus.Fields{
 				"rule_name": rule.Name,
 				"priority":  rule.Priority,
@@ -111,7 +222,7 @@ func (r *PolicyResolver) applyPriorityRules(email *types.Email, results []*types
 				Action:      rule.Action,
 				Confidence:  1.0, // Priority rules have maximum confidence
 				Reasoning:   rule.Reason,
-				ProcessedAt: time.Now(),
+				ProcessedAt: r.clock.Now(),
 			}
 
 			// Apply confidence boost if specified
@@ -130,54 +241,15 @@ func (r *PolicyResolver) applyPriorityRules(email *types.Email, results []*types
 				}
 			}
 
-			return result
-		}
-	}
-
-	return nil
-}
-
-// evaluateCondition evaluates a condition expression
-func (r *PolicyResolver) evaluateCondition(condition string, email *types.Email, results []*types.ClassificationResponse) bool {
-	// Simple condition evaluation - in production, use a proper expression evaluator
-	
-	// Security override: check for high phishing scores
-	if strings.Contains(condition, "phishing_score >= 0.8") {
-		for _, result := range results {
-			if phishingScore, exists := result.Metadata["phishing_score"]; exists {
-				if score, ok := phishingScore.(float64); ok && score >= 0.8 {
-					return true
-				}
-			}
-		}
-	}
-
-	// Importance override: check for critical importance
-	if strings.Contains(condition, "importance == 'critical'") {
-		for _, result := range results {
-			if importance, exists := result.Metadata["importance"]; exists {
-				if imp, ok := importance.(string); ok && imp == "critical" && result.Confidence >= 0.7 {
-					return true
-				}
-			}
-		}
-	}
-
-	// Trusted sender boost: check sender reputation
-	if strings.Contains(condition, "sender_reputation.trust_score >= 0.9") {
-		if trustScore, exists := email.Headers["X-Sender-Trust-Score"]; exists {
-			// Parse trust score from header (simplified)
-			if strings.Contains(trustScore, "0.9") || strings.Contains(trustScore, "1.0") {
-				return true
-			}
+			return result, rule
 		}
 	}
 
-	return false
+	return nil, nil
 }
 
 // applyConfidenceWeighting applies confidence weighting to results
-func (r *PolicyResolver) applyConfidenceWeighting(results []*types.ClassificationResponse) []*types.ClassificationResponse {
+func (r *PolicyResolver) applyConfidenceWeighting(results []*types.ClassificationResponse, trace *Explanation) []*types.ClassificationResponse {
 	weightedResults := make([]*types.ClassificationResponse, len(results))
 
 	for i, result := range results {
@@ -185,7 +257,8 @@ func (r *PolicyResolver) applyConfidenceWeighting(results []*types.Classificatio
 		weighted := *result
 		
 		// Apply profile weight
-		if weight, exists := r.config.ConfidenceWeighting.ProfileWeights[result.ProfileID]; exists {
+		weight, exists := r.profileWeight(result.ProfileID)
+		if exists {
 			weighted.Confidence = min(1.0, result.Confidence*weight)
 			r.logger.WithFields(logrus.Fields{
 				"profile_id":        result.ProfileID,
@@ -193,8 +266,17 @@ func (r *PolicyResolver) applyConfidenceWeighting(results []*types.Classificatio
 				"weight":           weight,
 				"weighted_conf":    weighted.Confidence,
 			}).Debug("Applied confidence weighting")
+		} else {
+			weight = 1.0
 		}
 		
+		trace.Weighted = append(trace.Weighted, WeightedConfidence{
+			ProfileID:  result.ProfileID,
+			Action:     result.Action,
+			Confidence: result.Confidence,
+			Weight:     weight,
+			Weighted:   weighted.Confidence,
+		})
 		weightedResults[i] = &weighted
 	}
 
@@ -202,16 +284,19 @@ func (r *PolicyResolver) applyConfidenceWeighting(results []*types.Classificatio
 }
 
 // resolveConflicts resolves conflicts using the configured method
-func (r *PolicyResolver) resolveConflicts(results []*types.ClassificationResponse) *types.ClassificationResponse {
+func (r *PolicyResolver) resolveConflicts(results []*types.ClassificationResponse, trace *Explanation) *types.ClassificationResponse {
 	switch r.config.ConfidenceWeighting.Method {
-	case "highest_confidence":
+	case MethodHighestConfidence:
+		trace.Method = MethodHighestConfidence
 		return r.resolveByHighestConfidence(results)
-	case "consensus":
-		return r.resolveByConsensus(results)
-	case "weighted_average":
+	case MethodConsensus:
+		trace.Method = MethodConsensus
+		return r.resolveByConsensus(results, trace)
+	case MethodWeightedAverage:
 		fallthrough
 	default:
-		return r.resolveByWeightedAverage(results)
+		trace.Method = MethodWeightedAverage
+		return r.resolveByWeightedAverage(results, trace)
 	}
 }
 
@@ -226,8 +311,9 @@ func (r *PolicyResolver) resolveByHighestConfidence(results []*types.Classificat
 	return best
 }
 
-// resolveByConsensus finds consensus among results
-func (r *PolicyResolver) resolveByConsensus(results []*types.ClassificationResponse) *types.ClassificationResponse {
+// resolveByConsensus finds consensus among results. Ties between equally
+// common actions go to the action that sorts first.
+func (r *PolicyResolver) resolveByConsensus(results []*types.ClassificationResponse, trace *Explanation) *types.ClassificationResponse {
 	// Count actions
 	actionCounts := make(map[string]int)
 	actionResults := make(map[string][]*types.ClassificationResponse)
@@ -240,7 +326,13 @@ func (r *PolicyResolver) resolveByConsensus(results []*types.ClassificationRespo
 	// Find most common action
 	var bestAction string
 	var maxCount int
-	for action, count := range actionCounts {
+	for _, action := range sortedActions(actionCounts) {
+		count := actionCounts[action]
+		trace.Candidates = append(trace.Candidates, ActionCandidate{
+			Action: action,
+			Count:  count,
+			Score:  r.resolveByHighestConfidence(actionResults[action]).Confidence,
+		})
 		if count > maxCount {
 			maxCount = count
 			bestAction = action
@@ -252,8 +344,9 @@ func (r *PolicyResolver) resolveByConsensus(results []*types.ClassificationRespo
 	return r.resolveByHighestConfidence(consensusResults)
 }
 
-// resolveByWeightedAverage creates a weighted average result
-func (r *PolicyResolver) resolveByWeightedAverage(results []*types.ClassificationResponse) *types.ClassificationResponse {
+// resolveByWeightedAverage creates a weighted average result. Ties between
+// equally confident actions go to the action that sorts first.
+func (r *PolicyResolver) resolveByWeightedAverage(results []*types.ClassificationResponse, trace *Explanation) *types.ClassificationResponse {
 	// Group by action and calculate weighted averages
 	actionGroups := make(map[string][]*types.ClassificationResponse)
 	for _, result := range results {
@@ -266,7 +359,7 @@ func (r *PolicyResolver) resolveByWeightedAverage(results []*types.Classificatio
 		var totalWeight, weightedSum float64
 		for _, result := range group {
 			weight := 1.0 // Default weight
-			if w, exists := r.config.ConfidenceWeighting.ProfileWeights[result.ProfileID]; exists {
+			if w, exists := r.profileWeight(result.ProfileID); exists {
 				weight = w
 			}
 			totalWeight += weight
@@ -278,7 +371,13 @@ func (r *PolicyResolver) resolveByWeightedAverage(results []*types.Classificatio
 	// Find action with highest weighted confidence
 	var bestAction string
 	var bestConfidence float64
-	for action, confidence := range actionConfidences {
+	for _, action := range sortedActions(actionConfidences) {
+		confidence := actionConfidences[action]
+		trace.Candidates = append(trace.Candidates, ActionCandidate{
+			Action: action,
+			Count:  len(actionGroups[action]),
+			Score:  confidence,
+		})
 		if confidence > bestConfidence {
 			bestConfidence = confidence
 			bestAction = action
@@ -290,7 +389,7 @@ func (r *PolicyResolver) resolveByWeightedAverage(results []*types.Classificatio
 		Action:      bestAction,
 		Confidence:  bestConfidence,
 		Reasoning:   r.combineReasonings(actionGroups[bestAction]),
-		ProcessedAt: time.Now(),
+		ProcessedAt: r.clock.Now(),
 	}
 	
 	// Combine labels from all results for this action
@@ -304,10 +403,21 @@ func (r *PolicyResolver) resolveByWeightedAverage(results []*types.Classificatio
 	for label := range labelSet {
 		combinedResult.Labels = append(combinedResult.Labels, label)
 	}
+	sort.Strings(combinedResult.Labels)
 	
 	return combinedResult
 }
 
+// sortedActions returns the keys of an action map in sorted order
+func sortedActions[V any](actions map[string]V) []string {
+	keys := make([]string, 0, len(actions))
+	for action := range actions {
+		keys = append(keys, action)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // combineReasonings combines reasoning from multiple results
 func (r *PolicyResolver) combineReasonings(results []*types.ClassificationResponse) string {
 	var reasonings []string
@@ -0,0 +1,702 @@
+package resolver
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/clock"
+	"github.com/mailsentinel/core/internal/language"
+	"github.com/mailsentinel/core/internal/links"
+	"github.com/mailsentinel/core/internal/replyspoof"
+	"github.com/mailsentinel/core/internal/reputation"
+	"github.com/mailsentinel/core/pkg/testutil"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestExplainWeightedAverage(t *testing.T) {
+	resolver := testResolver(MethodWeightedAverage)
+	resolver.SetExplainEnabled(true)
+
+	result, err := resolver.ResolveDecision(testEmail(), testResults())
+	require.NoError(t, err)
+
+	expected := `{
+		"method": "weighted_average",
+		"priority_rules": [
+			{"name": "security_override", "priority": 100, "matched": false},
+			{"name": "critical_importance", "priority": 90, "matched": false}
+		],
+		"weighted_confidences": [
+			{"profile_id": "spam", "action": "delete", "confidence": 0.6, "weight": 1, "weighted": 0.6},
+			{"profile_id": "promotional", "action": "archive", "confidence": 0.8, "weight": 0.5, "weighted": 0.4},
+			{"profile_id": "newsletter", "action": "archive", "confidence": 0.9, "weight": 1, "weighted": 0.9}
+		],
+		"candidates": [
+			{"action": "archive", "count": 2, "score": 0.7333333333333334},
+			{"action": "delete", "count": 1, "score": 0.6}
+		],
+		"action": "archive",
+		"confidence": 0.7333333333333334
+	}`
+	assert.JSONEq(t, expected, explanationJSON(t, result))
+}
+
+func TestExplainPriorityRule(t *testing.T) {
+	resolver := testResolver(MethodWeightedAverage)
+	resolver.SetExplainEnabled(true)
+
+	results := testResults()
+	results[2].Metadata = map[string]interface{}{"importance": "critical"}
+
+	result, err := resolver.ResolveDecision(testEmail(), results)
+	require.NoError(t, err)
+	assert.Equal(t, "star", result.Action)
+
+	expected := `{
+		"method": "priority_rule",
+		"rule": "critical_importance",
+		"priority_rules": [
+			{"name": "security_override", "priority": 100, "matched": false},
+			{"name": "critical_importance", "priority": 90, "matched": true}
+		],
+		"action": "star",
+		"confidence": 1
+	}`
+	assert.JSONEq(t, expected, explanationJSON(t, result))
+}
+
+func TestExplainIsDeterministic(t *testing.T) {
+	resolver := testResolver(MethodConsensus)
+	resolver.SetExplainEnabled(true)
+
+	// Two actions tie on count; the tie must always resolve the same way
+	results := []*types.ClassificationResponse{
+		{ProfileID: "a", Action: "keep", Confidence: 0.7, Labels: []string{"b", "a"}},
+		{ProfileID: "b", Action: "archive", Confidence: 0.6},
+	}
+
+	first, err := resolver.ResolveDecision(testEmail(), results)
+	require.NoError(t, err)
+	snapshot := explanationJSON(t, first)
+	assert.Equal(t, "archive", first.Action)
+
+	for i := 0; i < 20; i++ {
+		again, err := resolver.ResolveDecision(testEmail(), results)
+		require.NoError(t, err)
+		assert.Equal(t, snapshot, explanationJSON(t, again))
+	}
+}
+
+func TestExplainDisabled(t *testing.T) {
+	resolver := testResolver(MethodHighestConfidence)
+	results := testResults()
+	results[2].Metadata = map[string]interface{}{"source": "model"}
+
+	result, err := resolver.ResolveDecision(testEmail(), results)
+	require.NoError(t, err)
+	assert.NotContains(t, result.Metadata, types.MetadataResolution)
+
+	// Explaining must not modify the caller's results
+	resolver.SetExplainEnabled(true)
+	result, err = resolver.ResolveDecision(testEmail(), results)
+	require.NoError(t, err)
+	assert.Contains(t, result.Metadata, types.MetadataResolution)
+	assert.Equal(t, "model", result.Metadata["source"])
+	assert.NotContains(t, results[2].Metadata, types.MetadataResolution)
+}
+
+func TestDecide(t *testing.T) {
+	resolver := testResolver(MethodWeightedAverage)
+	results := testResults()
+	results[0].Fingerprint = &types.Fingerprint{ID: "fp-spam"}
+
+	decision, err := resolver.Decide(testEmail(), results)
+	require.NoError(t, err)
+	assert.Equal(t, "email-1", decision.EmailID)
+	assert.Equal(t, results, decision.Classifications)
+	assert.Equal(t, "archive", decision.Resolution.Action)
+	assert.Equal(t, testNow, decision.DecidedAt)
+	assert.Equal(t, []*types.Fingerprint{{ID: "fp-spam"}, nil, nil}, decision.Fingerprints, "aligned with the classifications")
+	assert.NotContains(t, decision.Resolution.Metadata, types.MetadataResolution, "explain mode stays off for the resolution")
+
+	explanation, ok := decision.Explanation.(*Explanation)
+	require.True(t, ok, "a decision is always explained")
+	assert.Equal(t, MethodWeightedAverage, explanation.Method)
+	assert.Equal(t, "archive", explanation.Action)
+
+	_, err = resolver.Decide(testEmail(), nil)
+	assert.Error(t, err)
+}
+
+func TestConfidenceFloorAbstainsWhenAllLow(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+	}{
+		{"weighted average", MethodWeightedAverage},
+		{"highest confidence", MethodHighestConfidence},
+		{"consensus", MethodConsensus},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := testResolver(tt.method)
+			resolver.config.ConfidenceFloor = 0.7
+			results := []*types.ClassificationResponse{
+				{ProfileID: "spam", Action: "delete", Confidence: 0.55, Reasoning: "Might be spam"},
+				{ProfileID: "promotional", Action: "delete", Confidence: 0.5, Reasoning: "Possibly a promotion"},
+				{ProfileID: "newsletter", Action: "archive", Confidence: 0.4, Reasoning: "Maybe a newsletter"},
+			}
+
+			result, err := resolver.ResolveDecision(testEmail(), results)
+			require.NoError(t, err)
+			assert.Equal(t, types.ActionReview, result.Action)
+			assert.Equal(t, "email-1", result.EmailID)
+			assert.Equal(t, true, result.Metadata[types.MetadataAbstained])
+			assert.Equal(t, "delete", result.Metadata[types.MetadataProposedAction])
+			assert.Contains(t, result.Reasoning, "below the 0.70 floor")
+
+			assert.NotContains(t, results[0].Metadata, types.MetadataAbstained, "caller's results are not modified")
+		})
+	}
+}
+
+func TestConfidenceFloor(t *testing.T) {
+	tests := []struct {
+		name      string
+		floor     float64
+		action    string
+		results   []*types.ClassificationResponse
+		expected  string
+		abstained bool
+	}{
+		{"disabled", 0, "", []*types.ClassificationResponse{{ProfileID: "spam", Action: "delete", Confidence: 0.1}}, "delete", false},
+		{"single result below floor", 0.7, "", []*types.ClassificationResponse{{ProfileID: "spam", Action: "delete", Confidence: 0.5}}, types.ActionReview, true},
+		{"single result at floor", 0.7, "", []*types.ClassificationResponse{{ProfileID: "spam", Action: "delete", Confidence: 0.7}}, "delete", false},
+		{"custom abstain action", 0.7, "needs_triage", []*types.ClassificationResponse{{ProfileID: "spam", Action: "delete", Confidence: 0.5}}, "needs_triage", true},
+		{"resolved above floor", 0.7, "", testResults(), "archive", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := testResolver(MethodWeightedAverage)
+			resolver.config.ConfidenceFloor = tt.floor
+			resolver.config.AbstainAction = tt.action
+
+			result, err := resolver.ResolveDecision(testEmail(), tt.results)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result.Action)
+			if tt.abstained {
+				assert.Equal(t, true, result.Metadata[types.MetadataAbstained])
+			} else {
+				assert.NotContains(t, result.Metadata, types.MetadataAbstained)
+			}
+		})
+	}
+}
+
+func TestConfidenceFloorPriorityRuleBypass(t *testing.T) {
+	// A boosted rule keeps the low confidence of the result it boosts
+	lowResults := func() []*types.ClassificationResponse {
+		return []*types.ClassificationResponse{
+			{ProfileID: "spam", Action: "delete", Confidence: 0.3, Metadata: map[string]interface{}{"phishing_score": 0.9}},
+			{ProfileID: "newsletter", Action: "archive", Confidence: 0.2},
+		}
+	}
+
+	for _, bypass := range []bool{false, true} {
+		resolver := testResolver(MethodWeightedAverage)
+		resolver.config.ConfidenceFloor = 0.7
+		resolver.config.PriorityRules[1].ConfidenceBoost = 0.1
+		resolver.config.PriorityRules[1].BypassConfidenceFloor = bypass
+		resolver.SetExplainEnabled(true)
+
+		result, err := resolver.ResolveDecision(testEmail(), lowResults())
+		require.NoError(t, err)
+
+		explanation := result.Metadata[types.MetadataResolution].(*Explanation)
+		assert.Equal(t, MethodPriorityRule, explanation.Method)
+		if bypass {
+			assert.Equal(t, "delete", result.Action)
+			assert.False(t, explanation.Abstained)
+		} else {
+			assert.Equal(t, types.ActionReview, result.Action)
+			assert.True(t, explanation.Abstained)
+			assert.Equal(t, "delete", explanation.ProposedAction)
+		}
+	}
+
+	// Rules with a fixed action resolve with full confidence and never abstain
+	resolver := testResolver(MethodWeightedAverage)
+	resolver.config.ConfidenceFloor = 0.7
+	result, err := resolver.ResolveDecision(testEmail(), lowResults())
+	require.NoError(t, err)
+	assert.Equal(t, "quarantine", result.Action)
+}
+
+func TestCompoundPriorityRules(t *testing.T) {
+	rules := []types.PriorityRule{
+		{
+			Name:      "phishing_unless_allowlisted",
+			Condition: "any(profile.risk_factors.phishing_score >= 0.8) && !allowlist.contains(email.from)",
+			Action:    "quarantine",
+			Priority:  100,
+			Reason:    "Phishing from an unknown sender",
+		},
+		{
+			Name:      "uncertain_delete",
+			Condition: "any(profile.action == 'delete') && max(results, confidence) < 0.6",
+			Action:    "review",
+			Priority:  90,
+			Reason:    "Deletion proposed without confidence",
+		},
+		{
+			Name:      "unanimous_archive",
+			Condition: "all(profile.action == 'archive') && min(results, confidence) >= 0.8",
+			Action:    "archive",
+			Priority:  80,
+			Reason:    "Every profile agrees",
+		},
+	}
+
+	phishing := map[string]interface{}{"risk_factors": map[string]interface{}{"phishing_score": 0.9}}
+
+	tests := []struct {
+		name     string
+		from     string
+		results  []*types.ClassificationResponse
+		expected string
+		rule     string
+	}{
+		{
+			name: "phishing from unknown sender",
+			from: "Support <support@evil.example>",
+			results: []*types.ClassificationResponse{
+				{ProfileID: "security", Action: "delete", Confidence: 0.9, Metadata: phishing},
+				{ProfileID: "newsletter", Action: "archive", Confidence: 0.7},
+			},
+			expected: "quarantine",
+			rule:     "phishing_unless_allowlisted",
+		},
+		{
+			name: "phishing score from allowlisted domain",
+			from: "IT Desk <it@corp.example.com>",
+			results: []*types.ClassificationResponse{
+				{ProfileID: "security", Action: "keep", Confidence: 0.9, Metadata: phishing},
+				{ProfileID: "newsletter", Action: "keep", Confidence: 0.7},
+			},
+			expected: "keep",
+		},
+		{
+			name: "low confidence delete",
+			from: "deals@shop.example",
+			results: []*types.ClassificationResponse{
+				{ProfileID: "spam", Action: "delete", Confidence: 0.55},
+				{ProfileID: "promotional", Action: "archive", Confidence: 0.5},
+			},
+			expected: "review",
+			rule:     "uncertain_delete",
+		},
+		{
+			name: "confident archive",
+			from: "news@list.example",
+			results: []*types.ClassificationResponse{
+				{ProfileID: "newsletter", Action: "archive", Confidence: 0.9},
+				{ProfileID: "promotional", Action: "archive", Confidence: 0.85},
+			},
+			expected: "archive",
+			rule:     "unanimous_archive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := testResolver(MethodHighestConfidence)
+			resolver.config.PriorityRules = rules
+			resolver.reputation = testReputation(t, types.ReputationConfig{Allowlist: []string{"boss@corp.example.com", "@corp.example.com"}})
+			resolver.SetExplainEnabled(true)
+
+			email := testEmail()
+			email.From = tt.from
+			result, err := resolver.ResolveDecision(email, tt.results)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result.Action)
+
+			explanation := result.Metadata[types.MetadataResolution].(*Explanation)
+			assert.Equal(t, tt.rule, explanation.Rule)
+		})
+	}
+}
+
+func TestResolvedResultsProcessedAt(t *testing.T) {
+	resolver := testResolver(MethodWeightedAverage)
+
+	priority := testResults()
+	priority[2].Metadata = map[string]interface{}{"importance": "critical"}
+	result, err := resolver.ResolveDecision(testEmail(), priority)
+	require.NoError(t, err)
+	assert.Equal(t, "star", result.Action)
+	assert.Equal(t, testNow, result.ProcessedAt)
+
+	later := testNow.Add(time.Hour)
+	resolver.SetClock(clock.NewFake(later))
+	result, err = resolver.ResolveDecision(testEmail(), testResults())
+	require.NoError(t, err)
+	assert.Equal(t, "archive", result.Action)
+	assert.Equal(t, later, result.ProcessedAt)
+}
+
+func TestEvaluateCondition(t *testing.T) {
+	resolver := testResolver(MethodWeightedAverage)
+	resolver.reputation = testReputation(t, types.ReputationConfig{
+		Allowlist: []string{"Boss@Corp.Example.com"},
+		Blocklist: []string{"corp.example.com"},
+	})
+
+	email := testEmail()
+	email.From = "The Boss <boss@corp.example.com>"
+	email.Auth = &types.AuthResults{SPF: "softfail", DKIM: types.AuthNone, DMARC: types.AuthFail}
+	email.Context = map[string]string{"source": "quarantine"}
+	email.Homoglyphs = &types.HomoglyphAnalysis{SenderDomain: "pаypаl.com", NormalizedSenderDomain: "paypal.com", Suspicious: true}
+
+	tests := []struct {
+		condition string
+		expected  bool
+	}{
+		{"allowlist.contains(email.from)", true},
+		{"allowlist.contains('someone@corp.example.com')", false},
+		{"email.sender == 'boss@corp.example.com'", true},
+		{"sender.trust_score >= 0.9 && !sender.blocked", true},
+		{"sender_reputation.trust_score >= 0.9", true},
+		{"sender.domain == 'corp.example.com'", true},
+		{"email.auth.dmarc == 'fail' && email.auth.spf != 'pass'", true},
+		{"email.auth.dkim == 'pass'", false},
+		{"email.context.source == 'quarantine'", true},
+		{"email.context.prior_action == 'archive'", false},
+		{"email.homoglyphs.suspicious && email.homoglyphs.normalized_sender_domain == 'paypal.com'", true},
+		{"count(profile.action == 'archive') == 2", true},
+		{"any(results, it.profile_id == 'spam' && it.confidence > 0.5)", true},
+		{"max(results, confidence) == 0.9 && min(results, confidence) == 0.6", true},
+		{"profile.confidence > 0", false},
+		{"not valid (", false},
+		{"confidence / 0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			assert.Equal(t, tt.expected, resolver.evaluateCondition(tt.condition, email, testResults()))
+		})
+	}
+}
+
+func TestEvaluateAttachmentConditions(t *testing.T) {
+	td := testutil.LoadTestData(t)
+	resolver := testResolver(MethodWeightedAverage)
+	dangerous := td.GetTestEmail("test-email-009")
+	benign := td.GetTestEmail("test-email-010")
+
+	tests := []struct {
+		condition string
+		dangerous bool
+		benign    bool
+	}{
+		{"email.has_attachment_type('application/x-msdownload')", true, false},
+		{"email.has_attachment_type('application/pdf', 'image/png')", false, true},
+		{"email.has_attachment_extension(['.exe', '.scr', '.js'])", true, false},
+		{"email.has_attachment_extension('docx')", false, true},
+		{"email.has_dangerous_attachment()", true, false},
+		{"any(email.attachments, it.dangerous && it.extension == '.docm')", true, false},
+		{"count(email.attachments, it.size > 1000000) == 1", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			assert.Equal(t, tt.dangerous, resolver.evaluateCondition(tt.condition, dangerous, testResults()))
+			assert.Equal(t, tt.benign, resolver.evaluateCondition(tt.condition, benign, testResults()))
+		})
+	}
+}
+
+func TestEvaluateReplyConditions(t *testing.T) {
+	td := testutil.LoadTestData(t)
+	resolver := testResolver(MethodWeightedAverage)
+	genuine := td.GetTestEmail("test-email-013")
+	spoofed := td.GetTestEmail("test-email-014")
+	replyspoof.Annotate(genuine)
+	replyspoof.Annotate(spoofed)
+
+	tests := []struct {
+		condition string
+		genuine   bool
+		spoofed   bool
+	}{
+		{"email.is_spoofed_reply()", false, true},
+		{"email.reply.kind == 'reply'", true, true},
+		{"email.reply.threaded", true, false},
+		{"'quoted_sender_mismatch' in email.reply.reasons", false, true},
+		{"email.is_spoofed_reply() && !allowlist.contains(email.from)", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			assert.Equal(t, tt.genuine, resolver.evaluateCondition(tt.condition, genuine, testResults()))
+			assert.Equal(t, tt.spoofed, resolver.evaluateCondition(tt.condition, spoofed, testResults()))
+		})
+	}
+}
+
+func TestEvaluateLanguageConditions(t *testing.T) {
+	resolver := testResolver(MethodWeightedAverage)
+	email := &types.Email{Body: "Estimado cliente, su cuenta ha sido suspendida. Haga clic en el enlace para confirmar sus datos."}
+	language.Annotate(email)
+
+	assert.True(t, resolver.evaluateCondition("email.language == 'es'", email, testResults()))
+	assert.False(t, resolver.evaluateCondition("email.language in ['en', 'unknown']", email, testResults()))
+}
+
+func TestEvaluateLinkConditions(t *testing.T) {
+	td := testutil.LoadTestData(t)
+	resolver := testResolver(MethodWeightedAverage)
+	resolver.reputation = testReputation(t, types.ReputationConfig{Blocklist: []string{"account-verify.example", "tinyurl.com"}})
+	mismatched := td.GetTestEmail("test-email-011")
+	shortened := td.GetTestEmail("test-email-012")
+	links.Annotate(mismatched)
+	links.Annotate(shortened)
+
+	tests := []struct {
+		condition  string
+		mismatched bool
+		shortened  bool
+	}{
+		{"email.has_link_text_mismatch()", true, false},
+		{"email.urls.any(host in blocklist)", false, true},
+		{"email.urls.any(blocked)", true, true},
+		{"email.urls.any(shortener)", false, true},
+		{"count(email.urls, it.mismatch && it.text_host == 'www.paypal.com') == 1", true, false},
+		{"all(email.urls, startsWith(url, 'https://'))", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			assert.Equal(t, tt.mismatched, resolver.evaluateCondition(tt.condition, mismatched, testResults()))
+			assert.Equal(t, tt.shortened, resolver.evaluateCondition(tt.condition, shortened, testResults()))
+		})
+	}
+}
+
+func TestNewPolicyResolverLoadsSenderReputation(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "scores.yaml"), []byte("partner.example: 0.92\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "resolver.yaml"), []byte(`
+priority_rules:
+  - name: "trusted_sender_boost"
+    condition: "sender.trust_score >= 0.9 && !sender.blocked"
+    confidence_boost: 0.1
+    priority: 800
+sender_reputation:
+  blocklist: ["spam@partner.example"]
+  scores_file: "scores.yaml"
+`), 0644))
+
+	resolver, err := NewPolicyResolver(filepath.Join(dir, "resolver.yaml"), testLogger())
+	require.NoError(t, err)
+
+	email := testEmail()
+	email.From = "Partner <news@mail.partner.example>"
+	result, err := resolver.ResolveDecision(email, testResults())
+	require.NoError(t, err)
+	assert.Equal(t, "archive", result.Action)
+	assert.InDelta(t, 1.0, result.Confidence, 1e-9)
+
+	email.From = "spam@partner.example"
+	result, err = resolver.ResolveDecision(email, testResults())
+	require.NoError(t, err)
+	assert.NotContains(t, result.Reasoning, "boosted")
+
+	_, err = NewPolicyResolver(filepath.Join(dir, "missing.yaml"), testLogger())
+	assert.Error(t, err)
+}
+
+func TestAdaptiveWeightsUpdateRule(t *testing.T) {
+	weights, err := NewAdaptiveWeights(types.ConfidenceWeighting{
+		ProfileWeights: map[string]float64{"promotional": 0.5, "security": 3.0},
+		Adaptive:       types.AdaptiveWeighting{Enabled: true, LearningRate: 0.5, MinWeight: 0.5, MaxWeight: 1.5},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 0.5, weights.Weight("promotional"), "profiles start at their static weight")
+	assert.Equal(t, 1.5, weights.Weight("security"), "static weights are clamped into the bounds")
+	assert.Equal(t, 1.0, weights.Weight("unknown"))
+
+	feedback := []struct {
+		correct  bool
+		expected float64
+	}{
+		{true, 1.0},
+		{true, 1.25},
+		{false, 0.875},
+		{false, 0.6875},
+	}
+	for _, step := range feedback {
+		require.NoError(t, weights.Record(map[string]bool{"promotional": step.correct}))
+		assert.InDelta(t, step.expected, weights.Weight("promotional"), 1e-9)
+	}
+	assert.Equal(t, 4, weights.Weights().Feedback["promotional"])
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, weights.Record(map[string]bool{"promotional": true, "security": false}))
+	}
+	assert.LessOrEqual(t, weights.Weight("promotional"), 1.5)
+	assert.GreaterOrEqual(t, weights.Weight("security"), 0.5)
+}
+
+func TestAdaptiveWeightsValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		adaptive types.AdaptiveWeighting
+	}{
+		{"unknown feedback", types.AdaptiveWeighting{Feedback: "votes"}},
+		{"learning rate above one", types.AdaptiveWeighting{LearningRate: 1.5}},
+		{"negative minimum", types.AdaptiveWeighting{MinWeight: -1}},
+		{"inverted bounds", types.AdaptiveWeighting{MinWeight: 2, MaxWeight: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.adaptive.Enabled = true
+			_, err := NewAdaptiveWeights(types.ConfidenceWeighting{Adaptive: tt.adaptive})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestAdaptiveWeightingLearnsFromAgreement(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "resolver.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+confidence_weighting:
+  method: "weighted_average"
+  profile_weights:
+    promotional: 0.5
+  adaptive:
+    enabled: true
+    learning_rate: 0.5
+    min_weight: 0.5
+    max_weight: 1.5
+    state_file: "state/weights.json"
+`), 0644))
+
+	first, err := NewPolicyResolver(path, testLogger())
+	require.NoError(t, err)
+
+	// Simulations do not move the weights
+	_, _, err = first.ExplainDecision(testEmail(), testResults())
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, first.adaptive.Weight("promotional"))
+
+	result, err := first.ResolveDecision(testEmail(), testResults())
+	require.NoError(t, err)
+	assert.Equal(t, "archive", result.Action)
+	assert.InDelta(t, 1.0, first.adaptive.Weight("promotional"), 1e-9)
+	assert.InDelta(t, 1.25, first.adaptive.Weight("newsletter"), 1e-9)
+	assert.InDelta(t, 0.75, first.adaptive.Weight("spam"), 1e-9, "the dissenting profile loses weight")
+
+	// A single result teaches nothing
+	_, err = first.ResolveDecision(testEmail(), testResults()[:1])
+	require.NoError(t, err)
+	assert.InDelta(t, 0.75, first.adaptive.Weight("spam"), 1e-9)
+
+	// The weights survive a restart
+	second, err := NewPolicyResolver(path, testLogger())
+	require.NoError(t, err)
+	assert.InDelta(t, 1.0, second.adaptive.Weight("promotional"), 1e-9)
+	assert.InDelta(t, 0.75, second.adaptive.Weight("spam"), 1e-9)
+	assert.FileExists(t, filepath.Join(dir, "state", "weights.json"))
+}
+
+func TestRecordFeedback(t *testing.T) {
+	resolver := testResolver(MethodWeightedAverage)
+	_, err := resolver.RecordFeedback(map[string]bool{"spam": true})
+	assert.Error(t, err, "feedback needs adaptive weighting")
+
+	resolver.config.ConfidenceWeighting.Adaptive = types.AdaptiveWeighting{Enabled: true, Feedback: FeedbackExternal, LearningRate: 0.5}
+	resolver.adaptive, err = NewAdaptiveWeights(resolver.config.ConfidenceWeighting)
+	require.NoError(t, err)
+
+	// External feedback is the only signal
+	_, err = resolver.ResolveDecision(testEmail(), testResults())
+	require.NoError(t, err)
+	assert.Empty(t, resolver.adaptive.Weights().Weights)
+
+	state, err := resolver.RecordFeedback(map[string]bool{"spam": true, "newsletter": false})
+	require.NoError(t, err)
+	assert.InDelta(t, 1.25, state.Weights["spam"], 1e-9)
+	assert.InDelta(t, 0.75, state.Weights["newsletter"], 1e-9)
+	assert.Equal(t, map[string]int{"spam": 1, "newsletter": 1}, state.Feedback)
+
+	trace := &Explanation{}
+	weighted := resolver.applyConfidenceWeighting(testResults(), trace)
+	assert.InDelta(t, 0.6*1.25, weighted[0].Confidence, 1e-9, "resolution uses the adaptive weights")
+	assert.Equal(t, 1.25, trace.Weighted[0].Weight)
+}
+
+// Helper functions
+
+// testNow is the time of testResolver's clock
+var testNow = time.Date(2026, 2, 1, 8, 30, 0, 0, time.UTC)
+
+func testResolver(method string) *PolicyResolver {
+	return &PolicyResolver{
+		config: &types.ResolverConfig{
+			PriorityRules: []types.PriorityRule{
+				{Name: "critical_importance", Condition: "any(profile.importance == 'critical' && profile.confidence >= 0.7)", Action: "star", Priority: 90, Reason: "Critical email"},
+				{Name: "security_override", Condition: "any(profile.phishing_score >= 0.8)", Action: "quarantine", Priority: 100, Reason: "Phishing"},
+			},
+			ConfidenceWeighting: types.ConfidenceWeighting{
+				Method:         method,
+				ProfileWeights: map[string]float64{"promotional": 0.5},
+			},
+		},
+		logger: testLogger(),
+		clock:  clock.NewFake(testNow),
+	}
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
+
+func testReputation(t *testing.T, cfg types.ReputationConfig) *reputation.Reputation {
+	senders, err := reputation.New(cfg)
+	require.NoError(t, err)
+	return senders
+}
+
+func testEmail() *types.Email {
+	return &types.Email{ID: "email-1", Subject: "Weekly deals"}
+}
+
+func testResults() []*types.ClassificationResponse {
+	return []*types.ClassificationResponse{
+		{ProfileID: "spam", Action: "delete", Confidence: 0.6, Reasoning: "Looks like spam"},
+		{ProfileID: "promotional", Action: "archive", Confidence: 0.8, Reasoning: "Promotion"},
+		{ProfileID: "newsletter", Action: "archive", Confidence: 0.9, Reasoning: "Newsletter"},
+	}
+}
+
+func explanationJSON(t *testing.T, result *types.ClassificationResponse) string {
+	explanation, ok := result.Metadata[types.MetadataResolution].(*Explanation)
+	require.True(t, ok, "result has no explanation")
+	data, err := json.Marshal(explanation)
+	require.NoError(t, err)
+	return string(data)
+}
@@ -0,0 +1,374 @@
+// Package rfc822 parses raw RFC 5322 messages, such as those fetched over
+// IMAP, into types.Email.
+//
+// Headers are decoded from RFC 2047 encoded words. The body is the first
+// text/plain part, with the first text/html part kept as BodyHTML; parts
+// with a filename, or an attachment disposition, are listed as attachments
+// under their MIME part number, such as "2" or "1.3". PGP and S/MIME
+// signatures are dropped and encrypted content is skipped, with the
+// protection recorded as the email's Security.
+package rfc822
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/mailsentinel/core/internal/mailauth"
+	"github.com/mailsentinel/core/internal/mailsec"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// maxDepth bounds the nesting of multipart bodies, as crafted messages may
+// nest them deeply to exhaust the parser
+const maxDepth = 16
+
+// wordDecoder decodes RFC 2047 encoded words in header values
+var wordDecoder = &mime.WordDecoder{}
+
+// Parse reads a raw message into an email. The caller sets the ID, the
+// labels and any other field its mail store knows better.
+func Parse(raw []byte) (*types.Email, error) {
+	message, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	email := &types.Email{
+		Subject:    decodeHeader(message.Header.Get("Subject")),
+		From:       decodeHeader(message.Header.Get("From")),
+		To:         AddressList(message.Header.Get("To")),
+		CC:         AddressList(message.Header.Get("Cc")),
+		ThreadID:   threadID(message.Header),
+		MessageID:  MessageID(message.Header.Get("Message-Id")),
+		InReplyTo:  MessageID(message.Header.Get("In-Reply-To")),
+		References: MessageIDs(message.Header.Get("References")),
+		Headers:    make(map[string]string, len(message.Header)),
+		Size:       int64(len(raw)),
+		Auth:       mailauth.FromHeader(message.Header),
+	}
+	if date, err := message.Header.Date(); err == nil {
+		email.Date = date
+	}
+	for name, values := range message.Header {
+		email.Headers[name] = decodeHeader(values[0])
+	}
+
+	root := &part{header: mimeHeader(message.Header), body: message.Body}
+	if err := walk(email, root, 0); err != nil {
+		return nil, err
+	}
+	return email, nil
+}
+
+// part is a MIME entity within a message
+type part struct {
+	header mimeHeader
+	body   io.Reader
+	number []int
+}
+
+// mimeHeader is the header of a MIME entity
+type mimeHeader map[string][]string
+
+// get returns the first value of a header
+func (h mimeHeader) get(name string) string {
+	if values := h[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// walk fills the body and the attachments of email from a MIME entity and
+// the entities nested in it
+func walk(email *types.Email, p *part, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(p.header.get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxDepth {
+			return fmt.Errorf("failed to parse message: multipart nesting deeper than %d", maxDepth)
+		}
+		security := mailsec.FromMediaType(mediaType, params)
+		mailsec.Mark(email, security)
+		if security != nil && security.Encrypted {
+			return nil
+		}
+		reader := multipart.NewReader(p.body, params["boundary"])
+		for i := 1; ; i++ {
+			entity, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read MIME part: %w", err)
+			}
+			if security != nil && security.Signed && i > 1 {
+				// The part after the signed payload is its signature
+				continue
+			}
+			child := &part{
+				header: mimeHeader(entity.Header),
+				body:   entity,
+				number: append(append([]int(nil), p.number...), i),
+			}
+			if err := walk(email, child, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	if security := mailsec.FromMediaType(mediaType, params); security != nil {
+		mailsec.Mark(email, security)
+		return nil
+	}
+
+	content, err := io.ReadAll(decodeTransfer(p.body, p.header.get("Content-Transfer-Encoding")))
+	if err != nil {
+		return fmt.Errorf("failed to decode MIME part: %w", err)
+	}
+
+	if filename := attachmentName(p.header); filename != "" {
+		email.Attachments = append(email.Attachments, types.Attachment{
+			ID:       partNumber(p.number),
+			Filename: filename,
+			MimeType: mediaType,
+			Size:     int64(len(content)),
+		})
+		return nil
+	}
+
+	switch mediaType {
+	case "text/plain":
+		if email.Body == "" {
+			body, security := mailsec.Inline(string(content))
+			mailsec.Mark(email, security)
+			email.Body = body
+		}
+	case "text/html":
+		if email.BodyHTML == "" {
+			email.BodyHTML = string(content)
+		}
+	}
+	return nil
+}
+
+// decodeTransfer undoes a part's Content-Transfer-Encoding
+func decodeTransfer(body io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &lineStripper{reader: bufio.NewReader(body)})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// lineStripper drops the line breaks base64 bodies are wrapped with
+type lineStripper struct {
+	reader *bufio.Reader
+}
+
+func (l *lineStripper) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		b, err := l.reader.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		if b == '\r' || b == '\n' {
+			continue
+		}
+		p[n] = b
+		n++
+	}
+	return n, nil
+}
+
+// attachmentName returns the filename of an attachment part, or "" for an
+// inline body part
+func attachmentName(header mimeHeader) string {
+	disposition, params, err := mime.ParseMediaType(header.get("Content-Disposition"))
+	if err == nil && params["filename"] != "" {
+		return decodeHeader(params["filename"])
+	}
+	if _, params, err := mime.ParseMediaType(header.get("Content-Type")); err == nil && params["name"] != "" {
+		return decodeHeader(params["name"])
+	}
+	if disposition == "attachment" {
+		return "unnamed"
+	}
+	return ""
+}
+
+// partNumber formats a MIME part number as IMAP does, such as "1.2"
+func partNumber(number []int) string {
+	if len(number) == 0 {
+		return "1"
+	}
+	parts := make([]string, len(number))
+	for i, n := range number {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
+
+// decodeHeader decodes the RFC 2047 encoded words of a header value, keeping
+// the raw value when they cannot be decoded
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// AddressList splits an address header such as To or Cc into its
+// addresses, formatted by FormatAddress. Display names may hold commas when
+// quoted, and groups such as "Team: a@example.com, b@example.com;" list
+// their members. A header that does not parse as a whole is split on the
+// commas outside quotes, angle brackets and comments, each address parsed
+// on its own or else kept as it is.
+func AddressList(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	addresses, err := mail.ParseAddressList(value)
+	if err != nil {
+		return splitAddressList(value)
+	}
+	list := make([]string, len(addresses))
+	for i, address := range addresses {
+		list[i] = FormatAddress(address)
+	}
+	return list
+}
+
+// addressSpecials are the characters that make a display name ambiguous
+// unless it is quoted
+const addressSpecials = ",;:<>@\\\"()[]"
+
+// FormatAddress formats an address as "Name <address>", quoting the name
+// when it holds a comma or another special character, or as the bare
+// address when it has no name. The result parses back to the same address.
+func FormatAddress(address *mail.Address) string {
+	if address.Name == "" {
+		return address.Address
+	}
+	name := address.Name
+	if strings.ContainsAny(name, addressSpecials) {
+		name = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+	return name + " <" + address.Address + ">"
+}
+
+// splitAddressList splits a malformed address header on the commas between
+// its addresses, unwrapping groups
+func splitAddressList(value string) []string {
+	var list []string
+	add := func(part string) {
+		part = strings.TrimSpace(part)
+		if colon := strings.Index(part, ":"); colon >= 0 && !strings.ContainsAny(part[:colon], "<\"") {
+			// The first member of a group, after the group's name
+			part = strings.TrimSpace(part[colon+1:])
+		}
+		part = strings.TrimSpace(strings.TrimSuffix(part, ";"))
+		if part == "" {
+			return
+		}
+		if address, err := mail.ParseAddress(part); err == nil {
+			list = append(list, FormatAddress(address))
+			return
+		}
+		list = append(list, decodeHeader(part))
+	}
+
+	var quoted, escaped bool
+	var angle, comment, start int
+	for i, r := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case quoted:
+			quoted = r != '"'
+		case r == '"':
+			quoted = true
+		case r == '<':
+			angle++
+		case r == '>' && angle > 0:
+			angle--
+		case r == '(':
+			comment++
+		case r == ')' && comment > 0:
+			comment--
+		case (r == ',' || r == ';') && angle == 0 && comment == 0:
+			add(value[start:i])
+			start = i + 1
+		}
+	}
+	add(value[start:])
+	return list
+}
+
+// threadID identifies the conversation of a message by the first message ID
+// in its References, the message it replies to when it has no References,
+// or its own Message-ID when it starts one
+func threadID(header mail.Header) string {
+	if references := MessageIDs(header.Get("References")); len(references) > 0 {
+		return references[0]
+	}
+	if inReplyTo := MessageID(header.Get("In-Reply-To")); inReplyTo != "" {
+		return inReplyTo
+	}
+	return MessageID(header.Get("Message-Id"))
+}
+
+// MessageIDs returns the message IDs of a Message-ID, In-Reply-To or
+// References header value, without their angle brackets. IDs written
+// without brackets, as some clients do, are split on whitespace.
+func MessageIDs(value string) []string {
+	var ids []string
+	for {
+		start := strings.IndexByte(value, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(value[start:], '>')
+		if end < 0 {
+			break
+		}
+		if id := strings.TrimSpace(value[start+1 : start+end]); id != "" {
+			ids = append(ids, id)
+		}
+		value = value[start+end+1:]
+	}
+	if fields := strings.Fields(value); ids == nil && len(fields) > 0 {
+		ids = fields
+	}
+	return ids
+}
+
+// MessageID returns the first message ID of a header value, or "" when it
+// has none
+func MessageID(value string) string {
+	if ids := MessageIDs(value); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
@@ -0,0 +1,239 @@
+package rfc822
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/emptybody"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestParseMultipartMessage(t *testing.T) {
+	email, err := Parse([]byte(multipartMessage))
+	require.NoError(t, err)
+
+	assert.Equal(t, "Facture n° 42", email.Subject, "encoded words are decoded")
+	assert.Equal(t, "Billing <billing@vendor.example>", email.From)
+	assert.Equal(t, []string{"Me <me@example.com>", "team@example.com"}, email.To)
+	assert.Equal(t, "root@vendor.example", email.ThreadID)
+	assert.Equal(t, time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC), email.Date.UTC())
+	assert.Equal(t, "Your invoice is attached = due today.", strings.TrimSpace(email.Body), "quoted-printable is decoded")
+	assert.Contains(t, email.BodyHTML, "<b>invoice</b>")
+	assert.Equal(t, int64(len(multipartMessage)), email.Size)
+	assert.Equal(t, &types.AuthResults{SPF: "pass", DKIM: "pass", DMARC: "pass"}, email.Auth)
+
+	require.Len(t, email.Attachments, 1)
+	assert.Equal(t, types.Attachment{ID: "2", Filename: "invoice.pdf.exe", MimeType: "application/octet-stream", Size: 11}, email.Attachments[0])
+	assert.True(t, email.HasDangerousAttachment())
+}
+
+func TestParsePlainMessage(t *testing.T) {
+	email, err := Parse([]byte("From: a@example.com\r\nSubject: Hi\r\nMessage-ID: <abc@example.com>\r\n\r\nHello\r\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "Hi", email.Subject)
+	assert.Equal(t, "Hello\r\n", email.Body, "a message without Content-Type is plain text")
+	assert.Equal(t, "abc@example.com", email.ThreadID)
+	assert.Nil(t, email.To)
+	assert.Empty(t, email.Attachments)
+}
+
+func TestParseRecipients(t *testing.T) {
+	email, err := Parse(readFixture(t, "recipients.eml"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		`"Doe, John" <john.doe@example.com>`,
+		"alice@example.com",
+		"Bob Stone <bob@example.com>",
+		"jane@example.com",
+	}, email.To, "quoted names keep their commas and groups list their members")
+	assert.Equal(t, []string{`"Müller, Jens" <jens@example.com>`}, email.CC, "empty groups list no one")
+}
+
+func TestAddressList(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected []string
+	}{
+		{"empty", "  ", nil},
+		{"bare addresses", "a@example.com, b@example.com", []string{"a@example.com", "b@example.com"}},
+		{"quoted comma", `"Doe, John" <j@example.com>`, []string{`"Doe, John" <j@example.com>`}},
+		{"escaped quote", `"Ann \"Annie\" Lee" <ann@example.com>`, []string{`"Ann \"Annie\" Lee" <ann@example.com>`}},
+		{"comment as name", "j@example.com (Doe, John)", []string{`"Doe, John" <j@example.com>`}},
+		// Unparseable lists are split on the commas between addresses
+		{"malformed member", `"Doe, John" <j@example.com>, not an address, Team: a@example.com;`, []string{`"Doe, John" <j@example.com>`, "not an address", "a@example.com"}},
+		{"unbalanced bracket", "Ann <ann@example.com, bob@example.com", []string{"Ann <ann@example.com, bob@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, AddressList(tt.value))
+		})
+	}
+}
+
+func TestParseThreadingHeaders(t *testing.T) {
+	reply, err := Parse(readFixture(t, "reply_genuine.eml"))
+	require.NoError(t, err)
+	assert.Equal(t, "reply-2@vendor.example", reply.MessageID)
+	assert.Equal(t, "paid-1@company.example", reply.InReplyTo)
+	assert.Nil(t, reply.References)
+	assert.Equal(t, "paid-1@company.example", reply.ThreadID, "a reply without References threads with the message it replies to")
+
+	spoofed, err := Parse(readFixture(t, "reply_spoofed.eml"))
+	require.NoError(t, err)
+	assert.Equal(t, "RE: Urgent wire transfer", spoofed.Subject)
+	assert.Empty(t, spoofed.InReplyTo)
+	assert.Nil(t, spoofed.References)
+	assert.Equal(t, spoofed.MessageID, spoofed.ThreadID)
+}
+
+func TestParseSystemMail(t *testing.T) {
+	dsn, err := Parse(readFixture(t, "delivery_status.eml"))
+	require.NoError(t, err)
+	assert.Equal(t, "Delivery Status Notification (Failure)", dsn.Subject)
+	assert.Equal(t, "auto-replied", dsn.Headers["Auto-Submitted"])
+	assert.Empty(t, strings.TrimSpace(dsn.Body), "the delivery status is not part of the body")
+	assert.Empty(t, dsn.Attachments)
+	assert.True(t, emptybody.IsEmpty(dsn))
+
+	invite, err := Parse(readFixture(t, "calendar_invite.eml"))
+	require.NoError(t, err)
+	assert.Empty(t, strings.TrimSpace(invite.Body), "the calendar part is not part of the body")
+	assert.Equal(t, []types.Attachment{{ID: "2", Filename: "invite.ics", MimeType: "application/ics", Size: 48}}, invite.Attachments)
+	assert.True(t, emptybody.IsEmpty(invite))
+}
+
+func TestMessageIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected []string
+	}{
+		{"empty", " ", nil},
+		{"single", "<a@example.com>", []string{"a@example.com"}},
+		{"folded", "<a@example.com>\r\n <b@example.com>", []string{"a@example.com", "b@example.com"}},
+		{"adjacent", "<a@example.com><b@example.com>", []string{"a@example.com", "b@example.com"}},
+		{"comment", "<a@example.com> (Jane Doe's message)", []string{"a@example.com"}},
+		{"without brackets", "a@example.com b@example.com", []string{"a@example.com", "b@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, MessageIDs(tt.value))
+		})
+	}
+}
+
+func TestParseRejectsDeepNesting(t *testing.T) {
+	var message strings.Builder
+	message.WriteString("From: a@example.com\r\n")
+	for i := 0; i <= maxDepth; i++ {
+		message.WriteString("Content-Type: multipart/mixed; boundary=b" + strings.Repeat("x", i) + "\r\n\r\n")
+		message.WriteString("--b" + strings.Repeat("x", i) + "\r\n")
+	}
+
+	_, err := Parse([]byte(message.String()))
+	assert.ErrorContains(t, err, "multipart nesting")
+}
+
+func TestParsePGPSignedMessage(t *testing.T) {
+	email, err := Parse(readFixture(t, "pgp_signed.eml"))
+	require.NoError(t, err)
+
+	assert.Equal(t, &types.MessageSecurity{Signed: true, Protocol: types.SecurityPGP}, email.Security)
+	assert.True(t, strings.HasPrefix(email.Body, "The new release signing key"), "the signed payload is the body")
+	assert.Contains(t, email.BodyHTML, "<b>wiki</b>")
+	assert.Empty(t, email.Attachments, "the signature is not an attachment")
+}
+
+func TestParsePGPEncryptedMessage(t *testing.T) {
+	email, err := Parse(readFixture(t, "pgp_encrypted.eml"))
+	require.NoError(t, err)
+
+	assert.Equal(t, &types.MessageSecurity{Encrypted: true, Protocol: types.SecurityPGP}, email.Security)
+	assert.True(t, email.IsEncrypted())
+	assert.Empty(t, email.Body, "ciphertext is not classified")
+	assert.Empty(t, email.Attachments)
+	assert.Equal(t, "Quarterly numbers", email.Subject)
+}
+
+func TestParseSMIMEEncryptedMessage(t *testing.T) {
+	message := "From: a@example.com\r\nSubject: Contract\r\nMIME-Version: 1.0\r\n" +
+		"Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=smime.p7m\r\n" +
+		"Content-Disposition: attachment; filename=smime.p7m\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\nMIAGCSqGSIb3DQEHA6CAMIACAQAxggE=\r\n"
+
+	email, err := Parse([]byte(message))
+	require.NoError(t, err)
+
+	assert.Equal(t, &types.MessageSecurity{Encrypted: true, Protocol: types.SecuritySMIME}, email.Security)
+	assert.Empty(t, email.Body)
+	assert.Empty(t, email.Attachments)
+}
+
+func TestParseInlinePGPSignedMessage(t *testing.T) {
+	message := "From: a@example.com\r\nSubject: Hi\r\n\r\n" +
+		"-----BEGIN PGP SIGNED MESSAGE-----\r\nHash: SHA256\r\n\r\n" +
+		"Meet at noon.\r\n- -- Alice\r\n" +
+		"-----BEGIN PGP SIGNATURE-----\r\n\r\niHUEARYIAB0=\r\n-----END PGP SIGNATURE-----\r\n"
+
+	email, err := Parse([]byte(message))
+	require.NoError(t, err)
+
+	assert.Equal(t, &types.MessageSecurity{Signed: true, Protocol: types.SecurityPGP}, email.Security)
+	assert.Equal(t, "Meet at noon.\n-- Alice", email.Body, "the armor is removed and dash escaping undone")
+}
+
+func TestParseInvalidMessage(t *testing.T) {
+	_, err := Parse([]byte("not a message"))
+	assert.Error(t, err)
+}
+
+// Helper functions
+
+const multipartMessage = "Authentication-Results: mx.example.com; spf=pass smtp.mailfrom=vendor.example;\r\n" +
+	" dkim=pass header.d=vendor.example; dmarc=pass header.from=vendor.example\r\n" +
+	"From: Billing <billing@vendor.example>\r\n" +
+	"To: Me <me@example.com>, team@example.com\r\n" +
+	"Subject: =?UTF-8?Q?Facture_n=C2=B0_42?=\r\n" +
+	"Date: Fri, 01 Mar 2024 09:30:00 +0000\r\n" +
+	"Message-ID: <reply@vendor.example>\r\n" +
+	"References: <root@vendor.example> <reply@vendor.example>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Your invoice is attached =3D due today.\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Your <b>invoice</b> is attached.</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Disposition: attachment; filename=\"invoice.pdf.exe\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"aGVsbG8g\r\nd29ybGQ=\r\n" +
+	"--outer--\r\n"
+
+// readFixture reads a raw message from testdata
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return raw
+}
@@ -0,0 +1,652 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/annotate"
+	"github.com/mailsentinel/core/internal/dedup"
+	"github.com/mailsentinel/core/internal/export"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// ContentTypeNDJSON selects a streamed batch response when sent in Accept
+const ContentTypeNDJSON = "application/x-ndjson"
+
+// ContentTypeCSV selects a CSV batch response, one export.Row per result,
+// when sent in Accept
+const ContentTypeCSV = "text/csv"
+
+// HeaderCorrelationID carries a batch's correlation ID. A client-supplied
+// value is used when logging.ValidCorrelationID accepts it; otherwise one
+// is generated. Either way it is echoed in the response and prefixes the
+// correlation ID of each email's log lines and audit entries.
+const HeaderCorrelationID = "X-Correlation-ID"
+
+// requestCorrelationID returns the request's correlation ID, generating one
+// when the client sent none or one that is not valid, and echoes it in the
+// response
+func requestCorrelationID(w http.ResponseWriter, r *http.Request) string {
+	correlationID := r.Header.Get(HeaderCorrelationID)
+	if !logging.ValidCorrelationID(correlationID) {
+		correlationID = logging.NewCorrelationID()
+	}
+	w.Header().Set(HeaderCorrelationID, correlationID)
+	return correlationID
+}
+
+// defaultBatchWorkers is used when ServerConfig.BatchWorkers is unset
+const defaultBatchWorkers = 4
+
+// BatchTrailer is the final line of a streamed batch response. Every line
+// before it is a types.ClassificationResponse.
+type BatchTrailer struct {
+	Summary     types.BatchSummary `json:"summary"`
+	ProcessedAt time.Time          `json:"processed_at"`
+	DryRun      bool               `json:"dry_run"`
+}
+
+// batchItem is the outcome of classifying one email in a batch
+type batchItem struct {
+	email    *types.Email
+	decision *types.Decision
+	err      error
+}
+
+// classifyFunc classifies one email of a batch and resolves its results
+// into a decision. A nil decision without an error means no profile applied
+// to the email.
+type classifyFunc func(ctx context.Context, email *types.Email) (*types.Decision, error)
+
+// handleBatch classifies every email in a batch request against the
+// requested profile or, when profile_id is omitted and routing is enabled,
+// against the profiles routed to each email. When dedup is enabled, each
+// group of near-identical emails is classified once and its duplicates are
+// given the representative's result. With a processor, each decision is
+// applied, or planned in a dry run, as soon as it is reached. Clients
+// sending Accept: application/x-ndjson receive each result as soon as it
+// completes, followed by a BatchTrailer; clients sending Accept: text/csv
+// receive the results as CSV export rows, without the summary; all other
+// clients receive a single types.BatchResponse. If the client disconnects,
+// the remaining classifications are cancelled.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	correlationID := requestCorrelationID(w, r)
+
+	if s.lifecycle.Stopping() {
+		s.writeError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+
+	if limit := s.config.Security.MaxBatchBytes; limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	var req types.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("batch request exceeds the maximum of %d bytes", tooLarge.Limit))
+			return
+		}
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid batch request: %v", err))
+		return
+	}
+	if err := s.validateBatch(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for i := range req.Emails {
+		req.Emails[i].MergeContext(req.Context)
+		annotate.Email(&req.Emails[i])
+	}
+
+	classify, err := s.classifierFor(req.ProfileID)
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(logging.WithCorrelationID(r.Context(), correlationID))
+	defer cancel()
+	logger := logging.Bind(s.logger, correlationID)
+
+	// Every email's context derives from the budgeted one, so that the
+	// remaining time also bounds classifications in flight
+	budgetCtx := ctx
+	if budget := s.batchBudget(&req); budget > 0 {
+		var cancelBudget context.CancelFunc
+		budgetCtx, cancelBudget = context.WithTimeout(ctx, budget)
+		defer cancelBudget()
+	}
+
+	representatives, duplicates, groups := s.deduplicate(req.Emails)
+	representatives, completed := s.skipCompleted(correlationID, req.DryRun, representatives, duplicates)
+
+	streaming := acceptsNDJSON(r)
+	csvOutput := !streaming && accepts(r, ContentTypeCSV)
+	logger.WithFields(logrus.Fields{
+		"email_count": len(req.Emails),
+		"duplicates":  len(req.Emails) - len(representatives),
+		"profile_id":  req.ProfileID,
+		"routed":      req.ProfileID == "",
+		"streaming":   streaming,
+	}).Info("Processing batch request")
+
+	var stream *ndjsonWriter
+	if streaming {
+		stream = newNDJSONWriter(w)
+	}
+
+	startTime := time.Now()
+	response := &types.BatchResponse{
+		DryRun: req.DryRun,
+		Summary: types.BatchSummary{
+			TotalEmails:         len(req.Emails),
+			ActionCounts:        make(map[string]int),
+			DuplicateGroups:     groups,
+			ConfidenceHistogram: types.NewConfidenceHistogram(s.config.Server.ConfidenceBuckets),
+		},
+	}
+
+	var totalConfidence float64
+	outcomes := make(map[*types.Email]bool, len(req.Emails))
+	for _, email := range completed {
+		outcomes[email] = true
+		response.Summary.SkippedEmails++
+	}
+	for classified := range s.classifyBatch(budgetCtx, classify, representatives) {
+		for _, item := range withDuplicates(classified, duplicates[classified.email]) {
+			if item.err != nil && budgetExceeded(ctx, budgetCtx) && errors.Is(item.err, context.DeadlineExceeded) {
+				// Cut short by the budget, so unprocessed rather than failed
+				continue
+			}
+			outcomes[item.email] = true
+			if s.actedOn(correlationID, req.DryRun, item.email) {
+				// One of a group whose other emails were left to act on
+				response.Summary.SkippedEmails++
+				s.progress.report(correlationID, &response.Summary)
+				continue
+			}
+			if item.err != nil {
+				response.Summary.AddFailure(item.email.ID, types.StageClassify, item.err)
+				if ctx.Err() == nil {
+					logger.WithError(item.err).WithField("email_id", item.email.ID).Error("Failed to classify email")
+					failure := &response.Summary.Failures[len(response.Summary.Failures)-1]
+					failure.DeadLetterID = s.deadLetter(ctx, item.email, req.ProfileID, item.err)
+				}
+				s.progress.report(correlationID, &response.Summary)
+				continue
+			}
+			if item.decision == nil {
+				response.Summary.SkippedEmails++
+				s.progress.report(correlationID, &response.Summary)
+				continue
+			}
+			emailCtx := logging.ForEmail(ctx, item.email.ID)
+			result := item.decision.Resolution
+			action := result.Action
+			if s.processor != nil {
+				applied, err := s.processor.ApplyDecision(emailCtx, correlationID, item.email, item.decision, req.DryRun)
+				if err != nil {
+					logger.WithError(err).WithField("email_id", item.email.ID).Error("Failed to apply decision")
+					response.Summary.AddFailure(item.email.ID, types.StageAction, err)
+					s.progress.report(correlationID, &response.Summary)
+					continue
+				}
+				response.Summary.Actions = append(response.Summary.Actions, *applied)
+				action = applied.Action
+			} else {
+				s.auditDecision(emailCtx, item.email, item.decision)
+			}
+
+			response.Summary.ProcessedEmails++
+			response.Summary.ActionCounts[action]++
+			response.Summary.RecordConfidence(action, result.Confidence)
+			totalConfidence += result.Confidence
+			s.progress.report(correlationID, &response.Summary)
+
+			if streaming {
+				if err := stream.write(result); err != nil {
+					// The client has gone away; stop the remaining work
+					cancel()
+				}
+				continue
+			}
+			response.Results = append(response.Results, *result)
+		}
+	}
+
+	if response.Summary.ProcessedEmails > 0 {
+		response.Summary.AvgConfidence = totalConfidence / float64(response.Summary.ProcessedEmails)
+	}
+	response.Summary.ProcessingTime = time.Since(startTime)
+	response.ProcessedAt = time.Now()
+	if budgetExceeded(ctx, budgetCtx) {
+		for i := range req.Emails {
+			if !outcomes[&req.Emails[i]] {
+				response.Summary.UnprocessedEmails = append(response.Summary.UnprocessedEmails, req.Emails[i].ID)
+			}
+		}
+		response.Summary.BudgetExceeded = true
+		logger.WithFields(logrus.Fields{
+			"processed":   response.Summary.ProcessedEmails,
+			"unprocessed": len(response.Summary.UnprocessedEmails),
+		}).Warn("Batch time budget exceeded, returning partial results")
+	}
+
+	if ctx.Err() != nil {
+		logger.WithFields(logrus.Fields{
+			"processed": response.Summary.ProcessedEmails,
+			"total":     response.Summary.TotalEmails,
+		}).Warn("Batch request cancelled before completion")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"processed": response.Summary.ProcessedEmails,
+		"failed":    response.Summary.FailedEmails,
+	}).Info("Finished batch request")
+	if !response.Summary.BudgetExceeded {
+		s.finishBatch(logger, correlationID, req.DryRun)
+	}
+
+	if streaming {
+		stream.write(&BatchTrailer{
+			Summary:     response.Summary,
+			ProcessedAt: response.ProcessedAt,
+			DryRun:      response.DryRun,
+		})
+		return
+	}
+	if csvOutput {
+		w.Header().Set("Content-Type", ContentTypeCSV+"; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if err := export.WriteCSV(w, export.BatchRows(response, req.Emails)); err != nil {
+			logger.WithError(err).Warn("Failed to write CSV batch response")
+		}
+		return
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// batchBudget returns the time budget of a validated batch request: the
+// shorter of the configured budget and the request's own, or zero when
+// neither sets one
+func (s *Server) batchBudget(req *types.BatchRequest) time.Duration {
+	budget := s.config.Server.BatchBudget
+	if req.Budget != "" {
+		if requested, _ := time.ParseDuration(req.Budget); budget == 0 || requested < budget {
+			budget = requested
+		}
+	}
+	return budget
+}
+
+// budgetExceeded reports whether a batch stopped because its budget ran out,
+// as opposed to the client going away
+func budgetExceeded(ctx, budgetCtx context.Context) bool {
+	return ctx.Err() == nil && errors.Is(budgetCtx.Err(), context.DeadlineExceeded)
+}
+
+// validateBatch checks a batch request before any work is started
+func (s *Server) validateBatch(req *types.BatchRequest) error {
+	if req.ProfileID == "" && s.router == nil {
+		return fmt.Errorf("profile_id is required")
+	}
+	if len(req.Emails) == 0 {
+		return fmt.Errorf("emails must not be empty")
+	}
+	if limit := s.config.Security.MaxBatchSize; limit > 0 && len(req.Emails) > limit {
+		return fmt.Errorf("batch of %d emails exceeds the maximum of %d", len(req.Emails), limit)
+	}
+	if req.Budget != "" {
+		if budget, err := time.ParseDuration(req.Budget); err != nil || budget <= 0 {
+			return fmt.Errorf("budget must be a positive duration such as \"30s\", got %q", req.Budget)
+		}
+	}
+	return nil
+}
+
+// deduplicate returns the emails of a batch to classify, the duplicates of
+// each that share its result and the groups to report in the summary. With
+// dedup disabled every email is classified.
+func (s *Server) deduplicate(emails []types.Email) ([]*types.Email, map[*types.Email][]*types.Email, []types.DuplicateGroup) {
+	if !s.config.Server.Dedup.Enabled {
+		representatives := make([]*types.Email, len(emails))
+		for i := range emails {
+			representatives[i] = &emails[i]
+		}
+		return representatives, nil, nil
+	}
+
+	var representatives []*types.Email
+	duplicates := make(map[*types.Email][]*types.Email)
+	var groups []types.DuplicateGroup
+	for _, group := range dedup.NewDeduplicator(s.config.Server.Dedup).Group(emails) {
+		representatives = append(representatives, group.Representative)
+		if len(group.Duplicates) == 0 {
+			continue
+		}
+
+		duplicates[group.Representative] = group.Duplicates
+		summary := types.DuplicateGroup{Representative: group.Representative.ID}
+		for _, duplicate := range group.Duplicates {
+			summary.Duplicates = append(summary.Duplicates, duplicate.ID)
+		}
+		groups = append(groups, summary)
+	}
+	return representatives, duplicates, groups
+}
+
+// skipCompleted drops the representatives the processor's cursor records as
+// acted on in the batch, along with their duplicates, so that they are not
+// classified again, returning the representatives left and the emails
+// dropped. A group with any email left to act on is kept whole. Dry runs
+// drop none.
+func (s *Server) skipCompleted(batchID string, dryRun bool, representatives []*types.Email, duplicates map[*types.Email][]*types.Email) ([]*types.Email, []*types.Email) {
+	if s.processor == nil || dryRun {
+		return representatives, nil
+	}
+
+	var pending, completed []*types.Email
+	for _, email := range representatives {
+		group := append([]*types.Email{email}, duplicates[email]...)
+		done := true
+		for _, member := range group {
+			if !s.processor.Completed(batchID, member.ID) {
+				done = false
+				break
+			}
+		}
+		if done {
+			completed = append(completed, group...)
+			continue
+		}
+		pending = append(pending, email)
+	}
+	return pending, completed
+}
+
+// actedOn reports whether the processor's cursor records the email as acted
+// on in the batch outside a dry run
+func (s *Server) actedOn(batchID string, dryRun bool, email *types.Email) bool {
+	return s.processor != nil && !dryRun && s.processor.Completed(batchID, email.ID)
+}
+
+// finishBatch drops the cursor's progress of a batch that ran to the end,
+// so that sending it again under the same correlation ID classifies and
+// acts on every email afresh. A batch cut short by shutdown keeps its
+// progress for the next server to resume.
+func (s *Server) finishBatch(logger *logrus.Entry, batchID string, dryRun bool) {
+	if s.processor == nil || dryRun || s.lifecycle.Stopping() {
+		return
+	}
+	if err := s.processor.FinishBatch(batchID); err != nil {
+		logger.WithError(err).Warn("Failed to finish batch in cursor")
+	}
+}
+
+// withDuplicates returns the outcome of classifying a representative for the
+// representative itself and for each of its duplicates. A duplicate's
+// decision shares the representative's classifications and resolves to a
+// copy of its resolution recording the representative in its metadata.
+func withDuplicates(item batchItem, duplicates []*types.Email) []batchItem {
+	items := []batchItem{item}
+	for _, duplicate := range duplicates {
+		copied := batchItem{email: duplicate, err: item.err}
+		if item.decision != nil {
+			resolution := item.decision.Resolution
+			result := *resolution
+			result.EmailID = duplicate.ID
+			result.Metadata = make(map[string]interface{}, len(resolution.Metadata)+1)
+			for key, value := range resolution.Metadata {
+				result.Metadata[key] = value
+			}
+			result.Metadata[types.MetadataDedupedFrom] = item.email.ID
+			copied.decision = types.NewDecision(duplicate.ID, item.decision.Classifications, &result)
+		}
+		items = append(items, copied)
+	}
+	return items
+}
+
+// classifierFor returns the classifyFunc of a batch: classification by the
+// profile with profileID and its shadows or, when profileID is empty, a
+// routed classification
+func (s *Server) classifierFor(profileID string) (classifyFunc, error) {
+	registry := s.profiles.GetRegistry()
+	if profileID == "" {
+		return s.classifyRouted(registry), nil
+	}
+
+	profile, err := s.profiles.GetProfile(profileID)
+	if err != nil {
+		return nil, err
+	}
+	shadows := shadowsOf(registry)[profile.ID]
+	return func(ctx context.Context, email *types.Email) (*types.Decision, error) {
+		result, err := s.classifyWithShadows(ctx, profile, shadows, email)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewDecision(email.ID, []*types.ClassificationResponse{result}, result), nil
+	}, nil
+}
+
+// classifyRouted returns a classifyFunc running each email through the
+// profiles the router selects, in dependency order so that conditional
+// execution can read earlier results, and resolving their results into one
+// decision, explained when the resolver is a Decider. Any profile failing
+// fails the email, since resolving without it could miss an override such
+// as a security rule.
+func (s *Server) classifyRouted(registry *types.ProfileRegistry) classifyFunc {
+	profiles := activeProfiles(registry)
+	shadows := shadowsOf(registry)
+
+	return func(ctx context.Context, email *types.Email) (*types.Decision, error) {
+		var results []*types.ClassificationResponse
+		for _, profile := range s.router.Route(email, profiles) {
+			if !s.router.ShouldExecute(profile, email, results) {
+				continue
+			}
+			result, err := s.classifyWithShadows(ctx, profile, shadows[profile.ID], email)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s: %w", profile.ID, err)
+			}
+			results = append(results, result)
+		}
+
+		if len(results) == 0 {
+			return nil, nil
+		}
+		if decider, ok := s.resolver.(Decider); ok {
+			return decider.Decide(email, results)
+		}
+		resolution, err := s.resolver.ResolveDecision(email, results)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewDecision(email.ID, results, resolution), nil
+	}
+}
+
+// activeProfiles returns the registry's profiles other than shadows, in
+// dependency order
+func activeProfiles(registry *types.ProfileRegistry) []*types.Profile {
+	var profiles []*types.Profile
+	if registry == nil {
+		return profiles
+	}
+	for _, id := range registry.LoadOrder {
+		if profile, exists := registry.Profiles[id]; exists && !profile.IsShadow() {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles
+}
+
+// shadowsOf indexes the registry's shadow profiles by the active profile
+// each runs alongside
+func shadowsOf(registry *types.ProfileRegistry) map[string][]*types.Profile {
+	shadows := make(map[string][]*types.Profile)
+	if registry == nil {
+		return shadows
+	}
+	for _, id := range registry.LoadOrder {
+		if profile, exists := registry.Profiles[id]; exists && profile.IsShadow() {
+			shadows[profile.ShadowOf] = append(shadows[profile.ShadowOf], profile)
+		}
+	}
+	return shadows
+}
+
+// classifyWithShadows classifies an email with profile and, concurrently,
+// with the shadow profiles running alongside it. Shadow results are audited
+// by the backend, tagged as shadow, and otherwise discarded: they never
+// reach resolution or the response. A failing shadow is only logged.
+func (s *Server) classifyWithShadows(ctx context.Context, profile *types.Profile, shadows []*types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	var wg sync.WaitGroup
+	for _, shadow := range shadows {
+		wg.Add(1)
+		go func(shadow *types.Profile) {
+			defer wg.Done()
+			if _, err := s.classifier.ClassifyEmail(ctx, shadow, email); err != nil && ctx.Err() == nil {
+				logging.FromContext(ctx, s.logger).WithError(err).WithFields(logrus.Fields{
+					"email_id":   email.ID,
+					"profile_id": shadow.ID,
+					"shadow_of":  profile.ID,
+				}).Warn("Shadow classification failed")
+			}
+		}(shadow)
+	}
+
+	result, err := s.classifier.ClassifyEmail(ctx, profile, email)
+	wg.Wait()
+	return result, err
+}
+
+// classifyTracked classifies an email as a unit of in-flight work, so that
+// shutdown waits for it. Once shutdown has started the email fails with
+// lifecycle.ErrShuttingDown without being classified.
+func (s *Server) classifyTracked(ctx context.Context, classify classifyFunc, email *types.Email) (*types.Decision, error) {
+	done, err := s.lifecycle.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return classify(logging.ForEmail(ctx, email.ID), email)
+}
+
+// auditDecision records the decision reached for an email in the audit log,
+// if one is configured. Audit failures are logged but do not fail the email.
+func (s *Server) auditDecision(ctx context.Context, email *types.Email, decision *types.Decision) {
+	if s.audit == nil {
+		return
+	}
+	if err := s.audit.LogDecision(ctx, email, decision); err != nil {
+		logging.FromContext(ctx, s.logger).WithError(err).WithField("email_id", email.ID).Error("Failed to audit decision")
+	}
+}
+
+// classifyBatch classifies emails on a pool of workers, sending each outcome
+// as soon as it completes. The channel is closed once every worker has
+// stopped; after ctx is cancelled no new classifications are started, but
+// the outcomes of those in flight are still sent, so the caller must drain
+// the channel.
+func (s *Server) classifyBatch(ctx context.Context, classify classifyFunc, emails []*types.Email) <-chan batchItem {
+	workers := s.config.Server.BatchWorkers
+	if workers <= 0 {
+		workers = defaultBatchWorkers
+	}
+	if workers > len(emails) {
+		workers = len(emails)
+	}
+
+	jobs := make(chan *types.Email)
+	items := make(chan batchItem)
+
+	go func() {
+		defer close(jobs)
+		for _, email := range emails {
+			select {
+			case jobs <- email:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for email := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				decision, err := s.classifyTracked(ctx, classify, email)
+				items <- batchItem{email: email, decision: decision, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(items)
+	}()
+
+	return items
+}
+
+// acceptsNDJSON reports whether the client asked for a streamed response
+func acceptsNDJSON(r *http.Request) bool {
+	return accepts(r, ContentTypeNDJSON)
+}
+
+// accepts reports whether the client listed contentType in Accept
+func accepts(r *http.Request, contentType string) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// ndjsonWriter writes newline-delimited JSON values, flushing each one to
+// the client immediately
+type ndjsonWriter struct {
+	controller *http.ResponseController
+	encoder    *json.Encoder
+}
+
+func newNDJSONWriter(w http.ResponseWriter) *ndjsonWriter {
+	controller := http.NewResponseController(w)
+	// A stream lasts as long as the batch, not the server's write timeout
+	controller.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", ContentTypeNDJSON)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	return &ndjsonWriter{controller: controller, encoder: json.NewEncoder(w)}
+}
+
+// write encodes v as one line and flushes it
+func (n *ndjsonWriter) write(v interface{}) error {
+	if err := n.encoder.Encode(v); err != nil {
+		return err
+	}
+	return n.controller.Flush()
+}
@@ -0,0 +1,984 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/cursor"
+	"github.com/mailsentinel/core/internal/export"
+	"github.com/mailsentinel/core/internal/lifecycle"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/internal/processor"
+	"github.com/mailsentinel/core/internal/profile"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestBatchJSONResponse(t *testing.T) {
+	classifier := newFakeClassifier()
+	classifier.failures["email-2"] = fmt.Errorf("model unavailable")
+	server := httptest.NewServer(NewServer(testConfig(2), classifier, testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	resp := postBatch(t, server.URL, "application/json", testBatch(3))
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var batch types.BatchResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&batch))
+	assert.Len(t, batch.Results, 2)
+	assert.Equal(t, 3, batch.Summary.TotalEmails)
+	assert.Equal(t, 2, batch.Summary.ProcessedEmails)
+	assert.Equal(t, 1, batch.Summary.FailedEmails)
+	assert.Equal(t, map[string]int{"archive": 2}, batch.Summary.ActionCounts)
+	assert.InDelta(t, 0.8, batch.Summary.AvgConfidence, 1e-9)
+	require.Len(t, batch.Summary.Failures, 1)
+	assert.Equal(t, "email-2", batch.Summary.Failures[0].EmailID)
+}
+
+func TestBatchProgress(t *testing.T) {
+	classifier := newFakeClassifier()
+	classifier.failures["email-4"] = fmt.Errorf("model unavailable")
+	srv := NewServer(testConfig(3), classifier, testProfiles(), testLogger())
+	var progress []BatchProgress
+	srv.SetBatchProgress(3, func(p BatchProgress) {
+		progress = append(progress, p)
+	})
+	server := httptest.NewServer(srv.Handler())
+	defer server.Close()
+
+	resp := postBatch(t, server.URL, "application/json", testBatch(7))
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.Len(t, progress, 3, "every 3 emails and once the last completes")
+	for i, p := range progress {
+		assert.Equal(t, resp.Header.Get(HeaderCorrelationID), p.CorrelationID)
+		assert.Equal(t, 7, p.Total)
+		assert.Equal(t, []int{3, 6, 7}[i], p.Completed())
+		if i > 0 {
+			assert.GreaterOrEqual(t, p.Processed, progress[i-1].Processed)
+			assert.GreaterOrEqual(t, p.Failed, progress[i-1].Failed)
+		}
+	}
+	assert.Equal(t, BatchProgress{CorrelationID: progress[2].CorrelationID, Total: 7, Processed: 6, Failed: 1}, progress[2])
+}
+
+func TestBatchSummaryConfidenceHistogram(t *testing.T) {
+	cfg := testConfig(2)
+	cfg.Server.ConfidenceBuckets = []float64{0.5, 0.9}
+	server := httptest.NewServer(NewServer(cfg, newFakeClassifier(), testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	resp := postBatch(t, server.URL, "application/json", testBatch(3))
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var batch types.BatchResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&batch))
+	assert.Equal(t, []types.ConfidenceBucket{
+		{Min: 0, Max: 0.5, Count: 0},
+		{Min: 0.5, Max: 0.9, Count: 3},
+		{Min: 0.9, Max: 1, Count: 0},
+	}, batch.Summary.ConfidenceHistogram)
+	assert.Equal(t, map[string]types.ConfidenceStats{
+		"archive": {Count: 3, Min: 0.8, Max: 0.8, Avg: 0.8},
+	}, batch.Summary.ActionConfidence)
+}
+
+func TestBatchCSVResponse(t *testing.T) {
+	server := httptest.NewServer(NewServer(testConfig(2), newFakeClassifier(), testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	batch := testBatch(2)
+	batch.Emails[0].From = "Shop <deals@shop.example.com>"
+	batch.Emails[0].Subject = "Deals, \"coupons\"\nand more"
+	resp := postBatch(t, server.URL, "text/csv, application/json;q=0.5", batch)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3, "the header plus one row per email")
+	assert.Equal(t, export.Columns, records[0])
+
+	rows := make(map[string][]string)
+	for _, record := range records[1:] {
+		rows[record[0]] = record
+	}
+	assert.Equal(t, []string{"email-1", "Shop <deals@shop.example.com>", "Deals, \"coupons\"\nand more", "archive", "0.8", "newsletter", "", ""}, rows["email-1"])
+	assert.Equal(t, "archive", rows["email-2"][3])
+}
+
+func TestBatchNDJSONStream(t *testing.T) {
+	classifier := newFakeClassifier()
+	server := httptest.NewServer(NewServer(testConfig(2), classifier, testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	resp := postBatch(t, server.URL, ContentTypeNDJSON, testBatch(5))
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, ContentTypeNDJSON, resp.Header.Get("Content-Type"))
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, lines, 6, "one line per email plus the trailer")
+
+	seen := make(map[string]bool)
+	for _, line := range lines[:5] {
+		var result types.ClassificationResponse
+		require.NoError(t, json.Unmarshal(line, &result))
+		assert.Equal(t, "archive", result.Action)
+		seen[result.EmailID] = true
+	}
+	assert.Len(t, seen, 5)
+
+	var trailer BatchTrailer
+	require.NoError(t, json.Unmarshal(lines[5], &trailer))
+	assert.Equal(t, 5, trailer.Summary.ProcessedEmails)
+	assert.Equal(t, 5, trailer.Summary.ActionCounts["archive"])
+}
+
+func TestBatchNDJSONStreamsBeforeCompletion(t *testing.T) {
+	classifier := newFakeClassifier()
+	classifier.block["email-2"] = make(chan struct{})
+	server := httptest.NewServer(NewServer(testConfig(1), classifier, testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	resp := postBatch(t, server.URL, ContentTypeNDJSON, testBatch(3))
+	defer resp.Body.Close()
+
+	// The first result arrives while the second email is still blocked
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadBytes('\n')
+	require.NoError(t, err)
+	var result types.ClassificationResponse
+	require.NoError(t, json.Unmarshal(line, &result))
+	assert.Equal(t, "email-1", result.EmailID)
+
+	close(classifier.block["email-2"])
+	remaining := 0
+	for {
+		if _, err := reader.ReadBytes('\n'); err != nil {
+			break
+		}
+		remaining++
+	}
+	assert.Equal(t, 3, remaining, "two results and the trailer")
+}
+
+func TestBatchClientDisconnectCancelsWork(t *testing.T) {
+	classifier := newFakeClassifier()
+	classifier.block["email-2"] = make(chan struct{}) // never released
+	server := httptest.NewServer(NewServer(testConfig(1), classifier, testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	resp := postBatch(t, server.URL, ContentTypeNDJSON, testBatch(10))
+	_, err := bufio.NewReader(resp.Body).ReadBytes('\n')
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	select {
+	case <-classifier.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("classification was not cancelled after the client disconnected")
+	}
+	assert.Equal(t, 2, classifier.callCount(), "no classifications start after cancellation")
+}
+
+func TestBatchShutdownDrainsInFlightClassifications(t *testing.T) {
+	classifier := newFakeClassifier()
+	classifier.block["email-1"] = make(chan struct{})
+	coordinator := lifecycle.NewCoordinator(nil, testLogger())
+	srv := NewServer(testConfig(1), classifier, testProfiles(), testLogger())
+	srv.SetLifecycle(coordinator)
+	server := httptest.NewServer(srv.Handler())
+	defer server.Close()
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		responses <- postBatch(t, server.URL, "application/json", testBatch(3))
+	}()
+	require.Eventually(t, func() bool { return classifier.callCount() == 1 }, time.Second, time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- coordinator.Shutdown(context.Background())
+	}()
+	require.Eventually(t, coordinator.Stopping, time.Second, time.Millisecond)
+
+	// New batches are refused while the running one drains
+	refused := postBatch(t, server.URL, "application/json", testBatch(1))
+	refused.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, refused.StatusCode)
+
+	close(classifier.block["email-1"])
+	require.NoError(t, <-shutdown)
+
+	resp := <-responses
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The in-flight email completes; the rest fail without being classified
+	var batch types.BatchResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&batch))
+	require.Len(t, batch.Results, 1)
+	assert.Equal(t, "email-1", batch.Results[0].EmailID)
+	assert.Equal(t, 1, batch.Summary.ProcessedEmails)
+	assert.Equal(t, 2, batch.Summary.FailedEmails)
+	for _, failure := range batch.Summary.Failures {
+		assert.Equal(t, lifecycle.ErrShuttingDown.Error(), failure.Err)
+	}
+	assert.Equal(t, 1, classifier.callCount())
+}
+
+func TestBatchBudgetReturnsPartialResults(t *testing.T) {
+	classifier := newFakeClassifier()
+	classifier.block["email-2"] = make(chan struct{}) // never released
+	cfg := testConfig(1)
+	cfg.Server.BatchBudget = time.Minute
+	server := httptest.NewServer(NewServer(cfg, classifier, testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	// The request's shorter budget applies and trips on the blocked email
+	req := testBatch(4)
+	req.Budget = "50ms"
+	resp := postBatch(t, server.URL, "application/json", req)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var batch types.BatchResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&batch))
+	require.Len(t, batch.Results, 1)
+	assert.Equal(t, "email-1", batch.Results[0].EmailID)
+	assert.True(t, batch.Summary.BudgetExceeded)
+	assert.Equal(t, 1, batch.Summary.ProcessedEmails)
+	assert.Equal(t, 0, batch.Summary.FailedEmails)
+	assert.Equal(t, []string{"email-2", "email-3", "email-4"}, batch.Summary.UnprocessedEmails)
+	assert.Equal(t, 2, classifier.callCount(), "no classifications start once the budget is spent")
+}
+
+func TestBatchBudget(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured time.Duration
+		requested  string
+		expected   time.Duration
+	}{
+		{"unbounded", 0, "", 0},
+		{"configured only", time.Minute, "", time.Minute},
+		{"requested only", 0, "10s", 10 * time.Second},
+		{"requested shorter", time.Minute, "10s", 10 * time.Second},
+		{"requested longer", time.Minute, "1h", time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(1)
+			cfg.Server.BatchBudget = tt.configured
+			srv := NewServer(cfg, newFakeClassifier(), testProfiles(), testLogger())
+			assert.Equal(t, tt.expected, srv.batchBudget(&types.BatchRequest{Budget: tt.requested}))
+		})
+	}
+}
+
+func TestBatchBodyTooLarge(t *testing.T) {
+	cfg := testConfig(1)
+	cfg.Security.MaxBatchBytes = 256
+	server := httptest.NewServer(NewServer(cfg, newFakeClassifier(), testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	resp := postBatch(t, server.URL, "application/json", testBatch(5))
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	small := postBatch(t, server.URL, "application/json", &types.BatchRequest{ProfileID: "newsletter", Emails: []types.Email{{ID: "email-1"}}})
+	defer small.Body.Close()
+	assert.Equal(t, http.StatusOK, small.StatusCode)
+}
+
+func TestBatchValidation(t *testing.T) {
+	server := httptest.NewServer(NewServer(testConfig(1), newFakeClassifier(), testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	unknown := testBatch(1)
+	unknown.ProfileID = "missing"
+	malformedBudget := testBatch(1)
+	malformedBudget.Budget = "soon"
+	negativeBudget := testBatch(1)
+	negativeBudget.Budget = "-1s"
+
+	tests := []struct {
+		name   string
+		body   interface{}
+		status int
+	}{
+		{"malformed body", "not a batch", http.StatusBadRequest},
+		{"missing profile id", &types.BatchRequest{Emails: []types.Email{{ID: "email-1"}}}, http.StatusBadRequest},
+		{"no emails", &types.BatchRequest{ProfileID: "newsletter"}, http.StatusBadRequest},
+		{"too many emails", testBatch(20), http.StatusBadRequest},
+		{"malformed budget", malformedBudget, http.StatusBadRequest},
+		{"negative budget", negativeBudget, http.StatusBadRequest},
+		{"unknown profile", unknown, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := postBatch(t, server.URL, "application/json", tt.body)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+
+			var body errorResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.NotEmpty(t, body.Error)
+		})
+	}
+}
+
+func TestBatchRoutesProfilesByLabel(t *testing.T) {
+	router, err := profile.NewRouter(config.RoutingConfig{Routes: []config.Route{
+		{Name: "promotions", Labels: []string{"CATEGORY_PROMOTIONS"}, Profiles: []string{"newsletter"}},
+		{Name: "unauthenticated", When: "email.auth.dmarc == 'fail'", Profiles: []string{"phishing"}},
+	}}, testLogger())
+	require.NoError(t, err)
+
+	classifier := newFakeClassifier()
+	srv := NewServer(testConfig(2), classifier, testRoutedProfiles(), testLogger())
+	srv.SetRouting(router, firstResult{})
+	server := httptest.NewServer(srv.Handler())
+	defer server.Close()
+
+	resp := postBatch(t, server.URL, "application/json", &types.BatchRequest{Emails: []types.Email{
+		{ID: "promo", Labels: []string{"INBOX", "CATEGORY_PROMOTIONS"}},
+		{ID: "spoofed", Labels: []string{"INBOX"}, Auth: &types.AuthResults{DMARC: types.AuthFail}},
+		{ID: "plain", Labels: []string{"INBOX"}},
+	}})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var batch types.BatchResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&batch))
+	assert.Equal(t, 3, batch.Summary.ProcessedEmails)
+	assert.Zero(t, batch.Summary.SkippedEmails)
+
+	// Profiles no route names run on every email
+	assert.ElementsMatch(t, []string{"spam", "newsletter"}, classifier.profilesFor("promo"))
+	assert.ElementsMatch(t, []string{"spam", "phishing"}, classifier.profilesFor("spoofed"))
+	assert.Equal(t, []string{"spam"}, classifier.profilesFor("plain"))
+}
+
+func TestBatchRunsShadowProfilesAlongsideActive(t *testing.T) {
+	router, err := profile.NewRouter(config.RoutingConfig{}, testLogger())
+	require.NoError(t, err)
+
+	profiles := fakeProfiles{
+		"spam":    {ID: "spam"},
+		"spam-v2": {ID: "spam-v2", ShadowOf: "spam"},
+	}
+	classifier := newFakeClassifier()
+	resolver := &recordingResolver{}
+	srv := NewServer(testConfig(2), classifier, profiles, testLogger())
+	srv.SetRouting(router, resolver)
+	server := httptest.NewServer(srv.Handler())
+	defer server.Close()
+
+	for _, profileID := range []string{"", "spam"} {
+		req := testBatch(2)
+		req.ProfileID = profileID
+		resp := postBatch(t, server.URL, "application/json", req)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var batch types.BatchResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&batch))
+		resp.Body.Close()
+		require.Len(t, batch.Results, 2)
+		for _, result := range batch.Results {
+			assert.Equal(t, "spam", result.ProfileID, "shadow results never reach the response")
+		}
+	}
+
+	assert.ElementsMatch(t, []string{"spam", "spam-v2", "spam", "spam-v2"}, classifier.profilesFor("email-1"))
+	assert.Equal(t, []string{"spam", "spam"}, resolver.resolved(), "shadow results are never resolved")
+}
+
+func TestBatchRoutingSkipsUnroutedEmails(t *testing.T) {
+	router, err := profile.NewRouter(config.RoutingConfig{Routes: []config.Route{
+		{Labels: []string{"CATEGORY_PROMOTIONS"}, Profiles: []string{"newsletter"}},
+	}}, testLogger())
+	require.NoError(t, err)
+
+	classifier := newFakeClassifier()
+	srv := NewServer(testConfig(1), classifier, testProfiles(), testLogger())
+	srv.SetRouting(router, firstResult{})
+	server := httptest.NewServer(srv.Handler())
+	defer server.Close()
+
+	resp := postBatch(t, server.URL, "application/json", &types.BatchRequest{Emails: []types.Email{
+		{ID: "promo", Labels: []string{"CATEGORY_PROMOTIONS"}},
+		{ID: "personal", Labels: []string{"INBOX"}},
+	}})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var batch types.BatchResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&batch))
+	require.Len(t, batch.Results, 1)
+	assert.Equal(t, "promo", batch.Results[0].EmailID)
+	assert.Equal(t, 1, batch.Summary.ProcessedEmails)
+	assert.Equal(t, 1, batch.Summary.SkippedEmails)
+	assert.Equal(t, 1, classifier.callCount())
+}
+
+func TestBatchAuditsDecisions(t *testing.T) {
+	router, err := profile.NewRouter(config.RoutingConfig{Routes: []config.Route{
+		{Labels: []string{"CATEGORY_PROMOTIONS"}, Profiles: []string{"newsletter"}},
+		{Labels: []string{"CATEGORY_PROMOTIONS"}, Profiles: []string{"phishing"}},
+	}}, testLogger())
+	require.NoError(t, err)
+	auditLogger, err := audit.NewLogger(&config.AuditConfig{Enabled: true, Directory: t.TempDir()}, testLogger())
+	require.NoError(t, err)
+	defer auditLogger.Close()
+
+	srv := NewServer(testConfig(2), newFakeClassifier(), fakeProfiles{"newsletter": {ID: "newsletter"}, "phishing": {ID: "phishing"}}, testLogger())
+	srv.SetRouting(router, firstResult{})
+	srv.SetAuditLogger(auditLogger)
+	server := httptest.NewServer(srv.Handler())
+	defer server.Close()
+
+	resp := postBatch(t, server.URL, "application/json", &types.BatchRequest{Emails: []types.Email{
+		{ID: "promo", Labels: []string{"CATEGORY_PROMOTIONS"}},
+		{ID: "personal", Labels: []string{"INBOX"}},
+	}})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	entries, err := auditLogger.Query(audit.Query{EventTypes: []string{audit.EventDecision}})
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "an email no profile applied to has no decision")
+	assert.Equal(t, "promo", entries[0].EmailID)
+	assert.Equal(t, "newsletter", entries[0].ProfileID)
+	assert.Equal(t, false, entries[0].Metadata["acted"])
+	assert.Contains(t, entries[0].Metadata[logging.FieldCorrelationID], resp.Header.Get(HeaderCorrelationID))
+
+	var decision types.Decision
+	data, err := json.Marshal(entries[0].Metadata[audit.MetadataDecision])
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &decision))
+	require.Len(t, decision.Classifications, 2, "every routed profile's result is in the decision")
+	assert.Equal(t, "newsletter", decision.Classifications[0].ProfileID)
+	assert.Equal(t, "phishing", decision.Classifications[1].ProfileID)
+	assert.Equal(t, "archive", decision.Resolution.Action)
+	assert.Nil(t, decision.Action, "batches classify without acting")
+}
+
+func TestBatchAppliesDecisions(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dry run %t", dryRun), func(t *testing.T) {
+			cfg := testConfig(2)
+			auditLogger, err := audit.NewLogger(&config.AuditConfig{Enabled: true, Directory: t.TempDir()}, testLogger())
+			require.NoError(t, err)
+			defer auditLogger.Close()
+			mail := &fakeMailbox{}
+
+			srv := NewServer(cfg, newFakeClassifier(), testProfiles(), testLogger())
+			srv.SetAuditLogger(auditLogger)
+			srv.SetProcessor(processor.NewProcessor(&cfg.Actions, mail, auditLogger, testLogger()))
+			server := httptest.NewServer(srv.Handler())
+			defer server.Close()
+
+			req := testBatch(2)
+			req.DryRun = dryRun
+			resp := postBatch(t, server.URL, "application/json", req)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var batch types.BatchResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&batch))
+			assert.Equal(t, 2, batch.Summary.ProcessedEmails)
+			require.Len(t, batch.Summary.Actions, 2)
+			assert.Equal(t, dryRun, batch.Summary.Actions[0].DryRun)
+			if dryRun {
+				assert.Empty(t, mail.modified(), "dry runs leave the mailbox untouched")
+			} else {
+				assert.ElementsMatch(t, []string{"email-1", "email-2"}, mail.modified())
+			}
+
+			entries, err := auditLogger.Query(audit.Query{EventTypes: []string{audit.EventDecision}})
+			require.NoError(t, err)
+			require.Len(t, entries, 2, "one decision entry per email, from the processor")
+			for _, entry := range entries {
+				assert.Equal(t, true, entry.Metadata["acted"])
+			}
+		})
+	}
+}
+
+func TestBatchDedupClassifiesIdenticalEmailsOnce(t *testing.T) {
+	cfg := testConfig(2)
+	cfg.Server.Dedup.Enabled = true
+
+	classifier := newFakeClassifier()
+	server := httptest.NewServer(NewServer(cfg, classifier, testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	blast := types.Email{From: "deals@shop.example", Subject: "Flash sale", Body: "Everything half price today only."}
+	req := &types.BatchRequest{ProfileID: "newsletter"}
+	for _, id := range []string{"blast-1", "blast-2", "blast-3"} {
+		email := blast
+		email.ID = id
+		req.Emails = append(req.Emails, email)
+	}
+	req.Emails = append(req.Emails, types.Email{ID: "personal", From: "friend@example.com", Subject: "Dinner", Body: "Are we still on?"})
+
+	resp := postBatch(t, server.URL, "application/json", req)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var batch types.BatchResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&batch))
+	assert.Equal(t, 2, classifier.callCount(), "one model call for the identical group")
+	assert.Len(t, classifier.profilesFor("blast-1"), 1)
+	assert.Empty(t, classifier.profilesFor("blast-2"))
+	assert.Len(t, classifier.profilesFor("personal"), 1)
+
+	assert.Equal(t, 4, batch.Summary.ProcessedEmails)
+	assert.Equal(t, map[string]int{"archive": 4}, batch.Summary.ActionCounts)
+	assert.Equal(t, []types.DuplicateGroup{
+		{Representative: "blast-1", Duplicates: []string{"blast-2", "blast-3"}},
+	}, batch.Summary.DuplicateGroups)
+
+	results := make(map[string]types.ClassificationResponse)
+	for _, result := range batch.Results {
+		results[result.EmailID] = result
+	}
+	require.Len(t, results, 4)
+	assert.Nil(t, results["blast-1"].Metadata[types.MetadataDedupedFrom])
+	assert.Equal(t, "blast-1", results["blast-2"].Metadata[types.MetadataDedupedFrom])
+	assert.Equal(t, "blast-1", results["blast-3"].Metadata[types.MetadataDedupedFrom])
+	assert.Nil(t, results["personal"].Metadata[types.MetadataDedupedFrom])
+}
+
+func TestBatchCorrelationIDs(t *testing.T) {
+	classifier := newFakeClassifier()
+	server := httptest.NewServer(NewServer(testConfig(2), classifier, testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	body, err := json.Marshal(testBatch(2))
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/v1/batch", bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderCorrelationID, "req-1")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "req-1", resp.Header.Get(HeaderCorrelationID))
+	assert.Equal(t, "req-1/email-1", classifier.correlationFor("email-1"))
+	assert.Equal(t, "req-1/email-2", classifier.correlationFor("email-2"))
+
+	resp = postBatch(t, server.URL, "application/json", testBatch(1))
+	resp.Body.Close()
+	generated := resp.Header.Get(HeaderCorrelationID)
+	require.NotEmpty(t, generated, "a correlation ID is generated when none is sent")
+	assert.Equal(t, generated+"/email-1", classifier.correlationFor("email-1"))
+
+	for _, invalid := range []string{strings.Repeat("a", logging.MaxCorrelationIDLength+1), "req-1 forged=true", "req/1"} {
+		req.Header.Set(HeaderCorrelationID, invalid)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		replaced := resp.Header.Get(HeaderCorrelationID)
+		assert.True(t, logging.ValidCorrelationID(replaced), "an invalid correlation ID is replaced")
+		assert.NotEqual(t, invalid, replaced)
+	}
+}
+
+func TestBatchContextReachesEveryEmail(t *testing.T) {
+	classifier := newFakeClassifier()
+	server := httptest.NewServer(NewServer(testConfig(2), classifier, testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	batch := testBatch(2)
+	batch.Context = map[string]string{"source": "quarantine", "prior_action": "keep"}
+	batch.Emails[0].Context = map[string]string{"prior_action": "archive"}
+	resp := postBatch(t, server.URL, "application/json", batch)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	classifier.mutex.Lock()
+	defer classifier.mutex.Unlock()
+	assert.Equal(t, map[string]string{"source": "quarantine", "prior_action": "archive"}, classifier.emails["email-1"].Context, "an email's own keys win")
+	assert.Equal(t, map[string]string{"source": "quarantine", "prior_action": "keep"}, classifier.emails["email-2"].Context)
+}
+
+func TestBatchAnalyzesLinksAndHomoglyphs(t *testing.T) {
+	classifier := newFakeClassifier()
+	server := httptest.NewServer(NewServer(testConfig(2), classifier, testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	batch := testBatch(2)
+	batch.Emails[0].From = "PayPal <service@pаypаl.com>"
+	batch.Emails[0].Body = "Confirm your details at https://pаypаl.com/confirm"
+	batch.Emails[1].Homoglyphs = &types.HomoglyphAnalysis{Suspicious: true}
+	batch.Emails[1].URLs = []types.Link{{URL: "https://evil.example", Mismatch: true}}
+	resp := postBatch(t, server.URL, "application/json", batch)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	classifier.mutex.Lock()
+	defer classifier.mutex.Unlock()
+	lookalike := classifier.emails["email-1"].Homoglyphs
+	require.NotNil(t, lookalike)
+	assert.Equal(t, "paypal.com", lookalike.NormalizedSenderDomain)
+	assert.True(t, lookalike.Suspicious)
+	require.Len(t, lookalike.URLs, 1)
+	assert.Equal(t, "https://paypal.com/confirm", lookalike.URLs[0].Normalized)
+	assert.Equal(t, []types.Link{{URL: "https://pаypаl.com/confirm", Host: "pаypаl.com"}}, classifier.emails["email-1"].URLs)
+	require.NotNil(t, classifier.emails["email-2"].Homoglyphs)
+	assert.False(t, classifier.emails["email-2"].Homoglyphs.Suspicious, "a caller cannot supply its own analysis")
+	assert.Empty(t, classifier.emails["email-2"].URLs, "nor its own links")
+}
+
+func TestBatchAnalyzesReplies(t *testing.T) {
+	classifier := newFakeClassifier()
+	server := httptest.NewServer(NewServer(testConfig(2), classifier, testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	batch := testBatch(2)
+	batch.Emails[0].Subject = "Re: Wire transfer"
+	batch.Emails[1].Reply = &types.ReplyAnalysis{Spoofed: true}
+	resp := postBatch(t, server.URL, "application/json", batch)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	classifier.mutex.Lock()
+	defer classifier.mutex.Unlock()
+	reply := classifier.emails["email-1"].Reply
+	require.NotNil(t, reply)
+	assert.True(t, reply.Spoofed)
+	assert.Equal(t, []string{types.ReplyMissingHeaders}, reply.Reasons)
+	assert.Nil(t, classifier.emails["email-2"].Reply, "a caller cannot supply its own analysis")
+}
+
+func TestAcceptsNDJSON(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected bool
+	}{
+		{"", false},
+		{"application/json", false},
+		{"application/x-ndjson", true},
+		{"application/json, application/x-ndjson;q=0.9", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/batch", nil)
+			req.Header.Set("Accept", tt.accept)
+			assert.Equal(t, tt.expected, acceptsNDJSON(req))
+		})
+	}
+}
+
+// Helper functions
+
+// fakeClassifier archives every email with confidence 0.8, failing or
+// blocking on the configured email IDs. A blocked classification returns
+// when its channel is closed or the context is cancelled.
+type fakeClassifier struct {
+	failures  map[string]error
+	block     map[string]chan struct{}
+	cancelled chan struct{}
+	once      sync.Once
+	mutex     sync.Mutex
+	calls     int
+	profiles  map[string][]string
+	contexts  map[string]string
+	// emails records each email as the classifier received it
+	emails map[string]types.Email
+}
+
+func newFakeClassifier() *fakeClassifier {
+	return &fakeClassifier{
+		failures:  make(map[string]error),
+		block:     make(map[string]chan struct{}),
+		cancelled: make(chan struct{}),
+		profiles:  make(map[string][]string),
+		contexts:  make(map[string]string),
+		emails:    make(map[string]types.Email),
+	}
+}
+
+func (f *fakeClassifier) ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error) {
+	f.mutex.Lock()
+	f.calls++
+	f.profiles[email.ID] = append(f.profiles[email.ID], profile.ID)
+	f.contexts[email.ID] = logging.CorrelationID(ctx)
+	f.emails[email.ID] = *email
+	f.mutex.Unlock()
+
+	if release, blocked := f.block[email.ID]; blocked {
+		select {
+		case <-release:
+		case <-ctx.Done():
+			f.once.Do(func() { close(f.cancelled) })
+			return nil, ctx.Err()
+		}
+	}
+
+	if err := f.failures[email.ID]; err != nil {
+		return nil, err
+	}
+	return &types.ClassificationResponse{
+		EmailID:    email.ID,
+		ProfileID:  profile.ID,
+		Action:     "archive",
+		Confidence: 0.8,
+	}, nil
+}
+
+func (f *fakeClassifier) callCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls
+}
+
+// profilesFor returns the profiles an email was classified with, in order
+func (f *fakeClassifier) profilesFor(emailID string) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.profiles[emailID]
+}
+
+// correlationFor returns the correlation ID an email was classified under
+func (f *fakeClassifier) correlationFor(emailID string) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.contexts[emailID]
+}
+
+func TestBatchSkipsEmailsCompletedBeforeCrash(t *testing.T) {
+	cfg := testConfig(2)
+	path := filepath.Join(t.TempDir(), "cursor")
+	progress, err := cursor.Open(path, testLogger())
+	require.NoError(t, err)
+	require.NoError(t, progress.Start("batch-1", "email-1"))
+	require.NoError(t, progress.Complete("batch-1", "email-1"))
+	require.NoError(t, progress.Complete("batch-0", "email-2"))
+	defer progress.Close()
+
+	auditLogger, err := audit.NewLogger(&config.AuditConfig{Enabled: true, Directory: t.TempDir()}, testLogger())
+	require.NoError(t, err)
+	defer auditLogger.Close()
+
+	mail := &fakeMailbox{}
+	actions := processor.NewProcessor(&cfg.Actions, mail, auditLogger, testLogger())
+	actions.SetCursor(progress)
+	classifier := newFakeClassifier()
+	srv := NewServer(cfg, classifier, testProfiles(), testLogger())
+	srv.SetProcessor(actions)
+	server := httptest.NewServer(srv.Handler())
+	defer server.Close()
+
+	// Sent again under its correlation ID, the batch resumes
+	batch := postBatchAs(t, server.URL, "batch-1", testBatch(2))
+	assert.Equal(t, 1, batch.Summary.SkippedEmails)
+	assert.Equal(t, 1, batch.Summary.ProcessedEmails)
+	assert.Empty(t, classifier.profilesFor("email-1"), "a completed email is not classified again")
+	assert.Equal(t, []string{"email-2"}, mail.modified())
+
+	// Having completed, it drops its progress and leaves other batches'
+	assert.False(t, progress.Completed("batch-1", "email-1"))
+	assert.False(t, progress.Completed("batch-1", "email-2"))
+	assert.True(t, progress.Completed("batch-0", "email-2"))
+
+	// Another batch classifies and acts on every email again
+	batch = postBatchAs(t, server.URL, "batch-2", testBatch(2))
+	assert.Equal(t, 0, batch.Summary.SkippedEmails)
+	assert.Equal(t, 2, batch.Summary.ProcessedEmails)
+	assert.NotEmpty(t, classifier.profilesFor("email-1"))
+	modified := mail.modified()
+	require.Len(t, modified, 3)
+	assert.ElementsMatch(t, []string{"email-1", "email-2"}, modified[1:])
+	assert.False(t, progress.Completed("batch-2", "email-1"))
+}
+
+// fakeMailbox records the emails whose labels were modified
+type fakeMailbox struct {
+	mutex sync.Mutex
+	calls []string
+}
+
+func (f *fakeMailbox) ModifyLabels(ctx context.Context, messageID string, addLabels, removeLabels []string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls = append(f.calls, messageID)
+	return nil
+}
+
+func (f *fakeMailbox) LabelIDForName(ctx context.Context, name string) (string, error) {
+	return name, nil
+}
+
+func (f *fakeMailbox) LabelNameForID(ctx context.Context, id string) (string, error) {
+	return id, nil
+}
+
+func (f *fakeMailbox) TrashMessage(ctx context.Context, messageID string) error {
+	return nil
+}
+
+func (f *fakeMailbox) DeleteMessage(ctx context.Context, messageID string) error {
+	return nil
+}
+
+func (f *fakeMailbox) modified() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// fakeProfiles serves a fixed set of profiles
+type fakeProfiles map[string]*types.Profile
+
+func (f fakeProfiles) GetProfile(id string) (*types.Profile, error) {
+	profile, exists := f[id]
+	if !exists {
+		return nil, fmt.Errorf("profile %s not found", id)
+	}
+	return profile, nil
+}
+
+func (f fakeProfiles) GetRegistry() *types.ProfileRegistry {
+	registry := &types.ProfileRegistry{Profiles: f}
+	for id := range f {
+		registry.LoadOrder = append(registry.LoadOrder, id)
+	}
+	sort.Strings(registry.LoadOrder)
+	return registry
+}
+
+// firstResult resolves a routed email to its first classification result
+type firstResult struct{}
+
+func (firstResult) ResolveDecision(email *types.Email, results []*types.ClassificationResponse) (*types.ClassificationResponse, error) {
+	return results[0], nil
+}
+
+// recordingResolver resolves to the first result, recording the profiles of
+// every result it is given
+type recordingResolver struct {
+	mutex    sync.Mutex
+	profiles []string
+}
+
+func (r *recordingResolver) ResolveDecision(email *types.Email, results []*types.ClassificationResponse) (*types.ClassificationResponse, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, result := range results {
+		r.profiles = append(r.profiles, result.ProfileID)
+	}
+	return results[0], nil
+}
+
+func (r *recordingResolver) resolved() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.profiles...)
+}
+
+func testProfiles() fakeProfiles {
+	return fakeProfiles{"newsletter": {ID: "newsletter"}}
+}
+
+// testRoutedProfiles returns a spam profile alongside the routed profiles
+func testRoutedProfiles() fakeProfiles {
+	return fakeProfiles{
+		"spam":       {ID: "spam"},
+		"newsletter": {ID: "newsletter"},
+		"phishing":   {ID: "phishing"},
+	}
+}
+
+func testConfig(workers int) *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.Server.BatchWorkers = workers
+	cfg.Security.MaxBatchSize = 10
+	return cfg
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
+
+func testBatch(count int) *types.BatchRequest {
+	req := &types.BatchRequest{ProfileID: "newsletter"}
+	for i := 1; i <= count; i++ {
+		req.Emails = append(req.Emails, types.Email{ID: fmt.Sprintf("email-%d", i)})
+	}
+	return req
+}
+
+func postBatch(t *testing.T, url, accept string, body interface{}) *http.Response {
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, url+"/v1/batch", bytes.NewReader(data))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+// postBatchAs sends a batch request under a correlation ID and decodes its
+// response
+func postBatchAs(t *testing.T, url, correlationID string, body interface{}) *types.BatchResponse {
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, url+"/v1/batch", bytes.NewReader(data))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderCorrelationID, correlationID)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var batch types.BatchResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&batch))
+	return &batch
+}
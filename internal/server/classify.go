@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/annotate"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/internal/resolver"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// EmailFetcher fetches a single email from the mail provider
+type EmailFetcher interface {
+	GetEmail(ctx context.Context, messageID string) (*types.Email, error)
+}
+
+// PreviewLister lists preview emails, which carry the mail provider's
+// snippet alone, for the threads matching a search query
+type PreviewLister interface {
+	ListPreviews(ctx context.Context, query string, maxResults int64) ([]*types.Email, error)
+}
+
+var (
+	// ErrNoMailbox is returned by ClassifyByID when no mail provider is set
+	ErrNoMailbox = errors.New("no mailbox is configured")
+	// ErrProfileRequired is returned by ClassifyByID without a profile when
+	// routing is disabled
+	ErrProfileRequired = errors.New("profile is required")
+	// ErrUnknownProfile wraps the lookup failure of a requested profile
+	ErrUnknownProfile = errors.New("unknown profile")
+	// ErrFetchFailed wraps the mail provider's failure to fetch the email
+	ErrFetchFailed = errors.New("failed to fetch email")
+	// ErrNoPreviews is returned by ClassifyPreviews when the mail provider
+	// cannot list previews
+	ErrNoPreviews = errors.New("previews are not available")
+)
+
+// ClassifyByIDResponse is the body of GET /v1/classify/{messageID}: the
+// fetched email, the result of every profile that classified it and the
+// decision they resolve to. Decision is nil when no profile applied.
+type ClassifyByIDResponse struct {
+	Email       *types.Email                    `json:"email"`
+	Results     []*types.ClassificationResponse `json:"results"`
+	Decision    *types.ClassificationResponse   `json:"decision"`
+	Explanation *resolver.Explanation           `json:"explanation,omitempty"`
+}
+
+// PreviewsResponse is the body of GET /v1/previews: every preview listed,
+// in the mail provider's order, with its results and decision
+type PreviewsResponse struct {
+	Previews []*ClassifyByIDResponse `json:"previews"`
+}
+
+// SetMailbox enables classifying single emails by ID, fetched with
+// fetcher. A nil fetcher disables it.
+func (s *Server) SetMailbox(fetcher EmailFetcher) {
+	s.mailbox = fetcher
+}
+
+// SetPreviews enables classifying the previews of a mailbox search, listed
+// with lister. A nil lister disables it.
+func (s *Server) SetPreviews(lister PreviewLister) {
+	s.previews = lister
+}
+
+// ClassifyByID fetches one email and classifies it with the profile with
+// profileID or, when profileID is empty, with the profiles routed to it,
+// resolving their results with an explanation when the resolver provides
+// one. It is read-only: no action is applied to the email, and shadow
+// profiles do not run.
+func (s *Server) ClassifyByID(ctx context.Context, messageID, profileID string) (*ClassifyByIDResponse, error) {
+	if s.mailbox == nil {
+		return nil, ErrNoMailbox
+	}
+	requested, err := s.requestedProfiles(profileID)
+	if err != nil {
+		return nil, err
+	}
+
+	done, err := s.lifecycle.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	ctx = logging.ForEmail(ctx, messageID)
+
+	email, err := s.mailbox.GetEmail(ctx, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %w", ErrFetchFailed, messageID, err)
+	}
+	response, err := s.classifyFetched(ctx, email, requested)
+	if err != nil {
+		return nil, err
+	}
+	logging.FromContext(ctx, s.logger).WithFields(logrus.Fields{
+		"email_id": email.ID,
+		"results":  len(response.Results),
+	}).Debug("Classified email by ID")
+	return response, nil
+}
+
+// ClassifyPreviews lists the previews of the threads matching query, at
+// most maxResults unless it is zero, and classifies each as ClassifyByID
+// classifies an email. Profiles with triage classify a preview on its
+// snippet, fetching its message only when the result is ambiguous. It is
+// read-only like ClassifyByID.
+func (s *Server) ClassifyPreviews(ctx context.Context, query, profileID string, maxResults int64) (*PreviewsResponse, error) {
+	if s.previews == nil {
+		return nil, ErrNoPreviews
+	}
+	requested, err := s.requestedProfiles(profileID)
+	if err != nil {
+		return nil, err
+	}
+
+	done, err := s.lifecycle.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	previews, err := s.previews.ListPreviews(ctx, query, maxResults)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	response := &PreviewsResponse{Previews: make([]*ClassifyByIDResponse, 0, len(previews))}
+	for _, preview := range previews {
+		classified, err := s.classifyFetched(logging.ForEmail(ctx, preview.ID), preview, requested)
+		if err != nil {
+			return nil, fmt.Errorf("email %s: %w", preview.ID, err)
+		}
+		response.Previews = append(response.Previews, classified)
+	}
+
+	logging.FromContext(ctx, s.logger).WithFields(logrus.Fields{
+		"query":    query,
+		"previews": len(response.Previews),
+	}).Debug("Classified previews")
+	return response, nil
+}
+
+// requestedProfiles returns the profile with profileID or, when profileID
+// is empty, nil for the profiles routed to each email
+func (s *Server) requestedProfiles(profileID string) ([]*types.Profile, error) {
+	switch {
+	case profileID != "":
+		profile, err := s.profiles.GetProfile(profileID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnknownProfile, err)
+		}
+		return []*types.Profile{profile}, nil
+	case s.router == nil:
+		return nil, ErrProfileRequired
+	default:
+		return nil, nil
+	}
+}
+
+// classifyFetched annotates an email fetched from the mailbox and
+// classifies it with requested or, when it is nil, with the profiles routed
+// to it, resolving their results
+func (s *Server) classifyFetched(ctx context.Context, email *types.Email, requested []*types.Profile) (*ClassifyByIDResponse, error) {
+	annotate.Email(email)
+	profiles := requested
+	if profiles == nil {
+		profiles = s.router.Route(email, activeProfiles(s.profiles.GetRegistry()))
+	}
+
+	response := &ClassifyByIDResponse{Email: email, Results: []*types.ClassificationResponse{}}
+	for _, profile := range profiles {
+		if requested == nil && !s.router.ShouldExecute(profile, email, response.Results) {
+			continue
+		}
+		result, err := s.classifier.ClassifyEmail(ctx, profile, email)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", profile.ID, err)
+		}
+		response.Results = append(response.Results, result)
+	}
+	if len(response.Results) == 0 {
+		return response, nil
+	}
+
+	var err error
+	switch explainer, ok := s.resolver.(Explainer); {
+	case ok:
+		response.Decision, response.Explanation, err = explainer.ExplainDecision(email, response.Results)
+	case len(response.Results) == 1:
+		response.Decision = response.Results[0]
+	default:
+		response.Decision, err = s.resolver.ResolveDecision(email, response.Results)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve decision: %w", err)
+	}
+	return response, nil
+}
+
+// handleClassifyByID classifies one email of the mailbox by its message ID
+// with the profile query parameter or, when it is omitted, with the routed
+// profiles. Nothing is acted on.
+func (s *Server) handleClassifyByID(w http.ResponseWriter, r *http.Request) {
+	if s.lifecycle.Stopping() {
+		s.writeError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+
+	response, err := s.ClassifyByID(r.Context(), r.PathValue("messageID"), r.URL.Query().Get("profile"))
+	switch {
+	case err == nil:
+		s.writeJSON(w, http.StatusOK, response)
+	case errors.Is(err, ErrNoMailbox), errors.Is(err, ErrUnknownProfile):
+		s.writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrProfileRequired):
+		s.writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		s.logger.WithError(err).WithField("email_id", r.PathValue("messageID")).Warn("Failed to classify email by ID")
+		s.writeError(w, http.StatusBadGateway, err.Error())
+	}
+}
+
+// handleClassifyPreviews classifies the previews of the threads matching
+// the q query parameter, at most max_results of them, with the profile
+// query parameter or, when it is omitted, with the routed profiles.
+// Nothing is acted on.
+func (s *Server) handleClassifyPreviews(w http.ResponseWriter, r *http.Request) {
+	if s.lifecycle.Stopping() {
+		s.writeError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+
+	var maxResults int64
+	if value := r.URL.Query().Get("max_results"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid max_results %q", value))
+			return
+		}
+		maxResults = parsed
+	}
+
+	query := r.URL.Query().Get("q")
+	response, err := s.ClassifyPreviews(r.Context(), query, r.URL.Query().Get("profile"), maxResults)
+	switch {
+	case err == nil:
+		s.writeJSON(w, http.StatusOK, response)
+	case errors.Is(err, ErrNoPreviews), errors.Is(err, ErrUnknownProfile):
+		s.writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrProfileRequired):
+		s.writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		s.logger.WithError(err).WithField("query", query).Warn("Failed to classify previews")
+		s.writeError(w, http.StatusBadGateway, err.Error())
+	}
+}
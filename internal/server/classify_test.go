@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gmailapi "google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+
+	"github.com/mailsentinel/core/internal/gmail"
+	"github.com/mailsentinel/core/internal/ollama"
+	"github.com/mailsentinel/core/internal/profile"
+	"github.com/mailsentinel/core/internal/resolver"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/testutil"
+)
+
+func TestClassifyByIDAgainstMockServers(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	ollamaServer := testData.MockOllamaServer(t)
+	defer ollamaServer.Close()
+	mailbox, methods := testMockMailbox(t, testData)
+
+	t.Run("profile", func(t *testing.T) {
+		srv := NewServer(testConfig(1), testOllamaClassifier(ollamaServer.URL), testRoutedProfiles(), testLogger())
+		srv.SetMailbox(mailbox)
+		server := httptest.NewServer(srv.Handler())
+		defer server.Close()
+
+		response := getClassifyByID(t, server.URL, "test-email-001?profile=phishing", http.StatusOK)
+		assert.Equal(t, "URGENT: Your account will be suspended", response.Email.Subject)
+		require.Len(t, response.Results, 1)
+		assert.Equal(t, "phishing", response.Results[0].ProfileID)
+		require.NotNil(t, response.Decision)
+		assert.Equal(t, "delete", response.Decision.Action)
+		assert.InDelta(t, 0.95, response.Decision.Confidence, 0.001)
+		assert.Nil(t, response.Explanation, "nothing to resolve without a resolver")
+	})
+
+	t.Run("routed", func(t *testing.T) {
+		router, err := profile.NewRouter(config.RoutingConfig{Routes: []config.Route{
+			{Name: "promotions", Labels: []string{"CATEGORY_PROMOTIONS"}, Profiles: []string{"newsletter"}},
+		}}, testLogger())
+		require.NoError(t, err)
+		policyResolver, err := resolver.NewPolicyResolver(testResolverConfig(t), testLogger())
+		require.NoError(t, err)
+
+		srv := NewServer(testConfig(1), testOllamaClassifier(ollamaServer.URL), testRoutedProfiles(), testLogger())
+		srv.SetMailbox(mailbox)
+		srv.SetRouting(router, policyResolver)
+		server := httptest.NewServer(srv.Handler())
+		defer server.Close()
+
+		response := getClassifyByID(t, server.URL, "test-email-003", http.StatusOK)
+		var profiles []string
+		for _, result := range response.Results {
+			profiles = append(profiles, result.ProfileID)
+		}
+		assert.ElementsMatch(t, []string{"phishing", "spam"}, profiles)
+		require.NotNil(t, response.Decision)
+		assert.Equal(t, "keep", response.Decision.Action)
+		require.NotNil(t, response.Explanation)
+		assert.Equal(t, resolver.MethodWeightedAverage, response.Explanation.Method)
+		assert.Equal(t, "keep", response.Explanation.Action)
+	})
+
+	require.NotEmpty(t, methods())
+	for _, method := range methods() {
+		assert.Equal(t, http.MethodGet, method, "classifying by ID must not act on the mailbox")
+	}
+}
+
+func TestClassifyByIDErrors(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	mailbox, _ := testMockMailbox(t, testData)
+
+	tests := []struct {
+		name    string
+		mailbox EmailFetcher
+		path    string
+		status  int
+		message string
+	}{
+		{"no mailbox", nil, "test-email-001?profile=newsletter", http.StatusNotFound, "no mailbox is configured"},
+		{"unknown profile", mailbox, "test-email-001?profile=missing", http.StatusNotFound, "profile missing not found"},
+		{"profile required", mailbox, "test-email-001", http.StatusBadRequest, "profile is required"},
+		{"missing email", mailbox, "missing-email?profile=newsletter", http.StatusBadGateway, "failed to fetch email missing-email"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			classifier := newFakeClassifier()
+			srv := NewServer(testConfig(1), classifier, testProfiles(), testLogger())
+			srv.SetMailbox(tt.mailbox)
+			server := httptest.NewServer(srv.Handler())
+			defer server.Close()
+
+			resp, err := http.Get(server.URL + "/v1/classify/" + tt.path)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+
+			var body errorResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.Contains(t, body.Error, tt.message)
+			assert.Zero(t, classifier.callCount())
+		})
+	}
+}
+
+func TestClassifyPreviews(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	mailbox, methods := testMockMailbox(t, testData)
+	classifier := newFakeClassifier()
+	srv := NewServer(testConfig(1), classifier, testRoutedProfiles(), testLogger())
+	srv.SetPreviews(mailbox)
+	server := httptest.NewServer(srv.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/previews?profile=phishing&max_results=2&q=" + url.QueryEscape("is:unread subject:your -label:newsletter"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var response PreviewsResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+	require.Len(t, response.Previews, 2)
+	for i, id := range []string{"test-email-001", "test-email-004"} {
+		preview := response.Previews[i]
+		assert.Equal(t, id, preview.Email.ID)
+		assert.True(t, preview.Email.Preview)
+		assert.Equal(t, testData.GetTestEmail(id).Snippet, preview.Email.Snippet)
+		require.Len(t, preview.Results, 1)
+		assert.Equal(t, "phishing", preview.Results[0].ProfileID)
+		assert.Equal(t, "archive", preview.Decision.Action)
+	}
+	assert.Equal(t, 2, classifier.callCount())
+	for _, method := range methods() {
+		assert.Equal(t, http.MethodGet, method, "classifying previews must not act on the mailbox")
+	}
+}
+
+func TestClassifyPreviewsErrors(t *testing.T) {
+	testData := testutil.LoadTestData(t)
+	mailbox, _ := testMockMailbox(t, testData)
+
+	tests := []struct {
+		name    string
+		lister  PreviewLister
+		query   string
+		status  int
+		message string
+	}{
+		{"no previews", nil, "profile=newsletter", http.StatusNotFound, "previews are not available"},
+		{"unknown profile", mailbox, "profile=missing", http.StatusNotFound, "profile missing not found"},
+		{"profile required", mailbox, "", http.StatusBadRequest, "profile is required"},
+		{"invalid max_results", mailbox, "profile=newsletter&max_results=-1", http.StatusBadRequest, `invalid max_results "-1"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			classifier := newFakeClassifier()
+			srv := NewServer(testConfig(1), classifier, testProfiles(), testLogger())
+			srv.SetPreviews(tt.lister)
+			server := httptest.NewServer(srv.Handler())
+			defer server.Close()
+
+			resp, err := http.Get(server.URL + "/v1/previews?" + tt.query)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+
+			var body errorResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.Contains(t, body.Error, tt.message)
+			assert.Zero(t, classifier.callCount())
+		})
+	}
+}
+
+// Helper functions
+
+// testMockMailbox returns a Gmail client of the mock Gmail server, closed
+// with the test, and a function listing the methods of its requests
+func testMockMailbox(t *testing.T, testData *testutil.TestData) (*gmail.Client, func() []string) {
+	t.Helper()
+	mock := testData.MockGmailServer(t)
+	t.Cleanup(mock.Close)
+
+	transport := &methodRecorder{}
+	service, err := gmailapi.NewService(context.Background(),
+		option.WithHTTPClient(&http.Client{Transport: transport}),
+		option.WithEndpoint(mock.URL))
+	require.NoError(t, err)
+	return gmail.NewClientFromService(service, &config.GmailConfig{}, testLogger()), transport.recorded
+}
+
+// methodRecorder records the method of every request it sends
+type methodRecorder struct {
+	mutex   sync.Mutex
+	methods []string
+}
+
+func (m *methodRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	m.mutex.Lock()
+	m.methods = append(m.methods, req.Method)
+	m.mutex.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (m *methodRecorder) recorded() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]string(nil), m.methods...)
+}
+
+// testOllamaClassifier returns an Ollama client of the mock Ollama server
+func testOllamaClassifier(baseURL string) *ollama.Client {
+	return ollama.NewClient(&config.OllamaConfig{
+		BaseURL:        baseURL,
+		DefaultModel:   "qwen2.5:7b",
+		RequestTimeout: 5 * time.Second,
+		CircuitBreaker: config.CircuitBreakerConfig{
+			MaxRequests: 5,
+			Interval:    60 * time.Second,
+			Timeout:     60 * time.Second,
+			ReadyToTrip: 5,
+		},
+	}, testLogger())
+}
+
+func getClassifyByID(t *testing.T, url, path string, status int) *ClassifyByIDResponse {
+	t.Helper()
+	resp, err := http.Get(url + "/v1/classify/" + path)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, status, resp.StatusCode)
+
+	var response ClassifyByIDResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+	return &response
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/annotate"
+	"github.com/mailsentinel/core/internal/deadletter"
+	"github.com/mailsentinel/core/internal/lifecycle"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// DeadLettersResponse is the body of GET /v1/dead-letters
+type DeadLettersResponse struct {
+	DeadLetters []deadletter.Entry `json:"dead_letters"`
+}
+
+// RequeueResponse is the body of a successful
+// POST /v1/dead-letters/{id}/requeue. Result is nil when no profile applied
+// to the email.
+type RequeueResponse struct {
+	DeadLetterID string                        `json:"dead_letter_id"`
+	Result       *types.ClassificationResponse `json:"result"`
+}
+
+// deadLetter keeps an email whose classification failed in the dead-letter
+// queue, returning its entry ID, or "" when the email is not kept: without
+// a queue, or when it failed because the server is shutting down or the
+// request was cancelled rather than because of the email
+func (s *Server) deadLetter(ctx context.Context, email *types.Email, profileID string, classifyErr error) string {
+	if s.deadLetters == nil || errors.Is(classifyErr, lifecycle.ErrShuttingDown) || errors.Is(classifyErr, context.Canceled) {
+		return ""
+	}
+
+	entry, err := s.deadLetters.Add(ctx, email, profileID, classifyErr)
+	if err != nil {
+		logging.FromContext(ctx, s.logger).WithError(err).WithField("email_id", email.ID).Error("Failed to dead-letter email")
+		return ""
+	}
+	return entry.ID
+}
+
+// handleListDeadLetters lists the dead-lettered emails, oldest first
+func (s *Server) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if s.deadLetters == nil {
+		s.writeError(w, http.StatusNotFound, "no dead-letter queue is configured")
+		return
+	}
+
+	entries, err := s.deadLetters.List()
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, DeadLettersResponse{DeadLetters: entries})
+}
+
+// handleRequeueDeadLetter classifies a dead-lettered email again, with the
+// profile it failed with or routed when it failed a routed batch. The entry
+// is removed once the email classifies; another failure counts an attempt
+// and keeps it.
+func (s *Server) handleRequeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	correlationID := requestCorrelationID(w, r)
+
+	if s.deadLetters == nil {
+		s.writeError(w, http.StatusNotFound, "no dead-letter queue is configured")
+		return
+	}
+	entry, ok := s.deadLetterEntry(w, r)
+	if !ok {
+		return
+	}
+	if entry.ProfileID == "" && s.router == nil {
+		s.writeError(w, http.StatusUnprocessableEntity, "the email failed a routed batch and routing is not enabled")
+		return
+	}
+	classify, err := s.classifierFor(entry.ProfileID)
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	ctx := logging.WithCorrelationID(r.Context(), correlationID)
+	email := entry.Email
+	annotate.Email(&email)
+
+	decision, err := s.classifyTracked(ctx, classify, &email)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.deadLetter(ctx, &email, entry.ProfileID, err)
+		}
+		s.writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	if err := s.deadLetters.Remove(entry.ID); err != nil && !errors.Is(err, deadletter.ErrNotFound) {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var result *types.ClassificationResponse
+	if decision != nil {
+		s.auditDecision(logging.ForEmail(ctx, email.ID), &email, decision)
+		result = decision.Resolution
+	}
+
+	logging.Bind(s.logger, correlationID).WithFields(logrus.Fields{
+		"email_id":       email.ID,
+		"dead_letter_id": entry.ID,
+		"attempts":       entry.Attempts,
+	}).Info("Requeued dead-lettered email")
+	s.writeJSON(w, http.StatusOK, RequeueResponse{DeadLetterID: entry.ID, Result: result})
+}
+
+// handleDeleteDeadLetter discards a dead-lettered email without
+// classifying it
+func (s *Server) handleDeleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if s.deadLetters == nil {
+		s.writeError(w, http.StatusNotFound, "no dead-letter queue is configured")
+		return
+	}
+	entry, ok := s.deadLetterEntry(w, r)
+	if !ok {
+		return
+	}
+	if err := s.deadLetters.Remove(entry.ID); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deadLetterEntry loads the entry named by the request path, writing the
+// error response when it cannot
+func (s *Server) deadLetterEntry(w http.ResponseWriter, r *http.Request) (*deadletter.Entry, bool) {
+	entry, err := s.deadLetters.Get(r.PathValue("id"))
+	if errors.Is(err, deadletter.ErrNotFound) {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	return entry, true
+}
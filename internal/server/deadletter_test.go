@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/deadletter"
+	"github.com/mailsentinel/core/internal/llm"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestBatchDeadLettersEachFailureCategory(t *testing.T) {
+	classifier := newFakeClassifier()
+	classifier.failures["email-1"] = fmt.Errorf("failed to parse classification response: %w", llm.ErrInvalidResponse)
+	classifier.failures["email-2"] = fmt.Errorf("%w: 90000 bytes exceeds ollama.max_prompt_bytes of 65536", llm.ErrPromptTooLarge)
+	classifier.failures["email-3"] = fmt.Errorf("%w: %w", llm.ErrCircuitOpen, llm.ErrRetryQueueFull)
+	classifier.failures["email-4"] = fmt.Errorf("%w: llama3", llm.ErrModelNotFound)
+	srv, queue := testDeadLetterServer(t, classifier)
+	server := httptest.NewServer(srv.Handler())
+	defer server.Close()
+
+	resp := postBatch(t, server.URL, "application/json", testBatch(5))
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var batch types.BatchResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&batch))
+	assert.Equal(t, 1, batch.Summary.ProcessedEmails)
+	require.Len(t, batch.Summary.Failures, 4)
+	for _, failure := range batch.Summary.Failures {
+		assert.Equal(t, deadletter.EntryID(failure.EmailID, "newsletter"), failure.DeadLetterID)
+	}
+
+	entries, err := queue.List()
+	require.NoError(t, err)
+	reasons := make(map[string]string)
+	for _, entry := range entries {
+		reasons[entry.EmailID] = entry.Reason
+		assert.Equal(t, "newsletter", entry.ProfileID)
+	}
+	assert.Equal(t, map[string]string{
+		"email-1": deadletter.ReasonParse,
+		"email-2": deadletter.ReasonSize,
+		"email-3": deadletter.ReasonRetriesExhausted,
+		"email-4": deadletter.ReasonError,
+	}, reasons)
+
+	resp, err = http.Get(server.URL + "/v1/dead-letters")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var listed DeadLettersResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&listed))
+	assert.Len(t, listed.DeadLetters, 4)
+}
+
+func TestRequeueDeadLetter(t *testing.T) {
+	classifier := newFakeClassifier()
+	classifier.failures["email-1"] = fmt.Errorf("%w: deadline", llm.ErrTimeout)
+	srv, queue := testDeadLetterServer(t, classifier)
+	server := httptest.NewServer(srv.Handler())
+	defer server.Close()
+
+	resp := postBatch(t, server.URL, "application/json", testBatch(1))
+	resp.Body.Close()
+	id := deadletter.EntryID("email-1", "newsletter")
+
+	// A second failure keeps the entry and counts the attempt
+	resp = postRequeue(t, server.URL, id)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	entry, err := queue.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, 2, entry.Attempts)
+
+	delete(classifier.failures, "email-1")
+	resp = postRequeue(t, server.URL, id)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var requeued RequeueResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&requeued))
+	assert.Equal(t, id, requeued.DeadLetterID)
+	require.NotNil(t, requeued.Result)
+	assert.Equal(t, "archive", requeued.Result.Action)
+	assert.Equal(t, []string{"newsletter", "newsletter", "newsletter"}, classifier.profilesFor("email-1"))
+
+	_, err = queue.Get(id)
+	assert.ErrorIs(t, err, deadletter.ErrNotFound, "a requeued email leaves the queue")
+	resp = postRequeue(t, server.URL, id)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestDeleteDeadLetter(t *testing.T) {
+	classifier := newFakeClassifier()
+	classifier.failures["email-1"] = fmt.Errorf("boom")
+	srv, queue := testDeadLetterServer(t, classifier)
+	server := httptest.NewServer(srv.Handler())
+	defer server.Close()
+
+	resp := postBatch(t, server.URL, "application/json", testBatch(1))
+	resp.Body.Close()
+
+	req, err := http.NewRequest(http.MethodDelete, server.URL+"/v1/dead-letters/"+deadletter.EntryID("email-1", "newsletter"), nil)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	entries, err := queue.List()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	assert.Equal(t, 1, classifier.callCount(), "a deleted email is not classified again")
+}
+
+func TestDeadLettersWithoutQueue(t *testing.T) {
+	classifier := newFakeClassifier()
+	classifier.failures["email-1"] = fmt.Errorf("boom")
+	server := httptest.NewServer(NewServer(testConfig(1), classifier, testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	resp := postBatch(t, server.URL, "application/json", testBatch(1))
+	defer resp.Body.Close()
+	var batch types.BatchResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&batch))
+	require.Len(t, batch.Summary.Failures, 1)
+	assert.Empty(t, batch.Summary.Failures[0].DeadLetterID)
+
+	resp, err := http.Get(server.URL + "/v1/dead-letters")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// Helper functions
+
+// testDeadLetterServer returns a server dead-lettering failed emails into
+// a queue in a temporary directory
+func testDeadLetterServer(t *testing.T, classifier *fakeClassifier) (*Server, *deadletter.Queue) {
+	t.Helper()
+	queue, err := deadletter.NewQueue(&config.DeadLetterConfig{Enabled: true, Directory: t.TempDir()}, nil, testLogger())
+	require.NoError(t, err)
+	srv := NewServer(testConfig(2), classifier, testProfiles(), testLogger())
+	srv.SetDeadLetters(queue)
+	return srv, queue
+}
+
+func postRequeue(t *testing.T, url, id string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(url+"/v1/dead-letters/"+id+"/requeue", "application/json", nil)
+	require.NoError(t, err)
+	return resp
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/feedback"
+	"github.com/mailsentinel/core/internal/logging"
+	"github.com/mailsentinel/core/internal/resolver"
+)
+
+// FeedbackRecorder moves adaptive profile weights by whether the profiles'
+// results were correct
+type FeedbackRecorder interface {
+	RecordFeedback(correct map[string]bool) (resolver.AdaptiveState, error)
+}
+
+// FeedbackRequest is the body of POST /v1/feedback: whether each profile's
+// result for a decision was correct, keyed by profile ID, as judged by a
+// reviewer or any other source of truth
+type FeedbackRequest struct {
+	Correct map[string]bool `json:"correct"`
+}
+
+// handleFeedback feeds an external correctness signal to the resolver's
+// adaptive weights and responds with the updated weights
+func (s *Server) handleFeedback(w http.ResponseWriter, r *http.Request) {
+	recorder, ok := s.resolver.(FeedbackRecorder)
+	if !ok {
+		s.writeError(w, http.StatusNotFound, "no resolver is configured")
+		return
+	}
+
+	var req FeedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid feedback request: %v", err))
+		return
+	}
+	if len(req.Correct) == 0 {
+		s.writeError(w, http.StatusBadRequest, "correct must not be empty")
+		return
+	}
+	for profileID := range req.Correct {
+		if profileID == "" {
+			s.writeError(w, http.StatusBadRequest, "correct must not contain an empty profile ID")
+			return
+		}
+	}
+
+	state, err := recorder.RecordFeedback(req.Correct)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"profiles": len(req.Correct),
+	}).Debug("Recorded profile feedback")
+	s.writeJSON(w, http.StatusOK, state)
+}
+
+// UserFeedbackRequest is the body of POST /v1/feedback/actions: the action
+// a user took on an email, contradicting the action MailSentinel took, as
+// when an archived email is moved back to the inbox
+type UserFeedbackRequest struct {
+	EmailID    string `json:"email_id"`
+	UserAction string `json:"user_action"`
+	OurAction  string `json:"our_action"`
+}
+
+// handleUserFeedback audits a user contradicting an action, which also
+// moves the adaptive weights when the recorder feeds them
+func (s *Server) handleUserFeedback(w http.ResponseWriter, r *http.Request) {
+	correlationID := requestCorrelationID(w, r)
+
+	if s.feedback == nil {
+		s.writeError(w, http.StatusNotFound, "user feedback is not configured")
+		return
+	}
+
+	var req UserFeedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid feedback request: %v", err))
+		return
+	}
+	if req.EmailID == "" || req.UserAction == "" || req.OurAction == "" {
+		s.writeError(w, http.StatusBadRequest, "email_id, user_action and our_action are required")
+		return
+	}
+
+	ctx := logging.WithCorrelationID(r.Context(), correlationID)
+	if err := s.feedback.RecordFeedback(ctx, req.EmailID, req.UserAction, req.OurAction); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, feedback.ErrNoContradiction):
+			status = http.StatusBadRequest
+		case errors.Is(err, feedback.ErrAuditDisabled):
+			status = http.StatusServiceUnavailable
+		}
+		s.writeError(w, status, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleFeedbackReport responds with the misclassification report of the
+// user feedback audited within the optional since duration, such as 168h
+func (s *Server) handleFeedbackReport(w http.ResponseWriter, r *http.Request) {
+	if s.feedback == nil {
+		s.writeError(w, http.StatusNotFound, "user feedback is not configured")
+		return
+	}
+
+	var since time.Time
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		window, err := time.ParseDuration(raw)
+		if err != nil || window <= 0 {
+			s.writeError(w, http.StatusBadRequest, fmt.Sprintf("since must be a positive duration such as \"168h\", got %q", raw))
+			return
+		}
+		since = time.Now().Add(-window)
+	}
+
+	report, err := s.feedback.Report(since)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, report)
+}
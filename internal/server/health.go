@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// healthCheckTimeout bounds each component check made by a health request
+const healthCheckTimeout = 5 * time.Second
+
+// ComponentStatus is a component's health report. It is encoded as-is in
+// health responses.
+type ComponentStatus interface {
+	HealthState() types.HealthState
+}
+
+// HealthCheck reports the current health of one component
+type HealthCheck func(ctx context.Context) ComponentStatus
+
+// HealthResponse is the body of /healthz and /readyz
+type HealthResponse struct {
+	Status     types.HealthState          `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+	CheckedAt  time.Time                  `json:"checked_at"`
+}
+
+// AddHealthCheck includes a component in the health endpoints
+func (s *Server) AddHealthCheck(name string, check HealthCheck) {
+	s.healthChecks[name] = check
+}
+
+// handleHealth serves /healthz, which fails only when a component is
+// unhealthy. Use it for liveness probes: a degraded component is backing
+// off and restarting will not help.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	response := s.checkHealth(r.Context())
+
+	status := http.StatusOK
+	if response.Status == types.HealthUnhealthy {
+		status = http.StatusServiceUnavailable
+	}
+	s.writeJSON(w, status, response)
+}
+
+// handleReady serves /readyz, which fails unless every component is
+// healthy. Use it for readiness probes so traffic backs off while a
+// component is degraded.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	response := s.checkHealth(r.Context())
+
+	status := http.StatusOK
+	if response.Status != types.HealthHealthy {
+		status = http.StatusServiceUnavailable
+	}
+	s.writeJSON(w, status, response)
+}
+
+// checkHealth runs every health check and aggregates them to the worst state
+func (s *Server) checkHealth(ctx context.Context) *HealthResponse {
+	names := make([]string, 0, len(s.healthChecks))
+	for name := range s.healthChecks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	response := &HealthResponse{
+		Status:     types.HealthHealthy,
+		Components: make(map[string]ComponentStatus, len(names)),
+		CheckedAt:  time.Now(),
+	}
+	for _, name := range names {
+		checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		component := s.healthChecks[name](checkCtx)
+		cancel()
+
+		response.Components[name] = component
+		response.Status = response.Status.Worst(component.HealthState())
+	}
+	return response
+}
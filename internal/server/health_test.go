@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	tests := []struct {
+		name       string
+		states     map[string]types.HealthState
+		expected   types.HealthState
+		healthCode int
+		readyCode  int
+	}{
+		{"no components", nil, types.HealthHealthy, http.StatusOK, http.StatusOK},
+		{"all healthy", map[string]types.HealthState{"ollama": types.HealthHealthy, "gmail": types.HealthHealthy}, types.HealthHealthy, http.StatusOK, http.StatusOK},
+		{"one degraded", map[string]types.HealthState{"ollama": types.HealthDegraded, "gmail": types.HealthHealthy}, types.HealthDegraded, http.StatusOK, http.StatusServiceUnavailable},
+		{"one unhealthy", map[string]types.HealthState{"ollama": types.HealthDegraded, "gmail": types.HealthUnhealthy}, types.HealthUnhealthy, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewServer(testConfig(1), newFakeClassifier(), testProfiles(), testLogger())
+			for name, state := range tt.states {
+				srv.AddHealthCheck(name, fakeHealthCheck(state))
+			}
+			server := httptest.NewServer(srv.Handler())
+			defer server.Close()
+
+			for path, code := range map[string]int{"/healthz": tt.healthCode, "/readyz": tt.readyCode} {
+				resp, err := http.Get(server.URL + path)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+				assert.Equal(t, code, resp.StatusCode, path)
+
+				var body struct {
+					Status     types.HealthState                 `json:"status"`
+					Components map[string]map[string]interface{} `json:"components"`
+				}
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+				assert.Equal(t, tt.expected, body.Status)
+				assert.Len(t, body.Components, len(tt.states))
+				for name, state := range tt.states {
+					assert.Equal(t, string(state), body.Components[name]["status"])
+				}
+			}
+		})
+	}
+}
+
+// Helper functions
+
+// fakeStatus is a component status with a fixed state
+type fakeStatus struct {
+	State types.HealthState `json:"status"`
+}
+
+func (f fakeStatus) HealthState() types.HealthState {
+	return f.State
+}
+
+func fakeHealthCheck(state types.HealthState) HealthCheck {
+	return func(ctx context.Context) ComponentStatus {
+		return fakeStatus{State: state}
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// BatchProgress is how far a batch request has come. Processed, Failed and
+// Skipped count emails as the batch summary does; emails left unprocessed
+// by the time budget are in none of them.
+type BatchProgress struct {
+	CorrelationID string `json:"correlation_id"`
+	Total         int    `json:"total"`
+	Processed     int    `json:"processed"`
+	Failed        int    `json:"failed"`
+	Skipped       int    `json:"skipped"`
+}
+
+// Completed returns the number of emails with an outcome so far
+func (p BatchProgress) Completed() int {
+	return p.Processed + p.Failed + p.Skipped
+}
+
+// BatchProgressFunc receives the progress of batch requests. Calls are
+// serialized across every batch, and the batch waits for each to return,
+// so it must not block.
+type BatchProgressFunc func(progress BatchProgress)
+
+// progressReporter calls a BatchProgressFunc every so many completed emails
+type progressReporter struct {
+	mutex    sync.Mutex
+	every    int
+	callback BatchProgressFunc
+}
+
+// SetBatchProgress makes the server report the progress of every batch
+// request to callback each time another every emails complete, and once
+// the last one does. An every below one reports each email. A nil callback
+// disables reporting.
+func (s *Server) SetBatchProgress(every int, callback BatchProgressFunc) {
+	if callback == nil {
+		s.progress = nil
+		return
+	}
+	s.progress = &progressReporter{every: max(every, 1), callback: callback}
+}
+
+// report passes the progress of a batch to the callback when it is due
+func (r *progressReporter) report(correlationID string, summary *types.BatchSummary) {
+	if r == nil {
+		return
+	}
+	progress := BatchProgress{
+		CorrelationID: correlationID,
+		Total:         summary.TotalEmails,
+		Processed:     summary.ProcessedEmails,
+		Failed:        summary.FailedEmails,
+		Skipped:       summary.SkippedEmails,
+	}
+	if completed := progress.Completed(); completed%r.every != 0 && completed != progress.Total {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.callback(progress)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/annotate"
+	"github.com/mailsentinel/core/internal/resolver"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Explainer resolves profile results like Resolver and also returns how the
+// decision was reached
+type Explainer interface {
+	ExplainDecision(email *types.Email, results []*types.ClassificationResponse) (*types.ClassificationResponse, *resolver.Explanation, error)
+}
+
+// Decider resolves profile results like Resolver into a decision carrying
+// the explanation of how it was reached
+type Decider interface {
+	Decide(email *types.Email, results []*types.ClassificationResponse) (*types.Decision, error)
+}
+
+// ResolveRequest is the body of POST /v1/resolve: canned profile results
+// for one email. The email is optional; priority rules reading it see an
+// empty email otherwise.
+type ResolveRequest struct {
+	Email   *types.Email                   `json:"email,omitempty"`
+	Results []types.ClassificationResponse `json:"results"`
+}
+
+// ResolveResponse is the resolved decision for a ResolveRequest together
+// with its explanation
+type ResolveResponse struct {
+	Decision    *types.ClassificationResponse `json:"decision"`
+	Explanation *resolver.Explanation         `json:"explanation"`
+}
+
+// handleResolve resolves canned profile results with the loaded resolver
+// configuration, without classifying or acting on any mail, so that
+// confidence weighting and priority rules can be tuned interactively
+func (s *Server) handleResolve(w http.ResponseWriter, r *http.Request) {
+	explainer, ok := s.resolver.(Explainer)
+	if !ok {
+		s.writeError(w, http.StatusNotFound, "no resolver is configured")
+		return
+	}
+
+	var req ResolveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid resolve request: %v", err))
+		return
+	}
+	if err := s.validateResolve(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	email := req.Email
+	if email == nil {
+		email = &types.Email{ID: req.Results[0].EmailID}
+	}
+	annotate.Email(email)
+	results := make([]*types.ClassificationResponse, len(req.Results))
+	for i := range req.Results {
+		results[i] = &req.Results[i]
+	}
+
+	decision, explanation, err := explainer.ExplainDecision(email, results)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"email_id": email.ID,
+		"results":  len(results),
+		"method":   explanation.Method,
+		"action":   decision.Action,
+	}).Debug("Simulated resolution")
+	s.writeJSON(w, http.StatusOK, ResolveResponse{Decision: decision, Explanation: explanation})
+}
+
+// validateResolve checks a resolve request before any result is resolved
+func (s *Server) validateResolve(req *ResolveRequest) error {
+	if len(req.Results) == 0 {
+		return fmt.Errorf("results must not be empty")
+	}
+	if limit := s.config.Security.MaxBatchSize; limit > 0 && len(req.Results) > limit {
+		return fmt.Errorf("%d results exceed the maximum of %d", len(req.Results), limit)
+	}
+	for i, result := range req.Results {
+		if result.Action == "" {
+			return fmt.Errorf("results[%d].action is required", i)
+		}
+		if result.Confidence < 0 || result.Confidence > 1 {
+			return fmt.Errorf("results[%d].confidence must be between 0 and 1", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/feedback"
+	"github.com/mailsentinel/core/internal/resolver"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestResolveSimulatesWeightedAverage(t *testing.T) {
+	server := httptest.NewServer(testResolveServer(t).Handler())
+	defer server.Close()
+
+	resp := postResolve(t, server.URL, ResolveRequest{Results: []types.ClassificationResponse{
+		{EmailID: "email-1", ProfileID: "spam", Action: "archive", Confidence: 0.9},
+		{EmailID: "email-1", ProfileID: "newsletter", Action: "archive", Confidence: 0.7},
+		{EmailID: "email-1", ProfileID: "meetings", Action: "star", Confidence: 0.8},
+	}})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var resolved ResolveResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&resolved))
+	assert.Equal(t, "archive", resolved.Decision.Action)
+	assert.Equal(t, "email-1", resolved.Decision.EmailID)
+	require.NotNil(t, resolved.Explanation)
+	assert.Equal(t, resolver.MethodWeightedAverage, resolved.Explanation.Method)
+	assert.Equal(t, "archive", resolved.Explanation.Action)
+	assert.Len(t, resolved.Explanation.Weighted, 3)
+	assert.NotContains(t, resolved.Decision.Metadata, types.MetadataResolution, "the explanation is not repeated in the decision")
+}
+
+func TestResolveAppliesPriorityRulesToEmail(t *testing.T) {
+	server := httptest.NewServer(testResolveServer(t).Handler())
+	defer server.Close()
+
+	resp := postResolve(t, server.URL, ResolveRequest{
+		Email: &types.Email{ID: "email-2", Auth: &types.AuthResults{DMARC: types.AuthFail}},
+		Results: []types.ClassificationResponse{
+			{ProfileID: "spam", Action: "archive", Confidence: 0.9},
+			{ProfileID: "newsletter", Action: "label", Confidence: 0.8},
+		},
+	})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var resolved ResolveResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&resolved))
+	assert.Equal(t, "delete", resolved.Decision.Action)
+	assert.Equal(t, resolver.MethodPriorityRule, resolved.Explanation.Method)
+	assert.Equal(t, "spoofed", resolved.Explanation.Rule)
+}
+
+func TestResolveValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"malformed", `{"results": `, http.StatusBadRequest},
+		{"no results", `{"results": []}`, http.StatusBadRequest},
+		{"missing action", `{"results": [{"profile_id": "spam", "confidence": 0.5}]}`, http.StatusBadRequest},
+		{"confidence out of range", `{"results": [{"profile_id": "spam", "action": "archive", "confidence": 1.5}]}`, http.StatusBadRequest},
+	}
+
+	server := httptest.NewServer(testResolveServer(t).Handler())
+	defer server.Close()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(server.URL+"/v1/resolve", "application/json", bytes.NewBufferString(tt.body))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestResolveWithoutResolver(t *testing.T) {
+	server := httptest.NewServer(NewServer(testConfig(1), newFakeClassifier(), testProfiles(), testLogger()).Handler())
+	defer server.Close()
+
+	resp := postResolve(t, server.URL, ResolveRequest{Results: []types.ClassificationResponse{{Action: "archive", Confidence: 0.9}}})
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestFeedbackMovesAdaptiveWeights(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolver.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+confidence_weighting:
+  method: "weighted_average"
+  adaptive:
+    enabled: true
+    feedback: "external"
+    learning_rate: 0.5
+`), 0644))
+	policyResolver, err := resolver.NewPolicyResolver(path, testLogger())
+	require.NoError(t, err)
+	srv := NewServer(testConfig(1), newFakeClassifier(), testProfiles(), testLogger())
+	srv.SetRouting(nil, policyResolver)
+	server := httptest.NewServer(srv.Handler())
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/v1/feedback", "application/json", bytes.NewBufferString(`{"correct": {"spam": true, "newsletter": false}}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var state resolver.AdaptiveState
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&state))
+	assert.InDelta(t, 1.25, state.Weights["spam"], 1e-9)
+	assert.InDelta(t, 0.75, state.Weights["newsletter"], 1e-9)
+
+	static := httptest.NewServer(testResolveServer(t).Handler())
+	defer static.Close()
+
+	tests := []struct {
+		name   string
+		url    string
+		body   string
+		status int
+	}{
+		{"malformed", server.URL, `{"correct": `, http.StatusBadRequest},
+		{"empty", server.URL, `{"correct": {}}`, http.StatusBadRequest},
+		{"static weights", static.URL, `{"correct": {"spam": true}}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(tt.url+"/v1/feedback", "application/json", bytes.NewBufferString(tt.body))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestUserFeedbackIsAuditedAndReported(t *testing.T) {
+	auditLogger, err := audit.NewLogger(&config.AuditConfig{Enabled: true, Directory: t.TempDir()}, testLogger())
+	require.NoError(t, err)
+	defer auditLogger.Close()
+	srv := NewServer(testConfig(1), newFakeClassifier(), testProfiles(), testLogger())
+	srv.SetFeedback(feedback.NewRecorder(auditLogger, testLogger()))
+	server := httptest.NewServer(srv.Handler())
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/v1/feedback/actions", "application/json",
+		bytes.NewBufferString(`{"email_id": "email-1", "user_action": "keep", "our_action": "archive"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "/v1/feedback/report?since=1h")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var report feedback.Report
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(t, 1, report.Feedback)
+	assert.Equal(t, map[string]map[string]int{"archive": {"keep": 1}}, report.Corrections)
+
+	unconfigured := httptest.NewServer(NewServer(testConfig(1), newFakeClassifier(), testProfiles(), testLogger()).Handler())
+	defer unconfigured.Close()
+	disabledAudit, err := audit.NewLogger(&config.AuditConfig{Enabled: false}, testLogger())
+	require.NoError(t, err)
+	defer disabledAudit.Close()
+	unaudited := NewServer(testConfig(1), newFakeClassifier(), testProfiles(), testLogger())
+	unaudited.SetFeedback(feedback.NewRecorder(disabledAudit, testLogger()))
+	unauditedServer := httptest.NewServer(unaudited.Handler())
+	defer unauditedServer.Close()
+
+	tests := []struct {
+		name   string
+		url    string
+		body   string
+		status int
+	}{
+		{"missing fields", server.URL, `{"email_id": "email-1"}`, http.StatusBadRequest},
+		{"agreeing actions", server.URL, `{"email_id": "email-1", "user_action": "archive", "our_action": "archive"}`, http.StatusBadRequest},
+		{"not configured", unconfigured.URL, `{"email_id": "email-1", "user_action": "keep", "our_action": "archive"}`, http.StatusNotFound},
+		{"auditing disabled", unauditedServer.URL, `{"email_id": "email-1", "user_action": "keep", "our_action": "archive"}`, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(tt.url+"/v1/feedback/actions", "application/json", bytes.NewBufferString(tt.body))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
+
+// Helper functions
+
+// testResolveServer returns a server resolving with a weighted average and
+// a priority rule deleting emails failing DMARC
+func testResolveServer(t *testing.T) *Server {
+	t.Helper()
+	policyResolver, err := resolver.NewPolicyResolver(testResolverConfig(t), testLogger())
+	require.NoError(t, err)
+
+	srv := NewServer(testConfig(1), newFakeClassifier(), testProfiles(), testLogger())
+	srv.SetRouting(nil, policyResolver)
+	return srv
+}
+
+// testResolverConfig writes the resolver configuration of
+// testResolveServer, returning its path
+func testResolverConfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "resolver.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+priority_rules:
+  - name: "spoofed"
+    condition: "email.auth.dmarc == 'fail'"
+    action: "delete"
+    priority: 100
+confidence_weighting:
+  method: "weighted_average"
+  profile_weights:
+    spam: 1.0
+    newsletter: 0.6
+    meetings: 0.8
+`), 0644))
+	return path
+}
+
+func postResolve(t *testing.T, url string, req ResolveRequest) *http.Response {
+	t.Helper()
+	body, err := json.Marshal(req)
+	require.NoError(t, err)
+	resp, err := http.Post(url+"/v1/resolve", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	return resp
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/internal/deadletter"
+	"github.com/mailsentinel/core/internal/feedback"
+	"github.com/mailsentinel/core/internal/lifecycle"
+	"github.com/mailsentinel/core/internal/processor"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// Classifier is the subset of llm.Classifier used to classify batches, so
+// any backend can serve the API
+type Classifier interface {
+	ClassifyEmail(ctx context.Context, profile *types.Profile, email *types.Email) (*types.ClassificationResponse, error)
+}
+
+// ProfileProvider looks up loaded profiles by ID
+type ProfileProvider interface {
+	GetProfile(id string) (*types.Profile, error)
+	GetRegistry() *types.ProfileRegistry
+}
+
+// Router selects the profiles that classify an email in a routed batch
+type Router interface {
+	Route(email *types.Email, profiles []*types.Profile) []*types.Profile
+	ShouldExecute(profile *types.Profile, email *types.Email, prior []*types.ClassificationResponse) bool
+}
+
+// Resolver combines the results of every profile that classified an email
+type Resolver interface {
+	ResolveDecision(email *types.Email, results []*types.ClassificationResponse) (*types.ClassificationResponse, error)
+}
+
+// shutdownTimeout bounds how long Run waits for in-flight requests
+const shutdownTimeout = 10 * time.Second
+
+// Server exposes classification over HTTP
+type Server struct {
+	config       *config.Config
+	classifier   Classifier
+	profiles     ProfileProvider
+	router       Router
+	resolver     Resolver
+	mailbox      EmailFetcher
+	previews     PreviewLister
+	processor    *processor.Processor
+	audit        *audit.Logger
+	deadLetters  *deadletter.Queue
+	feedback     *feedback.Recorder
+	lifecycle    *lifecycle.Coordinator
+	progress     *progressReporter
+	healthChecks map[string]HealthCheck
+	logger       *logrus.Logger
+}
+
+// NewServer creates a new HTTP server
+func NewServer(cfg *config.Config, classifier Classifier, profiles ProfileProvider, logger *logrus.Logger) *Server {
+	return &Server{
+		config:       cfg,
+		classifier:   classifier,
+		profiles:     profiles,
+		healthChecks: make(map[string]HealthCheck),
+		logger:       logger,
+	}
+}
+
+// SetRouting enables routed batches, which omit profile_id: each email is
+// classified by the profiles the router selects and the results are
+// combined by the resolver
+func (s *Server) SetRouting(router Router, resolver Resolver) {
+	s.router = router
+	s.resolver = resolver
+}
+
+// SetAuditLogger records the decision reached for every email of a batch,
+// or requeued from the dead-letter queue, as one audit entry. A nil logger
+// disables decision auditing.
+func (s *Server) SetAuditLogger(auditLogger *audit.Logger) {
+	s.audit = auditLogger
+}
+
+// SetProcessor makes batches act on the mailbox: the decision reached for
+// each email is applied with processor as soon as it is reached or, in a dry
+// run batch, planned without modifying the mailbox. The processor audits
+// each decision it applies, in place of the server. A nil processor leaves
+// batches classifying only.
+func (s *Server) SetProcessor(p *processor.Processor) {
+	s.processor = p
+}
+
+// SetDeadLetters keeps the emails of a batch whose classification fails in
+// a dead-letter queue, from which they can be listed and requeued. A nil
+// queue disables dead-lettering.
+func (s *Server) SetDeadLetters(queue *deadletter.Queue) {
+	s.deadLetters = queue
+}
+
+// SetFeedback accepts user feedback contradicting the actions taken on
+// emails, recorded with recorder, and reports it as misclassifications. A
+// nil recorder disables user feedback.
+func (s *Server) SetFeedback(recorder *feedback.Recorder) {
+	s.feedback = recorder
+}
+
+// SetLifecycle makes the server track its classifications with a shutdown
+// coordinator. When Run stops, it shuts the coordinator down, waiting for
+// in-flight classifications before the HTTP server closes. A nil
+// coordinator disables tracking.
+func (s *Server) SetLifecycle(coordinator *lifecycle.Coordinator) {
+	s.lifecycle = coordinator
+}
+
+// Handler returns the HTTP handler serving the API routes
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/batch", s.handleBatch)
+	mux.HandleFunc("POST /v1/resolve", s.handleResolve)
+	mux.HandleFunc("GET /v1/classify/{messageID}", s.handleClassifyByID)
+	mux.HandleFunc("GET /v1/previews", s.handleClassifyPreviews)
+	mux.HandleFunc("POST /v1/feedback", s.handleFeedback)
+	mux.HandleFunc("POST /v1/feedback/actions", s.handleUserFeedback)
+	mux.HandleFunc("GET /v1/feedback/report", s.handleFeedbackReport)
+	mux.HandleFunc("GET /v1/dead-letters", s.handleListDeadLetters)
+	mux.HandleFunc("POST /v1/dead-letters/{id}/requeue", s.handleRequeueDeadLetter)
+	mux.HandleFunc("DELETE /v1/dead-letters/{id}", s.handleDeleteDeadLetter)
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("GET /readyz", s.handleReady)
+	return mux
+}
+
+// Run serves the API on the configured port until ctx is cancelled, then
+// shuts down gracefully
+func (s *Server) Run(ctx context.Context) error {
+	httpServer := &http.Server{
+		Addr:           fmt.Sprintf(":%d", s.config.Server.Port),
+		Handler:        s.Handler(),
+		ReadTimeout:    s.config.Server.ReadTimeout,
+		WriteTimeout:   s.config.Server.WriteTimeout,
+		MaxHeaderBytes: s.config.Server.MaxHeaderBytes,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		s.logger.WithField("addr", httpServer.Addr).Info("Starting HTTP server")
+		errCh <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("HTTP server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Refuse new classifications and drain the running ones first, so open
+	// batches finish quickly and the HTTP server can close cleanly
+	if err := s.lifecycle.Shutdown(shutdownCtx); err != nil {
+		s.logger.WithError(err).Error("Graceful shutdown incomplete")
+	}
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down HTTP server: %w", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("HTTP server failed: %w", err)
+	}
+	return nil
+}
+
+// errorResponse is the body of every non-2xx API response
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.WithError(err).Warn("Failed to write HTTP response")
+	}
+}
+
+// writeError writes a JSON error response
+func (s *Server) writeError(w http.ResponseWriter, status int, message string) {
+	s.writeJSON(w, status, errorResponse{Error: message})
+}
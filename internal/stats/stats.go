@@ -0,0 +1,291 @@
+// Package stats rolls classifications up into sender and domain statistics:
+// the top senders by volume, the actions taken per sender domain and the
+// distribution of confidence.
+//
+// Classifications are read from the audit log, whose email_classified
+// entries record the sender alongside the decision. Shadow decisions and
+// entries flagged as duplicates are not counted, as they never reached an
+// email.
+package stats
+
+import (
+	"fmt"
+	"math"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// UnknownSender stands in for a missing or empty sender address
+const UnknownSender = "unknown"
+
+// DefaultBuckets is the number of equal-width confidence buckets
+const DefaultBuckets = 10
+
+// SenderStats counts the classifications of one sender address
+type SenderStats struct {
+	Sender  string         `json:"sender"`
+	Domain  string         `json:"domain"`
+	Count   int            `json:"count"`
+	Actions map[string]int `json:"actions"`
+}
+
+// DomainStats counts the classifications of every sender in one domain
+type DomainStats struct {
+	Domain         string         `json:"domain"`
+	Count          int            `json:"count"`
+	Senders        int            `json:"senders"`
+	Actions        map[string]int `json:"actions"`
+	MeanConfidence float64        `json:"mean_confidence"`
+}
+
+// ConfidenceBucket counts the classifications with a confidence in
+// [Min, Max), the last bucket including 1
+type ConfidenceBucket struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+// StatsReport is the rollup of a set of classifications
+type StatsReport struct {
+	Classifications int       `json:"classifications"`
+	FirstSeen       time.Time `json:"first_seen,omitempty"`
+	LastSeen        time.Time `json:"last_seen,omitempty"`
+
+	// TopSenders are the senders with the most classifications, most first
+	TopSenders []SenderStats `json:"top_senders"`
+	// Domains are every sender domain, most classifications first
+	Domains []DomainStats `json:"domains"`
+	// Actions counts the classifications by action
+	Actions map[string]int `json:"actions"`
+
+	MeanConfidence float64            `json:"mean_confidence"`
+	Confidence     []ConfidenceBucket `json:"confidence"`
+}
+
+// String summarises the report in one line
+func (r *StatsReport) String() string {
+	return fmt.Sprintf("%d classifications from %d domains, mean confidence %.3f",
+		r.Classifications, len(r.Domains), r.MeanConfidence)
+}
+
+// Aggregator accumulates classifications. It is not safe for concurrent use.
+type Aggregator struct {
+	buckets       []int
+	senders       map[string]*SenderStats
+	domains       map[string]*domainTotals
+	actions       map[string]int
+	total         int
+	confidenceSum float64
+	first, last   time.Time
+}
+
+// domainTotals accumulates a domain's stats and its confidence sum
+type domainTotals struct {
+	DomainStats
+	confidenceSum float64
+}
+
+// NewAggregator creates an aggregator bucketing confidence into buckets
+// equal-width buckets, or DefaultBuckets when buckets is not positive
+func NewAggregator(buckets int) *Aggregator {
+	if buckets <= 0 {
+		buckets = DefaultBuckets
+	}
+	return &Aggregator{
+		buckets: make([]int, buckets),
+		senders: make(map[string]*SenderStats),
+		domains: make(map[string]*domainTotals),
+		actions: make(map[string]int),
+	}
+}
+
+// Add counts one classification of an email from the given sender, made at
+// the given time
+func (a *Aggregator) Add(from string, result *types.ClassificationResponse, at time.Time) {
+	sender := NormalizeSender(from)
+	domain := senderDomain(sender)
+
+	stats, exists := a.senders[sender]
+	if !exists {
+		stats = &SenderStats{Sender: sender, Domain: domain, Actions: make(map[string]int)}
+		a.senders[sender] = stats
+	}
+	stats.Count++
+	stats.Actions[result.Action]++
+
+	totals, exists := a.domains[domain]
+	if !exists {
+		totals = &domainTotals{DomainStats: DomainStats{Domain: domain, Actions: make(map[string]int)}}
+		a.domains[domain] = totals
+	}
+	if stats.Count == 1 {
+		totals.Senders++
+	}
+	totals.Count++
+	totals.Actions[result.Action]++
+	totals.confidenceSum += result.Confidence
+
+	a.actions[result.Action]++
+	a.total++
+	a.confidenceSum += result.Confidence
+	a.buckets[a.bucket(result.Confidence)]++
+
+	if !at.IsZero() {
+		if a.first.IsZero() || at.Before(a.first) {
+			a.first = at
+		}
+		if at.After(a.last) {
+			a.last = at
+		}
+	}
+}
+
+// AddEntry counts an email_classified audit entry, skipping other entries,
+// shadow decisions and duplicates. It reports whether the entry was counted.
+func (a *Aggregator) AddEntry(entry audit.AuditEntry) bool {
+	if entry.EventType != audit.EventEmailClassified {
+		return false
+	}
+	if shadow, _ := entry.Metadata[types.MetadataShadow].(bool); shadow {
+		return false
+	}
+	if _, duplicate := entry.Metadata[audit.MetadataDuplicateOf]; duplicate {
+		return false
+	}
+
+	from, _ := entry.Metadata["email_from"].(string)
+	a.Add(from, &types.ClassificationResponse{
+		EmailID:    entry.EmailID,
+		ProfileID:  entry.ProfileID,
+		Action:     entry.Action,
+		Confidence: entry.Confidence,
+	}, entry.Timestamp)
+	return true
+}
+
+// Report returns the rollup so far, listing at most top senders, or every
+// sender when top is not positive. Ties are broken by address so that
+// reports are stable.
+func (a *Aggregator) Report(top int) *StatsReport {
+	report := &StatsReport{
+		Classifications: a.total,
+		FirstSeen:       a.first,
+		LastSeen:        a.last,
+		Actions:         make(map[string]int, len(a.actions)),
+		Confidence:      make([]ConfidenceBucket, len(a.buckets)),
+	}
+	for action, count := range a.actions {
+		report.Actions[action] = count
+	}
+	if a.total > 0 {
+		report.MeanConfidence = a.confidenceSum / float64(a.total)
+	}
+
+	width := 1 / float64(len(a.buckets))
+	for i, count := range a.buckets {
+		report.Confidence[i] = ConfidenceBucket{
+			Min:   round(float64(i) * width),
+			Max:   round(float64(i+1) * width),
+			Count: count,
+		}
+	}
+
+	senders := make([]SenderStats, 0, len(a.senders))
+	for _, stats := range a.senders {
+		senders = append(senders, copySender(stats))
+	}
+	sort.Slice(senders, func(i, j int) bool {
+		if senders[i].Count != senders[j].Count {
+			return senders[i].Count > senders[j].Count
+		}
+		return senders[i].Sender < senders[j].Sender
+	})
+	if top > 0 && len(senders) > top {
+		senders = senders[:top]
+	}
+	report.TopSenders = senders
+
+	report.Domains = make([]DomainStats, 0, len(a.domains))
+	for _, totals := range a.domains {
+		stats := totals.DomainStats
+		stats.Actions = make(map[string]int, len(totals.Actions))
+		for action, count := range totals.Actions {
+			stats.Actions[action] = count
+		}
+		stats.MeanConfidence = totals.confidenceSum / float64(totals.Count)
+		report.Domains = append(report.Domains, stats)
+	}
+	sort.Slice(report.Domains, func(i, j int) bool {
+		if report.Domains[i].Count != report.Domains[j].Count {
+			return report.Domains[i].Count > report.Domains[j].Count
+		}
+		return report.Domains[i].Domain < report.Domains[j].Domain
+	})
+	return report
+}
+
+// bucket returns the index of the bucket a confidence falls in, clamping
+// confidences outside [0, 1]
+func (a *Aggregator) bucket(confidence float64) int {
+	index := int(math.Floor(confidence * float64(len(a.buckets))))
+	if index < 0 || math.IsNaN(confidence) {
+		return 0
+	}
+	if index >= len(a.buckets) {
+		return len(a.buckets) - 1
+	}
+	return index
+}
+
+// NormalizeSender reduces a From header to a comparable address: the
+// display name is dropped, the address is lowercased and a "+tag"
+// subaddress is removed, so "Shop <Deals+Spring@Shop.example>" becomes
+// deals@shop.example. Unparseable headers are only trimmed and lowercased.
+func NormalizeSender(from string) string {
+	address := strings.TrimSpace(from)
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		address = parsed.Address
+	}
+	address = strings.ToLower(address)
+	if address == "" {
+		return UnknownSender
+	}
+
+	local, domain, found := strings.Cut(address, "@")
+	if !found {
+		return address
+	}
+	if base, _, tagged := strings.Cut(local, "+"); tagged && base != "" {
+		local = base
+	}
+	return local + "@" + strings.TrimSuffix(domain, ".")
+}
+
+// senderDomain returns the domain of a normalized sender address
+func senderDomain(sender string) string {
+	if _, domain, found := strings.Cut(sender, "@"); found && domain != "" {
+		return domain
+	}
+	return UnknownSender
+}
+
+// copySender copies sender stats so the report does not share their maps
+func copySender(stats *SenderStats) SenderStats {
+	copied := *stats
+	copied.Actions = make(map[string]int, len(stats.Actions))
+	for action, count := range stats.Actions {
+		copied.Actions[action] = count
+	}
+	return copied
+}
+
+// round drops the floating-point noise of bucket bounds
+func round(value float64) float64 {
+	return math.Round(value*1e6) / 1e6
+}
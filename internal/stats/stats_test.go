@@ -0,0 +1,134 @@
+package stats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/internal/audit"
+	"github.com/mailsentinel/core/pkg/config"
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestReportRollups(t *testing.T) {
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	fixtures := []struct {
+		from       string
+		action     string
+		confidence float64
+	}{
+		{"Shop <deals@shop.example>", "archive", 0.95},
+		{"DEALS+spring@Shop.Example", "archive", 0.85},
+		{"deals@shop.example", "keep", 0.55},
+		{"news@shop.example", "archive", 0.75},
+		{"Alice <alice@corp.example>", "keep", 0.9},
+		{"alice@corp.example", "keep", 1.0},
+		{"", "spam", 0.05},
+	}
+
+	aggregator := NewAggregator(4)
+	for i, fixture := range fixtures {
+		aggregator.Add(fixture.from, &types.ClassificationResponse{Action: fixture.action, Confidence: fixture.confidence}, start.Add(time.Duration(i)*time.Minute))
+	}
+	report := aggregator.Report(2)
+
+	assert.Equal(t, 7, report.Classifications)
+	assert.Equal(t, start, report.FirstSeen)
+	assert.Equal(t, start.Add(6*time.Minute), report.LastSeen)
+	assert.Equal(t, map[string]int{"archive": 3, "keep": 3, "spam": 1}, report.Actions)
+	assert.InDelta(t, (0.95+0.85+0.55+0.75+0.9+1.0+0.05)/7, report.MeanConfidence, 1e-9)
+
+	assert.Equal(t, []SenderStats{
+		{Sender: "deals@shop.example", Domain: "shop.example", Count: 3, Actions: map[string]int{"archive": 2, "keep": 1}},
+		{Sender: "alice@corp.example", Domain: "corp.example", Count: 2, Actions: map[string]int{"keep": 2}},
+	}, report.TopSenders)
+
+	require.Len(t, report.Domains, 3)
+	shop := report.Domains[0]
+	assert.Equal(t, "shop.example", shop.Domain)
+	assert.Equal(t, 4, shop.Count)
+	assert.Equal(t, 2, shop.Senders)
+	assert.Equal(t, map[string]int{"archive": 3, "keep": 1}, shop.Actions)
+	assert.InDelta(t, (0.95+0.85+0.55+0.75)/4, shop.MeanConfidence, 1e-9)
+	assert.Equal(t, "corp.example", report.Domains[1].Domain)
+	assert.Equal(t, UnknownSender, report.Domains[2].Domain)
+
+	assert.Equal(t, []ConfidenceBucket{
+		{Min: 0, Max: 0.25, Count: 1},
+		{Min: 0.25, Max: 0.5, Count: 0},
+		{Min: 0.5, Max: 0.75, Count: 1},
+		{Min: 0.75, Max: 1, Count: 5},
+	}, report.Confidence, "a confidence of exactly 1 falls in the last bucket")
+}
+
+func TestReportFromAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	auditLogger, err := audit.NewLogger(&config.AuditConfig{Enabled: true, Directory: dir}, testLogger())
+	require.NoError(t, err)
+
+	record := func(id, from, action string, metadata map[string]interface{}) {
+		email := &types.Email{ID: id, Subject: "Subject", From: from}
+		require.NoError(t, auditLogger.LogEmailClassification(context.Background(), email, &types.ClassificationResponse{
+			ProfileID:  "newsletter",
+			Action:     action,
+			Confidence: 0.8,
+			Metadata:   metadata,
+		}))
+	}
+	record("email-1", "deals@shop.example", "archive", nil)
+	record("email-1", "deals@shop.example", "archive", nil) // flagged as a duplicate
+	record("email-2", "news@shop.example", "keep", nil)
+	record("email-2", "news@shop.example", "archive", map[string]interface{}{types.MetadataShadow: true})
+	require.NoError(t, auditLogger.LogAction(context.Background(), &types.Email{ID: "email-1"}, "archive", "-INBOX"))
+	require.NoError(t, auditLogger.Close())
+
+	entries, err := audit.QueryDirectory(dir, audit.Query{})
+	require.NoError(t, err)
+	aggregator := NewAggregator(0)
+	counted := 0
+	for _, entry := range entries {
+		if aggregator.AddEntry(entry) {
+			counted++
+		}
+	}
+
+	report := aggregator.Report(0)
+	assert.Equal(t, 2, counted, "duplicates, shadow decisions and other events are skipped")
+	assert.Equal(t, map[string]int{"archive": 1, "keep": 1}, report.Actions)
+	assert.Len(t, report.Confidence, DefaultBuckets)
+	assert.Equal(t, 2, report.Confidence[8].Count)
+	require.Len(t, report.Domains, 1)
+	assert.Equal(t, 2, report.Domains[0].Senders)
+}
+
+func TestNormalizeSender(t *testing.T) {
+	tests := []struct {
+		from     string
+		expected string
+	}{
+		{"Shop <Deals@Shop.Example>", "deals@shop.example"},
+		{"deals+spring@shop.example", "deals@shop.example"},
+		{"+only@shop.example", "+only@shop.example"},
+		{"=?UTF-8?Q?Caf=C3=A9?= <cafe@example.com>", "cafe@example.com"},
+		{"  not an address  ", "not an address"},
+		{"", UnknownSender},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			assert.Equal(t, tt.expected, NormalizeSender(tt.from))
+		})
+	}
+}
+
+// Helper functions
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logger
+}
@@ -1,35 +1,126 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
+
+	"github.com/mailsentinel/core/pkg/types"
 )
 
 // Config represents the main application configuration
 type Config struct {
-	Gmail    GmailConfig    `yaml:"gmail" json:"gmail"`
-	Ollama   OllamaConfig   `yaml:"ollama" json:"ollama"`
-	Profiles ProfilesConfig `yaml:"profiles" json:"profiles"`
-	Audit    AuditConfig    `yaml:"audit" json:"audit"`
-	Security SecurityConfig `yaml:"security" json:"security"`
-	Server   ServerConfig   `yaml:"server" json:"server"`
+	Gmail         GmailConfig         `yaml:"gmail" json:"gmail"`
+	Mail          MailConfig          `yaml:"mail" json:"mail"`
+	Ollama        OllamaConfig        `yaml:"ollama" json:"ollama"`
+	LLM           LLMConfig           `yaml:"llm" json:"llm"`
+	Profiles      ProfilesConfig      `yaml:"profiles" json:"profiles"`
+	Audit         AuditConfig         `yaml:"audit" json:"audit"`
+	Security      SecurityConfig      `yaml:"security" json:"security"`
+	Server        ServerConfig        `yaml:"server" json:"server"`
+	Concurrency   ConcurrencyConfig   `yaml:"concurrency" json:"concurrency"`
+	Actions       ActionsConfig       `yaml:"actions" json:"actions"`
+	Logging       LoggingConfig       `yaml:"logging" json:"logging"`
+	Notifications NotificationsConfig `yaml:"notifications" json:"notifications"`
+}
+
+// NotificationsConfig controls webhook notifications for classifications
+// matching a rule
+type NotificationsConfig struct {
+	Enabled    bool               `yaml:"enabled" json:"enabled"`
+	Timeout    time.Duration      `yaml:"timeout" json:"timeout"`
+	Retries    int                `yaml:"retries" json:"retries"`
+	RetryDelay time.Duration      `yaml:"retry_delay" json:"retry_delay"`
+	Rules      []NotificationRule `yaml:"rules" json:"rules"`
+}
+
+// NotificationRule posts to a webhook when a classification has one of the
+// actions, from one of the profiles, with at least the minimum confidence.
+// Empty actions or profiles match any.
+type NotificationRule struct {
+	Name          string   `yaml:"name" json:"name"`
+	Profiles      []string `yaml:"profiles,omitempty" json:"profiles,omitempty"`
+	Actions       []string `yaml:"actions,omitempty" json:"actions,omitempty"`
+	MinConfidence float64  `yaml:"min_confidence" json:"min_confidence"`
+	URL           string   `yaml:"url" json:"url"`
+	// Template is a text/template rendering the request body; empty sends
+	// the notification as JSON
+	Template    string            `yaml:"template,omitempty" json:"template,omitempty"`
+	ContentType string            `yaml:"content_type,omitempty" json:"content_type,omitempty"`
+	Headers     map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+}
+
+// LoggingConfig controls the format and level of log output
+type LoggingConfig struct {
+	Format string `yaml:"format" json:"format"` // "text" or "json"
+	Level  string `yaml:"level" json:"level"`
 }
 
 // GmailConfig contains Gmail API configuration
 type GmailConfig struct {
-	ClientID       string        `yaml:"client_id" json:"client_id"`
-	ClientSecret   string        `yaml:"client_secret" json:"client_secret"`
-	TokenFile      string        `yaml:"token_file" json:"token_file"`
-	Scopes         []string      `yaml:"scopes" json:"scopes"`
-	BatchSize      int           `yaml:"batch_size" json:"batch_size"`
-	RateLimit      int           `yaml:"rate_limit" json:"rate_limit"`
-	Timeout        time.Duration `yaml:"timeout" json:"timeout"`
-	RetryAttempts  int           `yaml:"retry_attempts" json:"retry_attempts"`
-	RetryDelay     time.Duration `yaml:"retry_delay" json:"retry_delay"`
+	ClientID             string        `yaml:"client_id" json:"client_id"`
+	ClientSecret         string        `yaml:"client_secret" json:"client_secret"`
+	TokenFile            string        `yaml:"token_file" json:"token_file"`
+	SyncStateFile        string        `yaml:"sync_state_file" json:"sync_state_file"`
+	Scopes               []string      `yaml:"scopes" json:"scopes"`
+	BatchSize            int           `yaml:"batch_size" json:"batch_size"`
+	RateLimit            int           `yaml:"rate_limit" json:"rate_limit"`
+	Timeout              time.Duration `yaml:"timeout" json:"timeout"`
+	RetryAttempts        int           `yaml:"retry_attempts" json:"retry_attempts"`
+	RetryDelay           time.Duration `yaml:"retry_delay" json:"retry_delay"`
+	// AllowPermanentDelete opts in to label_mapping operations that delete
+	// messages permanently instead of moving them to the trash. It requires
+	// the https://mail.google.com/ scope.
+	AllowPermanentDelete bool          `yaml:"allow_permanent_delete" json:"allow_permanent_delete"`
+}
+
+// GmailScopeFull is the Gmail scope permanent deletes require
+const GmailScopeFull = "https://mail.google.com/"
+
+// Mail providers selectable with MailConfig.Provider
+const (
+	MailProviderGmail = "gmail"
+	MailProviderIMAP  = "imap"
+)
+
+// MailConfig selects the mailbox emails are read from and acted on
+type MailConfig struct {
+	Provider string     `yaml:"provider" json:"provider"`
+	IMAP     IMAPConfig `yaml:"imap" json:"imap"`
+}
+
+// IMAP connection security modes selectable with IMAPConfig.TLS
+const (
+	IMAPTLSImplicit = "implicit"
+	IMAPTLSStartTLS = "starttls"
+	IMAPTLSNone     = "none"
+)
+
+// IMAPConfig configures a generic IMAP mailbox. Gmail labels map to IMAP
+// flags and folders: UNREAD and STARRED to the \Seen and \Flagged flags,
+// archiving and reporting spam to moves into the archive and spam folders,
+// and other labels to copies into the folder of that name.
+type IMAPConfig struct {
+	Address       string        `yaml:"address" json:"address"`
+	Username      string        `yaml:"username" json:"username"`
+	Password      string        `yaml:"password" json:"password"`
+	TLS           string        `yaml:"tls" json:"tls"`
+	Mailbox       string        `yaml:"mailbox" json:"mailbox"`
+	ArchiveFolder string        `yaml:"archive_folder" json:"archive_folder"`
+	SpamFolder    string        `yaml:"spam_folder" json:"spam_folder"`
+	TrashFolder   string        `yaml:"trash_folder" json:"trash_folder"`
+	Timeout       time.Duration `yaml:"timeout" json:"timeout"`
+	// AllowPermanentDelete opts in to label_mapping operations that expunge
+	// messages instead of moving them to the trash folder
+	AllowPermanentDelete bool `yaml:"allow_permanent_delete" json:"allow_permanent_delete"`
 }
 
 // OllamaConfig contains Ollama client configuration
@@ -41,6 +132,137 @@ type OllamaConfig struct {
 	CircuitBreaker    CircuitBreakerConfig `yaml:"circuit_breaker" json:"circuit_breaker"`
 	RequestTimeout    time.Duration `yaml:"request_timeout" json:"request_timeout"`
 	HealthCheckPeriod time.Duration `yaml:"health_check_period" json:"health_check_period"`
+	Deterministic     bool          `yaml:"deterministic" json:"deterministic"`
+	DeterministicSeed int64         `yaml:"deterministic_seed" json:"deterministic_seed"`
+	// KeepAlive is how long Ollama keeps a model loaded after a request;
+	// negative keeps it loaded until Ollama stops, and zero leaves Ollama's
+	// default of five minutes. Profiles may override it.
+	KeepAlive         time.Duration `yaml:"keep_alive" json:"keep_alive"`
+	// DefaultModelParams fills in the model parameters a profile leaves
+	// unset, such as its temperature or max_tokens
+	DefaultModelParams types.ModelParams `yaml:"default_model_params" json:"default_model_params"`
+	// MaxPromptBytes caps the size of an assembled prompt, including the
+	// profile's system prompt, examples and thread context; larger prompts
+	// fail before they are sent. Zero disables the limit.
+	MaxPromptBytes int `yaml:"max_prompt_bytes" json:"max_prompt_bytes"`
+	// PullMissing pulls the default model at startup when Ollama does not
+	// have it yet
+	PullMissing bool `yaml:"pull_missing" json:"pull_missing"`
+}
+
+// LLM backends selectable with LLMConfig.Backend
+const (
+	LLMBackendOllama = "ollama"
+	LLMBackendOpenAI = "openai"
+)
+
+// LLMConfig selects the language model backend used for classification
+type LLMConfig struct {
+	Backend       string              `yaml:"backend" json:"backend"`
+	OpenAI        OpenAIConfig        `yaml:"openai" json:"openai"`
+	Cache         CacheConfig         `yaml:"cache" json:"cache"`
+	ThreadContext ThreadContextConfig `yaml:"thread_context" json:"thread_context"`
+	RetryQueue    RetryQueueConfig    `yaml:"retry_queue" json:"retry_queue"`
+	FailAction    FailActionConfig    `yaml:"fail_action" json:"fail_action"`
+	Previews      PreviewsConfig      `yaml:"previews" json:"previews"`
+	EmptyResponse EmptyResponseConfig `yaml:"empty_response" json:"empty_response"`
+	// RawResponse includes the exact text the model returned in the
+	// metadata of every result, for debugging prompts and parsing. It is
+	// off by default, as it bloats every result.
+	RawResponse bool `yaml:"raw_response" json:"raw_response"`
+}
+
+// PreviewsConfig controls classifying preview emails, which carry only the
+// mail provider's snippet: enabled, the full message is fetched from the
+// mail provider when a profile needs it, or else the snippet is all that is
+// classified
+type PreviewsConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+}
+
+// EmptyResponseConfig controls what becomes of a classification whose model
+// returned no output at all. With Retry, it is sent again once with its
+// temperature raised by TemperatureStep, up to MaxRetryTemperature, before
+// failing with llm.ErrEmptyResponse.
+type EmptyResponseConfig struct {
+	Retry           bool    `yaml:"retry" json:"retry"`
+	TemperatureStep float64 `yaml:"temperature_step" json:"temperature_step"`
+}
+
+// MaxRetryTemperature bounds the temperature of an empty response retry
+const MaxRetryTemperature = 1.0
+
+// FailActionConfig controls what becomes of an email whose classification
+// failed for good, after every model and retry. Enabled, the failure yields
+// a zero-confidence result with Action, flagged classification_failed,
+// instead of an error, so that the email is still routed and audited. A
+// profile setting fail_action opts in on its own.
+type FailActionConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Action is the action of failed classifications, review by default
+	Action string `yaml:"action" json:"action"`
+}
+
+// DefaultFailAction is the action failed classifications are given
+const DefaultFailAction = "review"
+
+// ActionFor returns the fail action of a profile's classifications, which
+// sets its own with fail_action, or "" when failures stay errors
+func (f FailActionConfig) ActionFor(profileAction string) string {
+	switch {
+	case profileAction != "":
+		return profileAction
+	case !f.Enabled:
+		return ""
+	case f.Action != "":
+		return f.Action
+	default:
+		return DefaultFailAction
+	}
+}
+
+// RetryQueueConfig controls holding classifications rejected by an open
+// circuit breaker until the breaker lets requests through again, so that a
+// brief backend outage delays them instead of failing them
+type RetryQueueConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// MaxSize bounds the classifications waiting at once; beyond it they
+	// fail straight away
+	MaxSize int `yaml:"max_size" json:"max_size"`
+	// MaxWait bounds how long a classification waits for the breaker
+	MaxWait time.Duration `yaml:"max_wait" json:"max_wait"`
+}
+
+// ThreadContextConfig controls including the earlier messages of an email's
+// thread in its classification prompt, fetched from the mail provider by
+// thread ID
+type ThreadContextConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// MaxTokens bounds the estimated tokens of the included messages; the
+	// most recent messages are kept
+	MaxTokens int `yaml:"max_tokens" json:"max_tokens"`
+	// MaxMessages bounds the number of included messages; zero includes as
+	// many as MaxTokens allows
+	MaxMessages int `yaml:"max_messages" json:"max_messages"`
+}
+
+// CacheConfig controls caching of classification results, so that an
+// unchanged email under an unchanged profile version is not re-classified
+type CacheConfig struct {
+	Enabled    bool `yaml:"enabled" json:"enabled"`
+	MaxEntries int  `yaml:"max_entries" json:"max_entries"`
+}
+
+// OpenAIConfig configures a server speaking the OpenAI chat completions API,
+// such as vLLM or the llama.cpp server
+type OpenAIConfig struct {
+	BaseURL           string               `yaml:"base_url" json:"base_url"`
+	APIKey            string               `yaml:"api_key" json:"api_key"`
+	DefaultModel      string               `yaml:"default_model" json:"default_model"`
+	RequestTimeout    time.Duration        `yaml:"request_timeout" json:"request_timeout"`
+	CircuitBreaker    CircuitBreakerConfig `yaml:"circuit_breaker" json:"circuit_breaker"`
+	Deterministic     bool                 `yaml:"deterministic" json:"deterministic"`
+	DeterministicSeed int64                `yaml:"deterministic_seed" json:"deterministic_seed"`
 }
 
 // CircuitBreakerConfig defines circuit breaker parameters
@@ -58,6 +280,48 @@ type ProfilesConfig struct {
 	ReloadInterval  time.Duration `yaml:"reload_interval" json:"reload_interval"`
 	ValidateOnLoad  bool          `yaml:"validate_on_load" json:"validate_on_load"`
 	CacheEnabled    bool          `yaml:"cache_enabled" json:"cache_enabled"`
+	Source          ProfileSource `yaml:"source" json:"source"`
+	Routing         RoutingConfig `yaml:"routing" json:"routing"`
+	// PromptTemplate is the text/template prompt of profiles that neither
+	// set nor inherit a prompt_template; empty keeps the built-in prompt
+	PromptTemplate string `yaml:"prompt_template" json:"prompt_template"`
+}
+
+// RoutingConfig restricts profiles to the emails they apply to, so routed
+// batches skip model calls that cannot matter. A profile named by one or
+// more routes runs only on emails matching at least one of them; profiles
+// no route names run on every email.
+type RoutingConfig struct {
+	Routes []Route `yaml:"routes,omitempty" json:"routes,omitempty"`
+}
+
+// Route selects profiles for emails carrying any of Labels (Gmail label
+// IDs, compared case-insensitively) and satisfying When, an expression over
+// email. At least one of Labels and When must be set.
+type Route struct {
+	Name     string   `yaml:"name" json:"name"`
+	Labels   []string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	When     string   `yaml:"when,omitempty" json:"when,omitempty"`
+	Profiles []string `yaml:"profiles" json:"profiles"`
+}
+
+// Profile source types
+const (
+	ProfileSourceDirectory = "directory"
+	ProfileSourceHTTP      = "http"
+	ProfileSourceGit       = "git"
+)
+
+// ProfileSource configures where profiles are loaded from. Remote sources
+// are fetched read-only into CacheDir, and the last good copy is used when a
+// fetch fails.
+type ProfileSource struct {
+	Type     string        `yaml:"type" json:"type"`
+	URL      string        `yaml:"url,omitempty" json:"url,omitempty"`
+	Ref      string        `yaml:"ref,omitempty" json:"ref,omitempty"`
+	Path     string        `yaml:"path,omitempty" json:"path,omitempty"`
+	CacheDir string        `yaml:"cache_dir,omitempty" json:"cache_dir,omitempty"`
+	Timeout  time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
 }
 
 // AuditConfig contains audit logging configuration
@@ -69,15 +333,67 @@ type AuditConfig struct {
 	RotationPeriod  time.Duration `yaml:"rotation_period" json:"rotation_period"`
 	IntegrityCheck  bool          `yaml:"integrity_check" json:"integrity_check"`
 	EncryptionKey   string        `yaml:"encryption_key" json:"encryption_key"`
+	SigningKeyFile  string        `yaml:"signing_key_file,omitempty" json:"signing_key_file,omitempty"`
+	ChainID         string        `yaml:"chain_id" json:"chain_id"`
+	NodeID          string        `yaml:"node_id,omitempty" json:"node_id,omitempty"`
+	GenesisMetadata map[string]string `yaml:"genesis_metadata,omitempty" json:"genesis_metadata,omitempty"`
+	BufferedWrites  bool          `yaml:"buffered_writes" json:"buffered_writes"`
+	FlushInterval   time.Duration `yaml:"flush_interval" json:"flush_interval"`
+	FlushEntries    int           `yaml:"flush_entries" json:"flush_entries"`
+	Anomaly         AnomalyConfig `yaml:"anomaly" json:"anomaly"`
+}
+
+// AnomalyConfig sets the thresholds over a sliding window of audit events
+// beyond which an anomaly is reported. Zero thresholds are not checked.
+type AnomalyConfig struct {
+	Enabled bool          `yaml:"enabled" json:"enabled"`
+	Window  time.Duration `yaml:"window" json:"window"`
+	// Cooldown is the minimum time between two reports of the same anomaly
+	Cooldown time.Duration `yaml:"cooldown" json:"cooldown"`
+	// ActionCounts is the most classifications to each action per window
+	ActionCounts map[string]int `yaml:"action_counts,omitempty" json:"action_counts,omitempty"`
+	// ActionShares is the largest fraction of the window's classifications
+	// that may go to each action, checked once the window holds at least
+	// MinClassifications
+	ActionShares       map[string]float64 `yaml:"action_shares,omitempty" json:"action_shares,omitempty"`
+	MinClassifications int                `yaml:"min_classifications" json:"min_classifications"`
+	// SecurityViolations is the most security violations per window
+	SecurityViolations int           `yaml:"security_violations" json:"security_violations"`
+	WebhookURL         string        `yaml:"webhook_url,omitempty" json:"webhook_url,omitempty"`
+	WebhookTimeout     time.Duration `yaml:"webhook_timeout" json:"webhook_timeout"`
 }
 
 // SecurityConfig contains security-related settings
 type SecurityConfig struct {
-	EncryptionKey     string `yaml:"encryption_key" json:"encryption_key"`
-	TokenEncryption   bool   `yaml:"token_encryption" json:"token_encryption"`
-	InputSanitization bool   `yaml:"input_sanitization" json:"input_sanitization"`
-	MaxEmailSize      int64  `yaml:"max_email_size" json:"max_email_size"`
-	MaxBatchSize      int    `yaml:"max_batch_size" json:"max_batch_size"`
+	EncryptionKey     string          `yaml:"encryption_key" json:"encryption_key"`
+	TokenEncryption   bool            `yaml:"token_encryption" json:"token_encryption"`
+	InputSanitization bool            `yaml:"input_sanitization" json:"input_sanitization"`
+	MaxEmailSize      int64           `yaml:"max_email_size" json:"max_email_size"`
+	MaxBatchSize      int             `yaml:"max_batch_size" json:"max_batch_size"`
+	MaxBatchBytes     int64           `yaml:"max_batch_bytes" json:"max_batch_bytes"`
+	Redaction         RedactionConfig `yaml:"redaction" json:"redaction"`
+}
+
+// RedactionConfig controls replacing personal data in emails with typed
+// placeholders, such as [EMAIL_1], before they reach the model and the
+// audit log
+type RedactionConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Builtin selects the built-in patterns among email, phone, ssn and
+	// card; empty enables all of them
+	Builtin []string `yaml:"builtin" json:"builtin"`
+	// Patterns are additional regular expressions, replaced with
+	// placeholders named after them
+	Patterns []RedactionPattern `yaml:"patterns" json:"patterns"`
+	// Restore puts the original values back into the reasoning returned
+	// for the request; the audit log keeps the placeholders
+	Restore bool `yaml:"restore" json:"restore"`
+}
+
+// RedactionPattern is a named regular expression of personal data
+type RedactionPattern struct {
+	Name    string `yaml:"name" json:"name"`
+	Pattern string `yaml:"pattern" json:"pattern"`
 }
 
 // ServerConfig contains server configuration
@@ -87,8 +403,133 @@ type ServerConfig struct {
 	WriteTimeout    time.Duration `yaml:"write_timeout" json:"write_timeout"`
 	MaxHeaderBytes  int           `yaml:"max_header_bytes" json:"max_header_bytes"`
 	EnableProfiling bool          `yaml:"enable_profiling" json:"enable_profiling"`
+	BatchWorkers    int           `yaml:"batch_workers" json:"batch_workers"`
+	// BatchBudget caps the time a batch request may take; once it is spent
+	// no more emails are classified and the partial results are returned.
+	// Zero leaves batches unbounded.
+	BatchBudget time.Duration    `yaml:"batch_budget" json:"batch_budget"`
+	Dedup       DedupConfig      `yaml:"dedup" json:"dedup"`
+	DeadLetter  DeadLetterConfig `yaml:"dead_letter" json:"dead_letter"`
+	// BatchProgressEvery logs the progress of a batch request each time
+	// that many more emails complete, and once the last one does. Zero logs
+	// none.
+	BatchProgressEvery int `yaml:"batch_progress_every" json:"batch_progress_every"`
+	// ConfidenceBuckets are the increasing bounds between the buckets of
+	// the batch summary's confidence histogram; empty splits it in tenths
+	ConfidenceBuckets []float64 `yaml:"confidence_buckets" json:"confidence_buckets"`
+	// ClassifyByID serves GET /v1/classify/{messageID}, which fetches the
+	// email from the mail provider, so it needs mail credentials
+	ClassifyByID bool `yaml:"classify_by_id" json:"classify_by_id"`
+	// ApplyActions applies the decision reached for each email of a batch
+	// to the mailbox, or plans it in a dry run batch, so it needs mail
+	// credentials. Batches only classify otherwise.
+	ApplyActions bool `yaml:"apply_actions" json:"apply_actions"`
+}
+
+// ConcurrencyConfig caps the operations the process runs at once on each
+// backend, across every batch and worker. Zero leaves a backend uncapped.
+type ConcurrencyConfig struct {
+	// LLM caps classifications and embeddings running on the LLM backend
+	LLM int `yaml:"llm" json:"llm"`
+	// Gmail caps requests in flight to the Gmail API
+	Gmail int `yaml:"gmail" json:"gmail"`
+}
+
+// DeadLetterConfig controls where emails whose classification failed are
+// kept for inspection and requeueing
+type DeadLetterConfig struct {
+	Enabled   bool   `yaml:"enabled" json:"enabled"`
+	Directory string `yaml:"directory" json:"directory"`
+}
+
+// DedupConfig controls grouping of near-identical emails in a batch so that
+// each group is classified once
+type DedupConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// SimilarityThreshold is the minimum Jaccard similarity of two emails'
+	// normalized content for them to group; 1 groups exact copies only
+	SimilarityThreshold float64 `yaml:"similarity_threshold" json:"similarity_threshold"`
+}
+
+// ActionsConfig contains the mapping from classification actions to Gmail changes
+type ActionsConfig struct {
+	LabelMapping map[string]LabelChange `yaml:"label_mapping" json:"label_mapping"`
+	// MinConfidence is the confidence an action needs to be applied. A
+	// result below its action's threshold is downgraded to
+	// BelowThresholdAction; actions without a threshold always apply.
+	MinConfidence map[string]float64 `yaml:"min_confidence,omitempty" json:"min_confidence,omitempty"`
+	// BelowThresholdAction is applied instead of a downgraded action,
+	// review by default; use a no-op action such as none to leave the email
+	// untouched
+	BelowThresholdAction string `yaml:"below_threshold_action,omitempty" json:"below_threshold_action,omitempty"`
+	// SafeMode guarantees no email is ever trashed or deleted: an action
+	// with a message operation archives the email and adds SafeModeLabel
+	// instead
+	SafeMode bool `yaml:"safe_mode" json:"safe_mode"`
+	// SafeModeLabel marks the emails safe mode kept from being removed,
+	// MailSentinel/WouldDelete by default
+	SafeModeLabel string `yaml:"safe_mode_label,omitempty" json:"safe_mode_label,omitempty"`
+	// Reconcile removes the owned labels an email carries that its new
+	// classification does not add, as after a changed profile classified it
+	// again, and skips adding the labels it already carries
+	Reconcile bool `yaml:"reconcile" json:"reconcile"`
+	// OwnedLabels are the labels reconciling may remove besides the user
+	// labels the audit log shows the executor applied to the email. Other
+	// labels, such as those users apply, are never removed.
+	OwnedLabels []string `yaml:"owned_labels,omitempty" json:"owned_labels,omitempty"`
+	// CursorFile records the progress of applying batch decisions, so that
+	// a batch sent again after a crash under the same correlation ID skips
+	// the emails already acted on. Empty disables it.
+	CursorFile string `yaml:"cursor_file,omitempty" json:"cursor_file,omitempty"`
+}
+
+// DefaultBelowThresholdAction is the action results below their action's
+// minimum confidence are downgraded to
+const DefaultBelowThresholdAction = "review"
+
+// DowngradeAction returns the action results below their action's minimum
+// confidence are downgraded to
+func (a *ActionsConfig) DowngradeAction() string {
+	if a.BelowThresholdAction != "" {
+		return a.BelowThresholdAction
+	}
+	return DefaultBelowThresholdAction
+}
+
+// DefaultSafeModeLabel is the label safe mode adds to the emails an action
+// would have trashed or deleted
+const DefaultSafeModeLabel = "MailSentinel/WouldDelete"
+
+// InterceptLabel returns the label safe mode adds to the emails an action
+// would have trashed or deleted
+func (a *ActionsConfig) InterceptLabel() string {
+	if a.SafeModeLabel != "" {
+		return a.SafeModeLabel
+	}
+	return DefaultSafeModeLabel
 }
 
+// LabelChange describes the labels added to and removed from an email for an
+// action, and the message operation applied after them, if any. System names
+// a Gmail system action, such as report_spam, whose labels are changed along
+// with Add and Remove.
+type LabelChange struct {
+	System    string   `yaml:"system,omitempty" json:"system,omitempty"`
+	Add       []string `yaml:"add,omitempty" json:"add,omitempty"`
+	Remove    []string `yaml:"remove,omitempty" json:"remove,omitempty"`
+	Operation string   `yaml:"operation,omitempty" json:"operation,omitempty"`
+}
+
+// Message operations of a label change
+const (
+	// OperationTrash moves the message to the trash, where Gmail keeps it
+	// for 30 days
+	OperationTrash = "trash"
+	// OperationDelete deletes the message permanently; it requires
+	// gmail.allow_permanent_delete
+	OperationDelete = "delete"
+)
+
 // DefaultConfig returns a configuration with sensible defaults
 func DefaultConfig() *Config {
 	return &Config{
@@ -100,6 +541,18 @@ func DefaultConfig() *Config {
 			RetryAttempts: 3,
 			RetryDelay:    1 * time.Second,
 			TokenFile:     "data/gmail_token.json",
+			SyncStateFile: "data/gmail_sync.json",
+		},
+		Mail: MailConfig{
+			Provider: MailProviderGmail,
+			IMAP: IMAPConfig{
+				TLS:           IMAPTLSImplicit,
+				Mailbox:       "INBOX",
+				ArchiveFolder: "Archive",
+				SpamFolder:    "Junk",
+				TrashFolder:   "Trash",
+				Timeout:       30 * time.Second,
+			},
 		},
 		Ollama: OllamaConfig{
 			BaseURL:           "http://127.0.0.1:11434",
@@ -108,6 +561,8 @@ func DefaultConfig() *Config {
 			MaxRetries:        3,
 			RequestTimeout:    30 * time.Second,
 			HealthCheckPeriod: 60 * time.Second,
+			DeterministicSeed: 42,
+			MaxPromptBytes:    1024 * 1024, // 1MB
 			CircuitBreaker: CircuitBreakerConfig{
 				MaxRequests:  10,
 				Interval:     60 * time.Second,
@@ -115,12 +570,48 @@ func DefaultConfig() *Config {
 				ReadyToTrip:  5,
 			},
 		},
+		LLM: LLMConfig{
+			Backend: LLMBackendOllama,
+			Cache: CacheConfig{
+				MaxEntries: 10000,
+			},
+			ThreadContext: ThreadContextConfig{
+				MaxTokens: 1000,
+			},
+			RetryQueue: RetryQueueConfig{
+				MaxSize: 100,
+				MaxWait: 2 * time.Minute,
+			},
+			FailAction: FailActionConfig{
+				Action: DefaultFailAction,
+			},
+			EmptyResponse: EmptyResponseConfig{
+				Retry:           true,
+				TemperatureStep: 0.2,
+			},
+			OpenAI: OpenAIConfig{
+				BaseURL:           "http://127.0.0.1:8000",
+				RequestTimeout:    30 * time.Second,
+				DeterministicSeed: 42,
+				CircuitBreaker: CircuitBreakerConfig{
+					MaxRequests: 10,
+					Interval:    60 * time.Second,
+					Timeout:     60 * time.Second,
+					ReadyToTrip: 5,
+				},
+			},
+		},
 		Profiles: ProfilesConfig{
 			Directory:       "profiles",
 			ResolverConfig:  "profiles/resolver.yaml",
 			ReloadInterval:  5 * time.Minute,
 			ValidateOnLoad:  true,
 			CacheEnabled:    true,
+			Source: ProfileSource{
+				Type:     ProfileSourceDirectory,
+				CacheDir: "cache/profiles",
+				Timeout:  30 * time.Second,
+			},
 		},
 		Audit: AuditConfig{
 			Enabled:         true,
@@ -129,12 +620,22 @@ func DefaultConfig() *Config {
 			MaxFiles:        10,
 			RotationPeriod:  24 * time.Hour,
 			IntegrityCheck:  true,
+			ChainID:         "mailsentinel",
+			FlushInterval:   time.Second,
+			FlushEntries:    100,
+			Anomaly: AnomalyConfig{
+				Window:             10 * time.Minute,
+				Cooldown:           10 * time.Minute,
+				MinClassifications: 20,
+				WebhookTimeout:     5 * time.Second,
+			},
 		},
 		Security: SecurityConfig{
 			TokenEncryption:   true,
 			InputSanitization: true,
 			MaxEmailSize:      10 * 1024 * 1024, // 10MB
 			MaxBatchSize:      1000,
+			MaxBatchBytes:     64 * 1024 * 1024, // 64MB
 		},
 		Server: ServerConfig{
 			Port:            8080,
@@ -142,38 +643,97 @@ func DefaultConfig() *Config {
 			WriteTimeout:    15 * time.Second,
 			MaxHeaderBytes:  1 << 20, // 1MB
 			EnableProfiling: false,
+			BatchWorkers:    4,
+			Dedup: DedupConfig{
+				SimilarityThreshold: 0.9,
+			},
+			DeadLetter: DeadLetterConfig{
+				Directory: "data/dead_letters",
+			},
+		},
+		Concurrency: ConcurrencyConfig{
+			LLM:   4,
+			Gmail: 10,
+		},
+		Actions: ActionsConfig{
+			LabelMapping: map[string]LabelChange{
+				"archive":    {Remove: []string{"INBOX"}},
+				"delete":     {Operation: OperationTrash},
+				"star":       {Add: []string{"STARRED"}},
+				"prioritize": {Add: []string{"IMPORTANT", "STARRED"}},
+				"keep":       {},
+				"none":       {},
+				"review":     {Add: []string{"MailSentinel/Review"}},
+				"spam":       {System: "report_spam"},
+			},
+		},
+		Logging: LoggingConfig{
+			Format: "text",
+			Level:  "info",
+		},
+		Notifications: NotificationsConfig{
+			Timeout:    5 * time.Second,
+			Retries:    2,
+			RetryDelay: time.Second,
 		},
 	}
 }
 
-// LoadConfig loads configuration from a YAML file
+// LoadConfig loads configuration from a YAML file. Values are layered with
+// increasing precedence: defaults, then the file, then MAILSENTINEL_
+// environment overrides (see ApplyEnvOverrides). A missing file leaves the
+// defaults in place.
 func LoadConfig(path string) (*Config, error) {
 	config := DefaultConfig()
 	
-	if path == "" {
-		return config, nil
+	if path != "" {
+		if err := config.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	
+	if err := config.ApplyEnvOverrides(); err != nil {
+		return nil, fmt.Errorf("failed to apply environment overrides: %w", err)
 	}
 	
+	return config, nil
+}
+
+// loadFile merges a YAML file into the configuration
+func (c *Config) loadFile(path string) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return config, nil // Use defaults if file doesn't exist
+			return nil // Use defaults if file doesn't exist
 		}
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return fmt.Errorf("failed to read config file: %w", err)
 	}
 	
 	// Expand environment variables in the YAML content
 	expandedData := os.ExpandEnv(string(data))
 	
-	if err := yaml.Unmarshal([]byte(expandedData), config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	if err := yaml.Unmarshal([]byte(expandedData), c); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
 	}
 	
-	return config, nil
+	return nil
 }
 
-// SaveConfig saves configuration to a YAML file
-func (c *Config) SaveConfig(path string) error {
+// SaveConfig saves configuration to a YAML file. The file is readable only
+// by its owner since it may contain secrets; pass RefusePlaintextSecrets to
+// fail rather than write them.
+func (c *Config) SaveConfig(path string, options ...SaveOption) error {
+	var opts saveOptions
+	for _, option := range options {
+		option(&opts)
+	}
+	
+	if opts.refusePlaintextSecrets {
+		if secrets := c.plaintextSecrets(); len(secrets) > 0 {
+			return fmt.Errorf("refusing to save plaintext secrets: %s", strings.Join(secrets, ", "))
+		}
+	}
+	
 	// Ensure directory exists
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return fmt.Errorf("failed to create config directory: %w", err)
@@ -184,34 +744,346 @@ func (c *Config) SaveConfig(path string) error {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 	
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	if err := os.WriteFile(path, data, 0600); err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
+	// WriteFile keeps the mode of a file that already exists
+	if err := os.Chmod(path, 0600); err != nil {
+		return fmt.Errorf("failed to restrict config file permissions: %w", err)
+	}
 	
 	return nil
 }
 
-// Validate validates the configuration
+// Validate validates the configuration and returns every problem found,
+// joined with errors.Join
 func (c *Config) Validate() error {
-	if c.Gmail.ClientID == "" {
-		return fmt.Errorf("gmail.client_id is required")
+	var errs []error
+	addf := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
 	}
 	
-	if c.Gmail.ClientSecret == "" {
-		return fmt.Errorf("gmail.client_secret is required")
+	switch c.Mail.Provider {
+	case "", MailProviderGmail:
+		if c.Gmail.ClientID == "" {
+			addf("gmail.client_id is required")
+		}
+		if c.Gmail.ClientSecret == "" {
+			addf("gmail.client_secret is required")
+		}
+	case MailProviderIMAP:
+		if c.Mail.IMAP.Address == "" {
+			addf("mail.imap.address is required for the imap provider")
+		}
+		if c.Mail.IMAP.Username == "" {
+			addf("mail.imap.username is required for the imap provider")
+		}
+		switch c.Mail.IMAP.TLS {
+		case "", IMAPTLSImplicit, IMAPTLSStartTLS, IMAPTLSNone:
+		default:
+			addf("mail.imap.tls must be %q, %q or %q, got %q", IMAPTLSImplicit, IMAPTLSStartTLS, IMAPTLSNone, c.Mail.IMAP.TLS)
+		}
+	default:
+		addf("unknown mail.provider %q", c.Mail.Provider)
 	}
 	
-	if c.Ollama.BaseURL == "" {
-		return fmt.Errorf("ollama.base_url is required")
+	if c.Gmail.BatchSize <= 0 {
+		addf("gmail.batch_size must be positive")
 	}
 	
-	if c.Ollama.DefaultModel == "" {
-		return fmt.Errorf("ollama.default_model is required")
+	switch c.LLM.Backend {
+	case "", LLMBackendOllama:
+		if c.Ollama.BaseURL == "" {
+			addf("ollama.base_url is required")
+		}
+		if c.Ollama.DefaultModel == "" {
+			addf("ollama.default_model is required")
+		}
+		if c.Ollama.CircuitBreaker.ReadyToTrip <= 0 {
+			addf("ollama.circuit_breaker.ready_to_trip must be positive")
+		}
+		params := c.Ollama.DefaultModelParams
+		if params.Temperature != nil && (*params.Temperature < 0 || *params.Temperature > 2) {
+			addf("ollama.default_model_params.temperature must be between 0 and 2")
+		}
+		if params.MaxTokens < 0 || params.TimeoutSeconds < 0 || params.TopK < 0 {
+			addf("ollama.default_model_params max_tokens, timeout_seconds and top_k must not be negative")
+		}
+		if params.TopP < 0 || params.TopP > 1 {
+			addf("ollama.default_model_params.top_p must be between 0 and 1")
+		}
+		if params.KeepAlive != "" {
+			if _, err := time.ParseDuration(params.KeepAlive); err != nil {
+				addf("ollama.default_model_params.keep_alive: %v", err)
+			}
+		}
+		if c.Ollama.MaxPromptBytes < 0 {
+			addf("ollama.max_prompt_bytes must not be negative")
+		}
+	case LLMBackendOpenAI:
+		if c.LLM.OpenAI.BaseURL == "" {
+			addf("llm.openai.base_url is required for the openai backend")
+		}
+		if c.LLM.OpenAI.DefaultModel == "" {
+			addf("llm.openai.default_model is required for the openai backend")
+		}
+		if c.LLM.OpenAI.CircuitBreaker.ReadyToTrip <= 0 {
+			addf("llm.openai.circuit_breaker.ready_to_trip must be positive")
+		}
+	default:
+		addf("unknown llm.backend %q", c.LLM.Backend)
+	}
+	if c.LLM.Cache.MaxEntries < 0 {
+		addf("llm.cache.max_entries must not be negative, got %d", c.LLM.Cache.MaxEntries)
+	}
+	if c.LLM.ThreadContext.Enabled && c.LLM.ThreadContext.MaxTokens <= 0 {
+		addf("llm.thread_context.max_tokens must be positive, got %d", c.LLM.ThreadContext.MaxTokens)
+	}
+	if c.LLM.ThreadContext.MaxMessages < 0 {
+		addf("llm.thread_context.max_messages must not be negative, got %d", c.LLM.ThreadContext.MaxMessages)
+	}
+	if c.LLM.RetryQueue.Enabled {
+		if c.LLM.RetryQueue.MaxSize <= 0 {
+			addf("llm.retry_queue.max_size must be positive, got %d", c.LLM.RetryQueue.MaxSize)
+		}
+		if c.LLM.RetryQueue.MaxWait <= 0 {
+			addf("llm.retry_queue.max_wait must be positive, got %s", c.LLM.RetryQueue.MaxWait)
+		}
+	}
+	if c.LLM.EmptyResponse.Retry && (c.LLM.EmptyResponse.TemperatureStep <= 0 || c.LLM.EmptyResponse.TemperatureStep > MaxRetryTemperature) {
+		addf("llm.empty_response.temperature_step must be between 0 and %g, got %g", MaxRetryTemperature, c.LLM.EmptyResponse.TemperatureStep)
 	}
 	
-	if c.Profiles.Directory == "" {
-		return fmt.Errorf("profiles.directory is required")
+	for i, bound := range c.Server.ConfidenceBuckets {
+		if bound <= 0 || bound >= 1 || i > 0 && bound <= c.Server.ConfidenceBuckets[i-1] {
+			addf("server.confidence_buckets must be increasing and between 0 and 1, got %v", c.Server.ConfidenceBuckets)
+			break
+		}
+	}
+	if c.Concurrency.LLM < 0 || c.Concurrency.Gmail < 0 {
+		addf("concurrency.llm and concurrency.gmail must not be negative")
 	}
 	
-	return nil
+	switch c.Logging.Format {
+	case "", "text", "json":
+	default:
+		addf("unknown logging.format %q", c.Logging.Format)
+	}
+	if c.Logging.Level != "" {
+		if _, err := logrus.ParseLevel(c.Logging.Level); err != nil {
+			addf("logging.level: %w", err)
+		}
+	}
+	
+	switch c.Profiles.Source.Type {
+	case "", ProfileSourceDirectory:
+		if c.Profiles.Directory == "" {
+			addf("profiles.directory is required")
+		}
+		// Remote sources fetch the resolver configuration at runtime
+		if c.Profiles.ResolverConfig != "" {
+			if _, err := os.Stat(c.Profiles.ResolverConfig); err != nil {
+				addf("profiles.resolver_config %q cannot be read: %w", c.Profiles.ResolverConfig, err)
+			}
+		}
+	case ProfileSourceHTTP, ProfileSourceGit:
+		if c.Profiles.Source.URL == "" {
+			addf("profiles.source.url is required for %s sources", c.Profiles.Source.Type)
+		}
+		if c.Profiles.Source.CacheDir == "" {
+			addf("profiles.source.cache_dir is required for %s sources", c.Profiles.Source.Type)
+		}
+	default:
+		addf("unknown profiles.source.type %q", c.Profiles.Source.Type)
+	}
+	for i, route := range c.Profiles.Routing.Routes {
+		if len(route.Profiles) == 0 {
+			addf("profiles.routing.routes[%d].profiles must not be empty", i)
+		}
+		if len(route.Labels) == 0 && route.When == "" {
+			addf("profiles.routing.routes[%d] needs labels or when", i)
+		}
+	}
+	
+	if c.Audit.Enabled {
+		if c.Audit.Directory == "" {
+			addf("audit.directory is required when audit is enabled")
+		} else if err := checkWritableDir(c.Audit.Directory); err != nil {
+			addf("audit.directory %q is not writable: %w", c.Audit.Directory, err)
+		}
+	}
+	if c.Audit.Anomaly.Enabled {
+		if !c.Audit.Enabled {
+			addf("audit.anomaly requires audit to be enabled")
+		}
+		if c.Audit.Anomaly.Window <= 0 {
+			addf("audit.anomaly.window must be positive")
+		}
+		for action, count := range c.Audit.Anomaly.ActionCounts {
+			if count <= 0 {
+				addf("audit.anomaly.action_counts.%s must be positive, got %d", action, count)
+			}
+		}
+		for action, share := range c.Audit.Anomaly.ActionShares {
+			if share <= 0 || share > 1 {
+				addf("audit.anomaly.action_shares.%s must be in (0, 1], got %g", action, share)
+			}
+		}
+		if c.Audit.Anomaly.SecurityViolations < 0 {
+			addf("audit.anomaly.security_violations must not be negative, got %d", c.Audit.Anomaly.SecurityViolations)
+		}
+		if c.Audit.Anomaly.WebhookURL != "" {
+			if u, err := url.Parse(c.Audit.Anomaly.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				addf("audit.anomaly.webhook_url must be an http or https URL, got %q", c.Audit.Anomaly.WebhookURL)
+			}
+		}
+	}
+	
+	if c.Notifications.Enabled {
+		if c.Notifications.Retries < 0 {
+			addf("notifications.retries must not be negative, got %d", c.Notifications.Retries)
+		}
+		for i, rule := range c.Notifications.Rules {
+			if u, err := url.Parse(rule.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				addf("notifications.rules[%d].url must be an http or https URL", i)
+			}
+			if rule.MinConfidence < 0 || rule.MinConfidence > 1 {
+				addf("notifications.rules[%d].min_confidence must be between 0 and 1, got %g", i, rule.MinConfidence)
+			}
+		}
+	}
+	
+	actions := make([]string, 0, len(c.Actions.LabelMapping))
+	for action := range c.Actions.LabelMapping {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	for _, action := range actions {
+		switch operation := c.Actions.LabelMapping[action].Operation; operation {
+		case "", OperationTrash:
+		case OperationDelete:
+			if c.Mail.Provider == MailProviderIMAP && !c.Mail.IMAP.AllowPermanentDelete {
+				addf("actions.label_mapping.%s permanently deletes messages, which requires mail.imap.allow_permanent_delete", action)
+			} else if c.Mail.Provider != MailProviderIMAP && !c.Gmail.AllowPermanentDelete {
+				addf("actions.label_mapping.%s permanently deletes messages, which requires gmail.allow_permanent_delete", action)
+			}
+		default:
+			addf("actions.label_mapping.%s.operation must be %q or %q, got %q", action, OperationTrash, OperationDelete, operation)
+		}
+	}
+	thresholds := make([]string, 0, len(c.Actions.MinConfidence))
+	for action := range c.Actions.MinConfidence {
+		thresholds = append(thresholds, action)
+	}
+	sort.Strings(thresholds)
+	for _, action := range thresholds {
+		if threshold := c.Actions.MinConfidence[action]; threshold < 0 || threshold > 1 {
+			addf("actions.min_confidence.%s must be between 0 and 1, got %g", action, threshold)
+		}
+		if _, mapped := c.Actions.LabelMapping[action]; !mapped {
+			addf("actions.min_confidence.%s has no label mapping", action)
+		}
+	}
+	if len(thresholds) > 0 {
+		downgrade := c.Actions.DowngradeAction()
+		if _, mapped := c.Actions.LabelMapping[downgrade]; !mapped {
+			addf("actions.below_threshold_action %q has no label mapping", downgrade)
+		}
+		if _, gated := c.Actions.MinConfidence[downgrade]; gated {
+			addf("actions.below_threshold_action %q must not have a minimum confidence", downgrade)
+		}
+	}
+	if failAction := c.LLM.FailAction.ActionFor(""); failAction != "" {
+		if _, mapped := c.Actions.LabelMapping[failAction]; !mapped {
+			addf("llm.fail_action.action %q has no label mapping", failAction)
+		}
+	}
+	if c.Gmail.AllowPermanentDelete && !containsScope(c.Gmail.Scopes, GmailScopeFull) {
+		addf("gmail.allow_permanent_delete requires the %s scope", GmailScopeFull)
+	}
+	
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		addf("server.port must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	if c.Server.Dedup.Enabled && (c.Server.Dedup.SimilarityThreshold <= 0 || c.Server.Dedup.SimilarityThreshold > 1) {
+		addf("server.dedup.similarity_threshold must be in (0, 1], got %g", c.Server.Dedup.SimilarityThreshold)
+	}
+	if c.Server.DeadLetter.Enabled && c.Server.DeadLetter.Directory == "" {
+		addf("server.dead_letter.directory is required when the dead-letter queue is enabled")
+	}
+	
+	durations := []struct {
+		field string
+		value time.Duration
+	}{
+		{"gmail.timeout", c.Gmail.Timeout},
+		{"gmail.retry_delay", c.Gmail.RetryDelay},
+		{"mail.imap.timeout", c.Mail.IMAP.Timeout},
+		{"ollama.timeout", c.Ollama.Timeout},
+		{"ollama.request_timeout", c.Ollama.RequestTimeout},
+		{"ollama.health_check_period", c.Ollama.HealthCheckPeriod},
+		{"ollama.circuit_breaker.interval", c.Ollama.CircuitBreaker.Interval},
+		{"ollama.circuit_breaker.timeout", c.Ollama.CircuitBreaker.Timeout},
+		{"llm.openai.request_timeout", c.LLM.OpenAI.RequestTimeout},
+		{"llm.openai.circuit_breaker.interval", c.LLM.OpenAI.CircuitBreaker.Interval},
+		{"llm.openai.circuit_breaker.timeout", c.LLM.OpenAI.CircuitBreaker.Timeout},
+		{"profiles.reload_interval", c.Profiles.ReloadInterval},
+		{"profiles.source.timeout", c.Profiles.Source.Timeout},
+		{"audit.rotation_period", c.Audit.RotationPeriod},
+		{"audit.flush_interval", c.Audit.FlushInterval},
+		{"audit.anomaly.cooldown", c.Audit.Anomaly.Cooldown},
+		{"audit.anomaly.webhook_timeout", c.Audit.Anomaly.WebhookTimeout},
+		{"notifications.timeout", c.Notifications.Timeout},
+		{"notifications.retry_delay", c.Notifications.RetryDelay},
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.batch_budget", c.Server.BatchBudget},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			addf("%s must not be negative, got %s", d.field, d.value)
+		}
+	}
+	
+	return errors.Join(errs...)
+}
+
+// checkWritableDir reports whether files can be created in dir, or in its
+// nearest existing parent when dir does not exist yet. Nothing is left
+// behind on disk.
+func checkWritableDir(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+	
+	f, err := os.CreateTemp(dir, ".mailsentinel-write-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// containsScope reports whether scopes includes scope
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
 }
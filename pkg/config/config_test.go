@@ -1,13 +1,17 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -37,6 +41,10 @@ func TestDefaultConfig(t *testing.T) {
 	assert.True(t, cfg.Audit.Enabled)
 	assert.True(t, cfg.Audit.IntegrityCheck)
 	assert.Equal(t, int64(100*1024*1024), cfg.Audit.MaxFileSize)
+
+	// Test concurrency defaults
+	assert.Equal(t, 4, cfg.Concurrency.LLM)
+	assert.Equal(t, 10, cfg.Concurrency.Gmail)
 }
 
 func TestConfigValidation(t *testing.T) {
@@ -48,13 +56,13 @@ func TestConfigValidation(t *testing.T) {
 	}{
 		{
 			name:   "valid_config",
-			config: validTestConfig(),
+			config: validTestConfig(t),
 			wantErr: false,
 		},
 		{
 			name: "missing_gmail_client_id",
 			config: func() *Config {
-				cfg := validTestConfig()
+				cfg := validTestConfig(t)
 				cfg.Gmail.ClientID = ""
 				return cfg
 			}(),
@@ -64,17 +72,82 @@ func TestConfigValidation(t *testing.T) {
 		{
 			name: "missing_gmail_client_secret",
 			config: func() *Config {
-				cfg := validTestConfig()
+				cfg := validTestConfig(t)
 				cfg.Gmail.ClientSecret = ""
 				return cfg
 			}(),
 			wantErr: true,
 			errMsg:  "gmail.client_secret is required",
 		},
+		{
+			name: "imap_provider_without_gmail_credentials",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Gmail.ClientID = ""
+				cfg.Gmail.ClientSecret = ""
+				cfg.Mail.Provider = MailProviderIMAP
+				cfg.Mail.IMAP.Address = "imap.example.com:993"
+				cfg.Mail.IMAP.Username = "me@example.com"
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "imap_provider_without_address",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Mail.Provider = MailProviderIMAP
+				cfg.Mail.IMAP.Username = "me@example.com"
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "mail.imap.address is required for the imap provider",
+		},
+		{
+			name: "unknown_mail_provider",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Mail.Provider = "exchange"
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  `unknown mail.provider "exchange"`,
+		},
+		{
+			name: "min_confidence_out_of_range",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Actions.MinConfidence = map[string]float64{"delete": 1.5}
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "actions.min_confidence.delete must be between 0 and 1",
+		},
+		{
+			name: "min_confidence_unmapped_action",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Actions.MinConfidence = map[string]float64{"teleport": 0.9}
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "actions.min_confidence.teleport has no label mapping",
+		},
+		{
+			name: "unmapped_below_threshold_action",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Actions.MinConfidence = map[string]float64{"delete": 0.95}
+				cfg.Actions.BelowThresholdAction = "quarantine"
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  `actions.below_threshold_action "quarantine" has no label mapping`,
+		},
 		{
 			name: "missing_ollama_base_url",
 			config: func() *Config {
-				cfg := validTestConfig()
+				cfg := validTestConfig(t)
 				cfg.Ollama.BaseURL = ""
 				return cfg
 			}(),
@@ -84,7 +157,7 @@ func TestConfigValidation(t *testing.T) {
 		{
 			name: "missing_ollama_model",
 			config: func() *Config {
-				cfg := validTestConfig()
+				cfg := validTestConfig(t)
 				cfg.Ollama.DefaultModel = ""
 				return cfg
 			}(),
@@ -94,13 +167,310 @@ func TestConfigValidation(t *testing.T) {
 		{
 			name: "missing_profiles_directory",
 			config: func() *Config {
-				cfg := validTestConfig()
+				cfg := validTestConfig(t)
 				cfg.Profiles.Directory = ""
 				return cfg
 			}(),
 			wantErr: true,
 			errMsg:  "profiles.directory is required",
 		},
+		{
+			name: "negative_timeout",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Ollama.RequestTimeout = -time.Second
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "ollama.request_timeout must not be negative",
+		},
+		{
+			name: "negative_max_prompt_bytes",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Ollama.MaxPromptBytes = -1
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "ollama.max_prompt_bytes must not be negative",
+		},
+		{
+			name: "zero_batch_size",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Gmail.BatchSize = 0
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "gmail.batch_size must be positive",
+		},
+		{
+			name: "zero_ready_to_trip",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Ollama.CircuitBreaker.ReadyToTrip = 0
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "ollama.circuit_breaker.ready_to_trip must be positive",
+		},
+		{
+			name: "unwritable_audit_directory",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				file := filepath.Join(t.TempDir(), "file")
+				require.NoError(t, os.WriteFile(file, nil, 0644))
+				cfg.Audit.Directory = filepath.Join(file, "audit")
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "is not writable",
+		},
+		{
+			name: "audit_directory_ignored_when_disabled",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Audit.Enabled = false
+				cfg.Audit.Directory = ""
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "port_out_of_range",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Server.Port = 70000
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "server.port must be between 1 and 65535, got 70000",
+		},
+		{
+			name: "dedup_threshold_out_of_range",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Server.Dedup.Enabled = true
+				cfg.Server.Dedup.SimilarityThreshold = 1.5
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "server.dedup.similarity_threshold must be in (0, 1], got 1.5",
+		},
+		{
+			name: "unknown_logging_format",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Logging.Format = "xml"
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  `unknown logging.format "xml"`,
+		},
+		{
+			name: "anomaly_share_out_of_range",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Audit.Anomaly.Enabled = true
+				cfg.Audit.Anomaly.ActionShares = map[string]float64{"delete": 1.5}
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "audit.anomaly.action_shares.delete must be in (0, 1], got 1.5",
+		},
+		{
+			name: "anomaly_invalid_webhook",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Audit.Anomaly.Enabled = true
+				cfg.Audit.Anomaly.WebhookURL = "hooks.example.com/alerts"
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  `audit.anomaly.webhook_url must be an http or https URL, got "hooks.example.com/alerts"`,
+		},
+		{
+			name: "notification_rule_without_url",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Notifications.Enabled = true
+				cfg.Notifications.Rules = []NotificationRule{{Name: "phishing", MinConfidence: 0.95}}
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "notifications.rules[0].url must be an http or https URL",
+		},
+		{
+			name: "permanent_delete_without_opt_in",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Actions.LabelMapping["purge"] = LabelChange{Operation: OperationDelete}
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "actions.label_mapping.purge permanently deletes messages, which requires gmail.allow_permanent_delete",
+		},
+		{
+			name: "permanent_delete_without_full_scope",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Gmail.AllowPermanentDelete = true
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "gmail.allow_permanent_delete requires the https://mail.google.com/ scope",
+		},
+		{
+			name: "unknown_label_operation",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Actions.LabelMapping["delete"] = LabelChange{Operation: "shred"}
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  `actions.label_mapping.delete.operation must be "trash" or "delete", got "shred"`,
+		},
+		{
+			name: "missing_resolver_config",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Profiles.ResolverConfig = filepath.Join(t.TempDir(), "resolver.yaml")
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "profiles.resolver_config",
+		},
+		{
+			name: "resolver_config_disabled",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Profiles.ResolverConfig = ""
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "unknown_llm_backend",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.LLM.Backend = "bedrock"
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  `unknown llm.backend "bedrock"`,
+		},
+		{
+			name: "openai_backend_without_ollama",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.LLM.Backend = LLMBackendOpenAI
+				cfg.LLM.OpenAI.DefaultModel = "Qwen/Qwen2.5-7B-Instruct"
+				cfg.Ollama.BaseURL = ""
+				return cfg
+			}(),
+			wantErr: false,
+		},
+		{
+			name: "openai_backend_missing_model",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.LLM.Backend = LLMBackendOpenAI
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "llm.openai.default_model is required for the openai backend",
+		},
+		{
+			name: "invalid_default_temperature",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				temperature := 2.5
+				cfg.Ollama.DefaultModelParams.Temperature = &temperature
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "ollama.default_model_params.temperature must be between 0 and 2",
+		},
+		{
+			name: "invalid_default_top_p",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Ollama.DefaultModelParams.TopP = 1.5
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "ollama.default_model_params.top_p must be between 0 and 1",
+		},
+		{
+			name: "thread_context_without_token_budget",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.LLM.ThreadContext = ThreadContextConfig{Enabled: true}
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "llm.thread_context.max_tokens must be positive",
+		},
+		{
+			name: "negative_thread_context_messages",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.LLM.ThreadContext.MaxMessages = -1
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "llm.thread_context.max_messages must not be negative",
+		},
+		{
+			name: "retry_queue_without_size",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.LLM.RetryQueue = RetryQueueConfig{Enabled: true, MaxWait: time.Minute}
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "llm.retry_queue.max_size must be positive",
+		},
+		{
+			name: "unmapped_fail_action",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.LLM.FailAction = FailActionConfig{Enabled: true, Action: "quarantine"}
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  `llm.fail_action.action "quarantine" has no label mapping`,
+		},
+		{
+			name: "empty_response_retry_without_step",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.LLM.EmptyResponse.TemperatureStep = 0
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "llm.empty_response.temperature_step must be between 0 and 1, got 0",
+		},
+		{
+			name: "unordered_confidence_buckets",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Server.ConfidenceBuckets = []float64{0.5, 0.3, 0.9}
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "server.confidence_buckets must be increasing and between 0 and 1",
+		},
+		{
+			name: "negative_concurrency",
+			config: func() *Config {
+				cfg := validTestConfig(t)
+				cfg.Concurrency.Gmail = -1
+				return cfg
+			}(),
+			wantErr: true,
+			errMsg:  "concurrency.llm and concurrency.gmail must not be negative",
+		},
 	}
 
 	for _, tt := range tests {
@@ -116,6 +486,23 @@ func TestConfigValidation(t *testing.T) {
 	}
 }
 
+func TestConfigValidationReportsEveryProblem(t *testing.T) {
+	cfg := validTestConfig(t)
+	cfg.Gmail.ClientID = ""
+	cfg.Gmail.Timeout = -time.Second
+	cfg.Server.Port = 0
+
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "gmail.client_id is required")
+	assert.Contains(t, err.Error(), "gmail.timeout must not be negative")
+	assert.Contains(t, err.Error(), "server.port must be between 1 and 65535")
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	require.True(t, ok, "Validate returns a joined error")
+	assert.Len(t, joined.Unwrap(), 3)
+}
+
 func TestLoadConfig(t *testing.T) {
 	// Test loading non-existent file (should return defaults)
 	cfg, err := LoadConfig("non-existent.yaml")
@@ -150,11 +537,59 @@ profiles:
 	assert.Equal(t, "test_profiles", cfg.Profiles.Directory)
 }
 
+func TestLoadConfigEnvOverrides(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	configContent := `
+ollama:
+  base_url: "http://file:11434"
+  timeout: 10s
+  circuit_breaker:
+    timeout: 20s
+gmail:
+  batch_size: 50
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(configContent), 0644))
+
+	t.Setenv("MAILSENTINEL_OLLAMA_TIMEOUT", "90s")
+	t.Setenv("MAILSENTINEL_OLLAMA_CIRCUIT_BREAKER_TIMEOUT", "2m")
+	t.Setenv("MAILSENTINEL_GMAIL_BATCH_SIZE", "25")
+	t.Setenv("MAILSENTINEL_GMAIL_SCOPES", "scope-a, scope-b")
+	t.Setenv("MAILSENTINEL_AUDIT_BUFFERED_WRITES", "true")
+	t.Setenv("MAILSENTINEL_OLLAMA_DEFAULT_MODEL_PARAMS_TEMPERATURE", "0.2")
+
+	cfg, err := LoadConfig(configPath)
+	require.NoError(t, err)
+
+	// Environment beats the file, the file beats defaults
+	assert.Equal(t, 90*time.Second, cfg.Ollama.Timeout)
+	assert.Equal(t, 2*time.Minute, cfg.Ollama.CircuitBreaker.Timeout)
+	assert.Equal(t, 25, cfg.Gmail.BatchSize)
+	assert.Equal(t, []string{"scope-a", "scope-b"}, cfg.Gmail.Scopes)
+	assert.True(t, cfg.Audit.BufferedWrites)
+	require.NotNil(t, cfg.Ollama.DefaultModelParams.Temperature)
+	assert.Equal(t, 0.2, *cfg.Ollama.DefaultModelParams.Temperature)
+	assert.Equal(t, "http://file:11434", cfg.Ollama.BaseURL)
+	assert.Equal(t, "qwen2.5:7b", cfg.Ollama.DefaultModel)
+
+	// Overrides apply without a config file too
+	cfg, err = LoadConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, cfg.Ollama.Timeout)
+}
+
+func TestLoadConfigInvalidEnvOverride(t *testing.T) {
+	t.Setenv("MAILSENTINEL_SERVER_READ_TIMEOUT", "fifteen")
+
+	_, err := LoadConfig("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "MAILSENTINEL_SERVER_READ_TIMEOUT")
+}
+
 func TestSaveConfig(t *testing.T) {
 	tempDir := t.TempDir()
 	configPath := filepath.Join(tempDir, "save-test.yaml")
 
-	cfg := validTestConfig()
+	cfg := validTestConfig(t)
 	cfg.Gmail.BatchSize = 200
 	cfg.Ollama.DefaultModel = "custom_model"
 
@@ -172,6 +607,81 @@ func TestSaveConfig(t *testing.T) {
 	assert.Equal(t, "custom_model", loadedCfg.Ollama.DefaultModel)
 }
 
+func TestRedactedCoversEverySecret(t *testing.T) {
+	cfg := validTestConfig(t)
+
+	// Every string field named like a credential must be redacted
+	var secrets []string
+	setSecrets(reflect.ValueOf(cfg).Elem(), &secrets)
+	require.NotEmpty(t, secrets)
+
+	data, err := yaml.Marshal(cfg.Redacted())
+	require.NoError(t, err)
+	for _, secret := range secrets {
+		assert.NotContains(t, string(data), secret)
+	}
+	assert.Equal(t, RedactedValue, cfg.Redacted().Gmail.ClientSecret)
+
+	// The original is left untouched
+	assert.Contains(t, secrets, cfg.Gmail.ClientSecret)
+}
+
+func TestRedactedCoversWebhooks(t *testing.T) {
+	cfg := validTestConfig(t)
+	cfg.Audit.Anomaly.WebhookURL = "https://hooks.example.com/anomaly?token=anomaly-token"
+	cfg.Notifications.Rules = []NotificationRule{{
+		Name:    "phishing",
+		URL:     "https://hooks.slack.com/services/T000/B000/slack-token",
+		Headers: map[string]string{"Authorization": "Bearer header-token"},
+	}}
+
+	redacted := cfg.Redacted()
+	data, err := yaml.Marshal(redacted)
+	require.NoError(t, err)
+	for _, token := range []string{"anomaly-token", "slack-token", "header-token"} {
+		assert.NotContains(t, string(data), token)
+	}
+	assert.Equal(t, RedactedValue, redacted.Notifications.Rules[0].URL)
+	assert.Equal(t, RedactedValue, redacted.Notifications.Rules[0].Headers["Authorization"])
+	assert.Equal(t, "phishing", redacted.Notifications.Rules[0].Name)
+
+	// The original rules and headers are left untouched
+	assert.Equal(t, "https://hooks.slack.com/services/T000/B000/slack-token", cfg.Notifications.Rules[0].URL)
+	assert.Equal(t, "Bearer header-token", cfg.Notifications.Rules[0].Headers["Authorization"])
+
+	err = cfg.SaveConfig(filepath.Join(t.TempDir(), "config.yaml"), RefusePlaintextSecrets())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "audit.anomaly.webhook_url (use MAILSENTINEL_AUDIT_ANOMALY_WEBHOOK_URL)")
+	assert.Contains(t, err.Error(), "notifications.rules[0].url (use a ${VAR} placeholder)")
+	assert.Contains(t, err.Error(), "notifications.rules[0].headers.Authorization (use a ${VAR} placeholder)")
+}
+
+func TestSaveConfigRefusesPlaintextSecrets(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+
+	cfg := validTestConfig(t)
+	cfg.Audit.EncryptionKey = "audit-key"
+	err := cfg.SaveConfig(configPath, RefusePlaintextSecrets())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "gmail.client_secret (use MAILSENTINEL_GMAIL_CLIENT_SECRET)")
+	assert.Contains(t, err.Error(), "audit.encryption_key (use MAILSENTINEL_AUDIT_ENCRYPTION_KEY)")
+	assert.NoFileExists(t, configPath)
+
+	cfg.Gmail.ClientSecret = "${GMAIL_CLIENT_SECRET}"
+	cfg.Audit.EncryptionKey = ""
+	require.NoError(t, cfg.SaveConfig(configPath, RefusePlaintextSecrets()))
+
+	info, err := os.Stat(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	require.NoError(t, os.Chmod(configPath, 0644))
+	require.NoError(t, cfg.SaveConfig(configPath, RefusePlaintextSecrets()))
+	info, err = os.Stat(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "an existing world-readable file is restricted")
+}
+
 func TestCircuitBreakerConfig(t *testing.T) {
 	cfg := DefaultConfig()
 	cb := cfg.Ollama.CircuitBreaker
@@ -183,12 +693,36 @@ func TestCircuitBreakerConfig(t *testing.T) {
 }
 
 // validTestConfig returns a valid configuration for testing
-func validTestConfig() *Config {
+func validTestConfig(t *testing.T) *Config {
+	tempDir := t.TempDir()
+	resolverPath := filepath.Join(tempDir, "resolver.yaml")
+	require.NoError(t, os.WriteFile(resolverPath, []byte("version: \"1.0.0\"\n"), 0644))
+
 	cfg := DefaultConfig()
 	cfg.Gmail.ClientID = "test_client_id"
 	cfg.Gmail.ClientSecret = "test_client_secret"
 	cfg.Ollama.BaseURL = "http://127.0.0.1:11434"
 	cfg.Ollama.DefaultModel = "qwen2.5:7b"
 	cfg.Profiles.Directory = "profiles"
+	cfg.Profiles.ResolverConfig = resolverPath
+	cfg.Audit.Directory = filepath.Join(tempDir, "audit")
 	return cfg
 }
+
+// setSecrets sets every string field whose name contains Secret, Key or
+// Password (other than key file paths) to a unique value and records it
+func setSecrets(v reflect.Value, secrets *[]string) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		value := v.Field(i)
+		switch {
+		case value.Kind() == reflect.Struct:
+			setSecrets(value, secrets)
+		case value.Kind() == reflect.String && !strings.HasSuffix(field.Name, "File") &&
+			(strings.Contains(field.Name, "Secret") || strings.Contains(field.Name, "Key") || strings.Contains(field.Name, "Password")):
+			secret := fmt.Sprintf("secret-value-%d", len(*secrets))
+			value.SetString(secret)
+			*secrets = append(*secrets, secret)
+		}
+	}
+}
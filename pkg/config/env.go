@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix starts the name of every environment variable that overrides a
+// configuration field
+const EnvPrefix = "MAILSENTINEL_"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ApplyEnvOverrides sets configuration fields from MAILSENTINEL_ environment
+// variables. Each variable name is the prefix followed by the field's YAML
+// path, upper-cased and joined with underscores, so ollama.base_url is
+// overridden by MAILSENTINEL_OLLAMA_BASE_URL. Durations use time.ParseDuration
+// syntax and string lists are comma-separated. Map fields cannot be
+// overridden.
+func (c *Config) ApplyEnvOverrides() error {
+	return applyEnv(reflect.ValueOf(c).Elem(), strings.TrimSuffix(EnvPrefix, "_"))
+}
+
+// applyEnv walks the struct v, overriding each field whose variable is set
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		name := prefix + "_" + strings.ToUpper(tag)
+		value := v.Field(i)
+		if value.Kind() == reflect.Struct {
+			if err := applyEnv(value, name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, exists := os.LookupEnv(name)
+		if !exists {
+			continue
+		}
+		if err := setFromEnv(value, raw); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setFromEnv parses raw into the field according to its type
+func setFromEnv(value reflect.Value, raw string) error {
+	if value.Type() == durationType {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(duration))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.Pointer:
+		target := reflect.New(value.Type().Elem())
+		if err := setFromEnv(target.Elem(), raw); err != nil {
+			return err
+		}
+		value.Set(target)
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(raw, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetFloat(parsed)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", value.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", value.Type())
+	}
+	return nil
+}
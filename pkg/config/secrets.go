@@ -0,0 +1,128 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RedactedValue replaces secret values in Redacted copies
+const RedactedValue = "****"
+
+// placeholderPattern matches a value that is entirely an environment
+// placeholder such as ${GMAIL_CLIENT_SECRET}, which is safe to write to disk
+var placeholderPattern = regexp.MustCompile(`^\$(\{[A-Za-z_][A-Za-z0-9_]*\}|[A-Za-z_][A-Za-z0-9_]*)$`)
+
+// secretField is a configuration field holding a credential. Fields in
+// lists and maps, such as notification headers, have no MAILSENTINEL_
+// variable.
+type secretField struct {
+	path  string
+	value string
+	set   func(value string)
+	inEnv bool
+}
+
+// stringSecret is the secretField of a plain string field, which a
+// MAILSENTINEL_ variable overrides
+func stringSecret(path string, value *string) secretField {
+	return secretField{path: path, value: *value, set: func(v string) { *value = v }, inEnv: true}
+}
+
+// secretFields lists every field of c that holds a credential. Webhook URLs
+// and headers are included because they commonly carry tokens.
+func (c *Config) secretFields() []secretField {
+	fields := []secretField{
+		stringSecret("gmail.client_secret", &c.Gmail.ClientSecret),
+		stringSecret("llm.openai.api_key", &c.LLM.OpenAI.APIKey),
+		stringSecret("mail.imap.password", &c.Mail.IMAP.Password),
+		stringSecret("audit.encryption_key", &c.Audit.EncryptionKey),
+		stringSecret("audit.anomaly.webhook_url", &c.Audit.Anomaly.WebhookURL),
+		stringSecret("security.encryption_key", &c.Security.EncryptionKey),
+	}
+	for i := range c.Notifications.Rules {
+		rule := &c.Notifications.Rules[i]
+		fields = append(fields, secretField{
+			path:  fmt.Sprintf("notifications.rules[%d].url", i),
+			value: rule.URL,
+			set:   func(v string) { rule.URL = v },
+		})
+		names := make([]string, 0, len(rule.Headers))
+		for name := range rule.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fields = append(fields, secretField{
+				path:  fmt.Sprintf("notifications.rules[%d].headers.%s", i, name),
+				value: rule.Headers[name],
+				set:   func(v string) { rule.Headers[name] = v },
+			})
+		}
+	}
+	return fields
+}
+
+// envName returns the MAILSENTINEL_ variable that overrides the field
+func (s secretField) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(s.path, ".", "_"))
+}
+
+// Redacted returns a copy of the configuration with every secret that is set
+// replaced by RedactedValue, suitable for logging or debug output. The
+// notification rules are copied; other slices and maps are shared with c
+// and must not be modified through the copy.
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redacted.Notifications.Rules = make([]NotificationRule, len(c.Notifications.Rules))
+	for i, rule := range c.Notifications.Rules {
+		if rule.Headers != nil {
+			headers := make(map[string]string, len(rule.Headers))
+			for name, value := range rule.Headers {
+				headers[name] = value
+			}
+			rule.Headers = headers
+		}
+		redacted.Notifications.Rules[i] = rule
+	}
+	for _, field := range redacted.secretFields() {
+		if field.value != "" {
+			field.set(RedactedValue)
+		}
+	}
+	return &redacted
+}
+
+// SaveOption configures SaveConfig
+type SaveOption func(*saveOptions)
+
+type saveOptions struct {
+	refusePlaintextSecrets bool
+}
+
+// RefusePlaintextSecrets makes SaveConfig fail instead of writing a secret
+// to disk. Secrets may still be saved as ${VAR} placeholders; otherwise they
+// should be left empty and supplied through their MAILSENTINEL_ variable.
+func RefusePlaintextSecrets() SaveOption {
+	return func(o *saveOptions) {
+		o.refusePlaintextSecrets = true
+	}
+}
+
+// plaintextSecrets returns the paths of secrets set to a literal value,
+// each with the environment variable to use instead
+func (c *Config) plaintextSecrets() []string {
+	var found []string
+	for _, field := range c.secretFields() {
+		if field.value == "" || placeholderPattern.MatchString(field.value) {
+			continue
+		}
+		if field.inEnv {
+			found = append(found, fmt.Sprintf("%s (use %s)", field.path, field.envName()))
+		} else {
+			found = append(found, fmt.Sprintf("%s (use a ${VAR} placeholder)", field.path))
+		}
+	}
+	return found
+}
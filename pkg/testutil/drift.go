@@ -0,0 +1,211 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// DefaultConfidenceTolerance is the confidence delta accepted before a
+// matching classification counts as drifted, as in AssertClassificationResult
+const DefaultConfidenceTolerance = 0.05
+
+// Regression kinds
+const (
+	RegressionAction     = "action"
+	RegressionConfidence = "confidence"
+	RegressionMissing    = "missing"
+)
+
+// GoldenCase is one expected classification of the golden set
+type GoldenCase struct {
+	Name       string  `json:"name"`
+	EmailID    string  `json:"email_id"`
+	Action     string  `json:"action"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Regression is a golden case the results no longer reproduce
+type Regression struct {
+	Name               string  `json:"name"`
+	EmailID            string  `json:"email_id"`
+	Kind               string  `json:"kind"`
+	ExpectedAction     string  `json:"expected_action"`
+	ActualAction       string  `json:"actual_action,omitempty"`
+	ExpectedConfidence float64 `json:"expected_confidence"`
+	ActualConfidence   float64 `json:"actual_confidence,omitempty"`
+	ConfidenceDelta    float64 `json:"confidence_delta,omitempty"`
+}
+
+// String describes the regression in one line
+func (r Regression) String() string {
+	switch r.Kind {
+	case RegressionMissing:
+		return fmt.Sprintf("%s (%s): no result", r.Name, r.EmailID)
+	case RegressionConfidence:
+		return fmt.Sprintf("%s (%s): confidence %.2f, expected %.2f", r.Name, r.EmailID, r.ActualConfidence, r.ExpectedConfidence)
+	default:
+		return fmt.Sprintf("%s (%s): action %s, expected %s", r.Name, r.EmailID, r.ActualAction, r.ExpectedAction)
+	}
+}
+
+// DriftReport aggregates how a set of classification results compares with
+// the golden set
+type DriftReport struct {
+	Total      int `json:"total"`
+	Matched    int `json:"matched"`
+	Mismatched int `json:"mismatched"`
+	Drifted    int `json:"drifted"` // same action, confidence beyond tolerance
+	Missing    int `json:"missing"`
+
+	// Confidence deltas are absolute and cover the cases with a result
+	MeanConfidenceDelta float64 `json:"mean_confidence_delta"`
+	MaxConfidenceDelta  float64 `json:"max_confidence_delta"`
+
+	Regressions []Regression `json:"regressions"`
+}
+
+// DriftRate is the fraction of golden cases whose action changed or that
+// have no result
+func (r DriftReport) DriftRate() float64 {
+	if r.Total == 0 {
+		return 0
+	}
+	return float64(r.Mismatched+r.Missing) / float64(r.Total)
+}
+
+// Exceeds reports whether the drift rate is above maxRate, the check a CI
+// gate fails on
+func (r DriftReport) Exceeds(maxRate float64) bool {
+	return r.DriftRate() > maxRate
+}
+
+// LoadGoldenCases reads a classification golden file, such as
+// testdata/golden/classification_outputs.json, without a testing.T so
+// drift can also be checked outside tests
+func LoadGoldenCases(path string) ([]GoldenCase, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read golden file: %w", err)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse golden file %s: %w", path, err)
+	}
+	return goldenCases(raw), nil
+}
+
+// CompareClassifications compares results, matched to golden cases by email
+// ID, against the golden set. When an email has several results the last one
+// is compared; results for emails outside the golden set are ignored.
+func CompareClassifications(golden []GoldenCase, results []*types.ClassificationResponse, tolerance float64) DriftReport {
+	byEmail := make(map[string]*types.ClassificationResponse, len(results))
+	for _, result := range results {
+		byEmail[result.EmailID] = result
+	}
+
+	report := DriftReport{Total: len(golden)}
+	var totalDelta float64
+	compared := 0
+	for _, expected := range golden {
+		regression := Regression{
+			Name:               expected.Name,
+			EmailID:            expected.EmailID,
+			ExpectedAction:     expected.Action,
+			ExpectedConfidence: expected.Confidence,
+		}
+
+		actual, exists := byEmail[expected.EmailID]
+		if !exists {
+			regression.Kind = RegressionMissing
+			report.Missing++
+			report.Regressions = append(report.Regressions, regression)
+			continue
+		}
+
+		delta := math.Abs(actual.Confidence - expected.Confidence)
+		compared++
+		totalDelta += delta
+		report.MaxConfidenceDelta = math.Max(report.MaxConfidenceDelta, delta)
+
+		regression.ActualAction = actual.Action
+		regression.ActualConfidence = actual.Confidence
+		regression.ConfidenceDelta = actual.Confidence - expected.Confidence
+		switch {
+		case actual.Action != expected.Action:
+			regression.Kind = RegressionAction
+			report.Mismatched++
+		case delta > tolerance:
+			regression.Kind = RegressionConfidence
+			report.Drifted++
+		default:
+			report.Matched++
+			continue
+		}
+		report.Regressions = append(report.Regressions, regression)
+	}
+
+	if compared > 0 {
+		report.MeanConfidenceDelta = totalDelta / float64(compared)
+	}
+	return report
+}
+
+// CompareAgainstGolden compares results against the classification golden
+// set with DefaultConfidenceTolerance
+func (td *TestData) CompareAgainstGolden(results []*types.ClassificationResponse) DriftReport {
+	return CompareClassifications(goldenCases(td.ClassificationGold), results, DefaultConfidenceTolerance)
+}
+
+// AssertDriftWithin fails the test, listing every regression, when the
+// results drift from the golden set by more than maxRate
+func (td *TestData) AssertDriftWithin(t *testing.T, results []*types.ClassificationResponse, maxRate float64) DriftReport {
+	t.Helper()
+
+	report := td.CompareAgainstGolden(results)
+	if report.Exceeds(maxRate) {
+		lines := make([]string, len(report.Regressions))
+		for i, regression := range report.Regressions {
+			lines[i] = "  " + regression.String()
+		}
+		t.Errorf("classification drift %.1f%% exceeds %.1f%%:\n%s", report.DriftRate()*100, maxRate*100, strings.Join(lines, "\n"))
+	}
+	return report
+}
+
+// goldenCases extracts the golden cases from a parsed golden file, sorted by
+// name. Entries without an input email ID or an expected action are skipped.
+func goldenCases(raw map[string]interface{}) []GoldenCase {
+	var cases []GoldenCase
+	for name, value := range raw {
+		data, _ := value.(map[string]interface{})
+		input, _ := data["input"].(map[string]interface{})
+		expected, _ := data["expected_output"].(map[string]interface{})
+
+		emailID, _ := input["email_id"].(string)
+		action, _ := expected["action"].(string)
+		if emailID == "" || action == "" {
+			continue
+		}
+		confidence, _ := expected["confidence"].(float64)
+
+		cases = append(cases, GoldenCase{
+			Name:       name,
+			EmailID:    emailID,
+			Action:     action,
+			Confidence: confidence,
+		})
+	}
+
+	sort.Slice(cases, func(i, j int) bool {
+		return cases[i].Name < cases[j].Name
+	})
+	return cases
+}
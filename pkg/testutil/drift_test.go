@@ -0,0 +1,93 @@
+package testutil
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+func TestCompareAgainstGoldenMatches(t *testing.T) {
+	td := LoadTestData(t)
+
+	report := td.CompareAgainstGolden(goldenResults(t, td))
+
+	assert.Equal(t, 5, report.Total)
+	assert.Equal(t, 5, report.Matched)
+	assert.Empty(t, report.Regressions)
+	assert.Zero(t, report.DriftRate())
+	assert.False(t, report.Exceeds(0))
+}
+
+func TestCompareAgainstGoldenReportsRegressions(t *testing.T) {
+	td := LoadTestData(t)
+
+	results := goldenResults(t, td)
+	var kept []*types.ClassificationResponse
+	for _, result := range results {
+		switch result.EmailID {
+		case "test-email-001":
+			result.Action = "archive" // phishing no longer deleted
+		case "test-email-003":
+			result.Confidence -= 0.2 // same action, much less certain
+		case "test-email-007":
+			continue // no result
+		}
+		kept = append(kept, result)
+	}
+	kept = append(kept, &types.ClassificationResponse{EmailID: "not-golden", Action: "delete"})
+
+	report := td.CompareAgainstGolden(kept)
+
+	assert.Equal(t, 5, report.Total)
+	assert.Equal(t, 2, report.Matched)
+	assert.Equal(t, 1, report.Mismatched)
+	assert.Equal(t, 1, report.Drifted)
+	assert.Equal(t, 1, report.Missing)
+	assert.InDelta(t, 0.2, report.MaxConfidenceDelta, 1e-9)
+	assert.InDelta(t, 0.05, report.MeanConfidenceDelta, 1e-9)
+	assert.InDelta(t, 0.4, report.DriftRate(), 1e-9, "the action change and the missing result")
+	assert.True(t, report.Exceeds(0.2))
+	assert.False(t, report.Exceeds(0.5))
+
+	require.Len(t, report.Regressions, 3)
+	kinds := map[string]string{}
+	for _, regression := range report.Regressions {
+		kinds[regression.EmailID] = regression.Kind
+	}
+	assert.Equal(t, map[string]string{
+		"test-email-001": RegressionAction,
+		"test-email-003": RegressionConfidence,
+		"test-email-007": RegressionMissing,
+	}, kinds)
+	assert.Equal(t, "phishing_email_001 (test-email-001): action archive, expected delete", report.Regressions[2].String())
+}
+
+func TestLoadGoldenCases(t *testing.T) {
+	cases, err := LoadGoldenCases(filepath.Join(getTestDataDir(t), "golden", "classification_outputs.json"))
+	require.NoError(t, err)
+	require.Len(t, cases, 5)
+	assert.Equal(t, GoldenCase{Name: "important_email_005", EmailID: "test-email-005", Action: "prioritize", Confidence: 0.94}, cases[0])
+
+	_, err = LoadGoldenCases(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+// Helper functions
+
+// goldenResults returns results reproducing every golden case exactly
+func goldenResults(t *testing.T, td *TestData) []*types.ClassificationResponse {
+	var results []*types.ClassificationResponse
+	for _, golden := range goldenCases(td.ClassificationGold) {
+		results = append(results, &types.ClassificationResponse{
+			EmailID:    golden.EmailID,
+			Action:     golden.Action,
+			Confidence: golden.Confidence,
+		})
+	}
+	require.NotEmpty(t, results)
+	return results
+}
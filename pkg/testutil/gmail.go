@@ -0,0 +1,296 @@
+package testutil
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailsentinel/core/pkg/types"
+)
+
+// gmailError writes an error in the shape the Gmail API returns, so clients
+// surface it as a googleapi.Error with the given status
+func gmailError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    status,
+			"message": message,
+		},
+	})
+}
+
+// messageFixture returns the Gmail message with the given ID, preferring a
+// recorded response in GmailResponses and otherwise synthesizing one from
+// the matching email fixture
+func (td *TestData) messageFixture(id string) (interface{}, bool) {
+	for _, response := range td.GmailResponses {
+		message, ok := response.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, hasPayload := message["payload"]; hasPayload && message["id"] == id {
+			return message, true
+		}
+	}
+
+	if email := td.GetTestEmail(id); email != nil {
+		return syntheticMessage(email), true
+	}
+	return nil, false
+}
+
+// listMessages answers users.messages.list. Without a query the recorded
+// list response is returned unchanged; with one, the email fixtures are
+// filtered by matchesQuery and paged by maxResults, the page token being
+// the number of matches on earlier pages. A fixture without a thread ID is
+// a thread of its own, as in listThreads.
+func (td *TestData) listMessages(r *http.Request) interface{} {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		return td.GmailResponses["messages_list_response"]
+	}
+
+	maxResults, _ := strconv.Atoi(r.URL.Query().Get("maxResults"))
+	start, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+
+	messages := []map[string]string{}
+	matches := 0
+	nextPageToken := ""
+	for i := range td.Emails {
+		email := &td.Emails[i]
+		if !matchesQuery(email, query) {
+			continue
+		}
+		matches++
+		if matches <= start {
+			continue
+		}
+		if maxResults > 0 && len(messages) == maxResults {
+			nextPageToken = strconv.Itoa(start + maxResults)
+			break
+		}
+		threadID := email.ThreadID
+		if threadID == "" {
+			threadID = email.ID
+		}
+		messages = append(messages, map[string]string{"id": email.ID, "threadId": threadID})
+	}
+
+	response := map[string]interface{}{
+		"messages":           messages,
+		"resultSizeEstimate": len(messages),
+	}
+	if nextPageToken != "" {
+		response["nextPageToken"] = nextPageToken
+	}
+	return response
+}
+
+// mockSnippetLength is the length of the snippets the mock thread list
+// takes from message bodies
+const mockSnippetLength = 100
+
+// listThreads answers users.threads.list with the threads of the email
+// fixtures matching q, in fixture order and up to maxResults, each with the
+// snippet of its latest matching message, or the start of its body. A
+// fixture without a thread ID is a thread of its own, with its ID as Gmail
+// gives it.
+func (td *TestData) listThreads(r *http.Request) interface{} {
+	query := r.URL.Query().Get("q")
+	maxResults, _ := strconv.Atoi(r.URL.Query().Get("maxResults"))
+
+	threads := []map[string]string{}
+	index := make(map[string]int)
+	for i := range td.Emails {
+		email := &td.Emails[i]
+		if !matchesQuery(email, query) {
+			continue
+		}
+		snippet := email.Snippet
+		if snippet == "" {
+			snippet = email.Body
+			if len(snippet) > mockSnippetLength {
+				snippet = snippet[:mockSnippetLength]
+			}
+		}
+		threadID := email.ThreadID
+		if threadID == "" {
+			threadID = email.ID
+		}
+		if at, seen := index[threadID]; seen {
+			threads[at]["snippet"] = snippet
+			continue
+		}
+		if maxResults > 0 && len(threads) == maxResults {
+			continue
+		}
+		index[threadID] = len(threads)
+		threads = append(threads, map[string]string{"id": threadID, "snippet": snippet})
+	}
+
+	return map[string]interface{}{
+		"threads":            threads,
+		"resultSizeEstimate": len(threads),
+	}
+}
+
+// mockLabels is the label store of a mock Gmail server, seeded from the
+// recorded label list, to which created labels are added
+type mockLabels struct {
+	mutex   sync.Mutex
+	labels  []interface{}
+	created int
+}
+
+// newMockLabels seeds a label store from the recorded label list response
+func (td *TestData) newMockLabels() *mockLabels {
+	store := &mockLabels{}
+	if response, ok := td.GmailResponses["labels_list_response"].(map[string]interface{}); ok {
+		if labels, ok := response["labels"].([]interface{}); ok {
+			store.labels = append(store.labels, labels...)
+		}
+	}
+	return store
+}
+
+// serve answers users.labels.list and users.labels.create. Like Gmail, it
+// rejects a label whose name matches an existing one ignoring case.
+func (m *mockLabels) serve(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if r.Method != http.MethodPost {
+		json.NewEncoder(w).Encode(map[string]interface{}{"labels": m.labels})
+		return
+	}
+
+	var label map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&label); err != nil {
+		gmailError(w, http.StatusBadRequest, "Invalid label.")
+		return
+	}
+	name, _ := label["name"].(string)
+	for _, existing := range m.labels {
+		if existingName, _ := existing.(map[string]interface{})["name"].(string); strings.EqualFold(existingName, name) {
+			gmailError(w, http.StatusConflict, "Label name exists or conflicts")
+			return
+		}
+	}
+	m.created++
+	label["id"] = "Label_created_" + strconv.Itoa(m.created)
+	label["type"] = "user"
+	m.labels = append(m.labels, label)
+	json.NewEncoder(w).Encode(label)
+}
+
+// syntheticMessage builds a users.messages.get response for an email fixture
+func syntheticMessage(email *types.Email) map[string]interface{} {
+	headers := []map[string]string{
+		{"name": "Subject", "value": email.Subject},
+		{"name": "From", "value": email.From},
+		{"name": "To", "value": strings.Join(email.To, ",")},
+	}
+	if len(email.CC) > 0 {
+		headers = append(headers, map[string]string{"name": "Cc", "value": strings.Join(email.CC, ",")})
+	}
+	if !email.Date.IsZero() {
+		headers = append(headers, map[string]string{"name": "Date", "value": email.Date.Format(time.RFC1123Z)})
+	}
+	if email.MessageID != "" {
+		headers = append(headers, map[string]string{"name": "Message-ID", "value": "<" + email.MessageID + ">"})
+	}
+	if email.InReplyTo != "" {
+		headers = append(headers, map[string]string{"name": "In-Reply-To", "value": "<" + email.InReplyTo + ">"})
+	}
+	if len(email.References) > 0 {
+		headers = append(headers, map[string]string{"name": "References", "value": "<" + strings.Join(email.References, "> <") + ">"})
+	}
+
+	payload := map[string]interface{}{
+		"mimeType": "text/plain",
+		"headers":  headers,
+		"body": map[string]interface{}{
+			"data": base64.URLEncoding.EncodeToString([]byte(email.Body)),
+			"size": len(email.Body),
+		},
+	}
+	if len(email.Attachments) > 0 {
+		// The body and attachments become parts of a multipart/mixed message
+		parts := []map[string]interface{}{{"mimeType": "text/plain", "body": payload["body"]}}
+		for _, attachment := range email.Attachments {
+			parts = append(parts, map[string]interface{}{
+				"mimeType": attachment.MimeType,
+				"filename": attachment.Filename,
+				"body": map[string]interface{}{
+					"attachmentId": attachment.ID,
+					"size":         attachment.Size,
+				},
+			})
+		}
+		payload = map[string]interface{}{
+			"mimeType": "multipart/mixed",
+			"headers":  headers,
+			"parts":    parts,
+		}
+	}
+
+	return map[string]interface{}{
+		"id":           email.ID,
+		"threadId":     email.ThreadID,
+		"labelIds":     email.Labels,
+		"internalDate": strconv.FormatInt(email.Date.UnixMilli(), 10),
+		"sizeEstimate": email.Size,
+		"payload":      payload,
+	}
+}
+
+// matchesQuery reports whether email satisfies every term of a Gmail search
+// query. The from:, to:, subject:, label:, in: and is: operators are
+// supported, a leading - negates a term, and any other term matches the
+// subject, sender or body. Matching is case-insensitive.
+func matchesQuery(email *types.Email, query string) bool {
+	for _, term := range strings.Fields(strings.ToLower(query)) {
+		negate := strings.HasPrefix(term, "-")
+		if matchesTerm(email, strings.TrimPrefix(term, "-")) == negate {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesTerm reports whether email satisfies a single lower-cased query term
+func matchesTerm(email *types.Email, term string) bool {
+	operator, value, found := strings.Cut(term, ":")
+	if !found {
+		return containsFold(email.Subject, term) || containsFold(email.From, term) || containsFold(email.Body, term)
+	}
+
+	switch operator {
+	case "from":
+		return containsFold(email.From, value)
+	case "to":
+		return containsFold(strings.Join(email.To, ","), value)
+	case "subject":
+		return containsFold(email.Subject, value)
+	case "label", "in", "is":
+		for _, label := range email.Labels {
+			if strings.EqualFold(label, value) {
+				return true
+			}
+		}
+		return false
+	default:
+		return containsFold(email.Subject, term) || containsFold(email.Body, term)
+	}
+}
+
+// containsFold reports whether substr, which is already lower-cased, occurs
+// in text ignoring case
+func containsFold(text, substr string) bool {
+	return strings.Contains(strings.ToLower(text), substr)
+}
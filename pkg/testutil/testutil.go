@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/mailsentinel/core/pkg/types"
@@ -108,21 +109,44 @@ func (td *TestData) MockOllamaServer(t *testing.T) *httptest.Server {
 	}))
 }
 
-// MockGmailServer creates a mock Gmail API server
+// MockGmailServer creates a mock Gmail API server. Messages can be fetched,
+// trashed and deleted by any fixture ID, and the list endpoint filters the
+// email fixtures when a q search query is given, as the thread list
+// endpoint does. Labels created through the server are listed with the
+// recorded ones.
 func (td *TestData) MockGmailServer(t *testing.T) *httptest.Server {
+	const messagesPath = "/gmail/v1/users/me/messages"
+	labels := td.newMockLabels()
+
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		
 		switch {
-		case r.URL.Path == "/gmail/v1/users/me/messages":
-			response := td.GmailResponses["messages_list_response"]
+		case r.URL.Path == messagesPath:
+			response := td.listMessages(r)
 			json.NewEncoder(w).Encode(response)
-		case r.URL.Path == "/gmail/v1/users/me/messages/test-email-001":
-			response := td.GmailResponses["message_get_response"]
+		case strings.HasPrefix(r.URL.Path, messagesPath+"/"):
+			id := strings.TrimPrefix(r.URL.Path, messagesPath+"/")
+			trash := r.Method == http.MethodPost && strings.HasSuffix(id, "/trash")
+			id = strings.TrimSuffix(id, "/trash")
+			response, found := td.messageFixture(id)
+			if !found {
+				gmailError(w, http.StatusNotFound, "Requested entity was not found.")
+				return
+			}
+			switch {
+			case r.Method == http.MethodDelete:
+				w.WriteHeader(http.StatusNoContent)
+			case trash:
+				json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "labelIds": []string{"TRASH"}})
+			default:
+				json.NewEncoder(w).Encode(response)
+			}
+		case r.URL.Path == "/gmail/v1/users/me/threads":
+			response := td.listThreads(r)
 			json.NewEncoder(w).Encode(response)
 		case r.URL.Path == "/gmail/v1/users/me/labels":
-			response := td.GmailResponses["labels_list_response"]
-			json.NewEncoder(w).Encode(response)
+			labels.serve(w, r)
 		case r.URL.Path == "/gmail/v1/users/me/profile":
 			response := td.GmailResponses["profile_response"]
 			json.NewEncoder(w).Encode(response)
@@ -0,0 +1,66 @@
+package types
+
+import "time"
+
+// Decision is the complete record of what was decided for one email: the
+// result of every profile that classified it, the outcome they resolved
+// to with the resolver's explanation, and the action taken on it or, in a
+// dry run, intended. It is the unit reported, replayed and fed back on, and
+// is audited as a single entry.
+type Decision struct {
+	EmailID         string                    `json:"email_id"`
+	Classifications []*ClassificationResponse `json:"classifications"`
+	// Resolution is the outcome acted on: the single classification, or the
+	// resolver's decision combining several
+	Resolution *ClassificationResponse `json:"resolution"`
+	// Explanation traces how the resolver reached the resolution, when it
+	// explains its decisions; see MetadataResolution
+	Explanation interface{} `json:"explanation,omitempty"`
+	// Fingerprints identify what produced each classification, index for
+	// index, with nil for a classification without one. It is nil when no
+	// classification has one.
+	Fingerprints []*Fingerprint `json:"fingerprints,omitempty"`
+	// Action is nil until the resolution is acted on
+	Action    *AppliedAction `json:"action,omitempty"`
+	DecidedAt time.Time      `json:"decided_at"`
+	ActedAt   *time.Time     `json:"acted_at,omitempty"`
+}
+
+// NewDecision records the resolution of an email's classifications, taking
+// the resolver's explanation from the resolution's metadata and the time of
+// the decision from its ProcessedAt
+func NewDecision(emailID string, classifications []*ClassificationResponse, resolution *ClassificationResponse) *Decision {
+	decision := &Decision{
+		EmailID:         emailID,
+		Classifications: classifications,
+		Resolution:      resolution,
+		Explanation:     resolution.Metadata[MetadataResolution],
+		DecidedAt:       resolution.ProcessedAt,
+	}
+	for i, classification := range classifications {
+		if classification.Fingerprint == nil {
+			continue
+		}
+		if decision.Fingerprints == nil {
+			decision.Fingerprints = make([]*Fingerprint, len(classifications))
+		}
+		decision.Fingerprints[i] = classification.Fingerprint
+	}
+	return decision
+}
+
+// RecordAction records the action applied to the email, or intended in a
+// dry run, at the given time
+func (d *Decision) RecordAction(action *AppliedAction, at time.Time) {
+	d.Action = action
+	d.ActedAt = &at
+}
+
+// FinalAction returns the action taken on the email or, until it is acted
+// on, the resolved action
+func (d *Decision) FinalAction() string {
+	if d.Action != nil {
+		return d.Action.Action
+	}
+	return d.Resolution.Action
+}
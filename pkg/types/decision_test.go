@@ -0,0 +1,54 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDecision(t *testing.T) {
+	decidedAt := time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)
+	spam := &ClassificationResponse{ProfileID: "spam", Action: "keep", Confidence: 0.6, Fingerprint: &Fingerprint{ID: "fp-spam"}}
+	phishing := &ClassificationResponse{ProfileID: "phishing", Action: "quarantine", Confidence: 0.9, Fingerprint: &Fingerprint{ID: "fp-phishing"}}
+	explanation := map[string]interface{}{"method": "priority_rule"}
+	resolution := &ClassificationResponse{
+		ProfileID:   "phishing",
+		Action:      "quarantine",
+		Confidence:  0.9,
+		ProcessedAt: decidedAt,
+		Metadata:    map[string]interface{}{MetadataResolution: explanation},
+	}
+
+	decision := NewDecision("email-1", []*ClassificationResponse{spam, phishing}, resolution)
+	assert.Equal(t, "email-1", decision.EmailID)
+	assert.Equal(t, []*ClassificationResponse{spam, phishing}, decision.Classifications)
+	assert.Same(t, resolution, decision.Resolution)
+	assert.Equal(t, explanation, decision.Explanation)
+	assert.Equal(t, []*Fingerprint{{ID: "fp-spam"}, {ID: "fp-phishing"}}, decision.Fingerprints)
+	assert.Equal(t, decidedAt, decision.DecidedAt)
+	assert.Nil(t, decision.Action)
+	assert.Nil(t, decision.ActedAt)
+	assert.Equal(t, "quarantine", decision.FinalAction())
+
+	actedAt := decidedAt.Add(time.Second)
+	decision.RecordAction(&AppliedAction{EmailID: "email-1", Action: "review", ProposedAction: "quarantine", DryRun: true}, actedAt)
+	require.NotNil(t, decision.ActedAt)
+	assert.Equal(t, actedAt, *decision.ActedAt)
+	assert.Equal(t, "review", decision.FinalAction(), "the action taken once acted on")
+}
+
+func TestNewDecisionWithoutExplanation(t *testing.T) {
+	result := &ClassificationResponse{ProfileID: "newsletter", Action: "archive"}
+	decision := NewDecision("email-1", []*ClassificationResponse{result}, result)
+	assert.Nil(t, decision.Explanation)
+	assert.Nil(t, decision.Fingerprints)
+}
+
+func TestNewDecisionAlignsFingerprints(t *testing.T) {
+	cached := &ClassificationResponse{ProfileID: "newsletter", Action: "archive"}
+	spam := &ClassificationResponse{ProfileID: "spam", Action: "keep", Fingerprint: &Fingerprint{ID: "fp-spam"}}
+	decision := NewDecision("email-1", []*ClassificationResponse{cached, spam}, spam)
+	assert.Equal(t, []*Fingerprint{nil, {ID: "fp-spam"}}, decision.Fingerprints, "each fingerprint stays at its classification's index")
+}
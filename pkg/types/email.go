@@ -1,6 +1,10 @@
 package types
 
 import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -20,6 +24,223 @@ type Email struct {
 	Headers     map[string]string `json:"headers"`
 	Attachments []Attachment      `json:"attachments,omitempty"`
 	Size        int64             `json:"size"`
+	Auth        *AuthResults      `json:"auth,omitempty"`
+	Security    *MessageSecurity  `json:"security,omitempty"`
+	// MessageID is the email's Message-ID, and InReplyTo and References
+	// the message IDs of its In-Reply-To and References headers, all
+	// without angle brackets
+	MessageID  string   `json:"message_id,omitempty"`
+	InReplyTo  string   `json:"in_reply_to,omitempty"`
+	References []string `json:"references,omitempty"`
+	// Thread holds earlier messages of the email's thread, oldest first,
+	// included in the classification prompt as context
+	Thread []ThreadMessage `json:"thread,omitempty"`
+	// Context holds hints from the caller, such as {"source": "quarantine"},
+	// included in the prompt, in route, conditional execution and priority
+	// rule conditions as email.context, and in email_classified audit
+	// entries as email_context
+	Context map[string]string `json:"context,omitempty"`
+	// URLs are the links found in the plain and HTML bodies, Homoglyphs
+	// holds the sender domain and link hosts in raw and normalized form,
+	// Reply checks a subject claiming a reply or a forward against the
+	// threading headers, Language is the ISO 639-1 code of the body's
+	// language or LanguageUnknown, and EmptyBody is set when the body is
+	// empty or nearly so. All are computed from the email by the server and
+	// overwrite any value a caller sends.
+	URLs       []Link             `json:"urls,omitempty"`
+	Homoglyphs *HomoglyphAnalysis `json:"homoglyphs,omitempty"`
+	Reply      *ReplyAnalysis     `json:"reply,omitempty"`
+	Language   string             `json:"language,omitempty"`
+	EmptyBody  bool               `json:"empty_body,omitempty"`
+	// Snippet is the mail provider's short plain text preview of the body.
+	// A Preview email was listed without fetching its message: it carries
+	// the snippet and IDs only, and stands for the latest message of its
+	// thread.
+	Snippet string `json:"snippet,omitempty"`
+	Preview bool   `json:"preview,omitempty"`
+}
+
+// Link is a link found in an email's body
+type Link struct {
+	URL  string `json:"url"`
+	Host string `json:"host"`
+	// Text is the anchor text of a link in the HTML body, and TextHost the
+	// host it names when it reads as a URL or a domain
+	Text     string `json:"text,omitempty"`
+	TextHost string `json:"text_host,omitempty"`
+	// Mismatch is set when the anchor text names another host than the
+	// link leads to, as phishing links often do
+	Mismatch bool `json:"mismatch,omitempty"`
+	// Shortener is set when the host is a URL shortener hiding where the
+	// link leads
+	Shortener bool `json:"shortener,omitempty"`
+}
+
+// HomoglyphAnalysis is an email's sender domain and the links in its body,
+// each with its normalized form: NFKC, lowercased, without diacritics and
+// with confusable characters such as the Cyrillic "а" mapped to the ASCII
+// letters they imitate
+type HomoglyphAnalysis struct {
+	SenderDomain           string `json:"sender_domain"`
+	NormalizedSenderDomain string `json:"normalized_sender_domain"`
+	// Suspicious is set when the normalized sender domain differs from the
+	// raw one, as it does for a domain imitating another
+	Suspicious bool            `json:"suspicious"`
+	URLs       []NormalizedURL `json:"urls,omitempty"`
+}
+
+// NormalizedURL is a link found in an email's body with its normalized
+// form, in which only the host is normalized
+type NormalizedURL struct {
+	Raw            string `json:"raw"`
+	Normalized     string `json:"normalized"`
+	Host           string `json:"host"`
+	NormalizedHost string `json:"normalized_host"`
+	// Suspicious is set when the normalized host differs from the raw one
+	Suspicious bool `json:"suspicious"`
+}
+
+// MetadataHomoglyphSender is set on a result when the email's normalized
+// sender domain differs from the raw one, with the normalized domain as its
+// value
+const MetadataHomoglyphSender = "homoglyph_sender"
+
+// ReplyAnalysis compares an email whose subject claims a reply or a
+// forward, such as "Re: Invoice" or "Fwd: Invoice", with its threading
+// headers and the original it quotes. Business email compromise often
+// fakes a thread this way to borrow the trust of an earlier conversation.
+type ReplyAnalysis struct {
+	// Kind is ReplyKindReply or ReplyKindForward, after the subject prefix
+	Kind string `json:"kind"`
+	// Threaded is set when the email has an In-Reply-To or References
+	// header
+	Threaded bool `json:"threaded"`
+	// QuotedFrom is the sender of the quoted or forwarded original, as its
+	// attribution line names them, when it names an address
+	QuotedFrom string `json:"quoted_from,omitempty"`
+	// Spoofed is set when any of Reasons applies
+	Spoofed bool     `json:"spoofed"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// Kinds of ReplyAnalysis
+const (
+	ReplyKindReply   = "reply"
+	ReplyKindForward = "forward"
+)
+
+// Reasons of a spoofed ReplyAnalysis
+const (
+	// ReplyMissingHeaders is a reply without In-Reply-To and References
+	// headers, or a forward without them and without a forwarded message
+	ReplyMissingHeaders = "missing_headers"
+	// ReplyNoThread is a threaded email the mail provider threads alone, as
+	// it does when the messages it references are not in the mailbox
+	ReplyNoThread = "no_thread"
+	// ReplyQuotedSender is a quoted original attributed to the sender's
+	// name at another address, or a reply quoting someone who is neither
+	// its sender nor one of its recipients
+	ReplyQuotedSender = "quoted_sender_mismatch"
+)
+
+// MetadataSpoofedReply is set on a result when the email's subject claims
+// a reply or a forward its threading headers or quoted original contradict,
+// with the reasons as its value
+const MetadataSpoofedReply = "spoofed_reply"
+
+// LanguageUnknown is the language of an email whose body is too short or
+// too mixed to tell
+const LanguageUnknown = "unknown"
+
+// MetadataLanguage is set on a result to the ISO 639-1 code of the email's
+// language, when it is known
+const MetadataLanguage = "language"
+
+// MetadataEmptyBody is set on a result to true when the email's body is
+// empty or nearly so, and the result was classified from its subject and
+// headers alone or handled as system mail
+const MetadataEmptyBody = "empty_body"
+
+// ThreadMessage is an earlier message of an email's thread
+type ThreadMessage struct {
+	ID      string    `json:"id"`
+	From    string    `json:"from"`
+	Subject string    `json:"subject,omitempty"`
+	Date    time.Time `json:"date"`
+	Body    string    `json:"body"`
+	// Truncated is set when the body was cut to fit the context budget
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// AuthResults are the SPF, DKIM and DMARC verdicts for an email, taken from
+// its Authentication-Results and Received-SPF headers. Each is a lowercase
+// RFC 8601 result such as "pass", "fail" or "softfail", or AuthNone when the
+// headers do not report the method.
+type AuthResults struct {
+	SPF   string `json:"spf"`
+	DKIM  string `json:"dkim"`
+	DMARC string `json:"dmarc"`
+}
+
+// Authentication results with special meaning
+const (
+	AuthPass = "pass"
+	AuthFail = "fail"
+	AuthNone = "none"
+)
+
+// MessageSecurity is the PGP or S/MIME protection of a signed or encrypted
+// email. The body of a signed email is its signed payload; the content of
+// an encrypted email cannot be read, so its body holds at most the text
+// around the ciphertext.
+type MessageSecurity struct {
+	Signed    bool   `json:"signed"`
+	Encrypted bool   `json:"encrypted"`
+	Protocol  string `json:"protocol"`
+}
+
+// Message security protocols
+const (
+	SecurityPGP   = "pgp"
+	SecuritySMIME = "smime"
+)
+
+// IsEncrypted reports whether the email is encrypted
+func (e *Email) IsEncrypted() bool {
+	return e.Security != nil && e.Security.Encrypted
+}
+
+// IsSpoofedReply reports whether the email's subject claims a reply or a
+// forward that its threading headers or quoted original contradict
+func (e *Email) IsSpoofedReply() bool {
+	return e.Reply != nil && e.Reply.Spoofed
+}
+
+// ContextKeys returns the keys of the email's classification context, sorted
+// so that prompts and cache keys do not depend on map order
+func (e *Email) ContextKeys() []string {
+	keys := make([]string, 0, len(e.Context))
+	for key := range e.Context {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MergeContext adds the keys of a request-wide context the email does not
+// set itself to the email's context
+func (e *Email) MergeContext(context map[string]string) {
+	if len(context) == 0 {
+		return
+	}
+	merged := make(map[string]string, len(context)+len(e.Context))
+	for key, value := range context {
+		merged[key] = value
+	}
+	for key, value := range e.Context {
+		merged[key] = value
+	}
+	e.Context = merged
 }
 
 // Attachment represents an email attachment
@@ -30,7 +251,112 @@ type Attachment struct {
 	Size     int64  `json:"size"`
 }
 
-// ClassificationRequest represents a request to classify an email
+// DangerousAttachmentExtensions are the file extensions commonly used to
+// deliver malware: executables, scripts, shortcuts, disk images and
+// macro-enabled Office documents
+var DangerousAttachmentExtensions = []string{
+	".exe", ".scr", ".com", ".pif", ".bat", ".cmd", ".msi", ".dll",
+	".js", ".jse", ".vbs", ".vbe", ".wsf", ".hta", ".ps1", ".jar", ".lnk",
+	".iso", ".img",
+	".docm", ".dotm", ".xlsm", ".xltm", ".xlam", ".pptm", ".potm", ".ppsm",
+}
+
+// DangerousAttachmentTypes are the MIME types of executables and
+// macro-enabled Office documents
+var DangerousAttachmentTypes = []string{
+	"application/x-msdownload",
+	"application/x-msdos-program",
+	"application/x-dosexec",
+	"application/x-msi",
+	"application/java-archive",
+	"application/x-iso9660-image",
+	"application/vnd.ms-word.document.macroenabled.12",
+	"application/vnd.ms-word.template.macroenabled.12",
+	"application/vnd.ms-excel.sheet.macroenabled.12",
+	"application/vnd.ms-excel.template.macroenabled.12",
+	"application/vnd.ms-excel.addin.macroenabled.12",
+	"application/vnd.ms-powerpoint.presentation.macroenabled.12",
+	"application/vnd.ms-powerpoint.slideshow.macroenabled.12",
+}
+
+// Extension returns the attachment's lowercase file extension with its
+// leading dot, the last one of a double extension such as invoice.pdf.exe
+func (a Attachment) Extension() string {
+	return strings.ToLower(filepath.Ext(a.Filename))
+}
+
+// IsDangerous reports whether the attachment has a dangerous extension or
+// MIME type. Whether an archive is password-protected cannot be told from
+// its metadata, so archives are not considered dangerous.
+func (a Attachment) IsDangerous() bool {
+	return containsFold(DangerousAttachmentExtensions, a.Extension()) ||
+		containsFold(DangerousAttachmentTypes, a.MimeType)
+}
+
+// HasAttachmentType reports whether any attachment has one of the MIME
+// types, compared case-insensitively
+func (e *Email) HasAttachmentType(mimeTypes ...string) bool {
+	for _, attachment := range e.Attachments {
+		if containsFold(mimeTypes, attachment.MimeType) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAttachmentExtension reports whether any attachment has one of the file
+// extensions, given with or without their leading dot
+func (e *Email) HasAttachmentExtension(extensions ...string) bool {
+	for _, attachment := range e.Attachments {
+		extension := attachment.Extension()
+		if extension == "" {
+			continue
+		}
+		for _, candidate := range extensions {
+			if strings.EqualFold("."+strings.TrimPrefix(candidate, "."), extension) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasDangerousAttachment reports whether any attachment is dangerous
+func (e *Email) HasDangerousAttachment() bool {
+	for _, attachment := range e.Attachments {
+		if attachment.IsDangerous() {
+			return true
+		}
+	}
+	return false
+}
+
+// HasLinkTextMismatch reports whether any link's anchor text names another
+// host than the link leads to
+func (e *Email) HasLinkTextMismatch() bool {
+	for _, link := range e.URLs {
+		if link.Mismatch {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold reports whether values holds value, ignoring case
+func containsFold(values []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassificationRequest represents a request to classify an email. Its
+// Context is merged into the email's; see Email.MergeContext.
 type ClassificationRequest struct {
 	Email     Email             `json:"email"`
 	ProfileID string            `json:"profile_id"`
@@ -39,6 +365,7 @@ type ClassificationRequest struct {
 
 // ClassificationResponse represents the result of email classification
 type ClassificationResponse struct {
+	EmailID     string                 `json:"email_id,omitempty"`
 	ProfileID   string                 `json:"profile_id"`
 	Action      string                 `json:"action"`
 	Confidence  float64                `json:"confidence"`
@@ -46,14 +373,102 @@ type ClassificationResponse struct {
 	Labels      []string               `json:"labels,omitempty"`
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
 	ProcessedAt time.Time              `json:"processed_at"`
+	// Fingerprint identifies the model and profile that produced a backend's
+	// result; a decision the resolver combines from several results has none
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+}
+
+// Fingerprint records what produced a classification so that an old
+// decision can be traced and reproduced. PromptHash covers the system prompt
+// and the few-shot examples sent, not the email. ID hashes every other field,
+// so two results share an ID exactly when they share a fingerprint.
+type Fingerprint struct {
+	ID             string `json:"id"`
+	Model          string `json:"model"`
+	ModelDigest    string `json:"model_digest,omitempty"`
+	ProfileID      string `json:"profile_id"`
+	ProfileVersion string `json:"profile_version"`
+	PromptHash     string `json:"prompt_hash"`
+}
+
+// MetadataFingerprint is the audit entry metadata key recording a
+// classification's Fingerprint
+const MetadataFingerprint = "fingerprint"
+
+// Fields exposes a result to expressions, such as priority rule and
+// conditional execution conditions. Metadata keys are also available
+// directly, so importance reads the importance a profile reported, but never
+// shadow the result's own fields.
+func (r *ClassificationResponse) Fields() map[string]interface{} {
+	fields := make(map[string]interface{}, len(r.Metadata)+6)
+	for key, value := range r.Metadata {
+		fields[key] = value
+	}
+
+	fields["profile_id"] = r.ProfileID
+	fields["action"] = r.Action
+	fields["confidence"] = r.Confidence
+	fields["reasoning"] = r.Reasoning
+	fields["labels"] = r.Labels
+	fields["metadata"] = r.Metadata
+	return fields
+}
+
+// MetadataResolution is the ClassificationResponse metadata key holding the
+// resolver's explanation of a decision when explain mode is enabled
+const MetadataResolution = "resolution"
+
+// MetadataReasoningTruncated is the ClassificationResponse metadata key set
+// when the reasoning exceeded the profile's max_reasoning_length and was cut
+// short
+const MetadataReasoningTruncated = "reasoning_truncated"
+
+// ActionReview is the action the resolver returns, unless configured
+// otherwise, when it abstains from a low-confidence decision so the email is
+// routed to a human
+const ActionReview = "review"
+
+// Metadata keys set on a result the resolver abstained from
+const (
+	MetadataAbstained      = "abstained"
+	MetadataProposedAction = "proposed_action"
+)
+
+// Metadata keys set on the result a fail action stands in for a failed
+// classification with, recording the error
+const (
+	MetadataClassificationFailed = "classification_failed"
+	MetadataClassificationError  = "classification_error"
+)
+
+// MetadataDowngraded is set on a result whose action was replaced because
+// its confidence was below the action's minimum, with the replaced action
+// under MetadataProposedAction
+const MetadataDowngraded = "downgraded"
+
+// Metadata keys set on the result of a shadow profile, naming the active
+// profile it runs alongside
+const (
+	MetadataShadow   = "shadow"
+	MetadataShadowOf = "shadow_of"
+)
+
+// IsShadow reports whether the result came from a shadow profile
+func (r *ClassificationResponse) IsShadow() bool {
+	shadow, _ := r.Metadata[MetadataShadow].(bool)
+	return shadow
 }
 
-// BatchRequest represents a batch of emails to process
+// BatchRequest represents a batch of emails to process. Its Context applies
+// to every email, which may override single keys in its own context.
 type BatchRequest struct {
 	Emails    []Email           `json:"emails"`
 	ProfileID string            `json:"profile_id"`
 	Context   map[string]string `json:"context,omitempty"`
 	DryRun    bool              `json:"dry_run"`
+	// Budget caps the time the whole batch may take, as a duration such as
+	// "30s". It can only shorten the server's configured batch budget.
+	Budget string `json:"budget,omitempty"`
 }
 
 // BatchResponse represents the results of batch processing
@@ -69,8 +484,148 @@ type BatchSummary struct {
 	TotalEmails     int                    `json:"total_emails"`
 	ProcessedEmails int                    `json:"processed_emails"`
 	FailedEmails    int                    `json:"failed_emails"`
+	SkippedEmails   int                    `json:"skipped_emails,omitempty"`
 	ActionCounts    map[string]int         `json:"action_counts"`
 	AvgConfidence   float64                `json:"avg_confidence"`
 	ProcessingTime  time.Duration          `json:"processing_time"`
 	Errors          []string               `json:"errors,omitempty"`
+	Failures        []EmailFailure         `json:"failures,omitempty"`
+	Actions         []AppliedAction        `json:"actions,omitempty"`
+	DuplicateGroups []DuplicateGroup       `json:"duplicate_groups,omitempty"`
+	// BudgetExceeded is set when the batch ran out of time, leaving the
+	// UnprocessedEmails unclassified
+	BudgetExceeded    bool     `json:"budget_exceeded,omitempty"`
+	UnprocessedEmails []string `json:"unprocessed_emails,omitempty"`
+	// ConfidenceHistogram counts the processed emails per confidence
+	// bucket, and ActionConfidence summarizes their confidence per action
+	ConfidenceHistogram []ConfidenceBucket         `json:"confidence_histogram,omitempty"`
+	ActionConfidence    map[string]ConfidenceStats `json:"action_confidence,omitempty"`
+}
+
+// DuplicateGroup lists the emails of a batch that were given the result of
+// classifying a near-identical representative
+type DuplicateGroup struct {
+	Representative string   `json:"representative"`
+	Duplicates     []string `json:"duplicates"`
+}
+
+// MetadataDedupedFrom is the ClassificationResponse metadata key naming the
+// representative email whose result a duplicate was given
+const MetadataDedupedFrom = "deduped_from"
+
+// Processing stages reported in EmailFailure
+const (
+	StageClassify = "classify"
+	StageAction   = "action"
+)
+
+// EmailFailure describes why a single email in a batch could not be processed
+type EmailFailure struct {
+	EmailID string `json:"email_id"`
+	Stage   string `json:"stage"`
+	Err     string `json:"error"`
+	// DeadLetterID names the dead-letter queue entry the email was kept in
+	DeadLetterID string `json:"dead_letter_id,omitempty"`
+}
+
+// AddFailure records a failed email in the summary, keeping Errors in sync
+// with the structured Failures list
+func (s *BatchSummary) AddFailure(emailID, stage string, err error) {
+	s.FailedEmails++
+	s.Failures = append(s.Failures, EmailFailure{
+		EmailID: emailID,
+		Stage:   stage,
+		Err:     err.Error(),
+	})
+	s.Errors = append(s.Errors, fmt.Sprintf("%s: %s: %v", emailID, stage, err))
+}
+
+// RecordConfidence counts the confidence of a processed email in the
+// histogram, created with DefaultConfidenceBuckets when unset, and in the
+// statistics of its action
+func (s *BatchSummary) RecordConfidence(action string, confidence float64) {
+	if len(s.ConfidenceHistogram) == 0 {
+		s.ConfidenceHistogram = NewConfidenceHistogram(nil)
+	}
+	// The first bucket whose upper bound exceeds the confidence; the last
+	// bucket includes its upper bound
+	i := sort.Search(len(s.ConfidenceHistogram), func(i int) bool {
+		return confidence < s.ConfidenceHistogram[i].Max
+	})
+	if i == len(s.ConfidenceHistogram) {
+		i--
+	}
+	s.ConfidenceHistogram[i].Count++
+
+	if s.ActionConfidence == nil {
+		s.ActionConfidence = make(map[string]ConfidenceStats)
+	}
+	stats := s.ActionConfidence[action]
+	stats.add(confidence)
+	s.ActionConfidence[action] = stats
+}
+
+// DefaultConfidenceBuckets are the bounds between confidence histogram
+// buckets when none are configured: one bucket per tenth
+var DefaultConfidenceBuckets = []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}
+
+// ConfidenceBucket counts the emails whose confidence is at least Min and
+// below Max; the last bucket of a histogram also counts Max
+type ConfidenceBucket struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+// NewConfidenceHistogram returns empty buckets covering confidences from 0
+// to 1, split at bounds, which must be increasing and between 0 and 1. No
+// bounds split at DefaultConfidenceBuckets.
+func NewConfidenceHistogram(bounds []float64) []ConfidenceBucket {
+	if len(bounds) == 0 {
+		bounds = DefaultConfidenceBuckets
+	}
+	buckets := make([]ConfidenceBucket, 0, len(bounds)+1)
+	lower := 0.0
+	for _, bound := range bounds {
+		buckets = append(buckets, ConfidenceBucket{Min: lower, Max: bound})
+		lower = bound
+	}
+	return append(buckets, ConfidenceBucket{Min: lower, Max: 1})
+}
+
+// ConfidenceStats summarizes the confidence of a set of emails
+type ConfidenceStats struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+}
+
+// add counts a confidence, updating the average incrementally
+func (c *ConfidenceStats) add(confidence float64) {
+	if c.Count == 0 || confidence < c.Min {
+		c.Min = confidence
+	}
+	if c.Count == 0 || confidence > c.Max {
+		c.Max = confidence
+	}
+	c.Count++
+	c.Avg += (confidence - c.Avg) / float64(c.Count)
+}
+
+// AppliedAction records the Gmail changes made for an email, or the changes
+// that would have been made when processing in dry-run mode
+type AppliedAction struct {
+	EmailID      string   `json:"email_id"`
+	Action       string   `json:"action"`
+	AddLabels    []string `json:"add_labels,omitempty"`
+	RemoveLabels []string `json:"remove_labels,omitempty"`
+	Operation    string   `json:"operation,omitempty"`
+	DryRun       bool     `json:"dry_run"`
+	// ProposedAction is the classified action when it was downgraded to
+	// Action for falling below its minimum confidence
+	ProposedAction string `json:"proposed_action,omitempty"`
+	// InterceptedOperation is the message operation safe mode kept the
+	// action from applying
+	InterceptedOperation string `json:"intercepted_operation,omitempty"`
 }
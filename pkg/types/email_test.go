@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestEmail_Validation(t *testing.T) {
@@ -80,6 +81,47 @@ func TestBatchSummary_Calculations(t *testing.T) {
 	assert.Equal(t, 95, totalActions, "Action counts should sum to processed emails")
 }
 
+func TestBatchSummary_RecordConfidence(t *testing.T) {
+	summary := BatchSummary{ConfidenceHistogram: NewConfidenceHistogram([]float64{0.5, 0.7, 0.9})}
+	recorded := []struct {
+		action     string
+		confidence float64
+	}{
+		{"archive", 0.95}, {"archive", 0.98}, {"archive", 1.0},
+		{"archive", 0.1}, {"archive", 0.2},
+		{"star", 0.7}, {"star", 0.5}, {"star", 0.89},
+	}
+	for _, r := range recorded {
+		summary.RecordConfidence(r.action, r.confidence)
+	}
+
+	assert.Equal(t, []ConfidenceBucket{
+		{Min: 0, Max: 0.5, Count: 2},
+		{Min: 0.5, Max: 0.7, Count: 1},
+		{Min: 0.7, Max: 0.9, Count: 2},
+		{Min: 0.9, Max: 1, Count: 3},
+	}, summary.ConfidenceHistogram, "bounds belong to the bucket above them, and 1.0 to the last")
+
+	archive := summary.ActionConfidence["archive"]
+	assert.Equal(t, 5, archive.Count)
+	assert.Equal(t, 0.1, archive.Min)
+	assert.Equal(t, 1.0, archive.Max)
+	assert.InDelta(t, 0.646, archive.Avg, 1e-9)
+	star := summary.ActionConfidence["star"]
+	assert.Equal(t, 3, star.Count)
+	assert.InDelta(t, 2.09/3, star.Avg, 1e-9)
+}
+
+func TestBatchSummary_DefaultConfidenceHistogram(t *testing.T) {
+	var summary BatchSummary
+	summary.RecordConfidence("archive", 0.85)
+	summary.RecordConfidence("archive", 0.05)
+
+	require.Len(t, summary.ConfidenceHistogram, len(DefaultConfidenceBuckets)+1)
+	assert.Equal(t, ConfidenceBucket{Min: 0, Max: 0.1, Count: 1}, summary.ConfidenceHistogram[0])
+	assert.Equal(t, ConfidenceBucket{Min: 0.8, Max: 0.9, Count: 1}, summary.ConfidenceHistogram[8])
+}
+
 func TestClassificationResponse_Validation(t *testing.T) {
 	response := ClassificationResponse{
 		ProfileID:   "spam",
@@ -111,3 +153,44 @@ func TestAttachment_Structure(t *testing.T) {
 	assert.Equal(t, "application/pdf", attachment.MimeType)
 	assert.Equal(t, int64(1024000), attachment.Size)
 }
+
+func TestAttachment_IsDangerous(t *testing.T) {
+	tests := []struct {
+		name       string
+		attachment Attachment
+		dangerous  bool
+	}{
+		{"executable", Attachment{Filename: "setup.exe", MimeType: "application/octet-stream"}, true},
+		{"double extension", Attachment{Filename: "Invoice.PDF.exe"}, true},
+		{"macro-enabled document", Attachment{Filename: "report.docm"}, true},
+		{"executable type with a harmless name", Attachment{Filename: "invoice.pdf", MimeType: "application/x-msdownload"}, true},
+		{"macro-enabled type", Attachment{Filename: "sheet", MimeType: "application/vnd.ms-excel.sheet.macroEnabled.12"}, true},
+		{"pdf", Attachment{Filename: "invoice.pdf", MimeType: "application/pdf"}, false},
+		{"archive", Attachment{Filename: "photos.zip", MimeType: "application/zip"}, false},
+		{"no extension", Attachment{Filename: "README"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.dangerous, tt.attachment.IsDangerous())
+		})
+	}
+}
+
+func TestEmail_AttachmentHelpers(t *testing.T) {
+	email := &Email{Attachments: []Attachment{
+		{Filename: "minutes.docx", MimeType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{Filename: "Payload.SCR", MimeType: "application/octet-stream"},
+	}}
+
+	assert.True(t, email.HasAttachmentType("application/pdf", "APPLICATION/OCTET-STREAM"))
+	assert.False(t, email.HasAttachmentType("application/x-msdownload"))
+	assert.True(t, email.HasAttachmentExtension("scr"))
+	assert.True(t, email.HasAttachmentExtension(".exe", ".scr"))
+	assert.False(t, email.HasAttachmentExtension(".zip"))
+	assert.True(t, email.HasDangerousAttachment())
+
+	email.Attachments = email.Attachments[:1]
+	assert.False(t, email.HasDangerousAttachment())
+	assert.False(t, (&Email{}).HasAttachmentExtension(""), "an email without attachments has no extensions")
+}
@@ -0,0 +1,27 @@
+package types
+
+// HealthState summarizes whether a component can serve requests
+type HealthState string
+
+// Health states, from best to worst. Degraded means the component is
+// backing off and callers should retry later; unhealthy means it is down.
+const (
+	HealthHealthy   HealthState = "healthy"
+	HealthDegraded  HealthState = "degraded"
+	HealthUnhealthy HealthState = "unhealthy"
+)
+
+// healthSeverity orders health states for aggregation
+var healthSeverity = map[HealthState]int{
+	HealthHealthy:   0,
+	HealthDegraded:  1,
+	HealthUnhealthy: 2,
+}
+
+// Worst returns the more severe of s and other
+func (s HealthState) Worst(other HealthState) HealthState {
+	if healthSeverity[other] > healthSeverity[s] {
+		return other
+	}
+	return s
+}
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -12,15 +13,33 @@ type Profile struct {
 	DependsOn             []string               `yaml:"depends_on,omitempty" json:"depends_on,omitempty"`
 	ConditionalExecution  *ConditionalExecution  `yaml:"conditional_execution,omitempty" json:"conditional_execution,omitempty"`
 	Model                 string                 `yaml:"model" json:"model"`
+	FallbackModels        []string               `yaml:"fallback_models,omitempty" json:"fallback_models,omitempty"`
 	ModelParams           ModelParams            `yaml:"model_params" json:"model_params"`
 	Response              ResponseConfig         `yaml:"response" json:"response"`
+	Calibration           *ConfidenceCalibration `yaml:"calibration,omitempty" json:"calibration,omitempty"`
 	System                string                 `yaml:"system" json:"system"`
+	// PromptTemplate replaces the built-in prompt with a text/template
+	// rendering llm.PromptData
+	PromptTemplate        string                 `yaml:"prompt_template,omitempty" json:"prompt_template,omitempty"`
 	FewShot               []FewShotExample       `yaml:"fewshot" json:"fewshot"`
+	FewShotSelection      *FewShotSelection      `yaml:"fewshot_selection,omitempty" json:"fewshot_selection,omitempty"`
 	Policy                PolicyConfig           `yaml:"policy" json:"policy"`
+	Cache                 *bool                  `yaml:"cache,omitempty" json:"cache,omitempty"`
+	ShadowOf              string                 `yaml:"shadow_of,omitempty" json:"shadow_of,omitempty"`
+	FailAction            string                 `yaml:"fail_action,omitempty" json:"fail_action,omitempty"`
+	Triage                *TriageConfig          `yaml:"triage,omitempty" json:"triage,omitempty"`
+	EmptyBody             *EmptyBodyConfig       `yaml:"empty_body,omitempty" json:"empty_body,omitempty"`
 	CreatedAt             time.Time              `json:"created_at"`
 	UpdatedAt             time.Time              `json:"updated_at"`
 }
 
+// IsShadow reports whether the profile is a candidate run in shadow mode
+// alongside the active profile it names in shadow_of. Shadow results are
+// audited but never resolved or acted on.
+func (p *Profile) IsShadow() bool {
+	return p.ShadowOf != ""
+}
+
 // ConditionalExecution defines when a profile should be executed
 type ConditionalExecution struct {
 	When   string `yaml:"when" json:"when"`
@@ -29,31 +48,281 @@ type ConditionalExecution struct {
 
 // ModelParams defines parameters for the LLM model
 type ModelParams struct {
-	Temperature    float64 `yaml:"temperature" json:"temperature"`
-	MaxTokens      int     `yaml:"max_tokens" json:"max_tokens"`
-	TimeoutSeconds int     `yaml:"timeout_seconds" json:"timeout_seconds"`
-	TopP           float64 `yaml:"top_p,omitempty" json:"top_p,omitempty"`
-	TopK           int     `yaml:"top_k,omitempty" json:"top_k,omitempty"`
+	// Temperature is nil when unset, so that an explicit zero is not
+	// replaced by a parent's or a default temperature
+	Temperature    *float64 `yaml:"temperature,omitempty" json:"temperature,omitempty"`
+	MaxTokens      int      `yaml:"max_tokens" json:"max_tokens"`
+	TimeoutSeconds int      `yaml:"timeout_seconds" json:"timeout_seconds"`
+	TopP           float64  `yaml:"top_p,omitempty" json:"top_p,omitempty"`
+	TopK           int      `yaml:"top_k,omitempty" json:"top_k,omitempty"`
+	// Seed fixes the sampling seed of the profile's requests instead of a
+	// random one
+	Seed *int64 `yaml:"seed,omitempty" json:"seed,omitempty"`
+	// KeepAlive overrides the backend's keep-alive for the profile's models,
+	// as a duration such as "30m"; negative keeps them loaded indefinitely
+	KeepAlive string `yaml:"keep_alive,omitempty" json:"keep_alive,omitempty"`
+}
+
+// WithDefaults returns the params with every unset field taken from
+// defaults. Temperature and Seed are unset when nil, the others when zero.
+func (m ModelParams) WithDefaults(defaults ModelParams) ModelParams {
+	if m.Temperature == nil {
+		m.Temperature = defaults.Temperature
+	}
+	if m.MaxTokens == 0 {
+		m.MaxTokens = defaults.MaxTokens
+	}
+	if m.TimeoutSeconds == 0 {
+		m.TimeoutSeconds = defaults.TimeoutSeconds
+	}
+	if m.TopP == 0 {
+		m.TopP = defaults.TopP
+	}
+	if m.TopK == 0 {
+		m.TopK = defaults.TopK
+	}
+	if m.Seed == nil {
+		m.Seed = defaults.Seed
+	}
+	if m.KeepAlive == "" {
+		m.KeepAlive = defaults.KeepAlive
+	}
+	return m
+}
+
+// TemperatureOrZero returns the temperature, or zero when it is unset
+func (m ModelParams) TemperatureOrZero() float64 {
+	if m.Temperature == nil {
+		return 0
+	}
+	return *m.Temperature
 }
 
 // ResponseConfig defines the expected response format and validation
 type ResponseConfig struct {
 	Schema     string             `yaml:"schema" json:"schema"`
 	Validation ValidationConfig   `yaml:"validation" json:"validation"`
+	// FieldMapping names the model's field for each response field it
+	// answers differently, such as action: category, for models whose output
+	// does not follow the prompt's schema
+	FieldMapping map[string]string `yaml:"field_mapping,omitempty" json:"field_mapping,omitempty"`
+	// PostProcess adjusts the parsed result, in order, before it is
+	// returned
+	PostProcess []PostProcessRule `yaml:"post_process,omitempty" json:"post_process,omitempty"`
 }
 
+// PostProcessRule overrides parts of a parsed classification when its
+// condition holds. When is an expr program evaluated against the result's
+// action, confidence, reasoning, labels and metadata, with the metadata
+// keys also at the top level, and the email.
+type PostProcessRule struct {
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	When string `yaml:"when" json:"when"`
+	// Action replaces the result's action, when set
+	Action       string   `yaml:"action,omitempty" json:"action,omitempty"`
+	AddLabels    []string `yaml:"add_labels,omitempty" json:"add_labels,omitempty"`
+	RemoveLabels []string `yaml:"remove_labels,omitempty" json:"remove_labels,omitempty"`
+	// Metadata is merged into the result's metadata
+	Metadata map[string]interface{} `yaml:"metadata,omitempty" json:"metadata,omitempty"`
+}
+
+// RuleName returns the rule's name, or its position among the profile's
+// rules when it has none
+func (r PostProcessRule) RuleName(index int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("post_process[%d]", index)
+}
+
+// MappableResponseFields are the response fields a field mapping may rename
+var MappableResponseFields = []string{"action", "confidence", "reasoning", "labels", "metadata"}
+
 // ValidationConfig defines validation rules for responses
 type ValidationConfig struct {
 	RequiredFields   []string  `yaml:"required_fields" json:"required_fields"`
 	ConfidenceRange  [2]float64 `yaml:"confidence_range" json:"confidence_range"`
 	AllowedActions   []string  `yaml:"allowed_actions,omitempty" json:"allowed_actions,omitempty"`
+	// MaxReasoningLength caps the reasoning kept from a response, in
+	// characters; zero means DefaultMaxReasoningLength
+	MaxReasoningLength int `yaml:"max_reasoning_length,omitempty" json:"max_reasoning_length,omitempty"`
+}
+
+// DefaultMaxReasoningLength is the reasoning cap of profiles that set none
+const DefaultMaxReasoningLength = 2000
+
+// ReasoningLimit returns the maximum reasoning length in characters
+func (v ValidationConfig) ReasoningLimit() int {
+	if v.MaxReasoningLength > 0 {
+		return v.MaxReasoningLength
+	}
+	return DefaultMaxReasoningLength
+}
+
+// Requires reports whether field is one of the required fields
+func (v ValidationConfig) Requires(field string) bool {
+	for _, required := range v.RequiredFields {
+		if required == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Confidence calibration methods
+const (
+	CalibrationLinear    = "linear"
+	CalibrationPiecewise = "piecewise"
+)
+
+// ConfidenceCalibration maps a model's raw confidence to a calibrated
+// confidence. Linear calibration computes scale*raw + offset; piecewise
+// calibration interpolates between points ordered by raw confidence and
+// holds the end values outside them. Calibrated values are clamped to [0, 1].
+type ConfidenceCalibration struct {
+	Method string             `yaml:"method" json:"method"`
+	Scale  float64            `yaml:"scale,omitempty" json:"scale,omitempty"`
+	Offset float64            `yaml:"offset,omitempty" json:"offset,omitempty"`
+	Points []CalibrationPoint `yaml:"points,omitempty" json:"points,omitempty"`
+}
+
+// CalibrationPoint maps one raw confidence to its calibrated value
+type CalibrationPoint struct {
+	Raw        float64 `yaml:"raw" json:"raw"`
+	Calibrated float64 `yaml:"calibrated" json:"calibrated"`
 }
 
-// FewShotExample represents a training example for the model
+// Apply returns the calibrated confidence for raw. A nil calibration is the
+// identity and returns raw unchanged.
+func (c *ConfidenceCalibration) Apply(raw float64) float64 {
+	if c == nil {
+		return raw
+	}
+
+	calibrated := raw
+	switch c.Method {
+	case CalibrationLinear:
+		calibrated = c.Scale*raw + c.Offset
+	case CalibrationPiecewise:
+		calibrated = c.interpolate(raw)
+	}
+
+	if calibrated < 0 {
+		return 0
+	}
+	if calibrated > 1 {
+		return 1
+	}
+	return calibrated
+}
+
+// interpolate evaluates the piecewise linear mapping at raw
+func (c *ConfidenceCalibration) interpolate(raw float64) float64 {
+	points := c.Points
+	if len(points) == 0 {
+		return raw
+	}
+	if raw <= points[0].Raw {
+		return points[0].Calibrated
+	}
+	for i := 1; i < len(points); i++ {
+		if raw <= points[i].Raw {
+			lower, upper := points[i-1], points[i]
+			fraction := (raw - lower.Raw) / (upper.Raw - lower.Raw)
+			return lower.Calibrated + fraction*(upper.Calibrated-lower.Calibrated)
+		}
+	}
+	return points[len(points)-1].Calibrated
+}
+
+// FewShotSelection includes only the few-shot examples most similar to the
+// email in its prompt, by the cosine similarity of their embeddings
+type FewShotSelection struct {
+	// TopK is the number of examples included in the prompt
+	TopK int `yaml:"top_k" json:"top_k"`
+	// Model is the embedding model the examples and email are embedded with
+	Model string `yaml:"model" json:"model"`
+}
+
+// TriageConfig lets a profile classify preview emails on their snippet
+// alone, fetching the full message only for ambiguous results: those below
+// EscalateBelow confidence or with one of EscalateActions
+type TriageConfig struct {
+	EscalateBelow   float64  `yaml:"escalate_below" json:"escalate_below"`
+	EscalateActions []string `yaml:"escalate_actions,omitempty" json:"escalate_actions,omitempty"`
+}
+
+// Escalates reports whether a result classified from a snippet is
+// ambiguous enough to classify the full message
+func (t *TriageConfig) Escalates(result *ClassificationResponse) bool {
+	if result.Confidence < t.EscalateBelow {
+		return true
+	}
+	for _, action := range t.EscalateActions {
+		if result.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultEmptyBodyMaxConfidence caps the confidence of emails with an
+// empty body classified by profiles that set empty_body without a cap
+const DefaultEmptyBodyMaxConfidence = 0.5
+
+// EmptyBodyConfig sets how a profile handles emails whose body is empty or
+// nearly so. They are classified from their subject and headers alone, with
+// a confidence capped at MaxConfidence, zero meaning
+// DefaultEmptyBodyMaxConfidence; profiles without the config leave it
+// uncapped. A profile with an Action handles them as system mail instead:
+// they are given the action at Confidence, zero meaning 1, without
+// prompting the model.
+type EmptyBodyConfig struct {
+	Action        string  `yaml:"action,omitempty" json:"action,omitempty"`
+	Confidence    float64 `yaml:"confidence,omitempty" json:"confidence,omitempty"`
+	MaxConfidence float64 `yaml:"max_confidence,omitempty" json:"max_confidence,omitempty"`
+}
+
+// SystemMail reports whether emails with an empty body are handled as
+// system mail. A nil config classifies them.
+func (c *EmptyBodyConfig) SystemMail() bool {
+	return c != nil && c.Action != ""
+}
+
+// SystemMailConfidence returns the confidence of system mail results
+func (c *EmptyBodyConfig) SystemMailConfidence() float64 {
+	if c == nil || c.Confidence == 0 {
+		return 1
+	}
+	return c.Confidence
+}
+
+// ConfidenceLimit returns the highest confidence of emails with an empty
+// body classified from their subject and headers, and false when a nil
+// config leaves it uncapped
+func (c *EmptyBodyConfig) ConfidenceLimit() (float64, bool) {
+	switch {
+	case c == nil:
+		return 0, false
+	case c.MaxConfidence == 0:
+		return DefaultEmptyBodyMaxConfidence, true
+	default:
+		return c.MaxConfidence, true
+	}
+}
+
+// FewShotExample represents a training example for the model. Input and
+// Output may instead be read from files named by InputFile and OutputFile,
+// relative to the profile's directory; the loader inlines them. An example
+// with a When condition, an expr program evaluated against the email, is
+// only included in the prompt of emails it holds for.
 type FewShotExample struct {
-	Name   string `yaml:"name" json:"name"`
-	Input  string `yaml:"input" json:"input"`
-	Output string `yaml:"output" json:"output"`
+	Name       string `yaml:"name" json:"name"`
+	Input      string `yaml:"input" json:"input"`
+	Output     string `yaml:"output" json:"output"`
+	InputFile  string `yaml:"input_file,omitempty" json:"input_file,omitempty"`
+	OutputFile string `yaml:"output_file,omitempty" json:"output_file,omitempty"`
+	When       string `yaml:"when,omitempty" json:"when,omitempty"`
 }
 
 // PolicyConfig defines the decision-making policy
@@ -82,20 +351,45 @@ type ResolverConfig struct {
 	PriorityRules       []PriorityRule           `yaml:"priority_rules" json:"priority_rules"`
 	ConfidenceWeighting ConfidenceWeighting      `yaml:"confidence_weighting" json:"confidence_weighting"`
 	ConflictResolution  map[string]string        `yaml:"conflict_resolution" json:"conflict_resolution"`
+	ConfidenceFloor     float64                  `yaml:"confidence_floor,omitempty" json:"confidence_floor,omitempty"`
+	AbstainAction       string                   `yaml:"abstain_action,omitempty" json:"abstain_action,omitempty"`
+	SenderReputation    ReputationConfig         `yaml:"sender_reputation,omitempty" json:"sender_reputation,omitempty"`
+}
+
+// ReputationConfig defines the static sources of sender reputation. List
+// entries are full addresses or domains; a domain also covers its
+// subdomains.
+type ReputationConfig struct {
+	Allowlist  []string `yaml:"allowlist,omitempty" json:"allowlist,omitempty"`
+	Blocklist  []string `yaml:"blocklist,omitempty" json:"blocklist,omitempty"`
+	ScoresFile string   `yaml:"scores_file,omitempty" json:"scores_file,omitempty"`
 }
 
 // PriorityRule defines high-priority override conditions
 type PriorityRule struct {
-	Name            string  `yaml:"name" json:"name"`
-	Condition       string  `yaml:"condition" json:"condition"`
-	Action          string  `yaml:"action,omitempty" json:"action,omitempty"`
-	ConfidenceBoost float64 `yaml:"confidence_boost,omitempty" json:"confidence_boost,omitempty"`
-	Priority        int     `yaml:"priority" json:"priority"`
-	Reason          string  `yaml:"reason" json:"reason"`
+	Name                  string  `yaml:"name" json:"name"`
+	Condition             string  `yaml:"condition" json:"condition"`
+	Action                string  `yaml:"action,omitempty" json:"action,omitempty"`
+	ConfidenceBoost       float64 `yaml:"confidence_boost,omitempty" json:"confidence_boost,omitempty"`
+	Priority              int     `yaml:"priority" json:"priority"`
+	Reason                string  `yaml:"reason" json:"reason"`
+	BypassConfidenceFloor bool    `yaml:"bypass_confidence_floor,omitempty" json:"bypass_confidence_floor,omitempty"`
 }
 
 // ConfidenceWeighting defines how to weight different profile results
 type ConfidenceWeighting struct {
 	Method         string             `yaml:"method" json:"method"`
 	ProfileWeights map[string]float64 `yaml:"profile_weights" json:"profile_weights"`
+	Adaptive       AdaptiveWeighting  `yaml:"adaptive,omitempty" json:"adaptive,omitempty"`
+}
+
+// AdaptiveWeighting lets profile weights drift from ProfileWeights, within
+// MinWeight and MaxWeight, as feedback shows how often each profile is right
+type AdaptiveWeighting struct {
+	Enabled      bool    `yaml:"enabled" json:"enabled"`
+	Feedback     string  `yaml:"feedback,omitempty" json:"feedback,omitempty"` // "agreement" or "external"
+	LearningRate float64 `yaml:"learning_rate,omitempty" json:"learning_rate,omitempty"`
+	MinWeight    float64 `yaml:"min_weight,omitempty" json:"min_weight,omitempty"`
+	MaxWeight    float64 `yaml:"max_weight,omitempty" json:"max_weight,omitempty"`
+	StateFile    string  `yaml:"state_file,omitempty" json:"state_file,omitempty"`
 }
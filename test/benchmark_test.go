@@ -41,7 +41,7 @@ func BenchmarkEmailClassification(b *testing.B) {
 		ID:    "benchmark",
 		Model: "qwen2.5:7b",
 		ModelParams: types.ModelParams{
-			Temperature: 0.7,
+			Temperature: float64Ptr(0.7),
 			MaxTokens:   100,
 		},
 		Response: types.ResponseConfig{
@@ -87,7 +87,7 @@ func BenchmarkBatchProcessing(b *testing.B) {
 		ID:    "batch",
 		Model: "qwen2.5:7b",
 		ModelParams: types.ModelParams{
-			Temperature: 0.5,
+			Temperature: float64Ptr(0.5),
 			MaxTokens:   50,
 		},
 		Response: types.ResponseConfig{},
@@ -157,7 +157,7 @@ func BenchmarkMemoryUsage(b *testing.B) {
 		Model:  "qwen2.5:7b",
 		System: "Process large emails efficiently.",
 		ModelParams: types.ModelParams{
-			Temperature: 0.7,
+			Temperature: float64Ptr(0.7),
 			MaxTokens:   100,
 		},
 		Response: types.ResponseConfig{Schema: "json"},
@@ -182,3 +182,7 @@ func generateLargeEmailBody(size int) string {
 	}
 	return result[:size]
 }
+
+func float64Ptr(value float64) *float64 {
+	return &value
+}
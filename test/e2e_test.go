@@ -97,7 +97,7 @@ func (suite *E2ETestSuite) TestCompleteEmailTriageWorkflow() {
 				Version: "1.0.0",
 				System:  "You are an email classifier. Classify emails and respond with JSON containing action, confidence, and reasoning.",
 				ModelParams: types.ModelParams{
-					Temperature: 0.7,
+					Temperature: float64Ptr(0.7),
 					MaxTokens:   150,
 				},
 				Response: types.ResponseConfig{
@@ -120,11 +120,11 @@ func (suite *E2ETestSuite) TestCompleteEmailTriageWorkflow() {
 			assert.Equal(suite.T(), testProfile.ID, result.ProfileID)
 			
 			// Log to audit trail
-			err = auditLogger.LogClassification(email, result)
+			err = auditLogger.LogClassification(context.Background(), email, result)
 			require.NoError(suite.T(), err)
 			
 			// Log action
-			err = auditLogger.LogAction(email, result.Action, "automated_classification")
+			err = auditLogger.LogAction(context.Background(), email, result.Action, "automated_classification")
 			require.NoError(suite.T(), err)
 			
 			suite.logger.Infof("E2E Test: %s -> %s (%.2f confidence)", 
@@ -155,7 +155,7 @@ func (suite *E2ETestSuite) TestErrorRecoveryScenarios() {
 		Version: "1.0.0",
 		System:  "Test profile for error scenarios",
 		ModelParams: types.ModelParams{
-			Temperature: 0.7,
+			Temperature: float64Ptr(0.7),
 			MaxTokens:   100,
 		},
 		Response: types.ResponseConfig{
@@ -198,7 +198,7 @@ func (suite *E2ETestSuite) TestPerformanceUnderLoad() {
 		Version: "1.0.0",
 		System:  "Quick email classifier for performance testing",
 		ModelParams: types.ModelParams{
-			Temperature: 0.5,
+			Temperature: float64Ptr(0.5),
 			MaxTokens:   200,
 		},
 		Response: types.ResponseConfig{
@@ -189,11 +189,11 @@ func (suite *IntegrationTestSuite) TestAuditLogging() {
 		ProcessedAt: time.Now(),
 	}
 	
-	err = auditLogger.LogClassification(email, classification)
+	err = auditLogger.LogClassification(context.Background(), email, classification)
 	assert.NoError(suite.T(), err)
 
 	// Test action logging
-	err = auditLogger.LogAction(email, "archive", "spam")
+	err = auditLogger.LogAction(context.Background(), email, "archive", "spam")
 	assert.NoError(suite.T(), err)
 
 	// Test integrity verification
@@ -245,7 +245,7 @@ func (suite *IntegrationTestSuite) TestCircuitBreakerBehavior() {
 		ID:    "test",
 		Model: "llama2",
 		ModelParams: types.ModelParams{
-			Temperature: 0.7,
+			Temperature: float64Ptr(0.7),
 			MaxTokens:   100,
 		},
 		Response: types.ResponseConfig{
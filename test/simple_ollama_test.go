@@ -81,7 +81,7 @@ func TestLocalOllamaClassification(t *testing.T) {
 		Version: "1.0.0",
 		System:  "You are an email classifier. Classify emails as spam, legitimate, or phishing. Respond with JSON only.",
 		ModelParams: types.ModelParams{
-			Temperature: 0.7,
+			Temperature: float64Ptr(0.7),
 			MaxTokens:   150,
 		},
 		Response: types.ResponseConfig{